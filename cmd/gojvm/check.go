@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/vm"
+)
+
+// check implements "gojvm check": it statically analyzes class files or a
+// jar and prints whether the VM supports everything they need, without
+// running them. It returns 0 if it does, 1 if not and 2 on usage errors.
+func check(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gojvm check <classfile>... | <jarfile>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	bootstrap, err := bootstrapLoader()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	var classes []*classfile.ClassFile
+	var loader vm.ClassLoader
+	if fs.NArg() == 1 && strings.HasSuffix(fs.Arg(0), ".jar") {
+		jar, err := readJar(fs.Arg(0), bootstrap)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		classes, loader = jar.list, jar
+	} else {
+		// Classes referenced by the named ones are looked up next to them.
+		user := vm.NewUserClassLoader(filepath.Dir(fs.Arg(0)), bootstrap)
+		for _, path := range fs.Args() {
+			cf, err := classfile.ParseFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			name, _ := cf.ClassName()
+			user.Cache[name] = cf
+			classes = append(classes, cf)
+		}
+		loader = user
+	}
+
+	report, err := vm.NewVM(loader).CheckCompatibility(classes...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if err := report.Write(os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if !report.Compatible() {
+		return 1
+	}
+	return 0
+}
+
+// jarClasses holds the classes of a jar and loads them ahead of its parent.
+type jarClasses struct {
+	byName map[string]*classfile.ClassFile
+	list   []*classfile.ClassFile
+	parent vm.ClassLoader
+}
+
+func readJar(path string, parent vm.ClassLoader) (*jarClasses, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	jar := &jarClasses{byName: make(map[string]*classfile.ClassFile), parent: parent}
+	for _, f := range zr.File {
+		if !strings.HasSuffix(f.Name, ".class") || strings.HasPrefix(f.Name, "META-INF/") {
+			continue // resources, and multi-release and module variants
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		cf, err := classfile.Parse(rc)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.Name, err)
+		}
+		if cf.IsModuleInfo() {
+			continue
+		}
+		name, _ := cf.ClassName()
+		jar.byName[name] = cf
+		jar.list = append(jar.list, cf)
+	}
+	if len(jar.list) == 0 {
+		return nil, fmt.Errorf("%s: no classes", path)
+	}
+	return jar, nil
+}
+
+func (j *jarClasses) LoadClass(name string) (*classfile.ClassFile, error) {
+	if cf, ok := j.byName[name]; ok {
+		return cf, nil
+	}
+	return j.parent.LoadClass(name)
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/vm"
+)
+
+// Access flags not exported by pkg/classfile.
+const (
+	accPrivate      = 0x0002
+	accProtected    = 0x0004
+	accSynchronized = 0x0020
+	accVolatile     = 0x0040
+	accBridge       = 0x0040
+	accTransient    = 0x0080
+	accVarargs      = 0x0080
+	accNative       = 0x0100
+	accInterface    = 0x0200
+	accSynthetic    = 0x1000
+	accAnnotation   = 0x2000
+	accEnum         = 0x4000
+)
+
+// describe implements "gojvm describe": it prints the public API of a class
+// on the classpath (its supertypes, annotations, and public and protected
+// fields and methods) in Java syntax. It returns the exit status.
+func describe(args []string) int {
+	fs := flag.NewFlagSet("describe", flag.ContinueOnError)
+	classPath := fs.String("cp", ".", "directory to load user classes from")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gojvm describe [-cp dir] <class>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	className := strings.ReplaceAll(strings.TrimSuffix(fs.Arg(0), ".class"), ".", "/")
+
+	// JDK classes can only be described when java.base is available.
+	bootstrap, _ := bootstrapLoader()
+	cf, err := vm.NewUserClassLoader(*classPath, bootstrap).LoadClass(className)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	describeClass(out, cf)
+	return 0
+}
+
+// describeClass writes the public API of cf.
+func describeClass(w io.Writer, cf *classfile.ClassFile) {
+	name, _ := cf.ClassName()
+	flags := cf.ModifierFlags()
+
+	for _, a := range cf.Annotations {
+		fmt.Fprintln(w, formatAnnotation(a))
+	}
+	var kind string
+	switch {
+	case flags&accAnnotation != 0:
+		kind, flags = "@interface", flags&^(accInterface|classfile.AccAbstract)
+	case flags&accInterface != 0:
+		kind, flags = "interface", flags&^classfile.AccAbstract
+	case flags&accEnum != 0:
+		kind, flags = "enum", flags&^classfile.AccFinal
+	case cf.IsRecord():
+		kind, flags = "record", flags&^classfile.AccFinal
+	default:
+		kind = "class"
+	}
+	if kind != "class" {
+		flags &^= classfile.AccStatic // implicit for nested types other than classes
+	}
+	header := modifiers(flags, classfile.AccPublic|accProtected|accPrivate|classfile.AccStatic|classfile.AccFinal|classfile.AccAbstract)
+	if cf.IsSealed() {
+		header += "sealed "
+	}
+	header += kind + " " + javaName(name)
+
+	super := javaName(cf.SuperClassName())
+	interfaces := make([]string, len(cf.Interfaces))
+	for i, idx := range cf.Interfaces {
+		iface, _ := classfile.GetClassName(cf.ConstantPool, idx)
+		interfaces[i] = javaName(iface)
+	}
+	if sig := cf.Signature; sig != nil {
+		header += typeParams(sig.TypeParams)
+		if sig.Super != nil {
+			super = sig.Super.String()
+		}
+		if len(sig.Interfaces) == len(interfaces) {
+			for i, t := range sig.Interfaces {
+				interfaces[i] = t.String()
+			}
+		}
+	}
+	// Implicit supertypes are left out, as in source.
+	if super != "" && super != "java.lang.Object" && kind == "class" {
+		header += " extends " + super
+	}
+	if len(interfaces) > 0 && kind != "@interface" {
+		if kind == "interface" {
+			header += " extends "
+		} else {
+			header += " implements "
+		}
+		header += strings.Join(interfaces, ", ")
+	}
+	if len(cf.PermittedSubclasses) > 0 {
+		permits := make([]string, len(cf.PermittedSubclasses))
+		for i, p := range cf.PermittedSubclasses {
+			permits[i] = javaName(p)
+		}
+		header += " permits " + strings.Join(permits, ", ")
+	}
+	fmt.Fprintln(w, header+" {")
+
+	fields := 0
+	for _, f := range cf.Fields {
+		if !isAPI(f.AccessFlags) {
+			continue
+		}
+		fields++
+		for _, a := range f.Annotations {
+			fmt.Fprintln(w, "  "+formatAnnotation(a))
+		}
+		line := "  " + modifiers(f.AccessFlags, classfile.AccPublic|accProtected|classfile.AccStatic|classfile.AccFinal|accVolatile|accTransient)
+		line += fieldType(f) + " " + f.Name
+		if c := constantValue(cf.ConstantPool, f.ConstantValue); c != "" {
+			line += " = " + c
+		}
+		fmt.Fprintln(w, line+";")
+	}
+	for _, m := range cf.Methods {
+		if !isAPI(m.AccessFlags) || m.AccessFlags&(accBridge|accSynthetic) != 0 || m.Name == "<clinit>" {
+			continue
+		}
+		if fields > 0 {
+			fmt.Fprintln(w) // separate fields from methods
+			fields = 0
+		}
+		for _, a := range m.Annotations {
+			fmt.Fprintln(w, "  "+formatAnnotation(a))
+		}
+		fmt.Fprintln(w, "  "+methodDeclaration(cf.SimpleName(), flags&accInterface != 0, &m)+";")
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// isAPI reports whether a member with the given flags is visible outside
+// its package to subclasses or everyone.
+func isAPI(flags uint16) bool {
+	return flags&(classfile.AccPublic|accProtected) != 0 && flags&accSynthetic == 0
+}
+
+// modifiers renders the flags in mask as Java modifiers, in source order
+// and followed by a space.
+func modifiers(flags, mask uint16) string {
+	var sb strings.Builder
+	for _, m := range []struct {
+		flag uint16
+		name string
+	}{
+		{classfile.AccPublic, "public"},
+		{accProtected, "protected"},
+		{accPrivate, "private"},
+		{classfile.AccAbstract, "abstract"},
+		{classfile.AccStatic, "static"},
+		{classfile.AccFinal, "final"},
+		{accTransient, "transient"},
+		{accVolatile, "volatile"},
+		{accSynchronized, "synchronized"},
+		{accNative, "native"},
+	} {
+		if flags&mask&m.flag != 0 {
+			sb.WriteString(m.name + " ")
+		}
+	}
+	return sb.String()
+}
+
+// methodDeclaration renders the signature of m; constructors are named
+// after the simple name of their class.
+func methodDeclaration(simpleName string, inInterface bool, m *classfile.MethodInfo) string {
+	flags := m.AccessFlags
+	mask := uint16(classfile.AccPublic | accProtected | classfile.AccAbstract | classfile.AccStatic | classfile.AccFinal | accSynchronized | accNative)
+	prefix := ""
+	if inInterface {
+		// Interface members are implicitly public, and abstract unless they
+		// have a body.
+		mask &^= classfile.AccPublic | classfile.AccAbstract
+		if flags&(classfile.AccAbstract|classfile.AccStatic) == 0 {
+			prefix = "default "
+		}
+	}
+	decl := modifiers(flags, mask) + prefix
+
+	sig := m.Signature
+	if sig == nil {
+		sig, _ = classfile.ParseMethodSignature(m.Descriptor)
+	}
+	if sig == nil { // malformed descriptor
+		return decl + m.Name + m.Descriptor
+	}
+	if tp := typeParams(sig.TypeParams); tp != "" {
+		decl += tp + " "
+	}
+	if m.Name == "<init>" {
+		decl += simpleName
+	} else {
+		decl += sig.Return.String() + " " + m.Name
+	}
+
+	params := make([]string, len(sig.Params))
+	for i, p := range sig.Params {
+		params[i] = p.String()
+	}
+	if n := len(params); n > 0 && flags&accVarargs != 0 && sig.Params[n-1].Kind == '[' {
+		params[n-1] = sig.Params[n-1].Elem.String() + "..."
+	}
+	decl += "(" + strings.Join(params, ", ") + ")"
+
+	throws := make([]string, 0, len(m.Throws))
+	if len(sig.Throws) > 0 {
+		for _, t := range sig.Throws {
+			throws = append(throws, t.String())
+		}
+	} else {
+		for _, t := range m.Throws {
+			throws = append(throws, javaName(t))
+		}
+	}
+	if len(throws) > 0 {
+		decl += " throws " + strings.Join(throws, ", ")
+	}
+	return decl
+}
+
+// typeParams renders formal type parameters such as "<T extends Comparable<T>>",
+// or "" if there are none.
+func typeParams(params []classfile.TypeParameter) string {
+	if len(params) == 0 {
+		return ""
+	}
+	out := make([]string, len(params))
+	for i, p := range params {
+		var bounds []string
+		if p.ClassBound != nil && p.ClassBound.ClassName != "java/lang/Object" {
+			bounds = append(bounds, p.ClassBound.String())
+		}
+		for _, b := range p.InterfaceBounds {
+			bounds = append(bounds, b.String())
+		}
+		out[i] = p.Name
+		if len(bounds) > 0 {
+			out[i] += " extends " + strings.Join(bounds, " & ")
+		}
+	}
+	return "<" + strings.Join(out, ", ") + ">"
+}
+
+// fieldType renders the declared type of f.
+func fieldType(f classfile.FieldInfo) string {
+	if f.Signature != nil {
+		return f.Signature.String()
+	}
+	if t, err := classfile.ParseFieldSignature(f.Descriptor); err == nil {
+		return t.String()
+	}
+	return f.Descriptor
+}
+
+// constantValue renders a ConstantValue entry as a Java literal, or ""
+// if there is none.
+func constantValue(pool []classfile.ConstantPoolEntry, c classfile.ConstantPoolEntry) string {
+	switch c := c.(type) {
+	case *classfile.ConstantInteger:
+		return strconv.Itoa(int(c.Value))
+	case *classfile.ConstantLong:
+		return strconv.FormatInt(c.Value, 10) + "L"
+	case *classfile.ConstantFloat:
+		return strconv.FormatFloat(float64(c.Value), 'g', -1, 32) + "f"
+	case *classfile.ConstantDouble:
+		return strconv.FormatFloat(c.Value, 'g', -1, 64)
+	case *classfile.ConstantString:
+		if s, err := classfile.GetUtf8(pool, c.StringIndex); err == nil {
+			return strconv.Quote(s)
+		}
+	}
+	return ""
+}
+
+// formatAnnotation renders an annotation as it would appear in source.
+func formatAnnotation(a classfile.Annotation) string {
+	s := "@" + descriptorName(a.Type)
+	if len(a.Elements) == 0 {
+		return s
+	}
+	elems := make([]string, len(a.Elements))
+	for i, e := range a.Elements {
+		elems[i] = e.Name + "=" + formatElementValue(e.Value)
+	}
+	return s + "(" + strings.Join(elems, ", ") + ")"
+}
+
+func formatElementValue(v classfile.ElementValue) string {
+	switch v.Tag {
+	case 's':
+		return strconv.Quote(fmt.Sprint(v.Const))
+	case 'C':
+		return strconv.QuoteRune(rune(v.Const.(int32)))
+	case 'Z':
+		return strconv.FormatBool(v.Const.(int32) != 0)
+	case 'e':
+		return descriptorName(v.EnumType) + "." + v.EnumConst
+	case 'c':
+		if t, err := classfile.ParseFieldSignature(v.ClassInfo); err == nil {
+			return t.String() + ".class"
+		}
+		return v.ClassInfo + ".class"
+	case '@':
+		return formatAnnotation(*v.Annotation)
+	case '[':
+		vals := make([]string, len(v.Values))
+		for i, e := range v.Values {
+			vals[i] = formatElementValue(e)
+		}
+		return "{" + strings.Join(vals, ", ") + "}"
+	}
+	return fmt.Sprint(v.Const)
+}
+
+// descriptorName converts a class descriptor such as "Ljava/lang/Deprecated;"
+// to a Java name.
+func descriptorName(descriptor string) string {
+	return javaName(strings.TrimSuffix(strings.TrimPrefix(descriptor, "L"), ";"))
+}
+
+// javaName converts an internal class name to its dotted form.
+func javaName(internal string) string {
+	return strings.ReplaceAll(internal, "/", ".")
+}
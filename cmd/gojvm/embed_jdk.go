@@ -0,0 +1,14 @@
+//go:build embedjdk
+
+package main
+
+import _ "embed"
+
+// embeddedBase is the java.base subset built into self-contained binaries.
+// Create it with a JDK at hand, then build without one needed at run time:
+//
+//	go run ./cmd/gojvm extract-base -o cmd/gojvm/java.base.jar
+//	CGO_ENABLED=0 go build -tags embedjdk ./cmd/gojvm
+//
+//go:embed java.base.jar
+var embeddedBase []byte
@@ -0,0 +1,7 @@
+//go:build !embedjdk
+
+package main
+
+// embeddedBase is nil unless built with -tags embedjdk; JDK classes are
+// then loaded from an installed java.base.jmod.
+var embeddedBase []byte
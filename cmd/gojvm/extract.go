@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/vm"
+)
+
+// extractBase implements "gojvm extract-base": it writes the part of
+// java.base reachable from the VM itself, the smoke programs and any given
+// application classes to a jar for embedding with -tags embedjdk, after
+// checking that the smoke programs run against it. It returns the exit
+// status.
+func extractBase(args []string) int {
+	fs := flag.NewFlagSet("extract-base", flag.ContinueOnError)
+	output := fs.String("o", "java.base.jar", "write the jar to `file`")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gojvm extract-base [-o file] [<classfile>... | <jarfile>]\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	jmodPath := findJmodPath()
+	if jmodPath == "" {
+		fmt.Fprintf(os.Stderr, "Error: could not find java.base.jmod. Set JAVA_HOME or JAVA_BASE_JMOD.\n")
+		return 1
+	}
+	jmod := vm.NewJmodClassLoader(jmodPath)
+
+	// Application classes are roots but not part of the subset.
+	app := &jarClasses{byName: make(map[string]*classfile.ClassFile), parent: jmod}
+	for _, p := range smokePrograms {
+		app.list = append(app.list, p.build())
+	}
+	for _, path := range fs.Args() {
+		if strings.HasSuffix(path, ".jar") {
+			jar, err := readJar(path, jmod)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				return 1
+			}
+			app.list = append(app.list, jar.list...)
+			continue
+		}
+		cf, err := classfile.ParseFile(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		app.list = append(app.list, cf)
+	}
+	roots := append([]string(nil), vm.RuntimeClasses...)
+	for _, cf := range app.list {
+		name, _ := cf.ClassName()
+		app.byName[name] = cf
+		roots = append(roots, name)
+	}
+
+	var names []string
+	for _, name := range vm.ClassClosure(app, roots...) {
+		if _, ok := app.byName[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	var jar bytes.Buffer
+	if err := jmod.WriteSubset(&jar, names); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	subset, err := vm.NewZipClassLoader(jar.Bytes())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	if failed := runSmokePrograms(subset, false); failed > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d smoke programs failed against the subset\n", failed)
+		return 1
+	}
+	if err := os.WriteFile(*output, jar.Bytes(), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Printf("wrote %d classes (%d bytes) to %s\n", len(names), jar.Len(), *output)
+	return 0
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -29,29 +31,120 @@ func findJmodPath() string {
 	return ""
 }
 
+// bootstrapLoader returns the loader for JDK classes: the java.base subset
+// embedded in the binary unless JAVA_BASE_JMOD names a jmod to use
+// instead, and otherwise the java.base.jmod found by findJmodPath.
+func bootstrapLoader() (vm.ClassLoader, error) {
+	if embeddedBase != nil && os.Getenv("JAVA_BASE_JMOD") == "" {
+		cl, err := vm.NewZipClassLoader(embeddedBase)
+		if err != nil {
+			return nil, fmt.Errorf("embedded java.base: %w", err)
+		}
+		return cl, nil
+	}
+	jmodPath := findJmodPath()
+	if jmodPath == "" {
+		return nil, errors.New("could not find java.base.jmod. Set JAVA_HOME or JAVA_BASE_JMOD.")
+	}
+	return vm.NewJmodClassLoader(jmodPath), nil
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: gojvm <classfile>\n")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "trace-view":
+			os.Exit(traceView(os.Args[2:]))
+		case "describe":
+			os.Exit(describe(os.Args[2:]))
+		case "check":
+			os.Exit(check(os.Args[2:]))
+		case "selfcheck":
+			os.Exit(selfcheck(os.Args[2:]))
+		case "extract-base":
+			os.Exit(extractBase(os.Args[2:]))
+		}
+	}
+
+	traceFile := flag.String("trace", "", "write a binary call and instruction trace to `file`")
+	enablePreview := flag.Bool("enable-preview", false, "run classes compiled with preview features")
+	checkReturns := flag.Bool("check-returns", false, "check that returned values match method descriptors")
+	noRecover := flag.Bool("no-recover", false, "crash on internal VM panics instead of throwing InternalError")
+	var props propertyFlags
+	flag.Var(&props, "D", "set a system property, such as user.timezone=UTC (`key=value`, repeatable)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: gojvm [-trace file] [-enable-preview] [-check-returns] [-no-recover] [-D key=value]... <classfile>\n       gojvm trace-view [flags] <tracefile>\n       gojvm describe [-cp dir] <class>\n       gojvm check <classfile>... | <jarfile>\n       gojvm selfcheck\n       gojvm extract-base [-o file] [<classfile>... | <jarfile>]\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
 
-	filename := os.Args[1]
+	filename := flag.Arg(0)
 	dir := filepath.Dir(filename)
 	className := strings.TrimSuffix(filepath.Base(filename), ".class")
 
-	jmodPath := findJmodPath()
-	if jmodPath == "" {
-		fmt.Fprintf(os.Stderr, "Error: could not find java.base.jmod. Set JAVA_HOME or JAVA_BASE_JMOD.\n")
+	bootstrap, err := bootstrapLoader()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-
-	bootstrap := vm.NewJmodClassLoader(jmodPath)
 	userCL := vm.NewUserClassLoader(dir, bootstrap)
 
 	v := vm.NewVM(userCL)
+	v.EnablePreview = *enablePreview
+	v.CheckReturns = *checkReturns
+	v.NoRecover = *noRecover
+	v.FileAccess = true
+	for _, kv := range props {
+		key, value, _ := strings.Cut(kv, "=")
+		v.SetProperty(key, value)
+	}
 
-	if err := v.Execute(className); err != nil {
-		fmt.Fprintf(os.Stderr, "Error executing: %v\n", err)
+	if *traceFile == "" {
+		os.Exit(run(v, className))
+	}
+
+	f, err := os.Create(*traceFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
+	v.Trace = vm.NewTraceWriter(f)
+	status := run(v, className)
+	err = v.Trace.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing trace: %v\n", err)
+		status = 1
+	}
+	os.Exit(status)
+}
+
+// propertyFlags collects repeated -D key=value flags.
+type propertyFlags []string
+
+func (p *propertyFlags) String() string { return strings.Join(*p, ",") }
+
+func (p *propertyFlags) Set(kv string) error {
+	if key, _, ok := strings.Cut(kv, "="); !ok || key == "" {
+		return fmt.Errorf("want key=value, got %q", kv)
+	}
+	*p = append(*p, kv)
+	return nil
+}
+
+// run executes the main class and returns the process exit status.
+func run(v *vm.VM, className string) int {
+	if err := v.Execute(className); err != nil {
+		if v.ReportUncaught(v.Stderr, err) {
+			return 1
+		}
+		fmt.Fprintf(v.Stderr, "Error executing: %v\n", err)
+		return 1
+	}
+	return 0
 }
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/vm"
+)
+
+// smokeProgram is a main class built in memory together with the output
+// it must print.
+type smokeProgram struct {
+	name  string
+	want  string
+	build func() *classfile.ClassFile
+}
+
+// smokePrograms cover the interpreter core and enough of java.base to tell
+// whether a deployment, in particular one with an embedded java.base
+// subset, can run real programs.
+var smokePrograms = []smokeProgram{
+	{"hello", "Hello, world\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("Hello")
+		printString := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+		msg := b.String("Hello, world")
+		return smokeMain(b, 2, 1, []byte{
+			vm.OpGetstatic, byte(out >> 8), byte(out), // getstatic System.out
+			vm.OpLdcW, byte(msg >> 8), byte(msg), // ldc_w "Hello, world"
+			vm.OpInvokevirtual, byte(printString >> 8), byte(printString), // invokevirtual println
+			vm.OpReturn,
+		})
+	}},
+	{"arithmetic", "5050\n1234567890123000\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("Arithmetic")
+		printInt := b.Methodref("java/io/PrintStream", "println", "(I)V")
+		printLong := b.Methodref("java/io/PrintStream", "println", "(J)V")
+		big := b.Long(1234567890123)
+		return smokeMain(b, 5, 3, []byte{
+			vm.OpIconst0, vm.OpIstore1, vm.OpIconst1, vm.OpIstore2, // sum = 0; i = 1
+			vm.OpIload2, vm.OpBipush, 100, vm.OpIfIcmpgt, 0, 13, // 4: if i > 100 goto 20
+			vm.OpIload1, vm.OpIload2, vm.OpIadd, vm.OpIstore1, // sum += i
+			vm.OpIinc, 2, 1, // i++
+			vm.OpGoto, 0xff, 0xf3, // goto 4
+			vm.OpGetstatic, byte(out >> 8), byte(out), vm.OpIload1, // 20: getstatic System.out; iload_1
+			vm.OpInvokevirtual, byte(printInt >> 8), byte(printInt), // invokevirtual println(I)
+			vm.OpGetstatic, byte(out >> 8), byte(out), // getstatic System.out
+			vm.OpLdc2W, byte(big >> 8), byte(big), // ldc2_w 1234567890123
+			vm.OpSipush, 0x03, 0xe8, vm.OpI2l, vm.OpLmul, // sipush 1000; i2l; lmul
+			vm.OpInvokevirtual, byte(printLong >> 8), byte(printLong), // invokevirtual println(J)
+			vm.OpReturn,
+		})
+	}},
+	{"strings", "answer=42\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("Strings")
+		printString := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+		sb := b.Class("java/lang/StringBuilder")
+		init := b.Methodref("java/lang/StringBuilder", "<init>", "()V")
+		appendString := b.Methodref("java/lang/StringBuilder", "append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;")
+		appendInt := b.Methodref("java/lang/StringBuilder", "append", "(I)Ljava/lang/StringBuilder;")
+		toString := b.Methodref("java/lang/StringBuilder", "toString", "()Ljava/lang/String;")
+		prefix := b.String("answer=")
+		return smokeMain(b, 3, 2, []byte{
+			vm.OpNew, byte(sb >> 8), byte(sb), vm.OpDup, // new StringBuilder; dup
+			vm.OpInvokespecial, byte(init >> 8), byte(init), // invokespecial <init>
+			vm.OpLdcW, byte(prefix >> 8), byte(prefix), // ldc_w "answer="
+			vm.OpInvokevirtual, byte(appendString >> 8), byte(appendString), // invokevirtual append(String)
+			vm.OpBipush, 42, // bipush 42
+			vm.OpInvokevirtual, byte(appendInt >> 8), byte(appendInt), // invokevirtual append(int)
+			vm.OpInvokevirtual, byte(toString >> 8), byte(toString), vm.OpAstore1, // invokevirtual toString; astore_1
+			vm.OpGetstatic, byte(out >> 8), byte(out), vm.OpAload1, // getstatic System.out; aload_1
+			vm.OpInvokevirtual, byte(printString >> 8), byte(printString), // invokevirtual println
+			vm.OpReturn,
+		})
+	}},
+	{"exceptions", "caught ArithmeticException\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("Exceptions")
+		printString := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+		arithmetic := b.Class("java/lang/ArithmeticException")
+		msg := b.String("caught ArithmeticException")
+		cf := smokeMain(b, 2, 2, []byte{
+			vm.OpIconst1, vm.OpIconst0, vm.OpIdiv, vm.OpPop, // 1 / 0; pop
+			vm.OpReturn,
+			vm.OpAstore1,                              // 5: astore_1
+			vm.OpGetstatic, byte(out >> 8), byte(out), // getstatic System.out
+			vm.OpLdcW, byte(msg >> 8), byte(msg), // ldc_w "caught ArithmeticException"
+			vm.OpInvokevirtual, byte(printString >> 8), byte(printString), // invokevirtual println
+			vm.OpReturn,
+		})
+		cf.FindMethodByName("main").Code.ExceptionHandlers = []classfile.ExceptionHandler{
+			{StartPC: 0, EndPC: 4, HandlerPC: 5, CatchType: arithmetic},
+		}
+		return cf
+	}},
+	{"collections", "2\nb\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("Collections")
+		printInt := b.Methodref("java/io/PrintStream", "println", "(I)V")
+		printString := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+		list := b.Class("java/util/ArrayList")
+		str := b.Class("java/lang/String")
+		init := b.Methodref("java/util/ArrayList", "<init>", "()V")
+		add := b.Methodref("java/util/ArrayList", "add", "(Ljava/lang/Object;)Z")
+		size := b.Methodref("java/util/ArrayList", "size", "()I")
+		get := b.Methodref("java/util/ArrayList", "get", "(I)Ljava/lang/Object;")
+		a, bb := b.String("a"), b.String("b")
+		return smokeMain(b, 3, 2, []byte{
+			vm.OpNew, byte(list >> 8), byte(list), vm.OpDup, // new ArrayList; dup
+			vm.OpInvokespecial, byte(init >> 8), byte(init), vm.OpAstore1, // invokespecial <init>; astore_1
+			vm.OpAload1, vm.OpLdcW, byte(a >> 8), byte(a), // aload_1; ldc_w "a"
+			vm.OpInvokevirtual, byte(add >> 8), byte(add), vm.OpPop, // invokevirtual add; pop
+			vm.OpAload1, vm.OpLdcW, byte(bb >> 8), byte(bb), // aload_1; ldc_w "b"
+			vm.OpInvokevirtual, byte(add >> 8), byte(add), vm.OpPop, // invokevirtual add; pop
+			vm.OpGetstatic, byte(out >> 8), byte(out), vm.OpAload1, // getstatic System.out; aload_1
+			vm.OpInvokevirtual, byte(size >> 8), byte(size), // invokevirtual size
+			vm.OpInvokevirtual, byte(printInt >> 8), byte(printInt), // invokevirtual println(I)
+			vm.OpGetstatic, byte(out >> 8), byte(out), vm.OpAload1, vm.OpIconst1, // getstatic System.out; aload_1; iconst_1
+			vm.OpInvokevirtual, byte(get >> 8), byte(get), // invokevirtual get
+			vm.OpCheckcast, byte(str >> 8), byte(str), // checkcast String
+			vm.OpInvokevirtual, byte(printString >> 8), byte(printString), // invokevirtual println(String)
+			vm.OpReturn,
+		})
+	}},
+	{"hashmap", "v\n", func() *classfile.ClassFile {
+		b, out := smokeBuilder("HashMap")
+		printString := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+		hashMap := b.Class("java/util/HashMap")
+		str := b.Class("java/lang/String")
+		init := b.Methodref("java/util/HashMap", "<init>", "()V")
+		put := b.Methodref("java/util/HashMap", "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;")
+		get := b.Methodref("java/util/HashMap", "get", "(Ljava/lang/Object;)Ljava/lang/Object;")
+		k, v := b.String("k"), b.String("v")
+		return smokeMain(b, 4, 2, []byte{
+			vm.OpNew, byte(hashMap >> 8), byte(hashMap), vm.OpDup, // new HashMap; dup
+			vm.OpInvokespecial, byte(init >> 8), byte(init), vm.OpAstore1, // invokespecial <init>; astore_1
+			vm.OpAload1, vm.OpLdcW, byte(k >> 8), byte(k), vm.OpLdcW, byte(v >> 8), byte(v), // aload_1; ldc_w "k"; ldc_w "v"
+			vm.OpInvokevirtual, byte(put >> 8), byte(put), vm.OpPop, // invokevirtual put; pop
+			vm.OpGetstatic, byte(out >> 8), byte(out), vm.OpAload1, // getstatic System.out; aload_1
+			vm.OpLdcW, byte(k >> 8), byte(k), // ldc_w "k"
+			vm.OpInvokevirtual, byte(get >> 8), byte(get), // invokevirtual get
+			vm.OpCheckcast, byte(str >> 8), byte(str), // checkcast String
+			vm.OpInvokevirtual, byte(printString >> 8), byte(printString), // invokevirtual println
+			vm.OpReturn,
+		})
+	}},
+}
+
+// smokeBuilder starts the class of a smoke program and returns it with
+// the pool index of System.out.
+func smokeBuilder(name string) (*classfile.Builder, uint16) {
+	b := classfile.NewBuilder("selfcheck/"+name, "java/lang/Object")
+	return b, b.Fieldref("java/lang/System", "out", "Ljava/io/PrintStream;")
+}
+
+// smokeMain adds a main method with the given code and builds the class.
+func smokeMain(b *classfile.Builder, maxStack, maxLocals uint16, code []byte) *classfile.ClassFile {
+	b.AddMethod(classfile.AccPublic|classfile.AccStatic, "main", "([Ljava/lang/String;)V", &classfile.CodeAttribute{
+		MaxStack:  maxStack,
+		MaxLocals: maxLocals,
+		Code:      code,
+	})
+	return b.Build()
+}
+
+// runSmokeProgram runs p against the JDK classes of bootstrap.
+func runSmokeProgram(p smokeProgram, bootstrap vm.ClassLoader) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	cf := p.build()
+	name, _ := cf.ClassName()
+	loader := &jarClasses{byName: map[string]*classfile.ClassFile{name: cf}, parent: bootstrap}
+	var out bytes.Buffer
+	v := vm.NewVM(loader)
+	v.Stdout = &out
+	if err := v.Execute(name); err != nil {
+		return err
+	}
+	if out.String() != p.want {
+		return fmt.Errorf("printed %q, want %q", out.String(), p.want)
+	}
+	return nil
+}
+
+// runSmokePrograms runs every smoke program, reporting each to stdout if
+// verbose, and returns the number that failed.
+func runSmokePrograms(bootstrap vm.ClassLoader, verbose bool) int {
+	failed := 0
+	for _, p := range smokePrograms {
+		err := runSmokeProgram(p, bootstrap)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %v\n", p.name, err)
+		case verbose:
+			fmt.Printf("ok   %s\n", p.name)
+		}
+	}
+	return failed
+}
+
+// selfcheck implements "gojvm selfcheck": it runs the built-in smoke
+// programs to verify that this binary and its java.base work, which is
+// useful after deploying to a new environment. It returns 0 if all pass.
+func selfcheck(args []string) int {
+	fs := flag.NewFlagSet("selfcheck", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gojvm selfcheck\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	bootstrap, err := bootstrapLoader()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	switch cl := bootstrap.(type) {
+	case *vm.ZipClassLoader:
+		fmt.Printf("java.base: embedded subset of %d classes\n", cl.Len())
+	case *vm.JmodClassLoader:
+		fmt.Printf("java.base: %s\n", cl.JmodPath)
+	}
+	if failed := runSmokePrograms(bootstrap, true); failed > 0 {
+		fmt.Printf("selfcheck: %d of %d programs failed\n", failed, len(smokePrograms))
+		return 1
+	}
+	fmt.Printf("selfcheck: all %d programs passed\n", len(smokePrograms))
+	return 0
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/vm"
+)
+
+// traceView implements "gojvm trace-view": it prints the events of a trace
+// written with -trace, one per line and indented by call depth, keeping
+// only those that match the filters. It returns the exit status.
+func traceView(args []string) int {
+	fs := flag.NewFlagSet("trace-view", flag.ContinueOnError)
+	method := fs.String("method", "", "only events in methods whose \"class.name:descriptor\" contains `substring`")
+	thread := fs.String("thread", "", "only events on the thread with this `name`")
+	pcRange := fs.String("pc", "", "only instructions at a pc in `lo-hi` (or a single pc)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: gojvm trace-view [flags] <tracefile>\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	lo, hi, err := parsePCRange(*pcRange)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 2
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	tr, err := vm.NewTraceReader(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	for {
+		ev, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return 0
+		}
+		if err != nil {
+			out.Flush()
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return 1
+		}
+		if *thread != "" && ev.Thread != *thread ||
+			*method != "" && !strings.Contains(ev.Method, *method) ||
+			*pcRange != "" && (ev.Kind != vm.TraceInstruction || ev.PC < lo || ev.PC > hi) {
+			continue
+		}
+
+		indent := strings.Repeat("  ", ev.Depth)
+		switch ev.Kind {
+		case vm.TraceCall:
+			fmt.Fprintf(out, "[%s] %s-> %s\n", ev.Thread, indent, ev.Method)
+		case vm.TraceReturn:
+			fmt.Fprintf(out, "[%s] %s<- %s\n", ev.Thread, indent, ev.Method)
+		case vm.TraceInstruction:
+			fmt.Fprintf(out, "[%s] %s   %5d: %s\n", ev.Thread, indent, ev.PC, vm.OpcodeName(ev.Opcode))
+		case vm.TraceThrow:
+			fmt.Fprintf(out, "[%s] %s!! %s\n", ev.Thread, indent, ev.Class)
+		}
+	}
+}
+
+// parsePCRange parses "lo-hi" or a single pc. An empty string matches
+// every pc.
+func parsePCRange(s string) (lo, hi int, err error) {
+	if s == "" {
+		return 0, int(^uint(0) >> 1), nil
+	}
+	from, to, isRange := strings.Cut(s, "-")
+	if lo, err = strconv.Atoi(from); err != nil {
+		return 0, 0, fmt.Errorf("invalid pc range %q", s)
+	}
+	hi = lo
+	if isRange {
+		if hi, err = strconv.Atoi(to); err != nil || hi < lo {
+			return 0, 0, fmt.Errorf("invalid pc range %q", s)
+		}
+	}
+	return lo, hi, nil
+}
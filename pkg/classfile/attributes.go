@@ -0,0 +1,465 @@
+package classfile
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// attributeReader reads big-endian values from the body of an attribute,
+// reporting truncation as an error instead of panicking.
+type attributeReader struct {
+	name string
+	data []byte
+	off  int
+	err  error
+}
+
+func newAttributeReader(name string, data []byte) *attributeReader {
+	return &attributeReader{name: name, data: data}
+}
+
+func (r *attributeReader) need(n int) bool {
+	if r.err != nil {
+		return false
+	}
+	if r.off+n > len(r.data) {
+		r.err = fmt.Errorf("%s truncated at offset %d", r.name, r.off)
+		return false
+	}
+	return true
+}
+
+func (r *attributeReader) u1() uint8 {
+	if !r.need(1) {
+		return 0
+	}
+	v := r.data[r.off]
+	r.off++
+	return v
+}
+
+func (r *attributeReader) u2() uint16 {
+	if !r.need(2) {
+		return 0
+	}
+	v := binary.BigEndian.Uint16(r.data[r.off:])
+	r.off += 2
+	return v
+}
+
+func (r *attributeReader) u4() uint32 {
+	if !r.need(4) {
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.data[r.off:])
+	r.off += 4
+	return v
+}
+
+func (r *attributeReader) bytes(n int) []byte {
+	if !r.need(n) {
+		return nil
+	}
+	b := r.data[r.off : r.off+n]
+	r.off += n
+	return b
+}
+
+// utf8 reads a u2 constant pool index and resolves it as a Utf8 entry.
+func (r *attributeReader) utf8(pool []ConstantPoolEntry) string {
+	idx := r.u2()
+	if r.err != nil {
+		return ""
+	}
+	s, err := GetUtf8(pool, idx)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", r.name, err)
+	}
+	return s
+}
+
+// className reads a u2 CONSTANT_Class index and resolves it. Index 0 is
+// allowed where the format permits it and yields "".
+func (r *attributeReader) className(pool []ConstantPoolEntry) string {
+	idx := r.u2()
+	if r.err != nil || idx == 0 {
+		return ""
+	}
+	s, err := GetClassName(pool, idx)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", r.name, err)
+	}
+	return s
+}
+
+// optionalUtf8 is like utf8 but maps index 0 to "".
+func (r *attributeReader) optionalUtf8(pool []ConstantPoolEntry) string {
+	if r.need(2) && binary.BigEndian.Uint16(r.data[r.off:]) == 0 {
+		r.off += 2
+		return ""
+	}
+	return r.utf8(pool)
+}
+
+// parseInnerClasses parses an InnerClasses attribute body.
+func parseInnerClasses(data []byte, pool []ConstantPoolEntry) ([]InnerClass, error) {
+	r := newAttributeReader("InnerClasses", data)
+	count := r.u2()
+	classes := make([]InnerClass, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		classes = append(classes, InnerClass{
+			InnerClass:  r.className(pool),
+			OuterClass:  r.className(pool),
+			InnerName:   r.optionalUtf8(pool),
+			AccessFlags: r.u2(),
+		})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return classes, nil
+}
+
+// parseEnclosingMethod parses an EnclosingMethod attribute body.
+func parseEnclosingMethod(data []byte, pool []ConstantPoolEntry) (*EnclosingMethod, error) {
+	r := newAttributeReader("EnclosingMethod", data)
+	em := &EnclosingMethod{Class: r.className(pool)}
+	idx := r.u2()
+	if r.err != nil {
+		return nil, r.err
+	}
+	if idx != 0 {
+		nat, ok := constantAt(pool, idx).(*ConstantNameAndType)
+		if !ok {
+			return nil, fmt.Errorf("EnclosingMethod: constant pool index %d is not NameAndType", idx)
+		}
+		var err error
+		if em.MethodName, err = GetUtf8(pool, nat.NameIndex); err != nil {
+			return nil, fmt.Errorf("EnclosingMethod: %w", err)
+		}
+		if em.Descriptor, err = GetUtf8(pool, nat.DescriptorIndex); err != nil {
+			return nil, fmt.Errorf("EnclosingMethod: %w", err)
+		}
+	}
+	return em, nil
+}
+
+// parseExceptions parses an Exceptions attribute body into the names of
+// the declared exception classes.
+func parseExceptions(data []byte, pool []ConstantPoolEntry) ([]string, error) {
+	r := newAttributeReader("Exceptions", data)
+	count := r.u2()
+	throws := make([]string, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		idx := r.u2()
+		if r.err != nil {
+			break
+		}
+		name, err := GetClassName(pool, idx)
+		if err != nil {
+			return nil, fmt.Errorf("Exceptions: %w", err)
+		}
+		throws = append(throws, name)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return throws, nil
+}
+
+// parseSignatureAttribute returns the signature string a Signature
+// attribute body refers to.
+func parseSignatureAttribute(data []byte, pool []ConstantPoolEntry) (string, error) {
+	r := newAttributeReader("Signature", data)
+	sig := r.utf8(pool)
+	return sig, r.err
+}
+
+// parseRecord parses a Record attribute body. The result is non-nil even
+// for a record without components. A malformed Signature or annotations
+// of a component are dropped unless strict is set, as they are for fields.
+func parseRecord(data []byte, pool []ConstantPoolEntry, strict bool) ([]RecordComponent, error) {
+	r := newAttributeReader("Record", data)
+	count := r.u2()
+	components := make([]RecordComponent, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		rc := RecordComponent{Name: r.utf8(pool), Descriptor: r.utf8(pool)}
+		attrCount := r.u2()
+		for j := uint16(0); j < attrCount && r.err == nil; j++ {
+			name := r.utf8(pool)
+			body := r.bytes(int(r.u4()))
+			if r.err != nil {
+				break
+			}
+			switch name {
+			case "Signature":
+				sig, err := parseSignatureAttribute(body, pool)
+				var parsed *TypeSignature
+				if err == nil {
+					parsed, err = ParseFieldSignature(sig)
+				}
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing Signature for record component %s: %v", rc.Name, err)
+					}
+					continue
+				}
+				rc.Signature = parsed
+			case "RuntimeVisibleAnnotations":
+				anns, err := parseAnnotations(body, pool)
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing annotations for record component %s: %v", rc.Name, err)
+					}
+					continue
+				}
+				rc.Annotations = anns
+			}
+		}
+		components = append(components, rc)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return components, nil
+}
+
+// parseNestHost parses a NestHost attribute body.
+func parseNestHost(data []byte, pool []ConstantPoolEntry) (string, error) {
+	r := newAttributeReader("NestHost", data)
+	host := r.className(pool)
+	if r.err == nil && host == "" {
+		r.err = fmt.Errorf("NestHost: host_class_index is 0")
+	}
+	return host, r.err
+}
+
+// parseNestMembers parses a NestMembers attribute body.
+func parseNestMembers(data []byte, pool []ConstantPoolEntry) ([]string, error) {
+	r := newAttributeReader("NestMembers", data)
+	count := r.u2()
+	members := make([]string, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		members = append(members, r.className(pool))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return members, nil
+}
+
+// parsePermittedSubclasses parses a PermittedSubclasses attribute body.
+func parsePermittedSubclasses(data []byte, pool []ConstantPoolEntry) ([]string, error) {
+	r := newAttributeReader("PermittedSubclasses", data)
+	count := r.u2()
+	classes := make([]string, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		classes = append(classes, r.className(pool))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return classes, nil
+}
+
+// moduleName reads a u2 CONSTANT_Module index and resolves it.
+func (r *attributeReader) moduleName(pool []ConstantPoolEntry) string {
+	idx := r.u2()
+	if r.err != nil {
+		return ""
+	}
+	s, err := GetModuleName(pool, idx)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", r.name, err)
+	}
+	return s
+}
+
+// packageName reads a u2 CONSTANT_Package index and resolves it.
+func (r *attributeReader) packageName(pool []ConstantPoolEntry) string {
+	idx := r.u2()
+	if r.err != nil {
+		return ""
+	}
+	s, err := GetPackageName(pool, idx)
+	if err != nil {
+		r.err = fmt.Errorf("%s: %w", r.name, err)
+	}
+	return s
+}
+
+// parseModule parses a Module attribute body.
+func parseModule(data []byte, pool []ConstantPoolEntry) (*Module, error) {
+	r := newAttributeReader("Module", data)
+	m := &Module{
+		Name:    r.moduleName(pool),
+		Flags:   r.u2(),
+		Version: r.optionalUtf8(pool),
+	}
+
+	count := r.u2()
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		m.Requires = append(m.Requires, ModuleRequires{
+			Module:  r.moduleName(pool),
+			Flags:   r.u2(),
+			Version: r.optionalUtf8(pool),
+		})
+	}
+	// exports and opens share a layout; only the target kind differs
+	packages := func() []ModulePackage {
+		var dirs []ModulePackage
+		count := r.u2()
+		for i := uint16(0); i < count && r.err == nil; i++ {
+			dir := ModulePackage{Package: r.packageName(pool), Flags: r.u2()}
+			toCount := r.u2()
+			for j := uint16(0); j < toCount && r.err == nil; j++ {
+				dir.To = append(dir.To, r.moduleName(pool))
+			}
+			dirs = append(dirs, dir)
+		}
+		return dirs
+	}
+	m.Exports = packages()
+	m.Opens = packages()
+
+	count = r.u2()
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		m.Uses = append(m.Uses, r.className(pool))
+	}
+	count = r.u2()
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		p := ModuleProvides{Service: r.className(pool)}
+		withCount := r.u2()
+		for j := uint16(0); j < withCount && r.err == nil; j++ {
+			p.With = append(p.With, r.className(pool))
+		}
+		m.Provides = append(m.Provides, p)
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return m, nil
+}
+
+// parseSourceFile parses a SourceFile attribute body.
+func parseSourceFile(data []byte, pool []ConstantPoolEntry) (string, error) {
+	r := newAttributeReader("SourceFile", data)
+	name := r.utf8(pool)
+	return name, r.err
+}
+
+// parseLineNumberTable parses a LineNumberTable attribute body.
+func parseLineNumberTable(data []byte) ([]LineNumber, error) {
+	r := newAttributeReader("LineNumberTable", data)
+	count := r.u2()
+	lines := make([]LineNumber, 0, count)
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		lines = append(lines, LineNumber{StartPC: r.u2(), Line: r.u2()})
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return lines, nil
+}
+
+// parseConstantValue parses a ConstantValue attribute body and returns the
+// referenced constant pool entry.
+func parseConstantValue(data []byte, pool []ConstantPoolEntry) (ConstantPoolEntry, error) {
+	r := newAttributeReader("ConstantValue", data)
+	idx := r.u2()
+	if r.err != nil {
+		return nil, r.err
+	}
+	switch c := constantAt(pool, idx).(type) {
+	case *ConstantInteger, *ConstantFloat, *ConstantLong, *ConstantDouble, *ConstantString:
+		return c, nil
+	}
+	return nil, fmt.Errorf("ConstantValue: invalid constant pool index %d", idx)
+}
+
+// parseAnnotations parses a RuntimeVisibleAnnotations attribute body.
+func parseAnnotations(data []byte, pool []ConstantPoolEntry) ([]Annotation, error) {
+	r := newAttributeReader("RuntimeVisibleAnnotations", data)
+	count := r.u2()
+	var annotations []Annotation
+	for i := uint16(0); i < count && r.err == nil; i++ {
+		annotations = append(annotations, r.annotation(pool))
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return annotations, nil
+}
+
+func (r *attributeReader) annotation(pool []ConstantPoolEntry) Annotation {
+	a := Annotation{Type: r.utf8(pool)}
+	pairs := r.u2()
+	for i := uint16(0); i < pairs && r.err == nil; i++ {
+		name := r.utf8(pool)
+		a.Elements = append(a.Elements, ElementValuePair{Name: name, Value: r.elementValue(pool)})
+	}
+	return a
+}
+
+func (r *attributeReader) elementValue(pool []ConstantPoolEntry) ElementValue {
+	ev := ElementValue{Tag: r.u1()}
+	if r.err != nil {
+		return ev
+	}
+	switch ev.Tag {
+	case 'B', 'C', 'I', 'S', 'Z':
+		idx := r.u2()
+		if c, ok := constantAt(pool, idx).(*ConstantInteger); ok {
+			ev.Const = c.Value
+		} else if r.err == nil {
+			r.err = fmt.Errorf("%s: element value '%c' does not reference an Integer constant", r.name, ev.Tag)
+		}
+	case 'J':
+		idx := r.u2()
+		if c, ok := constantAt(pool, idx).(*ConstantLong); ok {
+			ev.Const = c.Value
+		} else if r.err == nil {
+			r.err = fmt.Errorf("%s: element value 'J' does not reference a Long constant", r.name)
+		}
+	case 'F':
+		idx := r.u2()
+		if c, ok := constantAt(pool, idx).(*ConstantFloat); ok {
+			ev.Const = c.Value
+		} else if r.err == nil {
+			r.err = fmt.Errorf("%s: element value 'F' does not reference a Float constant", r.name)
+		}
+	case 'D':
+		idx := r.u2()
+		if c, ok := constantAt(pool, idx).(*ConstantDouble); ok {
+			ev.Const = c.Value
+		} else if r.err == nil {
+			r.err = fmt.Errorf("%s: element value 'D' does not reference a Double constant", r.name)
+		}
+	case 's':
+		ev.Const = r.utf8(pool)
+	case 'e':
+		ev.EnumType = r.utf8(pool)
+		ev.EnumConst = r.utf8(pool)
+	case 'c':
+		ev.ClassInfo = r.utf8(pool)
+	case '@':
+		nested := r.annotation(pool)
+		ev.Annotation = &nested
+	case '[':
+		n := r.u2()
+		for i := uint16(0); i < n && r.err == nil; i++ {
+			ev.Values = append(ev.Values, r.elementValue(pool))
+		}
+	default:
+		r.err = fmt.Errorf("%s: unknown element value tag '%c'", r.name, ev.Tag)
+	}
+	return ev
+}
+
+// constantAt returns the constant pool entry at index, or nil if out of range.
+func constantAt(pool []ConstantPoolEntry, index uint16) ConstantPoolEntry {
+	if int(index) >= len(pool) {
+		return nil
+	}
+	return pool[index]
+}
@@ -0,0 +1,328 @@
+package classfile
+
+import (
+	"os"
+	"testing"
+)
+
+func TestParseAnnotations(t *testing.T) {
+	// @Tag(value = "x", level = 3, kind = Kind.A, names = {"p"}, inner = @Inner)
+	pool := make([]ConstantPoolEntry, 12)
+	pool[1] = &ConstantUtf8{Value: "LTag;"}
+	pool[2] = &ConstantUtf8{Value: "value"}
+	pool[3] = &ConstantUtf8{Value: "x"}
+	pool[4] = &ConstantUtf8{Value: "level"}
+	pool[5] = &ConstantInteger{Value: 3}
+	pool[6] = &ConstantUtf8{Value: "kind"}
+	pool[7] = &ConstantUtf8{Value: "LKind;"}
+	pool[8] = &ConstantUtf8{Value: "A"}
+	pool[9] = &ConstantUtf8{Value: "names"}
+	pool[10] = &ConstantUtf8{Value: "inner"}
+	pool[11] = &ConstantUtf8{Value: "LInner;"}
+
+	data := []byte{
+		0x00, 0x01, // num_annotations
+		0x00, 0x01, 0x00, 0x05, // type_index=LTag;, num_element_value_pairs=5
+		0x00, 0x02, 's', 0x00, 0x03, // value = "x"
+		0x00, 0x04, 'I', 0x00, 0x05, // level = 3
+		0x00, 0x06, 'e', 0x00, 0x07, 0x00, 0x08, // kind = Kind.A
+		0x00, 0x09, '[', 0x00, 0x01, 's', 0x00, 0x03, // names = {"x"}
+		0x00, 0x0A, '@', 0x00, 0x0B, 0x00, 0x00, // inner = @Inner
+	}
+
+	anns, err := parseAnnotations(data, pool)
+	if err != nil {
+		t.Fatalf("parseAnnotations: %v", err)
+	}
+	a := FindAnnotation(anns, "LTag;")
+	if a == nil {
+		t.Fatal("annotation LTag; not found")
+	}
+
+	if v, ok := a.Element("value"); !ok || v.Const != "x" {
+		t.Errorf("value: got %+v", v)
+	}
+	if v, ok := a.Element("level"); !ok || v.Const != int32(3) {
+		t.Errorf("level: got %+v", v)
+	}
+	if v, _ := a.Element("kind"); v.EnumType != "LKind;" || v.EnumConst != "A" {
+		t.Errorf("kind: got %+v", v)
+	}
+	if v, _ := a.Element("names"); len(v.Values) != 1 || v.Values[0].Const != "x" {
+		t.Errorf("names: got %+v", v)
+	}
+	if v, _ := a.Element("inner"); v.Annotation == nil || v.Annotation.Type != "LInner;" {
+		t.Errorf("inner: got %+v", v)
+	}
+	if _, ok := a.Element("missing"); ok {
+		t.Error("missing element reported as present")
+	}
+
+	// 途中で切れたデータはエラーになること
+	if _, err := parseAnnotations(data[:len(data)-3], pool); err == nil {
+		t.Error("expected error for truncated annotations")
+	}
+}
+
+func TestParseInnerClasses(t *testing.T) {
+	f, err := os.Open("../../testdata/EnumTest$Color.class")
+	if err != nil {
+		t.Fatalf("failed to open EnumTest$Color.class: %v", err)
+	}
+	defer f.Close()
+	cf, err := Parse(f)
+	if err != nil {
+		t.Fatalf("failed to parse EnumTest$Color.class: %v", err)
+	}
+
+	// メンバークラスなので EnclosingMethod はない
+	if cf.EnclosingMethod != nil {
+		t.Errorf("EnclosingMethod: got %+v, want nil", cf.EnclosingMethod)
+	}
+	if !cf.IsNested() {
+		t.Fatal("EnumTest$Color should be nested")
+	}
+	if got := cf.SimpleName(); got != "Color" {
+		t.Errorf("SimpleName: got %q, want %q", got, "Color")
+	}
+	if got := cf.EnclosingClassName(); got != "EnumTest" {
+		t.Errorf("EnclosingClassName: got %q, want %q", got, "EnumTest")
+	}
+	// ネストした enum は暗黙に static final
+	if got := cf.ModifierFlags(); got&(AccStatic|0x0010|0x4000) != AccStatic|0x0010|0x4000 {
+		t.Errorf("ModifierFlags: got 0x%04x, want static final enum", got)
+	}
+}
+
+func TestParseEnclosingMethod(t *testing.T) {
+	pool := make([]ConstantPoolEntry, 6)
+	pool[1] = &ConstantUtf8{Value: "Outer"}
+	pool[2] = &ConstantClass{NameIndex: 1}
+	pool[3] = &ConstantUtf8{Value: "run"}
+	pool[4] = &ConstantUtf8{Value: "()V"}
+	pool[5] = &ConstantNameAndType{NameIndex: 3, DescriptorIndex: 4}
+
+	em, err := parseEnclosingMethod([]byte{0, 2, 0, 5}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *em != (EnclosingMethod{Class: "Outer", MethodName: "run", Descriptor: "()V"}) {
+		t.Errorf("got %+v", *em)
+	}
+
+	// 初期化子内の匿名クラスは method_index が 0
+	em, err = parseEnclosingMethod([]byte{0, 2, 0, 0}, pool)
+	if err != nil || em.MethodName != "" {
+		t.Errorf("got %+v, %v", em, err)
+	}
+
+	if _, err := parseEnclosingMethod([]byte{0, 2}, pool); err == nil {
+		t.Error("expected truncation error")
+	}
+}
+
+func TestParseExceptions(t *testing.T) {
+	pool := make([]ConstantPoolEntry, 5)
+	pool[1] = &ConstantUtf8{Value: "java/io/IOException"}
+	pool[2] = &ConstantClass{NameIndex: 1}
+	pool[3] = &ConstantUtf8{Value: "java/lang/InterruptedException"}
+	pool[4] = &ConstantClass{NameIndex: 3}
+
+	// throws IOException, InterruptedException
+	throws, err := parseExceptions([]byte{0, 2, 0, 2, 0, 4}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(throws) != 2 || throws[0] != "java/io/IOException" || throws[1] != "java/lang/InterruptedException" {
+		t.Errorf("got %v", throws)
+	}
+
+	// Class 以外を指すインデックスはエラー
+	if _, err := parseExceptions([]byte{0, 1, 0, 1}, pool); err == nil {
+		t.Error("expected error for non-Class index")
+	}
+	if _, err := parseExceptions([]byte{0, 2, 0, 2}, pool); err == nil {
+		t.Error("expected truncation error")
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	pool := make([]ConstantPoolEntry, 7)
+	pool[1] = &ConstantUtf8{Value: "x"}
+	pool[2] = &ConstantUtf8{Value: "I"}
+	pool[3] = &ConstantUtf8{Value: "items"}
+	pool[4] = &ConstantUtf8{Value: "Ljava/util/List;"}
+	pool[5] = &ConstantUtf8{Value: "Signature"}
+	pool[6] = &ConstantUtf8{Value: "Ljava/util/List<Ljava/lang/String;>;"}
+
+	// record R(int x, List<String> items)
+	data := []byte{
+		0, 2,
+		0, 1, 0, 2, 0, 0, // x:I, 属性なし
+		0, 3, 0, 4, 0, 1, // items:List, 属性 1 個
+		0, 5, 0, 0, 0, 2, 0, 6, // Signature
+	}
+	rcs, err := parseRecord(data, pool, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rcs) != 2 || rcs[0].Name != "x" || rcs[0].Descriptor != "I" || rcs[0].Signature != nil {
+		t.Fatalf("got %+v", rcs)
+	}
+	if rcs[1].Signature == nil || rcs[1].Signature.String() != "java.util.List<java.lang.String>" {
+		t.Errorf("items signature: got %+v", rcs[1].Signature)
+	}
+
+	// コンポーネントなしの record も nil ではない
+	empty, err := parseRecord([]byte{0, 0}, pool, true)
+	if err != nil || empty == nil {
+		t.Errorf("empty record: got %v, %v", empty, err)
+	}
+
+	if _, err := parseRecord(data[:len(data)-1], pool, false); err == nil {
+		t.Error("expected truncation error")
+	}
+}
+
+func TestParseNestAttributes(t *testing.T) {
+	open := func(name string) *ClassFile {
+		t.Helper()
+		f, err := os.Open("../../testdata/" + name + ".class")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		cf, err := Parse(f)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return cf
+	}
+
+	host := open("EnumTest")
+	member := open("EnumTest$Color")
+	if host.NestHost != "" || host.NestHostName() != "EnumTest" {
+		t.Errorf("host: NestHost=%q NestHostName=%q", host.NestHost, host.NestHostName())
+	}
+	if !host.HasNestMember("EnumTest$Color") {
+		t.Errorf("host NestMembers: got %v", host.NestMembers)
+	}
+	if member.NestHost != "EnumTest" || member.NestMembers != nil {
+		t.Errorf("member: NestHost=%q NestMembers=%v", member.NestHost, member.NestMembers)
+	}
+
+	// NestHost の host_class_index 0 は不正
+	if _, err := parseNestHost([]byte{0, 0}, host.ConstantPool); err == nil {
+		t.Error("expected error for host_class_index 0")
+	}
+}
+
+func TestParsePermittedSubclasses(t *testing.T) {
+	pool := []ConstantPoolEntry{
+		nil,
+		&ConstantClass{NameIndex: 2},
+		&ConstantUtf8{Value: "Shape$Circle"},
+		&ConstantClass{NameIndex: 4},
+		&ConstantUtf8{Value: "Shape$Square"},
+	}
+	classes, err := parsePermittedSubclasses([]byte{0, 2, 0, 1, 0, 3}, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf := &ClassFile{PermittedSubclasses: classes}
+	if !cf.IsSealed() || !cf.Permits("Shape$Circle") || !cf.Permits("Shape$Square") {
+		t.Errorf("got %v", classes)
+	}
+	if cf.Permits("Shape$Triangle") {
+		t.Error("Shape$Triangle should not be permitted")
+	}
+	if open := (&ClassFile{}); open.IsSealed() || !open.Permits("Anything") {
+		t.Error("a class without PermittedSubclasses must permit every subclass")
+	}
+
+	if _, err := parsePermittedSubclasses([]byte{0, 2, 0, 1}, pool); err == nil {
+		t.Error("expected truncation error")
+	}
+}
+
+func TestParseModule(t *testing.T) {
+	// module app@1.0 { requires transitive java.base; exports app.api to lib;
+	//                  opens app.impl; uses app.Spi; provides app.Spi with app.Impl; }
+	pool := []ConstantPoolEntry{
+		nil,
+		&ConstantModule{NameIndex: 2}, &ConstantUtf8{Value: "app"},
+		&ConstantUtf8{Value: "1.0"},
+		&ConstantModule{NameIndex: 5}, &ConstantUtf8{Value: "java.base"},
+		&ConstantPackage{NameIndex: 7}, &ConstantUtf8{Value: "app/api"},
+		&ConstantModule{NameIndex: 9}, &ConstantUtf8{Value: "lib"},
+		&ConstantPackage{NameIndex: 11}, &ConstantUtf8{Value: "app/impl"},
+		&ConstantClass{NameIndex: 13}, &ConstantUtf8{Value: "app/Spi"},
+		&ConstantClass{NameIndex: 15}, &ConstantUtf8{Value: "app/Impl"},
+	}
+	data := []byte{
+		0, 1, 0, 0, 0, 3, // name, flags, version
+		0, 1, 0, 4, 0x00, 0x20, 0, 0, // requires
+		0, 1, 0, 6, 0, 0, 0, 1, 0, 8, // exports
+		0, 1, 0, 10, 0, 0, 0, 0, // opens
+		0, 1, 0, 12, // uses
+		0, 1, 0, 12, 0, 1, 0, 14, // provides
+	}
+	m, err := parseModule(data, pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "app" || m.Version != "1.0" {
+		t.Errorf("name/version: got %q %q", m.Name, m.Version)
+	}
+	if len(m.Requires) != 1 || m.Requires[0] != (ModuleRequires{Module: "java.base", Flags: 0x20}) {
+		t.Errorf("requires: got %+v", m.Requires)
+	}
+	if len(m.Exports) != 1 || m.Exports[0].Package != "app/api" || len(m.Exports[0].To) != 1 || m.Exports[0].To[0] != "lib" {
+		t.Errorf("exports: got %+v", m.Exports)
+	}
+	if len(m.Opens) != 1 || m.Opens[0].Package != "app/impl" || m.Opens[0].To != nil {
+		t.Errorf("opens: got %+v", m.Opens)
+	}
+	if len(m.Uses) != 1 || m.Uses[0] != "app/Spi" {
+		t.Errorf("uses: got %v", m.Uses)
+	}
+	if len(m.Provides) != 1 || m.Provides[0].Service != "app/Spi" || len(m.Provides[0].With) != 1 || m.Provides[0].With[0] != "app/Impl" {
+		t.Errorf("provides: got %+v", m.Provides)
+	}
+
+	if _, err := parseModule(data[:len(data)-1], pool); err == nil {
+		t.Error("expected truncation error")
+	}
+	// the module name must be a CONSTANT_Module, not a CONSTANT_Class
+	if _, err := parseModule([]byte{0, 12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, pool); err == nil {
+		t.Error("expected error for a non-Module name index")
+	}
+}
+
+func TestParseSourceFileAndLineNumbers(t *testing.T) {
+	cf, err := ParseFile("../../testdata/EnumTest.class")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf.SourceFile != "EnumTest.java" {
+		t.Errorf("SourceFile: got %q", cf.SourceFile)
+	}
+	main := cf.FindMethod("main", "([Ljava/lang/String;)V")
+	if main == nil || len(main.Code.LineNumbers) == 0 {
+		t.Fatal("main has no LineNumberTable")
+	}
+	first := main.Code.LineNumbers[0]
+	if got := main.Code.LineNumber(int(first.StartPC)); got != int(first.Line) {
+		t.Errorf("LineNumber(%d): got %d, want %d", first.StartPC, got, first.Line)
+	}
+
+	code := &CodeAttribute{LineNumbers: []LineNumber{{StartPC: 4, Line: 10}, {StartPC: 0, Line: 9}, {StartPC: 9, Line: 12}}}
+	for pc, want := range map[int]int{0: 9, 3: 9, 4: 10, 8: 10, 20: 12} {
+		if got := code.LineNumber(pc); got != want {
+			t.Errorf("LineNumber(%d): got %d, want %d", pc, got, want)
+		}
+	}
+	if got := (&CodeAttribute{}).LineNumber(0); got != -1 {
+		t.Errorf("without LineNumberTable: got %d, want -1", got)
+	}
+}
@@ -0,0 +1,153 @@
+package classfile
+
+// Builder assembles a ClassFile from scratch, managing the constant pool.
+// Constant methods return pool indices for use in bytecode operands:
+//
+//	b := NewBuilder("Gen", "java/lang/Object")
+//	b.AddMethod(AccPublic|AccStatic, "answer", "()I", &CodeAttribute{
+//		MaxStack: 1,
+//		Code:     []byte{0x10, 42, 0xac}, // bipush 42; ireturn
+//	})
+//	data, err := b.Build().Bytes()
+type Builder struct {
+	cp *poolBuilder
+	cf *ClassFile
+}
+
+// NewBuilder starts a public class named name (internal form) extending
+// superName. superName is "" only for java/lang/Object.
+func NewBuilder(name, superName string) *Builder {
+	b := &Builder{
+		cp: newPoolBuilder(nil),
+		cf: &ClassFile{MajorVersion: 52, AccessFlags: AccPublic | AccSuper},
+	}
+	b.cf.ThisClass = b.Class(name)
+	if superName != "" {
+		b.cf.SuperClass = b.Class(superName)
+	}
+	return b
+}
+
+// SetAccessFlags replaces the class access flags.
+func (b *Builder) SetAccessFlags(flags uint16) {
+	b.cf.AccessFlags = flags
+}
+
+// AddInterface declares that the class implements name.
+func (b *Builder) AddInterface(name string) {
+	b.cf.Interfaces = append(b.cf.Interfaces, b.Class(name))
+}
+
+// AddField adds a field. constant is the ConstantValue of a static final
+// field and may be nil.
+func (b *Builder) AddField(flags uint16, name, descriptor string, constant ConstantPoolEntry) {
+	b.cf.Fields = append(b.cf.Fields, FieldInfo{
+		AccessFlags:   flags,
+		Name:          name,
+		Descriptor:    descriptor,
+		ConstantValue: constant,
+	})
+}
+
+// AddMethod adds a method. code is nil for abstract and native methods.
+func (b *Builder) AddMethod(flags uint16, name, descriptor string, code *CodeAttribute) {
+	b.cf.Methods = append(b.cf.Methods, MethodInfo{
+		AccessFlags: flags,
+		Name:        name,
+		Descriptor:  descriptor,
+		Code:        code,
+	})
+}
+
+// AddBootstrapMethod adds a BootstrapMethods entry and returns its index
+// for use with InvokeDynamic and Dynamic.
+func (b *Builder) AddBootstrapMethod(methodHandle uint16, args ...uint16) uint16 {
+	b.cf.BootstrapMethods = append(b.cf.BootstrapMethods, BootstrapMethod{
+		MethodRef:          methodHandle,
+		BootstrapArguments: args,
+	})
+	return uint16(len(b.cf.BootstrapMethods) - 1)
+}
+
+// Build returns the assembled class file. The builder must not be used
+// afterwards.
+func (b *Builder) Build() *ClassFile {
+	b.cf.ConstantPool = b.cp.pool
+	return b.cf
+}
+
+// Utf8 returns the index of a CONSTANT_Utf8 entry.
+func (b *Builder) Utf8(s string) uint16 {
+	return b.cp.utf8(s)
+}
+
+// Class returns the index of a CONSTANT_Class entry.
+func (b *Builder) Class(name string) uint16 {
+	return b.cp.add(&ConstantClass{NameIndex: b.Utf8(name)})
+}
+
+// String returns the index of a CONSTANT_String entry.
+func (b *Builder) String(s string) uint16 {
+	return b.cp.add(&ConstantString{StringIndex: b.Utf8(s)})
+}
+
+// Integer returns the index of a CONSTANT_Integer entry.
+func (b *Builder) Integer(v int32) uint16 {
+	return b.cp.add(&ConstantInteger{Value: v})
+}
+
+// Float returns the index of a CONSTANT_Float entry.
+func (b *Builder) Float(v float32) uint16 {
+	return b.cp.add(&ConstantFloat{Value: v})
+}
+
+// Long returns the index of a CONSTANT_Long entry.
+func (b *Builder) Long(v int64) uint16 {
+	return b.cp.add(&ConstantLong{Value: v})
+}
+
+// Double returns the index of a CONSTANT_Double entry.
+func (b *Builder) Double(v float64) uint16 {
+	return b.cp.add(&ConstantDouble{Value: v})
+}
+
+// NameAndType returns the index of a CONSTANT_NameAndType entry.
+func (b *Builder) NameAndType(name, descriptor string) uint16 {
+	return b.cp.add(&ConstantNameAndType{NameIndex: b.Utf8(name), DescriptorIndex: b.Utf8(descriptor)})
+}
+
+// Fieldref returns the index of a CONSTANT_Fieldref entry.
+func (b *Builder) Fieldref(class, name, descriptor string) uint16 {
+	return b.cp.add(&ConstantFieldref{ClassIndex: b.Class(class), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// Methodref returns the index of a CONSTANT_Methodref entry.
+func (b *Builder) Methodref(class, name, descriptor string) uint16 {
+	return b.cp.add(&ConstantMethodref{ClassIndex: b.Class(class), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// InterfaceMethodref returns the index of a CONSTANT_InterfaceMethodref entry.
+func (b *Builder) InterfaceMethodref(class, name, descriptor string) uint16 {
+	return b.cp.add(&ConstantInterfaceMethodref{ClassIndex: b.Class(class), NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// MethodHandle returns the index of a CONSTANT_MethodHandle entry.
+// reference is the index of the referenced Fieldref or Methodref.
+func (b *Builder) MethodHandle(kind uint8, reference uint16) uint16 {
+	return b.cp.add(&ConstantMethodHandle{ReferenceKind: kind, ReferenceIndex: reference})
+}
+
+// MethodType returns the index of a CONSTANT_MethodType entry.
+func (b *Builder) MethodType(descriptor string) uint16 {
+	return b.cp.add(&ConstantMethodType{DescriptorIndex: b.Utf8(descriptor)})
+}
+
+// Dynamic returns the index of a CONSTANT_Dynamic entry.
+func (b *Builder) Dynamic(bootstrap uint16, name, descriptor string) uint16 {
+	return b.cp.add(&ConstantDynamic{BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
+
+// InvokeDynamic returns the index of a CONSTANT_InvokeDynamic entry.
+func (b *Builder) InvokeDynamic(bootstrap uint16, name, descriptor string) uint16 {
+	return b.cp.add(&ConstantInvokeDynamic{BootstrapMethodAttrIndex: bootstrap, NameAndTypeIndex: b.NameAndType(name, descriptor)})
+}
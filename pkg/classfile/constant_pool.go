@@ -24,6 +24,8 @@ const (
 	TagMethodType         = 16
 	TagDynamic            = 17
 	TagInvokeDynamic      = 18
+	TagModule             = 19
+	TagPackage            = 20
 )
 
 // parseConstantPool reads constant_pool_count-1 entries from the reader.
@@ -160,7 +162,22 @@ func parseConstantPool(r io.Reader, count uint16) ([]ConstantPoolEntry, error) {
 			if err := binary.Read(r, binary.BigEndian, &natIndex); err != nil {
 				return nil, fmt.Errorf("reading InvokeDynamic at index %d: %w", i, err)
 			}
-			pool[i] = &ConstantInvokeDynamic{BootstrapMethodAttrIndex: bsmIndex, NameAndTypeIndex: natIndex}
+			if tag == TagDynamic {
+				pool[i] = &ConstantDynamic{BootstrapMethodAttrIndex: bsmIndex, NameAndTypeIndex: natIndex}
+			} else {
+				pool[i] = &ConstantInvokeDynamic{BootstrapMethodAttrIndex: bsmIndex, NameAndTypeIndex: natIndex}
+			}
+
+		case TagModule, TagPackage:
+			var nameIndex uint16
+			if err := binary.Read(r, binary.BigEndian, &nameIndex); err != nil {
+				return nil, fmt.Errorf("reading Module/Package at index %d: %w", i, err)
+			}
+			if tag == TagModule {
+				pool[i] = &ConstantModule{NameIndex: nameIndex}
+			} else {
+				pool[i] = &ConstantPackage{NameIndex: nameIndex}
+			}
 
 		default:
 			return nil, fmt.Errorf("unknown constant pool tag %d at index %d", tag, i)
@@ -201,6 +218,31 @@ func GetClassName(pool []ConstantPoolEntry, classIndex uint16) (string, error) {
 	return GetUtf8(pool, class.NameIndex)
 }
 
+// GetModuleName returns the module name referenced by a CONSTANT_Module entry.
+func GetModuleName(pool []ConstantPoolEntry, index uint16) (string, error) {
+	if int(index) >= len(pool) || pool[index] == nil {
+		return "", fmt.Errorf("invalid constant pool index %d", index)
+	}
+	module, ok := pool[index].(*ConstantModule)
+	if !ok {
+		return "", fmt.Errorf("constant pool index %d is not Module", index)
+	}
+	return GetUtf8(pool, module.NameIndex)
+}
+
+// GetPackageName returns the internal package name ("java/lang")
+// referenced by a CONSTANT_Package entry.
+func GetPackageName(pool []ConstantPoolEntry, index uint16) (string, error) {
+	if int(index) >= len(pool) || pool[index] == nil {
+		return "", fmt.Errorf("invalid constant pool index %d", index)
+	}
+	pkg, ok := pool[index].(*ConstantPackage)
+	if !ok {
+		return "", fmt.Errorf("constant pool index %d is not Package", index)
+	}
+	return GetUtf8(pool, pkg.NameIndex)
+}
+
 // MethodRefInfo holds resolved method reference info.
 type MethodRefInfo struct {
 	ClassName  string
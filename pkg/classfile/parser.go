@@ -21,6 +21,11 @@ func ParseFile(path string) (*ClassFile, error) {
 
 // Parse reads a .class file from the given reader and returns a ClassFile.
 func Parse(r io.Reader) (*ClassFile, error) {
+	return ParseWithOptions(r, ParseOptions{})
+}
+
+// ParseWithOptions is like Parse but with optional strict validation.
+func ParseWithOptions(r io.Reader, opts ParseOptions) (*ClassFile, error) {
 	cf := &ClassFile{}
 
 	// Magic number
@@ -50,6 +55,11 @@ func Parse(r io.Reader) (*ClassFile, error) {
 		return nil, fmt.Errorf("parsing constant pool: %w", err)
 	}
 	cf.ConstantPool = pool
+	if opts.Strict {
+		if err := validateConstantPool(pool); err != nil {
+			return nil, err
+		}
+	}
 
 	// Access flags, this_class, super_class
 	if err := binary.Read(r, binary.BigEndian, &cf.AccessFlags); err != nil {
@@ -79,7 +89,7 @@ func Parse(r io.Reader) (*ClassFile, error) {
 	if err := binary.Read(r, binary.BigEndian, &fieldsCount); err != nil {
 		return nil, fmt.Errorf("reading fields count: %w", err)
 	}
-	cf.Fields, err = parseFields(r, cf.ConstantPool, fieldsCount)
+	cf.Fields, err = parseFields(r, cf.ConstantPool, fieldsCount, opts.Strict)
 	if err != nil {
 		return nil, fmt.Errorf("parsing fields: %w", err)
 	}
@@ -89,20 +99,29 @@ func Parse(r io.Reader) (*ClassFile, error) {
 	if err := binary.Read(r, binary.BigEndian, &methodsCount); err != nil {
 		return nil, fmt.Errorf("reading methods count: %w", err)
 	}
-	cf.Methods, err = parseMethods(r, cf.ConstantPool, methodsCount)
+	cf.Methods, err = parseMethods(r, cf.ConstantPool, methodsCount, opts.Strict)
 	if err != nil {
 		return nil, fmt.Errorf("parsing methods: %w", err)
 	}
 
-	// Class-level attributes (parse BootstrapMethods, skip others)
-	if err := cf.parseClassAttributes(r); err != nil {
+	// Class-level attributes (parse known attributes, skip others)
+	if err := cf.parseClassAttributes(r, opts.Strict); err != nil {
 		return nil, fmt.Errorf("parsing class attributes: %w", err)
 	}
 
+	if opts.Strict {
+		if n, _ := r.Read(make([]byte, 1)); n != 0 {
+			return nil, formatError("extra bytes at the end of the class file")
+		}
+		if err := cf.validate(); err != nil {
+			return nil, err
+		}
+	}
+
 	return cf, nil
 }
 
-func parseFields(r io.Reader, pool []ConstantPoolEntry, count uint16) ([]FieldInfo, error) {
+func parseFields(r io.Reader, pool []ConstantPoolEntry, count uint16, strict bool) ([]FieldInfo, error) {
 	fields := make([]FieldInfo, count)
 	for i := uint16(0); i < count; i++ {
 		var accessFlags, nameIndex, descIndex, attrCount uint16
@@ -139,11 +158,43 @@ func parseFields(r io.Reader, pool []ConstantPoolEntry, count uint16) ([]FieldIn
 			Descriptor:  desc,
 			Attributes:  attrs,
 		}
+
+		for _, attr := range attrs {
+			switch attr.Name {
+			case "RuntimeVisibleAnnotations":
+				anns, err := parseAnnotations(attr.Data, pool)
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing annotations for field %s: %v", name, err)
+					}
+					continue
+				}
+				fields[i].Annotations = anns
+			case "ConstantValue":
+				fields[i].ConstantValue, err = parseConstantValue(attr.Data, pool)
+				if err != nil {
+					return nil, fmt.Errorf("parsing ConstantValue for field %s: %w", name, err)
+				}
+			case "Signature":
+				sig, err := parseSignatureAttribute(attr.Data, pool)
+				var parsed *TypeSignature
+				if err == nil {
+					parsed, err = ParseFieldSignature(sig)
+				}
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing Signature for field %s: %v", name, err)
+					}
+					continue
+				}
+				fields[i].Signature = parsed
+			}
+		}
 	}
 	return fields, nil
 }
 
-func parseMethods(r io.Reader, pool []ConstantPoolEntry, count uint16) ([]MethodInfo, error) {
+func parseMethods(r io.Reader, pool []ConstantPoolEntry, count uint16, strict bool) ([]MethodInfo, error) {
 	methods := make([]MethodInfo, count)
 	for i := uint16(0); i < count; i++ {
 		var accessFlags, nameIndex, descIndex, attrCount uint16
@@ -181,15 +232,42 @@ func parseMethods(r io.Reader, pool []ConstantPoolEntry, count uint16) ([]Method
 			Attributes:  attrs,
 		}
 
-		// Extract Code attribute
+		// Extract Code, Exceptions, Signature and annotation attributes
 		for _, attr := range attrs {
-			if attr.Name == "Code" {
-				code, err := parseCodeAttribute(attr.Data)
+			switch attr.Name {
+			case "Code":
+				code, err := parseCodeAttribute(attr.Data, pool)
 				if err != nil {
 					return nil, fmt.Errorf("parsing Code attribute for method %s: %w", name, err)
 				}
 				m.Code = code
-				break
+			case "RuntimeVisibleAnnotations":
+				anns, err := parseAnnotations(attr.Data, pool)
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing annotations for method %s: %v", name, err)
+					}
+					continue
+				}
+				m.Annotations = anns
+			case "Exceptions":
+				m.Throws, err = parseExceptions(attr.Data, pool)
+				if err != nil {
+					return nil, fmt.Errorf("parsing Exceptions attribute for method %s: %w", name, err)
+				}
+			case "Signature":
+				sig, err := parseSignatureAttribute(attr.Data, pool)
+				var parsed *MethodSignature
+				if err == nil {
+					parsed, err = ParseMethodSignature(sig)
+				}
+				if err != nil {
+					if strict {
+						return nil, formatError("parsing Signature for method %s: %v", name, err)
+					}
+					continue
+				}
+				m.Signature = parsed
 			}
 		}
 
@@ -224,7 +302,7 @@ func parseAttributeInfos(r io.Reader, pool []ConstantPoolEntry, count uint16) ([
 	return attrs, nil
 }
 
-func parseCodeAttribute(data []byte) (*CodeAttribute, error) {
+func parseCodeAttribute(data []byte, pool []ConstantPoolEntry) (*CodeAttribute, error) {
 	if len(data) < 8 {
 		return nil, fmt.Errorf("Code attribute too short: %d bytes", len(data))
 	}
@@ -261,15 +339,39 @@ func parseCodeAttribute(data []byte) (*CodeAttribute, error) {
 		}
 	}
 
+	// Nested attributes: only LineNumberTable is kept
+	var lines []LineNumber
+	r := newAttributeReader("Code", data)
+	r.off = offset
+	attrCount := uint16(0)
+	if offset < len(data) {
+		attrCount = r.u2()
+	}
+	for i := uint16(0); i < attrCount && r.err == nil; i++ {
+		name := r.utf8(pool)
+		body := r.bytes(int(r.u4()))
+		if name == "LineNumberTable" && r.err == nil {
+			table, err := parseLineNumberTable(body)
+			if err != nil {
+				return nil, err
+			}
+			lines = append(lines, table...)
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+
 	return &CodeAttribute{
 		MaxStack:          maxStack,
 		MaxLocals:         maxLocals,
 		Code:              code,
 		ExceptionHandlers: handlers,
+		LineNumbers:       lines,
 	}, nil
 }
 
-func (cf *ClassFile) parseClassAttributes(r io.Reader) error {
+func (cf *ClassFile) parseClassAttributes(r io.Reader, strict bool) error {
 	var count uint16
 	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
 		return err
@@ -291,11 +393,75 @@ func (cf *ClassFile) parseClassAttributes(r io.Reader) error {
 		if err != nil {
 			continue // skip unknown attributes
 		}
-		if name == "BootstrapMethods" {
+		cf.Attributes = append(cf.Attributes, AttributeInfo{Name: name, Data: data})
+		switch name {
+		case "BootstrapMethods":
 			cf.BootstrapMethods, err = parseBootstrapMethods(data)
 			if err != nil {
 				return fmt.Errorf("parsing BootstrapMethods: %w", err)
 			}
+		case "RuntimeVisibleAnnotations":
+			anns, err := parseAnnotations(data, cf.ConstantPool)
+			if err != nil {
+				if strict {
+					return formatError("parsing RuntimeVisibleAnnotations: %v", err)
+				}
+				continue
+			}
+			cf.Annotations = anns
+		case "InnerClasses":
+			cf.InnerClasses, err = parseInnerClasses(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing InnerClasses: %w", err)
+			}
+		case "EnclosingMethod":
+			cf.EnclosingMethod, err = parseEnclosingMethod(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing EnclosingMethod: %w", err)
+			}
+		case "Signature":
+			sig, err := parseSignatureAttribute(data, cf.ConstantPool)
+			var parsed *ClassSignature
+			if err == nil {
+				parsed, err = ParseClassSignature(sig)
+			}
+			if err != nil {
+				if strict {
+					return formatError("parsing Signature: %v", err)
+				}
+				continue
+			}
+			cf.Signature = parsed
+		case "Record":
+			cf.RecordComponents, err = parseRecord(data, cf.ConstantPool, strict)
+			if err != nil {
+				return fmt.Errorf("parsing Record: %w", err)
+			}
+		case "NestHost":
+			cf.NestHost, err = parseNestHost(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing NestHost: %w", err)
+			}
+		case "NestMembers":
+			cf.NestMembers, err = parseNestMembers(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing NestMembers: %w", err)
+			}
+		case "PermittedSubclasses":
+			cf.PermittedSubclasses, err = parsePermittedSubclasses(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing PermittedSubclasses: %w", err)
+			}
+		case "SourceFile":
+			cf.SourceFile, err = parseSourceFile(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing SourceFile: %w", err)
+			}
+		case "Module":
+			cf.Module, err = parseModule(data, cf.ConstantPool)
+			if err != nil {
+				return fmt.Errorf("parsing Module: %w", err)
+			}
 		}
 	}
 	return nil
@@ -0,0 +1,311 @@
+package classfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Generic signatures (JVMS §4.7.9.1). Descriptors are a subset of the same
+// grammar, so ParseFieldSignature and ParseMethodSignature accept plain
+// descriptors as well.
+
+// TypeSignature is a parsed reference or base type. Which fields are set
+// depends on Kind:
+//
+//	B C D F I J S Z V  base type (V only as a method return type)
+//	L                  ClassName, TypeArgs, Owner
+//	T                  Name (type variable)
+//	[                  Elem
+type TypeSignature struct {
+	Kind      byte
+	ClassName string // internal name; nested classes are joined with '$'
+	TypeArgs  []TypeArgument
+	Owner     *TypeSignature // parameterized outer class, e.g. Outer<T> in Outer<T>.Inner
+	Name      string
+	Elem      *TypeSignature
+}
+
+// TypeArgument is an actual type argument. Wildcard is 0 for an exact type,
+// '+' for "? extends", '-' for "? super" and '*' for an unbounded "?".
+type TypeArgument struct {
+	Wildcard byte
+	Type     *TypeSignature // nil when Wildcard is '*'
+}
+
+// TypeParameter is a formal type parameter such as <T extends Comparable<T>>.
+type TypeParameter struct {
+	Name            string
+	ClassBound      *TypeSignature // nil when only interface bounds are given
+	InterfaceBounds []*TypeSignature
+}
+
+// ClassSignature is the parsed Signature attribute of a class.
+type ClassSignature struct {
+	TypeParams []TypeParameter
+	Super      *TypeSignature
+	Interfaces []*TypeSignature
+}
+
+// MethodSignature is the parsed Signature attribute (or descriptor) of a method.
+type MethodSignature struct {
+	TypeParams []TypeParameter
+	Params     []*TypeSignature
+	Return     *TypeSignature
+	Throws     []*TypeSignature
+}
+
+var baseTypeNames = map[byte]string{
+	'B': "byte", 'C': "char", 'D': "double", 'F': "float",
+	'I': "int", 'J': "long", 'S': "short", 'Z': "boolean", 'V': "void",
+}
+
+// String renders the type as it would appear in Java source, e.g.
+// "java.util.Map<java.lang.String, ? extends T>[]".
+func (t *TypeSignature) String() string {
+	switch t.Kind {
+	case 'T':
+		return t.Name
+	case '[':
+		return t.Elem.String() + "[]"
+	case 'L':
+		var sb strings.Builder
+		name := t.ClassName
+		if t.Owner != nil {
+			sb.WriteString(t.Owner.String())
+			sb.WriteByte('$')
+			name = name[len(t.Owner.ClassName)+1:]
+		}
+		sb.WriteString(strings.ReplaceAll(name, "/", "."))
+		if len(t.TypeArgs) > 0 {
+			sb.WriteByte('<')
+			for i, a := range t.TypeArgs {
+				if i > 0 {
+					sb.WriteString(", ")
+				}
+				sb.WriteString(a.String())
+			}
+			sb.WriteByte('>')
+		}
+		return sb.String()
+	}
+	return baseTypeNames[t.Kind]
+}
+
+func (a TypeArgument) String() string {
+	switch a.Wildcard {
+	case '*':
+		return "?"
+	case '+':
+		return "? extends " + a.Type.String()
+	case '-':
+		return "? super " + a.Type.String()
+	}
+	return a.Type.String()
+}
+
+// ParseClassSignature parses a class Signature attribute value.
+func ParseClassSignature(sig string) (*ClassSignature, error) {
+	p := &signatureParser{sig: sig}
+	cs := &ClassSignature{TypeParams: p.typeParams()}
+	cs.Super = p.classType()
+	for p.err == nil && p.pos < len(sig) {
+		cs.Interfaces = append(cs.Interfaces, p.classType())
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return cs, nil
+}
+
+// ParseMethodSignature parses a method Signature attribute value or descriptor.
+func ParseMethodSignature(sig string) (*MethodSignature, error) {
+	p := &signatureParser{sig: sig}
+	ms := &MethodSignature{TypeParams: p.typeParams()}
+	p.expect('(')
+	for p.err == nil && p.peek() != ')' {
+		ms.Params = append(ms.Params, p.javaType())
+	}
+	p.expect(')')
+	if p.peek() == 'V' {
+		p.pos++
+		ms.Return = &TypeSignature{Kind: 'V'}
+	} else {
+		ms.Return = p.javaType()
+	}
+	for p.err == nil && p.peek() == '^' {
+		p.pos++
+		if p.peek() == 'T' {
+			ms.Throws = append(ms.Throws, p.typeVariable())
+		} else {
+			ms.Throws = append(ms.Throws, p.classType())
+		}
+	}
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return ms, nil
+}
+
+// ParseFieldSignature parses a field Signature attribute value or descriptor.
+func ParseFieldSignature(sig string) (*TypeSignature, error) {
+	p := &signatureParser{sig: sig}
+	t := p.javaType()
+	if err := p.finish(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// signatureParser is a recursive-descent parser over a signature string.
+// The first error is kept in err; later calls become no-ops.
+type signatureParser struct {
+	sig string
+	pos int
+	err error
+}
+
+func (p *signatureParser) peek() byte {
+	if p.err != nil || p.pos >= len(p.sig) {
+		return 0
+	}
+	return p.sig[p.pos]
+}
+
+func (p *signatureParser) fail(format string, args ...interface{}) {
+	if p.err == nil {
+		p.err = fmt.Errorf("malformed signature %q at offset %d: %s", p.sig, p.pos, fmt.Sprintf(format, args...))
+	}
+}
+
+func (p *signatureParser) expect(c byte) {
+	if p.peek() != c {
+		p.fail("expected '%c'", c)
+		return
+	}
+	p.pos++
+}
+
+func (p *signatureParser) finish() error {
+	if p.err == nil && p.pos != len(p.sig) {
+		p.fail("unexpected trailing characters")
+	}
+	return p.err
+}
+
+// identifier reads up to (not including) the first of . ; [ / < > :
+func (p *signatureParser) identifier() string {
+	start := p.pos
+	for p.err == nil && p.pos < len(p.sig) && !strings.ContainsRune(".;[/<>:", rune(p.sig[p.pos])) {
+		p.pos++
+	}
+	if p.pos == start {
+		p.fail("expected identifier")
+	}
+	return p.sig[start:p.pos]
+}
+
+func (p *signatureParser) typeParams() []TypeParameter {
+	if p.peek() != '<' {
+		return nil
+	}
+	p.pos++
+	var params []TypeParameter
+	for p.err == nil && p.peek() != '>' {
+		tp := TypeParameter{Name: p.identifier()}
+		p.expect(':')
+		if c := p.peek(); c == 'L' || c == 'T' || c == '[' {
+			tp.ClassBound = p.referenceType()
+		}
+		for p.err == nil && p.peek() == ':' {
+			p.pos++
+			tp.InterfaceBounds = append(tp.InterfaceBounds, p.referenceType())
+		}
+		params = append(params, tp)
+	}
+	p.expect('>')
+	if p.err == nil && len(params) == 0 {
+		p.fail("empty type parameter list")
+	}
+	return params
+}
+
+// javaType parses a base type or a reference type.
+func (p *signatureParser) javaType() *TypeSignature {
+	c := p.peek()
+	if c != 'V' && baseTypeNames[c] != "" {
+		p.pos++
+		return &TypeSignature{Kind: c}
+	}
+	return p.referenceType()
+}
+
+func (p *signatureParser) referenceType() *TypeSignature {
+	switch p.peek() {
+	case 'L':
+		return p.classType()
+	case 'T':
+		return p.typeVariable()
+	case '[':
+		p.pos++
+		return &TypeSignature{Kind: '[', Elem: p.javaType()}
+	}
+	p.fail("expected reference type")
+	return &TypeSignature{}
+}
+
+func (p *signatureParser) typeVariable() *TypeSignature {
+	p.expect('T')
+	t := &TypeSignature{Kind: 'T', Name: p.identifier()}
+	p.expect(';')
+	return t
+}
+
+func (p *signatureParser) classType() *TypeSignature {
+	p.expect('L')
+	name := p.identifier()
+	for p.err == nil && p.peek() == '/' {
+		p.pos++
+		name += "/" + p.identifier()
+	}
+	t := &TypeSignature{Kind: 'L', ClassName: name, TypeArgs: p.typeArgs()}
+	for p.err == nil && p.peek() == '.' {
+		p.pos++
+		owner := t
+		t = &TypeSignature{Kind: 'L', Owner: owner}
+		t.ClassName = owner.ClassName + "$" + p.identifier()
+		t.TypeArgs = p.typeArgs()
+	}
+	p.expect(';')
+	// A non-generic owner adds nothing over the '$'-joined name.
+	for inner := t; inner != nil; inner = inner.Owner {
+		if inner.Owner != nil && len(inner.Owner.TypeArgs) == 0 && inner.Owner.Owner == nil {
+			inner.Owner = nil
+		}
+	}
+	return t
+}
+
+func (p *signatureParser) typeArgs() []TypeArgument {
+	if p.peek() != '<' {
+		return nil
+	}
+	p.pos++
+	var args []TypeArgument
+	for p.err == nil && p.peek() != '>' {
+		switch c := p.peek(); c {
+		case '*':
+			p.pos++
+			args = append(args, TypeArgument{Wildcard: '*'})
+		case '+', '-':
+			p.pos++
+			args = append(args, TypeArgument{Wildcard: c, Type: p.referenceType()})
+		default:
+			args = append(args, TypeArgument{Type: p.referenceType()})
+		}
+	}
+	p.expect('>')
+	if p.err == nil && len(args) == 0 {
+		p.fail("empty type argument list")
+	}
+	return args
+}
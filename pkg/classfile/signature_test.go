@@ -0,0 +1,90 @@
+package classfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSignatures(t *testing.T) {
+	tests := []struct {
+		kind, sig, want string
+	}{
+		{"field", "Ljava/util/List<Ljava/lang/String;>;", "java.util.List<java.lang.String>"},
+		{"field", "Ljava/util/Map<TK;+Ljava/lang/Number;>;", "java.util.Map<K, ? extends java.lang.Number>"},
+		{"field", "[[Ljava/util/List<*>;", "java.util.List<?>[][]"},
+		{"field", "Lp/Outer<TT;>.Inner<-TU;>;", "p.Outer<T>$Inner<? super U>"},
+		{"field", "Lp/Outer.Inner;", "p.Outer$Inner"},
+		{"field", "I", "int"},
+		{"method", "<T::Ljava/lang/Comparable<-TT;>;>(Ljava/util/List<TT;>;I)TT;^Ljava/io/IOException;", ""},
+		{"method", "([Ljava/lang/String;)V", ""},
+		{"class", "<E:Ljava/lang/Object;>Ljava/util/AbstractList<TE;>;Ljava/util/RandomAccess;", ""},
+	}
+	for _, tt := range tests {
+		switch tt.kind {
+		case "field":
+			ts, err := ParseFieldSignature(tt.sig)
+			if err != nil {
+				t.Errorf("%s: %v", tt.sig, err)
+				continue
+			}
+			if got := ts.String(); got != tt.want {
+				t.Errorf("%s: got %q, want %q", tt.sig, got, tt.want)
+			}
+		case "method":
+			if _, err := ParseMethodSignature(tt.sig); err != nil {
+				t.Errorf("%s: %v", tt.sig, err)
+			}
+		case "class":
+			if _, err := ParseClassSignature(tt.sig); err != nil {
+				t.Errorf("%s: %v", tt.sig, err)
+			}
+		}
+	}
+
+	// 型パラメータ・throws の中身を確認
+	ms, _ := ParseMethodSignature("<T::Ljava/lang/Comparable<-TT;>;>(Ljava/util/List<TT;>;I)TT;^Ljava/io/IOException;")
+	if len(ms.TypeParams) != 1 || ms.TypeParams[0].Name != "T" || ms.TypeParams[0].ClassBound != nil ||
+		len(ms.TypeParams[0].InterfaceBounds) != 1 {
+		t.Errorf("type params: got %+v", ms.TypeParams)
+	}
+	if len(ms.Params) != 2 || ms.Params[1].Kind != 'I' || ms.Return.Name != "T" {
+		t.Errorf("params/return: got %v -> %v", ms.Params, ms.Return)
+	}
+	if len(ms.Throws) != 1 || ms.Throws[0].ClassName != "java/io/IOException" {
+		t.Errorf("throws: got %v", ms.Throws)
+	}
+
+	cs, _ := ParseClassSignature("<E:Ljava/lang/Object;>Ljava/util/AbstractList<TE;>;Ljava/util/RandomAccess;")
+	if cs.Super.String() != "java.util.AbstractList<E>" || len(cs.Interfaces) != 1 {
+		t.Errorf("class signature: got super=%v interfaces=%v", cs.Super, cs.Interfaces)
+	}
+
+	for _, bad := range []string{"", "Ljava/util/List<>;", "Ljava/lang/String", "Q", "TT", "II"} {
+		if _, err := ParseFieldSignature(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
+
+func TestParseTestdataSignatures(t *testing.T) {
+	// testdata のクラスはすべて Signature 込みでパースできること
+	files, _ := filepath.Glob("../../testdata/*.class")
+	for _, path := range files {
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cf, err := Parse(f)
+		f.Close()
+		if err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+			continue
+		}
+		if filepath.Base(path) == "GenericClass$Pair.class" {
+			if cf.Signature == nil || len(cf.Signature.TypeParams) != 2 || cf.Signature.TypeParams[0].Name != "A" {
+				t.Errorf("GenericClass$Pair: got signature %+v", cf.Signature)
+			}
+		}
+	}
+}
@@ -1,10 +1,15 @@
 package classfile
 
+import "strings"
+
 // Access flags
 const (
-	AccPublic = 0x0001
-	AccStatic = 0x0008
-	AccSuper  = 0x0020
+	AccPublic   = 0x0001
+	AccStatic   = 0x0008
+	AccFinal    = 0x0010
+	AccSuper    = 0x0020
+	AccAbstract = 0x0400
+	AccModule   = 0x8000
 )
 
 // ClassFile represents a parsed .class file.
@@ -18,7 +23,22 @@ type ClassFile struct {
 	Interfaces       []uint16
 	Fields           []FieldInfo
 	Methods          []MethodInfo
+	Attributes       []AttributeInfo // raw class attributes
 	BootstrapMethods []BootstrapMethod
+	Annotations      []Annotation // RuntimeVisibleAnnotations
+	InnerClasses     []InnerClass
+	EnclosingMethod  *EnclosingMethod  // nil unless local or anonymous
+	Signature        *ClassSignature   // nil unless the class is generic
+	RecordComponents []RecordComponent // nil unless the class is a record
+	NestHost         string            // set on nest members, "" otherwise
+	NestMembers      []string          // set on nest hosts
+	// PermittedSubclasses lists the direct subclasses allowed to extend or
+	// implement a sealed class; nil unless the class is sealed.
+	PermittedSubclasses []string
+	// Module is the module descriptor of a module-info class, nil otherwise.
+	Module *Module
+	// SourceFile is the source file name, e.g. "Foo.java"; "" if absent.
+	SourceFile string
 }
 
 // SuperClassName returns the fully qualified name of the super class.
@@ -122,6 +142,15 @@ type ConstantMethodType struct {
 
 func (c *ConstantMethodType) Tag() uint8 { return TagMethodType }
 
+// ConstantDynamic is a dynamically-computed constant (condy), resolved by
+// invoking its bootstrap method with the name and field type.
+type ConstantDynamic struct {
+	BootstrapMethodAttrIndex uint16
+	NameAndTypeIndex         uint16
+}
+
+func (c *ConstantDynamic) Tag() uint8 { return TagDynamic }
+
 type ConstantInvokeDynamic struct {
 	BootstrapMethodAttrIndex uint16
 	NameAndTypeIndex         uint16
@@ -129,12 +158,158 @@ type ConstantInvokeDynamic struct {
 
 func (c *ConstantInvokeDynamic) Tag() uint8 { return TagInvokeDynamic }
 
+type ConstantModule struct {
+	NameIndex uint16
+}
+
+func (c *ConstantModule) Tag() uint8 { return TagModule }
+
+type ConstantPackage struct {
+	NameIndex uint16
+}
+
+func (c *ConstantPackage) Tag() uint8 { return TagPackage }
+
 // BootstrapMethod represents an entry in the BootstrapMethods attribute.
 type BootstrapMethod struct {
 	MethodRef          uint16   // CP index to ConstantMethodHandle
 	BootstrapArguments []uint16 // CP indices
 }
 
+// InnerClass is an entry of the InnerClasses attribute. OuterClass is ""
+// for local and anonymous classes, and InnerName is "" for anonymous ones.
+type InnerClass struct {
+	InnerClass  string
+	OuterClass  string
+	InnerName   string
+	AccessFlags uint16 // flags as declared in source, e.g. private or static
+}
+
+// EnclosingMethod represents the EnclosingMethod attribute of a local or
+// anonymous class. MethodName and Descriptor are "" when the class is not
+// immediately enclosed by a method (e.g. in an initializer).
+type EnclosingMethod struct {
+	Class      string
+	MethodName string
+	Descriptor string
+}
+
+// InnerClassEntry returns the InnerClasses entry describing className, or nil.
+func (cf *ClassFile) InnerClassEntry(className string) *InnerClass {
+	for i := range cf.InnerClasses {
+		if cf.InnerClasses[i].InnerClass == className {
+			return &cf.InnerClasses[i]
+		}
+	}
+	return nil
+}
+
+// IsNested reports whether this class is a member, local or anonymous class.
+func (cf *ClassFile) IsNested() bool {
+	name, err := cf.ClassName()
+	return err == nil && cf.InnerClassEntry(name) != nil
+}
+
+// SimpleName returns the source-level simple name: the InnerName for nested
+// classes ("" for anonymous ones) and the unqualified name otherwise.
+func (cf *ClassFile) SimpleName() string {
+	name, err := cf.ClassName()
+	if err != nil {
+		return ""
+	}
+	if ic := cf.InnerClassEntry(name); ic != nil {
+		return ic.InnerName
+	}
+	return name[strings.LastIndex(name, "/")+1:]
+}
+
+// DeclaringClassName returns the class this one is a member of, or "" for
+// top-level, local and anonymous classes.
+func (cf *ClassFile) DeclaringClassName() string {
+	name, err := cf.ClassName()
+	if err != nil {
+		return ""
+	}
+	if ic := cf.InnerClassEntry(name); ic != nil {
+		return ic.OuterClass
+	}
+	return ""
+}
+
+// EnclosingClassName returns the immediately enclosing class of a nested
+// class, or "" for top-level classes.
+func (cf *ClassFile) EnclosingClassName() string {
+	if cf.EnclosingMethod != nil {
+		return cf.EnclosingMethod.Class
+	}
+	return cf.DeclaringClassName()
+}
+
+// ModifierFlags returns the access flags as declared in source. Nested
+// classes are compiled with package/public flags only, so their real
+// modifiers come from the InnerClasses entry.
+func (cf *ClassFile) ModifierFlags() uint16 {
+	name, err := cf.ClassName()
+	if err == nil {
+		if ic := cf.InnerClassEntry(name); ic != nil {
+			return ic.AccessFlags
+		}
+	}
+	return cf.AccessFlags
+}
+
+// RecordComponent is a component of a record class from the Record attribute.
+type RecordComponent struct {
+	Name        string
+	Descriptor  string
+	Signature   *TypeSignature // generic signature, nil if absent
+	Annotations []Annotation   // RuntimeVisibleAnnotations
+}
+
+// IsRecord reports whether the class was declared as a record.
+func (cf *ClassFile) IsRecord() bool {
+	return cf.RecordComponents != nil && cf.SuperClassName() == "java/lang/Record"
+}
+
+// NestHostName returns the host of the nest this class belongs to. A class
+// without a NestHost attribute is the host of its own nest.
+func (cf *ClassFile) NestHostName() string {
+	if cf.NestHost != "" {
+		return cf.NestHost
+	}
+	name, _ := cf.ClassName()
+	return name
+}
+
+// IsSealed reports whether the class is sealed.
+func (cf *ClassFile) IsSealed() bool {
+	return cf.PermittedSubclasses != nil
+}
+
+// Permits reports whether a sealed class allows name as a direct subclass.
+// Classes that are not sealed permit every subclass.
+func (cf *ClassFile) Permits(name string) bool {
+	if !cf.IsSealed() {
+		return true
+	}
+	for _, p := range cf.PermittedSubclasses {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// HasNestMember reports whether this nest host lists name in NestMembers.
+func (cf *ClassFile) HasNestMember(name string) bool {
+	for _, m := range cf.NestMembers {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
 // MethodInfo represents a method in a class file.
 type MethodInfo struct {
 	AccessFlags uint16
@@ -142,6 +317,9 @@ type MethodInfo struct {
 	Descriptor  string
 	Attributes  []AttributeInfo
 	Code        *CodeAttribute
+	Annotations []Annotation     // RuntimeVisibleAnnotations
+	Throws      []string         // declared exception class names from the Exceptions attribute
+	Signature   *MethodSignature // generic signature, nil if absent
 }
 
 // FieldInfo represents a field in a class file.
@@ -150,6 +328,13 @@ type FieldInfo struct {
 	Name        string
 	Descriptor  string
 	Attributes  []AttributeInfo
+	Annotations []Annotation   // RuntimeVisibleAnnotations
+	Signature   *TypeSignature // generic signature, nil if absent
+
+	// ConstantValue is the constant pool entry named by the field's
+	// ConstantValue attribute (Integer, Float, Long, Double or String),
+	// or nil if the field has none.
+	ConstantValue ConstantPoolEntry
 }
 
 // AttributeInfo represents a raw attribute.
@@ -172,4 +357,127 @@ type CodeAttribute struct {
 	MaxLocals         uint16
 	Code              []byte
 	ExceptionHandlers []ExceptionHandler
+	LineNumbers       []LineNumber // from LineNumberTable, in class file order
+}
+
+// LineNumber maps the bytecode starting at StartPC to a source line.
+type LineNumber struct {
+	StartPC uint16
+	Line    uint16
+}
+
+// LineNumber returns the source line of the instruction at pc, or -1 if
+// the method has no line number information covering it.
+func (c *CodeAttribute) LineNumber(pc int) int {
+	line, best := -1, -1
+	for _, ln := range c.LineNumbers {
+		if int(ln.StartPC) <= pc && int(ln.StartPC) > best {
+			line, best = int(ln.Line), int(ln.StartPC)
+		}
+	}
+	return line
+}
+
+// Annotation represents an annotation from a RuntimeVisibleAnnotations attribute.
+type Annotation struct {
+	Type     string // field descriptor of the annotation interface, e.g. "Ljava/lang/Deprecated;"
+	Elements []ElementValuePair
+}
+
+// ElementValuePair is a single name=value element of an annotation.
+type ElementValuePair struct {
+	Name  string
+	Value ElementValue
+}
+
+// ElementValue is a resolved annotation element value. Which field is set
+// depends on Tag:
+//
+//	B C I S Z  Const (int32)
+//	J          Const (int64)
+//	F          Const (float32)
+//	D          Const (float64)
+//	s          Const (string)
+//	e          EnumType, EnumConst
+//	c          ClassInfo (return descriptor, e.g. "Ljava/lang/String;" or "V")
+//	@          Annotation
+//	[          Values
+type ElementValue struct {
+	Tag        byte
+	Const      interface{}
+	EnumType   string
+	EnumConst  string
+	ClassInfo  string
+	Annotation *Annotation
+	Values     []ElementValue
+}
+
+// FindAnnotation returns the annotation with the given type descriptor, or nil.
+func FindAnnotation(annotations []Annotation, typeDescriptor string) *Annotation {
+	for i := range annotations {
+		if annotations[i].Type == typeDescriptor {
+			return &annotations[i]
+		}
+	}
+	return nil
+}
+
+// Element returns the value of the named element and whether it is present.
+// Elements left at their default value are not recorded in the class file.
+func (a *Annotation) Element(name string) (ElementValue, bool) {
+	for _, p := range a.Elements {
+		if p.Name == name {
+			return p.Value, true
+		}
+	}
+	return ElementValue{}, false
+}
+
+// Module is the descriptor carried by the Module attribute of module-info.
+// Package names are in internal form ("java/lang").
+type Module struct {
+	Name     string
+	Flags    uint16
+	Version  string // "" if absent
+	Requires []ModuleRequires
+	Exports  []ModulePackage
+	Opens    []ModulePackage
+	Uses     []string // service interface class names
+	Provides []ModuleProvides
+}
+
+// ModuleRequires is a requires directive.
+type ModuleRequires struct {
+	Module  string
+	Flags   uint16 // ACC_TRANSITIVE, ACC_STATIC_PHASE, ...
+	Version string // "" if absent
+}
+
+// ModulePackage is an exports or opens directive. To is nil when the
+// package is exported or opened to every module.
+type ModulePackage struct {
+	Package string
+	Flags   uint16
+	To      []string
+}
+
+// ModuleProvides is a provides directive.
+type ModuleProvides struct {
+	Service string
+	With    []string // implementation class names
+}
+
+// PreviewMinorVersion is the minor version of class files compiled with
+// --enable-preview, which may use preview features of their major version.
+const PreviewMinorVersion = 0xFFFF
+
+// UsesPreview reports whether the class file was compiled with preview
+// features enabled.
+func (cf *ClassFile) UsesPreview() bool {
+	return cf.MinorVersion == PreviewMinorVersion
+}
+
+// IsModuleInfo reports whether the class file is a module descriptor.
+func (cf *ClassFile) IsModuleInfo() bool {
+	return cf.AccessFlags&AccModule != 0
 }
@@ -0,0 +1,320 @@
+package classfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// ParseOptions controls optional parser behaviour.
+type ParseOptions struct {
+	// Strict validates constant pool indices and tag cross-references,
+	// attribute lengths, exception table ranges and descriptor syntax while
+	// parsing. Violations are reported as *ClassFormatError instead of
+	// surfacing later as confusing interpreter failures.
+	//
+	// Without Strict, RuntimeVisibleAnnotations and Signature attributes
+	// that cannot be parsed are dropped rather than rejecting the class:
+	// HotSpot reads them only when reflection asks for them.
+	Strict bool
+}
+
+// ClassFormatError reports a structurally invalid class file detected by
+// strict parsing, like java.lang.ClassFormatError.
+type ClassFormatError struct {
+	Msg string
+}
+
+func (e *ClassFormatError) Error() string {
+	return "ClassFormatError: " + e.Msg
+}
+
+func formatError(format string, args ...interface{}) error {
+	return &ClassFormatError{Msg: fmt.Sprintf(format, args...)}
+}
+
+// entryAt returns the constant at index if it has the wanted tag.
+func entryAt(pool []ConstantPoolEntry, index uint16, tag uint8) (ConstantPoolEntry, bool) {
+	if index == 0 || int(index) >= len(pool) || pool[index] == nil {
+		return nil, false
+	}
+	return pool[index], pool[index].Tag() == tag
+}
+
+// checkRef verifies that index refers to a constant with one of tags.
+func checkRef(pool []ConstantPoolEntry, from int, what string, index uint16, tags ...uint8) error {
+	for _, tag := range tags {
+		if _, ok := entryAt(pool, index, tag); ok {
+			return nil
+		}
+	}
+	return formatError("constant pool index %d: %s index %d is invalid", from, what, index)
+}
+
+// validateConstantPool checks that every reference between constants
+// points at an entry of the right kind.
+func validateConstantPool(pool []ConstantPoolEntry) error {
+	for i, entry := range pool {
+		var err error
+		switch c := entry.(type) {
+		case *ConstantClass:
+			err = checkRef(pool, i, "name", c.NameIndex, TagUtf8)
+		case *ConstantString:
+			err = checkRef(pool, i, "string", c.StringIndex, TagUtf8)
+		case *ConstantFieldref:
+			err = checkMemberRef(pool, i, c.ClassIndex, c.NameAndTypeIndex, false)
+		case *ConstantMethodref:
+			err = checkMemberRef(pool, i, c.ClassIndex, c.NameAndTypeIndex, true)
+		case *ConstantInterfaceMethodref:
+			err = checkMemberRef(pool, i, c.ClassIndex, c.NameAndTypeIndex, true)
+		case *ConstantNameAndType:
+			if err = checkRef(pool, i, "name", c.NameIndex, TagUtf8); err == nil {
+				err = checkRef(pool, i, "descriptor", c.DescriptorIndex, TagUtf8)
+			}
+		case *ConstantMethodHandle:
+			switch {
+			case c.ReferenceKind >= 1 && c.ReferenceKind <= 4:
+				err = checkRef(pool, i, "reference", c.ReferenceIndex, TagFieldref)
+			case c.ReferenceKind == 9:
+				err = checkRef(pool, i, "reference", c.ReferenceIndex, TagInterfaceMethodref)
+			case c.ReferenceKind >= 5 && c.ReferenceKind <= 8:
+				err = checkRef(pool, i, "reference", c.ReferenceIndex, TagMethodref, TagInterfaceMethodref)
+			default:
+				err = formatError("constant pool index %d: invalid reference kind %d", i, c.ReferenceKind)
+			}
+		case *ConstantMethodType:
+			if err = checkRef(pool, i, "descriptor", c.DescriptorIndex, TagUtf8); err == nil {
+				err = checkMethodDescriptor(pool[c.DescriptorIndex].(*ConstantUtf8).Value)
+			}
+		case *ConstantDynamic:
+			if err = checkRef(pool, i, "name_and_type", c.NameAndTypeIndex, TagNameAndType); err == nil {
+				nat := pool[c.NameAndTypeIndex].(*ConstantNameAndType)
+				if desc, ok := entryAt(pool, nat.DescriptorIndex, TagUtf8); ok {
+					err = checkFieldDescriptor(desc.(*ConstantUtf8).Value)
+				}
+			}
+		case *ConstantInvokeDynamic:
+			err = checkRef(pool, i, "name_and_type", c.NameAndTypeIndex, TagNameAndType)
+		case *ConstantModule:
+			err = checkRef(pool, i, "name", c.NameIndex, TagUtf8)
+		case *ConstantPackage:
+			err = checkRef(pool, i, "name", c.NameIndex, TagUtf8)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkMemberRef checks a Fieldref/Methodref/InterfaceMethodref and the
+// syntax of its descriptor.
+func checkMemberRef(pool []ConstantPoolEntry, from int, classIndex, natIndex uint16, method bool) error {
+	if err := checkRef(pool, from, "class", classIndex, TagClass); err != nil {
+		return err
+	}
+	if err := checkRef(pool, from, "name_and_type", natIndex, TagNameAndType); err != nil {
+		return err
+	}
+	nat := pool[natIndex].(*ConstantNameAndType)
+	desc, ok := entryAt(pool, nat.DescriptorIndex, TagUtf8)
+	if !ok {
+		return formatError("constant pool index %d: name_and_type %d has no Utf8 descriptor", from, natIndex)
+	}
+	if method {
+		return checkMethodDescriptor(desc.(*ConstantUtf8).Value)
+	}
+	return checkFieldDescriptor(desc.(*ConstantUtf8).Value)
+}
+
+// Validate runs the checks of strict parsing on an already parsed class
+// file, such as one parsed leniently or assembled with a Builder. Problems
+// are reported as *ClassFormatError.
+func (cf *ClassFile) Validate() error {
+	if err := validateConstantPool(cf.ConstantPool); err != nil {
+		return err
+	}
+	return cf.validate()
+}
+
+// validate performs the checks that need the whole class file.
+func (cf *ClassFile) validate() error {
+	pool := cf.ConstantPool
+	if err := checkRef(pool, 0, "this_class", cf.ThisClass, TagClass); err != nil {
+		return err
+	}
+	if cf.SuperClass == 0 {
+		if name, _ := cf.ClassName(); name != "java/lang/Object" && !cf.IsModuleInfo() {
+			return formatError("class %s has no superclass", name)
+		}
+	} else if err := checkRef(pool, 0, "super_class", cf.SuperClass, TagClass); err != nil {
+		return err
+	}
+	for _, idx := range cf.Interfaces {
+		if err := checkRef(pool, 0, "interface", idx, TagClass); err != nil {
+			return err
+		}
+	}
+	for i, entry := range pool {
+		var bsm uint16
+		switch c := entry.(type) {
+		case *ConstantInvokeDynamic:
+			bsm = c.BootstrapMethodAttrIndex
+		case *ConstantDynamic:
+			bsm = c.BootstrapMethodAttrIndex
+		default:
+			continue
+		}
+		if int(bsm) >= len(cf.BootstrapMethods) {
+			return formatError("constant pool index %d: bootstrap method %d does not exist", i, bsm)
+		}
+	}
+
+	for _, f := range cf.Fields {
+		if err := checkFieldDescriptor(f.Descriptor); err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		if err := checkAttributeLengths(f.Attributes); err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+	}
+	for _, m := range cf.Methods {
+		if err := checkMethodDescriptor(m.Descriptor); err != nil {
+			return fmt.Errorf("method %s: %w", m.Name, err)
+		}
+		if err := checkAttributeLengths(m.Attributes); err != nil {
+			return fmt.Errorf("method %s: %w", m.Name, err)
+		}
+		if m.Code != nil {
+			if err := checkCode(pool, m.Code); err != nil {
+				return fmt.Errorf("method %s%s: %w", m.Name, m.Descriptor, err)
+			}
+		}
+	}
+	return checkAttributeLengths(cf.Attributes)
+}
+
+// fixedAttributeLengths are the attribute_length values mandated by the
+// specification for fixed-size attributes.
+var fixedAttributeLengths = map[string]int{
+	"ConstantValue":   2,
+	"Signature":       2,
+	"SourceFile":      2,
+	"NestHost":        2,
+	"EnclosingMethod": 4,
+}
+
+func checkAttributeLengths(attrs []AttributeInfo) error {
+	for _, a := range attrs {
+		if want, ok := fixedAttributeLengths[a.Name]; ok && len(a.Data) != want {
+			return formatError("%s attribute has length %d, want %d", a.Name, len(a.Data), want)
+		}
+		if a.Name == "Code" {
+			if err := checkCodeLength(a.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkCodeLength verifies that a Code attribute body is exactly as long
+// as its code, exception table and nested attributes.
+func checkCodeLength(data []byte) error {
+	off := 8
+	if len(data) < off {
+		return formatError("Code attribute truncated")
+	}
+	codeLength := int(binary.BigEndian.Uint32(data[4:8]))
+	if codeLength == 0 || codeLength >= 65536 {
+		return formatError("Code attribute has invalid code_length %d", codeLength)
+	}
+	off += codeLength
+	if off+2 > len(data) {
+		return formatError("Code attribute truncated")
+	}
+	off += 2 + 8*int(binary.BigEndian.Uint16(data[off:]))
+	if off+2 > len(data) {
+		return formatError("Code attribute truncated")
+	}
+	count := int(binary.BigEndian.Uint16(data[off:]))
+	off += 2
+	for i := 0; i < count; i++ {
+		if off+6 > len(data) {
+			return formatError("Code attribute truncated")
+		}
+		off += 6 + int(binary.BigEndian.Uint32(data[off+2:]))
+	}
+	if off != len(data) {
+		return formatError("Code attribute has length %d, contents need %d", len(data), off)
+	}
+	return nil
+}
+
+// checkCode validates the exception table of a method body.
+func checkCode(pool []ConstantPoolEntry, code *CodeAttribute) error {
+	n := len(code.Code)
+	for _, h := range code.ExceptionHandlers {
+		if h.StartPC >= h.EndPC || int(h.EndPC) > n || int(h.HandlerPC) >= n {
+			return formatError("exception handler [%d, %d) -> %d out of range for code length %d", h.StartPC, h.EndPC, h.HandlerPC, n)
+		}
+		if h.CatchType != 0 {
+			if err := checkRef(pool, 0, "catch_type", h.CatchType, TagClass); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFieldDescriptor validates the syntax of a field descriptor.
+func checkFieldDescriptor(desc string) error {
+	if rest, ok := scanFieldType(desc); !ok || rest != "" {
+		return formatError("invalid field descriptor %q", desc)
+	}
+	return nil
+}
+
+// checkMethodDescriptor validates the syntax of a method descriptor.
+func checkMethodDescriptor(desc string) error {
+	rest, ok := strings.CutPrefix(desc, "(")
+	for ok && rest != "" && rest[0] != ')' {
+		rest, ok = scanFieldType(rest)
+	}
+	if ok {
+		rest, ok = strings.CutPrefix(rest, ")")
+	}
+	if ok && rest != "V" {
+		rest, ok = scanFieldType(rest)
+		ok = ok && rest == ""
+	}
+	if !ok {
+		return formatError("invalid method descriptor %q", desc)
+	}
+	return nil
+}
+
+// scanFieldType consumes one field type from the front of s.
+func scanFieldType(s string) (string, bool) {
+	dims := 0
+	for dims < len(s) && s[dims] == '[' {
+		dims++
+	}
+	if dims > 255 || dims == len(s) {
+		return "", false
+	}
+	s = s[dims:]
+	switch s[0] {
+	case 'B', 'C', 'D', 'F', 'I', 'J', 'S', 'Z':
+		return s[1:], true
+	case 'L':
+		end := strings.IndexByte(s, ';')
+		name := s[1:max(end, 1)]
+		if end < 0 || name == "" || strings.ContainsAny(name, ".[<>") ||
+			strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || strings.Contains(name, "//") {
+			return "", false
+		}
+		return s[end+1:], true
+	}
+	return "", false
+}
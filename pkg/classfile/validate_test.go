@@ -0,0 +1,159 @@
+package classfile
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrictParseAcceptsTestdata(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/*.class")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no class files found: %v", err)
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ParseWithOptions(bytes.NewReader(data), ParseOptions{Strict: true}); err != nil {
+			t.Errorf("%s: %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestStrictParseRejectsMalformed(t *testing.T) {
+	// build assembles a small valid class, lets edit damage it and encodes it.
+	build := func(edit func(b *Builder) func(cf *ClassFile)) []byte {
+		t.Helper()
+		b := NewBuilder("Bad", "java/lang/Object")
+		b.AddMethod(AccPublic|AccStatic, "f", "()V", &CodeAttribute{Code: []byte{0xb1}}) // return
+		after := edit(b)
+		cf := b.Build()
+		if after != nil {
+			after(cf)
+		}
+		data, err := cf.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"bad method descriptor", build(func(*Builder) func(*ClassFile) {
+			return func(cf *ClassFile) { cf.Methods[0].Descriptor = "(Ljava/lang/String)V" }
+		})},
+		{"bad field descriptor in Fieldref", build(func(b *Builder) func(*ClassFile) {
+			b.Fieldref("Bad", "x", "Q")
+			return nil
+		})},
+		{"String pointing at a Class", build(func(b *Builder) func(*ClassFile) {
+			b.cp.add(&ConstantString{StringIndex: b.Class("Bad")})
+			return nil
+		})},
+		{"exception handler out of range", build(func(*Builder) func(*ClassFile) {
+			return func(cf *ClassFile) {
+				cf.Methods[0].Code.ExceptionHandlers = []ExceptionHandler{{StartPC: 0, EndPC: 5, HandlerPC: 0}}
+			}
+		})},
+		{"wrong SourceFile length", build(func(b *Builder) func(*ClassFile) {
+			name := b.Utf8("Bad.java")
+			b.Utf8("SourceFile")
+			return func(cf *ClassFile) {
+				cf.Attributes = []AttributeInfo{{Name: "SourceFile", Data: []byte{byte(name >> 8), byte(name), 0}}}
+			}
+		})},
+		{"trailing bytes", append(build(func(*Builder) func(*ClassFile) { return nil }), 0)},
+		{"truncated class annotations", build(func(*Builder) func(*ClassFile) {
+			return func(cf *ClassFile) {
+				cf.Attributes = append(cf.Attributes, AttributeInfo{Name: "RuntimeVisibleAnnotations", Data: []byte{0, 1, 0}})
+			}
+		})},
+		{"truncated method annotations", build(func(*Builder) func(*ClassFile) {
+			return func(cf *ClassFile) {
+				cf.Methods[0].Attributes = append(cf.Methods[0].Attributes, AttributeInfo{Name: "RuntimeVisibleAnnotations", Data: []byte{0, 1, 0}})
+			}
+		})},
+		{"truncated field annotations", build(func(b *Builder) func(*ClassFile) {
+			b.AddField(AccPublic, "x", "I", nil)
+			return func(cf *ClassFile) {
+				cf.Fields[0].Attributes = append(cf.Fields[0].Attributes, AttributeInfo{Name: "RuntimeVisibleAnnotations", Data: []byte{0, 1, 0}})
+			}
+		})},
+		{"malformed class Signature", build(func(b *Builder) func(*ClassFile) {
+			sig := b.Utf8("<T:Ljava/lang/Object;Ljava/lang/Object;")
+			return func(cf *ClassFile) {
+				cf.Attributes = append(cf.Attributes, AttributeInfo{Name: "Signature", Data: []byte{byte(sig >> 8), byte(sig)}})
+			}
+		})},
+		{"malformed method Signature", build(func(b *Builder) func(*ClassFile) {
+			sig := b.Utf8("()Ljava/util/List<")
+			return func(cf *ClassFile) {
+				cf.Methods[0].Attributes = append(cf.Methods[0].Attributes, AttributeInfo{Name: "Signature", Data: []byte{byte(sig >> 8), byte(sig)}})
+			}
+		})},
+		{"malformed field Signature", build(func(b *Builder) func(*ClassFile) {
+			b.AddField(AccPublic, "x", "Ljava/util/List;", nil)
+			sig := b.Utf8("Ljava/util/List<;")
+			return func(cf *ClassFile) {
+				cf.Fields[0].Attributes = append(cf.Fields[0].Attributes, AttributeInfo{Name: "Signature", Data: []byte{byte(sig >> 8), byte(sig)}})
+			}
+		})},
+		{"malformed record component Signature", build(func(b *Builder) func(*ClassFile) {
+			name, desc := b.Utf8("items"), b.Utf8("Ljava/util/List;")
+			attr, sig := b.Utf8("Signature"), b.Utf8("Ljava/util/List<;")
+			b.Utf8("Record")
+			return func(cf *ClassFile) {
+				cf.Attributes = append(cf.Attributes, AttributeInfo{Name: "Record", Data: []byte{
+					0, 1, byte(name >> 8), byte(name), byte(desc >> 8), byte(desc), 0, 1,
+					byte(attr >> 8), byte(attr), 0, 0, 0, 2, byte(sig >> 8), byte(sig),
+				}})
+			}
+		})},
+		{"truncated record component annotations", build(func(b *Builder) func(*ClassFile) {
+			name, desc := b.Utf8("x"), b.Utf8("I")
+			attr := b.Utf8("RuntimeVisibleAnnotations")
+			b.Utf8("Record")
+			return func(cf *ClassFile) {
+				cf.Attributes = append(cf.Attributes, AttributeInfo{Name: "Record", Data: []byte{
+					0, 1, byte(name >> 8), byte(name), byte(desc >> 8), byte(desc), 0, 1,
+					byte(attr >> 8), byte(attr), 0, 0, 0, 3, 0, 1, 0,
+				}})
+			}
+		})},
+	}
+	for _, tt := range tests {
+		if _, err := Parse(bytes.NewReader(tt.data)); err != nil {
+			t.Errorf("%s: lenient Parse failed: %v", tt.name, err)
+		}
+		_, err := ParseWithOptions(bytes.NewReader(tt.data), ParseOptions{Strict: true})
+		var cfe *ClassFormatError
+		if !errors.As(err, &cfe) {
+			t.Errorf("%s: got %v, want a ClassFormatError", tt.name, err)
+		}
+	}
+}
+
+func TestCheckDescriptors(t *testing.T) {
+	valid := []string{"()V", "(IJ[[Ljava/lang/String;)Z", "([B)[Ljava/util/List;"}
+	for _, d := range valid {
+		if err := checkMethodDescriptor(d); err != nil {
+			t.Errorf("%s: %v", d, err)
+		}
+	}
+	invalid := []string{"", "V", "()", "(V)V", "(I)VV", "(L;)V", "(Ljava.lang.String;)V", "<T:>()V", "(TT;)V"}
+	for _, d := range invalid {
+		if checkMethodDescriptor(d) == nil {
+			t.Errorf("%q accepted", d)
+		}
+	}
+	if checkFieldDescriptor("V") == nil || checkFieldDescriptor("[") == nil || checkFieldDescriptor("[I") != nil {
+		t.Error("field descriptor checks are wrong")
+	}
+}
@@ -0,0 +1,276 @@
+package classfile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Write serializes cf in the class file format.
+//
+// Code, ConstantValue and BootstrapMethods are encoded from their parsed
+// form, so edits to MethodInfo.Code, FieldInfo.ConstantValue and
+// ClassFile.BootstrapMethods are reflected in the output. Every other
+// attribute is copied verbatim from the raw Attributes lists. Of the
+// attributes nested in Code only LineNumberTable is retained by the parser,
+// so StackMapTable and the like are not written. Constants needed by the
+// encoded attributes are appended to the constant pool if missing; cf
+// itself is not modified.
+func (cf *ClassFile) Write(w io.Writer) error {
+	data, err := cf.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Bytes returns cf serialized in the class file format. See Write.
+func (cf *ClassFile) Bytes() ([]byte, error) {
+	cp := newPoolBuilder(cf.ConstantPool)
+
+	// The body is encoded first because it may add constants to the pool.
+	var body bytes.Buffer
+	put16(&body, cf.AccessFlags)
+	put16(&body, cf.ThisClass)
+	put16(&body, cf.SuperClass)
+	put16(&body, uint16(len(cf.Interfaces)))
+	for _, idx := range cf.Interfaces {
+		put16(&body, idx)
+	}
+
+	put16(&body, uint16(len(cf.Fields)))
+	for _, f := range cf.Fields {
+		var attrs []AttributeInfo
+		if f.ConstantValue != nil {
+			var data bytes.Buffer
+			put16(&data, cp.add(f.ConstantValue))
+			attrs = append(attrs, AttributeInfo{Name: "ConstantValue", Data: data.Bytes()})
+		}
+		attrs = append(attrs, withoutAttribute(f.Attributes, "ConstantValue")...)
+		writeMember(&body, cp, f.AccessFlags, f.Name, f.Descriptor, attrs)
+	}
+
+	put16(&body, uint16(len(cf.Methods)))
+	for _, m := range cf.Methods {
+		var attrs []AttributeInfo
+		if m.Code != nil {
+			attrs = append(attrs, AttributeInfo{Name: "Code", Data: encodeCode(cp, m.Code)})
+		}
+		attrs = append(attrs, withoutAttribute(m.Attributes, "Code")...)
+		writeMember(&body, cp, m.AccessFlags, m.Name, m.Descriptor, attrs)
+	}
+
+	var attrs []AttributeInfo
+	if len(cf.BootstrapMethods) > 0 {
+		attrs = append(attrs, AttributeInfo{Name: "BootstrapMethods", Data: encodeBootstrapMethods(cf.BootstrapMethods)})
+	}
+	attrs = append(attrs, withoutAttribute(cf.Attributes, "BootstrapMethods")...)
+	writeAttributes(&body, cp, attrs)
+
+	var out bytes.Buffer
+	put32(&out, classMagic)
+	put16(&out, cf.MinorVersion)
+	put16(&out, cf.MajorVersion)
+	if err := writeConstantPool(&out, cp.pool); err != nil {
+		return nil, err
+	}
+	out.Write(body.Bytes())
+	return out.Bytes(), nil
+}
+
+func put16(b *bytes.Buffer, v uint16) {
+	b.Write(binary.BigEndian.AppendUint16(nil, v))
+}
+
+func put32(b *bytes.Buffer, v uint32) {
+	b.Write(binary.BigEndian.AppendUint32(nil, v))
+}
+
+func put64(b *bytes.Buffer, v uint64) {
+	b.Write(binary.BigEndian.AppendUint64(nil, v))
+}
+
+func withoutAttribute(attrs []AttributeInfo, name string) []AttributeInfo {
+	var kept []AttributeInfo
+	for _, a := range attrs {
+		if a.Name != name {
+			kept = append(kept, a)
+		}
+	}
+	return kept
+}
+
+func writeMember(b *bytes.Buffer, cp *poolBuilder, flags uint16, name, descriptor string, attrs []AttributeInfo) {
+	put16(b, flags)
+	put16(b, cp.utf8(name))
+	put16(b, cp.utf8(descriptor))
+	writeAttributes(b, cp, attrs)
+}
+
+func writeAttributes(b *bytes.Buffer, cp *poolBuilder, attrs []AttributeInfo) {
+	put16(b, uint16(len(attrs)))
+	for _, a := range attrs {
+		put16(b, cp.utf8(a.Name))
+		put32(b, uint32(len(a.Data)))
+		b.Write(a.Data)
+	}
+}
+
+func encodeCode(cp *poolBuilder, code *CodeAttribute) []byte {
+	var b bytes.Buffer
+	put16(&b, code.MaxStack)
+	put16(&b, code.MaxLocals)
+	put32(&b, uint32(len(code.Code)))
+	b.Write(code.Code)
+	put16(&b, uint16(len(code.ExceptionHandlers)))
+	for _, h := range code.ExceptionHandlers {
+		put16(&b, h.StartPC)
+		put16(&b, h.EndPC)
+		put16(&b, h.HandlerPC)
+		put16(&b, h.CatchType)
+	}
+	if len(code.LineNumbers) == 0 {
+		put16(&b, 0) // attributes_count
+		return b.Bytes()
+	}
+	var table bytes.Buffer
+	put16(&table, uint16(len(code.LineNumbers)))
+	for _, ln := range code.LineNumbers {
+		put16(&table, ln.StartPC)
+		put16(&table, ln.Line)
+	}
+	writeAttributes(&b, cp, []AttributeInfo{{Name: "LineNumberTable", Data: table.Bytes()}})
+	return b.Bytes()
+}
+
+func encodeBootstrapMethods(methods []BootstrapMethod) []byte {
+	var b bytes.Buffer
+	put16(&b, uint16(len(methods)))
+	for _, m := range methods {
+		put16(&b, m.MethodRef)
+		put16(&b, uint16(len(m.BootstrapArguments)))
+		for _, arg := range m.BootstrapArguments {
+			put16(&b, arg)
+		}
+	}
+	return b.Bytes()
+}
+
+func writeConstantPool(b *bytes.Buffer, pool []ConstantPoolEntry) error {
+	if len(pool) > math.MaxUint16 {
+		return fmt.Errorf("constant pool too large: %d entries", len(pool))
+	}
+	count := len(pool)
+	if count == 0 {
+		count = 1 // constant_pool_count includes the unused entry 0
+	}
+	put16(b, uint16(count))
+	for i := 1; i < len(pool); i++ {
+		entry := pool[i]
+		if entry == nil {
+			return fmt.Errorf("constant pool index %d is empty", i)
+		}
+		b.WriteByte(entry.Tag())
+		switch c := entry.(type) {
+		case *ConstantUtf8:
+			if len(c.Value) > math.MaxUint16 {
+				return fmt.Errorf("constant pool index %d: Utf8 too long", i)
+			}
+			put16(b, uint16(len(c.Value)))
+			b.WriteString(c.Value)
+		case *ConstantInteger:
+			put32(b, uint32(c.Value))
+		case *ConstantFloat:
+			put32(b, math.Float32bits(c.Value))
+		case *ConstantLong:
+			put64(b, uint64(c.Value))
+			i++ // long takes 2 slots
+		case *ConstantDouble:
+			put64(b, math.Float64bits(c.Value))
+			i++ // double takes 2 slots
+		case *ConstantClass:
+			put16(b, c.NameIndex)
+		case *ConstantString:
+			put16(b, c.StringIndex)
+		case *ConstantFieldref:
+			put16(b, c.ClassIndex)
+			put16(b, c.NameAndTypeIndex)
+		case *ConstantMethodref:
+			put16(b, c.ClassIndex)
+			put16(b, c.NameAndTypeIndex)
+		case *ConstantInterfaceMethodref:
+			put16(b, c.ClassIndex)
+			put16(b, c.NameAndTypeIndex)
+		case *ConstantNameAndType:
+			put16(b, c.NameIndex)
+			put16(b, c.DescriptorIndex)
+		case *ConstantMethodHandle:
+			b.WriteByte(c.ReferenceKind)
+			put16(b, c.ReferenceIndex)
+		case *ConstantMethodType:
+			put16(b, c.DescriptorIndex)
+		case *ConstantDynamic:
+			put16(b, c.BootstrapMethodAttrIndex)
+			put16(b, c.NameAndTypeIndex)
+		case *ConstantInvokeDynamic:
+			put16(b, c.BootstrapMethodAttrIndex)
+			put16(b, c.NameAndTypeIndex)
+		case *ConstantModule:
+			put16(b, c.NameIndex)
+		case *ConstantPackage:
+			put16(b, c.NameIndex)
+		default:
+			return fmt.Errorf("constant pool index %d: cannot encode tag %d", i, entry.Tag())
+		}
+	}
+	return nil
+}
+
+// poolBuilder appends constants to a constant pool, reusing an existing
+// entry when an equal one is already present.
+type poolBuilder struct {
+	pool  []ConstantPoolEntry
+	index map[string]uint16
+}
+
+// newPoolBuilder returns a builder over a copy of pool.
+func newPoolBuilder(pool []ConstantPoolEntry) *poolBuilder {
+	p := &poolBuilder{
+		pool:  append([]ConstantPoolEntry{nil}, pool[min(1, len(pool)):]...),
+		index: make(map[string]uint16),
+	}
+	for i := len(p.pool) - 1; i > 0; i-- {
+		if p.pool[i] != nil {
+			p.index[constantKey(p.pool[i])] = uint16(i)
+		}
+	}
+	return p
+}
+
+// constantKey identifies a constant by tag and contents.
+func constantKey(e ConstantPoolEntry) string {
+	return fmt.Sprintf("%d:%+v", e.Tag(), e)
+}
+
+// add returns the index of a constant equal to e, appending it if needed.
+func (p *poolBuilder) add(e ConstantPoolEntry) uint16 {
+	key := constantKey(e)
+	if idx, ok := p.index[key]; ok {
+		return idx
+	}
+	idx := uint16(len(p.pool))
+	p.pool = append(p.pool, e)
+	switch e.(type) {
+	case *ConstantLong, *ConstantDouble:
+		p.pool = append(p.pool, nil) // long and double take 2 slots
+	}
+	p.index[key] = idx
+	return idx
+}
+
+func (p *poolBuilder) utf8(s string) uint16 {
+	return p.add(&ConstantUtf8{Value: s})
+}
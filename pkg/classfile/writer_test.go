@@ -0,0 +1,101 @@
+package classfile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestWriteRoundTrip(t *testing.T) {
+	for _, name := range []string{"EnumTest", "Lambda", "ObjectMethods$Point"} {
+		orig, err := ParseFile("../../testdata/" + name + ".class")
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := orig.Bytes()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		got, err := Parse(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: reparsing: %v", name, err)
+		}
+
+		// すべての属性名は元のプールに存在するので、プールは変化しない
+		if !reflect.DeepEqual(got.ConstantPool, orig.ConstantPool) {
+			t.Errorf("%s: constant pool changed", name)
+		}
+		if len(got.Methods) != len(orig.Methods) {
+			t.Fatalf("%s: got %d methods, want %d", name, len(got.Methods), len(orig.Methods))
+		}
+		for i, m := range got.Methods {
+			want := orig.Methods[i]
+			if m.Name != want.Name || m.Descriptor != want.Descriptor || !reflect.DeepEqual(m.Code, want.Code) {
+				t.Errorf("%s: method %s%s differs after round trip", name, want.Name, want.Descriptor)
+			}
+		}
+		if !reflect.DeepEqual(got.Fields, orig.Fields) ||
+			!reflect.DeepEqual(got.BootstrapMethods, orig.BootstrapMethods) ||
+			!reflect.DeepEqual(got.InnerClasses, orig.InnerClasses) ||
+			!reflect.DeepEqual(got.RecordComponents, orig.RecordComponents) {
+			t.Errorf("%s: fields or class attributes differ after round trip", name)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder("Gen", "java/lang/Object")
+	b.AddInterface("java/lang/Runnable")
+	b.AddField(AccPublic|AccStatic|AccFinal, "LIMIT", "J", &ConstantLong{Value: 1 << 40})
+	b.AddMethod(AccPublic|AccStatic, "add", "(II)I", &CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 2,
+		Code:      []byte{0x1a, 0x1b, 0x60, 0xac}, // iload_0; iload_1; iadd; ireturn
+	})
+	b.AddMethod(AccPublic|AccAbstract, "run", "()V", nil)
+	hello := b.String("hello")
+	if again := b.String("hello"); again != hello {
+		t.Errorf("equal constants should share an index: %d != %d", again, hello)
+	}
+	bsm := b.AddBootstrapMethod(b.MethodHandle(6, b.Methodref("Gen", "bsm", "()V")))
+	condy := b.Dynamic(bsm, "value", "I")
+
+	var buf bytes.Buffer
+	if err := b.Build().Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	cf, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := cf.ClassName(); name != "Gen" || cf.SuperClassName() != "java/lang/Object" {
+		t.Errorf("class: got %s extends %s", name, cf.SuperClassName())
+	}
+	if iface, _ := GetClassName(cf.ConstantPool, cf.Interfaces[0]); iface != "java/lang/Runnable" {
+		t.Errorf("interface: got %s", iface)
+	}
+	if c, ok := cf.Fields[0].ConstantValue.(*ConstantLong); !ok || c.Value != 1<<40 {
+		t.Errorf("LIMIT ConstantValue: got %+v", cf.Fields[0].ConstantValue)
+	}
+	add := cf.FindMethod("add", "(II)I")
+	if add == nil || add.Code == nil || !bytes.Equal(add.Code.Code, []byte{0x1a, 0x1b, 0x60, 0xac}) || add.Code.MaxStack != 2 {
+		t.Errorf("add: got %+v", add)
+	}
+	if run := cf.FindMethod("run", "()V"); run == nil || run.Code != nil {
+		t.Errorf("run: got %+v", run)
+	}
+	if s, _ := cf.ConstantPool[hello].(*ConstantString); s == nil {
+		t.Errorf("index %d is not the String constant", hello)
+	}
+	if d, _ := cf.ConstantPool[condy].(*ConstantDynamic); d == nil || d.BootstrapMethodAttrIndex != bsm {
+		t.Errorf("index %d: got %+v, want the Dynamic constant", condy, cf.ConstantPool[condy])
+	}
+}
+
+func TestWriteRejectsUnencodableConstant(t *testing.T) {
+	cf := &ClassFile{ConstantPool: []ConstantPoolEntry{nil, &constantPlaceholder{tag: 99}}}
+	if _, err := cf.Bytes(); err == nil {
+		t.Error("expected error for an unencodable constant")
+	}
+}
@@ -8,6 +8,7 @@ import (
 // PrintStream represents a java.io.PrintStream.
 type PrintStream struct {
 	Writer io.Writer
+	Err    bool // System.err, whose text is encoded in stderr.encoding
 }
 
 // Println prints a value followed by a newline.
@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"math"
+	"unsafe"
+)
+
+// Java code can ask for arrays far larger than the process can hold, and
+// Go aborts the whole process when an allocation fails. The array
+// creation instructions therefore check the size of the array against
+// VM.MaxArrayBytes first, and throw OutOfMemoryError, which Java code can
+// catch, for arrays over the limit.
+
+// defaultMaxArrayBytes is the limit when VM.MaxArrayBytes is zero.
+const defaultMaxArrayBytes = 1 << 30
+
+// maxArrayLength is the largest array length HotSpot allocates.
+const maxArrayLength = math.MaxInt32 - 2
+
+// elementSize returns the bytes an array element of the given component
+// type descriptor takes.
+func elementSize(component string) int {
+	switch component {
+	case "Z", "B":
+		return 1
+	case "C", "S":
+		return 2
+	case "I", "F":
+		return 4
+	case "J", "D":
+		return 8
+	}
+	return int(unsafe.Sizeof(Value{}))
+}
+
+// arrayBytes returns the bytes needed by an array of the array type
+// descriptor whose dimensions have the given sizes, outermost first,
+// counting the arrays of every level allocated.
+func arrayBytes(descriptor string, sizes []int) float64 {
+	total, count := 0.0, 1.0
+	for i, size := range sizes {
+		count *= float64(size)
+		total += count * float64(elementSize(descriptor[i+1:]))
+	}
+	return total
+}
+
+// checkArraySize returns the OutOfMemoryError to throw instead of
+// allocating an array of the array type descriptor with the given
+// dimension sizes, or nil if it may be allocated.
+func (vm *VM) checkArraySize(descriptor string, sizes ...int) error {
+	for _, size := range sizes {
+		if size > maxArrayLength {
+			return NewJavaExceptionMessage("java/lang/OutOfMemoryError", "Requested array size exceeds VM limit")
+		}
+	}
+	if arrayBytes(descriptor, sizes) > float64(vm.maxArrayBytes()) {
+		return NewJavaExceptionMessage("java/lang/OutOfMemoryError", "Java heap space")
+	}
+	return nil
+}
+
+// maxArrayBytes returns the largest allocation VM.MaxArrayBytes allows,
+// which also bounds Unsafe.allocateMemory.
+func (vm *VM) maxArrayBytes() int64 {
+	if vm.MaxArrayBytes == 0 {
+		return defaultMaxArrayBytes
+	}
+	return vm.MaxArrayBytes
+}
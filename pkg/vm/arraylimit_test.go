@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestArraySizeLimit(t *testing.T) {
+	b := classfile.NewBuilder("Arrays", "java/lang/Object")
+	grid := b.Class("[[J")
+	// ints(n) returns new int[n].length.
+	b.AddMethod(classfile.AccStatic, "ints", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpIload0, OpNewarray, 10, OpArraylength, OpIreturn},
+	})
+	// grid(n) returns new long[n][n].length.
+	b.AddMethod(classfile.AccStatic, "grid", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpIload0, OpMultianewarray, byte(grid >> 8), byte(grid), 2,
+			OpArraylength, OpIreturn,
+		},
+	})
+	// caught(n) returns ints(n), or -1 if it throws OutOfMemoryError.
+	ints := b.Methodref("Arrays", "ints", "(I)I")
+	oom := b.Class("java/lang/OutOfMemoryError")
+	b.AddMethod(classfile.AccStatic, "caught", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpInvokestatic, byte(ints >> 8), byte(ints), OpIreturn,
+			OpPop, OpIconstM1, OpIreturn, // 5: handler
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 5, HandlerPC: 5, CatchType: oom}},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Arrays": cf})
+	v.MaxArrayBytes = 1024
+
+	for _, tt := range []struct {
+		method string
+		n      int32
+		want   string // OutOfMemoryError message, or "" if it succeeds
+	}{
+		{"ints", 256, ""},
+		{"ints", 257, "Java heap space"},
+		{"ints", maxArrayLength + 1, "Requested array size exceeds VM limit"},
+		{"grid", 4, ""},
+		{"grid", 12, "Java heap space"}, // 12*12 longs alone take 1152 bytes
+	} {
+		got, err := v.executeMethod(cf, cf.FindMethodByName(tt.method), []Value{IntValue(tt.n)})
+		if tt.want == "" {
+			if err != nil || got.Int != tt.n {
+				t.Errorf("%s(%d): got %d, %v", tt.method, tt.n, got.Int, err)
+			}
+			continue
+		}
+		if !isJavaException(err, "java/lang/OutOfMemoryError") {
+			t.Errorf("%s(%d): got %v, want OutOfMemoryError", tt.method, tt.n, err)
+			continue
+		}
+		if msg, _ := err.(*JavaException).Message(); msg != tt.want {
+			t.Errorf("%s(%d): got message %q, want %q", tt.method, tt.n, msg, tt.want)
+		}
+	}
+
+	got, err := v.executeMethod(cf, cf.FindMethodByName("caught"), []Value{IntValue(1 << 20)})
+	if err != nil || got.Int != -1 {
+		t.Errorf("caught(1<<20): got %d, %v, want -1", got.Int, err)
+	}
+}
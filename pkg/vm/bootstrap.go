@@ -0,0 +1,245 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Loadable constants (JVMS §5.1) are resolved here, both for ldc and for
+// the static arguments of bootstrap methods. MethodType and MethodHandle
+// constants become opaque java/lang/invoke objects whose hidden fields
+// record the descriptor and the referenced member; dynamically-computed
+// constants are resolved once per constant pool entry by invoking their
+// bootstrap method.
+
+// methodTypeObject returns a java/lang/invoke/MethodType for descriptor.
+func methodTypeObject(descriptor string) Value {
+	return RefValue(&JObject{
+		ClassName: "java/lang/invoke/MethodType",
+		Fields:    map[string]Value{"_descriptor": RefValue(descriptor)},
+	})
+}
+
+// methodHandleObject returns a java/lang/invoke/MethodHandle for a
+// CONSTANT_MethodHandle entry.
+func methodHandleObject(pool []classfile.ConstantPoolEntry, mh *classfile.ConstantMethodHandle) (Value, error) {
+	var className, name, descriptor string
+	switch mh.ReferenceKind {
+	case 1, 2, 3, 4: // getField, getStatic, putField, putStatic
+		fref, err := classfile.ResolveFieldref(pool, mh.ReferenceIndex)
+		if err != nil {
+			return Value{}, err
+		}
+		className, name, descriptor = fref.ClassName, fref.FieldName, fref.Descriptor
+	default:
+		mref, err := classfile.ResolveMethodref(pool, mh.ReferenceIndex)
+		if err != nil {
+			// interface static and private methods are InterfaceMethodrefs
+			mref, err = classfile.ResolveInterfaceMethodref(pool, mh.ReferenceIndex)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		className, name, descriptor = mref.ClassName, mref.MethodName, mref.Descriptor
+	}
+	return RefValue(&JObject{
+		ClassName: "java/lang/invoke/MethodHandle",
+		Fields: map[string]Value{
+			"_refKind":    IntValue(int32(mh.ReferenceKind)),
+			"_class":      RefValue(className),
+			"_name":       RefValue(name),
+			"_descriptor": RefValue(descriptor),
+		},
+	}), nil
+}
+
+// methodHandleTarget returns the reference kind and member of a handle
+// created by methodHandleObject.
+func methodHandleTarget(v Value) (kind uint8, className, name, descriptor string, ok bool) {
+	obj, isObj := v.Ref.(*JObject)
+	if !isObj || obj.ClassName != "java/lang/invoke/MethodHandle" {
+		return 0, "", "", "", false
+	}
+	className, _ = obj.Fields["_class"].Ref.(string)
+	name, _ = obj.Fields["_name"].Ref.(string)
+	descriptor, _ = obj.Fields["_descriptor"].Ref.(string)
+	return uint8(obj.Fields["_refKind"].Int), className, name, descriptor, true
+}
+
+// resolveConstant resolves the loadable constant at index in cf.
+func (vm *VM) resolveConstant(cf *classfile.ClassFile, index uint16) (Value, error) {
+	pool := cf.ConstantPool
+	if int(index) >= len(pool) || pool[index] == nil {
+		return Value{}, fmt.Errorf("invalid constant pool index %d", index)
+	}
+	switch c := pool[index].(type) {
+	case *classfile.ConstantInteger:
+		return IntValue(c.Value), nil
+	case *classfile.ConstantFloat:
+		return FloatValue(c.Value), nil
+	case *classfile.ConstantLong:
+		return LongValue(c.Value), nil
+	case *classfile.ConstantDouble:
+		return DoubleValue(c.Value), nil
+	case *classfile.ConstantString:
+		s, err := classfile.GetUtf8(pool, c.StringIndex)
+		if err != nil {
+			return Value{}, fmt.Errorf("resolving string: %w", err)
+		}
+		return RefValue(s), nil
+	case *classfile.ConstantClass:
+		name, err := classfile.GetUtf8(pool, c.NameIndex)
+		if err != nil {
+			return Value{}, fmt.Errorf("resolving class name: %w", err)
+		}
+		if err := vm.linkClass(name); err != nil {
+			return Value{}, err
+		}
+		// name is an internal name, or a descriptor for array classes
+		return vm.classObject(name), nil
+	case *classfile.ConstantMethodType:
+		desc, err := classfile.GetUtf8(pool, c.DescriptorIndex)
+		if err != nil {
+			return Value{}, fmt.Errorf("resolving method type: %w", err)
+		}
+		return methodTypeObject(desc), nil
+	case *classfile.ConstantMethodHandle:
+		return methodHandleObject(pool, c)
+	case *classfile.ConstantDynamic:
+		return vm.resolveDynamicConstant(cf, index, c)
+	}
+	return Value{}, fmt.Errorf("constant pool index %d (tag=%d) is not loadable", index, pool[index].Tag())
+}
+
+// resolveBootstrapArgs resolves the static arguments of bsm.
+func (vm *VM) resolveBootstrapArgs(cf *classfile.ClassFile, bsm classfile.BootstrapMethod) ([]Value, error) {
+	args := make([]Value, len(bsm.BootstrapArguments))
+	for i, idx := range bsm.BootstrapArguments {
+		v, err := vm.resolveConstant(cf, idx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return args, nil
+}
+
+// resolveDynamicConstant resolves a CONSTANT_Dynamic entry by calling its
+// bootstrap method with a lookup on cf, the constant's name, its type as a
+// Class, and the static arguments. The result is cached, so the bootstrap
+// method runs at most once per entry; a constant that depends on itself
+// fails with StackOverflowError.
+func (vm *VM) resolveDynamicConstant(cf *classfile.ClassFile, index uint16, c *classfile.ConstantDynamic) (Value, error) {
+	className, _ := cf.ClassName()
+	key := fmt.Sprintf("%s#%d", className, index)
+	if v, ok := vm.dynamicConstants[key]; ok {
+		if v == nil {
+			return Value{}, NewJavaException("java/lang/StackOverflowError")
+		}
+		return *v, nil
+	}
+	if vm.dynamicConstants == nil {
+		vm.dynamicConstants = make(map[string]*Value)
+	}
+
+	pool := cf.ConstantPool
+	nat, ok := pool[c.NameAndTypeIndex].(*classfile.ConstantNameAndType)
+	if !ok {
+		return Value{}, fmt.Errorf("dynamic constant %d: invalid NameAndType index", index)
+	}
+	name, _ := classfile.GetUtf8(pool, nat.NameIndex)
+	descriptor, _ := classfile.GetUtf8(pool, nat.DescriptorIndex)
+	if int(c.BootstrapMethodAttrIndex) >= len(cf.BootstrapMethods) {
+		return Value{}, fmt.Errorf("dynamic constant %d: bootstrap method index %d out of range", index, c.BootstrapMethodAttrIndex)
+	}
+	bsm := cf.BootstrapMethods[c.BootstrapMethodAttrIndex]
+	mh, ok := pool[bsm.MethodRef].(*classfile.ConstantMethodHandle)
+	if !ok {
+		return Value{}, fmt.Errorf("dynamic constant %d: bootstrap method is not MethodHandle", index)
+	}
+	handle, err := methodHandleObject(pool, mh)
+	if err != nil {
+		return Value{}, fmt.Errorf("dynamic constant %d: %w", index, err)
+	}
+
+	vm.dynamicConstants[key] = nil // in progress
+	static, err := vm.resolveBootstrapArgs(cf, bsm)
+	var v Value
+	if err == nil {
+		v, err = vm.invokeConstantBootstrap(className, handle, name, descriptor, static)
+	}
+	if err != nil {
+		delete(vm.dynamicConstants, key)
+		return Value{}, err
+	}
+	v = vm.adaptValue(v, descriptor[0])
+	vm.dynamicConstants[key] = &v
+	return v, nil
+}
+
+// invokeConstantBootstrap runs the bootstrap method of a dynamic constant.
+// The simple java/lang/invoke/ConstantBootstraps methods are implemented
+// directly; any other static bootstrap method is executed.
+func (vm *VM) invokeConstantBootstrap(caller string, handle Value, name, descriptor string, static []Value) (Value, error) {
+	kind, bsmClass, bsmName, bsmDesc, _ := methodHandleTarget(handle)
+	if kind != refInvokeStatic {
+		return Value{}, fmt.Errorf("dynamic constant %s: unsupported bootstrap method reference kind %d", name, kind)
+	}
+	typeName := descriptorClassName(descriptor)
+
+	if bsmClass == "java/lang/invoke/ConstantBootstraps" {
+		switch bsmName {
+		case "nullConstant":
+			return NullValue(), nil
+		case "primitiveClass":
+			return vm.classObject(descriptorClassName(name)), nil
+		case "getStaticFinal", "enumConstant":
+			owner := typeName
+			if len(static) > 0 {
+				owner = classObjectName(static[0])
+			}
+			owner = vm.staticFieldOwner(owner, name)
+			if err := vm.ensureInitialized(owner); err != nil {
+				return Value{}, err
+			}
+			return vm.getStaticField(owner, name), nil
+		}
+	}
+
+	bsmCf, method, err := vm.resolveMethod(bsmClass, bsmName, bsmDesc)
+	if err != nil {
+		return Value{}, fmt.Errorf("dynamic constant %s: %w", name, err)
+	}
+	if err := vm.ensureInitialized(bsmClass); err != nil {
+		return Value{}, err
+	}
+	lookup := RefValue(&JObject{
+		ClassName: "java/lang/invoke/MethodHandles$Lookup",
+		Fields:    map[string]Value{"lookupClass": vm.classObject(caller)},
+	})
+	args := append([]Value{lookup, RefValue(name), vm.classObject(typeName)}, static...)
+
+	sig, err := classfile.ParseMethodSignature(bsmDesc)
+	if err != nil {
+		return Value{}, fmt.Errorf("dynamic constant %s: %w", name, err)
+	}
+	n := len(sig.Params)
+	if _, isArray := args[len(args)-1].Ref.(*JArray); method.AccessFlags&AccVarargs != 0 && n > 0 && (len(args) != n || !isArray) {
+		// collect the trailing static arguments into the varargs array
+		rest := make([]Value, 0, len(args)-n+1)
+		for _, a := range args[n-1:] {
+			rest = append(rest, vm.adaptValue(a, 'L'))
+		}
+		component := paramDescriptors(bsmDesc)[n-1][1:]
+		args = append(args[:n-1], RefValue(&JArray{Component: component, Elements: rest}))
+	}
+	if len(args) != n {
+		return Value{}, fmt.Errorf("dynamic constant %s: bootstrap method %s.%s%s takes %d arguments, got %d",
+			name, bsmClass, bsmName, bsmDesc, n, len(args))
+	}
+	for i, p := range sig.Params {
+		args[i] = vm.adaptValue(args[i], p.Kind)
+	}
+	return vm.executeMethod(bsmCf, method, args)
+}
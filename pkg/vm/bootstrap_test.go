@@ -0,0 +1,54 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestBootstrapArguments(t *testing.T) {
+	b := classfile.NewBuilder("Gen", "java/lang/Object")
+	twiceDesc := "(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/Class;J)J"
+	b.AddMethod(classfile.AccStatic, "twice", twiceDesc, &classfile.CodeAttribute{
+		MaxStack:  4,
+		MaxLocals: 5,
+		Code:      []byte{0x21, 0x21, 0x61, 0xad}, // lload_3; lload_3; ladd; lreturn
+	})
+	twice := b.AddBootstrapMethod(b.MethodHandle(refInvokeStatic, b.Methodref("Gen", "twice", twiceDesc)), b.Long(21))
+	condy := b.Dynamic(twice, "answer", "J")
+
+	nullConstant := b.AddBootstrapMethod(b.MethodHandle(refInvokeStatic, b.Methodref("java/lang/invoke/ConstantBootstraps", "nullConstant",
+		"(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/Class;)Ljava/lang/Object;")))
+	null := b.Dynamic(nullConstant, "_", "Ljava/lang/Object;")
+
+	concatDesc := "(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/MethodType;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/invoke/CallSite;"
+	concat := b.AddBootstrapMethod(b.MethodHandle(refInvokeStatic, b.Methodref("java/lang/invoke/StringConcatFactory", "makeConcatWithConstants", concatDesc)),
+		b.String("\x01:\x02:\x02:\x02"), b.Long(1<<40), b.Double(2.5), condy)
+	indy := b.InvokeDynamic(concat, "makeConcatWithConstants", "(Ljava/lang/String;)Ljava/lang/String;")
+	methodType := b.MethodType("(I)V")
+	cf := b.Build()
+
+	v := NewVM(mapClassLoader{"Gen": cf})
+	v.Stdout = io.Discard
+
+	if got := runFrame(t, v, cf, []byte{0x14, byte(condy >> 8), byte(condy), 0xad}); got.Long != 42 { // ldc2_w; lreturn
+		t.Errorf("ldc2_w condy: got %d, want 42", got.Long)
+	}
+	if got := runFrame(t, v, cf, []byte{0x13, byte(null >> 8), byte(null), 0xb0}); got.Type != TypeNull { // ldc_w; areturn
+		t.Errorf("ldc_w nullConstant: got %+v", got)
+	}
+	got := runFrame(t, v, cf, []byte{0x13, byte(methodType >> 8), byte(methodType), 0xb0})
+	if obj, ok := got.Ref.(*JObject); !ok || obj.ClassName != "java/lang/invoke/MethodType" || obj.Fields["_descriptor"].Ref != "(I)V" {
+		t.Errorf("ldc_w MethodType: got %+v", got.Ref)
+	}
+
+	got = runFrame(t, v, cf, []byte{
+		0x01,                                          // aconst_null
+		0xba, byte(indy >> 8), byte(indy), 0x00, 0x00, // invokedynamic
+		0xb0, // areturn
+	})
+	if s, _ := extractGoString(got); s != "null:1099511627776:2.5:42" {
+		t.Errorf("string concat: got %q", s)
+	}
+}
@@ -0,0 +1,87 @@
+package vm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// boxCacheRanges are the values whose boxes valueOf shares, as the JDK's
+// caches do, so that == holds between small boxes of equal value. The
+// Integer cache reaches java.lang.Integer.IntegerCache.high if that
+// property is larger, as it is with -XX:AutoBoxCacheMax.
+var boxCacheRanges = map[string][2]int64{
+	"java/lang/Boolean":   {0, 1},
+	"java/lang/Byte":      {-128, 127},
+	"java/lang/Short":     {-128, 127},
+	"java/lang/Character": {0, 127},
+	integerClass:          {-128, 127},
+	longClass:             {-128, 127},
+}
+
+// box returns the object of wrapper class className holding v, which is
+// shared with every other box of the same value when valueOf would share
+// it. Float and Double boxes are never shared.
+func (vm *VM) box(className string, v Value) Value {
+	bounds, cached := boxCacheRanges[className]
+	n := int64(v.Int)
+	if className == longClass {
+		n = v.Long
+	}
+	if !cached || n < bounds[0] || n > vm.boxCacheHigh(className, bounds[1]) {
+		return newBox(className, v)
+	}
+	// The boxes are made on first use, so a large IntegerCache.high costs
+	// only the values a program boxes.
+	if vm.boxCache == nil {
+		vm.boxCache = make(map[string]map[int64]Value)
+	}
+	boxes := vm.boxCache[className]
+	if boxes == nil {
+		boxes = make(map[int64]Value)
+		vm.boxCache[className] = boxes
+	}
+	b, ok := boxes[n]
+	if !ok {
+		b = newBox(className, v)
+		boxes[n] = b
+	}
+	return b
+}
+
+// boxCacheHigh returns the largest value whose box of className is
+// shared, given the class's default high.
+func (vm *VM) boxCacheHigh(className string, high int64) int64 {
+	if className != integerClass {
+		return high
+	}
+	prop := vm.systemProperties()["java.lang.Integer.IntegerCache.high"]
+	if h, err := strconv.ParseInt(strings.TrimSpace(prop), 10, 32); err == nil && h > high {
+		return h
+	}
+	return high
+}
+
+// newBox returns a new, unshared object of wrapper class className
+// holding v.
+func newBox(className string, v Value) Value {
+	return RefValue(&JObject{ClassName: className, Fields: map[string]Value{"value": v}})
+}
+
+// handleBoxValueOf implements valueOf of the primitive types on the
+// wrapper classes with caches, and Boolean.valueOf(String), so that
+// interpreted code and the VM's own boxing share one set of boxes. It
+// reports false for other methods.
+func (vm *VM) handleBoxValueOf(className, descriptor string, args []Value) (Value, bool) {
+	if _, cached := boxCacheRanges[className]; !cached || len(args) != 1 {
+		return Value{}, false
+	}
+	if descriptor == "(Ljava/lang/String;)Ljava/lang/Boolean;" {
+		s, _ := extractGoString(args[0])
+		return vm.box(className, boolValue(strings.EqualFold(s, "true"))), true
+	}
+	if len(descriptor) < 2 || boxClassNames[descriptor[1]] != className ||
+		descriptor != "("+descriptor[1:2]+")L"+className+";" {
+		return Value{}, false
+	}
+	return vm.box(className, args[0]), true
+}
@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestBoxCache(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	valueOf := func(class, descriptor string, arg Value) Value {
+		t.Helper()
+		ret, ok := v.handleBoxValueOf(class, descriptor, []Value{arg})
+		if !ok {
+			t.Fatalf("%s.valueOf%s is not handled", class, descriptor)
+		}
+		return ret
+	}
+	for _, c := range []struct {
+		class, descriptor string
+		arg               Value
+		shared            bool
+	}{
+		{integerClass, "(I)Ljava/lang/Integer;", IntValue(127), true},
+		{integerClass, "(I)Ljava/lang/Integer;", IntValue(-128), true},
+		{integerClass, "(I)Ljava/lang/Integer;", IntValue(128), false},
+		{longClass, "(J)Ljava/lang/Long;", LongValue(-1), true},
+		{longClass, "(J)Ljava/lang/Long;", LongValue(1 << 40), false},
+		{"java/lang/Short", "(S)Ljava/lang/Short;", IntValue(5), true},
+		{"java/lang/Byte", "(B)Ljava/lang/Byte;", IntValue(-128), true},
+		{"java/lang/Character", "(C)Ljava/lang/Character;", IntValue('a'), true},
+		{"java/lang/Character", "(C)Ljava/lang/Character;", IntValue('é'), false},
+		{"java/lang/Boolean", "(Z)Ljava/lang/Boolean;", IntValue(1), true},
+	} {
+		a, b := valueOf(c.class, c.descriptor, c.arg), valueOf(c.class, c.descriptor, c.arg)
+		if (a.Ref == b.Ref) != c.shared {
+			t.Errorf("%s.valueOf(%v): shared is %v, want %v", c.class, c.arg, a.Ref == b.Ref, c.shared)
+		}
+		if box := a.Ref.(*JObject); box.ClassName != c.class {
+			t.Errorf("%s.valueOf(%v): got a %s", c.class, c.arg, box.ClassName)
+		}
+	}
+
+	if valueOf("java/lang/Boolean", "(Ljava/lang/String;)Ljava/lang/Boolean;", RefValue("TRUE")).Ref != v.box("java/lang/Boolean", IntValue(1)).Ref {
+		t.Error(`Boolean.valueOf("TRUE") is not the shared TRUE`)
+	}
+	if v.boxValue(IntValue(7)).Ref != valueOf(integerClass, "(I)Ljava/lang/Integer;", IntValue(7)).Ref {
+		t.Error("the VM's own boxing does not share valueOf's boxes")
+	}
+	if v.boxValue(DoubleValue(1)).Ref == v.boxValue(DoubleValue(1)).Ref {
+		t.Error("Double boxes are shared")
+	}
+	if _, ok := v.handleBoxValueOf(integerClass, "(Ljava/lang/String;)Ljava/lang/Integer;", []Value{RefValue("1")}); ok {
+		t.Error("valueOf(String) is handled as a primitive valueOf")
+	}
+	if other := (&VM{}).boxValue(IntValue(7)); other.Ref == v.boxValue(IntValue(7)).Ref {
+		t.Error("boxes are shared between VMs")
+	}
+
+	high := &VM{Properties: map[string]string{"java.lang.Integer.IntegerCache.high": "1000"}}
+	if high.boxValue(IntValue(1000)).Ref != high.boxValue(IntValue(1000)).Ref {
+		t.Error("IntegerCache.high does not extend the Integer cache")
+	}
+	huge := &VM{Properties: map[string]string{"java.lang.Integer.IntegerCache.high": "2000000000"}}
+	if huge.boxValue(IntValue(1999999999)).Ref != huge.boxValue(IntValue(1999999999)).Ref {
+		t.Error("a large IntegerCache.high does not extend the Integer cache")
+	}
+	if n := len(huge.boxCache[integerClass]); n != 1 {
+		t.Errorf("the Integer cache holds %d boxes after boxing one value", n)
+	}
+}
+
+func TestBooleanConstantIsShared(t *testing.T) {
+	// static boolean same() { return Boolean.TRUE == Boolean.valueOf(true); }
+	b := classfile.NewBuilder("app/Boxes", "java/lang/Object")
+	trueField := b.Fieldref("java/lang/Boolean", "TRUE", "Ljava/lang/Boolean;")
+	valueOf := b.Methodref("java/lang/Boolean", "valueOf", "(Z)Ljava/lang/Boolean;")
+	b.AddMethod(classfile.AccStatic, "same", "()Z", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code: []byte{
+			OpGetstatic, byte(trueField >> 8), byte(trueField),
+			OpIconst1, OpInvokestatic, byte(valueOf >> 8), byte(valueOf),
+			OpIfAcmpne, 0, 5,
+			OpIconst1, OpIreturn,
+			OpIconst0, OpIreturn,
+		},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"app/Boxes": cf})
+	ret, err := v.executeMethod(cf, cf.FindMethodByName("same"), nil)
+	if err != nil || ret.Int != 1 {
+		t.Errorf("Boolean.TRUE == Boolean.valueOf(true): got %v, %v", ret, err)
+	}
+}
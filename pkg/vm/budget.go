@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Embedders running untrusted classes can bound the work they do by
+// setting VM.MaxInstructions, a budget for the life of the VM, and
+// VM.CallInstructions, a budget for each call of Execute or WarmUp. Once a
+// budget is spent the VM stops at the next instruction with an error
+// wrapping ErrBudgetExceeded. The error is not a Java exception, so no
+// handler or finally block in the program can intercept it, and since
+// instructions are counted the stop is deterministic.
+
+// ErrBudgetExceeded is wrapped by the error returned when execution is
+// stopped by an instruction budget.
+var ErrBudgetExceeded = errors.New("instruction budget exceeded")
+
+// budget counts executed instructions against the VM's budgets.
+type budget struct {
+	total uint64 // instructions executed by the VM
+	call  uint64 // instructions executed by the current Execute or WarmUp
+}
+
+// limited reports whether any instruction budget is set.
+func (vm *VM) limited() bool {
+	return vm.MaxInstructions != 0 || vm.CallInstructions != 0
+}
+
+// startCall starts the per-call budget of an Execute or WarmUp call.
+func (vm *VM) startCall() {
+	vm.budget.call = 0
+}
+
+// chargeInstruction counts an instruction about to execute, failing if it
+// would exceed a budget.
+func (vm *VM) chargeInstruction() error {
+	if vm.MaxInstructions != 0 && vm.budget.total >= vm.MaxInstructions {
+		return fmt.Errorf("%w: executed %d instructions (MaxInstructions)", ErrBudgetExceeded, vm.budget.total)
+	}
+	if vm.CallInstructions != 0 && vm.budget.call >= vm.CallInstructions {
+		return fmt.Errorf("%w: executed %d instructions in this call (CallInstructions)", ErrBudgetExceeded, vm.budget.call)
+	}
+	vm.budget.total++
+	vm.budget.call++
+	return nil
+}
@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestInstructionBudget(t *testing.T) {
+	// An infinite loop inside a catch-all handler, which must not catch
+	// the budget running out.
+	b := classfile.NewBuilder("Spin", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "spin", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code: []byte{
+			OpGoto, 0, 0, // 0: goto 0
+			OpPop, OpGoto, 0xff, 0xfc, // 3: handler: pop; goto 0
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 3, HandlerPC: 3}},
+	})
+	b.AddMethod(classfile.AccPublic|classfile.AccStatic, "main", "([Ljava/lang/String;)V", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpIconst0, OpPop, OpReturn},
+	})
+	cf := b.Build()
+	spin := cf.FindMethodByName("spin")
+
+	v := NewVM(mapClassLoader{"Spin": cf})
+	v.Stdout = io.Discard
+	v.MaxInstructions = 1000
+	_, err := v.executeMethod(cf, spin, nil)
+	if !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("got %v, want ErrBudgetExceeded", err)
+	}
+	if v.budget.total != 1000 {
+		t.Errorf("executed %d instructions, want 1000", v.budget.total)
+	}
+
+	// The per-call budget restarts with each Execute; the total does not.
+	v = NewVM(mapClassLoader{"Spin": cf})
+	v.Stdout = io.Discard
+	v.CallInstructions = 3
+	v.MaxInstructions = 5
+	if err := v.Execute("Spin"); err != nil {
+		t.Fatalf("first call within both budgets: %v", err)
+	}
+	if err := v.Execute("Spin"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("second call past MaxInstructions: got %v", err)
+	}
+
+	v = NewVM(mapClassLoader{"Spin": cf})
+	v.CallInstructions = 2
+	if err := v.Execute("Spin"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("call past CallInstructions: got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package vm
+
+import (
+	"strings"
+	"unicode"
+)
+
+// characterClass is java.lang.Character. Its classification and case
+// methods go through the CharacterData tables, which the VM replaces with
+// Go's unicode package. Both the char and the int code point overloads of
+// each method are handled; the rest of Character is plain Java and runs
+// as bytecode. getNumericValue knows only the decimal digits and the Latin
+// letters, so it reports -1 for other numerals such as Roman numerals and
+// fractions.
+const characterClass = "java/lang/Character"
+
+// characterPredicates are the boolean Character methods, by name.
+var characterPredicates = map[string]func(rune) bool{
+	"isDigit":  unicode.IsDigit,
+	"isLetter": unicode.IsLetter,
+	"isLetterOrDigit": func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	},
+	"isAlphabetic": func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.In(r, unicode.Nl, unicode.Other_Alphabetic)
+	},
+	"isWhitespace": isJavaWhitespace,
+	"isSpaceChar": func(r rune) bool {
+		return unicode.In(r, unicode.Zs, unicode.Zl, unicode.Zp)
+	},
+	"isUpperCase": func(r rune) bool {
+		return unicode.In(r, unicode.Lu, unicode.Other_Uppercase)
+	},
+	"isLowerCase": func(r rune) bool {
+		return unicode.In(r, unicode.Ll, unicode.Other_Lowercase)
+	},
+	"isTitleCase": unicode.IsTitle,
+	"isDefined": func(r rune) bool {
+		return getCharacterType(r) != 0
+	},
+	"isJavaIdentifierStart": func(r rune) bool {
+		return unicode.In(r, unicode.L, unicode.Nl, unicode.Sc, unicode.Pc)
+	},
+	"isJavaIdentifierPart": func(r rune) bool {
+		return unicode.In(r, unicode.L, unicode.Nl, unicode.Nd, unicode.Sc, unicode.Pc, unicode.Mn, unicode.Mc) ||
+			isIdentifierIgnorable(r)
+	},
+	"isIdentifierIgnorable": isIdentifierIgnorable,
+}
+
+// characterTypes maps the Unicode general categories to the values
+// Character.getType returns for them. Unassigned code points are 0.
+var characterTypes = []struct {
+	table *unicode.RangeTable
+	code  int32
+}{
+	{unicode.Lu, 1}, {unicode.Ll, 2}, {unicode.Lt, 3}, {unicode.Lm, 4}, {unicode.Lo, 5},
+	{unicode.Mn, 6}, {unicode.Me, 7}, {unicode.Mc, 8},
+	{unicode.Nd, 9}, {unicode.Nl, 10}, {unicode.No, 11},
+	{unicode.Zs, 12}, {unicode.Zl, 13}, {unicode.Zp, 14},
+	{unicode.Cc, 15}, {unicode.Cf, 16}, {unicode.Co, 18}, {unicode.Cs, 19},
+	{unicode.Pd, 20}, {unicode.Ps, 21}, {unicode.Pe, 22}, {unicode.Pc, 23}, {unicode.Po, 24},
+	{unicode.Sm, 25}, {unicode.Sc, 26}, {unicode.Sk, 27}, {unicode.So, 28},
+	{unicode.Pi, 29}, {unicode.Pf, 30},
+}
+
+// getCharacterType implements Character.getType.
+func getCharacterType(r rune) int32 {
+	for _, t := range characterTypes {
+		if unicode.Is(t.table, r) {
+			return t.code
+		}
+	}
+	return 0
+}
+
+// isIdentifierIgnorable implements Character.isIdentifierIgnorable: the
+// non-whitespace ISO controls and the format characters.
+func isIdentifierIgnorable(r rune) bool {
+	return r <= 0x08 || (r >= 0x0E && r <= 0x1B) || (r >= 0x7F && r <= 0x9F) || unicode.Is(unicode.Cf, r)
+}
+
+// digitValue returns the value of r as a digit in any radix up to 36: the
+// decimal digits of every script, then the Latin letters and their
+// fullwidth forms from 10. It returns -1 for anything else.
+func digitValue(r rune) int32 {
+	switch {
+	case r >= 'a' && r <= 'z':
+		return r - 'a' + 10
+	case r >= 'A' && r <= 'Z':
+		return r - 'A' + 10
+	case r >= 'ａ' && r <= 'ｚ':
+		return r - 'ａ' + 10
+	case r >= 'Ａ' && r <= 'Ｚ':
+		return r - 'Ａ' + 10
+	}
+	// Every run of decimal digits starts at zero, so a digit's value is
+	// its offset in its range.
+	for _, rng := range unicode.Nd.R16 {
+		if lo, hi := rune(rng.Lo), rune(rng.Hi); r >= lo && r <= hi {
+			return (r - lo) % 10
+		}
+	}
+	for _, rng := range unicode.Nd.R32 {
+		if lo, hi := rune(rng.Lo), rune(rng.Hi); r >= lo && r <= hi {
+			return (r - lo) % 10
+		}
+	}
+	return -1
+}
+
+// handleCharacterStatic implements the static Character methods that use
+// CharacterData. It reports false for other methods.
+func handleCharacterStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	if len(args) == 0 {
+		return Value{}, false, nil
+	}
+	r := args[0].Int
+	if pred, ok := characterPredicates[methodName]; ok {
+		return boolValue(pred(r)), true, nil
+	}
+
+	var ret int32
+	switch methodName {
+	case "toUpperCase":
+		ret = unicode.ToUpper(r)
+	case "toLowerCase":
+		ret = unicode.ToLower(r)
+	case "toTitleCase":
+		ret = unicode.ToTitle(r)
+	case "getType":
+		ret = getCharacterType(r)
+	case "getNumericValue":
+		ret = digitValue(r)
+	case "digit":
+		radix := args[1].Int
+		ret = digitValue(r)
+		if radix < 2 || radix > 36 || ret >= radix {
+			ret = -1
+		}
+	default:
+		return Value{}, false, nil
+	}
+	if strings.HasSuffix(descriptor, ")C") {
+		ret = int32(uint16(ret))
+	}
+	return IntValue(ret), true, nil
+}
@@ -0,0 +1,72 @@
+package vm
+
+import "testing"
+
+func TestCharacterStatic(t *testing.T) {
+	call := func(method, descriptor string, args ...Value) int32 {
+		t.Helper()
+		ret, handled, err := handleCharacterStatic(method, descriptor, args)
+		if !handled || err != nil {
+			t.Fatalf("%s%s: %v, %v", method, descriptor, handled, err)
+		}
+		return ret.Int
+	}
+	for _, c := range []struct {
+		method string
+		ch     rune
+		want   bool
+	}{
+		{"isDigit", '7', true},
+		{"isDigit", '٣', true},
+		{"isDigit", 'x', false},
+		{"isLetter", 'é', true},
+		{"isLetter", '_', false},
+		{"isLetterOrDigit", '9', true},
+		{"isAlphabetic", 'Ⅻ', true},
+		{"isWhitespace", '\t', true},
+		{"isWhitespace", ' ', false},
+		{"isSpaceChar", ' ', true},
+		{"isUpperCase", 'Ä', true},
+		{"isLowerCase", 'ª', true},
+		{"isTitleCase", 'ǅ', true},
+		{"isJavaIdentifierStart", '$', true},
+		{"isJavaIdentifierStart", '1', false},
+		{"isJavaIdentifierPart", '1', true},
+		{"isIdentifierIgnorable", '\u200B', true},
+		{"isDefined", 0x0378, false},
+	} {
+		if got := call(c.method, "(I)Z", IntValue(c.ch)) != 0; got != c.want {
+			t.Errorf("%s(%q): got %v", c.method, c.ch, got)
+		}
+	}
+
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+		want               int32
+	}{
+		{"toUpperCase", "(C)C", []Value{IntValue('a')}, 'A'},
+		{"toUpperCase", "(C)C", []Value{IntValue('ß')}, 'ß'},
+		{"toLowerCase", "(I)I", []Value{IntValue('İ')}, 'i'},
+		{"toLowerCase", "(I)I", []Value{IntValue(0x10400)}, 0x10428},
+		{"toTitleCase", "(C)C", []Value{IntValue('ǆ')}, 'ǅ'},
+		{"getNumericValue", "(C)I", []Value{IntValue('8')}, 8},
+		{"getNumericValue", "(C)I", []Value{IntValue('Z')}, 35},
+		{"getNumericValue", "(C)I", []Value{IntValue('९')}, 9},
+		{"getNumericValue", "(C)I", []Value{IntValue('-')}, -1},
+		{"digit", "(CI)I", []Value{IntValue('f'), IntValue(16)}, 15},
+		{"digit", "(CI)I", []Value{IntValue('g'), IntValue(16)}, -1},
+		{"digit", "(II)I", []Value{IntValue('1'), IntValue(1)}, -1},
+		{"getType", "(C)I", []Value{IntValue('A')}, 1},
+		{"getType", "(C)I", []Value{IntValue('(')}, 21},
+		{"getType", "(I)I", []Value{IntValue(0xD800)}, 19},
+	} {
+		if got := call(c.method, c.descriptor, c.args...); got != c.want {
+			t.Errorf("%s%s%v: got %d, want %d", c.method, c.descriptor, c.args, got, c.want)
+		}
+	}
+
+	if _, handled, _ := handleCharacterStatic("isSurrogate", "(C)Z", []Value{IntValue('a')}); handled {
+		t.Error("isSurrogate is plain Java and should not be handled")
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/daimatz/gojvm/pkg/classfile"
 )
@@ -91,7 +92,8 @@ func (cl *JmodClassLoader) LoadClass(name string) (*classfile.ClassFile, error)
 	return nil, fmt.Errorf("jmod: class %s not found in %s", name, cl.JmodPath)
 }
 
-// UserClassLoader loads user classes from the classpath, delegating to the parent first.
+// UserClassLoader loads user classes from the classpath, delegating to the
+// parent, if any, first.
 type UserClassLoader struct {
 	ClassPath string
 	Parent    ClassLoader
@@ -111,8 +113,10 @@ func (cl *UserClassLoader) LoadClass(name string) (*classfile.ClassFile, error)
 	if cf, ok := cl.Cache[name]; ok {
 		return cf, nil
 	}
-	if cf, err := cl.Parent.LoadClass(name); err == nil {
-		return cf, nil
+	if cl.Parent != nil {
+		if cf, err := cl.Parent.LoadClass(name); err == nil {
+			return cf, nil
+		}
 	}
 	path := filepath.Join(cl.ClassPath, name+".class")
 	cf, err := classfile.ParseFile(path)
@@ -122,3 +126,54 @@ func (cl *UserClassLoader) LoadClass(name string) (*classfile.ClassFile, error)
 	cl.Cache[name] = cf
 	return cf, nil
 }
+
+// ZipClassLoader loads classes from a jar held in memory, such as the
+// java.base subset embedded in a self-contained binary.
+type ZipClassLoader struct {
+	Cache   map[string]*classfile.ClassFile
+	entries map[string]*zip.File
+}
+
+// NewZipClassLoader creates a ZipClassLoader for the jar in data.
+func NewZipClassLoader(data []byte) (*ZipClassLoader, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("zip: opening jar: %w", err)
+	}
+	cl := &ZipClassLoader{
+		Cache:   make(map[string]*classfile.ClassFile),
+		entries: make(map[string]*zip.File, len(zr.File)),
+	}
+	for _, f := range zr.File {
+		if name, ok := strings.CutSuffix(f.Name, ".class"); ok {
+			cl.entries[name] = f
+		}
+	}
+	return cl, nil
+}
+
+// Len returns the number of classes in the jar.
+func (cl *ZipClassLoader) Len() int {
+	return len(cl.entries)
+}
+
+func (cl *ZipClassLoader) LoadClass(name string) (*classfile.ClassFile, error) {
+	if cf, ok := cl.Cache[name]; ok {
+		return cf, nil
+	}
+	f, ok := cl.entries[name]
+	if !ok {
+		return nil, fmt.Errorf("zip: class %s not found", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("zip: opening %s: %w", f.Name, err)
+	}
+	defer rc.Close()
+	cf, err := classfile.Parse(rc)
+	if err != nil {
+		return nil, fmt.Errorf("zip: parsing %s: %w", name, err)
+	}
+	cl.Cache[name] = cf
+	return cf, nil
+}
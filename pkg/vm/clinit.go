@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Class initialization follows the procedure of JVMS §5.5. Every class is
+// in one of four states: not yet initialized, being initialized by one
+// thread, fully initialized, or erroneous after a failed <clinit>. An
+// erroneous class keeps the exception that made it so, which later
+// NoClassDefFoundErrors for it report as their cause. The
+// state table is guarded by initMu. A thread that asks for a class being
+// initialized by another thread waits on initCond until the owner is done;
+// a recursive request from the initializing thread itself returns at once,
+// so <clinit> runs exactly once and never interleaves. The VM currently
+// runs a single Java thread, for which the waiting branch never triggers.
+
+type classInitState int
+
+const (
+	classUninitialized classInitState = iota
+	classBeingInitialized
+	classInitialized
+	classErroneous
+)
+
+// classInit is the initialization state of one class.
+type classInit struct {
+	state   classInitState
+	thread  *JObject // initializing thread while classBeingInitialized
+	failure *JObject // the exception that made the class erroneous, if any
+}
+
+// initCondition returns the condition variable signalled whenever a class
+// leaves the being-initialized state. initMu must be held.
+func (vm *VM) initCondition() *sync.Cond {
+	if vm.initCond == nil {
+		vm.initCond = sync.NewCond(&vm.initMu)
+	}
+	return vm.initCond
+}
+
+// ensureInitialized initializes className if that has not been done yet,
+// running the superclass initialization and then <clinit>.
+func (vm *VM) ensureInitialized(className string) error {
+	thread, _ := vm.currentThread().Ref.(*JObject)
+
+	vm.initMu.Lock()
+	if vm.classInits == nil {
+		vm.classInits = make(map[string]*classInit)
+	}
+	for {
+		ci := vm.classInits[className]
+		if ci == nil {
+			vm.classInits[className] = &classInit{state: classBeingInitialized, thread: thread}
+			break
+		}
+		switch {
+		case ci.state == classBeingInitialized && ci.thread != thread:
+			vm.initCondition().Wait()
+			continue
+		case ci.state == classErroneous:
+			vm.initMu.Unlock()
+			return vm.noClassDefFound(className, ci.failure)
+		}
+		// initialized, or a recursive request by the initializing thread
+		vm.initMu.Unlock()
+		return nil
+	}
+	vm.initMu.Unlock()
+
+	cf, err := vm.ClassLoader.LoadClass(className)
+	if err != nil {
+		vm.finishInitialization(className, classUninitialized)
+		return nil // class not found is OK for initialization
+	}
+
+	if err := vm.linkClass(className); err != nil {
+		vm.finishInitialization(className, classUninitialized)
+		return err
+	}
+
+	// statics start at their defaults, and static final constants are set
+	// from ConstantValue attributes rather than by <clinit>
+	vm.prepareStaticFields(className, cf)
+
+	// Initialize superclass first
+	if superName := cf.SuperClassName(); superName != "" {
+		if err := vm.ensureInitialized(superName); err != nil {
+			vm.failInitialization(className, err)
+			return err
+		}
+	}
+
+	// Run <clinit> if present
+	if clinit := cf.FindMethod("<clinit>", "()V"); clinit != nil {
+		if _, err := vm.executeMethod(cf, clinit, nil); err != nil {
+			vm.failInitialization(className, err)
+			if exc, ok := err.(*JavaException); ok {
+				return vm.initializerError(exc)
+			}
+			return fmt.Errorf("error in <clinit> of %s: %w", className, err)
+		}
+	}
+	vm.finishInitialization(className, classInitialized)
+	if vm.metrics != nil {
+		vm.metrics.countClass()
+	}
+	return nil
+}
+
+// finishInitialization records the outcome of initializing className and
+// wakes threads waiting for it. classUninitialized forgets the attempt, so
+// that a class that could not be loaded is tried again on next use.
+func (vm *VM) finishInitialization(className string, state classInitState) {
+	vm.initMu.Lock()
+	defer vm.initMu.Unlock()
+	if state == classUninitialized {
+		delete(vm.classInits, className)
+	} else {
+		vm.classInits[className] = &classInit{state: state}
+	}
+	vm.initCondition().Broadcast()
+}
+
+// failInitialization marks className erroneous after initializing it
+// failed with err, and wakes threads waiting for it.
+func (vm *VM) failInitialization(className string, err error) {
+	ci := &classInit{state: classErroneous}
+	if exc, ok := err.(*JavaException); ok {
+		ci.failure = exc.Object
+	}
+	vm.initMu.Lock()
+	defer vm.initMu.Unlock()
+	vm.classInits[className] = ci
+	vm.initCondition().Broadcast()
+}
+
+// initializerError returns the exception to throw for an exception that
+// escaped <clinit>: Errors propagate unchanged, anything else is wrapped in
+// ExceptionInInitializerError.
+func (vm *VM) initializerError(exc *JavaException) error {
+	if vm.isError(exc.Object.ClassName) {
+		return exc
+	}
+	wrapped := NewJavaException("java/lang/ExceptionInInitializerError")
+	wrapped.Object.Fields["cause"] = RefValue(exc.Object)
+	return wrapped
+}
+
+// noClassDefFound returns the error thrown on use of the erroneous class
+// className. As in JDK 21, its cause is an ExceptionInInitializerError
+// that describes failure, the exception that made the class erroneous,
+// and carries its stack trace.
+func (vm *VM) noClassDefFound(className string, failure *JObject) error {
+	exc := NewJavaExceptionMessage("java/lang/NoClassDefFoundError", "Could not initialize class "+strings.ReplaceAll(className, "/", "."))
+	if failure != nil {
+		thread, _ := vm.currentThread().Ref.(*JObject)
+		name, _ := extractGoString(thread.Fields["name"])
+		cause := NewJavaExceptionMessage("java/lang/ExceptionInInitializerError",
+			fmt.Sprintf("Exception %s [in thread %q]", vm.valueToString(RefValue(failure)), name))
+		if trace, ok := failure.Fields["_stackTrace"]; ok {
+			cause.Object.Fields["_stackTrace"] = trace
+		}
+		exc.Object.Fields["cause"] = RefValue(cause.Object)
+	}
+	return exc
+}
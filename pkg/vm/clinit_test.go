@@ -0,0 +1,171 @@
+package vm
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestClassInitialization(t *testing.T) {
+	b := classfile.NewBuilder("Once", "java/lang/Object")
+	b.AddField(classfile.AccStatic, "runs", "I", nil)
+	runs := b.Fieldref("Once", "runs", "I")
+	touch := b.Methodref("Once", "touch", "()V")
+	b.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code: []byte{
+			0xb2, byte(runs >> 8), byte(runs), // getstatic runs
+			0x04, 0x60, // iconst_1; iadd
+			0xb3, byte(runs >> 8), byte(runs), // putstatic runs
+			0xb8, byte(touch >> 8), byte(touch), // invokestatic touch: a recursive request
+			0xb1, // return
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "touch", "()V", &classfile.CodeAttribute{Code: []byte{0xb1}})
+
+	bad := classfile.NewBuilder("Bad", "java/lang/Object")
+	bad.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{0x01, 0xbf}, // aconst_null; athrow
+	})
+
+	// Errors escape <clinit> unwrapped, even when the JDK's classes are
+	// not available to tell that they are Errors.
+	oom := classfile.NewBuilder("Oom", "java/lang/Object")
+	errClass := oom.Class("java/lang/OutOfMemoryError")
+	errInit := oom.Methodref("java/lang/OutOfMemoryError", "<init>", "()V")
+	oom.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code: []byte{
+			OpNew, byte(errClass >> 8), byte(errClass), OpDup,
+			OpInvokespecial, byte(errInit >> 8), byte(errInit), OpAthrow,
+		},
+	})
+
+	v := NewVM(mapClassLoader{"Once": b.Build(), "Bad": bad.Build(), "Oom": oom.Build()})
+	v.Stdout = io.Discard
+
+	for i := 0; i < 2; i++ {
+		if err := v.ensureInitialized("Once"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := v.getStaticField("Once", "runs"); got.Int != 1 {
+		t.Errorf("<clinit> ran %d times, want 1", got.Int)
+	}
+
+	err := v.ensureInitialized("Bad")
+	exc, ok := err.(*JavaException)
+	if !ok || exc.Object.ClassName != "java/lang/ExceptionInInitializerError" {
+		t.Fatalf("first attempt: got %v", err)
+	}
+	if cause, _ := exc.Object.Fields["cause"].Ref.(*JObject); cause == nil || cause.ClassName != "java/lang/NullPointerException" {
+		t.Errorf("cause: got %+v", exc.Object.Fields["cause"])
+	}
+	err = v.ensureInitialized("Bad")
+	exc, ok = err.(*JavaException)
+	if !ok || exc.Object.ClassName != "java/lang/NoClassDefFoundError" {
+		t.Fatalf("second attempt: got %v", err)
+	}
+	if msg, _ := exc.Message(); msg != "Could not initialize class Bad" {
+		t.Errorf("second attempt message: got %q", msg)
+	}
+	cause := exc.Cause()
+	if cause == nil || cause.Object.ClassName != "java/lang/ExceptionInInitializerError" {
+		t.Fatalf("second attempt cause: got %v", cause)
+	}
+	if msg, _ := cause.Message(); msg != `Exception java.lang.NullPointerException: Cannot throw exception because "null" is null [in thread "main"]` {
+		t.Errorf("second attempt cause message: got %q", msg)
+	}
+
+	if err := v.ensureInitialized("Oom"); !isJavaException(err, "java/lang/OutOfMemoryError") {
+		t.Errorf("Error in <clinit>: got %v", err)
+	}
+}
+
+func TestClassInitializationWaitsForOtherThread(t *testing.T) {
+	v := NewVM(mapClassLoader{"Slow": classfile.NewBuilder("Slow", "java/lang/Object").Build()})
+	v.Stdout = io.Discard
+	v.currentThread()
+
+	// Another thread is in the middle of initializing Slow.
+	other := &JObject{ClassName: "java/lang/Thread", Fields: map[string]Value{}}
+	v.classInits = map[string]*classInit{"Slow": {state: classBeingInitialized, thread: other}}
+
+	done := make(chan error)
+	go func() { done <- v.ensureInitialized("Slow") }()
+	select {
+	case err := <-done:
+		t.Fatalf("returned while another thread was initializing: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	v.finishInitialization("Slow", classInitialized)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("still waiting after initialization finished")
+	}
+}
+
+func TestClassInitializationErrorsAreCatchable(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		code func(b *classfile.Builder) []byte // leaves nothing on the stack
+	}{
+		{"getstatic", func(b *classfile.Builder) []byte {
+			f := b.Fieldref("Bad", "f", "I")
+			return []byte{OpGetstatic, byte(f >> 8), byte(f), OpPop}
+		}},
+		{"putstatic", func(b *classfile.Builder) []byte {
+			f := b.Fieldref("Bad", "f", "I")
+			return []byte{OpIconst0, OpPutstatic, byte(f >> 8), byte(f)}
+		}},
+		{"invokestatic", func(b *classfile.Builder) []byte {
+			m := b.Methodref("Bad", "m", "()V")
+			return []byte{OpInvokestatic, byte(m >> 8), byte(m)}
+		}},
+		{"new", func(b *classfile.Builder) []byte {
+			c := b.Class("Bad")
+			return []byte{OpNew, byte(c >> 8), byte(c), OpPop}
+		}},
+	} {
+		bad := classfile.NewBuilder("Bad", "java/lang/Object")
+		bad.AddField(classfile.AccStatic, "f", "I", nil)
+		bad.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+			MaxStack: 1,
+			Code:     []byte{OpAconstNull, OpAthrow},
+		})
+		bad.AddMethod(classfile.AccStatic, "m", "()V", &classfile.CodeAttribute{Code: []byte{OpReturn}})
+
+		// probe() runs the instruction and returns whatever it throws.
+		b := classfile.NewBuilder("Probe", "java/lang/Object")
+		code := append(tt.code(b), OpAconstNull, OpAreturn)
+		handler := uint16(len(code))
+		code = append(code, OpAreturn)
+		b.AddMethod(classfile.AccStatic, "probe", "()Ljava/lang/Throwable;", &classfile.CodeAttribute{
+			MaxStack:          2,
+			Code:              code,
+			ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: handler, HandlerPC: handler}},
+		})
+		cf := b.Build()
+		v := NewVM(mapClassLoader{"Bad": bad.Build(), "Probe": cf})
+		v.Stdout = io.Discard
+
+		for _, want := range []string{"java/lang/ExceptionInInitializerError", "java/lang/NoClassDefFoundError"} {
+			got, err := v.executeMethod(cf, cf.FindMethodByName("probe"), nil)
+			if err != nil {
+				t.Errorf("%s: escaped the handler: %v", tt.name, err)
+				break
+			}
+			if obj, _ := got.Ref.(*JObject); obj == nil || obj.ClassName != want {
+				t.Errorf("%s: caught %+v, want %s", tt.name, got.Ref, want)
+			}
+		}
+	}
+}
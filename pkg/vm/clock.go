@@ -0,0 +1,42 @@
+package vm
+
+import "time"
+
+// Clock is the time source of System.currentTimeMillis, System.nanoTime
+// and the JDK's Instant.now. Setting VM.Clock to a fixed or stepped Clock
+// makes timing code deterministic.
+type Clock interface {
+	// Now returns the wall-clock time.
+	Now() time.Time
+	// Nanotime returns monotonic nanoseconds since an arbitrary origin.
+	Nanotime() int64
+}
+
+// hostClock reads the host clock. Its monotonic origin is the start of
+// the process.
+type hostClock struct{}
+
+var processStart = time.Now()
+
+func (hostClock) Now() time.Time  { return time.Now() }
+func (hostClock) Nanotime() int64 { return int64(time.Since(processStart)) }
+
+// clock returns the VM's Clock, the host clock if none is set.
+func (vm *VM) clock() Clock {
+	if vm.Clock == nil {
+		return hostClock{}
+	}
+	return vm.Clock
+}
+
+// nanoTimeAdjustment returns the nanoseconds between offset seconds since
+// the epoch and now, or -1 if that does not fit in a long, as
+// jdk.internal.misc.VM.getNanoTimeAdjustment does. The JDK only accepts
+// offsets within 2^32 seconds of now.
+func nanoTimeAdjustment(now time.Time, offset int64) int64 {
+	diff := now.Unix() - offset
+	if diff >= 1<<32 || diff <= -(1<<32) {
+		return -1
+	}
+	return diff*int64(time.Second) + int64(now.Nanosecond())
+}
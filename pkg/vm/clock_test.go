@@ -0,0 +1,52 @@
+package vm
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedClock is a Clock stopped at now, whose monotonic time is nanos.
+type fixedClock struct {
+	now   time.Time
+	nanos int64
+}
+
+func (c fixedClock) Now() time.Time  { return c.now }
+func (c fixedClock) Nanotime() int64 { return c.nanos }
+
+func TestClock(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	v.Clock = fixedClock{now: time.Unix(1700000000, 123456789), nanos: 42}
+
+	if got, err := v.executeNativeMethod("java/lang/System", "currentTimeMillis", "()J", nil); err != nil || got != LongValue(1700000000123) {
+		t.Errorf("currentTimeMillis: got %v, %v", got, err)
+	}
+	if got, err := v.executeNativeMethod("java/lang/System", "nanoTime", "()J", nil); err != nil || got != LongValue(42) {
+		t.Errorf("nanoTime: got %v, %v", got, err)
+	}
+	for _, c := range []struct {
+		offset, want int64
+	}{
+		{1700000000, 123456789},
+		{1699999990, 10123456789},
+		{1700000001, -876543211},
+		{-3000000000, -1},
+	} {
+		got, err := v.executeNativeMethod("jdk/internal/misc/VM", "getNanoTimeAdjustment", "(J)J", []Value{LongValue(c.offset)})
+		if err != nil || got != LongValue(c.want) {
+			t.Errorf("getNanoTimeAdjustment(%d): got %v, %v, want %d", c.offset, got, err, c.want)
+		}
+	}
+
+	// The host clock is monotonic and close to the wall clock.
+	v.Clock = nil
+	first, _ := v.executeNativeMethod("java/lang/System", "nanoTime", "()J", nil)
+	second, _ := v.executeNativeMethod("java/lang/System", "nanoTime", "()J", nil)
+	if second.Long < first.Long {
+		t.Errorf("nanoTime went backwards: %d then %d", first.Long, second.Long)
+	}
+	millis, _ := v.executeNativeMethod("java/lang/System", "currentTimeMillis", "()J", nil)
+	if d := time.Now().UnixMilli() - millis.Long; d < 0 || d > 1000 {
+		t.Errorf("currentTimeMillis: %d ms from the host clock", d)
+	}
+}
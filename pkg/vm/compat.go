@@ -0,0 +1,369 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// CheckCompatibility answers "can gojvm run this?" without executing
+// anything. It scans the bytecode of a set of classes for the opcodes they
+// use, the methods they invoke outside the set, the native methods those
+// and the classes themselves need, and the invokedynamic bootstrap methods
+// they link, and checks each against what the VM implements. Invoked
+// methods are resolved through the VM's class loader; the natives of the
+// JDK code they run in turn are not followed.
+
+// CompatStatus says whether the VM supports a required feature.
+type CompatStatus int
+
+const (
+	CompatSupported   CompatStatus = iota
+	CompatUnchecked                // left to an installed fallback
+	CompatUnsupported              // fails at run time
+)
+
+func (s CompatStatus) String() string {
+	switch s {
+	case CompatSupported:
+		return "ok"
+	case CompatUnchecked:
+		return "unchecked"
+	}
+	return "unsupported"
+}
+
+// Kinds of CompatFinding.
+const (
+	CompatOpcode    = "opcode"
+	CompatMethod    = "method"
+	CompatNative    = "native"
+	CompatBootstrap = "bootstrap"
+)
+
+// CompatFinding is one feature the analyzed classes require.
+type CompatFinding struct {
+	Kind   string // CompatOpcode, CompatMethod, CompatNative or CompatBootstrap
+	Name   string // mnemonic, "class.name:descriptor" or bootstrap "class.name"
+	Status CompatStatus
+	Detail string   // why the feature is not supported, "" if it is
+	UsedBy []string // "class.name:descriptor" of the methods requiring it
+}
+
+// CompatReport is the result of CheckCompatibility.
+type CompatReport struct {
+	Classes  []string
+	Findings []CompatFinding // ordered by kind, then name
+}
+
+// Compatible reports whether no finding is unsupported.
+func (r *CompatReport) Compatible() bool {
+	for _, f := range r.Findings {
+		if f.Status == CompatUnsupported {
+			return false
+		}
+	}
+	return true
+}
+
+// Write renders the report, one finding per line, grouped by kind and
+// followed by the verdict.
+func (r *CompatReport) Write(w io.Writer) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "classes: %s\n", strings.Join(r.Classes, ", "))
+	kind := ""
+	for _, f := range r.Findings {
+		if f.Kind != kind {
+			kind = f.Kind
+			fmt.Fprintf(&sb, "\n%ss:\n", kind)
+		}
+		fmt.Fprintf(&sb, "  %-11s %s", f.Status, f.Name)
+		if f.Detail != "" {
+			fmt.Fprintf(&sb, " (%s)", f.Detail)
+		}
+		sb.WriteByte('\n')
+		if f.Status != CompatSupported {
+			for _, user := range f.UsedBy {
+				fmt.Fprintf(&sb, "              used by %s\n", user)
+			}
+		}
+	}
+	verdict := "PASS"
+	if !r.Compatible() {
+		verdict = "FAIL"
+	}
+	fmt.Fprintf(&sb, "\nverdict: %s\n", verdict)
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// unimplementedOpcodes are the valid opcodes the interpreter rejects: the
+// reserved opcodes, which never appear in class files.
+var unimplementedOpcodes = map[byte]bool{
+	0xCA: true, // breakpoint
+	0xFE: true, // impdep1
+	0xFF: true, // impdep2
+}
+
+// builtinUnsafeNatives are the Unsafe methods handleUnsafe implements by
+// name, besides its field accessors.
+var builtinUnsafeNatives = map[string]bool{
+	"allocateInstance": true, "arrayBaseOffset0": true, "arrayBaseOffset": true,
+	"arrayIndexScale0": true, "arrayIndexScale": true, "objectFieldOffset1": true,
+	"objectFieldOffset0": true, "staticFieldOffset0": true, "staticFieldBase0": true,
+	"allocateMemory0": true, "reallocateMemory0": true, "freeMemory0": true,
+	"setMemory0": true, "copyMemory0": true, "compareAndSetInt": true,
+	"compareAndSetLong": true, "compareAndSetReference": true, "compareAndExchangeInt": true,
+	"compareAndExchangeLong": true, "compareAndExchangeReference": true,
+}
+
+// builtinBootstraps are the invokedynamic bootstrap methods
+// executeInvokedynamic links.
+var builtinBootstraps = map[string]bool{
+	"java/lang/invoke/LambdaMetafactory.metafactory":               true,
+	"java/lang/invoke/StringConcatFactory.makeConcatWithConstants": true,
+	"java/lang/runtime/ObjectMethods.bootstrap":                    true,
+}
+
+// implementsNative reports whether executeNativeMethod has an
+// implementation of the native method, built in or registered.
+func (vm *VM) implementsNative(className, methodName, descriptor string) bool {
+	if vm.lookupNative(className, methodName, descriptor) != nil {
+		return true
+	}
+	if className == unsafeClass {
+		if builtinUnsafeNatives[methodName] ||
+			strings.HasPrefix(methodName, "get") && strings.HasPrefix(descriptor, "(Ljava/lang/Object;J)") ||
+			strings.HasPrefix(methodName, "put") && strings.HasPrefix(descriptor, "(Ljava/lang/Object;J") {
+			return true
+		}
+	}
+	return (methodName == "registerNatives" || methodName == "initIDs") && descriptor == "()V"
+}
+
+// compatChecker accumulates findings while scanning.
+type compatChecker struct {
+	vm       *VM
+	analyzed map[string]bool
+	findings map[string]*CompatFinding // kind + " " + name -> finding
+}
+
+// CheckCompatibility analyzes classes, which must be loadable through the
+// VM's class loader together with everything they reference.
+func (vm *VM) CheckCompatibility(classes ...*classfile.ClassFile) (*CompatReport, error) {
+	c := &compatChecker{vm: vm, analyzed: make(map[string]bool), findings: make(map[string]*CompatFinding)}
+	report := &CompatReport{}
+	for _, cf := range classes {
+		name, err := cf.ClassName()
+		if err != nil {
+			return nil, err
+		}
+		c.analyzed[name] = true
+		report.Classes = append(report.Classes, name)
+	}
+	for _, cf := range classes {
+		name, _ := cf.ClassName()
+		for i := range cf.Methods {
+			m := &cf.Methods[i]
+			user := name + "." + m.Name + ":" + m.Descriptor
+			if m.AccessFlags&AccNative != 0 {
+				c.native(name, m.Name, m.Descriptor, user)
+			}
+			if m.Code != nil {
+				if err := c.scanCode(cf, m.Code.Code, user); err != nil {
+					return nil, fmt.Errorf("%s: %w", user, err)
+				}
+			}
+		}
+	}
+
+	for _, f := range c.findings {
+		report.Findings = append(report.Findings, *f)
+	}
+	order := map[string]int{CompatOpcode: 0, CompatBootstrap: 1, CompatMethod: 2, CompatNative: 3}
+	sort.Slice(report.Findings, func(i, j int) bool {
+		a, b := report.Findings[i], report.Findings[j]
+		if a.Kind != b.Kind {
+			return order[a.Kind] < order[b.Kind]
+		}
+		return a.Name < b.Name
+	})
+	return report, nil
+}
+
+// add records that user requires a feature. The status of the first
+// report of a feature wins.
+func (c *compatChecker) add(kind, name string, status CompatStatus, detail, user string) {
+	key := kind + " " + name
+	f, ok := c.findings[key]
+	if !ok {
+		f = &CompatFinding{Kind: kind, Name: name, Status: status, Detail: detail}
+		c.findings[key] = f
+	}
+	for _, u := range f.UsedBy {
+		if u == user {
+			return
+		}
+	}
+	f.UsedBy = append(f.UsedBy, user)
+}
+
+// scanCode walks the instructions of a method body.
+func (c *compatChecker) scanCode(cf *classfile.ClassFile, code []byte, user string) error {
+	pool := cf.ConstantPool
+	for pc := 0; pc < len(code); {
+		op := code[pc]
+		n, err := instructionLength(code, pc)
+		if err != nil {
+			return err
+		}
+		switch {
+		case unimplementedOpcodes[op] && c.vm.OpcodeFallback != nil:
+			c.add(CompatOpcode, OpcodeName(op), CompatUnchecked, "left to OpcodeFallback", user)
+		case unimplementedOpcodes[op] || opcodeNames[op] == "":
+			c.add(CompatOpcode, OpcodeName(op), CompatUnsupported, "not implemented", user)
+		default:
+			c.add(CompatOpcode, OpcodeName(op), CompatSupported, "", user)
+		}
+
+		switch op {
+		case OpInvokevirtual, OpInvokespecial, OpInvokestatic, OpInvokeinterface:
+			index := binary.BigEndian.Uint16(code[pc+1:])
+			ref, err := classfile.ResolveMethodref(pool, index)
+			if err != nil {
+				ref, err = classfile.ResolveInterfaceMethodref(pool, index)
+			}
+			if err != nil {
+				return fmt.Errorf("pc %d: %w", pc, err)
+			}
+			c.method(ref, user)
+		case OpInvokedynamic:
+			c.bootstrap(cf, binary.BigEndian.Uint16(code[pc+1:]), user)
+		}
+		pc += n
+	}
+	return nil
+}
+
+// method resolves an invoked method and checks the native it may be.
+func (c *compatChecker) method(ref *classfile.MethodRefInfo, user string) {
+	name := ref.ClassName + "." + ref.MethodName + ":" + ref.Descriptor
+	if strings.HasPrefix(ref.ClassName, "[") {
+		return // clone() and the Object methods of arrays
+	}
+	owner, m, err := c.vm.resolveMethod(ref.ClassName, ref.MethodName, ref.Descriptor)
+	if err != nil {
+		// Signature polymorphic methods such as MethodHandle.invoke are
+		// declared with a single (Object[])Object descriptor.
+		if cf, lerr := c.vm.ClassLoader.LoadClass(ref.ClassName); lerr == nil {
+			if pm := cf.FindMethodByName(ref.MethodName); pm != nil && pm.AccessFlags&(AccNative|AccVarargs) == AccNative|AccVarargs {
+				owner, m, err = cf, pm, nil
+			}
+		}
+	}
+	if err != nil {
+		c.add(CompatMethod, name, CompatUnsupported, "not found", user)
+		return
+	}
+	if c.analyzed[ref.ClassName] {
+		return // checked where it is declared
+	}
+	c.add(CompatMethod, name, CompatSupported, "", user)
+	if m.AccessFlags&AccNative != 0 {
+		ownerName, _ := owner.ClassName()
+		c.native(ownerName, m.Name, m.Descriptor, user)
+	}
+}
+
+// native checks that the VM implements a native method.
+func (c *compatChecker) native(className, methodName, descriptor, user string) {
+	name := className + "." + methodName + ":" + descriptor
+	switch {
+	case c.vm.implementsNative(className, methodName, descriptor):
+		c.add(CompatNative, name, CompatSupported, "", user)
+	case c.vm.NativeFallback != nil:
+		c.add(CompatNative, name, CompatUnchecked, "left to NativeFallback", user)
+	default:
+		c.add(CompatNative, name, CompatUnsupported, "not implemented", user)
+	}
+}
+
+// bootstrap checks the bootstrap method of an invokedynamic call site.
+func (c *compatChecker) bootstrap(cf *classfile.ClassFile, index uint16, user string) {
+	pool := cf.ConstantPool
+	name := "?"
+	if indy, ok := pool[index].(*classfile.ConstantInvokeDynamic); ok && int(indy.BootstrapMethodAttrIndex) < len(cf.BootstrapMethods) {
+		bsm := cf.BootstrapMethods[indy.BootstrapMethodAttrIndex]
+		if mh, ok := pool[bsm.MethodRef].(*classfile.ConstantMethodHandle); ok {
+			ref, err := classfile.ResolveMethodref(pool, mh.ReferenceIndex)
+			if err != nil {
+				ref, err = classfile.ResolveInterfaceMethodref(pool, mh.ReferenceIndex)
+			}
+			if err == nil {
+				name = ref.ClassName + "." + ref.MethodName
+			}
+		}
+	}
+	if builtinBootstraps[name] {
+		c.add(CompatBootstrap, name, CompatSupported, "", user)
+	} else {
+		c.add(CompatBootstrap, name, CompatUnsupported, "not implemented", user)
+	}
+}
+
+// instructionLength returns the length in bytes of the instruction at pc,
+// including its operands.
+func instructionLength(code []byte, pc int) (int, error) {
+	op := code[pc]
+	n := 1
+	switch {
+	case op == OpBipush, op == OpLdc, op >= 0x15 && op <= 0x19, op >= 0x36 && op <= 0x3A, op == OpRet, op == OpNewarray: // loads and stores
+		n = 2
+	case op == OpSipush, op == OpLdcW, op == OpLdc2W, op == OpIinc, op >= 0x99 && op <= OpJsr, // branches, goto and jsr
+		op >= OpGetstatic && op <= OpInvokestatic, op == OpNew, op == OpAnewarray,
+		op == OpCheckcast, op == OpInstanceof, op == OpIfnull, op == OpIfnonnull:
+		n = 3
+	case op == OpMultianewarray:
+		n = 4
+	case op == OpInvokeinterface, op == OpInvokedynamic, op == OpGotoW, op == OpJsrW:
+		n = 5
+	case op == OpWide:
+		n = 4
+		if pc+1 < len(code) && code[pc+1] == OpIinc {
+			n = 6
+		}
+	case op == OpTableswitch, op == OpLookupswitch:
+		base := (pc + 4) &^ 3 // operands are 4-byte aligned
+		header := 12          // default, low and high
+		if op == OpLookupswitch {
+			header = 8 // default and npairs
+		}
+		if base+header > len(code) {
+			return 0, fmt.Errorf("pc %d: truncated %s", pc, OpcodeName(op))
+		}
+		a := int32(binary.BigEndian.Uint32(code[base+4:]))
+		if op == OpTableswitch {
+			b := int32(binary.BigEndian.Uint32(code[base+8:]))
+			if b < a {
+				return 0, fmt.Errorf("pc %d: tableswitch high %d is less than low %d", pc, b, a)
+			}
+			n = base + 12 + 4*int(int64(b)-int64(a)+1) - pc
+		} else {
+			if a < 0 {
+				return 0, fmt.Errorf("pc %d: lookupswitch has negative npairs %d", pc, a)
+			}
+			n = base + 8 + 8*int(a) - pc
+		}
+		if n <= 0 {
+			return 0, fmt.Errorf("pc %d: malformed %s", pc, OpcodeName(op))
+		}
+	}
+	if pc+n > len(code) {
+		return 0, fmt.Errorf("pc %d: truncated %s", pc, OpcodeName(op))
+	}
+	return n, nil
+}
@@ -0,0 +1,226 @@
+package vm
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// switchCases returns the constant case expressions of the switch on tag
+// in the named function of a source file, as string literals or
+// identifiers.
+func switchCases(t *testing.T, file, function, tag string) []ast.Expr {
+	t.Helper()
+	f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cases []ast.Expr
+	for _, d := range f.Decls {
+		fd, ok := d.(*ast.FuncDecl)
+		if !ok || fd.Name.Name != function {
+			continue
+		}
+		ast.Inspect(fd.Body, func(n ast.Node) bool {
+			sw, ok := n.(*ast.SwitchStmt)
+			if !ok {
+				return true
+			}
+			if id, ok := sw.Tag.(*ast.Ident); ok && id.Name == tag {
+				for _, s := range sw.Body.List {
+					cases = append(cases, s.(*ast.CaseClause).List...)
+				}
+			}
+			return true
+		})
+	}
+	if len(cases) == 0 {
+		t.Fatalf("no switch on %s in %s.%s", tag, file, function)
+	}
+	return cases
+}
+
+// stringCases returns the sorted string literal cases of a switch.
+func stringCases(t *testing.T, file, function, tag string) []string {
+	t.Helper()
+	var out []string
+	for _, e := range switchCases(t, file, function, tag) {
+		if lit, ok := e.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			s, _ := strconv.Unquote(lit.Value)
+			out = append(out, s)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func sortedKeys(m map[string]bool) []string {
+	var out []string
+	for k := range m {
+		out = append(out, k)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// The compatibility checker's view of the VM must match its dispatchers.
+func TestCompatTablesMatchDispatchers(t *testing.T) {
+	for _, tt := range []struct {
+		file, function, tag string
+		table               map[string]bool
+	}{
+		{"unsafe.go", "handleUnsafe", "methodName", builtinUnsafeNatives},
+		{"vm.go", "executeInvokedynamic", "bsmKey", builtinBootstraps},
+	} {
+		got, want := sortedKeys(tt.table), stringCases(t, tt.file, tt.function, tt.tag)
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("table for %s out of sync:\ngot  %v\nwant %v", tt.function, got, want)
+		}
+	}
+
+	// Opcode constants are resolved from their declarations.
+	f, err := parser.ParseFile(token.NewFileSet(), "instructions.go", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]byte)
+	for _, d := range f.Decls {
+		if gd, ok := d.(*ast.GenDecl); ok && gd.Tok == token.CONST {
+			for _, spec := range gd.Specs {
+				vs := spec.(*ast.ValueSpec)
+				if lit, ok := vs.Values[0].(*ast.BasicLit); ok && len(vs.Values) == 1 {
+					v, _ := constant.Uint64Val(constant.MakeFromLiteral(lit.Value, lit.Kind, 0))
+					values[vs.Names[0].Name] = byte(v)
+				}
+			}
+		}
+	}
+	implemented := make(map[byte]bool)
+	for _, e := range switchCases(t, "instructions.go", "executeInstruction", "opcode") {
+		if id, ok := e.(*ast.Ident); ok {
+			implemented[values[id.Name]] = true
+		}
+	}
+	for op := 0; op < 256; op++ {
+		if opcodeNames[op] != "" && implemented[byte(op)] == unimplementedOpcodes[byte(op)] {
+			t.Errorf("%s: implemented=%v but unimplementedOpcodes=%v", OpcodeName(byte(op)), implemented[byte(op)], unimplementedOpcodes[byte(op)])
+		}
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	lib := classfile.NewBuilder("Lib", "java/lang/Object")
+	lib.AddMethod(classfile.AccStatic|AccNative, "probe", "()I", nil)
+	lib.AddMethod(classfile.AccStatic, "helper", "()V", &classfile.CodeAttribute{Code: []byte{0xb1}})
+
+	b := classfile.NewBuilder("App", "java/lang/Object")
+	probe := b.Methodref("Lib", "probe", "()I")
+	helper := b.Methodref("Lib", "helper", "()V")
+	missing := b.Methodref("Lib", "gone", "()V")
+	own := b.Methodref("App", "sub", "()V")
+	b.AddMethod(classfile.AccStatic, "main", "()V", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code: []byte{
+			0xb8, byte(probe >> 8), byte(probe), 0x57, // invokestatic Lib.probe; pop
+			0xb8, byte(helper >> 8), byte(helper), // invokestatic Lib.helper
+			0xb8, byte(own >> 8), byte(own), // invokestatic App.sub
+			0x03, 0xaa, // iconst_0; tableswitch at pc 11, operands aligned at 12
+			0, 0, 0, 17, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 17, // default and case 0 jump to return
+			0xb1,                                          // return
+			0xb8, byte(missing >> 8), byte(missing), 0xb1, // invokestatic Lib.gone; return
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "sub", "()V", &classfile.CodeAttribute{
+		Code: []byte{0xcb, 0xb1}, // undefined opcode; return
+	})
+	b.AddMethod(classfile.AccStatic|AccNative, "registerNatives", "()V", nil)
+	cf := b.Build()
+
+	v := NewVM(mapClassLoader{"App": cf, "Lib": lib.Build()})
+	report, err := v.CheckCompatibility(cf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := make(map[string]CompatStatus)
+	for _, f := range report.Findings {
+		status[f.Kind+" "+f.Name] = f.Status
+	}
+	want := map[string]CompatStatus{
+		"opcode tableswitch":             CompatSupported,
+		"opcode 0xCB":                    CompatUnsupported,
+		"method Lib.probe:()I":           CompatSupported,
+		"method Lib.helper:()V":          CompatSupported,
+		"method Lib.gone:()V":            CompatUnsupported,
+		"native Lib.probe:()I":           CompatUnsupported,
+		"native App.registerNatives:()V": CompatSupported,
+	}
+	for name, st := range want {
+		if got, ok := status[name]; !ok || got != st {
+			t.Errorf("%s: got %v (present %v), want %v", name, got, ok, st)
+		}
+	}
+	if _, ok := status["method App.sub:()V"]; ok {
+		t.Error("calls within the analyzed classes should not be listed")
+	}
+	if report.Compatible() {
+		t.Error("report should fail")
+	}
+	var out strings.Builder
+	if err := report.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); !strings.Contains(s, "used by App.sub:()V") || !strings.HasSuffix(s, "verdict: FAIL\n") {
+		t.Errorf("report:\n%s", s)
+	}
+
+	// Fallbacks may handle what the VM lacks, so the checker cannot tell.
+	v.NativeFallback = ReturnDefault
+	report, _ = v.CheckCompatibility(cf)
+	for _, f := range report.Findings {
+		if f.Name == "Lib.probe:()I" && f.Kind == CompatNative && f.Status != CompatUnchecked {
+			t.Errorf("native with fallback: got %v", f.Status)
+		}
+	}
+
+	// So may natives registered with the VM, which the checker can.
+	v.NativeFallback = nil
+	v.RegisterNative("Lib", "probe", "()I", nativeFalse)
+	report, _ = v.CheckCompatibility(cf)
+	for _, f := range report.Findings {
+		if f.Name == "Lib.probe:()I" && f.Kind == CompatNative && f.Status != CompatSupported {
+			t.Errorf("registered native: got %v", f.Status)
+		}
+	}
+}
+
+func TestInstructionLength(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		pc   int
+		want int
+	}{
+		{"nop", []byte{0x00}, 0, 1},
+		{"iload", []byte{0x15, 4}, 0, 2},
+		{"invokeinterface", []byte{0xb9, 0, 1, 1, 0}, 0, 5},
+		{"wide iload", []byte{0xc4, 0x15, 1, 0}, 0, 4},
+		{"wide iinc", []byte{0xc4, 0x84, 1, 0, 0, 5}, 0, 6},
+		{"lookupswitch", []byte{0x00, 0xab, 0, 0, 0, 0, 0, 9, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 5}, 1, 19},
+		{"empty lookupswitch", []byte{0xab, 0, 0, 0, 0, 0, 0, 8, 0, 0, 0, 0}, 0, 12},
+	}
+	for _, tt := range tests {
+		if got, err := instructionLength(tt.code, tt.pc); err != nil || got != tt.want {
+			t.Errorf("%s: got %d, %v, want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := instructionLength([]byte{0xb8, 0}, 0); err == nil {
+		t.Error("truncated invokestatic: expected an error")
+	}
+}
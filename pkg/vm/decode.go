@@ -0,0 +1,294 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Methods are decoded once, before they first run, so that the operands of
+// loads, stores, constants, branches and switches are not re-read and
+// switch padding is not re-aligned on every execution. Other instructions
+// run from the raw bytecode. Decoding also verifies that every instruction
+// is complete, that it only uses locals below max_locals, that lookupswitch
+// keys are sorted, which lets them be binary searched, and that branches,
+// switches and exception handlers only lead to the start of an
+// instruction, so that corrupt code fails with a VerifyError before it runs
+// rather than jumping into operand bytes or past the frame's locals.
+
+// maxCodeLength is the largest code_length of a method. Offsets within a
+// method always fit in 16 bits, but jumps across more than 32767 bytes
+// need goto_w, or a conditional branch over one, and switches.
+const maxCodeLength = 65535
+
+// instruction is a decoded bytecode instruction. PCs remain byte offsets,
+// which exception tables, line numbers and jsr/ret refer to.
+type instruction struct {
+	op      byte
+	next    int     // offset of the following instruction
+	index   int     // local variable index; wide forms are decoded to the widened opcode
+	value   int32   // bipush and sipush operand, iinc increment
+	target  int     // branch target, or the default target of a switch
+	low     int32   // smallest key of a tableswitch
+	keys    []int32 // lookupswitch keys, in increasing order
+	targets []int   // switch targets, by key
+}
+
+// decodedCode is the decoded form of a method's bytecode.
+type decodedCode struct {
+	at  []*instruction // by PC; nil where no decoded instruction starts
+	err error          // why the code failed verification, if it did
+}
+
+// decodeCode decodes and verifies code.
+func decodeCode(code *classfile.CodeAttribute) *decodedCode {
+	d := &decodedCode{at: make([]*instruction, len(code.Code))}
+	if len(code.Code) == 0 || len(code.Code) > maxCodeLength {
+		d.err = fmt.Errorf("invalid code length %d", len(code.Code))
+		return d
+	}
+	starts := make([]bool, len(code.Code)+1) // the end is a valid exclusive bound
+	starts[len(code.Code)] = true
+	for pc := 0; pc < len(code.Code); {
+		n, err := instructionLength(code.Code, pc)
+		if err != nil {
+			d.err = err
+			return d
+		}
+		starts[pc] = true
+		if index, width, ok := localVariable(code.Code, pc); ok && index+width > int(code.MaxLocals) {
+			d.err = fmt.Errorf("pc %d: illegal local variable number %d", pc, index)
+			return d
+		}
+		d.at[pc] = decodeInstruction(code.Code, pc, pc+n)
+		if ins := d.at[pc]; ins != nil && ins.op == OpLookupswitch && !increasing(ins.keys) {
+			d.err = fmt.Errorf("pc %d: lookupswitch keys are not sorted", pc)
+			return d
+		}
+		pc += n
+	}
+
+	for pc, ins := range d.at {
+		for _, target := range ins.jumpTargets() {
+			if target < 0 || target >= len(code.Code) || !starts[target] {
+				d.err = fmt.Errorf("pc %d: illegal target of jump or branch %d", pc, target)
+				return d
+			}
+		}
+	}
+	for _, h := range code.ExceptionHandlers {
+		start, end, handler := int(h.StartPC), int(h.EndPC), int(h.HandlerPC)
+		if start >= end || end > len(code.Code) || handler >= len(code.Code) || !starts[start] || !starts[end] || !starts[handler] {
+			d.err = fmt.Errorf("illegal exception handler [%d, %d) -> %d", start, end, handler)
+			return d
+		}
+	}
+	return d
+}
+
+// decodeInstruction decodes the instruction at pc, which ends at next, or
+// returns nil for instructions that run undecoded.
+func decodeInstruction(code []byte, pc, next int) *instruction {
+	op := code[pc]
+	ins := &instruction{op: op, next: next}
+	u16 := func(at int) int { return int(binary.BigEndian.Uint16(code[at:])) }
+	i32 := func(at int) int32 { return int32(binary.BigEndian.Uint32(code[at:])) }
+	switch {
+	case op == OpBipush:
+		ins.value = int32(int8(code[pc+1]))
+	case op == OpSipush:
+		ins.value = int32(int16(u16(pc + 1)))
+	case op >= OpIload && op <= OpAload, op >= OpIstore && op <= OpAstore:
+		ins.index = int(code[pc+1])
+	case op == OpIinc:
+		ins.index = int(code[pc+1])
+		ins.value = int32(int8(code[pc+2]))
+	case op == OpWide:
+		ins.op = code[pc+1]
+		ins.index = u16(pc + 2)
+		switch {
+		case ins.op == OpIinc:
+			ins.value = int32(int16(u16(pc + 4)))
+		case ins.op >= OpIload && ins.op <= OpAload, ins.op >= OpIstore && ins.op <= OpAstore:
+		default:
+			return nil // wide ret, or malformed
+		}
+	case op >= OpIfeq && op <= OpJsr, op == OpIfnull, op == OpIfnonnull:
+		ins.target = pc + int(int16(u16(pc+1)))
+	case op == OpGotoW, op == OpJsrW:
+		ins.target = pc + int(i32(pc+1))
+	case op == OpTableswitch:
+		base := (pc + 4) &^ 3
+		ins.target = pc + int(i32(base))
+		ins.low = i32(base + 4)
+		for at := base + 12; at < next; at += 4 {
+			ins.targets = append(ins.targets, pc+int(i32(at)))
+		}
+	case op == OpLookupswitch:
+		base := (pc + 4) &^ 3
+		ins.target = pc + int(i32(base))
+		for at := base + 8; at < next; at += 8 {
+			ins.keys = append(ins.keys, i32(at))
+			ins.targets = append(ins.targets, pc+int(i32(at+4)))
+		}
+	default:
+		return nil
+	}
+	return ins
+}
+
+// increasing reports whether keys are in strictly increasing order.
+func increasing(keys []int32) bool {
+	for i := 1; i < len(keys); i++ {
+		if keys[i-1] >= keys[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// localVariable returns the local variable index that the complete
+// instruction at pc loads, stores, increments or returns through, and the
+// number of slots it spans, which is 2 for longs and doubles.
+func localVariable(code []byte, pc int) (index, width int, ok bool) {
+	op := code[pc]
+	if op == OpWide {
+		op, index = code[pc+1], int(binary.BigEndian.Uint16(code[pc+2:]))
+	} else if pc+1 < len(code) {
+		index = int(code[pc+1])
+	}
+	// The order of types in each group is int, long, float, double, reference.
+	typed := func(t byte) (int, int, bool) {
+		if t == 1 || t == 3 {
+			return index, 2, true
+		}
+		return index, 1, true
+	}
+	switch {
+	case op >= OpIload && op <= OpAload:
+		return typed(op - OpIload)
+	case op >= OpIstore && op <= OpAstore:
+		return typed(op - OpIstore)
+	case op >= OpIload0 && op <= OpAload3:
+		index = int(op-OpIload0) % 4
+		return typed((op - OpIload0) / 4)
+	case op >= OpIstore0 && op <= OpAstore3:
+		index = int(op-OpIstore0) % 4
+		return typed((op - OpIstore0) / 4)
+	case op == OpIinc, op == OpRet:
+		return index, 1, true
+	}
+	return 0, 0, false
+}
+
+// decodeCache maps the code of methods that have run to its decoded form.
+type decodeCache map[*classfile.CodeAttribute]*decodedCode
+
+// decodedCode returns the decoded form of code, decoding it on first use.
+func (vm *VM) decodedCode(code *classfile.CodeAttribute) *decodedCode {
+	if d, ok := vm.decoded[code]; ok {
+		return d
+	}
+	if vm.decoded == nil {
+		vm.decoded = make(decodeCache)
+	}
+	d := decodeCode(code)
+	vm.decoded[code] = d
+	return d
+}
+
+// executeDecoded executes a decoded instruction whose opcode is at
+// frame.PC-1 and leaves frame.PC at the next instruction to run.
+func (vm *VM) executeDecoded(frame *Frame, ins *instruction) {
+	frame.PC = ins.next
+	switch op := ins.op; {
+	case op == OpBipush, op == OpSipush:
+		frame.Push(IntValue(ins.value))
+	case op >= OpIload && op <= OpAload:
+		frame.Push(frame.GetLocal(ins.index))
+	case op >= OpIstore && op <= OpAstore:
+		frame.SetLocal(ins.index, frame.Pop())
+	case op == OpIinc:
+		frame.SetLocal(ins.index, IntValue(frame.GetLocal(ins.index).Int+ins.value))
+	case op >= OpIfeq && op <= OpIfle:
+		if intCondition(op-OpIfeq, frame.Pop().Int, 0) {
+			frame.PC = ins.target
+		}
+	case op >= OpIfIcmpeq && op <= OpIfIcmple:
+		v2 := frame.Pop().Int
+		if intCondition(op-OpIfIcmpeq, frame.Pop().Int, v2) {
+			frame.PC = ins.target
+		}
+	case op == OpIfAcmpeq, op == OpIfAcmpne:
+		v2 := frame.Pop()
+		if sameReference(frame.Pop(), v2) == (op == OpIfAcmpeq) {
+			frame.PC = ins.target
+		}
+	case op == OpIfnull, op == OpIfnonnull:
+		if isNullReference(frame.Pop()) == (op == OpIfnull) {
+			frame.PC = ins.target
+		}
+	case op == OpGoto, op == OpGotoW:
+		frame.PC = ins.target
+	case op == OpJsr, op == OpJsrW:
+		frame.Push(ReturnAddressValue(ins.next))
+		frame.PC = ins.target
+	case op == OpTableswitch:
+		frame.PC = ins.target
+		if i := int64(frame.Pop().Int) - int64(ins.low); i >= 0 && i < int64(len(ins.targets)) {
+			frame.PC = ins.targets[i]
+		}
+	case op == OpLookupswitch:
+		frame.PC = ins.target
+		if i, found := slices.BinarySearch(ins.keys, frame.Pop().Int); found {
+			frame.PC = ins.targets[i]
+		}
+	}
+}
+
+// jumpTargets returns the PCs that ins may transfer control to other than
+// the next instruction.
+func (ins *instruction) jumpTargets() []int {
+	if ins == nil {
+		return nil
+	}
+	switch op := ins.op; {
+	case op >= OpIfeq && op <= OpJsr, op == OpIfnull, op == OpIfnonnull, op == OpGotoW, op == OpJsrW:
+		return []int{ins.target}
+	case op == OpTableswitch, op == OpLookupswitch:
+		return append([]int{ins.target}, ins.targets...)
+	}
+	return nil
+}
+
+// intCondition evaluates the condition of an if<cond> or if_icmp<cond>
+// branch, given its offset from ifeq or if_icmpeq, which list the
+// conditions in the same order.
+func intCondition(cond byte, v1, v2 int32) bool {
+	switch cond {
+	case 0:
+		return v1 == v2
+	case 1:
+		return v1 != v2
+	case 2:
+		return v1 < v2
+	case 3:
+		return v1 >= v2
+	case 4:
+		return v1 > v2
+	}
+	return v1 <= v2
+}
+
+// sameReference reports whether two references are identical, as
+// if_acmpeq decides.
+func sameReference(v1, v2 Value) bool {
+	return (v1.Type == TypeNull && v2.Type == TypeNull) || (v1.Type == v2.Type && v1.Ref == v2.Ref)
+}
+
+// isNullReference reports whether v is null, as ifnull decides.
+func isNullReference(v Value) bool {
+	return v.Type == TypeNull || (v.Type == TypeRef && v.Ref == nil)
+}
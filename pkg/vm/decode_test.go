@@ -0,0 +1,376 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestDecodeCode(t *testing.T) {
+	code := []byte{
+		OpSipush, 0xff, 0xfe, // 0: sipush -2
+		OpWide, OpIinc, 0x01, 0x00, 0x80, 0x00, // 3: wide iinc 256 -32768
+		OpIload, 7, // 9: iload 7
+		OpTableswitch,                                                 // 11: tableswitch, operands aligned at 12
+		0, 0, 0, 21, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 24, 0, 0, 0, 21, // default 32, 1 -> 35, 2 -> 32
+		OpInvokestatic, 0, 1, // 32: runs undecoded
+		OpGoto, 0xff, 0xfd, // 35: goto 32
+	}
+	d := decodeCode(&classfile.CodeAttribute{MaxLocals: 257, Code: code})
+	if d.err != nil {
+		t.Fatal(d.err)
+	}
+	if ins := d.at[0]; ins == nil || ins.op != OpSipush || ins.value != -2 || ins.next != 3 {
+		t.Errorf("sipush: got %+v", ins)
+	}
+	if ins := d.at[3]; ins == nil || ins.op != OpIinc || ins.index != 256 || ins.value != -32768 || ins.next != 9 {
+		t.Errorf("wide iinc: got %+v", ins)
+	}
+	if ins := d.at[9]; ins == nil || ins.op != OpIload || ins.index != 7 {
+		t.Errorf("iload: got %+v", ins)
+	}
+	if ins := d.at[11]; ins == nil || ins.target != 32 || ins.low != 1 || len(ins.targets) != 2 || ins.targets[0] != 35 || ins.targets[1] != 32 || ins.next != 32 {
+		t.Errorf("tableswitch: got %+v", ins)
+	}
+	if d.at[32] != nil || d.at[4] != nil {
+		t.Error("invokestatic and operand bytes should have no decoded instruction")
+	}
+	if ins := d.at[35]; ins == nil || ins.target != 32 {
+		t.Errorf("goto: got %+v", ins)
+	}
+}
+
+func TestVerifyJumpTargets(t *testing.T) {
+	tests := []struct {
+		name     string
+		code     []byte
+		handlers []classfile.ExceptionHandler
+		want     string // in the VerifyError message, or "" if valid
+	}{
+		{"valid loop", []byte{OpGoto, 0, 3, OpReturn}, nil, ""},
+		{"into operand bytes", []byte{OpSipush, 0, 1, OpGoto, 0xff, 0xfe, OpReturn}, nil, "pc 3: illegal target of jump or branch 1"},
+		{"before the code", []byte{OpGoto, 0xff, 0xff}, nil, "pc 0: illegal target of jump or branch -1"},
+		{"past the end", []byte{OpIconst0, OpIfeq, 0, 3}, nil, "pc 1: illegal target of jump or branch 4"},
+		{"goto_w", []byte{OpGotoW, 0x7f, 0xff, 0xff, 0xff}, nil, "illegal target"},
+		{"jsr", []byte{OpJsr, 0, 2, OpReturn}, nil, "pc 0: illegal target of jump or branch 2"},
+		{"tableswitch case", []byte{
+			OpIconst0, OpTableswitch, 0, 0, 0, 0, 0, 19, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, OpReturn,
+		}, nil, "pc 1: illegal target of jump or branch 3"},
+		{"lookupswitch default", []byte{
+			OpIconst0, OpLookupswitch, 0, 0, 0, 0, 0, 100, 0, 0, 0, 0, OpReturn,
+		}, nil, "pc 1: illegal target of jump or branch 101"},
+		{"lookupswitch keys out of order", []byte{
+			OpIconst0, OpLookupswitch, 0, 0, 0, 0, 0, 27, 0, 0, 0, 2,
+			0, 0, 0, 5, 0, 0, 0, 27, 0, 0, 0, 3, 0, 0, 0, 27, OpReturn,
+		}, nil, "pc 1: lookupswitch keys are not sorted"},
+		{"lookupswitch duplicate keys", []byte{
+			OpIconst0, OpLookupswitch, 0, 0, 0, 0, 0, 27, 0, 0, 0, 2,
+			0, 0, 0, 5, 0, 0, 0, 27, 0, 0, 0, 5, 0, 0, 0, 27, OpReturn,
+		}, nil, "pc 1: lookupswitch keys are not sorted"},
+		{"truncated", []byte{OpNop, OpGoto, 0}, nil, "pc 1: truncated goto"},
+		{"handler in operand bytes", []byte{OpSipush, 0, 1, OpPop, OpReturn},
+			[]classfile.ExceptionHandler{{StartPC: 0, EndPC: 4, HandlerPC: 2}}, "illegal exception handler [0, 4) -> 2"},
+		{"empty handler range", []byte{OpReturn},
+			[]classfile.ExceptionHandler{{StartPC: 0, EndPC: 0, HandlerPC: 0}}, "illegal exception handler"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := classfile.NewBuilder("Bad", "java/lang/Object")
+			b.AddMethod(classfile.AccStatic, "m", "()V", &classfile.CodeAttribute{MaxStack: 1, Code: tt.code, ExceptionHandlers: tt.handlers})
+			cf := b.Build()
+			v := NewVM(mapClassLoader{"Bad": cf})
+			_, err := v.executeMethod(cf, cf.FindMethodByName("m"), nil)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("valid code: %v", err)
+				}
+				return
+			}
+			if !isJavaException(err, "java/lang/VerifyError") {
+				t.Fatalf("got %v, want VerifyError", err)
+			}
+			msg, _ := err.(*JavaException).Object.Fields["detailMessage"].Ref.(string)
+			if !strings.HasPrefix(msg, "Bad.m()V: ") || !strings.Contains(msg, tt.want) {
+				t.Errorf("message: got %q, want %q", msg, tt.want)
+			}
+		})
+	}
+}
+
+// Methods generated with many locals address them with wide, on both the
+// decoded path and in verification.
+func TestManyLocals(t *testing.T) {
+	code := []byte{
+		OpIload0,
+		OpWide, OpIstore, 0x01, 0x2b, // wide istore 299
+		OpWide, OpIinc, 0x01, 0x2b, 0x80, 0x00, // wide iinc 299 -32768
+		OpWide, OpIinc, 0x01, 0x2b, 0x7f, 0xff, // wide iinc 299 32767
+		OpWide, OpIload, 0x01, 0x2b, // wide iload 299
+		OpI2l,
+		OpWide, OpLstore, 0x01, 0x29, // wide lstore 297
+		OpWide, OpLload, 0x01, 0x29, // wide lload 297
+		OpL2i, OpIreturn,
+	}
+	run := func(maxLocals uint16) (Value, error) {
+		b := classfile.NewBuilder("Locals", "java/lang/Object")
+		b.AddMethod(classfile.AccStatic, "m", "(I)I", &classfile.CodeAttribute{MaxStack: 2, MaxLocals: maxLocals, Code: code})
+		cf := b.Build()
+		v := NewVM(mapClassLoader{"Locals": cf})
+		return v.executeMethod(cf, cf.FindMethodByName("m"), []Value{IntValue(42)})
+	}
+
+	got, err := run(300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != 41 {
+		t.Errorf("got %d, want 41", got.Int)
+	}
+
+	_, err = run(299)
+	if !isJavaException(err, "java/lang/VerifyError") {
+		t.Fatalf("max_locals 299: got %v, want VerifyError", err)
+	}
+	if msg, _ := err.(*JavaException).Object.Fields["detailMessage"].Ref.(string); !strings.Contains(msg, "pc 1: illegal local variable number 299") {
+		t.Errorf("message: got %q", msg)
+	}
+
+	// A long in local 1 spans locals 1 and 2.
+	long := []byte{OpLconst1, OpLstore1, OpReturn}
+	if d := decodeCode(&classfile.CodeAttribute{MaxLocals: 2, Code: long}); d.err == nil {
+		t.Error("lstore_1 with max_locals 2: expected an error")
+	}
+	if d := decodeCode(&classfile.CodeAttribute{MaxLocals: 3, Code: long}); d.err != nil {
+		t.Errorf("lstore_1 with max_locals 3: %v", d.err)
+	}
+}
+
+// largeMethod builds a method of almost 64KB whose switches and branches
+// reach across more than 32767 bytes of padding in both directions. It
+// returns 10, 11, 12 and 13 for 0 to 3, -1 for 4 and 12 otherwise.
+func largeMethod() (*classfile.ClassFile, *classfile.MethodInfo) {
+	const far0 = 65000
+	far1, far2 := far0+3, far0+6
+	far2End := far2 + 20
+	code := make([]byte, far2End+3) // nop padding
+	put32 := func(at, v int) { binary.BigEndian.PutUint32(code[at:], uint32(int32(v))) }
+
+	code[0] = OpIload0
+	code[1] = OpTableswitch // operands aligned at 4, ending at 24
+	put32(4, 24-1)
+	put32(8, 0)
+	put32(12, 1)
+	put32(16, far0-1)
+	put32(20, far1-1)
+	copy(code[24:], []byte{
+		OpIload0, OpIconst4, OpIfIcmpeq, 0, 8, // 24: if n == 4 goto 34
+		OpGotoW, 0, 0, 0, 0, // 29: goto_w far2
+		OpIconstM1, OpIreturn, // 34: return -1
+		OpBipush, 13, OpIreturn, // 36: return 13
+	})
+	put32(30, far2-29)
+	copy(code[far0:], []byte{OpBipush, 10, OpIreturn})
+	copy(code[far1:], []byte{OpBipush, 11, OpIreturn})
+	code[far2] = OpIload0
+	code[far2+1] = OpLookupswitch // default and one pair, aligned
+	base := (far2 + 5) &^ 3
+	put32(base, far2End-(far2+1))
+	put32(base+4, 1)
+	put32(base+8, 3)
+	put32(base+12, 36-(far2+1))
+	copy(code[far2End:], []byte{OpBipush, 12, OpIreturn})
+
+	b := classfile.NewBuilder("Large", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "m", "(I)I", &classfile.CodeAttribute{MaxStack: 2, MaxLocals: 1, Code: code})
+	cf := b.Build()
+	return cf, cf.FindMethodByName("m")
+}
+
+func TestLargeMethod(t *testing.T) {
+	cf, method := largeMethod()
+	data, err := cf.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cf, err = classfile.Parse(bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	method = cf.FindMethodByName("m")
+
+	want := []int32{10, 11, 12, 13, -1, 12}
+	for _, decoded := range []bool{true, false} {
+		v := NewVM(mapClassLoader{"Large": cf})
+		if !decoded {
+			v.decoded = decodeCache{method.Code: nil}
+		}
+		for n, w := range want {
+			got, err := v.executeMethod(cf, method, []Value{IntValue(int32(n))})
+			if err != nil {
+				t.Fatalf("decoded=%v, m(%d): %v", decoded, n, err)
+			}
+			if got.Int != w {
+				t.Errorf("decoded=%v, m(%d): got %d, want %d", decoded, n, got.Int, w)
+			}
+		}
+	}
+
+	// 65536 bytes of code cannot be addressed by exception tables.
+	d := decodeCode(&classfile.CodeAttribute{Code: make([]byte, maxCodeLength+1)})
+	if d.err == nil || !strings.Contains(d.err.Error(), "invalid code length 65536") {
+		t.Errorf("64KB of code: got %v", d.err)
+	}
+}
+
+// Lookupswitch keys are binary searched on the decoded path; both paths
+// must find every key, including the extremes, and miss between them.
+func TestLookupswitch(t *testing.T) {
+	keys := []int32{math.MinInt32, -5, 0, 3, 1000, math.MaxInt32}
+	end := 12 + 8*len(keys)                        // cases start after the pairs
+	code := []byte{OpIload0, OpLookupswitch, 0, 0} // operands aligned at 4
+	put32 := func(v int) { code = binary.BigEndian.AppendUint32(code, uint32(v)) }
+	put32(end + 3*len(keys) - 1) // default
+	put32(len(keys))
+	for i, k := range keys {
+		put32(int(k))
+		put32(end + 3*i - 1)
+	}
+	for i := range keys {
+		code = append(code, OpBipush, byte(i), OpIreturn) // case i: return i
+	}
+	code = append(code, OpIconstM1, OpIreturn) // default: return -1
+
+	b := classfile.NewBuilder("Switch", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "m", "(I)I", &classfile.CodeAttribute{MaxStack: 1, MaxLocals: 1, Code: code})
+	cf := b.Build()
+	method := cf.FindMethodByName("m")
+	for _, decoded := range []bool{true, false} {
+		v := NewVM(mapClassLoader{"Switch": cf})
+		if !decoded {
+			v.decoded = decodeCache{method.Code: nil}
+		}
+		check := func(key, want int32) {
+			got, err := v.executeMethod(cf, method, []Value{IntValue(key)})
+			if err != nil {
+				t.Fatalf("decoded=%v, m(%d): %v", decoded, key, err)
+			}
+			if got.Int != want {
+				t.Errorf("decoded=%v, m(%d): got %d, want %d", decoded, key, got.Int, want)
+			}
+		}
+		for i, k := range keys {
+			check(k, int32(i))
+		}
+		for _, miss := range []int32{math.MinInt32 + 1, -6, -1, 1, 4, 999, math.MaxInt32 - 1} {
+			check(miss, -1)
+		}
+	}
+}
+
+func TestMalformedSwitches(t *testing.T) {
+	// Each switch is at pc 0 with its operands at 4, and is followed by a
+	// return its default targets.
+	for _, tt := range []struct {
+		name string
+		code []byte
+		want string
+	}{
+		{"tableswitch high < low", []byte{OpTableswitch, 0, 0, 0, 0, 0, 0, 16, 0, 0, 0, 1, 0, 0, 0, 0, OpReturn},
+			"pc 0: tableswitch high 0 is less than low 1"},
+		{"lookupswitch npairs < 0", []byte{OpLookupswitch, 0, 0, 0, 0, 0, 0, 12, 0xff, 0xff, 0xff, 0xff, OpReturn},
+			"pc 0: lookupswitch has negative npairs -1"},
+	} {
+		d := decodeCode(&classfile.CodeAttribute{MaxStack: 1, MaxLocals: 1, Code: tt.code})
+		if d.err == nil || d.err.Error() != tt.want {
+			t.Errorf("%s: got %v, want %q", tt.name, d.err, tt.want)
+		}
+	}
+}
+
+// switchLoop builds a method that sums the results of a tableswitch and a
+// lookupswitch over i = 0..n-1, exercising decoded branches and locals.
+func switchLoop() (*classfile.ClassFile, *classfile.MethodInfo) {
+	b := classfile.NewBuilder("Loop", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "run", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 3,
+		Code: []byte{
+			0x03, 0x3c, 0x03, 0x3d, // 0: i = 0; sum = 0
+			0x1b, 0x1a, 0xa2, 0x00, 0x47, // 4: if i >= n goto 77
+			0x1b, 0x06, 0x70, // 9: i % 3
+			0xaa, 0, 0, 0, // 12: tableswitch, operands aligned at 16
+			0, 0, 0, 36, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 24, 0, 0, 0, 30, // default 48, 0 -> 36, 1 -> 42
+			0x84, 0x02, 0x01, 0xa7, 0x00, 0x09, // 36: sum += 1; goto 48
+			0x84, 0x02, 0x0a, 0xa7, 0x00, 0x03, // 42: sum += 10; goto 48
+			0x1b, 0xab, 0, 0, // 48: iload_1; lookupswitch, operands aligned at 52
+			0, 0, 0, 22, 0, 0, 0, 1, 0, 0, 0, 7, 0, 0, 0, 19, // default 71, 7 -> 68
+			0x84, 0x02, 100, // 68: sum += 100
+			0x84, 0x01, 0x01, 0xa7, 0xff, 0xba, // 71: i++; goto 4
+			0x1c, 0xac, // 77: return sum
+		},
+	})
+	cf := b.Build()
+	return cf, cf.FindMethodByName("run")
+}
+
+func TestDecodedExecutionMatchesRaw(t *testing.T) {
+	cf, method := switchLoop()
+	want := int32(0)
+	for i := 0; i < 50; i++ {
+		switch i % 3 {
+		case 0:
+			want++
+		case 1:
+			want += 10
+		}
+		if i == 7 {
+			want += 100
+		}
+	}
+
+	for _, decoded := range []bool{true, false} {
+		v := NewVM(mapClassLoader{"Loop": cf})
+		v.Stdout = io.Discard
+		if !decoded {
+			v.decoded = decodeCache{method.Code: nil}
+		}
+		got, err := v.executeMethod(cf, method, []Value{IntValue(50)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Int != want {
+			t.Errorf("decoded=%v: got %d, want %d", decoded, got.Int, want)
+		}
+		if decoded && v.decoded[method.Code] == nil {
+			t.Error("method was not decoded")
+		}
+	}
+}
+
+func BenchmarkSwitchLoop(b *testing.B) {
+	cf, method := switchLoop()
+	for _, decoded := range []bool{true, false} {
+		name := "raw"
+		if decoded {
+			name = "decoded"
+		}
+		b.Run(name, func(b *testing.B) {
+			v := NewVM(mapClassLoader{"Loop": cf})
+			if !decoded {
+				v.decoded = decodeCache{method.Code: nil}
+			}
+			for i := 0; i < b.N; i++ {
+				if _, err := v.executeMethod(cf, method, []Value{IntValue(1000)}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// UnknownOpcodeError is returned when execution reaches an opcode that
+// neither the interpreter nor an OpcodeFallback implements. Once it leaves
+// the method it occurred in, it also describes the method, the operand
+// stack depth and the disassembled code around the opcode, which is
+// usually enough to tell what feature the code needs.
+type UnknownOpcodeError struct {
+	Opcode     byte
+	PC         int
+	Method     string // "pkg/Class.name(descriptor)", once known
+	StackDepth int    // operand stack entries when the opcode was reached
+	Context    string // disassembly around PC, marked with "=>"
+}
+
+func (e *UnknownOpcodeError) Error() string {
+	msg := fmt.Sprintf("unknown opcode: 0x%02X at PC=%d", e.Opcode, e.PC)
+	if e.Method != "" {
+		msg += fmt.Sprintf(" in %s, stack depth %d\n%s", e.Method, e.StackDepth, e.Context)
+	}
+	return msg
+}
+
+// disassemblyWindow is the number of instructions shown before and after
+// the one that failed.
+const disassemblyWindow = 4
+
+// disassembleAround disassembles the instructions of code within window
+// instructions of the one at pc, one per line, marking pc with "=>".
+func disassembleAround(code []byte, pc int, pool []classfile.ConstantPoolEntry, window int) string {
+	var starts []int
+	at := -1
+	for p := 0; p < len(code); {
+		if p == pc {
+			at = len(starts)
+		}
+		starts = append(starts, p)
+		n, err := instructionLength(code, p)
+		if err != nil {
+			break
+		}
+		if p < pc && p+n > pc { // pc is inside this instruction's operands
+			at = len(starts) - 1
+		}
+		p += n
+	}
+	if at < 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i := max(0, at-window); i <= at+window && i < len(starts); i++ {
+		marker := "  "
+		if i == at {
+			marker = "=>"
+		}
+		fmt.Fprintf(&b, "\t%s %5d: %s\n", marker, starts[i], disassemble(code, starts[i], pool))
+	}
+	return b.String()
+}
+
+// disassemble formats the instruction at pc in the style of javap -c,
+// with branch targets as absolute pcs and constants named after "//".
+func disassemble(code []byte, pc int, pool []classfile.ConstantPoolEntry) string {
+	op := code[pc]
+	name := OpcodeName(op)
+	n, err := instructionLength(code, pc)
+	if err != nil {
+		return name + " <truncated>"
+	}
+	u16 := func(at int) int { return int(binary.BigEndian.Uint16(code[at:])) }
+	i32 := func(at int) int { return int(int32(binary.BigEndian.Uint32(code[at:]))) }
+	switch {
+	case op == OpBipush:
+		return fmt.Sprintf("%s %d", name, int8(code[pc+1]))
+	case op == OpSipush:
+		return fmt.Sprintf("%s %d", name, int16(u16(pc+1)))
+	case op == OpLdc:
+		return fmt.Sprintf("%s #%d%s", name, code[pc+1], constantComment(pool, uint16(code[pc+1])))
+	case op == OpNewarray, op >= OpIload && op <= OpAload, op >= OpIstore && op <= OpAstore, op == OpRet:
+		return fmt.Sprintf("%s %d", name, code[pc+1])
+	case op == OpIinc:
+		return fmt.Sprintf("%s %d, %d", name, code[pc+1], int8(code[pc+2]))
+	case op == OpWide:
+		if code[pc+1] == OpIinc {
+			return fmt.Sprintf("wide iinc %d, %d", u16(pc+2), int16(u16(pc+4)))
+		}
+		return fmt.Sprintf("wide %s %d", OpcodeName(code[pc+1]), u16(pc+2))
+	case op >= OpIfeq && op <= OpJsr, op == OpIfnull, op == OpIfnonnull:
+		return fmt.Sprintf("%s %d", name, pc+int(int16(u16(pc+1))))
+	case op == OpGotoW, op == OpJsrW:
+		return fmt.Sprintf("%s %d", name, pc+i32(pc+1))
+	case op == OpTableswitch, op == OpLookupswitch:
+		ins := decodeInstruction(code, pc, pc+n)
+		var cases []string
+		for i, target := range ins.targets {
+			key := int(ins.low) + i
+			if op == OpLookupswitch {
+				key = int(ins.keys[i])
+			}
+			cases = append(cases, fmt.Sprintf("%d: %d", key, target))
+		}
+		cases = append(cases, fmt.Sprintf("default: %d", ins.target))
+		return fmt.Sprintf("%s { %s }", name, strings.Join(cases, ", "))
+	case op == OpMultianewarray:
+		return fmt.Sprintf("%s #%d, %d%s", name, u16(pc+1), code[pc+3], constantComment(pool, uint16(u16(pc+1))))
+	case n >= 3 && (op == OpLdcW || op == OpLdc2W || op >= OpGetstatic && op <= OpInvokedynamic ||
+		op == OpNew || op == OpAnewarray || op == OpCheckcast || op == OpInstanceof):
+		return fmt.Sprintf("%s #%d%s", name, u16(pc+1), constantComment(pool, uint16(u16(pc+1))))
+	case n > 1:
+		return fmt.Sprintf("%s % x", name, code[pc+1:pc+n])
+	}
+	return name
+}
+
+// constantComment describes a constant pool entry for disassembly, or
+// returns "" if it cannot.
+func constantComment(pool []classfile.ConstantPoolEntry, index uint16) string {
+	if int(index) >= len(pool) || pool[index] == nil {
+		return ""
+	}
+	switch c := pool[index].(type) {
+	case *classfile.ConstantClass:
+		if name, err := classfile.GetClassName(pool, index); err == nil {
+			return " // class " + name
+		}
+	case *classfile.ConstantString:
+		if s, err := classfile.GetUtf8(pool, c.StringIndex); err == nil {
+			return fmt.Sprintf(" // String %q", s)
+		}
+	case *classfile.ConstantInteger:
+		return fmt.Sprintf(" // int %d", c.Value)
+	case *classfile.ConstantLong:
+		return fmt.Sprintf(" // long %d", c.Value)
+	case *classfile.ConstantFloat:
+		return fmt.Sprintf(" // float %v", c.Value)
+	case *classfile.ConstantDouble:
+		return fmt.Sprintf(" // double %v", c.Value)
+	case *classfile.ConstantFieldref:
+		if ref, err := classfile.ResolveFieldref(pool, index); err == nil {
+			return fmt.Sprintf(" // Field %s.%s:%s", ref.ClassName, ref.FieldName, ref.Descriptor)
+		}
+	case *classfile.ConstantMethodref:
+		if ref, err := classfile.ResolveMethodref(pool, index); err == nil {
+			return fmt.Sprintf(" // Method %s.%s:%s", ref.ClassName, ref.MethodName, ref.Descriptor)
+		}
+	case *classfile.ConstantInterfaceMethodref:
+		if ref, err := classfile.ResolveInterfaceMethodref(pool, index); err == nil {
+			return fmt.Sprintf(" // InterfaceMethod %s.%s:%s", ref.ClassName, ref.MethodName, ref.Descriptor)
+		}
+	}
+	return ""
+}
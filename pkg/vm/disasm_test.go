@@ -0,0 +1,78 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestUnknownOpcodeContext(t *testing.T) {
+	b := classfile.NewBuilder("Dis", "java/lang/Object")
+	helper := b.Methodref("Dis", "helper", "()V")
+	b.AddMethod(classfile.AccStatic, "m", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpBipush, 5, OpIadd,
+			0xCA, // breakpoint
+			OpInvokestatic, byte(helper >> 8), byte(helper),
+			OpGoto, 0xff, 0xf8,
+			OpIreturn,
+		},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Dis": cf})
+	_, err := v.executeMethod(cf, cf.FindMethodByName("m"), []Value{IntValue(1)})
+
+	var unknown *UnknownOpcodeError
+	if !errors.As(err, &unknown) {
+		t.Fatalf("got %v, want UnknownOpcodeError", err)
+	}
+	if unknown.Opcode != 0xCA || unknown.PC != 4 || unknown.Method != "Dis.m(I)I" || unknown.StackDepth != 1 {
+		t.Errorf("got %+v", unknown)
+	}
+	want := fmt.Sprintf(""+
+		"\t       0: iload_0\n"+
+		"\t       1: bipush 5\n"+
+		"\t       3: iadd\n"+
+		"\t=>     4: breakpoint\n"+
+		"\t       5: invokestatic #%d // Method Dis.helper:()V\n"+
+		"\t       8: goto 0\n"+
+		"\t      11: ireturn\n", helper)
+	if unknown.Context != want {
+		t.Errorf("context:\n%s\nwant:\n%s", unknown.Context, want)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "unknown opcode: 0xCA at PC=4 in Dis.m(I)I, stack depth 1\n") {
+		t.Errorf("message: %s", msg)
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	b := classfile.NewBuilder("Dis", "java/lang/Object")
+	str := b.String("hi")
+	field := b.Fieldref("java/lang/System", "out", "Ljava/io/PrintStream;")
+	tests := []struct {
+		code []byte
+		want string
+	}{
+		{[]byte{OpSipush, 0xff, 0xfe}, "sipush -2"},
+		{[]byte{OpLdc, byte(str)}, fmt.Sprintf("ldc #%d // String \"hi\"", str)},
+		{[]byte{OpGetstatic, byte(field >> 8), byte(field)}, fmt.Sprintf("getstatic #%d // Field java/lang/System.out:Ljava/io/PrintStream;", field)},
+		{[]byte{OpIinc, 3, 0xff}, "iinc 3, -1"},
+		{[]byte{OpWide, OpIinc, 1, 0, 0x80, 0}, "wide iinc 256, -32768"},
+		{[]byte{OpWide, OpAload, 1, 0}, "wide aload 256"},
+		{[]byte{OpGotoW, 0, 1, 0, 0}, "goto_w 65536"},
+		{[]byte{OpTableswitch, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 16, 0, 0, 0, 17}, "tableswitch { 1: 16, 2: 17, default: 20 }"},
+		{[]byte{OpLookupswitch, 0, 0, 0, 0, 0, 0, 20, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 18}, "lookupswitch { -1: 18, default: 20 }"},
+		{[]byte{OpGoto, 0}, "goto <truncated>"},
+	}
+	cp := b.Build().ConstantPool
+	for _, tt := range tests {
+		if got := disassemble(tt.code, 0, cp); got != tt.want {
+			t.Errorf("% x: got %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
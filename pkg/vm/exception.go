@@ -8,6 +8,9 @@ type JavaException struct {
 }
 
 func (e *JavaException) Error() string {
+	if msg, ok := e.Message(); ok {
+		return fmt.Sprintf("JavaException: %s: %s", e.Object.ClassName, msg)
+	}
 	return fmt.Sprintf("JavaException: %s", e.Object.ClassName)
 }
 
@@ -19,3 +22,25 @@ func NewJavaException(className string) *JavaException {
 		},
 	}
 }
+
+// NewJavaExceptionMessage creates an exception whose getMessage returns
+// message.
+func NewJavaExceptionMessage(className, message string) *JavaException {
+	exc := NewJavaException(className)
+	exc.Object.Fields["detailMessage"] = RefValue(message)
+	return exc
+}
+
+// Message returns the exception's detail message, and false if it has
+// none.
+func (e *JavaException) Message() (string, bool) {
+	return extractGoString(e.Object.Fields["detailMessage"])
+}
+
+// Cause returns the exception's cause, or nil if it has none.
+func (e *JavaException) Cause() *JavaException {
+	if cause := causeOf(e.Object); cause != nil {
+		return &JavaException{Object: cause}
+	}
+	return nil
+}
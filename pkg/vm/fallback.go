@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"errors"
+	"strings"
+)
+
+// Embedders can let experimental workloads run past gaps in the VM by
+// installing fallbacks on VM.OpcodeFallback and VM.NativeFallback. They are
+// consulted only for opcodes and native methods the VM does not implement;
+// without them such code fails with an error as before.
+
+// ErrUnimplemented is returned by a fallback to decline handling, in which
+// case the VM reports the opcode or native method as unimplemented.
+var ErrUnimplemented = errors.New("unimplemented")
+
+// OpcodeFallback executes an opcode the interpreter does not implement.
+// frame.PC points just past the opcode, so the fallback must read any
+// operands it has. It returns like a built-in instruction: a return value
+// and true to return from the method, or an error, which may be a
+// *JavaException to throw.
+type OpcodeFallback func(vm *VM, frame *Frame, opcode byte) (Value, bool, error)
+
+// NativeFallback executes a native method the VM does not implement. args
+// holds the receiver first for instance methods. It returns the method's
+// result, or an error, which may be a *JavaException to throw.
+type NativeFallback func(vm *VM, className, methodName, descriptor string, args []Value) (Value, error)
+
+// ReturnDefault is a NativeFallback that does nothing and returns the zero
+// value of the method's return type, for stubbing natives whose effect
+// does not matter to the program.
+func ReturnDefault(vm *VM, className, methodName, descriptor string, args []Value) (Value, error) {
+	ret := descriptor[strings.LastIndexByte(descriptor, ')')+1:]
+	if ret == "V" {
+		return Value{}, nil
+	}
+	return defaultValueForDescriptor(ret), nil
+}
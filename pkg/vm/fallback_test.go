@@ -0,0 +1,69 @@
+package vm
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestOpcodeFallback(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	code := []byte{0xCA, 0x07, 0xAC} // breakpoint 7; ireturn
+	frame := NewFrame(0, 2, code, nil)
+	frame.PC = 1
+	if _, _, err := v.executeInstruction(frame, 0xCA); err == nil || !strings.Contains(err.Error(), "unknown opcode: 0xCA") {
+		t.Errorf("without fallback: got %v", err)
+	}
+
+	var seen []byte
+	v.OpcodeFallback = func(vm *VM, frame *Frame, opcode byte) (Value, bool, error) {
+		seen = append(seen, opcode)
+		if opcode != 0xCA {
+			return Value{}, false, ErrUnimplemented
+		}
+		frame.Push(IntValue(int32(frame.ReadU8())))
+		return Value{}, false, nil
+	}
+	if got := runFrame(t, v, nil, code); got.Int != 7 {
+		t.Errorf("with fallback: got %d, want 7", got.Int)
+	}
+
+	frame = NewFrame(0, 2, []byte{0xCB, 0x00}, nil)
+	frame.PC = 1
+	if _, _, err := v.executeInstruction(frame, 0xCB); err == nil || frame.PC != 1 {
+		t.Errorf("declined: got %v at PC=%d", err, frame.PC)
+	}
+	if string(seen) != "\xca\xcb" {
+		t.Errorf("fallback saw opcodes %x", seen)
+	}
+}
+
+func TestNativeFallback(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	if _, err := v.executeNativeMethod("vendor/Probe", "level", "()J", nil); err == nil {
+		t.Error("without fallback: expected an error")
+	}
+
+	v.NativeFallback = ReturnDefault
+	got, err := v.executeNativeMethod("vendor/Probe", "level", "()J", nil)
+	if err != nil || got.Type != TypeLong || got.Long != 0 {
+		t.Errorf("ReturnDefault ()J: got %+v, %v", got, err)
+	}
+	if got, _ := v.executeNativeMethod("vendor/Probe", "name", "(I)Ljava/lang/String;", []Value{IntValue(1)}); got.Type != TypeNull {
+		t.Errorf("ReturnDefault (I)String: got %+v", got)
+	}
+
+	v.NativeFallback = func(vm *VM, className, methodName, descriptor string, args []Value) (Value, error) {
+		if methodName == "level" {
+			return LongValue(3), nil
+		}
+		return Value{}, ErrUnimplemented
+	}
+	if got, err := v.executeNativeMethod("vendor/Probe", "level", "()J", nil); err != nil || got.Long != 3 {
+		t.Errorf("custom fallback: got %+v, %v", got, err)
+	}
+	if _, err := v.executeNativeMethod("vendor/Probe", "name", "(I)Ljava/lang/String;", []Value{IntValue(1)}); err == nil ||
+		!strings.Contains(err.Error(), "native method not implemented") {
+		t.Errorf("declined: got %v", err)
+	}
+}
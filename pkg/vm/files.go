@@ -0,0 +1,384 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// File streams are native streams over host files, held open as an
+// *os.File in _file, which is nil once the stream is closed:
+//
+//	FileInputStream:  _file
+//	FileOutputStream: _file
+//	RandomAccessFile: _file
+//
+// A program may only open files when VM.FileAccess is set; otherwise the
+// constructors throw SecurityException, as under a security manager that
+// denies the FilePermission.
+
+// openFileStream opens the file named by target, a String or File, for a
+// stream constructor. action is the FilePermission action checked.
+func (vm *VM) openFileStream(obj *JObject, target Value, action string, flag int) error {
+	var path string
+	if s, ok := target.Ref.(string); ok {
+		path = s
+	} else {
+		p, err := filePath(target)
+		if err != nil {
+			return err
+		}
+		path = p
+	}
+	if err := vm.checkFileAccess(path, action); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, flag, 0666)
+	if err == nil && flag == os.O_RDONLY {
+		// Go opens directories for reading; the JDK does not
+		if info, serr := f.Stat(); serr == nil && info.IsDir() {
+			f.Close()
+			f, err = nil, &fs.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+		}
+	}
+	if err != nil {
+		return NewJavaExceptionMessage("java/io/FileNotFoundException", fmt.Sprintf("%s (%s)", path, errnoMessage(err)))
+	}
+	obj.Fields["_file"] = RefValue(f)
+	return nil
+}
+
+// errnoMessage returns the message of the system error underlying err,
+// capitalized as the C library's strerror.
+func errnoMessage(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	msg := err.Error()
+	if msg == "" {
+		return msg
+	}
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// openFile returns the open file of a file stream, or throws IOException
+// if the stream is closed.
+func openFile(obj *JObject) (*os.File, error) {
+	f, _ := obj.Fields["_file"].Ref.(*os.File)
+	if f == nil {
+		return nil, NewJavaExceptionMessage("java/io/IOException", "Stream Closed")
+	}
+	return f, nil
+}
+
+// closeFile closes the file of a file stream; closing twice has no effect.
+func closeFile(obj *JObject) error {
+	f, _ := obj.Fields["_file"].Ref.(*os.File)
+	if f == nil {
+		return nil
+	}
+	obj.Fields["_file"] = RefValue((*os.File)(nil))
+	if err := f.Close(); err != nil {
+		return ioException(err)
+	}
+	return nil
+}
+
+// readFileBytes implements the InputStream reads shared by FileInputStream
+// and RandomAccessFile. It reports false for other methods.
+func readFileBytes(f *os.File, methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + ":" + descriptor {
+	case "read:()I":
+		var b [1]byte
+		if _, err := io.ReadFull(f, b[:]); err == io.EOF {
+			return IntValue(-1), true, nil
+		} else if err != nil {
+			return Value{}, true, ioException(err)
+		}
+		return IntValue(int32(b[0])), true, nil
+	case "read:([B)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		n := int32(args[0].Ref.(*JArray).Len())
+		return readFileBytes(f, "read", "([BII)I", []Value{args[0], IntValue(0), IntValue(n)})
+	case "read:([BII)I", "readNBytes:([BII)I":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, true, err
+		}
+		b := make([]byte, args[2].Int)
+		if len(b) == 0 {
+			return IntValue(0), true, nil
+		}
+		var n int
+		if methodName == "read" {
+			n, err = f.Read(b)
+		} else {
+			n, err = io.ReadFull(f, b)
+		}
+		if n == 0 && err == io.EOF {
+			if methodName == "readNBytes" {
+				return IntValue(0), true, nil
+			}
+			return IntValue(-1), true, nil
+		}
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return Value{}, true, ioException(err)
+		}
+		for i := 0; i < n; i++ {
+			arr.Set(int(args[1].Int)+i, IntValue(int32(int8(b[i]))))
+		}
+		return IntValue(int32(n)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// writeFileBytes implements the OutputStream writes shared by
+// FileOutputStream and RandomAccessFile. It reports false for other
+// methods.
+func writeFileBytes(f *os.File, methodName, descriptor string, args []Value) (bool, error) {
+	var b []byte
+	switch methodName + ":" + descriptor {
+	case "write:(I)V":
+		b = []byte{byte(args[0].Int)}
+	case "write:([B)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return true, NewJavaException("java/lang/NullPointerException")
+		}
+		arr := args[0].Ref.(*JArray)
+		b = byteArrayToGo(arr, 0, arr.Len())
+	case "write:([BII)V":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return true, err
+		}
+		b = byteArrayToGo(arr, int(args[1].Int), int(args[2].Int))
+	default:
+		return false, nil
+	}
+	if _, err := f.Write(b); err != nil {
+		return true, ioException(err)
+	}
+	return true, nil
+}
+
+func (vm *VM) handleFileInputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	if methodName == "<init>" {
+		switch descriptor {
+		case "(Ljava/lang/String;)V", "(Ljava/io/File;)V":
+			obj.Fields["_stream"] = RefValue("java/io/FileInputStream")
+			return Value{}, vm.openFileStream(obj, args[0], "read", os.O_RDONLY)
+		}
+		return Value{}, fmt.Errorf("FileInputStream: unsupported constructor %s", descriptor)
+	}
+	if methodName == "close" {
+		return Value{}, closeFile(obj)
+	}
+	if methodName == "markSupported" {
+		return IntValue(0), nil
+	}
+	f, err := openFile(obj)
+	if err != nil {
+		return Value{}, err
+	}
+	if ret, ok, err := readFileBytes(f, methodName, descriptor, args); ok {
+		return ret, err
+	}
+	switch methodName + ":" + descriptor {
+	case "readAllBytes:()[B":
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return Value{}, ioException(err)
+		}
+		return RefValue(goBytesToArray(b)), nil
+	case "readNBytes:(I)[B":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "len < 0")
+		}
+		b := make([]byte, args[0].Int)
+		n, err := io.ReadFull(f, b)
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return Value{}, ioException(err)
+		}
+		return RefValue(goBytesToArray(b[:n])), nil
+	case "skip:(J)J":
+		// Skipping may pass the end of the file, as in the JDK
+		if _, err := f.Seek(args[0].Long, io.SeekCurrent); err != nil {
+			return Value{}, ioException(err)
+		}
+		return args[0], nil
+	case "available:()I":
+		info, err := f.Stat()
+		if err != nil {
+			return Value{}, ioException(err)
+		}
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil || !info.Mode().IsRegular() || pos > info.Size() {
+			return IntValue(0), nil
+		}
+		return IntValue(int32(min(info.Size()-pos, 1<<31-1))), nil
+	}
+	return Value{}, fmt.Errorf("FileInputStream: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleFileOutputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	if methodName == "<init>" {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		switch descriptor {
+		case "(Ljava/lang/String;)V", "(Ljava/io/File;)V":
+		case "(Ljava/lang/String;Z)V", "(Ljava/io/File;Z)V":
+			if args[1].Int != 0 {
+				flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+			}
+		default:
+			return Value{}, fmt.Errorf("FileOutputStream: unsupported constructor %s", descriptor)
+		}
+		obj.Fields["_stream"] = RefValue("java/io/FileOutputStream")
+		return Value{}, vm.openFileStream(obj, args[0], "write", flag)
+	}
+	if methodName == "close" {
+		return Value{}, closeFile(obj)
+	}
+	f, err := openFile(obj)
+	if err != nil {
+		return Value{}, err
+	}
+	if ok, err := writeFileBytes(f, methodName, descriptor, args); ok {
+		return Value{}, err
+	}
+	if methodName+":"+descriptor == "flush:()V" {
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("FileOutputStream: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleRandomAccessFile(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	if methodName == "<init>" {
+		if descriptor != "(Ljava/lang/String;Ljava/lang/String;)V" && descriptor != "(Ljava/io/File;Ljava/lang/String;)V" {
+			return Value{}, fmt.Errorf("RandomAccessFile: unsupported constructor %s", descriptor)
+		}
+		mode, _ := extractGoString(args[1])
+		flag := os.O_RDWR | os.O_CREATE
+		switch mode {
+		case "r":
+			flag = os.O_RDONLY
+		case "rw":
+		case "rws", "rwd":
+			flag |= os.O_SYNC
+		default:
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException",
+				fmt.Sprintf("Illegal mode \"%s\" must be one of \"r\", \"rw\", \"rws\", or \"rwd\"", mode))
+		}
+		obj.Fields["_stream"] = RefValue("java/io/RandomAccessFile")
+		return Value{}, vm.openFileStream(obj, args[0], "read", flag)
+	}
+	if methodName == "close" {
+		return Value{}, closeFile(obj)
+	}
+	f, err := openFile(obj)
+	if err != nil {
+		return Value{}, err
+	}
+	if ret, ok, err := readFileBytes(f, methodName, descriptor, args); ok {
+		return ret, err
+	}
+	if ok, err := writeFileBytes(f, methodName, descriptor, args); ok {
+		return Value{}, err
+	}
+	readFully := func(n int) ([]byte, error) {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(f, b); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, NewJavaException("java/io/EOFException")
+		} else if err != nil {
+			return nil, ioException(err)
+		}
+		return b, nil
+	}
+	if v, ok, err := decodeDataInput(methodName, readFully); ok {
+		return v, err
+	}
+	if b, ok, err := encodeDataOutput(methodName, args); ok {
+		if err != nil {
+			return Value{}, err
+		}
+		if _, err := f.Write(b); err != nil {
+			return Value{}, ioException(err)
+		}
+		return Value{}, nil
+	}
+	switch methodName + ":" + descriptor {
+	case "readFully:([B)V", "readFully:([BII)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		off, n := int32(0), int32(args[0].Ref.(*JArray).Len())
+		if len(args) == 3 {
+			off, n = args[1].Int, args[2].Int
+		}
+		arr, err := arrayRange(args[0], off, n)
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := readFully(int(n))
+		if err != nil {
+			return Value{}, err
+		}
+		for i, c := range b {
+			arr.Set(int(off)+i, IntValue(int32(int8(c))))
+		}
+		return Value{}, nil
+	case "skipBytes:(I)I":
+		if args[0].Int <= 0 {
+			return IntValue(0), nil
+		}
+		pos, _ := f.Seek(0, io.SeekCurrent)
+		info, err := f.Stat()
+		if err != nil {
+			return Value{}, ioException(err)
+		}
+		n := min(int64(args[0].Int), max(info.Size()-pos, 0))
+		if _, err := f.Seek(n, io.SeekCurrent); err != nil {
+			return Value{}, ioException(err)
+		}
+		return IntValue(int32(n)), nil
+	case "seek:(J)V":
+		if args[0].Long < 0 {
+			return Value{}, NewJavaExceptionMessage("java/io/IOException", "Negative seek offset")
+		}
+		if _, err := f.Seek(args[0].Long, io.SeekStart); err != nil {
+			return Value{}, ioException(err)
+		}
+		return Value{}, nil
+	case "getFilePointer:()J":
+		pos, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return Value{}, ioException(err)
+		}
+		return LongValue(pos), nil
+	case "length:()J":
+		info, err := f.Stat()
+		if err != nil {
+			return Value{}, ioException(err)
+		}
+		return LongValue(info.Size()), nil
+	case "setLength:(J)V":
+		if args[0].Long < 0 {
+			return Value{}, NewJavaExceptionMessage("java/io/IOException", "Invalid argument")
+		}
+		if err := f.Truncate(args[0].Long); err != nil {
+			return Value{}, ioException(err)
+		}
+		// The file pointer stays put unless it is now past the end
+		if pos, _ := f.Seek(0, io.SeekCurrent); pos > args[0].Long {
+			f.Seek(args[0].Long, io.SeekStart)
+		}
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("RandomAccessFile: unsupported method %s:%s", methodName, descriptor)
+}
@@ -0,0 +1,119 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileStreams(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	v := NewVM(mapClassLoader{})
+	v.FileAccess = true
+	call := func(class string, stream Value, method, desc string, args ...Value) Value {
+		t.Helper()
+		ret, err := v.handleNativeStream(class, stream, method, desc, args)
+		if err != nil {
+			t.Fatalf("%s.%s%s: %v", class, method, desc, err)
+		}
+		return ret
+	}
+
+	t.Run("write then append then read", func(t *testing.T) {
+		const fos = "java/io/FileOutputStream"
+		out := newStreamObject(t, v, fos, "(Ljava/lang/String;)V", RefValue(path))
+		call(fos, out, "write", "(I)V", IntValue('h'))
+		call(fos, out, "write", "([BII)V", RefValue(goBytesToArray([]byte("xiy"))), IntValue(1), IntValue(1))
+		call(fos, out, "close", "()V")
+		file := RefValue(&JObject{ClassName: "java/io/File", Fields: map[string]Value{"path": RefValue(path)}})
+		out = newStreamObject(t, v, fos, "(Ljava/io/File;Z)V", file, IntValue(1))
+		call(fos, out, "write", "([B)V", RefValue(goBytesToArray([]byte("!\n"))))
+		call(fos, out, "close", "()V")
+		if _, err := v.handleNativeStream(fos, out, "write", "(I)V", []Value{IntValue(0)}); !isJavaException(err, "java/io/IOException") {
+			t.Errorf("write after close: got %v", err)
+		}
+		if b, _ := os.ReadFile(path); string(b) != "hi!\n" {
+			t.Fatalf("file contents: got %q", b)
+		}
+
+		const fis = "java/io/FileInputStream"
+		in := newStreamObject(t, v, fis, "(Ljava/lang/String;)V", RefValue(path))
+		if got := call(fis, in, "available", "()I"); got.Int != 4 {
+			t.Errorf("available: got %d, want 4", got.Int)
+		}
+		if got := call(fis, in, "read", "()I"); got.Int != 'h' {
+			t.Errorf("read: got %d", got.Int)
+		}
+		buf := NewArray("B", 8)
+		if got := call(fis, in, "read", "([BII)I", RefValue(buf), IntValue(2), IntValue(6)); got.Int != 3 || string(byteArrayToGo(buf, 2, 3)) != "i!\n" {
+			t.Errorf("read([BII): got %d, %q", got.Int, byteArrayToGo(buf, 2, 3))
+		}
+		if got := call(fis, in, "read", "()I"); got.Int != -1 {
+			t.Errorf("read at end: got %d", got.Int)
+		}
+		call(fis, in, "close", "()V")
+		call(fis, in, "close", "()V")
+	})
+
+	t.Run("random access", func(t *testing.T) {
+		const raf = "java/io/RandomAccessFile"
+		f := newStreamObject(t, v, raf, "(Ljava/lang/String;Ljava/lang/String;)V", RefValue(filepath.Join(dir, "r.bin")), RefValue("rw"))
+		call(raf, f, "writeInt", "(I)V", IntValue(0x01020304))
+		call(raf, f, "writeUTF", "(Ljava/lang/String;)V", RefValue("hé"))
+		if got := call(raf, f, "length", "()J"); got.Long != 9 {
+			t.Errorf("length: got %d, want 9", got.Long)
+		}
+		call(raf, f, "seek", "(J)V", LongValue(2))
+		if got := call(raf, f, "readShort", "()S"); got.Int != 0x0304 {
+			t.Errorf("readShort: got %#x", got.Int)
+		}
+		if got := call(raf, f, "readUTF", "()Ljava/lang/String;"); got.Ref != "hé" {
+			t.Errorf("readUTF: got %v", got.Ref)
+		}
+		if got := call(raf, f, "getFilePointer", "()J"); got.Long != 9 {
+			t.Errorf("getFilePointer: got %d", got.Long)
+		}
+		if _, err := v.handleNativeStream(raf, f, "readInt", "()I", nil); !isJavaException(err, "java/io/EOFException") {
+			t.Errorf("readInt at end: got %v", err)
+		}
+		call(raf, f, "setLength", "(J)V", LongValue(4))
+		if got := call(raf, f, "getFilePointer", "()J"); got.Long != 4 {
+			t.Errorf("getFilePointer after setLength: got %d", got.Long)
+		}
+		if _, err := v.handleNativeStream(raf, f, "seek", "(J)V", []Value{LongValue(-1)}); !isJavaException(err, "java/io/IOException") {
+			t.Errorf("negative seek: got %v", err)
+		}
+		call(raf, f, "close", "()V")
+
+		ref := RefValue(&JObject{ClassName: raf, Fields: map[string]Value{}})
+		_, err := v.handleNativeStream(raf, ref, "<init>", "(Ljava/lang/String;Ljava/lang/String;)V", []Value{RefValue(path), RefValue("w")})
+		if !isJavaException(err, "java/lang/IllegalArgumentException") {
+			t.Errorf("mode w: got %v", err)
+		}
+	})
+
+	t.Run("open failures", func(t *testing.T) {
+		ref := RefValue(&JObject{ClassName: "java/io/FileInputStream", Fields: map[string]Value{}})
+		missing := filepath.Join(dir, "missing")
+		_, err := v.handleNativeStream("java/io/FileInputStream", ref, "<init>", "(Ljava/lang/String;)V", []Value{RefValue(missing)})
+		if msg, _ := err.(*JavaException).Message(); !isJavaException(err, "java/io/FileNotFoundException") || msg != missing+" (No such file or directory)" {
+			t.Errorf("missing file: got %v", err)
+		}
+		_, err = v.handleNativeStream("java/io/FileInputStream", ref, "<init>", "(Ljava/lang/String;)V", []Value{RefValue(dir)})
+		if msg, _ := err.(*JavaException).Message(); msg != dir+" (Is a directory)" {
+			t.Errorf("directory: got %v", err)
+		}
+		_, err = v.handleNativeStream("java/io/FileInputStream", ref, "<init>", "(Ljava/lang/String;)V", []Value{NullValue()})
+		if !isJavaException(err, "java/lang/NullPointerException") {
+			t.Errorf("null path: got %v", err)
+		}
+
+		denied := NewVM(mapClassLoader{})
+		_, err = denied.handleNativeStream("java/io/FileOutputStream", ref, "<init>", "(Ljava/lang/String;)V", []Value{RefValue(path)})
+		if msg, _ := err.(*JavaException).Message(); !isJavaException(err, "java/lang/SecurityException") || !strings.Contains(msg, `"java.io.FilePermission" "`+path+`" "write"`) {
+			t.Errorf("without FileAccess: got %v", err)
+		}
+	})
+}
@@ -0,0 +1,459 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// String.format and String.formatted are implemented natively after
+// java.util.Formatter with US symbols. Floating-point conversions round
+// the digits FloatingDecimal gives the value half-up, as Java's
+// FormattedFloatingDecimal does, so "%.2f" of 0.125 is "0.13". The date
+// and time conversions and %a are not supported.
+
+// formatSpecifier matches a format specifier after its '%', as
+// Formatter's fsPattern does.
+var formatSpecifier = regexp.MustCompile(`^(\d+\$)?([-#+ 0,(<]*)?(\d+)?(\.\d+)?([tT])?([a-zA-Z%])`)
+
+// formatSpec is a parsed format specifier.
+type formatSpec struct {
+	text       string // the specifier as written, for error messages
+	flags      string
+	width      int // -1 if absent
+	precision  int // -1 if absent
+	conversion byte
+	upper      bool
+}
+
+func (f *formatSpec) has(flag byte) bool {
+	return strings.IndexByte(f.flags, flag) >= 0
+}
+
+// formatException returns a java.util formatting exception. Those classes
+// compute getMessage from their own fields, so fields sets them as well as
+// the detail message.
+func formatException(class, message string, fields map[string]Value) *JavaException {
+	exc := NewJavaExceptionMessage("java/util/"+class, message)
+	for name, v := range fields {
+		exc.Object.Fields[name] = v
+	}
+	return exc
+}
+
+// flagsMismatch reports a flag that conversion does not accept.
+func flagsMismatch(conversion, flag byte) *JavaException {
+	return formatException("FormatFlagsConversionMismatchException",
+		fmt.Sprintf("Conversion = %c, Flags = %c", conversion, flag),
+		map[string]Value{"f": RefValue(string(flag)), "c": IntValue(int32(conversion))})
+}
+
+// illegalPrecision reports a precision that the conversion does not take.
+func illegalPrecision(p int) *JavaException {
+	return formatException("IllegalFormatPrecisionException", strconv.Itoa(p), map[string]Value{"p": IntValue(int32(p))})
+}
+
+// unknownConversion reports an unknown conversion character.
+func unknownConversion(conv string) *JavaException {
+	return formatException("UnknownFormatConversionException", "Conversion = '"+conv+"'", map[string]Value{"s": RefValue(conv)})
+}
+
+// formatString implements String.format(format, args).
+func (vm *VM) formatString(format string, args []Value) (string, error) {
+	var b strings.Builder
+	ordinary, last := 0, -1
+	for i := 0; i < len(format); {
+		pct := strings.IndexByte(format[i:], '%')
+		if pct < 0 {
+			b.WriteString(format[i:])
+			break
+		}
+		b.WriteString(format[i : i+pct])
+		i += pct + 1
+		m := formatSpecifier.FindStringSubmatch(format[i:])
+		if m == nil {
+			conv := "%"
+			if i < len(format) {
+				conv = format[i : i+1]
+			}
+			return "", unknownConversion(conv)
+		}
+		i += len(m[0])
+		spec := &formatSpec{text: "%" + m[0], flags: m[2], width: -1, precision: -1, conversion: m[6][0]}
+		if m[3] != "" {
+			spec.width, _ = strconv.Atoi(m[3])
+		}
+		if m[4] != "" {
+			spec.precision, _ = strconv.Atoi(m[4][1:])
+		}
+		if m[5] != "" {
+			return "", fmt.Errorf("String.format: date/time conversion %s not implemented", spec.text)
+		}
+		switch c := spec.conversion; {
+		case strings.IndexByte("aA", c) >= 0:
+			return "", fmt.Errorf("String.format: conversion %s not implemented", spec.text)
+		case strings.IndexByte("BHSCXEG", c) >= 0:
+			spec.upper, spec.conversion = true, c+'a'-'A'
+		case strings.IndexByte("bhscdoxefgn%", c) < 0:
+			return "", unknownConversion(string(c))
+		}
+		if (spec.has('-') || spec.has('0')) && spec.width < 0 {
+			return "", formatException("MissingFormatWidthException", spec.text, map[string]Value{"s": RefValue(spec.text)})
+		}
+
+		switch spec.conversion {
+		case 'n':
+			b.WriteString(vm.systemProperties()["line.separator"])
+			continue
+		case '%':
+			b.WriteString(justify(spec, "%"))
+			continue
+		}
+
+		// Pick the argument: explicit n$, < for the previous one, or the
+		// next ordinary one.
+		var index int
+		switch {
+		case m[1] != "":
+			index, _ = strconv.Atoi(strings.TrimSuffix(m[1], "$"))
+			index--
+		case spec.has('<'):
+			index = last
+		default:
+			index = ordinary
+			ordinary++
+		}
+		if index < 0 || index >= len(args) {
+			return "", formatException("MissingFormatArgumentException", "Format specifier '"+spec.text+"'",
+				map[string]Value{"s": RefValue(spec.text)})
+		}
+		last = index
+		s, err := vm.formatArg(spec, args[index])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s)
+	}
+	return b.String(), nil
+}
+
+// justify pads s to the width of spec and applies the upper-case variant
+// of the conversion.
+func justify(spec *formatSpec, s string) string {
+	if spec.upper {
+		s = strings.ToUpper(s)
+	}
+	if pad := spec.width - charLength(s); pad > 0 {
+		if spec.has('-') {
+			return s + strings.Repeat(" ", pad)
+		}
+		return strings.Repeat(" ", pad) + s
+	}
+	return s
+}
+
+// formatArg formats one argument.
+func (vm *VM) formatArg(spec *formatSpec, arg Value) (string, error) {
+	isNull := arg.Type == TypeNull || arg.Ref == nil
+	var class string
+	var inner Value
+	if obj, ok := arg.Ref.(*JObject); ok && !isNull {
+		class = obj.ClassName
+		inner = obj.Fields["value"]
+	} else if arr, ok := arg.Ref.(*JArray); ok && !isNull {
+		class = "[" + arr.Component
+	} else if _, ok := extractGoString(arg); ok {
+		class = "java/lang/String"
+	}
+	mismatch := func() error {
+		return formatException("IllegalFormatConversionException",
+			fmt.Sprintf("%c != %s", spec.conversion, strings.ReplaceAll(class, "/", ".")),
+			map[string]Value{"c": IntValue(int32(spec.conversion)), "arg": vm.classObject(class)})
+	}
+
+	switch spec.conversion {
+	case 'b', 's', 'h':
+		var s string
+		switch {
+		case spec.conversion == 'b' && isNull:
+			s = "false"
+		case spec.conversion == 'b' && class == "java/lang/Boolean":
+			s = strconv.FormatBool(inner.Int != 0)
+		case spec.conversion == 'b':
+			s = "true"
+		case isNull:
+			s = "null"
+		case spec.conversion == 'h':
+			s = strconv.FormatUint(uint64(uint32(vm.javaHashCode(arg))), 16)
+		default:
+			if spec.has('#') {
+				return "", flagsMismatch('s', '#')
+			}
+			s = vm.valueToString(arg)
+		}
+		if spec.precision >= 0 && spec.precision < charLength(s) {
+			s = charsString(stringChars(s)[:spec.precision])
+		}
+		return justify(spec, s), nil
+
+	case 'c':
+		if spec.precision >= 0 {
+			return "", illegalPrecision(spec.precision)
+		}
+		if isNull {
+			return justify(spec, "null"), nil
+		}
+		switch class {
+		case "java/lang/Character", "java/lang/Byte", "java/lang/Short", "java/lang/Integer":
+			cp := inner.Int
+			if class == "java/lang/Character" {
+				cp = int32(uint16(cp))
+			}
+			if cp < 0 || cp > unicode.MaxRune {
+				return "", formatException("IllegalFormatCodePointException", fmt.Sprintf("Code point = 0x%x", cp),
+					map[string]Value{"c": IntValue(cp)})
+			}
+			return justify(spec, string(rune(cp))), nil
+		}
+		return "", mismatch()
+
+	case 'd', 'o', 'x':
+		if spec.precision >= 0 {
+			return "", illegalPrecision(spec.precision)
+		}
+		if isNull {
+			return justify(spec, "null"), nil
+		}
+		var n *big.Int
+		bits := 0 // the width of two's complement for %o and %x
+		switch class {
+		case "java/lang/Byte":
+			n, bits = big.NewInt(int64(inner.Int)), 8
+		case "java/lang/Short":
+			n, bits = big.NewInt(int64(inner.Int)), 16
+		case "java/lang/Integer":
+			n, bits = big.NewInt(int64(inner.Int)), 32
+		case "java/lang/Long":
+			n, bits = big.NewInt(inner.Long), 64
+		case "java/math/BigInteger":
+			n, _ = new(big.Int).SetString(vm.valueToString(arg), 10)
+		}
+		if n == nil {
+			return "", mismatch()
+		}
+		return formatInteger(spec, n, bits)
+
+	case 'e', 'f', 'g':
+		if isNull {
+			return justify(spec, "null"), nil
+		}
+		var d float64
+		switch class {
+		case "java/lang/Double":
+			d = inner.Double
+		case "java/lang/Float":
+			d = float64(inner.Float)
+		default:
+			return "", mismatch()
+		}
+		return formatFloatSpec(spec, d)
+	}
+	return "", unknownConversion(spec.text[len(spec.text)-1:])
+}
+
+// formatInteger implements %d, %o and %x. bits is the two's complement
+// width in which %o and %x show negative primitives, or 0 for BigInteger,
+// which they show with a sign.
+func formatInteger(spec *formatSpec, n *big.Int, bits int) (string, error) {
+	negative := n.Sign() < 0
+	var digits, prefix string
+	switch spec.conversion {
+	case 'd':
+		if spec.has('#') {
+			return "", flagsMismatch('d', '#')
+		}
+		digits = new(big.Int).Abs(n).String()
+		if spec.has(',') {
+			digits = groupThousands(digits)
+		}
+	default:
+		base := 8
+		if spec.conversion == 'x' {
+			base = 16
+		}
+		if bits > 0 {
+			for _, flag := range "+ ,(" {
+				if spec.has(byte(flag)) {
+					return "", flagsMismatch(spec.conversion, byte(flag))
+				}
+			}
+			if negative {
+				n = new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(bits)))
+				negative = false
+			}
+		}
+		digits = new(big.Int).Abs(n).Text(base)
+		if spec.has('#') {
+			prefix = "0"
+			if base == 16 {
+				prefix = "0x"
+			}
+		}
+	}
+	return signAndPad(spec, negative, prefix, digits), nil
+}
+
+// signAndPad adds the sign, prefix and zero padding of a number.
+func signAndPad(spec *formatSpec, negative bool, prefix, digits string) string {
+	sign, suffix := "", ""
+	switch {
+	case negative && spec.has('('):
+		sign, suffix = "(", ")"
+	case negative:
+		sign = "-"
+	case spec.has('+'):
+		sign = "+"
+	case spec.has(' '):
+		sign = " "
+	}
+	sign += prefix
+	if spec.has('0') {
+		if pad := spec.width - len(sign) - len(digits) - len(suffix); pad > 0 {
+			digits = strings.Repeat("0", pad) + digits
+		}
+	}
+	return justify(spec, sign+digits+suffix)
+}
+
+// groupThousands inserts US grouping separators into integer digits.
+func groupThousands(digits string) string {
+	var b strings.Builder
+	for i := 0; i < len(digits); i++ {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteByte(digits[i])
+	}
+	return b.String()
+}
+
+// decimalDigits returns the digits of d, which must be finite and
+// non-negative, as FloatingDecimal gives them to Formatter, and the
+// decimal exponent of the first digit.
+func decimalDigits(d float64) (string, int) {
+	if d == 0 {
+		return "0", 0
+	}
+	digits, decExp := floatingDigits(d, 64, false)
+	return string(digits), decExp - 1
+}
+
+// roundScientific rounds the digits of a number with exponent e to
+// precision digits after the first, half-up.
+func roundScientific(digits string, e, precision int) (string, string, int) {
+	first, rest := roundDigits(digits[:1], digits[1:], precision, "HALF_UP", false)
+	if len(first) > 1 { // 9.99 rounded up to 10.0
+		first, rest, e = "1", strings.Repeat("0", precision), e+1
+	}
+	return first, rest, e
+}
+
+// roundFixed rounds the digits of a number with exponent e to precision
+// fraction digits, half-up.
+func roundFixed(digits string, e, precision int) (string, string) {
+	var intDigits, fracDigits string
+	if e >= 0 {
+		if len(digits) <= e {
+			digits += strings.Repeat("0", e+1-len(digits))
+		}
+		intDigits, fracDigits = digits[:e+1], digits[e+1:]
+	} else {
+		intDigits, fracDigits = "0", strings.Repeat("0", -e-1)+digits
+	}
+	intDigits, fracDigits = roundDigits(intDigits, fracDigits, precision, "HALF_UP", false)
+	if trimmed := strings.TrimLeft(intDigits, "0"); trimmed != "" {
+		intDigits = trimmed
+	} else {
+		intDigits = "0"
+	}
+	return intDigits, fracDigits
+}
+
+// formatFloatSpec implements %e, %f and %g.
+func formatFloatSpec(spec *formatSpec, d float64) (string, error) {
+	if spec.has('#') && spec.conversion == 'g' {
+		return "", flagsMismatch('g', '#')
+	}
+	if spec.has(',') && spec.conversion == 'e' {
+		return "", flagsMismatch('e', ',')
+	}
+	negative := math.Signbit(d) && !math.IsNaN(d)
+	switch {
+	case math.IsNaN(d):
+		return justify(spec, "NaN"), nil
+	case math.IsInf(d, 0):
+		// Infinity is never zero-padded.
+		noZero := *spec
+		noZero.flags = strings.ReplaceAll(spec.flags, "0", "")
+		return signAndPad(&noZero, negative, "", "Infinity"), nil
+	}
+
+	precision := spec.precision
+	if precision < 0 {
+		precision = 6
+	}
+	digits, e := decimalDigits(math.Abs(d))
+	conversion := spec.conversion
+	if conversion == 'g' {
+		if precision == 0 {
+			precision = 1
+		}
+		// Pick the fixed form for rounded values in [10^-4, 10^precision).
+		_, _, re := roundScientific(digits, e, precision-1)
+		if d != 0 && (re < -4 || re >= precision) {
+			conversion, precision = 'e', precision-1
+		} else {
+			conversion, precision = 'f', precision-re-1
+			if d == 0 {
+				precision = spec.precision - 1
+				if spec.precision < 0 {
+					precision = 5
+				}
+			}
+		}
+	}
+
+	var body string
+	if conversion == 'e' {
+		if d == 0 {
+			digits, e = "0", 0
+		}
+		first, rest, exp := roundScientific(digits, e, precision)
+		body = first
+		if rest != "" || spec.has('#') {
+			body += "." + rest
+		}
+		sign := '+'
+		if exp < 0 {
+			sign, exp = '-', -exp
+		}
+		body += fmt.Sprintf("e%c%02d", sign, exp)
+	} else {
+		intDigits, fracDigits := roundFixed(digits, e, precision)
+		if d == 0 {
+			intDigits, fracDigits = "0", strings.Repeat("0", precision)
+		}
+		if spec.has(',') {
+			intDigits = groupThousands(intDigits)
+		}
+		body = intDigits
+		if fracDigits != "" || spec.has('#') {
+			body += "." + fracDigits
+		}
+	}
+	return signAndPad(spec, negative, "", body), nil
+}
@@ -0,0 +1,71 @@
+package vm
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	char := func(c rune) Value {
+		return RefValue(&JObject{ClassName: "java/lang/Character", Fields: map[string]Value{"value": IntValue(c)}})
+	}
+	boolean := RefValue(&JObject{ClassName: "java/lang/Boolean", Fields: map[string]Value{"value": IntValue(0)}})
+	for _, c := range []struct {
+		format string
+		args   []Value
+		want   string
+	}{
+		{"%d items", []Value{v.boxValue(IntValue(3))}, "3 items"},
+		{"%5d|%-5d|%05d", []Value{v.boxValue(IntValue(42)), v.boxValue(IntValue(42)), v.boxValue(IntValue(-42))}, "   42|42   |-0042"},
+		{"%,d %+d %(d", []Value{v.boxValue(LongValue(1234567)), v.boxValue(IntValue(5)), v.boxValue(IntValue(-5))}, "1,234,567 +5 (5)"},
+		{"%x %X %o %#x", []Value{v.boxValue(IntValue(-1)), v.boxValue(IntValue(255)), v.boxValue(IntValue(8)), v.boxValue(LongValue(-1))}, "ffffffff FF 10 0xffffffffffffffff"},
+		{"%.2f %.2f %.0f", []Value{v.boxValue(DoubleValue(0.125)), v.boxValue(DoubleValue(1.005)), v.boxValue(DoubleValue(2.5))}, "0.13 1.01 3"},
+		{"%f %08.3f", []Value{v.boxValue(DoubleValue(math.Pi)), v.boxValue(DoubleValue(-3.14159))}, "3.141593 -003.142"},
+		{"%e %.2E", []Value{v.boxValue(DoubleValue(12345.678)), v.boxValue(DoubleValue(0.000123))}, "1.234568e+04 1.23E-04"},
+		{"%g %g", []Value{v.boxValue(DoubleValue(0.0001)), v.boxValue(DoubleValue(123456789))}, "0.000100000 1.23457e+08"},
+		{"%f %f", []Value{v.boxValue(DoubleValue(math.NaN())), v.boxValue(DoubleValue(math.Inf(-1)))}, "NaN -Infinity"},
+		{"%s|%-6s|%.3s|%S", []Value{RefValue("a"), RefValue("ab"), RefValue("abcdef"), RefValue("up")}, "a|ab    |abc|UP"},
+		{"%b %b %b", []Value{NullValue(), v.boxValue(IntValue(0)), boolean}, "false true false"},
+		{"%c%c", []Value{char('é'), v.boxValue(IntValue(0x1F600))}, "é😀"},
+		{"%2$s %1$s %<s", []Value{RefValue("a"), RefValue("b")}, "b a a"},
+		{"100%% %s", []Value{NullValue()}, "100% null"},
+	} {
+		got, err := v.formatString(c.format, c.args)
+		if err != nil || got != c.want {
+			t.Errorf("format(%q): got %q, %v, want %q", c.format, got, err, c.want)
+		}
+	}
+	if got, _ := v.formatString("a%nb", nil); got != "a\nb" {
+		t.Errorf("%%n: got %q", got)
+	}
+}
+
+func TestFormatErrors(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	for _, c := range []struct {
+		format    string
+		args      []Value
+		exception string
+	}{
+		{"%d", []Value{RefValue("x")}, "java/util/IllegalFormatConversionException"},
+		{"%s %s", []Value{RefValue("x")}, "java/util/MissingFormatArgumentException"},
+		{"%q", nil, "java/util/UnknownFormatConversionException"},
+		{"%D", []Value{v.boxValue(IntValue(1))}, "java/util/UnknownFormatConversionException"},
+		{"%", nil, "java/util/UnknownFormatConversionException"},
+		{"%-d", []Value{v.boxValue(IntValue(1))}, "java/util/MissingFormatWidthException"},
+		{"%.2d", []Value{v.boxValue(IntValue(1))}, "java/util/IllegalFormatPrecisionException"},
+		{"%#d", []Value{v.boxValue(IntValue(1))}, "java/util/FormatFlagsConversionMismatchException"},
+		{"%c", []Value{v.boxValue(IntValue(-1))}, "java/util/IllegalFormatCodePointException"},
+	} {
+		if _, err := v.formatString(c.format, c.args); !isJavaException(err, c.exception) {
+			t.Errorf("format(%q): got %v, want %s", c.format, err, c.exception)
+		}
+	}
+	// The JDK computes these messages from the exception's own fields.
+	_, err := v.formatString("%.2d", []Value{v.boxValue(IntValue(1))})
+	if p := err.(*JavaException).Object.Fields["p"]; p.Int != 2 {
+		t.Errorf("IllegalFormatPrecisionException.p: got %v", p)
+	}
+}
@@ -16,8 +16,31 @@ const (
 	TypeDouble
 	TypeRef
 	TypeNull
+	TypeReturnAddress // pushed by jsr; Int holds the pc to return to
 )
 
+// String returns the name of t as used in diagnostics, such as "long" or
+// "returnAddress".
+func (t ValueType) String() string {
+	switch t {
+	case TypeInt:
+		return "int"
+	case TypeFloat:
+		return "float"
+	case TypeLong:
+		return "long"
+	case TypeDouble:
+		return "double"
+	case TypeRef:
+		return "reference"
+	case TypeNull:
+		return "null"
+	case TypeReturnAddress:
+		return "returnAddress"
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
 // Value represents a value on the operand stack or in local variables.
 type Value struct {
 	Type   ValueType
@@ -28,6 +51,15 @@ type Value struct {
 	Ref    interface{}
 }
 
+// Category returns the computational type category of v (JVMS §2.11.1):
+// 2 for long and double, 1 for everything else.
+func (v Value) Category() int {
+	if v.Type == TypeLong || v.Type == TypeDouble {
+		return 2
+	}
+	return 1
+}
+
 // IntValue creates an integer Value.
 func IntValue(v int32) Value {
 	return Value{Type: TypeInt, Int: v}
@@ -58,7 +90,19 @@ func NullValue() Value {
 	return Value{Type: TypeNull}
 }
 
+// ReturnAddressValue creates a returnAddress Value for the given pc.
+func ReturnAddressValue(pc int) Value {
+	return Value{Type: TypeReturnAddress, Int: int32(pc)}
+}
+
 // Frame represents a stack frame for method execution.
+//
+// Local variables are indexed by JVM slot: a long or double stored at slot
+// n occupies n and n+1, and slot n+1 is left unused, so the indices javac
+// emits and MaxLocals apply unchanged. The operand stack instead holds one
+// entry per value whatever its size; instructions that manipulate the
+// stack by slot (pop2, dup2 and the like) consult Value.Category, and
+// MaxStack is an upper bound on the entries used.
 type Frame struct {
 	LocalVars    []Value
 	OperandStack []Value
@@ -66,6 +110,8 @@ type Frame struct {
 	Code         []byte
 	PC           int
 	Class        *classfile.ClassFile
+	Monitors     []interface{} // monitors entered by this frame, in order
+	slots        []Value       // backing store of LocalVars and OperandStack if pooled
 }
 
 // NewFrame creates a new Frame with the given parameters.
@@ -80,10 +126,20 @@ func NewFrame(maxLocals, maxStack uint16, code []byte, class *classfile.ClassFil
 	}
 }
 
+// operandStackOverflow is the value Push panics with when the operand
+// stack is full, which the interpreter throws as a Java error.
+type operandStackOverflow struct {
+	sp, max int
+}
+
+func (e operandStackOverflow) Error() string {
+	return fmt.Sprintf("operand stack overflow: SP=%d, max=%d", e.sp, e.max)
+}
+
 // Push pushes a value onto the operand stack.
 func (f *Frame) Push(v Value) {
 	if f.SP >= len(f.OperandStack) {
-		panic(fmt.Sprintf("operand stack overflow: SP=%d, max=%d", f.SP, len(f.OperandStack)))
+		panic(operandStackOverflow{f.SP, len(f.OperandStack)})
 	}
 	f.OperandStack[f.SP] = v
 	f.SP++
@@ -1,6 +1,9 @@
 package vm
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -132,3 +135,56 @@ func TestFrameLocalVars(t *testing.T) {
 		}
 	})
 }
+
+func TestValueConstructors(t *testing.T) {
+	tests := []struct {
+		v        Value
+		want     Value
+		category int
+	}{
+		{IntValue(-7), Value{Type: TypeInt, Int: -7}, 1},
+		{FloatValue(1.5), Value{Type: TypeFloat, Float: 1.5}, 1},
+		{LongValue(1 << 40), Value{Type: TypeLong, Long: 1 << 40}, 2},
+		{DoubleValue(-2.5), Value{Type: TypeDouble, Double: -2.5}, 2},
+		{RefValue("s"), Value{Type: TypeRef, Ref: "s"}, 1},
+		{NullValue(), Value{Type: TypeNull}, 1},
+		{ReturnAddressValue(12), Value{Type: TypeReturnAddress, Int: 12}, 1},
+	}
+	for _, tt := range tests {
+		// Each constructor sets its type and only the field of that type.
+		if tt.v != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.want.Type, tt.v, tt.want)
+		}
+		if got := tt.v.Category(); got != tt.category {
+			t.Errorf("%v: category %d, want %d", tt.want.Type, got, tt.category)
+		}
+	}
+	for typ := TypeInt; typ <= TypeReturnAddress; typ++ {
+		if strings.HasPrefix(typ.String(), "ValueType(") {
+			t.Errorf("ValueType %d has no name", int(typ))
+		}
+	}
+}
+
+// Values are built only by the constructors in frame.go, so that the
+// representation of each type is defined in one place.
+func TestValuesBuiltByConstructors(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file == "frame.go" || strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, line := range strings.Split(string(src), "\n") {
+			if strings.Contains(line, "Value{Type:") {
+				t.Errorf("%s:%d: Value literal instead of a constructor: %s", file, i+1, strings.TrimSpace(line))
+			}
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package vm
+
+import "github.com/daimatz/gojvm/pkg/classfile"
+
+// Every method call needs a Frame with its locals and operand stack.
+// Rather than allocating them afresh, executeMethod takes frames from a
+// per-VM free list and returns them when the method returns, so that
+// call-heavy code does not produce a frame's worth of garbage per call.
+// Locals and stack share one backing slice, whose length is rounded up to
+// a power of two so that methods of similar size reuse the same frames.
+// Released frames are cleared, so that pooled frames do not keep the
+// objects they referenced alive. Nothing may keep a frame after its
+// method returns, which includes an OpcodeFallback.
+
+const (
+	minFrameSlots  = 8    // the smallest size class
+	maxPooledSlots = 1024 // larger frames are not pooled
+	maxFreeFrames  = 64   // frames kept per size class
+)
+
+// framePool holds released frames by size class.
+type framePool map[int][]*Frame
+
+// frameSizeClass returns the number of slots allocated for a frame that
+// needs n.
+func frameSizeClass(n int) int {
+	size := minFrameSlots
+	for size < n {
+		size *= 2
+	}
+	return size
+}
+
+// newFrame returns a cleared frame for a method, reusing a released one
+// of the same size class if there is one.
+func (vm *VM) newFrame(maxLocals, maxStack uint16, code []byte, class *classfile.ClassFile) *Frame {
+	n := int(maxLocals) + int(maxStack)
+	if n > maxPooledSlots {
+		return NewFrame(maxLocals, maxStack, code, class)
+	}
+	size := frameSizeClass(n)
+	var f *Frame
+	if free := vm.frames[size]; len(free) > 0 {
+		f = free[len(free)-1]
+		vm.frames[size] = free[:len(free)-1]
+	} else {
+		f = &Frame{slots: make([]Value, size)}
+	}
+	f.LocalVars = f.slots[:maxLocals:maxLocals]
+	f.OperandStack = f.slots[maxLocals:n:n]
+	f.Code = code
+	f.Class = class
+	return f
+}
+
+// releaseFrame clears f and returns it to the pool, if it came from it.
+func (vm *VM) releaseFrame(f *Frame) {
+	if f.slots == nil {
+		return
+	}
+	size := len(f.slots)
+	if len(vm.frames[size]) >= maxFreeFrames {
+		return
+	}
+	clear(f.slots[:len(f.LocalVars)+len(f.OperandStack)])
+	clear(f.Monitors)
+	*f = Frame{slots: f.slots, Monitors: f.Monitors[:0]}
+	if vm.frames == nil {
+		vm.frames = make(framePool)
+	}
+	vm.frames[size] = append(vm.frames[size], f)
+}
@@ -0,0 +1,92 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestFramePool(t *testing.T) {
+	v := &VM{}
+	f := v.newFrame(3, 2, []byte{OpReturn}, nil)
+	if len(f.LocalVars) != 3 || len(f.OperandStack) != 2 || len(f.slots) != minFrameSlots {
+		t.Fatalf("got %d locals, %d stack, %d slots", len(f.LocalVars), len(f.OperandStack), len(f.slots))
+	}
+	f.SetLocal(2, RefValue("kept alive"))
+	f.Push(RefValue("also kept alive"))
+	f.PC = 1
+	f.Monitors = append(f.Monitors, "lock")
+	v.releaseFrame(f)
+
+	// A method of the same size class gets the cleared frame back.
+	g := v.newFrame(1, 6, nil, nil)
+	if g != f {
+		t.Fatal("released frame was not reused")
+	}
+	if len(g.LocalVars) != 1 || len(g.OperandStack) != 6 || cap(g.LocalVars) != 1 {
+		t.Errorf("got %d locals (cap %d), %d stack", len(g.LocalVars), cap(g.LocalVars), len(g.OperandStack))
+	}
+	for i, slot := range g.slots {
+		if slot != (Value{}) {
+			t.Errorf("slot %d not cleared: %+v", i, slot)
+		}
+	}
+	if g.SP != 0 || g.PC != 0 || len(g.Monitors) != 0 {
+		t.Errorf("frame state not reset: SP=%d PC=%d monitors=%v", g.SP, g.PC, g.Monitors)
+	}
+
+	if h := v.newFrame(10, 10, nil, nil); len(h.slots) != 32 {
+		t.Errorf("20 slots: got size class %d, want 32", len(h.slots))
+	}
+	big := v.newFrame(maxPooledSlots, 1, nil, nil)
+	v.releaseFrame(big)
+	if big.slots != nil || len(v.frames[2*maxPooledSlots]) != 0 {
+		t.Error("oversized frame was pooled")
+	}
+}
+
+// fib builds the naive recursive Fibonacci function, whose cost is
+// dominated by calls.
+func fib() (*classfile.ClassFile, *classfile.MethodInfo) {
+	b := classfile.NewBuilder("Fib", "java/lang/Object")
+	self := b.Methodref("Fib", "fib", "(I)I")
+	b.AddMethod(classfile.AccStatic, "fib", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  3,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpIconst2, OpIfIcmpge, 0, 5, // if n >= 2 goto 7
+			OpIload0, OpIreturn, // return n
+			OpIload0, OpIconst1, OpIsub, OpInvokestatic, byte(self >> 8), byte(self), // 7: fib(n-1)
+			OpIload0, OpIconst2, OpIsub, OpInvokestatic, byte(self >> 8), byte(self), // fib(n-2)
+			OpIadd, OpIreturn,
+		},
+	})
+	cf := b.Build()
+	return cf, cf.FindMethodByName("fib")
+}
+
+func TestPooledFramesRecursion(t *testing.T) {
+	cf, method := fib()
+	v := NewVM(mapClassLoader{"Fib": cf})
+	got, err := v.executeMethod(cf, method, []Value{IntValue(20)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != 6765 {
+		t.Errorf("fib(20): got %d, want 6765", got.Int)
+	}
+	if n := len(v.frames[minFrameSlots]); n == 0 || n > maxFreeFrames {
+		t.Errorf("%d frames pooled after returning", n)
+	}
+}
+
+func BenchmarkFib(b *testing.B) {
+	cf, method := fib()
+	v := NewVM(mapClassLoader{"Fib": cf})
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := v.executeMethod(cf, method, []Value{IntValue(15)}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -1,8 +1,10 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
 	"math"
+	"strings"
 
 	"github.com/daimatz/gojvm/pkg/classfile"
 )
@@ -101,6 +103,8 @@ const (
 	OpDupX1      = 0x5A
 	OpDupX2      = 0x5B
 	OpDup2       = 0x5C
+	OpDup2X1     = 0x5D
+	OpDup2X2     = 0x5E
 	OpPop2       = 0x58
 	OpSwap       = 0x5F
 	OpIadd       = 0x60
@@ -175,6 +179,8 @@ const (
 	OpIfAcmpeq   = 0xA5
 	OpIfAcmpne   = 0xA6
 	OpGoto       = 0xA7
+	OpJsr        = 0xA8
+	OpRet        = 0xA9
 	OpTableswitch  = 0xAA
 	OpLookupswitch = 0xAB
 	OpIreturn    = 0xAC
@@ -200,11 +206,13 @@ const (
 	OpInstanceof    = 0xC1
 	OpMonitorenter  = 0xC2
 	OpMonitorexit   = 0xC3
+	OpWide          = 0xC4
 	OpInvokedynamic     = 0xBA
 	OpMultianewarray   = 0xC5
 	OpIfnull           = 0xC6
 	OpIfnonnull        = 0xC7
 	OpGotoW            = 0xC8
+	OpJsrW             = 0xC9
 )
 
 // executeInstruction executes a single bytecode instruction.
@@ -277,6 +285,12 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 			frame.Push(LongValue(c.Value))
 		case *classfile.ConstantDouble:
 			frame.Push(DoubleValue(c.Value))
+		case *classfile.ConstantDynamic:
+			v, err := vm.resolveConstant(frame.Class, index)
+			if err != nil {
+				return Value{}, false, err
+			}
+			frame.Push(v)
 		default:
 			return Value{}, false, fmt.Errorf("ldc2_w: unsupported type at index %d", index)
 		}
@@ -347,31 +361,31 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		index := frame.Pop().Int
 		arrRef := frame.Pop()
 		if arrRef.Type == TypeNull || arrRef.Ref == nil {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		arr, ok := arrRef.Ref.(*JArray)
 		if !ok {
 			return Value{}, false, fmt.Errorf("xaload: reference is not an array")
 		}
-		if index < 0 || int(index) >= len(arr.Elements) {
-			return Value{}, false, NewJavaException("java/lang/ArrayIndexOutOfBoundsException")
+		if index < 0 || int(index) >= arr.Len() {
+			return Value{}, false, arrayIndexOutOfBounds(int(index), arr.Len())
 		}
-		frame.Push(arr.Elements[index])
+		frame.Push(arr.Get(int(index)))
 
 	case OpAaload:
 		index := frame.Pop().Int
 		arrRef := frame.Pop()
 		if arrRef.Type == TypeNull || arrRef.Ref == nil {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		arr, ok := arrRef.Ref.(*JArray)
 		if !ok {
 			return Value{}, false, fmt.Errorf("aaload: reference is not an array")
 		}
-		if index < 0 || int(index) >= len(arr.Elements) {
-			return Value{}, false, NewJavaException("java/lang/ArrayIndexOutOfBoundsException")
+		if index < 0 || int(index) >= arr.Len() {
+			return Value{}, false, arrayIndexOutOfBounds(int(index), arr.Len())
 		}
-		frame.Push(arr.Elements[index])
+		frame.Push(arr.Get(int(index)))
 
 	// --- Local variable store instructions ---
 	case OpIstore:
@@ -440,32 +454,35 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		index := frame.Pop().Int
 		arrRef := frame.Pop()
 		if arrRef.Type == TypeNull || arrRef.Ref == nil {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		arr, ok := arrRef.Ref.(*JArray)
 		if !ok {
 			return Value{}, false, fmt.Errorf("xastore: reference is not an array")
 		}
-		if index < 0 || int(index) >= len(arr.Elements) {
-			return Value{}, false, NewJavaException("java/lang/ArrayIndexOutOfBoundsException")
+		if index < 0 || int(index) >= arr.Len() {
+			return Value{}, false, arrayIndexOutOfBounds(int(index), arr.Len())
 		}
-		arr.Elements[index] = value
+		arr.Set(int(index), value)
 
 	case OpAastore:
 		value := frame.Pop()
 		index := frame.Pop().Int
 		arrRef := frame.Pop()
 		if arrRef.Type == TypeNull || arrRef.Ref == nil {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		arr, ok := arrRef.Ref.(*JArray)
 		if !ok {
 			return Value{}, false, fmt.Errorf("aastore: reference is not an array")
 		}
-		if index < 0 || int(index) >= len(arr.Elements) {
-			return Value{}, false, NewJavaException("java/lang/ArrayIndexOutOfBoundsException")
+		if index < 0 || int(index) >= arr.Len() {
+			return Value{}, false, arrayIndexOutOfBounds(int(index), arr.Len())
+		}
+		if !vm.canStore(value, arr.Component) {
+			return Value{}, false, arrayStoreFailure(value)
 		}
-		arr.Elements[index] = value
+		arr.Set(int(index), value)
 
 	// --- Stack manipulation ---
 	case OpPop:
@@ -486,6 +503,12 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 	case OpDupX2:
 		v1 := frame.Pop()
 		v2 := frame.Pop()
+		if v2.Category() == 2 { // form 2: value2 is a long or double
+			frame.Push(v1)
+			frame.Push(v2)
+			frame.Push(v1)
+			break
+		}
 		v3 := frame.Pop()
 		frame.Push(v1)
 		frame.Push(v3)
@@ -494,15 +517,58 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 
 	case OpDup2:
 		v1 := frame.Pop()
+		if v1.Category() == 2 { // form 2: duplicate a single long or double
+			frame.Push(v1)
+			frame.Push(v1)
+			break
+		}
+		v2 := frame.Pop()
+		frame.Push(v2)
+		frame.Push(v1)
+		frame.Push(v2)
+		frame.Push(v1)
+
+	case OpDup2X1:
+		v1 := frame.Pop()
+		if v1.Category() == 2 { // form 2: a long or double over one value
+			v2 := frame.Pop()
+			frame.Push(v1)
+			frame.Push(v2)
+			frame.Push(v1)
+			break
+		}
 		v2 := frame.Pop()
+		v3 := frame.Pop()
 		frame.Push(v2)
 		frame.Push(v1)
+		frame.Push(v3)
 		frame.Push(v2)
 		frame.Push(v1)
 
+	case OpDup2X2:
+		// The four forms differ in whether the top two words and the two
+		// words below them are one category-2 value or two category-1 values.
+		var top, below []Value
+		if v1 := frame.Pop(); v1.Category() == 2 {
+			top = []Value{v1}
+		} else {
+			top = []Value{frame.Pop(), v1}
+		}
+		if v3 := frame.Pop(); v3.Category() == 2 {
+			below = []Value{v3}
+		} else {
+			below = []Value{frame.Pop(), v3}
+		}
+		for _, group := range [][]Value{top, below, top} {
+			for _, v := range group {
+				frame.Push(v)
+			}
+		}
+
 	case OpPop2:
-		frame.Pop()
-		frame.Pop()
+		if frame.Pop().Category() == 1 {
+			frame.Pop()
+		}
 
 	case OpSwap:
 		v2 := frame.Pop()
@@ -575,15 +641,17 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		v2 := frame.Pop()
 		v1 := frame.Pop()
 		if v2.Int == 0 {
-			return Value{}, false, NewJavaException("java/lang/ArithmeticException")
+			return Value{}, false, divisionByZero()
 		}
+		// Go, like the JVM, defines MinInt32 / -1 as MinInt32 (and the
+		// remainder as 0) rather than trapping on the overflow.
 		frame.Push(IntValue(v1.Int / v2.Int))
 
 	case OpLdiv:
 		v2 := frame.Pop()
 		v1 := frame.Pop()
 		if v2.Long == 0 {
-			return Value{}, false, NewJavaException("java/lang/ArithmeticException")
+			return Value{}, false, divisionByZero()
 		}
 		frame.Push(LongValue(v1.Long / v2.Long))
 
@@ -601,7 +669,7 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		v2 := frame.Pop()
 		v1 := frame.Pop()
 		if v2.Int == 0 {
-			return Value{}, false, NewJavaException("java/lang/ArithmeticException")
+			return Value{}, false, divisionByZero()
 		}
 		frame.Push(IntValue(v1.Int % v2.Int))
 
@@ -609,19 +677,22 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		v2 := frame.Pop()
 		v1 := frame.Pop()
 		if v2.Long == 0 {
-			return Value{}, false, NewJavaException("java/lang/ArithmeticException")
+			return Value{}, false, divisionByZero()
 		}
 		frame.Push(LongValue(v1.Long % v2.Long))
 
 	case OpFrem:
 		v2 := frame.Pop()
 		v1 := frame.Pop()
-		frame.Push(FloatValue(float32(math.Remainder(float64(v1.Float), float64(v2.Float)))))
+		// fmod of the widened operands is exact, so narrowing loses nothing
+		frame.Push(FloatValue(float32(math.Mod(float64(v1.Float), float64(v2.Float)))))
 
 	case OpDrem:
+		// Java's remainder truncates the quotient like C fmod; it is not
+		// the IEEE 754 remainder operation.
 		v2 := frame.Pop()
 		v1 := frame.Pop()
-		frame.Push(DoubleValue(math.Remainder(v1.Double, v2.Double)))
+		frame.Push(DoubleValue(math.Mod(v1.Double, v2.Double)))
 
 	case OpIneg:
 		v := frame.Pop()
@@ -733,19 +804,11 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 
 	case OpF2i:
 		v := frame.Pop()
-		if math.IsNaN(float64(v.Float)) {
-			frame.Push(IntValue(0))
-		} else {
-			frame.Push(IntValue(int32(v.Float)))
-		}
+		frame.Push(IntValue(doubleToInt(float64(v.Float))))
 
 	case OpF2l:
 		v := frame.Pop()
-		if math.IsNaN(float64(v.Float)) {
-			frame.Push(LongValue(0))
-		} else {
-			frame.Push(LongValue(int64(v.Float)))
-		}
+		frame.Push(LongValue(doubleToLong(float64(v.Float))))
 
 	case OpF2d:
 		v := frame.Pop()
@@ -753,19 +816,11 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 
 	case OpD2i:
 		v := frame.Pop()
-		if math.IsNaN(v.Double) {
-			frame.Push(IntValue(0))
-		} else {
-			frame.Push(IntValue(int32(v.Double)))
-		}
+		frame.Push(IntValue(doubleToInt(v.Double)))
 
 	case OpD2l:
 		v := frame.Pop()
-		if math.IsNaN(v.Double) {
-			frame.Push(LongValue(0))
-		} else {
-			frame.Push(LongValue(int64(v.Double)))
-		}
+		frame.Push(LongValue(doubleToLong(v.Double)))
 
 	case OpD2f:
 		v := frame.Pop()
@@ -878,10 +933,7 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		branchPC := frame.PC - 1
 		offset := frame.ReadI16()
 		v2 := frame.Pop()
-		v1 := frame.Pop()
-		eq := (v1.Type == TypeNull && v2.Type == TypeNull) ||
-			(v1.Type == v2.Type && v1.Ref == v2.Ref)
-		if eq {
+		if sameReference(frame.Pop(), v2) {
 			frame.PC = branchPC + int(offset)
 		}
 
@@ -889,10 +941,7 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		branchPC := frame.PC - 1
 		offset := frame.ReadI16()
 		v2 := frame.Pop()
-		v1 := frame.Pop()
-		eq := (v1.Type == TypeNull && v2.Type == TypeNull) ||
-			(v1.Type == v2.Type && v1.Ref == v2.Ref)
-		if !eq {
+		if !sameReference(frame.Pop(), v2) {
 			frame.PC = branchPC + int(offset)
 		}
 
@@ -906,6 +955,23 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		offset := frame.ReadI32()
 		frame.PC = branchPC + int(offset)
 
+	// Subroutines, emitted for finally blocks by compilers targeting class
+	// files before version 50.
+	case OpJsr:
+		branchPC := frame.PC - 1
+		offset := frame.ReadI16()
+		frame.Push(ReturnAddressValue(frame.PC))
+		frame.PC = branchPC + int(offset)
+
+	case OpJsrW:
+		branchPC := frame.PC - 1
+		offset := frame.ReadI32()
+		frame.Push(ReturnAddressValue(frame.PC))
+		frame.PC = branchPC + int(offset)
+
+	case OpRet:
+		return vm.executeRet(frame, int(frame.ReadU8()))
+
 	case OpTableswitch:
 		// PC of the tableswitch opcode
 		opcodePC := frame.PC - 1
@@ -996,46 +1062,51 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 
 	case OpNewarray:
 		atype := frame.ReadU8()
+		component, ok := newarrayComponents[atype]
+		if !ok {
+			return Value{}, false, fmt.Errorf("newarray: invalid atype %d", atype)
+		}
 		count := frame.Pop().Int
 		if count < 0 {
-			return Value{}, false, NewJavaException("java/lang/NegativeArraySizeException")
+			return Value{}, false, negativeArraySize(int(count))
 		}
-		elements := make([]Value, count)
-		_ = atype // type doesn't matter for initialization, all zero
-		for i := range elements {
-			elements[i] = IntValue(0)
+		if err := vm.checkArraySize("["+component, int(count)); err != nil {
+			return Value{}, false, err
 		}
-		arr := &JArray{Elements: elements}
-		frame.Push(RefValue(arr))
+		frame.Push(RefValue(NewArray(component, int(count))))
 
 	case OpAnewarray:
-		_ = frame.ReadU16() // CP index for element type
+		className, err := classfile.GetClassName(frame.Class.ConstantPool, frame.ReadU16())
+		if err != nil {
+			return Value{}, false, fmt.Errorf("anewarray: %w", err)
+		}
+		if err := vm.linkClass(className); err != nil {
+			return Value{}, false, err
+		}
 		count := frame.Pop().Int
 		if count < 0 {
-			return Value{}, false, NewJavaException("java/lang/NegativeArraySizeException")
+			return Value{}, false, negativeArraySize(int(count))
 		}
-		elements := make([]Value, count)
-		for i := range elements {
-			elements[i] = NullValue()
+		if err := vm.checkArraySize("["+classDescriptor(className), int(count)); err != nil {
+			return Value{}, false, err
 		}
-		arr := &JArray{Elements: elements}
-		frame.Push(RefValue(arr))
+		frame.Push(RefValue(NewArray(classDescriptor(className), int(count))))
 
 	case OpArraylength:
 		arrRef := frame.Pop()
 		if arrRef.Type == TypeNull || arrRef.Ref == nil {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		arr, ok := arrRef.Ref.(*JArray)
 		if !ok {
 			return Value{}, false, fmt.Errorf("arraylength: reference is not an array")
 		}
-		frame.Push(IntValue(int32(len(arr.Elements))))
+		frame.Push(IntValue(int32(arr.Len())))
 
 	case OpAthrow:
 		excRef := frame.Pop()
 		if excRef.Type == TypeNull {
-			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			return Value{}, false, nullOperand()
 		}
 		if obj, ok := excRef.Ref.(*JObject); ok {
 			return Value{}, false, &JavaException{Object: obj}
@@ -1049,19 +1120,11 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		if err != nil {
 			return Value{}, false, fmt.Errorf("checkcast: %w", err)
 		}
-		val := frame.Peek()
-		if val.Type != TypeNull {
-			if className[0] == '[' {
-				// array checkcast - pass through for now
-			} else if _, ok := val.Ref.(string); ok {
-				if className != "java/lang/String" && className != "java/lang/Object" && className != "java/lang/Comparable" && className != "java/io/Serializable" && className != "java/lang/CharSequence" {
-					return Value{}, false, NewJavaException("java/lang/ClassCastException")
-				}
-			} else if obj, ok := val.Ref.(*JObject); ok {
-				if !vm.isInstanceOf(obj.ClassName, className) {
-					return Value{}, false, NewJavaException("java/lang/ClassCastException")
-				}
-			}
+		if err := vm.linkClass(className); err != nil {
+			return Value{}, false, err
+		}
+		if val := frame.Peek(); val.Type != TypeNull && !vm.isInstanceOfDescriptor(val, classDescriptor(className)) {
+			return Value{}, false, classCastFailure(val, className)
 		}
 
 	case OpInstanceof:
@@ -1071,56 +1134,110 @@ func (vm *VM) executeInstruction(frame *Frame, opcode byte) (Value, bool, error)
 		if err != nil {
 			return Value{}, false, fmt.Errorf("instanceof: %w", err)
 		}
-		ref := frame.Pop()
-		if ref.Type == TypeNull {
-			frame.Push(IntValue(0))
-		} else if _, ok := ref.Ref.(string); ok && (className == "java/lang/String" || className == "java/lang/Object" || className == "java/lang/Comparable" || className == "java/io/Serializable" || className == "java/lang/CharSequence") {
-			frame.Push(IntValue(1))
-		} else if obj, ok := ref.Ref.(*JObject); ok && vm.isInstanceOf(obj.ClassName, className) {
+		if err := vm.linkClass(className); err != nil {
+			return Value{}, false, err
+		}
+		if ref := frame.Pop(); ref.Type != TypeNull && vm.isInstanceOfDescriptor(ref, classDescriptor(className)) {
 			frame.Push(IntValue(1))
 		} else {
 			frame.Push(IntValue(0))
 		}
 
 	case OpMonitorenter:
-		frame.Pop() // pop object reference, no-op for single-threaded
+		if err := vm.monitorEnter(frame, frame.Pop()); err != nil {
+			return Value{}, false, err
+		}
 	case OpMonitorexit:
-		frame.Pop() // pop object reference, no-op for single-threaded
+		if err := vm.monitorExit(frame, frame.Pop()); err != nil {
+			return Value{}, false, err
+		}
+
+	case OpWide:
+		// wide widens the local variable index of the next instruction to
+		// 16 bits (and the increment of iinc to a signed 16-bit value).
+		op := frame.ReadU8()
+		index := int(frame.ReadU16())
+		switch op {
+		case OpIload, OpLload, OpFload, OpDload, OpAload:
+			frame.Push(frame.GetLocal(index))
+		case OpIstore, OpLstore, OpFstore, OpDstore, OpAstore:
+			frame.SetLocal(index, frame.Pop())
+		case OpIinc:
+			constVal := frame.ReadI16()
+			frame.SetLocal(index, IntValue(frame.GetLocal(index).Int+int32(constVal)))
+		case OpRet:
+			return vm.executeRet(frame, index)
+		default:
+			return Value{}, false, fmt.Errorf("wide: unsupported opcode 0x%02x", op)
+		}
 
 	case OpMultianewarray:
-		_ = frame.ReadU16() // constant pool index (array class name)
+		index := frame.ReadU16()
 		dims := int(frame.ReadU8())
+		className, err := classfile.GetClassName(frame.Class.ConstantPool, index)
+		if err != nil {
+			return Value{}, false, fmt.Errorf("multianewarray: %w", err)
+		}
+		if err := vm.linkClass(className); err != nil {
+			return Value{}, false, err
+		}
+		if dims < 1 || !strings.HasPrefix(className, strings.Repeat("[", dims)) {
+			return Value{}, false, fmt.Errorf("multianewarray: %d dimensions for %s", dims, className)
+		}
 		sizes := make([]int, dims)
 		for i := dims - 1; i >= 0; i-- {
 			sizes[i] = int(frame.Pop().Int)
 		}
-		arr := createMultiArray(sizes, 0)
-		frame.Push(RefValue(arr))
+		// Every count is checked before anything is allocated.
+		for _, size := range sizes {
+			if size < 0 {
+				return Value{}, false, negativeArraySize(size)
+			}
+		}
+		if err := vm.checkArraySize(className, sizes...); err != nil {
+			return Value{}, false, err
+		}
+		frame.Push(RefValue(createMultiArray(className, sizes)))
 
 	case OpIfnull:
 		branchPC := frame.PC - 1
 		offset := frame.ReadI16()
-		val := frame.Pop()
-		if val.Type == TypeNull || (val.Type == TypeRef && val.Ref == nil) {
+		if isNullReference(frame.Pop()) {
 			frame.PC = branchPC + int(offset)
 		}
 
 	case OpIfnonnull:
 		branchPC := frame.PC - 1
 		offset := frame.ReadI16()
-		val := frame.Pop()
-		isNull := val.Type == TypeNull || (val.Type == TypeRef && val.Ref == nil)
-		if !isNull {
+		if !isNullReference(frame.Pop()) {
 			frame.PC = branchPC + int(offset)
 		}
 
 	default:
-		return Value{}, false, fmt.Errorf("unknown opcode: 0x%02X at PC=%d", opcode, frame.PC-1)
+		if vm.OpcodeFallback != nil {
+			pc := frame.PC
+			ret, hasReturn, err := vm.OpcodeFallback(vm, frame, opcode)
+			if !errors.Is(err, ErrUnimplemented) {
+				return ret, hasReturn, err
+			}
+			frame.PC = pc
+		}
+		return Value{}, false, &UnknownOpcodeError{Opcode: opcode, PC: frame.PC - 1}
 	}
 
 	return Value{}, false, nil
 }
 
+// executeRet returns from a subroutine to the address held in a local.
+func (vm *VM) executeRet(frame *Frame, index int) (Value, bool, error) {
+	addr := frame.GetLocal(index)
+	if addr.Type != TypeReturnAddress {
+		return Value{}, false, fmt.Errorf("ret: local %d does not hold a return address", index)
+	}
+	frame.PC = int(addr.Int)
+	return Value{}, false, nil
+}
+
 // executeBranchUnary handles unary branch instructions (ifeq, ifne, etc.)
 func (vm *VM) executeBranchUnary(frame *Frame, cond func(int32) bool) (Value, bool, error) {
 	branchPC := frame.PC - 1 // PC of the branch instruction
@@ -1144,14 +1261,91 @@ func (vm *VM) executeBranchBinary(frame *Frame, cond func(int32, int32) bool) (V
 	return Value{}, false, nil
 }
 
-// createMultiArray recursively creates a multi-dimensional JArray.
-func createMultiArray(sizes []int, depth int) *JArray {
-	size := sizes[depth]
-	arr := &JArray{Elements: make([]Value, size)}
-	if depth+1 < len(sizes) {
-		for i := 0; i < size; i++ {
-			arr.Elements[i] = RefValue(createMultiArray(sizes, depth+1))
+// newarrayComponents maps the atype operand of newarray to the component
+// descriptor of the array it creates.
+var newarrayComponents = map[uint8]string{
+	4: "Z", 5: "C", 6: "F", 7: "D", 8: "B", 9: "S", 10: "I", 11: "J",
+}
+
+// createMultiArray recursively creates a multi-dimensional JArray of the
+// array type descriptor with one size per allocated dimension.
+func createMultiArray(descriptor string, sizes []int) *JArray {
+	// The innermost allocated level holds default elements, which are null
+	// for the dimensions left unspecified.
+	arr := NewArray(descriptor[1:], sizes[0])
+	if len(sizes) > 1 {
+		for i := range arr.Elements {
+			arr.Elements[i] = RefValue(createMultiArray(descriptor[1:], sizes[1:]))
 		}
 	}
 	return arr
 }
+
+// doubleToInt converts like d2i: NaN becomes 0 and values outside the int
+// range saturate to its bounds.
+func doubleToInt(d float64) int32 {
+	switch {
+	case math.IsNaN(d):
+		return 0
+	case d >= math.MaxInt32:
+		return math.MaxInt32
+	case d <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(d)
+}
+
+// doubleToLong converts like d2l: NaN becomes 0 and values outside the long
+// range saturate to its bounds.
+func doubleToLong(d float64) int64 {
+	switch {
+	case math.IsNaN(d):
+		return 0
+	case d >= math.MaxInt64:
+		return math.MaxInt64
+	case d <= math.MinInt64:
+		return math.MinInt64
+	}
+	return int64(d)
+}
+
+// divisionByZero returns the exception thrown by integer division and
+// remainder with a zero divisor.
+func divisionByZero() *JavaException {
+	return NewJavaExceptionMessage("java/lang/ArithmeticException", "/ by zero")
+}
+
+// arrayIndexOutOfBounds returns the exception thrown for an access at
+// index of an array of the given length.
+func arrayIndexOutOfBounds(index, length int) *JavaException {
+	return NewJavaExceptionMessage("java/lang/ArrayIndexOutOfBoundsException",
+		fmt.Sprintf("Index %d out of bounds for length %d", index, length))
+}
+
+// negativeArraySize returns the exception thrown for creating an array of
+// a negative size.
+func negativeArraySize(size int) *JavaException {
+	return NewJavaExceptionMessage("java/lang/NegativeArraySizeException", fmt.Sprint(size))
+}
+
+// classCastFailure returns the exception checkcast throws when v is not an
+// instance of className, which names both classes as HotSpot does. The VM
+// has no module system, so both are in the unnamed module.
+func classCastFailure(v Value, className string) *JavaException {
+	from := runtimeClassName(v)
+	if from == "" {
+		return NewJavaException("java/lang/ClassCastException")
+	}
+	from, to := strings.ReplaceAll(from, "/", "."), strings.ReplaceAll(className, "/", ".")
+	return NewJavaExceptionMessage("java/lang/ClassCastException", fmt.Sprintf(
+		"class %s cannot be cast to class %s (%s and %s are in unnamed module of loader 'app')", from, to, from, to))
+}
+
+// arrayStoreFailure returns the exception aastore throws for storing v in
+// an array of an incompatible type, which names the class of v.
+func arrayStoreFailure(v Value) *JavaException {
+	if name := runtimeClassName(v); name != "" {
+		return NewJavaExceptionMessage("java/lang/ArrayStoreException", strings.ReplaceAll(name, "/", "."))
+	}
+	return NewJavaException("java/lang/ArrayStoreException")
+}
@@ -2,6 +2,7 @@ package vm
 
 import (
 	"io"
+	"math"
 	"testing"
 
 	"github.com/daimatz/gojvm/pkg/classfile"
@@ -342,6 +343,115 @@ func TestDivisionByZero(t *testing.T) {
 	})
 }
 
+// MIN_VALUE / -1 overflows; the JVM defines the quotient as MIN_VALUE and
+// the remainder as 0 instead of trapping.
+func TestDivisionOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		op     byte
+		v1, v2 Value
+		want   Value
+	}{
+		{"idiv", 0x6C, IntValue(math.MinInt32), IntValue(-1), IntValue(math.MinInt32)},
+		{"irem", 0x70, IntValue(math.MinInt32), IntValue(-1), IntValue(0)},
+		{"ldiv", 0x6D, LongValue(math.MinInt64), LongValue(-1), LongValue(math.MinInt64)},
+		{"lrem", 0x71, LongValue(math.MinInt64), LongValue(-1), LongValue(0)},
+		{"idiv by 1", 0x6C, IntValue(math.MinInt32), IntValue(1), IntValue(math.MinInt32)},
+		{"lrem by -2", 0x71, LongValue(math.MinInt64 + 1), LongValue(-2), LongValue(-1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// load v1; load v2; op; return the result
+			load, second, ret := byte(0x15), byte(1), byte(0xAC)
+			if tt.v1.Type == TypeLong {
+				load, second, ret = 0x16, 2, 0xAD // longs take two slots
+			}
+			frame := NewFrame(4, 4, []byte{load, 0, load, second, tt.op, ret}, nil)
+			frame.SetLocal(0, tt.v1)
+			frame.SetLocal(int(second), tt.v2)
+			if got := runFrameFrom(t, frame); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// frem and drem follow C fmod: the quotient is truncated, the result has
+// the sign of the dividend, and NaN, infinite dividends and zero divisors
+// give NaN. Expected values are those of exact fmod.
+func TestFloatingRemainder(t *testing.T) {
+	inf, nan := math.Inf(1), math.NaN()
+	tests := []struct {
+		name       string
+		v1, v2     float64
+		want       float64
+		doubleOnly bool // the operands are not exact floats
+	}{
+		{"truncated quotient", 5.5, 2, 1.5, false},
+		{"negative dividend", -5.5, 2, -1.5, false},
+		{"negative divisor", 5.5, -2, 1.5, false},
+		{"negative zero dividend", math.Copysign(0, -1), 5, math.Copysign(0, -1), false},
+		{"infinite divisor", -5, inf, -5, false},
+		{"negative infinite divisor", 5, -inf, 5, false},
+		{"infinite dividend", inf, 3, nan, false},
+		{"zero divisor", 1, 0, nan, false},
+		{"NaN dividend", nan, 1, nan, false},
+		{"NaN divisor", 1, nan, nan, false},
+		{"inexact decimal operands", 0.1, 0.01, 3.469446951953614e-18, true},
+		{"huge dividend", 1e308, 3, 2, true},
+		{"subnormal dividend", 5e-324, 1, 5e-324, true},
+		{"huge quotient", math.MaxFloat64, 1e-300, 2.589523889680434e-302, true},
+	}
+	same := func(got, want float64) bool {
+		if math.IsNaN(want) {
+			return math.IsNaN(got)
+		}
+		return got == want && math.Signbit(got) == math.Signbit(want)
+	}
+	v := &VM{Stdout: io.Discard}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := NewFrame(0, 2, []byte{0x73}, nil)
+			frame.Push(DoubleValue(tt.v1))
+			frame.Push(DoubleValue(tt.v2))
+			frame.PC++
+			if _, _, err := v.executeInstruction(frame, 0x73); err != nil {
+				t.Fatal(err)
+			}
+			if got := frame.Pop().Double; !same(got, tt.want) {
+				t.Errorf("drem: got %v, want %v", got, tt.want)
+			}
+
+			if tt.doubleOnly {
+				return
+			}
+			frame = NewFrame(0, 2, []byte{0x72}, nil)
+			frame.Push(FloatValue(float32(tt.v1)))
+			frame.Push(FloatValue(float32(tt.v2)))
+			frame.PC++
+			if _, _, err := v.executeInstruction(frame, 0x72); err != nil {
+				t.Fatal(err)
+			}
+			if got := frame.Pop().Float; !same(float64(got), tt.want) {
+				t.Errorf("frem: got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 0.1f % 0.01f is computed on the float values, not their decimal
+	// approximations as doubles.
+	frame := NewFrame(0, 2, []byte{0x72}, nil)
+	frame.Push(FloatValue(0.1))
+	frame.Push(FloatValue(0.01))
+	frame.PC++
+	if _, _, err := v.executeInstruction(frame, 0x72); err != nil {
+		t.Fatal(err)
+	}
+	if got := frame.Pop().Float; got != 3.7252903e-09 {
+		t.Errorf("0.1f %% 0.01f: got %v", got)
+	}
+}
+
 func TestOverflow(t *testing.T) {
 	tests := []struct {
 		name   string
@@ -812,8 +922,8 @@ func TestAnewarray(t *testing.T) {
 				if !ok {
 					t.Fatalf("expected *JArray, got %T", retVal.Ref)
 				}
-				if len(arr.Elements) != 5 {
-					t.Errorf("array length: got %d, want 5", len(arr.Elements))
+				if arr.Len() != 5 {
+					t.Errorf("array length: got %d, want 5", arr.Len())
 				}
 				return
 			}
@@ -841,8 +951,8 @@ func TestAnewarray(t *testing.T) {
 				if !ok {
 					t.Fatalf("expected *JArray, got %T", retVal.Ref)
 				}
-				if len(arr.Elements) != 0 {
-					t.Errorf("array length: got %d, want 0", len(arr.Elements))
+				if arr.Len() != 0 {
+					t.Errorf("array length: got %d, want 0", arr.Len())
 				}
 				return
 			}
@@ -851,6 +961,70 @@ func TestAnewarray(t *testing.T) {
 	})
 }
 
+func TestMultianewarray(t *testing.T) {
+	b := classfile.NewBuilder("Multi", "java/lang/Object")
+	longs := b.Class("[[[J")
+	strs := b.Class("[[Ljava/lang/String;")
+	cf := b.Build()
+	run := func(code ...byte) (Value, error) {
+		t.Helper()
+		frame := NewFrame(0, 4, code, cf)
+		v := &VM{Stdout: io.Discard}
+		for frame.PC < len(frame.Code) {
+			opcode := frame.Code[frame.PC]
+			frame.PC++
+			retVal, hasReturn, err := v.executeInstruction(frame, opcode)
+			if err != nil || hasReturn {
+				return retVal, err
+			}
+		}
+		t.Fatal("bytecode did not return a value")
+		return Value{}, nil
+	}
+
+	// new long[2][3][4]
+	got, err := run(0x05, 0x06, 0x07, OpMultianewarray, byte(longs>>8), byte(longs), 3, 0xB0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := got.Ref.(*JArray)
+	if outer.Len() != 2 {
+		t.Fatalf("outer length: got %d", outer.Len())
+	}
+	middle := outer.Get(1).Ref.(*JArray)
+	inner := middle.Get(2).Ref.(*JArray)
+	if middle.Len() != 3 || inner.Len() != 4 || inner.Get(3).Type != TypeLong {
+		t.Errorf("long[2][3][4]: got %+v", inner)
+	}
+	if outer.Get(0).Ref == outer.Get(1).Ref {
+		t.Error("sub-arrays must be distinct")
+	}
+
+	// new long[2][3][], leaving the last dimension unallocated
+	got, err = run(0x05, 0x06, OpMultianewarray, byte(longs>>8), byte(longs), 2, 0xB0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := got.Ref.(*JArray).Get(0).Ref.(*JArray).Get(0); leaf.Type != TypeNull {
+		t.Errorf("long[2][3][]: got leaf %+v, want null", leaf)
+	}
+
+	// new String[1][2] holds nulls
+	got, err = run(0x04, 0x05, OpMultianewarray, byte(strs>>8), byte(strs), 2, 0xB0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := got.Ref.(*JArray).Get(0).Ref.(*JArray).Get(1); leaf.Type != TypeNull {
+		t.Errorf("String[1][2]: got leaf %+v, want null", leaf)
+	}
+
+	// new long[0][-1][2] throws although the first dimension is empty
+	_, err = run(0x03, 0x02, 0x05, OpMultianewarray, byte(longs>>8), byte(longs), 3, 0xB0)
+	if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/NegativeArraySizeException" {
+		t.Errorf("negative size: got %v", err)
+	}
+}
+
 func TestAaloadAastore(t *testing.T) {
 	v := &VM{Stdout: io.Discard}
 
@@ -916,6 +1090,89 @@ func TestAaloadAastore(t *testing.T) {
 		}
 		t.Fatal("bytecode did not return a value")
 	})
+
+	t.Run("store incompatible element", func(t *testing.T) {
+		// Object[] objs = new String[1]; objs[0] = new Object();
+		arr := NewArray("Ljava/lang/String;", 1)
+		code := []byte{0x2A, 0x03, 0x2B, OpAastore, 0xB1} // aload_0; iconst_0; aload_1; aastore; return
+		frame := NewFrame(4, 10, code, nil)
+		frame.SetLocal(0, RefValue(arr))
+		frame.SetLocal(1, RefValue(&JObject{ClassName: "java/lang/Object", Fields: map[string]Value{}}))
+		for frame.PC < len(frame.Code) {
+			opcode := frame.Code[frame.PC]
+			frame.PC++
+			if _, _, err := v.executeInstruction(frame, opcode); err != nil {
+				if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/ArrayStoreException" {
+					t.Fatalf("got %v, want ArrayStoreException", err)
+				}
+				if arr.Elements[0].Type != TypeNull {
+					t.Errorf("element stored despite the exception: %+v", arr.Elements[0])
+				}
+				return
+			}
+		}
+		t.Fatal("aastore of an Object into a String[] succeeded")
+	})
+}
+
+func TestWideAndShortArrays(t *testing.T) {
+	tests := []struct {
+		name      string
+		atype     byte
+		push      []byte // pushes the value to store
+		load      byte
+		store     byte
+		ret       byte
+		check     func(Value) bool
+		component string
+	}{
+		{"long", 11, []byte{0x0A}, OpLaload, OpLastore, OpLreturn, func(v Value) bool { return v.Long == 1 }, "J"},
+		{"float", 6, []byte{0x0D}, OpFaload, OpFastore, OpFreturn, func(v Value) bool { return v.Float == 2 }, "F"},
+		{"double", 7, []byte{0x0F}, OpDaload, OpDastore, OpDreturn, func(v Value) bool { return v.Double == 1 }, "D"},
+		// 0x1234 << 4 does not fit in a short and is truncated to 0x2340.
+		{"short", 9, []byte{OpSipush, 0x12, 0x34, 0x07, OpIshl}, OpSaload, OpSastore, OpIreturn, func(v Value) bool { return v.Int == 0x2340 }, "S"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// a = new T[2]; a[1] = value; return a[1]
+			code := []byte{0x05, OpNewarray, tt.atype, 0x4B, 0x2A, 0x04}
+			code = append(code, tt.push...)
+			code = append(code, tt.store, 0x2A, 0x04, tt.load, tt.ret)
+			frame := NewFrame(4, 10, code, nil)
+			if got := runFrameFrom(t, frame); !tt.check(got) {
+				t.Errorf("got %+v", got)
+			}
+			arr := frame.GetLocal(0).Ref.(*JArray)
+			if arr.Component != tt.component || arr.Len() != 2 {
+				t.Errorf("array: component %q, length %d", arr.Component, arr.Len())
+			}
+			if zero := arr.Get(0); zero.Type != arr.Get(1).Type || zero.Long != 0 || zero.Int != 0 || zero.Float != 0 || zero.Double != 0 {
+				t.Errorf("default element: got %+v", zero)
+			}
+		})
+	}
+
+	// Out-of-range loads and stores to null throw for every variant.
+	v := &VM{Stdout: io.Discard}
+	for _, op := range []byte{OpLaload, OpFaload, OpDaload, OpSaload} {
+		frame := NewFrame(4, 10, []byte{op}, nil)
+		frame.Push(RefValue(NewArray("J", 0)))
+		frame.Push(IntValue(0))
+		frame.PC++
+		if _, _, err := v.executeInstruction(frame, op); !isJavaException(err, "java/lang/ArrayIndexOutOfBoundsException") {
+			t.Errorf("%s: got %v", OpcodeName(op), err)
+		}
+	}
+	for _, op := range []byte{OpLastore, OpFastore, OpDastore, OpSastore} {
+		frame := NewFrame(4, 10, []byte{op}, nil)
+		frame.Push(NullValue())
+		frame.Push(IntValue(0))
+		frame.Push(IntValue(0))
+		frame.PC++
+		if _, _, err := v.executeInstruction(frame, op); !isJavaException(err, "java/lang/NullPointerException") {
+			t.Errorf("%s: got %v", OpcodeName(op), err)
+		}
+	}
 }
 
 func TestIfAcmpne(t *testing.T) {
@@ -1074,6 +1331,89 @@ func TestInstanceof(t *testing.T) {
 	})
 }
 
+func TestCheckcastAndInstanceofTypes(t *testing.T) {
+	classes := mapClassLoader{"java/lang/Object": classfile.NewBuilder("java/lang/Object", "").Build()}
+	shape := classfile.NewBuilder("app/Shape", "java/lang/Object")
+	shape.SetAccessFlags(classfile.AccPublic | AccInterface | classfile.AccAbstract)
+	classes["app/Shape"] = shape.Build()
+	circle := classfile.NewBuilder("app/Circle", "java/lang/Object")
+	circle.AddInterface("app/Shape")
+	classes["app/Circle"] = circle.Build()
+	v := NewVM(classes)
+
+	strs := NewArray("Ljava/lang/String;", 1)
+	ints := NewArray("I", 1)
+	matrix := NewArray("[I", 1)
+	lambda := &JObject{ClassName: "app/Shape", Fields: map[string]Value{}, LambdaTarget: &LambdaTarget{InterfaceName: "app/Shape"}}
+	obj := &JObject{ClassName: "app/Circle", Fields: map[string]Value{}}
+	tests := []struct {
+		name   string
+		value  Value
+		class  string
+		isInst bool
+	}{
+		{"string as String", RefValue("s"), "java/lang/String", true},
+		{"string as CharSequence", RefValue("s"), "java/lang/CharSequence", true},
+		{"string as Integer", RefValue("s"), "java/lang/Integer", false},
+		{"String[] as Object[]", RefValue(strs), "[Ljava/lang/Object;", true},
+		{"String[] as String[]", RefValue(strs), "[Ljava/lang/String;", true},
+		{"String[] as Integer[]", RefValue(strs), "[Ljava/lang/Integer;", false},
+		{"String[] as Object", RefValue(strs), "java/lang/Object", true},
+		{"String[] as Cloneable", RefValue(strs), "java/lang/Cloneable", true},
+		{"String[] as String", RefValue(strs), "java/lang/String", false},
+		{"int[] as int[]", RefValue(ints), "[I", true},
+		{"int[] as long[]", RefValue(ints), "[J", false},
+		{"int[] as Object[]", RefValue(ints), "[Ljava/lang/Object;", false},
+		{"int[][] as Object[]", RefValue(matrix), "[Ljava/lang/Object;", true},
+		{"lambda as its interface", RefValue(lambda), "app/Shape", true},
+		{"lambda as Object", RefValue(lambda), "java/lang/Object", true},
+		{"lambda as a class", RefValue(lambda), "app/Circle", false},
+		{"object as its interface", RefValue(obj), "app/Shape", true},
+		{"object as Shape[]", RefValue(obj), "[Lapp/Shape;", false},
+	}
+	// ClassCastExceptions name both classes.
+	casts := map[string]string{
+		"string as Integer": "class java.lang.String cannot be cast to class java.lang.Integer " +
+			"(java.lang.String and java.lang.Integer are in unnamed module of loader 'app')",
+		"int[] as long[]": "class [I cannot be cast to class [J ([I and [J are in unnamed module of loader 'app')",
+		"object as Shape[]": "class app.Circle cannot be cast to class [Lapp.Shape; " +
+			"(app.Circle and [Lapp.Shape; are in unnamed module of loader 'app')",
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := classfile.NewBuilder("T", "java/lang/Object")
+			idx := b.Class(tt.class)
+			cf := b.Build()
+
+			frame := NewFrame(1, 2, []byte{OpInstanceof, byte(idx >> 8), byte(idx)}, cf)
+			frame.Push(tt.value)
+			frame.PC++
+			if _, _, err := v.executeInstruction(frame, OpInstanceof); err != nil {
+				t.Fatal(err)
+			}
+			if got := frame.Pop().Int == 1; got != tt.isInst {
+				t.Errorf("instanceof: got %v, want %v", got, tt.isInst)
+			}
+
+			frame = NewFrame(1, 2, []byte{OpCheckcast, byte(idx >> 8), byte(idx)}, cf)
+			frame.Push(tt.value)
+			frame.PC++
+			_, _, err := v.executeInstruction(frame, OpCheckcast)
+			if tt.isInst && err != nil {
+				t.Errorf("checkcast: %v", err)
+			}
+			if !tt.isInst && !isJavaException(err, "java/lang/ClassCastException") {
+				t.Errorf("checkcast: got %v, want ClassCastException", err)
+			}
+			if want, ok := casts[tt.name]; ok {
+				if msg, _ := err.(*JavaException).Message(); msg != want {
+					t.Errorf("checkcast: got message %q, want %q", msg, want)
+				}
+			}
+		})
+	}
+}
+
 func TestLocalVarInstructions(t *testing.T) {
 	t.Run("istore and iload", func(t *testing.T) {
 		// iconst_5, istore_0, iload_0, ireturn -> store 5, load 5, return 5
@@ -1102,3 +1442,501 @@ func TestLocalVarInstructions(t *testing.T) {
 		}
 	})
 }
+
+func TestDoubleInstructions(t *testing.T) {
+	// ldc2_w operands: 1 = 7.5, 3 = -2.0, 5 = NaN, 7 = +Inf, 9 = 1e300
+	cf := &classfile.ClassFile{ConstantPool: []classfile.ConstantPoolEntry{
+		nil,
+		&classfile.ConstantDouble{Value: 7.5}, nil,
+		&classfile.ConstantDouble{Value: -2}, nil,
+		&classfile.ConstantDouble{Value: math.NaN()}, nil,
+		&classfile.ConstantDouble{Value: math.Inf(1)}, nil,
+		&classfile.ConstantDouble{Value: 1e300}, nil,
+	}}
+	ldc := func(index byte) []byte { return []byte{0x14, 0x00, index} }
+	code := func(parts ...[]byte) []byte {
+		var c []byte
+		for _, p := range parts {
+			c = append(c, p...)
+		}
+		return c
+	}
+	dreturn := []byte{0xAF}
+
+	tests := []struct {
+		name string
+		code []byte
+		want float64
+	}{
+		{"dconst_1", code([]byte{0x0F}, dreturn), 1},
+		{"dadd", code(ldc(1), ldc(3), []byte{0x63}, dreturn), 5.5},
+		{"dsub", code(ldc(1), ldc(3), []byte{0x67}, dreturn), 9.5},
+		{"dmul", code(ldc(1), ldc(3), []byte{0x6B}, dreturn), -15},
+		{"ddiv", code(ldc(1), ldc(3), []byte{0x6F}, dreturn), -3.75},
+		{"ddiv by zero", code(ldc(1), []byte{0x0E, 0x6F}, dreturn), math.Inf(1)},
+		{"drem truncates", code(ldc(1), ldc(3), []byte{0x73}, dreturn), 1.5},
+		{"drem keeps dividend sign", code(ldc(3), ldc(1), []byte{0x77, 0x73}, dreturn), -2},
+		{"drem by zero", code(ldc(1), []byte{0x0E, 0x73}, dreturn), math.NaN()},
+		{"drem by infinity", code(ldc(1), ldc(7), []byte{0x73}, dreturn), 7.5},
+		{"dneg zero", code([]byte{0x0E, 0x77}, dreturn), math.Copysign(0, -1)},
+		{"NaN propagates", code(ldc(5), []byte{0x0F, 0x63}, dreturn), math.NaN()},
+		{"dstore/dload", code(ldc(1), []byte{0x48, 0x27}, dreturn), 7.5}, // dstore_1; dload_1
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runFrame(t, &VM{Stdout: io.Discard}, cf, tt.code)
+			if got.Type != TypeDouble || math.Float64bits(got.Double) != math.Float64bits(tt.want) &&
+				!(math.IsNaN(got.Double) && math.IsNaN(tt.want)) {
+				t.Errorf("got %v (%v), want %v", got.Double, got.Type, tt.want)
+			}
+		})
+	}
+
+	conversions := []struct {
+		name string
+		code []byte
+		want int64
+	}{
+		{"d2i NaN", code(ldc(5), []byte{0x8E, 0xAC}), 0},
+		{"d2i saturates", code(ldc(9), []byte{0x8E, 0xAC}), math.MaxInt32},
+		{"d2i saturates negative", code(ldc(7), []byte{0x77, 0x8E, 0xAC}), math.MinInt32},
+		{"d2i truncates", code(ldc(1), []byte{0x77, 0x8E, 0xAC}), -7},
+		{"d2l saturates", code(ldc(7), []byte{0x8F, 0xAD}), math.MaxInt64},
+		{"dcmpl NaN", code(ldc(5), []byte{0x0E, 0x97, 0xAC}), -1},
+		{"dcmpg NaN", code(ldc(5), []byte{0x0E, 0x98, 0xAC}), 1},
+	}
+	for _, tt := range conversions {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runFrame(t, &VM{Stdout: io.Discard}, cf, tt.code)
+			if n := int64(got.Int); got.Type == TypeLong && got.Long != tt.want || got.Type == TypeInt && n != tt.want {
+				t.Errorf("got %+v, want %d", got, tt.want)
+			}
+		})
+	}
+
+	arr := runFrame(t, &VM{Stdout: io.Discard}, cf, []byte{0x04, 0xBC, 0x07, 0xB0}) // iconst_1; newarray double; areturn
+	if e := arr.Ref.(*JArray).Get(0); e.Type != TypeDouble {
+		t.Errorf("new double[] element: got %+v, want 0.0", e)
+	}
+}
+
+func TestLongInstructions(t *testing.T) {
+	// ldc2_w operands: 1 = 7, 3 = -2, 5 = MinInt64, 7 = -1, 9 = 0
+	cf := &classfile.ClassFile{ConstantPool: []classfile.ConstantPoolEntry{
+		nil,
+		&classfile.ConstantLong{Value: 7}, nil,
+		&classfile.ConstantLong{Value: -2}, nil,
+		&classfile.ConstantLong{Value: math.MinInt64}, nil,
+		&classfile.ConstantLong{Value: -1}, nil,
+		&classfile.ConstantLong{Value: 0}, nil,
+	}}
+	op := func(a, b, opcode byte) []byte {
+		return []byte{0x14, 0x00, a, 0x14, 0x00, b, opcode, 0xAD} // ldc2_w a; ldc2_w b; op; lreturn
+	}
+
+	tests := []struct {
+		name string
+		code []byte
+		want int64
+	}{
+		{"lmul", op(1, 3, 0x69), -14},
+		{"lmul overflow wraps", op(5, 7, 0x69), math.MinInt64},
+		{"ldiv truncates", op(1, 3, 0x6D), -3},
+		{"ldiv MinInt64 by -1", op(5, 7, 0x6D), math.MinInt64},
+		{"lrem keeps dividend sign", op(3, 1, 0x71), -2},
+		{"lrem", op(1, 3, 0x71), 1},
+		{"lrem MinInt64 by -1", op(5, 7, 0x71), 0},
+		{"lneg", []byte{0x14, 0x00, 0x01, 0x75, 0xAD}, -7},
+		{"lneg MinInt64", []byte{0x14, 0x00, 0x05, 0x75, 0xAD}, math.MinInt64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runFrame(t, &VM{Stdout: io.Discard}, cf, tt.code); got.Type != TypeLong || got.Long != tt.want {
+				t.Errorf("got %+v, want %d", got, tt.want)
+			}
+		})
+	}
+
+	for _, opcode := range []byte{0x6D, 0x71} { // ldiv, lrem
+		frame := NewFrame(0, 4, op(1, 9, opcode), cf)
+		v := &VM{Stdout: io.Discard}
+		var err error
+		for err == nil && frame.PC < len(frame.Code) {
+			opcode := frame.Code[frame.PC]
+			frame.PC++
+			_, _, err = v.executeInstruction(frame, opcode)
+		}
+		if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/ArithmeticException" ||
+			exc.Object.Fields["detailMessage"].Ref != "/ by zero" {
+			t.Errorf("opcode 0x%02X by zero: got %v", opcode, err)
+		}
+	}
+}
+
+func TestFloatInstructions(t *testing.T) {
+	// ldc operands: 1 = 7.5, 2 = -2, 3 = NaN, 4 = +Inf, 5 = 3e38
+	cf := &classfile.ClassFile{ConstantPool: []classfile.ConstantPoolEntry{
+		nil,
+		&classfile.ConstantFloat{Value: 7.5},
+		&classfile.ConstantFloat{Value: -2},
+		&classfile.ConstantFloat{Value: float32(math.NaN())},
+		&classfile.ConstantFloat{Value: float32(math.Inf(1))},
+		&classfile.ConstantFloat{Value: 3e38},
+	}}
+	op := func(a, b, opcode byte) []byte {
+		return []byte{0x12, a, 0x12, b, opcode, 0xAE} // ldc a; ldc b; op; freturn
+	}
+	negZero := float32(math.Copysign(0, -1))
+
+	tests := []struct {
+		name string
+		code []byte
+		want float32
+	}{
+		{"fadd", op(1, 2, 0x62), 5.5},
+		{"fsub", op(1, 2, 0x66), 9.5},
+		{"fmul", op(1, 2, 0x6A), -15},
+		{"fdiv", op(1, 2, 0x6E), -3.75},
+		{"fdiv by zero", []byte{0x12, 0x02, 0x0B, 0x6E, 0xAE}, float32(math.Inf(-1))},
+		{"fadd overflows to infinity", op(5, 5, 0x62), float32(math.Inf(1))},
+		{"frem truncates", op(1, 2, 0x72), 1.5},
+		{"frem keeps dividend sign", op(2, 1, 0x72), -2},
+		{"frem by zero", []byte{0x12, 0x01, 0x0B, 0x72, 0xAE}, float32(math.NaN())},
+		{"frem of infinity", op(4, 1, 0x72), float32(math.NaN())},
+		{"frem by infinity", op(1, 4, 0x72), 7.5},
+		{"frem of negative zero", []byte{0x0B, 0x76, 0x12, 0x01, 0x72, 0xAE}, negZero},
+		{"fneg zero", []byte{0x0B, 0x76, 0xAE}, negZero},
+		{"fsub zero minus zero", []byte{0x0B, 0x0B, 0x66, 0xAE}, 0},
+		{"NaN propagates", op(3, 1, 0x62), float32(math.NaN())},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runFrame(t, &VM{Stdout: io.Discard}, cf, tt.code)
+			isNaN := func(f float32) bool { return f != f }
+			if got.Type != TypeFloat || math.Float32bits(got.Float) != math.Float32bits(tt.want) && !(isNaN(got.Float) && isNaN(tt.want)) {
+				t.Errorf("got %v (%v), want %v", got.Float, got.Type, tt.want)
+			}
+		})
+	}
+
+	conversions := []struct {
+		name string
+		code []byte
+		want int64
+	}{
+		{"f2i NaN", []byte{0x12, 0x03, 0x8B, 0xAC}, 0},
+		{"f2i saturates", []byte{0x12, 0x05, 0x8B, 0xAC}, math.MaxInt32},
+		{"f2i truncates", []byte{0x12, 0x01, 0x76, 0x8B, 0xAC}, -7},
+		{"f2l saturates", []byte{0x12, 0x04, 0x76, 0x8C, 0xAD}, math.MinInt64},
+	}
+	for _, tt := range conversions {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runFrame(t, &VM{Stdout: io.Discard}, cf, tt.code)
+			if n := int64(got.Int); got.Type == TypeLong && got.Long != tt.want || got.Type == TypeInt && n != tt.want {
+				t.Errorf("got %+v, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConversionInstructions(t *testing.T) {
+	// convert applies opcode to v. ireturn hands back whatever value is on
+	// the stack, so it serves for every result type.
+	convert := func(v Value, opcode byte) Value {
+		t.Helper()
+		frame := NewFrame(1, 2, []byte{0x2A, opcode, 0xAC}, nil) // aload_0; op; ireturn
+		frame.SetLocal(0, v)
+		return runFrameFrom(t, frame)
+	}
+	nan, inf := math.NaN(), math.Inf(1)
+
+	tests := []struct {
+		name   string
+		in     Value
+		opcode byte
+		want   Value
+	}{
+		{"i2l sign-extends", IntValue(-5), 0x85, LongValue(-5)},
+		{"i2f rounds", IntValue(16777217), 0x86, FloatValue(16777216)},
+		{"i2d", IntValue(math.MinInt32), 0x87, DoubleValue(math.MinInt32)},
+		{"l2i truncates", LongValue(1<<32 + 7), 0x88, IntValue(7)},
+		{"l2f rounds", LongValue(1<<53 + 1), 0x89, FloatValue(1 << 53)},
+		{"l2d rounds", LongValue(1<<53 + 1), 0x8A, DoubleValue(1 << 53)},
+		{"f2i NaN", FloatValue(float32(nan)), 0x8B, IntValue(0)},
+		{"f2i -Inf", FloatValue(float32(-inf)), 0x8B, IntValue(math.MinInt32)},
+		{"f2l truncates", FloatValue(-2.9), 0x8C, LongValue(-2)},
+		{"f2l NaN", FloatValue(float32(nan)), 0x8C, LongValue(0)},
+		{"f2d", FloatValue(0.5), 0x8D, DoubleValue(0.5)},
+		{"d2i +Inf", DoubleValue(inf), 0x8E, IntValue(math.MaxInt32)},
+		{"d2i -1e10", DoubleValue(-1e10), 0x8E, IntValue(math.MinInt32)},
+		{"d2l -Inf", DoubleValue(-inf), 0x8F, LongValue(math.MinInt64)},
+		{"d2l 2^63", DoubleValue(1 << 63), 0x8F, LongValue(math.MaxInt64)},
+		{"d2l NaN", DoubleValue(nan), 0x8F, LongValue(0)},
+		{"d2f overflows to infinity", DoubleValue(1e300), 0x90, FloatValue(float32(inf))},
+		{"d2f underflows to zero", DoubleValue(1e-300), 0x90, FloatValue(0)},
+		{"i2b", IntValue(0x1FF), 0x91, IntValue(-1)},
+		{"i2c zero-extends", IntValue(-1), 0x92, IntValue(0xFFFF)},
+		{"i2s", IntValue(0x18000), 0x93, IntValue(-32768)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := convert(tt.in, tt.opcode)
+			if got.Type != tt.want.Type || got.Int != tt.want.Int || got.Long != tt.want.Long ||
+				math.Float32bits(got.Float) != math.Float32bits(tt.want.Float) ||
+				math.Float64bits(got.Double) != math.Float64bits(tt.want.Double) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+	if got := convert(DoubleValue(nan), 0x90); got.Float == got.Float {
+		t.Errorf("d2f NaN: got %v", got.Float)
+	}
+}
+
+func TestDoubleComparisons(t *testing.T) {
+	// compare runs dload_0; dload_2; then tail with d1 and d2 in locals.
+	compare := func(d1, d2 float64, tail ...byte) int32 {
+		t.Helper()
+		frame := NewFrame(4, 4, append([]byte{0x26, 0x28}, tail...), nil)
+		frame.SetLocal(0, DoubleValue(d1))
+		frame.SetLocal(2, DoubleValue(d2))
+		return runFrameFrom(t, frame).Int
+	}
+	nan, inf, negZero := math.NaN(), math.Inf(1), math.Copysign(0, -1)
+
+	tests := []struct {
+		d1, d2       float64
+		dcmpl, dcmpg int32
+	}{
+		{1, 2, -1, -1},
+		{2, 1, 1, 1},
+		{1.5, 1.5, 0, 0},
+		{0, negZero, 0, 0},
+		{-inf, inf, -1, -1},
+		{nan, 1, -1, 1},
+		{1, nan, -1, 1},
+		{nan, nan, -1, 1},
+	}
+	for _, tt := range tests {
+		if got := compare(tt.d1, tt.d2, 0x97, 0xAC); got != tt.dcmpl {
+			t.Errorf("dcmpl(%v, %v) = %d, want %d", tt.d1, tt.d2, got, tt.dcmpl)
+		}
+		if got := compare(tt.d1, tt.d2, 0x98, 0xAC); got != tt.dcmpg {
+			t.Errorf("dcmpg(%v, %v) = %d, want %d", tt.d1, tt.d2, got, tt.dcmpg)
+		}
+	}
+
+	// javac compiles d1 < d2 to dcmpg; ifge and d1 > d2 to dcmpl; ifle, so
+	// that both are false when either operand is NaN.
+	less := []byte{0x98, 0x9C, 0x00, 0x05, 0x04, 0xAC, 0x03, 0xAC}    // dcmpg; ifge +5; iconst_1; ireturn; iconst_0; ireturn
+	greater := []byte{0x97, 0x9E, 0x00, 0x05, 0x04, 0xAC, 0x03, 0xAC} // dcmpl; ifle +5; ...
+	for _, tt := range []struct {
+		d1, d2        float64
+		less, greater int32
+	}{
+		{1, 2, 1, 0},
+		{2, 1, 0, 1},
+		{nan, 1, 0, 0},
+		{1, nan, 0, 0},
+	} {
+		if got := compare(tt.d1, tt.d2, less...); got != tt.less {
+			t.Errorf("%v < %v: got %d", tt.d1, tt.d2, got)
+		}
+		if got := compare(tt.d1, tt.d2, greater...); got != tt.greater {
+			t.Errorf("%v > %v: got %d", tt.d1, tt.d2, got)
+		}
+	}
+}
+
+func TestCategoryTwoValues(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		want int64
+	}{
+		{"pop2 long", []byte{0x08, 0x0A, 0x58, 0xAC}, 5},                       // iconst_5; lconst_1; pop2; ireturn
+		{"pop2 two ints", []byte{0x08, 0x04, 0x05, 0x58, 0xAC}, 5},             // iconst_5; iconst_1; iconst_2; pop2; ireturn
+		{"dup2 long", []byte{0x0A, 0x5C, 0x61, 0xAD}, 2},                       // lconst_1; dup2; ladd; lreturn
+		{"dup2 two ints", []byte{0x04, 0x05, 0x5C, 0x60, 0x60, 0x60, 0xAC}, 6}, // 1 2 1 2 summed
+		{"dup_x2 over long", []byte{0x0A, 0x05, 0x5B, 0x57, 0x58, 0xAC}, 2},    // lconst_1; iconst_2; dup_x2; pop; pop2; ireturn
+		{"dup_x2 over ints", []byte{0x04, 0x05, 0x06, 0x5B, 0x60, 0x60, 0x60, 0xAC}, 9},
+		{"dup2_x1 long over int", []byte{0x05, 0x0A, 0x5D, 0x58, 0x57, 0xAD}, 1},                   // iconst_2; lconst_1; dup2_x1; pop2; pop; lreturn
+		{"dup2_x1 ints over int", []byte{0x04, 0x05, 0x06, 0x5D, 0x64, 0x60, 0x60, 0x60, 0xAC}, 5}, // 1 2 3 -> 2 3 1 2 3; isub; sum
+		{"dup2_x2 long over long", []byte{0x0A, 0x09, 0x5E, 0x58, 0x58, 0xAD}, 0},                  // lconst_1; lconst_0; dup2_x2; pop2; pop2; lreturn
+		{"dup2_x2 long over ints", []byte{0x04, 0x05, 0x0A, 0x5E, 0x58, 0x64, 0x85, 0xAD}, -1},     // 1 2 L -> L 1 2 L; pop2; isub; i2l
+		{"dup2_x2 ints over long", []byte{0x0A, 0x05, 0x06, 0x5E, 0x60, 0x85, 0x61, 0xAD}, 6},      // L 2 3 -> 2 3 L 2 3; iadd; i2l; ladd
+		{"dup2_x2 ints over ints", []byte{0x04, 0x05, 0x06, 0x07, 0x5E, 0x60, 0x60, 0x60, 0x60, 0x60, 0xAC}, 17},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := runFrameFrom(t, NewFrame(0, 6, tt.code, nil))
+			if n := int64(got.Int); got.Type == TypeLong && got.Long != tt.want || got.Type == TypeInt && n != tt.want {
+				t.Errorf("got %+v, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// static int mix(long a, int b, double c, int d) { long e = a + b; return (int) e + (int) c + d; }
+	// a is in slots 0-1, b in 2, c in 3-4, d in 5 and e in 6-7.
+	b := classfile.NewBuilder("Mix", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "mix", "(JIDI)I", &classfile.CodeAttribute{
+		MaxStack:  4,
+		MaxLocals: 8,
+		Code: []byte{
+			0x1E, 0x1C, 0x85, 0x61, // lload_0; iload_2; i2l; ladd
+			0x37, 0x06, // lstore 6
+			0x16, 0x06, 0x88, // lload 6; l2i
+			0x29, 0x8E, 0x60, // dload_3; d2i; iadd
+			0x15, 0x05, 0x60, // iload 5; iadd
+			0xAC, // ireturn
+		},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Mix": cf})
+	v.Stdout = io.Discard
+	got, err := v.executeMethod(cf, cf.FindMethod("mix", "(JIDI)I"), []Value{LongValue(1 << 33), IntValue(2), DoubleValue(3.5), IntValue(4)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != 9 { // int truncation drops 1<<33
+		t.Errorf("mix: got %d, want 9", got.Int)
+	}
+}
+
+func TestWideInstructions(t *testing.T) {
+	b := classfile.NewBuilder("Wide", "java/lang/Object")
+	x := b.String("x")
+	cf := b.Build()
+
+	tests := []struct {
+		name  string
+		code  []byte
+		check func(Value) bool
+	}{
+		{"wide istore/iinc/iload", []byte{
+			0x11, 0x03, 0xE8, // sipush 1000
+			0xC4, 0x36, 0x01, 0x2C, // wide istore 300
+			0xC4, 0x84, 0x01, 0x2C, 0x80, 0x00, // wide iinc 300 -32768
+			0xC4, 0x15, 0x01, 0x2C, 0xAC, // wide iload 300; ireturn
+		}, func(v Value) bool { return v.Type == TypeInt && v.Int == -31768 }},
+		{"wide lstore/lload", []byte{0x0A, 0xC4, 0x37, 0x01, 0x2A, 0xC4, 0x16, 0x01, 0x2A, 0xAD}, // lconst_1; slot 298
+			func(v Value) bool { return v.Type == TypeLong && v.Long == 1 }},
+		{"wide fstore/fload", []byte{0x0D, 0xC4, 0x38, 0x01, 0x00, 0xC4, 0x17, 0x01, 0x00, 0xAE}, // fconst_2; slot 256
+			func(v Value) bool { return v.Type == TypeFloat && v.Float == 2 }},
+		{"wide dstore/dload", []byte{0x0F, 0xC4, 0x39, 0x01, 0x02, 0xC4, 0x18, 0x01, 0x02, 0xAF}, // dconst_1; slot 258
+			func(v Value) bool { return v.Type == TypeDouble && v.Double == 1 }},
+		{"wide astore/aload", []byte{0x12, byte(x), 0xC4, 0x3A, 0x01, 0x10, 0xC4, 0x19, 0x01, 0x10, 0xB0}, // ldc "x"; slot 272
+			func(v Value) bool { return v.Ref == "x" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runFrameFrom(t, NewFrame(400, 4, tt.code, cf)); !tt.check(got) {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
+
+func TestSubroutines(t *testing.T) {
+	cf := classfile.NewBuilder("Subroutines", "java/lang/Object").Build()
+
+	tests := []struct {
+		name string
+		code []byte
+		want int32
+	}{
+		{"jsr/ret", []byte{
+			0x03, 0x3C, // iconst_0; istore_1
+			0xA8, 0x00, 0x08, // jsr +8 (pc 10)
+			0x1B, 0xAC, // iload_1; ireturn
+			0x00, 0x00, 0x00,
+			0x4D,             // astore_2
+			0x84, 0x01, 0x05, // iinc 1 5
+			0xA9, 0x02, // ret 2
+		}, 5},
+		{"jsr_w/wide ret", []byte{
+			0xC9, 0x00, 0x00, 0x00, 0x09, // jsr_w +9 (pc 9)
+			0x1B, 0xAC, // iload_1; ireturn
+			0x00, 0x00,
+			0xC4, 0x3A, 0x01, 0x00, // wide astore 256
+			0x10, 0x07, 0x3C, // bipush 7; istore_1
+			0xC4, 0xA9, 0x01, 0x00, // wide ret 256
+		}, 7},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runFrameFrom(t, NewFrame(400, 4, tt.code, cf)); got.Type != TypeInt || got.Int != tt.want {
+				t.Errorf("got %+v, want %d", got, tt.want)
+			}
+		})
+	}
+
+	frame := NewFrame(1, 1, nil, nil)
+	frame.SetLocal(0, IntValue(3))
+	if _, _, err := (&VM{}).executeRet(frame, 0); err == nil {
+		t.Error("ret through an int local: expected an error")
+	}
+}
+
+// A try/finally as javac emitted it before 1.6, with the finally block as
+// a subroutine called from both the normal and the exceptional path.
+func TestLegacyFinallySubroutine(t *testing.T) {
+	b := classfile.NewBuilder("Legacy", "java/lang/Object")
+	b.AddField(classfile.AccStatic, "finallies", "I", nil)
+	fin := b.Fieldref("Legacy", "finallies", "I")
+	b.AddMethod(classfile.AccStatic, "divide", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 4,
+		Code: []byte{
+			0x03, 0x3C, // iconst_0; istore_1
+			0x10, 0x0A, 0x1A, 0x6C, 0x3C, // bipush 10; iload_0; idiv; istore_1
+			0xA8, 0x00, 0x0B, // jsr 18
+			0x1B, 0xAC, // iload_1; ireturn
+			0x4D,             // 12: astore_2 (any exception)
+			0xA8, 0x00, 0x05, // jsr 18
+			0x2C, 0xBF, // aload_2; athrow
+			0x4E,                            // 18: astore_3
+			0xB2, byte(fin >> 8), byte(fin), // getstatic finallies
+			0x04, 0x60, // iconst_1; iadd
+			0xB3, byte(fin >> 8), byte(fin), // putstatic finallies
+			0xA9, 0x03, // ret 3
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 2, EndPC: 7, HandlerPC: 12}},
+	})
+	cf := b.Build()
+	cf.MajorVersion = 48
+	v := NewVM(mapClassLoader{"Legacy": cf})
+	v.Stdout = io.Discard
+	method := cf.FindMethod("divide", "(I)I")
+
+	got, err := v.executeMethod(cf, method, []Value{IntValue(5)})
+	if err != nil || got.Int != 2 {
+		t.Fatalf("divide(5): got %v, %v", got.Int, err)
+	}
+	_, err = v.executeMethod(cf, method, []Value{IntValue(0)})
+	if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/ArithmeticException" {
+		t.Fatalf("divide(0): got %v, want ArithmeticException", err)
+	}
+	if n := v.getStaticField("Legacy", "finallies").Int; n != 2 {
+		t.Errorf("finally ran %d times, want 2", n)
+	}
+}
+
+// runFrameFrom executes a prepared frame until it returns.
+func runFrameFrom(t *testing.T, frame *Frame) Value {
+	t.Helper()
+	v := &VM{Stdout: io.Discard}
+	for frame.PC < len(frame.Code) {
+		opcode := frame.Code[frame.PC]
+		frame.PC++
+		retVal, hasReturn, err := v.executeInstruction(frame, opcode)
+		if err != nil {
+			t.Fatalf("execution error at PC=%d: %v", frame.PC-1, err)
+		}
+		if hasReturn {
+			return retVal
+		}
+	}
+	t.Fatal("bytecode did not return a value")
+	return Value{}
+}
@@ -0,0 +1,197 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Method handle reference kinds (JVMS §5.4.3.5) used by lambda targets.
+const (
+	refInvokeVirtual    = 5
+	refInvokeStatic     = 6
+	refInvokeSpecial    = 7
+	refNewInvokeSpecial = 8
+	refInvokeInterface  = 9
+)
+
+// invokeLambda calls the implementation method of a lambda proxy. The
+// captured arguments come first, followed by the arguments of the
+// functional interface method described by samDesc. Each argument is
+// adapted to the implementation's parameter type (widening, boxing and
+// unboxing, as LambdaMetafactory does), and the result is adapted to the
+// return type of samDesc.
+func (vm *VM) invokeLambda(lt *LambdaTarget, samDesc string, args []Value) (Value, error) {
+	fullArgs := make([]Value, 0, len(lt.CapturedArgs)+len(args))
+	fullArgs = append(fullArgs, lt.CapturedArgs...)
+	fullArgs = append(fullArgs, args...)
+
+	implSig, err := classfile.ParseMethodSignature(lt.TargetDesc)
+	if err != nil {
+		return Value{}, fmt.Errorf("lambda target %s.%s: %w", lt.TargetClass, lt.TargetMethod, err)
+	}
+
+	// Instance method handles take the receiver as their first argument.
+	hasReceiver := lt.ReferenceKind == refInvokeVirtual || lt.ReferenceKind == refInvokeInterface || lt.ReferenceKind == refInvokeSpecial
+	params := fullArgs
+	if hasReceiver {
+		if len(fullArgs) == 0 {
+			return Value{}, fmt.Errorf("lambda target %s.%s: missing receiver", lt.TargetClass, lt.TargetMethod)
+		}
+		params = fullArgs[1:]
+	}
+	if len(params) != len(implSig.Params) {
+		return Value{}, fmt.Errorf("lambda target %s.%s%s: got %d arguments, want %d",
+			lt.TargetClass, lt.TargetMethod, lt.TargetDesc, len(params), len(implSig.Params))
+	}
+	for i, p := range implSig.Params {
+		params[i] = vm.adaptValue(params[i], p.Kind)
+	}
+
+	var retVal Value
+	switch lt.ReferenceKind {
+	case refNewInvokeSpecial:
+		// Constructor reference (Foo::new)
+		if err := vm.ensureInitialized(lt.TargetClass); err != nil {
+			return Value{}, err
+		}
+		obj := RefValue(&JObject{ClassName: lt.TargetClass, Fields: make(map[string]Value)})
+		cf, method, err := vm.resolveMethod(lt.TargetClass, "<init>", lt.TargetDesc)
+		if err != nil {
+			return Value{}, err
+		}
+		if _, err := vm.executeMethod(cf, method, append([]Value{obj}, params...)); err != nil {
+			return Value{}, err
+		}
+		retVal = obj
+	case refInvokeVirtual, refInvokeInterface:
+		retVal, err = vm.invokeLambdaVirtual(lt, fullArgs[0], params)
+		if err != nil {
+			return Value{}, err
+		}
+	default:
+		cf, method, err := vm.resolveMethod(lt.TargetClass, lt.TargetMethod, lt.TargetDesc)
+		if err != nil {
+			return Value{}, err
+		}
+		retVal, err = vm.executeMethod(cf, method, fullArgs)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	if isVoidReturn(samDesc) {
+		return Value{}, nil
+	}
+	samSig, err := classfile.ParseMethodSignature(samDesc)
+	if err != nil {
+		return Value{}, err
+	}
+	switch samSig.Return.Kind {
+	case 'L', 'T', '[':
+		// Box by the implementation's return type: a boolean or char
+		// result is an int Value like any other.
+		if wrapper, ok := boxClassNames[implSig.Return.Kind]; ok {
+			return vm.box(wrapper, retVal), nil
+		}
+	}
+	return vm.adaptValue(retVal, samSig.Return.Kind), nil
+}
+
+// invokeLambdaVirtual dispatches an unbound or bound instance method
+// reference (e.g. String::length, obj::method) on the receiver's class.
+func (vm *VM) invokeLambdaVirtual(lt *LambdaTarget, receiver Value, params []Value) (Value, error) {
+	if receiver.Type == TypeNull || receiver.Ref == nil {
+		return Value{}, NewJavaException("java/lang/NullPointerException")
+	}
+	if str, ok := extractGoString(receiver); ok {
+		return vm.handleStringMethod(str, lt.TargetMethod, lt.TargetDesc, params)
+	}
+	className := lt.TargetClass
+	if obj, ok := receiver.Ref.(*JObject); ok {
+		if obj.LambdaTarget != nil && obj.LambdaTarget.MethodName == lt.TargetMethod {
+			return vm.invokeLambda(obj.LambdaTarget, lt.TargetDesc, params)
+		}
+		className = obj.ClassName
+	}
+	cf, method, err := vm.resolveMethod(className, lt.TargetMethod, lt.TargetDesc)
+	if err != nil {
+		cf, method, err = vm.resolveMethod(lt.TargetClass, lt.TargetMethod, lt.TargetDesc)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	return vm.executeMethod(cf, method, append([]Value{receiver}, params...))
+}
+
+// boxClassNames maps primitive descriptors to their wrapper classes.
+var boxClassNames = map[byte]string{
+	'Z': "java/lang/Boolean", 'B': "java/lang/Byte", 'C': "java/lang/Character", 'S': "java/lang/Short",
+	'I': "java/lang/Integer", 'J': "java/lang/Long", 'F': "java/lang/Float", 'D': "java/lang/Double",
+}
+
+// adaptValue converts v to the type denoted by kind (a descriptor's first
+// character): primitives are widened or boxed, and boxed values are
+// unboxed when a primitive is expected.
+func (vm *VM) adaptValue(v Value, kind byte) Value {
+	switch kind {
+	case 'L', 'T', '[':
+		if _, isBox := boxClassNames[primitiveKind(v)]; isBox {
+			return vm.boxValue(v)
+		}
+		return v
+	case 'V':
+		return v
+	}
+	if obj, ok := v.Ref.(*JObject); ok {
+		if inner, ok := obj.Fields["value"]; ok && v.Type == TypeRef {
+			v = inner
+		}
+	}
+	switch kind {
+	case 'J':
+		switch v.Type {
+		case TypeInt:
+			return LongValue(int64(v.Int))
+		}
+	case 'F':
+		switch v.Type {
+		case TypeInt:
+			return FloatValue(float32(v.Int))
+		case TypeLong:
+			return FloatValue(float32(v.Long))
+		}
+	case 'D':
+		switch v.Type {
+		case TypeInt:
+			return DoubleValue(float64(v.Int))
+		case TypeLong:
+			return DoubleValue(float64(v.Long))
+		case TypeFloat:
+			return DoubleValue(float64(v.Float))
+		}
+	}
+	return v
+}
+
+// primitiveKind returns the descriptor character of a primitive value, or 0.
+func primitiveKind(v Value) byte {
+	switch v.Type {
+	case TypeInt:
+		return 'I'
+	case TypeLong:
+		return 'J'
+	case TypeFloat:
+		return 'F'
+	case TypeDouble:
+		return 'D'
+	}
+	return 0
+}
+
+// boxValue wraps a primitive value in its wrapper object, sharing the
+// cached boxes as valueOf does. Sub-int values are boxed as Integer since
+// the Value model does not track them.
+func (vm *VM) boxValue(v Value) Value {
+	return vm.box(boxClassNames[primitiveKind(v)], v)
+}
@@ -0,0 +1,144 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestInvokeLambdaWideCaptures(t *testing.T) {
+	// class L { static double sum(long a, double b, int c) { return a + b + c; } }
+	// Captures (long, double) occupy four local slots; the SAM argument must
+	// still land in slot 4.
+	pool := make([]classfile.ConstantPoolEntry, 3)
+	pool[1] = &classfile.ConstantClass{NameIndex: 2}
+	pool[2] = &classfile.ConstantUtf8{Value: "L"}
+	code := []byte{
+		0x1E,       // lload_0
+		0x8A,       // l2d
+		0x28,       // dload_2
+		0x63,       // dadd
+		0x15, 0x04, // iload 4
+		0x87, // i2d
+		0x63, // dadd
+		0xAF, // dreturn
+	}
+	cf := &classfile.ClassFile{
+		ConstantPool: pool,
+		ThisClass:    1,
+		Methods: []classfile.MethodInfo{{
+			AccessFlags: classfile.AccStatic,
+			Name:        "sum",
+			Descriptor:  "(JDI)D",
+			Code:        &classfile.CodeAttribute{MaxStack: 4, MaxLocals: 5, Code: code},
+		}},
+	}
+	v := NewVM(mapClassLoader{"L": cf})
+	v.Stdout = io.Discard
+
+	lt := &LambdaTarget{
+		MethodName:    "apply",
+		TargetClass:   "L",
+		TargetMethod:  "sum",
+		TargetDesc:    "(JDI)D",
+		CapturedArgs:  []Value{LongValue(40), DoubleValue(1.5)},
+		ReferenceKind: refInvokeStatic,
+	}
+
+	t.Run("boxed argument and boxed result", func(t *testing.T) {
+		// Function<Integer, Object>.apply(Object)
+		arg := v.boxValue(IntValue(2))
+		got, err := v.invokeLambda(lt, "(Ljava/lang/Object;)Ljava/lang/Object;", []Value{arg})
+		if err != nil {
+			t.Fatal(err)
+		}
+		box, ok := got.Ref.(*JObject)
+		if !ok || box.ClassName != "java/lang/Double" || box.Fields["value"].Double != 43.5 {
+			t.Errorf("got %+v, want boxed Double 43.5", got)
+		}
+	})
+
+	t.Run("primitive SAM", func(t *testing.T) {
+		// IntToDoubleFunction.applyAsDouble(int)
+		got, err := v.invokeLambda(lt, "(I)D", []Value{IntValue(3)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != TypeDouble || got.Double != 44.5 {
+			t.Errorf("got %+v, want 44.5", got)
+		}
+	})
+
+	t.Run("argument count mismatch", func(t *testing.T) {
+		if _, err := v.invokeLambda(lt, "()D", nil); err == nil {
+			t.Error("expected an error for a missing argument")
+		}
+	})
+}
+
+func TestInvokeLambdaUnboundReceiver(t *testing.T) {
+	// Function<String, Integer> f = String::length
+	v := &VM{Stdout: io.Discard}
+	lt := &LambdaTarget{
+		MethodName:    "apply",
+		TargetClass:   "java/lang/String",
+		TargetMethod:  "length",
+		TargetDesc:    "()I",
+		ReferenceKind: refInvokeVirtual,
+	}
+	got, err := v.invokeLambda(lt, "(Ljava/lang/Object;)Ljava/lang/Object;", []Value{RefValue("gojvm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if box, ok := got.Ref.(*JObject); !ok || box.Fields["value"].Int != 5 {
+		t.Errorf("got %+v, want boxed 5", got)
+	}
+
+	if _, err := v.invokeLambda(lt, "(Ljava/lang/Object;)Ljava/lang/Object;", []Value{NullValue()}); err == nil {
+		t.Error("expected NullPointerException for a null receiver")
+	}
+}
+
+func TestInvokeLambdaBoxesByReturnType(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	for _, tt := range []struct {
+		method, desc string
+		args         []Value
+		wrapper      string
+		want         int32
+	}{
+		// Function<String, Boolean> f = String::isEmpty
+		{"isEmpty", "()Z", []Value{RefValue("")}, "java/lang/Boolean", 1},
+		// BiFunction<String, Integer, Character> f = String::charAt
+		{"charAt", "(I)C", []Value{RefValue("gojvm"), v.boxValue(IntValue(2))}, "java/lang/Character", 'j'},
+		{"length", "()I", []Value{RefValue("gojvm")}, "java/lang/Integer", 5},
+	} {
+		lt := &LambdaTarget{
+			MethodName:    "apply",
+			TargetClass:   "java/lang/String",
+			TargetMethod:  tt.method,
+			TargetDesc:    tt.desc,
+			ReferenceKind: refInvokeVirtual,
+		}
+		samDesc := "(Ljava/lang/Object;)Ljava/lang/Object;"
+		if len(tt.args) == 2 {
+			samDesc = "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;"
+		}
+		got, err := v.invokeLambda(lt, samDesc, tt.args)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.method, err)
+		}
+		if box, ok := got.Ref.(*JObject); !ok || box.ClassName != tt.wrapper || box.Fields["value"].Int != tt.want {
+			t.Errorf("%s: got %+v, want %s %d", tt.method, got.Ref, tt.wrapper, tt.want)
+		}
+	}
+
+	// Boxes are shared as Boolean.valueOf and Character.valueOf share them.
+	lt := &LambdaTarget{TargetClass: "java/lang/String", TargetMethod: "isEmpty", TargetDesc: "()Z", ReferenceKind: refInvokeVirtual}
+	a, _ := v.invokeLambda(lt, "(Ljava/lang/Object;)Ljava/lang/Object;", []Value{RefValue("x")})
+	b, _ := v.invokeLambda(lt, "(Ljava/lang/Object;)Ljava/lang/Object;", []Value{RefValue("y")})
+	if a.Ref != b.Ref || a.Ref != v.box("java/lang/Boolean", boolValue(false)).Ref {
+		t.Error("Boolean.FALSE not shared")
+	}
+}
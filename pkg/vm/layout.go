@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Objects are laid out like HotSpot with compressed class pointers: a
+// 12-byte header followed by the fields, each aligned to its size, with
+// superclass fields first so that a field keeps its offset in every
+// subclass. Arrays have a 16-byte header and elements of their natural
+// size. References take 4 bytes, as with compressed oops.
+//
+// The layout is the single source of offsets for Unsafe.objectFieldOffset,
+// staticFieldOffset, arrayBaseOffset and arrayIndexScale, and for every
+// Unsafe access that consumes them (including the JDK's VarHandle and
+// atomic classes, which are built on Unsafe). Objects still store fields
+// by name; an offset only ever selects which field is meant.
+const (
+	objectHeaderSize = 12
+	arrayBaseOffset  = 16
+	referenceSize    = 4
+
+	// Static fields live in the Class mirror returned by staticFieldBase,
+	// at offsets above every instance field of java/lang/Class itself.
+	staticFieldOffsetBase = 1 << 20
+)
+
+// classLayout maps the fields of one class to their offsets.
+type classLayout struct {
+	offsets       map[string]int64 // instance field name -> offset, inherited fields included
+	fields        map[int64]string // offset -> instance field name
+	staticOffsets map[string]int64 // static field name -> offset
+	staticFields  map[int64]string // offset -> static field name
+	size          int64            // instance size without trailing padding
+}
+
+// layoutOf returns the field layout of className, computing it on first
+// use. Classes that cannot be loaded get an empty layout.
+func (vm *VM) layoutOf(className string) *classLayout {
+	if l, ok := vm.layouts[className]; ok {
+		return l
+	}
+	if vm.layouts == nil {
+		vm.layouts = make(map[string]*classLayout)
+	}
+	l := &classLayout{
+		offsets:       make(map[string]int64),
+		fields:        make(map[int64]string),
+		staticOffsets: make(map[string]int64),
+		staticFields:  make(map[int64]string),
+		size:          objectHeaderSize,
+	}
+	cf, err := vm.ClassLoader.LoadClass(className)
+	if err != nil {
+		vm.layouts[className] = l
+		return l
+	}
+	if super := cf.SuperClassName(); super != "" {
+		parent := vm.layoutOf(super)
+		for name, off := range parent.offsets {
+			l.offsets[name] = off
+			l.fields[off] = name
+		}
+		l.size = parent.size
+	}
+	static := int64(staticFieldOffsetBase)
+	for _, f := range cf.Fields {
+		size := fieldSize(f.Descriptor)
+		if f.AccessFlags&classfile.AccStatic != 0 {
+			static = alignUp(static, size)
+			l.staticOffsets[f.Name] = static
+			l.staticFields[static] = f.Name
+			static += size
+			continue
+		}
+		off := alignUp(l.size, size)
+		l.offsets[f.Name] = off
+		l.fields[off] = f.Name
+		l.size = off + size
+	}
+	vm.layouts[className] = l
+	return l
+}
+
+// fieldSize returns the size in bytes of a field or array element of the
+// given descriptor.
+func fieldSize(descriptor string) int64 {
+	switch descriptor {
+	case "Z", "B":
+		return 1
+	case "C", "S":
+		return 2
+	case "I", "F":
+		return 4
+	case "J", "D":
+		return 8
+	}
+	return referenceSize
+}
+
+func alignUp(off, align int64) int64 {
+	return (off + align - 1) / align * align
+}
+
+// arrayIndexScale returns the element size of an array class such as "[I".
+func arrayIndexScale(arrayClass string) int64 {
+	if len(arrayClass) < 2 || arrayClass[0] != '[' {
+		return 0
+	}
+	return fieldSize(arrayClass[1:])
+}
+
+// objectFieldOffset returns the offset of an instance field, or -1 if the
+// class has no such field.
+func (vm *VM) objectFieldOffset(className, fieldName string) int64 {
+	if off, ok := vm.layoutOf(className).offsets[fieldName]; ok {
+		return off
+	}
+	return -1
+}
+
+// staticFieldOffset returns the offset of a static field in its class
+// mirror, or -1 if the class declares no such field.
+func (vm *VM) staticFieldOffset(className, fieldName string) int64 {
+	if off, ok := vm.layoutOf(className).staticOffsets[fieldName]; ok {
+		return off
+	}
+	return -1
+}
+
+// reflectedField returns the declaring class and name of a
+// java/lang/reflect/Field object.
+func reflectedField(field Value) (className, fieldName string, ok bool) {
+	obj, isObj := field.Ref.(*JObject)
+	if !isObj {
+		return "", "", false
+	}
+	fieldName, _ = extractGoString(obj.Fields["name"])
+	return classObjectName(obj.Fields["clazz"]), fieldName, fieldName != ""
+}
@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestFieldLayout(t *testing.T) {
+	base := classfile.NewBuilder("Base", "java/lang/Object")
+	base.AddField(0, "a", "I", nil)
+	base.AddField(0, "b", "J", nil)
+	derived := classfile.NewBuilder("Derived", "Base")
+	derived.AddField(0, "c", "B", nil)
+	derived.AddField(classfile.AccStatic, "s", "I", nil)
+	derived.AddField(0, "d", "Ljava/lang/Object;", nil)
+	v := NewVM(mapClassLoader{"Base": base.Build(), "Derived": derived.Build()})
+	v.Stdout = io.Discard
+
+	for _, tt := range []struct {
+		class, field string
+		want         int64
+	}{
+		{"Base", "a", 12},
+		{"Base", "b", 16},
+		{"Derived", "a", 12}, // inherited fields keep their offsets
+		{"Derived", "b", 16},
+		{"Derived", "c", 24},
+		{"Derived", "d", 28},
+		{"Derived", "s", -1}, // static
+		{"Base", "c", -1},
+	} {
+		if got := v.objectFieldOffset(tt.class, tt.field); got != tt.want {
+			t.Errorf("objectFieldOffset(%s.%s) = %d, want %d", tt.class, tt.field, got, tt.want)
+		}
+	}
+	if got := v.staticFieldOffset("Derived", "s"); got < staticFieldOffsetBase {
+		t.Errorf("staticFieldOffset(Derived.s) = %d, want >= %d", got, staticFieldOffsetBase)
+	}
+
+	unsafe := RefValue(&JObject{ClassName: unsafeClass, Fields: map[string]Value{}})
+	call := func(method, desc string, args ...Value) Value {
+		t.Helper()
+		got, err := v.executeNativeMethod(unsafeClass, method, desc, append([]Value{unsafe}, args...))
+		if err != nil {
+			t.Fatalf("%s%s: %v", method, desc, err)
+		}
+		return got
+	}
+
+	t.Run("arrays", func(t *testing.T) {
+		base := call("arrayBaseOffset0", "(Ljava/lang/Class;)I", v.classObject("[J")).Int
+		scale := call("arrayIndexScale0", "(Ljava/lang/Class;)I", v.classObject("[J")).Int
+		if base != arrayBaseOffset || scale != 8 {
+			t.Fatalf("long[]: base %d, scale %d", base, scale)
+		}
+		if scale := call("arrayIndexScale0", "(Ljava/lang/Class;)I", v.classObject("[Ljava/lang/String;")).Int; scale != referenceSize {
+			t.Errorf("String[] scale: got %d", scale)
+		}
+		arr := RefValue(&JArray{Elements: []Value{LongValue(0), LongValue(0), LongValue(0)}})
+		off := LongValue(int64(base) + 2*int64(scale))
+		call("putLong", "(Ljava/lang/Object;JJ)V", arr, off, LongValue(42))
+		if got := arr.Ref.(*JArray).Get(2); got.Long != 42 {
+			t.Errorf("element 2: got %d", got.Long)
+		}
+		if _, err := v.executeNativeMethod(unsafeClass, "getLong", "(Ljava/lang/Object;J)J",
+			[]Value{unsafe, arr, LongValue(int64(base) + 3*int64(scale))}); err == nil {
+			t.Error("reading past the end should fail")
+		}
+	})
+
+	t.Run("reflected fields", func(t *testing.T) {
+		field := func(name string) Value {
+			return RefValue(&JObject{ClassName: "java/lang/reflect/Field", Fields: map[string]Value{
+				"clazz": v.classObject("Derived"),
+				"name":  RefValue(name),
+			}})
+		}
+		if off := call("objectFieldOffset0", "(Ljava/lang/reflect/Field;)J", field("d")); off.Long != 28 {
+			t.Errorf("objectFieldOffset0(d) = %d, want 28", off.Long)
+		}
+		off := call("staticFieldOffset0", "(Ljava/lang/reflect/Field;)J", field("s"))
+		mirror := call("staticFieldBase0", "(Ljava/lang/reflect/Field;)Ljava/lang/Object;", field("s"))
+		call("putInt", "(Ljava/lang/Object;JI)V", mirror, off, IntValue(5))
+		if got := v.getStaticField("Derived", "s"); got.Int != 5 {
+			t.Errorf("static field s: got %d, want 5", got.Int)
+		}
+	})
+}
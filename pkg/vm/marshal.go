@@ -0,0 +1,303 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// NativeFunc adapts the Go function fn to a NativeMethod with the given
+// method descriptor, converting arguments and the result between Values
+// and Go types by their descriptors:
+//
+//	Z bool, B int8 or byte, C uint16, S int16, I int32, J int64,
+//	F float32, D float64
+//	java/lang/String  string
+//	boxed primitives  a pointer to the primitive's Go type, nil for null
+//	arrays            a slice of the component's Go type, nil for null
+//	other references  *JObject, nil for null
+//
+// A parameter or result of type Value is passed unconverted, whatever its
+// descriptor. Arrays are copied in and out, so fn's changes to a slice do
+// not reach the Java array. A null argument for a string parameter, or
+// element for a string slice, throws NullPointerException.
+//
+// fn may take a *VM first and then, for instance methods, a parameter for
+// the receiver, which is converted as a java/lang/Object, before the
+// method's parameters. It may return the result, an error, or the result
+// and an error; errors may be *JavaExceptions to throw.
+func NativeFunc(descriptor string, fn interface{}) (NativeMethod, error) {
+	f := reflect.ValueOf(fn)
+	t := f.Type()
+	if t.Kind() != reflect.Func {
+		return nil, fmt.Errorf("native function for %s: %s is not a function", descriptor, t)
+	}
+	params := paramDescriptors(descriptor)
+	ret := descriptor[strings.LastIndexByte(descriptor, ')')+1:]
+
+	in := make([]reflect.Type, t.NumIn())
+	for i := range in {
+		in[i] = t.In(i)
+	}
+	withVM := len(in) > 0 && in[0] == reflect.TypeOf((*VM)(nil))
+	if withVM {
+		in = in[1:]
+	}
+	switch len(in) {
+	case len(params):
+	case len(params) + 1:
+		params = append([]string{"Ljava/lang/Object;"}, params...) // the receiver
+	default:
+		return nil, fmt.Errorf("native function for %s: %s takes %d parameters", descriptor, t, len(in))
+	}
+	toGo := make([]func(Value) (reflect.Value, error), len(in))
+	for i := range in {
+		conv, err := goConverter(params[i], in[i])
+		if err != nil {
+			return nil, fmt.Errorf("native function for %s: parameter %d: %w", descriptor, i+1, err)
+		}
+		toGo[i] = conv
+	}
+
+	out := make([]reflect.Type, t.NumOut())
+	for i := range out {
+		out[i] = t.Out(i)
+	}
+	errType := reflect.TypeOf((*error)(nil)).Elem()
+	withErr := len(out) > 0 && out[len(out)-1] == errType
+	if withErr {
+		out = out[:len(out)-1]
+	}
+	var toJava func(*VM, reflect.Value) Value
+	switch {
+	case len(out) > 1:
+		return nil, fmt.Errorf("native function for %s: %s returns too many results", descriptor, t)
+	case ret == "V" && len(out) == 1:
+		return nil, fmt.Errorf("native function for %s: %s returns a result for a void method", descriptor, t)
+	case ret != "V" && len(out) == 0:
+		return nil, fmt.Errorf("native function for %s: %s returns no result", descriptor, t)
+	case len(out) == 1:
+		conv, err := javaConverter(ret, out[0])
+		if err != nil {
+			return nil, fmt.Errorf("native function for %s: result: %w", descriptor, err)
+		}
+		toJava = conv
+	}
+
+	return func(vm *VM, args []Value) (Value, error) {
+		if len(args) != len(toGo) {
+			return Value{}, fmt.Errorf("native function for %s: got %d arguments, want %d", descriptor, len(args), len(toGo))
+		}
+		goArgs := make([]reflect.Value, 0, len(args)+1)
+		if withVM {
+			goArgs = append(goArgs, reflect.ValueOf(vm))
+		}
+		for i, arg := range args {
+			v, err := toGo[i](arg)
+			if err != nil {
+				return Value{}, err
+			}
+			goArgs = append(goArgs, v)
+		}
+		results := f.Call(goArgs)
+		if withErr {
+			if err, _ := results[len(results)-1].Interface().(error); err != nil {
+				return Value{}, err
+			}
+		}
+		if toJava == nil {
+			return Value{}, nil
+		}
+		return toJava(vm, results[0]), nil
+	}, nil
+}
+
+// RegisterFunc registers the Go function fn, adapted by NativeFunc, as
+// the native method className.methodName with the given descriptor, as
+// RegisterNative does.
+func (vm *VM) RegisterFunc(className, methodName, descriptor string, fn interface{}) error {
+	native, err := NativeFunc(descriptor, fn)
+	if err != nil {
+		return fmt.Errorf("%s.%s: %w", className, methodName, err)
+	}
+	vm.RegisterNative(className, methodName, descriptor, native)
+	return nil
+}
+
+var (
+	valueType   = reflect.TypeOf(Value{})
+	jobjectType = reflect.TypeOf((*JObject)(nil))
+)
+
+// primitiveGoKinds are the Go kinds of the primitive descriptors.
+var primitiveGoKinds = map[byte]reflect.Kind{
+	'Z': reflect.Bool, 'B': reflect.Int8, 'C': reflect.Uint16, 'S': reflect.Int16,
+	'I': reflect.Int32, 'J': reflect.Int64, 'F': reflect.Float32, 'D': reflect.Float64,
+}
+
+// boxedKind returns the primitive descriptor of the wrapper class with
+// field descriptor desc, or 0 if it is not one.
+func boxedKind(desc string) byte {
+	for kind, class := range boxClassNames {
+		if desc == "L"+class+";" {
+			return kind
+		}
+	}
+	return 0
+}
+
+// matchesPrimitive reports whether the Go type t holds the primitive kind.
+func matchesPrimitive(kind byte, t reflect.Type) bool {
+	return t.Kind() == primitiveGoKinds[kind] || kind == 'B' && t.Kind() == reflect.Uint8
+}
+
+// goConverter returns the conversion of Values of field descriptor desc
+// to the Go type t.
+func goConverter(desc string, t reflect.Type) (func(Value) (reflect.Value, error), error) {
+	if t == valueType {
+		return func(v Value) (reflect.Value, error) { return reflect.ValueOf(v), nil }, nil
+	}
+	kind := desc[0]
+	switch {
+	case primitiveGoKinds[kind] != 0:
+		if !matchesPrimitive(kind, t) {
+			break
+		}
+		return func(v Value) (reflect.Value, error) { return primitiveToGo(v, t), nil }, nil
+	case desc == "Ljava/lang/String;" && t.Kind() == reflect.String:
+		return func(v Value) (reflect.Value, error) {
+			s, ok := extractGoString(v)
+			if !ok {
+				return reflect.Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			return reflect.ValueOf(s).Convert(t), nil
+		}, nil
+	case boxedKind(desc) != 0 && t.Kind() == reflect.Ptr && matchesPrimitive(boxedKind(desc), t.Elem()):
+		return func(v Value) (reflect.Value, error) {
+			obj, ok := v.Ref.(*JObject)
+			if !ok {
+				return reflect.Zero(t), nil
+			}
+			p := reflect.New(t.Elem())
+			p.Elem().Set(primitiveToGo(obj.Fields["value"], t.Elem()))
+			return p, nil
+		}, nil
+	case kind == '[' && t.Kind() == reflect.Slice:
+		elem, err := goConverter(desc[1:], t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return func(v Value) (reflect.Value, error) {
+			arr, ok := v.Ref.(*JArray)
+			if !ok {
+				if v.Type == TypeNull || v.Ref == nil {
+					return reflect.Zero(t), nil
+				}
+				return reflect.Value{}, fmt.Errorf("%v is not an array", v)
+			}
+			s := reflect.MakeSlice(t, arr.Len(), arr.Len())
+			for i := 0; i < arr.Len(); i++ {
+				e, err := elem(arr.Get(i))
+				if err != nil {
+					return reflect.Value{}, err
+				}
+				s.Index(i).Set(e)
+			}
+			return s, nil
+		}, nil
+	case kind == 'L' && t == jobjectType:
+		return func(v Value) (reflect.Value, error) {
+			obj, _ := v.Ref.(*JObject)
+			return reflect.ValueOf(obj), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to %s", desc, t)
+}
+
+// javaConverter returns the conversion of the Go type t to Values of
+// field descriptor desc.
+func javaConverter(desc string, t reflect.Type) (func(*VM, reflect.Value) Value, error) {
+	if t == valueType {
+		return func(_ *VM, v reflect.Value) Value { return v.Interface().(Value) }, nil
+	}
+	kind := desc[0]
+	switch {
+	case primitiveGoKinds[kind] != 0:
+		if !matchesPrimitive(kind, t) {
+			break
+		}
+		return func(_ *VM, v reflect.Value) Value { return primitiveToJava(v) }, nil
+	case desc == "Ljava/lang/String;" && t.Kind() == reflect.String:
+		return func(_ *VM, v reflect.Value) Value { return RefValue(v.String()) }, nil
+	case boxedKind(desc) != 0 && t.Kind() == reflect.Ptr && matchesPrimitive(boxedKind(desc), t.Elem()):
+		class := boxClassNames[boxedKind(desc)]
+		return func(vm *VM, v reflect.Value) Value {
+			if v.IsNil() {
+				return NullValue()
+			}
+			return vm.box(class, primitiveToJava(v.Elem()))
+		}, nil
+	case kind == '[' && t.Kind() == reflect.Slice:
+		elem, err := javaConverter(desc[1:], t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return func(vm *VM, v reflect.Value) Value {
+			if v.IsNil() {
+				return NullValue()
+			}
+			arr := NewArray(desc[1:], v.Len())
+			for i := 0; i < v.Len(); i++ {
+				arr.Set(i, elem(vm, v.Index(i)))
+			}
+			return RefValue(arr)
+		}, nil
+	case kind == 'L' && t == jobjectType:
+		return func(_ *VM, v reflect.Value) Value {
+			if v.IsNil() {
+				return NullValue()
+			}
+			return RefValue(v.Interface().(*JObject))
+		}, nil
+	}
+	return nil, fmt.Errorf("cannot convert %s to %s", t, desc)
+}
+
+// primitiveToGo converts the primitive v to the Go type t, whose kind
+// matches v's descriptor.
+func primitiveToGo(v Value, t reflect.Type) reflect.Value {
+	switch t.Kind() {
+	case reflect.Bool:
+		return reflect.ValueOf(v.Int != 0).Convert(t)
+	case reflect.Int64:
+		return reflect.ValueOf(v.Long).Convert(t)
+	case reflect.Float32:
+		return reflect.ValueOf(v.Float).Convert(t)
+	case reflect.Float64:
+		return reflect.ValueOf(v.Double).Convert(t)
+	}
+	return reflect.ValueOf(v.Int).Convert(t)
+}
+
+// primitiveToJava converts a Go value of a primitive's Go type to a
+// Value.
+func primitiveToJava(v reflect.Value) Value {
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return IntValue(1)
+		}
+		return IntValue(0)
+	case reflect.Uint8:
+		return IntValue(int32(int8(v.Uint())))
+	case reflect.Uint16:
+		return IntValue(int32(v.Uint()))
+	case reflect.Int64:
+		return LongValue(v.Int())
+	case reflect.Float32:
+		return FloatValue(float32(v.Float()))
+	case reflect.Float64:
+		return DoubleValue(v.Float())
+	}
+	return IntValue(int32(v.Int()))
+}
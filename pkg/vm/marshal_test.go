@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNativeFunc(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	call := func(descriptor string, fn interface{}, args ...Value) Value {
+		t.Helper()
+		native, err := NativeFunc(descriptor, fn)
+		if err != nil {
+			t.Fatalf("%s: %v", descriptor, err)
+		}
+		ret, err := native(v, args)
+		if err != nil {
+			t.Fatalf("%s: %v", descriptor, err)
+		}
+		return ret
+	}
+
+	if got := call("(ILjava/lang/String;)Ljava/lang/String;", func(n int32, s string) (string, error) {
+		return strings.Repeat(s, int(n)), nil
+	}, IntValue(3), RefValue("ab")); got != RefValue("ababab") {
+		t.Errorf("repeat: got %v", got)
+	}
+	if got := call("(ZBCSJFD)D", func(z bool, b int8, c uint16, s int16, j int64, f float32, d float64) float64 {
+		if !z {
+			return 0
+		}
+		return float64(b) + float64(c) + float64(s) + float64(j) + float64(f) + d
+	}, IntValue(1), IntValue(-1), IntValue('A'), IntValue(2), LongValue(1<<40), FloatValue(0.5), DoubleValue(0.25)); got != DoubleValue(-1+65+2+1<<40+0.75) {
+		t.Errorf("primitives: got %v", got)
+	}
+	if got := call("(Z)Z", func(z bool) bool { return !z }, IntValue(0)); got != IntValue(1) {
+		t.Errorf("boolean: got %v", got)
+	}
+
+	// Arrays are copied both ways.
+	in := NewArray("I", 3)
+	in.Ints[0], in.Ints[1], in.Ints[2] = 1, 2, 3
+	ret := call("([I)[I", func(a []int32) []int32 {
+		for i := range a {
+			a[i] *= 10
+		}
+		return a
+	}, RefValue(in))
+	if out, ok := ret.Ref.(*JArray); !ok || !reflect.DeepEqual(out.Ints, []int32{10, 20, 30}) || !reflect.DeepEqual(in.Ints, []int32{1, 2, 3}) {
+		t.Errorf("int array: got %v, argument now %v", ret, in.Ints)
+	}
+	strs := NewArray("Ljava/lang/String;", 2)
+	strs.Elements[0], strs.Elements[1] = RefValue("a"), RefValue("b")
+	if got := call("([Ljava/lang/String;)Ljava/lang/String;", func(s []string) string { return strings.Join(s, ",") }, RefValue(strs)); got != RefValue("a,b") {
+		t.Errorf("string array: got %v", got)
+	}
+	if got := call("([B)I", func(b []byte) int32 { return int32(len(b)) }, NullValue()); got != IntValue(0) {
+		t.Errorf("null byte array: got %v", got)
+	}
+	ret = call("()[B", func() []byte { return []byte{0xff, 1} })
+	if out, ok := ret.Ref.(*JArray); !ok || !reflect.DeepEqual(out.Bytes, []int8{-1, 1}) {
+		t.Errorf("byte array result: got %v", ret)
+	}
+
+	// Boxed values are pointers.
+	boxed := &JObject{ClassName: "java/lang/Integer", Fields: map[string]Value{"value": IntValue(41)}}
+	ret = call("(Ljava/lang/Integer;)Ljava/lang/Long;", func(i *int32) *int64 {
+		if i == nil {
+			return nil
+		}
+		n := int64(*i) + 1
+		return &n
+	}, RefValue(boxed))
+	if obj, ok := ret.Ref.(*JObject); !ok || obj.ClassName != "java/lang/Long" || obj.Fields["value"] != LongValue(42) {
+		t.Errorf("boxed: got %v", ret)
+	}
+	if got := call("(Ljava/lang/Integer;)Ljava/lang/Long;", func(i *int32) *int64 { return nil }, NullValue()); got.Type != TypeNull {
+		t.Errorf("null boxed: got %v", got)
+	}
+
+	// The VM, the receiver and Values.
+	receiver := &JObject{ClassName: "app/Counter", Fields: map[string]Value{"n": IntValue(7)}}
+	if got := call("(Ljava/lang/Object;)I", func(vm *VM, this *JObject, o Value) int32 {
+		if vm != v || o.Type != TypeNull {
+			return -1
+		}
+		return this.Fields["n"].Int
+	}, RefValue(receiver), NullValue()); got != IntValue(7) {
+		t.Errorf("receiver: got %v", got)
+	}
+
+	// Errors are returned, and Java exceptions thrown.
+	native, _ := NativeFunc("()V", func() error { return NewJavaException("java/lang/IllegalStateException") })
+	if _, err := native(v, nil); !isJavaException(err, "java/lang/IllegalStateException") {
+		t.Errorf("exception: got %v", err)
+	}
+	native, _ = NativeFunc("(Ljava/lang/String;)I", func(s string) int32 { return int32(len(s)) })
+	if _, err := native(v, []Value{NullValue()}); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("null string: got %v", err)
+	}
+	failure := errors.New("failure")
+	native, _ = NativeFunc("()I", func() (int32, error) { return 0, failure })
+	if _, err := native(v, nil); err != failure {
+		t.Errorf("error: got %v", err)
+	}
+}
+
+func TestNativeFuncMismatch(t *testing.T) {
+	for _, tt := range []struct {
+		descriptor string
+		fn         interface{}
+		want       string
+	}{
+		{"(I)I", 42, "is not a function"},
+		{"(I)I", func(int64) int32 { return 0 }, "parameter 1: cannot convert I to int64"},
+		{"(II)V", func(int32) {}, "takes 1 parameters"},
+		{"()I", func() {}, "returns no result"},
+		{"()V", func() int32 { return 0 }, "returns a result for a void method"},
+		{"()Ljava/lang/String;", func() []byte { return nil }, "result: cannot convert []uint8 to Ljava/lang/String;"},
+		{"(Ljava/lang/Integer;)V", func(*int64) {}, "cannot convert Ljava/lang/Integer; to *int64"},
+	} {
+		if _, err := NativeFunc(tt.descriptor, tt.fn); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %T: got %v, want %q", tt.descriptor, tt.fn, err, tt.want)
+		}
+	}
+
+	v := NewVM(mapClassLoader{})
+	if err := v.RegisterFunc("app/Lib", "f", "(J)V", func(int32) {}); err == nil || !strings.HasPrefix(err.Error(), "app/Lib.f: ") {
+		t.Errorf("RegisterFunc: got %v", err)
+	}
+	if err := v.RegisterFunc("app/Lib", "f", "(J)J", func(n int64) int64 { return n * 2 }); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := v.executeNativeMethod("app/Lib", "f", "(J)J", []Value{LongValue(21)}); err != nil || ret != LongValue(42) {
+		t.Errorf("registered function: got %v, %v", ret, err)
+	}
+}
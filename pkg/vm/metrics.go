@@ -0,0 +1,130 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Embedders hosting long-running workloads can monitor them by enabling
+// metrics with VM.EnableMetrics. The VM then counts method invocations,
+// thrown exceptions, loaded classes and executed instructions, and the
+// returned Metrics renders them in the OpenMetrics text format that
+// Prometheus scrapes. Collection is off by default so that the interpreter
+// loop pays nothing for it.
+
+// openMetricsContentType is the media type of the OpenMetrics text format.
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// Metrics holds the execution counters of a VM. It may be read from other
+// goroutines while the VM is running, and serves itself over HTTP.
+type Metrics struct {
+	instructions uint64 // updated atomically
+
+	mu         sync.Mutex
+	calls      map[methodKey]uint64
+	exceptions map[string]uint64 // exception class -> times thrown
+	classes    uint64            // classes loaded and initialized
+}
+
+// methodKey identifies a method for per-method counters.
+type methodKey struct {
+	class, name, descriptor string
+}
+
+// EnableMetrics starts collecting execution metrics and returns the
+// collector. Calling it again returns the same collector.
+func (vm *VM) EnableMetrics() *Metrics {
+	if vm.metrics == nil {
+		vm.metrics = &Metrics{
+			calls:      make(map[methodKey]uint64),
+			exceptions: make(map[string]uint64),
+		}
+	}
+	return vm.metrics
+}
+
+func (m *Metrics) countCall(class, name, descriptor string) {
+	m.mu.Lock()
+	m.calls[methodKey{class, name, descriptor}]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) countException(class string) {
+	m.mu.Lock()
+	m.exceptions[class]++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) countClass() {
+	m.mu.Lock()
+	m.classes++
+	m.mu.Unlock()
+}
+
+// WriteOpenMetrics writes a snapshot of the counters to w in the
+// OpenMetrics text format, terminated by "# EOF".
+func (m *Metrics) WriteOpenMetrics(w io.Writer) error {
+	var sb strings.Builder
+
+	m.mu.Lock()
+	calls := make([]methodKey, 0, len(m.calls))
+	for k := range m.calls {
+		calls = append(calls, k)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		a, b := calls[i], calls[j]
+		if a.class != b.class {
+			return a.class < b.class
+		}
+		if a.name != b.name {
+			return a.name < b.name
+		}
+		return a.descriptor < b.descriptor
+	})
+	sb.WriteString("# TYPE gojvm_method_calls counter\n")
+	sb.WriteString("# HELP gojvm_method_calls Method invocations.\n")
+	for _, k := range calls {
+		fmt.Fprintf(&sb, "gojvm_method_calls_total{class=\"%s\",method=\"%s\",descriptor=\"%s\"} %d\n",
+			escapeLabel(k.class), escapeLabel(k.name), escapeLabel(k.descriptor), m.calls[k])
+	}
+
+	exceptions := make([]string, 0, len(m.exceptions))
+	for k := range m.exceptions {
+		exceptions = append(exceptions, k)
+	}
+	sort.Strings(exceptions)
+	sb.WriteString("# TYPE gojvm_exceptions counter\n")
+	sb.WriteString("# HELP gojvm_exceptions Exceptions thrown, by exception class.\n")
+	for _, k := range exceptions {
+		fmt.Fprintf(&sb, "gojvm_exceptions_total{class=\"%s\"} %d\n", escapeLabel(k), m.exceptions[k])
+	}
+
+	sb.WriteString("# TYPE gojvm_classes_loaded counter\n")
+	sb.WriteString("# HELP gojvm_classes_loaded Classes loaded and initialized.\n")
+	fmt.Fprintf(&sb, "gojvm_classes_loaded_total %d\n", m.classes)
+	m.mu.Unlock()
+
+	sb.WriteString("# TYPE gojvm_instructions counter\n")
+	sb.WriteString("# HELP gojvm_instructions Bytecode instructions executed.\n")
+	fmt.Fprintf(&sb, "gojvm_instructions_total %d\n", atomic.LoadUint64(&m.instructions))
+	sb.WriteString("# EOF\n")
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// ServeHTTP serves the counters for scraping.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", openMetricsContentType)
+	m.WriteOpenMetrics(w)
+}
+
+// escapeLabel escapes a label value for the OpenMetrics text format.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
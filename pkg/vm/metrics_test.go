@@ -0,0 +1,74 @@
+package vm
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestMetrics(t *testing.T) {
+	b := classfile.NewBuilder("app/Main", "java/lang/Object")
+	one := b.Methodref("app/Main", "one", "()V")
+	b.AddMethod(classfile.AccStatic, "twice", "()V", &classfile.CodeAttribute{
+		Code: []byte{
+			0xb8, byte(one >> 8), byte(one), // invokestatic one
+			0xb8, byte(one >> 8), byte(one), // invokestatic one
+			0xb1, // return
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "one", "()V", &classfile.CodeAttribute{Code: []byte{0xb1}})
+	b.AddMethod(classfile.AccStatic, "fail", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{0x01, 0xbf}, // aconst_null; athrow
+	})
+	cf := b.Build()
+
+	v := NewVM(mapClassLoader{"app/Main": cf})
+	v.Stdout = io.Discard
+	m := v.EnableMetrics()
+	if v.EnableMetrics() != m {
+		t.Error("EnableMetrics returned a second collector")
+	}
+
+	if err := v.ensureInitialized("app/Main"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.executeMethod(cf, cf.FindMethod("twice", "()V"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.executeMethod(cf, cf.FindMethod("fail", "()V"), nil); err == nil {
+		t.Fatal("fail: expected an exception")
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Content-Type: got %q", ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE gojvm_method_calls counter\n",
+		`gojvm_method_calls_total{class="app/Main",method="fail",descriptor="()V"} 1` + "\n",
+		`gojvm_method_calls_total{class="app/Main",method="one",descriptor="()V"} 2` + "\n",
+		`gojvm_method_calls_total{class="app/Main",method="twice",descriptor="()V"} 1` + "\n",
+		`gojvm_exceptions_total{class="java/lang/NullPointerException"} 1` + "\n",
+		"gojvm_classes_loaded_total 1\n",
+		"gojvm_instructions_total 7\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("exposition does not end with # EOF")
+	}
+}
+
+func TestEscapeLabel(t *testing.T) {
+	if got := escapeLabel("a\"b\\c\nd"); got != `a\"b\\c\nd` {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,99 @@
+package vm
+
+import "strings"
+
+// The VM has no module system: every class, including the JDK's own, is
+// reported as a member of a single unnamed module. Package objects are
+// created per package name on first use so that repeated lookups observe
+// the same identities.
+
+// unnamedModule returns the java/lang/Module object of the unnamed module.
+func (vm *VM) unnamedModule() Value {
+	if vm.module == nil {
+		vm.module = &JObject{
+			ClassName: "java/lang/Module",
+			Fields: map[string]Value{
+				"name":   NullValue(),
+				"loader": vm.appClassLoader(),
+			},
+		}
+	}
+	return RefValue(vm.module)
+}
+
+// packageObject returns the java/lang/Package object for a package name in
+// binary form ("java.lang"; "" for the unnamed package).
+func (vm *VM) packageObject(name string) Value {
+	if vm.packages == nil {
+		vm.packages = make(map[string]*JObject)
+	}
+	pkg, ok := vm.packages[name]
+	if !ok {
+		pkg = &JObject{
+			ClassName: "java/lang/Package",
+			Fields: map[string]Value{
+				"name":   RefValue(name),
+				"module": vm.unnamedModule(),
+			},
+		}
+		vm.packages[name] = pkg
+	}
+	return RefValue(pkg)
+}
+
+// packageNameOf returns the binary package name of a name accepted by
+// classObject. Arrays belong to the package of their element type and
+// primitives to java.lang, as Class.getPackageName specifies.
+func packageNameOf(name string) string {
+	if strings.HasPrefix(name, "[") {
+		elem := strings.TrimLeft(name, "[")
+		if !strings.HasPrefix(elem, "L") {
+			return "java.lang"
+		}
+		name = descriptorClassName(elem)
+	}
+	if _, ok := primitiveDescriptors[name]; ok {
+		return "java.lang"
+	}
+	i := strings.LastIndex(name, "/")
+	if i < 0 {
+		return ""
+	}
+	return strings.ReplaceAll(name[:i], "/", ".")
+}
+
+// isModuleReflectionClass reports whether handleModuleMethod handles
+// instance methods of className.
+func isModuleReflectionClass(className string) bool {
+	switch className {
+	case "java/lang/Class", "java/lang/Module", "java/lang/Package":
+		return true
+	}
+	return false
+}
+
+// handleModuleMethod handles package and module queries on Class, Module
+// and Package objects. It reports false for methods it does not handle.
+func (vm *VM) handleModuleMethod(obj *JObject, methodName, descriptor string) (Value, bool) {
+	switch obj.ClassName + "." + methodName + ":" + descriptor {
+	case "java/lang/Class.getPackageName:()Ljava/lang/String;":
+		return RefValue(packageNameOf(classObjectName(RefValue(obj)))), true
+	case "java/lang/Class.getPackage:()Ljava/lang/Package;":
+		name := classObjectName(RefValue(obj))
+		if _, ok := primitiveDescriptors[name]; ok || strings.HasPrefix(name, "[") {
+			return NullValue(), true
+		}
+		return vm.packageObject(packageNameOf(name)), true
+	case "java/lang/Class.getModule:()Ljava/lang/Module;",
+		"java/lang/Package.getModule:()Ljava/lang/Module;":
+		return vm.unnamedModule(), true
+	case "java/lang/Module.getName:()Ljava/lang/String;",
+		"java/lang/Package.getName:()Ljava/lang/String;":
+		return obj.Fields["name"], true
+	case "java/lang/Module.isNamed:()Z":
+		return IntValue(0), true
+	case "java/lang/Module.getClassLoader:()Ljava/lang/ClassLoader;":
+		return obj.Fields["loader"], true
+	}
+	return Value{}, false
+}
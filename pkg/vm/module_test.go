@@ -0,0 +1,51 @@
+package vm
+
+import (
+	"io"
+	"testing"
+)
+
+func TestPackageAndModule(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+
+	names := map[string]string{
+		"java/lang/String":    "java.lang",
+		"Hello":               "",
+		"int":                 "java.lang",
+		"[[I":                 "java.lang",
+		"[Ljava/util/List;":   "java.util",
+		"com/example/Outer$A": "com.example",
+	}
+	for class, want := range names {
+		obj := v.classObject(class).Ref.(*JObject)
+		got, ok := v.handleModuleMethod(obj, "getPackageName", "()Ljava/lang/String;")
+		if !ok || got.Ref != want {
+			t.Errorf("%s.getPackageName(): got %v, want %q", class, got.Ref, want)
+		}
+	}
+
+	str := v.classObject("java/lang/String").Ref.(*JObject)
+	pkg, _ := v.handleModuleMethod(str, "getPackage", "()Ljava/lang/Package;")
+	again, _ := v.handleModuleMethod(v.classObject("java/lang/Object").Ref.(*JObject), "getPackage", "()Ljava/lang/Package;")
+	if pkg.Ref == nil || pkg.Ref != again.Ref {
+		t.Error("classes of the same package should share one Package object")
+	}
+	if name, _ := v.handleModuleMethod(pkg.Ref.(*JObject), "getName", "()Ljava/lang/String;"); name.Ref != "java.lang" {
+		t.Errorf("Package.getName(): got %v", name.Ref)
+	}
+	if p, _ := v.handleModuleMethod(v.classObject("int").Ref.(*JObject), "getPackage", "()Ljava/lang/Package;"); p.Type != TypeNull {
+		t.Errorf("int.class.getPackage(): got %v, want null", p.Ref)
+	}
+
+	module, _ := v.handleModuleMethod(str, "getModule", "()Ljava/lang/Module;")
+	if module.Ref == nil || module.Ref != v.unnamedModule().Ref {
+		t.Fatal("getModule should return the unnamed module")
+	}
+	mod := module.Ref.(*JObject)
+	if named, _ := v.handleModuleMethod(mod, "isNamed", "()Z"); named.Int != 0 {
+		t.Error("unnamed module reports isNamed() == true")
+	}
+	if name, _ := v.handleModuleMethod(mod, "getName", "()Ljava/lang/String;"); name.Type != TypeNull {
+		t.Errorf("unnamed module name: got %v, want null", name.Ref)
+	}
+}
@@ -0,0 +1,123 @@
+package vm
+
+import "sync"
+
+// Every object has a re-entrant monitor, owned by at most one thread at a
+// time and entered any number of times by its owner. The monitor table is
+// guarded by monitorMu, and a thread entering a monitor another thread owns
+// waits on monitorCond until it is released. The VM runs a single thread
+// today, so monitors are never contended, but monitorexit by a thread that
+// does not own the monitor must still fail, and frames that are popped
+// while holding monitors must release them.
+
+// monitor is the state of an object's monitor while it is owned.
+type monitor struct {
+	owner *JObject // java/lang/Thread holding the monitor
+	count int      // entries by owner not yet exited
+}
+
+// monitorCondition returns the condition variable signalled whenever a
+// monitor is released. monitorMu must be held.
+func (vm *VM) monitorCondition() *sync.Cond {
+	if vm.monitorCond == nil {
+		vm.monitorCond = sync.NewCond(&vm.monitorMu)
+	}
+	return vm.monitorCond
+}
+
+// monitorKey returns the identity used for an object's monitor.
+func monitorKey(ref Value) interface{} {
+	return ref.Ref
+}
+
+// monitorEnter acquires the monitor of ref on behalf of frame, waiting
+// while another thread owns it.
+func (vm *VM) monitorEnter(frame *Frame, ref Value) error {
+	if ref.Type == TypeNull || ref.Ref == nil {
+		return nullOperand()
+	}
+	thread, _ := vm.currentThread().Ref.(*JObject)
+	key := monitorKey(ref)
+
+	vm.monitorMu.Lock()
+	if vm.monitors == nil {
+		vm.monitors = make(map[interface{}]*monitor)
+	}
+	m := vm.monitors[key]
+	for m != nil && m.owner != thread {
+		vm.monitorCondition().Wait()
+		m = vm.monitors[key]
+	}
+	if m == nil {
+		m = &monitor{owner: thread}
+		vm.monitors[key] = m
+	}
+	m.count++
+	vm.monitorMu.Unlock()
+
+	frame.Monitors = append(frame.Monitors, key)
+	return nil
+}
+
+// monitorExit releases the monitor of ref held by frame. The current
+// thread must own it.
+func (vm *VM) monitorExit(frame *Frame, ref Value) error {
+	if ref.Type == TypeNull || ref.Ref == nil {
+		return nullOperand()
+	}
+	key := monitorKey(ref)
+	if thread, _ := vm.currentThread().Ref.(*JObject); vm.monitorOwner(key) != thread {
+		return NewJavaExceptionMessage("java/lang/IllegalMonitorStateException", "current thread is not owner")
+	}
+	vm.releaseMonitor(key)
+	for i := len(frame.Monitors) - 1; i >= 0; i-- {
+		if frame.Monitors[i] == key {
+			frame.Monitors = append(frame.Monitors[:i], frame.Monitors[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// releaseFrameMonitors releases every monitor frame still holds. It runs
+// when a frame is popped, so that a synchronized method's implicit lock and
+// any monitorenter left unbalanced by an exception are unlocked.
+func (vm *VM) releaseFrameMonitors(frame *Frame) {
+	for i := len(frame.Monitors) - 1; i >= 0; i-- {
+		vm.releaseMonitor(frame.Monitors[i])
+	}
+	frame.Monitors = nil
+}
+
+// releaseMonitor exits the monitor with the given key once, waking waiting
+// threads when that frees it.
+func (vm *VM) releaseMonitor(key interface{}) {
+	vm.monitorMu.Lock()
+	defer vm.monitorMu.Unlock()
+	m := vm.monitors[key]
+	if m == nil {
+		return
+	}
+	if m.count--; m.count == 0 {
+		delete(vm.monitors, key)
+		vm.monitorCondition().Broadcast()
+	}
+}
+
+// monitorOwner returns the thread owning the monitor with the given key,
+// or nil if it is free.
+func (vm *VM) monitorOwner(key interface{}) *JObject {
+	vm.monitorMu.Lock()
+	defer vm.monitorMu.Unlock()
+	if m := vm.monitors[key]; m != nil {
+		return m.owner
+	}
+	return nil
+}
+
+// holdsMonitor reports whether the current thread owns the monitor of ref,
+// as Thread.holdsLock does.
+func (vm *VM) holdsMonitor(ref Value) bool {
+	thread, _ := vm.currentThread().Ref.(*JObject)
+	return vm.monitorOwner(monitorKey(ref)) == thread
+}
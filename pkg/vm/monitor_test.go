@@ -0,0 +1,131 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestMonitorsReleasedOnUnwind(t *testing.T) {
+	pool := make([]classfile.ConstantPoolEntry, 5)
+	pool[1] = &classfile.ConstantClass{NameIndex: 2}
+	pool[2] = &classfile.ConstantUtf8{Value: "Sync"}
+	pool[3] = &classfile.ConstantClass{NameIndex: 4}
+	pool[4] = &classfile.ConstantUtf8{Value: "java/lang/RuntimeException"}
+	throwCode := []byte{0xBB, 0x00, 0x03, 0xBF} // new RuntimeException; athrow
+
+	cf := &classfile.ClassFile{
+		ConstantPool: pool,
+		ThisClass:    1,
+		Methods: []classfile.MethodInfo{
+			{
+				// static synchronized void boom() { throw new RuntimeException(); }
+				AccessFlags: classfile.AccStatic | AccSynchronized,
+				Name:        "boom",
+				Descriptor:  "()V",
+				Code:        &classfile.CodeAttribute{MaxStack: 2, MaxLocals: 0, Code: throwCode},
+			},
+			{
+				// void block(Object o) { synchronized (o) { throw new RuntimeException(); } }
+				// without the compiler's catch-any handler
+				Name:       "block",
+				Descriptor: "(Ljava/lang/Object;)V",
+				Code: &classfile.CodeAttribute{MaxStack: 2, MaxLocals: 2,
+					Code: append([]byte{0x2B, 0xC2}, throwCode...)}, // aload_1; monitorenter
+			},
+		},
+	}
+	v := NewVM(mapClassLoader{"Sync": cf})
+	v.Stdout = io.Discard
+
+	_, err := v.executeMethod(cf, cf.FindMethod("boom", "()V"), nil)
+	if _, ok := err.(*JavaException); !ok {
+		t.Fatalf("boom: expected JavaException, got %v", err)
+	}
+	if v.holdsMonitor(v.classObject("Sync")) {
+		t.Error("class monitor of a static synchronized method is still held after an exception")
+	}
+
+	this := RefValue(&JObject{ClassName: "Sync", Fields: map[string]Value{}})
+	lock := RefValue(&JObject{ClassName: "java/lang/Object", Fields: map[string]Value{}})
+	_, err = v.executeMethod(cf, cf.FindMethod("block", "(Ljava/lang/Object;)V"), []Value{this, lock})
+	if _, ok := err.(*JavaException); !ok {
+		t.Fatalf("block: expected JavaException, got %v", err)
+	}
+	if v.holdsMonitor(lock) {
+		t.Error("monitor entered by monitorenter is still held after the frame was unwound")
+	}
+}
+
+func TestMonitorExitNotHeld(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	frame := NewFrame(0, 2, nil, nil)
+	lock := RefValue(&JObject{ClassName: "java/lang/Object", Fields: map[string]Value{}})
+
+	// 再入可能: 2 回 enter したら 2 回 exit できる
+	v.monitorEnter(frame, lock)
+	v.monitorEnter(frame, lock)
+	for i := 0; i < 2; i++ {
+		if err := v.monitorExit(frame, lock); err != nil {
+			t.Fatalf("exit %d: %v", i, err)
+		}
+	}
+	err := v.monitorExit(frame, lock)
+	if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/lang/IllegalMonitorStateException" {
+		t.Errorf("expected IllegalMonitorStateException, got %v", err)
+	}
+	err = v.monitorEnter(frame, NullValue())
+	if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/lang/NullPointerException" {
+		t.Errorf("monitorenter null: expected NullPointerException, got %v", err)
+	}
+}
+
+func TestMonitorOwner(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	frame := NewFrame(2, 2, nil, nil)
+	lock := RefValue(&JObject{ClassName: "java/lang/Object", Fields: map[string]Value{}})
+	holdsLock := func() int32 {
+		t.Helper()
+		got, err := v.executeNativeMethod("java/lang/Thread", "holdsLock", "(Ljava/lang/Object;)Z", []Value{lock})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Int
+	}
+
+	// synchronized (lock) { } as javac compiles it, without the handler.
+	frame.Code = []byte{0x2A, 0x59, 0x4C, 0xC2, 0x2B, 0xC3, 0xB1} // aload_0; dup; astore_1; monitorenter; aload_1; monitorexit; return
+	frame.SetLocal(0, lock)
+	for frame.PC < len(frame.Code) {
+		opcode := frame.Code[frame.PC]
+		frame.PC++
+		if _, _, err := v.executeInstruction(frame, opcode); err != nil {
+			t.Fatalf("PC=%d: %v", frame.PC-1, err)
+		}
+	}
+	if holdsLock() != 0 || len(frame.Monitors) != 0 {
+		t.Fatalf("monitor still held after a balanced synchronized block: %v", frame.Monitors)
+	}
+
+	if err := v.monitorEnter(frame, lock); err != nil {
+		t.Fatal(err)
+	}
+	if holdsLock() != 1 {
+		t.Error("holdsLock: got false while owning the monitor")
+	}
+	// Another thread neither holds the monitor nor may exit it.
+	main := v.mainThread
+	v.mainThread = &JObject{ClassName: "java/lang/Thread", Fields: map[string]Value{}}
+	if holdsLock() != 0 {
+		t.Error("holdsLock: got true for a thread that does not own the monitor")
+	}
+	err := v.monitorExit(frame, lock)
+	if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/lang/IllegalMonitorStateException" {
+		t.Errorf("monitorexit by another thread: got %v", err)
+	}
+	v.mainThread = main
+	if err := v.monitorExit(frame, lock); err != nil || holdsLock() != 0 {
+		t.Errorf("monitorexit by the owner: %v", err)
+	}
+}
@@ -0,0 +1,76 @@
+package vm
+
+import "strings"
+
+// Native methods are looked up in a registry keyed by
+// "class.name:descriptor". The VM's own implementations are registered in
+// builtinNatives by the natives_*.go files, one per group of packages;
+// embedders and tests add their own, or replace built-in ones, with
+// VM.RegisterNative, or VM.RegisterFunc for plain Go functions.
+
+// NativeMethod implements a native method. args holds the receiver first
+// for instance methods. It returns the method's result, ignored for void
+// methods, or an error, which may be a *JavaException to throw.
+type NativeMethod func(vm *VM, args []Value) (Value, error)
+
+// nativeRegistry maps "class.name:descriptor" keys to native methods.
+type nativeRegistry map[string]NativeMethod
+
+// builtinNatives are the native methods the VM implements.
+var builtinNatives = nativeRegistry{}
+
+// nativeKey returns the registry key of a native method.
+func nativeKey(className, methodName, descriptor string) string {
+	return className + "." + methodName + ":" + descriptor
+}
+
+// add registers the natives of other, which must not already be
+// registered.
+func (r nativeRegistry) add(other nativeRegistry) {
+	for key, fn := range other {
+		if _, ok := r[key]; ok {
+			panic("native method registered twice: " + key)
+		}
+		r[key] = fn
+	}
+}
+
+// RegisterNative makes fn the implementation of the native method
+// className.methodName with the given descriptor for this VM, taking
+// precedence over a built-in implementation. className uses "/"
+// separators, as in "java/lang/Object".
+func (vm *VM) RegisterNative(className, methodName, descriptor string, fn NativeMethod) {
+	if vm.natives == nil {
+		vm.natives = make(nativeRegistry)
+	}
+	vm.natives[nativeKey(strings.ReplaceAll(className, ".", "/"), methodName, descriptor)] = fn
+}
+
+// lookupNative returns the implementation of a native method, or nil if
+// the VM has none. Unsafe's methods, implemented by handleUnsafe, and the
+// registerNatives and initIDs methods are not in the registry.
+func (vm *VM) lookupNative(className, methodName, descriptor string) NativeMethod {
+	key := nativeKey(className, methodName, descriptor)
+	if fn, ok := vm.natives[key]; ok {
+		return fn
+	}
+	return builtinNatives[key]
+}
+
+// nativeNoop implements native methods that have nothing to do.
+func nativeNoop(vm *VM, args []Value) (Value, error) {
+	return Value{}, nil
+}
+
+// nativeFalse implements native methods that return false.
+func nativeFalse(vm *VM, args []Value) (Value, error) {
+	return IntValue(0), nil
+}
+
+// boolValue returns the int Value of a boolean result.
+func boolValue(b bool) Value {
+	if b {
+		return IntValue(1)
+	}
+	return IntValue(0)
+}
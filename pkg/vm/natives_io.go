@@ -0,0 +1,285 @@
+package vm
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Boolean attributes and access modes of java.io.FileSystem.
+const (
+	fileExists    = 0x01
+	fileRegular   = 0x02
+	fileDirectory = 0x04
+	fileHidden    = 0x08
+
+	accessExecute = 0x01
+	accessWrite   = 0x02
+	accessRead    = 0x04
+)
+
+// init registers the natives of java.io, which back java.io.File through
+// UnixFileSystem with the host file system when VM.FileAccess is set.
+func init() {
+	builtinNatives.add(nativeRegistry{
+		"java/io/UnixFileSystem.initIDs:()V": nativeNoop,
+		"java/io/UnixFileSystem.canonicalize0:(Ljava/lang/String;)Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			path, ok := extractGoString(args[1])
+			if !ok {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			return RefValue(canonicalPath(path)), nil
+		},
+		"java/io/UnixFileSystem.getBooleanAttributes0:(Ljava/io/File;)I": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return IntValue(0), nil
+			}
+			attrs := int32(fileExists)
+			if info.Mode().IsRegular() {
+				attrs |= fileRegular
+			}
+			if info.IsDir() {
+				attrs |= fileDirectory
+			}
+			if strings.HasPrefix(filepath.Base(path), ".") {
+				attrs |= fileHidden
+			}
+			return IntValue(attrs), nil
+		},
+		"java/io/UnixFileSystem.checkAccess:(Ljava/io/File;I)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			var mode uint32
+			if args[2].Int&accessRead != 0 {
+				mode |= 4
+			}
+			if args[2].Int&accessWrite != 0 {
+				mode |= 2
+			}
+			if args[2].Int&accessExecute != 0 {
+				mode |= 1
+			}
+			return boolValue(syscall.Access(path, mode) == nil), nil
+		},
+		"java/io/UnixFileSystem.getLastModifiedTime:(Ljava/io/File;)J": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return LongValue(0), nil
+			}
+			return LongValue(info.ModTime().UnixMilli()), nil
+		},
+		"java/io/UnixFileSystem.getLength:(Ljava/io/File;)J": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return LongValue(0), nil
+			}
+			return LongValue(info.Size()), nil
+		},
+		"java/io/UnixFileSystem.setPermission:(Ljava/io/File;IZZ)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return boolValue(false), nil
+			}
+			// The access bit for the owner, or for everyone
+			var bits fs.FileMode
+			switch args[2].Int {
+			case accessRead:
+				bits = 0400
+			case accessWrite:
+				bits = 0200
+			case accessExecute:
+				bits = 0100
+			}
+			if args[4].Int == 0 {
+				bits |= bits>>3 | bits>>6
+			}
+			perm := info.Mode().Perm()
+			if args[3].Int != 0 {
+				perm |= bits
+			} else {
+				perm &^= bits
+			}
+			return boolValue(os.Chmod(path, perm) == nil), nil
+		},
+		"java/io/UnixFileSystem.setReadOnly:(Ljava/io/File;)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return boolValue(false), nil
+			}
+			return boolValue(os.Chmod(path, info.Mode().Perm()&^0222) == nil), nil
+		},
+		"java/io/UnixFileSystem.setLastModifiedTime:(Ljava/io/File;J)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				return boolValue(false), nil
+			}
+			mtime := time.UnixMilli(args[2].Long)
+			return boolValue(os.Chtimes(path, info.ModTime(), mtime) == nil), nil
+		},
+		"java/io/UnixFileSystem.createFileExclusively:(Ljava/lang/String;)Z": func(vm *VM, args []Value) (Value, error) {
+			path, ok := extractGoString(args[1])
+			if !ok {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			if err := vm.checkFileAccess(path, "write"); err != nil {
+				return Value{}, err
+			}
+			f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+			switch {
+			case errors.Is(err, fs.ErrExist):
+				return boolValue(false), nil
+			case err != nil:
+				return Value{}, ioException(err)
+			}
+			f.Close()
+			return boolValue(true), nil
+		},
+		"java/io/UnixFileSystem.delete0:(Ljava/io/File;)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "delete")
+			if err != nil {
+				return Value{}, err
+			}
+			return boolValue(os.Remove(path) == nil), nil
+		},
+		"java/io/UnixFileSystem.list:(Ljava/io/File;)[Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			entries, err := os.ReadDir(path)
+			if err != nil {
+				return NullValue(), nil
+			}
+			names := make([]string, len(entries))
+			for i, e := range entries {
+				names[i] = e.Name()
+			}
+			sort.Strings(names)
+			arr := NewArray("Ljava/lang/String;", len(names))
+			for i, name := range names {
+				arr.Set(i, RefValue(name))
+			}
+			return RefValue(arr), nil
+		},
+		"java/io/UnixFileSystem.createDirectory:(Ljava/io/File;)Z": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			return boolValue(os.Mkdir(path, 0777) == nil), nil
+		},
+		"java/io/UnixFileSystem.rename0:(Ljava/io/File;Ljava/io/File;)Z": func(vm *VM, args []Value) (Value, error) {
+			from, err := vm.accessFile(args[1], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			to, err := vm.accessFile(args[2], "write")
+			if err != nil {
+				return Value{}, err
+			}
+			return boolValue(os.Rename(from, to) == nil), nil
+		},
+		"java/io/UnixFileSystem.getSpace:(Ljava/io/File;I)J": func(vm *VM, args []Value) (Value, error) {
+			path, err := vm.accessFile(args[1], "read")
+			if err != nil {
+				return Value{}, err
+			}
+			var st syscall.Statfs_t
+			if syscall.Statfs(path, &st) != nil {
+				return LongValue(0), nil
+			}
+			// SPACE_TOTAL, SPACE_FREE and SPACE_USABLE
+			blocks := st.Blocks
+			switch args[2].Int {
+			case 1:
+				blocks = st.Bfree
+			case 2:
+				blocks = st.Bavail
+			}
+			return LongValue(int64(blocks) * int64(st.Bsize)), nil
+		},
+		"java/io/UnixFileSystem.getNameMax0:(Ljava/lang/String;)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(255), nil
+		},
+	})
+}
+
+// filePath returns the path of the java/io/File f.
+func filePath(f Value) (string, error) {
+	obj, ok := f.Ref.(*JObject)
+	if !ok || f.Type == TypeNull {
+		return "", NewJavaException("java/lang/NullPointerException")
+	}
+	path, _ := extractGoString(obj.Fields["path"])
+	return path, nil
+}
+
+// accessFile returns the path of the java/io/File f, or throws
+// SecurityException unless the program may perform action on it.
+func (vm *VM) accessFile(f Value, action string) (string, error) {
+	path, err := filePath(f)
+	if err != nil {
+		return "", err
+	}
+	if err := vm.checkFileAccess(path, action); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// canonicalPath makes path absolute and resolves "." and ".." elements and
+// symbolic links in the longest prefix of it that exists.
+func canonicalPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.Clean(path)
+	}
+	rest := ""
+	for dir := abs; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, rest)
+		}
+		if dir == filepath.Dir(dir) {
+			return abs
+		}
+		rest = filepath.Join(filepath.Base(dir), rest)
+	}
+}
+
+// ioException converts a host file system error to an IOException with
+// the message of its underlying system error, as the JDK reports errno.
+func ioException(err error) *JavaException {
+	return NewJavaExceptionMessage("java/io/IOException", errnoMessage(err))
+}
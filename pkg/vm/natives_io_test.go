@@ -0,0 +1,129 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixFileSystem(t *testing.T) {
+	dir := t.TempDir()
+	v := NewVM(mapClassLoader{})
+	v.FileAccess = true
+	fs := RefValue(&JObject{ClassName: "java/io/UnixFileSystem", Fields: map[string]Value{}})
+	file := func(path string) Value {
+		return RefValue(&JObject{ClassName: "java/io/File", Fields: map[string]Value{"path": RefValue(path)}})
+	}
+	call := func(name, desc string, args ...Value) Value {
+		t.Helper()
+		ret, err := v.executeNativeMethod("java/io/UnixFileSystem", name, desc, append([]Value{fs}, args...))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		return ret
+	}
+
+	path := filepath.Join(dir, "a.txt")
+	if got := call("getBooleanAttributes0", "(Ljava/io/File;)I", file(path)); got.Int != 0 {
+		t.Errorf("attributes of a missing file: got %#x", got.Int)
+	}
+	if got := call("createFileExclusively", "(Ljava/lang/String;)Z", RefValue(path)); got.Int != 1 {
+		t.Error("createFileExclusively: file not created")
+	}
+	if got := call("createFileExclusively", "(Ljava/lang/String;)Z", RefValue(path)); got.Int != 0 {
+		t.Error("createFileExclusively: existing file created again")
+	}
+	if _, err := v.executeNativeMethod("java/io/UnixFileSystem", "createFileExclusively", "(Ljava/lang/String;)Z", []Value{fs, RefValue(filepath.Join(dir, "no", "b"))}); !isJavaException(err, "java/io/IOException") {
+		t.Errorf("createFileExclusively in a missing directory: got %v", err)
+	}
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := call("getBooleanAttributes0", "(Ljava/io/File;)I", file(path)); got.Int != fileExists|fileRegular {
+		t.Errorf("attributes of a file: got %#x", got.Int)
+	}
+	if got := call("getLength", "(Ljava/io/File;)J", file(path)); got.Long != 5 {
+		t.Errorf("getLength: got %d", got.Long)
+	}
+	if got := call("checkAccess", "(Ljava/io/File;I)Z", file(path), IntValue(accessRead)); got.Int != 1 {
+		t.Error("checkAccess: file not readable")
+	}
+
+	sub := filepath.Join(dir, "sub")
+	if got := call("createDirectory", "(Ljava/io/File;)Z", file(sub)); got.Int != 1 {
+		t.Error("createDirectory: directory not created")
+	}
+	if got := call("getBooleanAttributes0", "(Ljava/io/File;)I", file(sub)); got.Int != fileExists|fileDirectory {
+		t.Errorf("attributes of a directory: got %#x", got.Int)
+	}
+	list := call("list", "(Ljava/io/File;)[Ljava/lang/String;", file(dir)).Ref.(*JArray)
+	if list.Len() != 2 || list.Get(0).Ref != "a.txt" || list.Get(1).Ref != "sub" {
+		t.Errorf("list: got %v", list)
+	}
+	if got := call("list", "(Ljava/io/File;)[Ljava/lang/String;", file(path)); got.Type != TypeNull {
+		t.Errorf("list of a file: got %v, want null", got)
+	}
+	if got := call("canonicalize0", "(Ljava/lang/String;)Ljava/lang/String;", RefValue(sub+"/../a.txt")); got.Ref != canonicalPath(path) {
+		t.Errorf("canonicalize0: got %v", got.Ref)
+	}
+
+	if got := call("delete0", "(Ljava/io/File;)Z", file(path)); got.Int != 1 {
+		t.Error("delete0: file not deleted")
+	}
+	if got := call("delete0", "(Ljava/io/File;)Z", file(path)); got.Int != 0 {
+		t.Error("delete0: missing file deleted")
+	}
+	if _, err := v.executeNativeMethod("java/io/UnixFileSystem", "getLength", "(Ljava/io/File;)J", []Value{fs, NullValue()}); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("getLength(null): got %v", err)
+	}
+}
+
+func TestUnixFileSystemWithoutFileAccess(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.txt")
+	if err := os.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := NewVM(mapClassLoader{})
+	fs := RefValue(&JObject{ClassName: "java/io/UnixFileSystem", Fields: map[string]Value{}})
+	file := func(path string) Value {
+		return RefValue(&JObject{ClassName: "java/io/File", Fields: map[string]Value{"path": RefValue(path)}})
+	}
+
+	for _, tt := range []struct {
+		name, desc string
+		args       []Value
+	}{
+		{"getBooleanAttributes0", "(Ljava/io/File;)I", []Value{file(path)}},
+		{"getLength", "(Ljava/io/File;)J", []Value{file(path)}},
+		{"list", "(Ljava/io/File;)[Ljava/lang/String;", []Value{file(dir)}},
+		{"createFileExclusively", "(Ljava/lang/String;)Z", []Value{RefValue(filepath.Join(dir, "b.txt"))}},
+		{"createDirectory", "(Ljava/io/File;)Z", []Value{file(filepath.Join(dir, "sub"))}},
+		{"setReadOnly", "(Ljava/io/File;)Z", []Value{file(path)}},
+		{"setPermission", "(Ljava/io/File;IZZ)Z", []Value{file(path), IntValue(accessWrite), IntValue(0), IntValue(1)}},
+		{"setLastModifiedTime", "(Ljava/io/File;J)Z", []Value{file(path), LongValue(0)}},
+		{"rename0", "(Ljava/io/File;Ljava/io/File;)Z", []Value{file(path), file(filepath.Join(dir, "c.txt"))}},
+		{"delete0", "(Ljava/io/File;)Z", []Value{file(path)}},
+	} {
+		_, err := v.executeNativeMethod("java/io/UnixFileSystem", tt.name, tt.desc, append([]Value{fs}, tt.args...))
+		if !isJavaException(err, "java/lang/SecurityException") {
+			t.Errorf("%s: got %v, want SecurityException", tt.name, err)
+		}
+	}
+
+	// Nothing on the host changed
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Errorf("directory now holds %v", entries)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 || info.ModTime().UnixMilli() == 0 {
+		t.Errorf("a.txt changed: mode %v, modified %v", info.Mode(), info.ModTime())
+	}
+}
@@ -0,0 +1,41 @@
+package vm
+
+// init registers the natives of the JDK's internal packages.
+func init() {
+	builtinNatives.add(nativeRegistry{
+		"jdk/internal/misc/VM.getSavedProperty:(Ljava/lang/String;)Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			key, _ := extractGoString(args[0])
+			return vm.propertyValue(key), nil
+		},
+		"jdk/internal/misc/VM.initialize:()V": func(vm *VM, args []Value) (Value, error) {
+			// Saved properties are a snapshot of the property store, as after
+			// System.initPhase1 in a real JVM
+			vm.setStaticField("jdk/internal/misc/VM", "savedProps", vm.savedPropertiesMap())
+			return Value{}, nil
+		},
+		"jdk/internal/misc/VM.getNanoTimeAdjustment:(J)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(nanoTimeAdjustment(vm.clock().Now(), args[0].Long)), nil
+		},
+
+		"jdk/internal/misc/CDS.initializeFromArchive:(Ljava/lang/Class;)V": nativeNoop,
+		"jdk/internal/misc/CDS.isDumpingClassList0:()Z":                    nativeFalse,
+		"jdk/internal/misc/CDS.isDumpingArchive0:()Z":                      nativeFalse,
+		"jdk/internal/misc/CDS.isSharingEnabled0:()Z":                      nativeFalse,
+		"jdk/internal/misc/CDS.getRandomSeedForDumping:()J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(0), nil
+		},
+
+		"jdk/internal/misc/Unsafe.getUnsafe:()Ljdk/internal/misc/Unsafe;": func(vm *VM, args []Value) (Value, error) {
+			obj := &JObject{ClassName: "jdk/internal/misc/Unsafe", Fields: make(map[string]Value)}
+			return RefValue(obj), nil
+		},
+		"jdk/internal/misc/Unsafe.storeFence:()V": nativeNoop,
+		"jdk/internal/misc/Unsafe.getObjectSize:(Ljava/lang/Object;)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(16), nil
+		},
+
+		"jdk/internal/reflect/Reflection.getCallerClass:()Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			return vm.classObject("java/lang/Object"), nil
+		},
+	})
+}
@@ -0,0 +1,212 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// init registers the natives of java.lang and its subpackages.
+func init() {
+	builtinNatives.add(nativeRegistry{
+		"java/lang/Object.hashCode:()I": func(vm *VM, args []Value) (Value, error) {
+			obj, ok := args[0].Ref.(*JObject)
+			if !ok {
+				return Value{}, fmt.Errorf("Object.hashCode: receiver is not a JObject")
+			}
+			hash := int32(reflect.ValueOf(obj).Pointer() & 0x7FFFFFFF)
+			return IntValue(hash), nil
+		},
+		"java/lang/Object.getClass:()Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			obj, ok := args[0].Ref.(*JObject)
+			if !ok {
+				return Value{}, fmt.Errorf("Object.getClass: receiver is not a JObject")
+			}
+			return vm.classObject(obj.ClassName), nil
+		},
+		"java/lang/Object.registerNatives:()V": nativeNoop,
+		"java/lang/Object.notifyAll:()V":       nativeNoop,
+		"java/lang/Object.notify:()V":          nativeNoop,
+
+		"java/lang/Class.getPrimitiveClass:(Ljava/lang/String;)Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			name, _ := extractGoString(args[0])
+			if _, ok := primitiveDescriptors[name]; !ok {
+				return Value{}, NewJavaException("java/lang/IllegalArgumentException")
+			}
+			return vm.classObject(name), nil
+		},
+		"java/lang/Class.getSimpleBinaryName0:()Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			cf := vm.classFileOfClassObject(args[0])
+			if cf == nil || !cf.IsNested() || cf.SimpleName() == "" {
+				return NullValue(), nil
+			}
+			return RefValue(cf.SimpleName()), nil
+		},
+		"java/lang/Class.getDeclaringClass0:()Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			cf := vm.classFileOfClassObject(args[0])
+			if cf == nil || cf.DeclaringClassName() == "" {
+				return NullValue(), nil
+			}
+			return vm.classObject(cf.DeclaringClassName()), nil
+		},
+		"java/lang/Class.getEnclosingMethod0:()[Ljava/lang/Object;": func(vm *VM, args []Value) (Value, error) {
+			cf := vm.classFileOfClassObject(args[0])
+			if cf == nil || cf.EnclosingMethod == nil {
+				return NullValue(), nil
+			}
+			em := cf.EnclosingMethod
+			info := &JArray{Component: "Ljava/lang/Object;", Elements: []Value{
+				vm.classObject(em.Class),
+				NullValue(),
+				NullValue(),
+			}}
+			if em.MethodName != "" {
+				info.Elements[1] = RefValue(em.MethodName)
+				info.Elements[2] = RefValue(em.Descriptor)
+			}
+			return RefValue(info), nil
+		},
+		"java/lang/Class.getNestHost0:()Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			name := classObjectName(args[0])
+			if vm.classFileOfClassObject(args[0]) == nil {
+				return args[0], nil // primitives and arrays are their own nest host
+			}
+			return vm.classObject(vm.nestHostOf(name)), nil
+		},
+		"java/lang/Class.getNestMembers0:()[Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			cf := vm.classFileOfClassObject(args[0])
+			if cf == nil {
+				return RefValue(&JArray{Elements: []Value{args[0]}, Component: "Ljava/lang/Class;"}), nil
+			}
+			host := vm.nestHostOf(classObjectName(args[0]))
+			members := []Value{vm.classObject(host)}
+			if hostCf, err := vm.ClassLoader.LoadClass(host); err == nil {
+				for _, m := range hostCf.NestMembers {
+					members = append(members, vm.classObject(m))
+				}
+			}
+			return RefValue(&JArray{Elements: members, Component: "Ljava/lang/Class;"}), nil
+		},
+		"java/lang/Class.isRecord0:()Z": func(vm *VM, args []Value) (Value, error) {
+			if cf := vm.classFileOfClassObject(args[0]); cf != nil && cf.IsRecord() {
+				return IntValue(1), nil
+			}
+			return IntValue(0), nil
+		},
+		"java/lang/Class.getModifiers:()I": func(vm *VM, args []Value) (Value, error) {
+			cf := vm.classFileOfClassObject(args[0])
+			if cf == nil {
+				return IntValue(classfile.AccPublic), nil
+			}
+			// ACC_SUPER is a class file artifact, not a source modifier
+			return IntValue(int32(cf.ModifierFlags() &^ classfile.AccSuper)), nil
+		},
+		"java/lang/Class.desiredAssertionStatus0:(Ljava/lang/Class;)Z": nativeFalse,
+		"java/lang/Class.desiredAssertionStatus:()Z":                   nativeFalse,
+		"java/lang/Class.registerNatives:()V":                          nativeNoop,
+		"java/lang/Class.isArray:()Z": func(vm *VM, args []Value) (Value, error) {
+			if strings.HasPrefix(classObjectName(args[0]), "[") {
+				return IntValue(1), nil
+			}
+			return IntValue(0), nil
+		},
+		"java/lang/Class.isPrimitive:()Z": func(vm *VM, args []Value) (Value, error) {
+			if _, ok := primitiveDescriptors[classObjectName(args[0])]; ok {
+				return IntValue(1), nil
+			}
+			return IntValue(0), nil
+		},
+		"java/lang/Class.forName0:(Ljava/lang/String;ZLjava/lang/ClassLoader;Ljava/lang/Class;)Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			name, _ := extractGoString(args[0])
+			return vm.classObject(strings.ReplaceAll(name, ".", "/")), nil
+		},
+		"java/lang/Class.getComponentType:()Ljava/lang/Class;": func(vm *VM, args []Value) (Value, error) {
+			name := classObjectName(args[0])
+			if !strings.HasPrefix(name, "[") {
+				return NullValue(), nil
+			}
+			return vm.classObject(descriptorClassName(name[1:])), nil
+		},
+		"java/lang/Class.isAssignableFrom:(Ljava/lang/Class;)Z": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(1), nil
+		},
+
+		"java/lang/Float.floatToRawIntBits:(F)I": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(int32(math.Float32bits(args[0].Float))), nil
+		},
+		"java/lang/Float.intBitsToFloat:(I)F": func(vm *VM, args []Value) (Value, error) {
+			return FloatValue(math.Float32frombits(uint32(args[0].Int))), nil
+		},
+		"java/lang/Float.isNaN:(F)Z": func(vm *VM, args []Value) (Value, error) {
+			if f := args[0].Float; f != f {
+				return IntValue(1), nil
+			}
+			return IntValue(0), nil
+		},
+
+		"java/lang/Double.doubleToRawLongBits:(D)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(int64(math.Float64bits(args[0].Double))), nil
+		},
+		"java/lang/Double.longBitsToDouble:(J)D": func(vm *VM, args []Value) (Value, error) {
+			return DoubleValue(math.Float64frombits(uint64(args[0].Long))), nil
+		},
+
+		"java/lang/System.registerNatives:()V": nativeNoop,
+		"java/lang/System.arraycopy:(Ljava/lang/Object;ILjava/lang/Object;II)V": func(vm *VM, args []Value) (Value, error) {
+			return vm.nativeArraycopy(args)
+		},
+		"java/lang/System.nanoTime:()J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(vm.clock().Nanotime()), nil
+		},
+		"java/lang/System.currentTimeMillis:()J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(vm.clock().Now().UnixMilli()), nil
+		},
+
+		"java/lang/Throwable.fillInStackTrace:(I)Ljava/lang/Throwable;": func(vm *VM, args []Value) (Value, error) {
+			if exc, ok := args[0].Ref.(*JObject); ok {
+				vm.fillInStackTrace(exc)
+			}
+			return args[0], nil
+		},
+
+		"java/lang/String.intern:()Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			return args[0], nil
+		},
+
+		"java/lang/StringUTF16.isBigEndian:()Z": nativeFalse,
+
+		"java/lang/Thread.currentThread:()Ljava/lang/Thread;": func(vm *VM, args []Value) (Value, error) {
+			return vm.currentThread(), nil
+		},
+		"java/lang/Thread.setPriority:(I)V": nativeNoop,
+		"java/lang/Thread.holdsLock:(Ljava/lang/Object;)Z": func(vm *VM, args []Value) (Value, error) {
+			if args[0].Type == TypeNull || args[0].Ref == nil {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			if vm.holdsMonitor(args[0]) {
+				return IntValue(1), nil
+			}
+			return IntValue(0), nil
+		},
+
+		"java/lang/Runtime.maxMemory:()J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(256 * 1024 * 1024), nil
+		},
+
+		"java/lang/reflect/Array.newArray:(Ljava/lang/Class;I)Ljava/lang/Object;": func(vm *VM, args []Value) (Value, error) {
+			length := int(args[1].Int)
+			if length < 0 {
+				return Value{}, negativeArraySize(length)
+			}
+			name := classObjectName(args[0])
+			component := primitiveDescriptors[name]
+			if component == "" {
+				component = classDescriptor(name)
+			}
+			return RefValue(NewArray(component, length)), nil
+		},
+	})
+}
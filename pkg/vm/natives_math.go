@@ -0,0 +1,175 @@
+package vm
+
+import "math"
+
+// init registers java.lang.Math and StrictMath. The JDK implements most of
+// Math in Java on top of StrictMath's natives, but both are registered so
+// that either class runs natively whichever of its methods are declared
+// native. The results follow Go's math package, which may differ from
+// fdlibm in the last place for the transcendental functions.
+func init() {
+	methods := nativeRegistry{
+		"sin:(D)D":            unaryDouble(math.Sin),
+		"cos:(D)D":            unaryDouble(math.Cos),
+		"tan:(D)D":            unaryDouble(math.Tan),
+		"asin:(D)D":           unaryDouble(math.Asin),
+		"acos:(D)D":           unaryDouble(math.Acos),
+		"atan:(D)D":           unaryDouble(math.Atan),
+		"sinh:(D)D":           unaryDouble(math.Sinh),
+		"cosh:(D)D":           unaryDouble(math.Cosh),
+		"tanh:(D)D":           unaryDouble(math.Tanh),
+		"exp:(D)D":            unaryDouble(math.Exp),
+		"expm1:(D)D":          unaryDouble(math.Expm1),
+		"log:(D)D":            unaryDouble(math.Log),
+		"log10:(D)D":          unaryDouble(math.Log10),
+		"log1p:(D)D":          unaryDouble(math.Log1p),
+		"sqrt:(D)D":           unaryDouble(math.Sqrt),
+		"cbrt:(D)D":           unaryDouble(math.Cbrt),
+		"floor:(D)D":          unaryDouble(math.Floor),
+		"ceil:(D)D":           unaryDouble(math.Ceil),
+		"rint:(D)D":           unaryDouble(math.RoundToEven),
+		"abs:(D)D":            unaryDouble(math.Abs),
+		"atan2:(DD)D":         binaryDouble(math.Atan2),
+		"hypot:(DD)D":         binaryDouble(math.Hypot),
+		"pow:(DD)D":           binaryDouble(javaPow),
+		"IEEEremainder:(DD)D": binaryDouble(math.Remainder),
+		"min:(DD)D":           binaryDouble(math.Min),
+		"max:(DD)D":           binaryDouble(math.Max),
+		"round:(D)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(doubleToLong(roundHalfUp(args[0].Double))), nil
+		},
+		"round:(F)I": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(doubleToInt(roundHalfUp(float64(args[0].Float)))), nil
+		},
+		"abs:(F)F":  unaryFloat(math.Abs),
+		"min:(FF)F": binaryFloat(math.Min),
+		"max:(FF)F": binaryFloat(math.Max),
+		"abs:(I)I": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(absInt(args[0].Int)), nil
+		},
+		"min:(II)I": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(min(args[0].Int, args[1].Int)), nil
+		},
+		"max:(II)I": func(vm *VM, args []Value) (Value, error) {
+			return IntValue(max(args[0].Int, args[1].Int)), nil
+		},
+		"abs:(J)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(absInt(args[0].Long)), nil
+		},
+		"min:(JJ)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(min(args[0].Long, args[1].Long)), nil
+		},
+		"max:(JJ)J": func(vm *VM, args []Value) (Value, error) {
+			return LongValue(max(args[0].Long, args[1].Long)), nil
+		},
+		"floorDiv:(II)I": func(vm *VM, args []Value) (Value, error) {
+			return intResult(floorDiv(args[0].Int, args[1].Int))
+		},
+		"floorDiv:(JI)J": func(vm *VM, args []Value) (Value, error) {
+			return longResult(floorDiv(args[0].Long, int64(args[1].Int)))
+		},
+		"floorDiv:(JJ)J": func(vm *VM, args []Value) (Value, error) {
+			return longResult(floorDiv(args[0].Long, args[1].Long))
+		},
+		"floorMod:(II)I": func(vm *VM, args []Value) (Value, error) {
+			return intResult(floorMod(args[0].Int, args[1].Int))
+		},
+		"floorMod:(JJ)J": func(vm *VM, args []Value) (Value, error) {
+			return longResult(floorMod(args[0].Long, args[1].Long))
+		},
+		"floorMod:(JI)I": func(vm *VM, args []Value) (Value, error) {
+			m, err := floorMod(args[0].Long, int64(args[1].Int))
+			return IntValue(int32(m)), err
+		},
+	}
+	natives := nativeRegistry{}
+	for key, fn := range methods {
+		natives["java/lang/Math."+key] = fn
+		natives["java/lang/StrictMath."+key] = fn
+	}
+	builtinNatives.add(natives)
+}
+
+// unaryDouble adapts a function of a double.
+func unaryDouble(f func(float64) float64) NativeMethod {
+	return func(vm *VM, args []Value) (Value, error) {
+		return DoubleValue(f(args[0].Double)), nil
+	}
+}
+
+// binaryDouble adapts a function of two doubles.
+func binaryDouble(f func(float64, float64) float64) NativeMethod {
+	return func(vm *VM, args []Value) (Value, error) {
+		return DoubleValue(f(args[0].Double, args[1].Double)), nil
+	}
+}
+
+// unaryFloat adapts a double function that is exact for floats.
+func unaryFloat(f func(float64) float64) NativeMethod {
+	return func(vm *VM, args []Value) (Value, error) {
+		return FloatValue(float32(f(float64(args[0].Float)))), nil
+	}
+}
+
+// binaryFloat adapts a double function of two arguments that is exact for
+// floats.
+func binaryFloat(f func(float64, float64) float64) NativeMethod {
+	return func(vm *VM, args []Value) (Value, error) {
+		return FloatValue(float32(f(float64(args[0].Float), float64(args[1].Float)))), nil
+	}
+}
+
+func intResult(v int32, err error) (Value, error)  { return IntValue(v), err }
+func longResult(v int64, err error) (Value, error) { return LongValue(v), err }
+
+// javaPow is Math.pow, which unlike math.Pow is NaN for a NaN exponent
+// and for ±1 raised to an infinity.
+func javaPow(x, y float64) float64 {
+	if math.IsNaN(y) || math.IsInf(y, 0) && math.Abs(x) == 1 {
+		return math.NaN()
+	}
+	return math.Pow(x, y)
+}
+
+// roundHalfUp rounds d to the nearest integer, ties towards positive
+// infinity, as Math.round does before converting to an integer type.
+func roundHalfUp(d float64) float64 {
+	r := math.Floor(d)
+	if d-r >= 0.5 {
+		r++
+	}
+	return r
+}
+
+// absInt is Math.abs, which leaves the most negative value unchanged.
+func absInt[T int32 | int64](x T) T {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// floorDiv is Math.floorDiv: the quotient rounded towards negative
+// infinity.
+func floorDiv[T int32 | int64](x, y T) (T, error) {
+	if y == 0 {
+		return 0, divisionByZero()
+	}
+	q := x / y
+	if (x%y != 0) && ((x < 0) != (y < 0)) {
+		q--
+	}
+	return q, nil
+}
+
+// floorMod is Math.floorMod: the remainder with the sign of the divisor.
+func floorMod[T int32 | int64](x, y T) (T, error) {
+	if y == 0 {
+		return 0, divisionByZero()
+	}
+	m := x % y
+	if m != 0 && ((m < 0) != (y < 0)) {
+		m += y
+	}
+	return m, nil
+}
@@ -0,0 +1,58 @@
+package vm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMathNatives(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	for _, c := range []struct {
+		method, desc string
+		args         []Value
+		want         Value
+	}{
+		{"sin", "(D)D", []Value{DoubleValue(0)}, DoubleValue(0)},
+		{"atan2", "(DD)D", []Value{DoubleValue(1), DoubleValue(1)}, DoubleValue(math.Pi / 4)},
+		{"log10", "(D)D", []Value{DoubleValue(1000)}, DoubleValue(3)},
+		{"cbrt", "(D)D", []Value{DoubleValue(-27)}, DoubleValue(-3)},
+		{"hypot", "(DD)D", []Value{DoubleValue(3), DoubleValue(4)}, DoubleValue(5)},
+		{"rint", "(D)D", []Value{DoubleValue(2.5)}, DoubleValue(2)},
+		{"pow", "(DD)D", []Value{DoubleValue(2), DoubleValue(10)}, DoubleValue(1024)},
+		{"round", "(D)J", []Value{DoubleValue(-2.5)}, LongValue(-2)},
+		{"round", "(D)J", []Value{DoubleValue(0.49999999999999994)}, LongValue(0)},
+		{"round", "(D)J", []Value{DoubleValue(math.NaN())}, LongValue(0)},
+		{"round", "(D)J", []Value{DoubleValue(1e300)}, LongValue(math.MaxInt64)},
+		{"round", "(F)I", []Value{FloatValue(2.5)}, IntValue(3)},
+		{"abs", "(I)I", []Value{IntValue(math.MinInt32)}, IntValue(math.MinInt32)},
+		{"abs", "(J)J", []Value{LongValue(-7)}, LongValue(7)},
+		{"abs", "(F)F", []Value{FloatValue(-1.5)}, FloatValue(1.5)},
+		{"min", "(II)I", []Value{IntValue(-1), IntValue(2)}, IntValue(-1)},
+		{"max", "(JJ)J", []Value{LongValue(-1), LongValue(2)}, LongValue(2)},
+		{"min", "(DD)D", []Value{DoubleValue(0), DoubleValue(math.Copysign(0, -1))}, DoubleValue(math.Copysign(0, -1))},
+		{"max", "(FF)F", []Value{FloatValue(1), FloatValue(2)}, FloatValue(2)},
+		{"floorDiv", "(II)I", []Value{IntValue(-7), IntValue(2)}, IntValue(-4)},
+		{"floorDiv", "(JJ)J", []Value{LongValue(7), LongValue(-2)}, LongValue(-4)},
+		{"floorMod", "(II)I", []Value{IntValue(-7), IntValue(2)}, IntValue(1)},
+		{"floorMod", "(JI)I", []Value{LongValue(7), IntValue(-2)}, IntValue(-1)},
+	} {
+		for _, class := range []string{"java/lang/Math", "java/lang/StrictMath"} {
+			got, err := v.executeNativeMethod(class, c.method, c.desc, c.args)
+			if err != nil || got != c.want || math.Signbit(got.Double) != math.Signbit(c.want.Double) {
+				t.Errorf("%s.%s%s%v: got %v, %v, want %v", class, c.method, c.desc, c.args, got, err, c.want)
+			}
+		}
+	}
+
+	for _, c := range []struct{ x, y float64 }{{1, math.NaN()}, {-1, math.Inf(1)}, {math.NaN(), 1}} {
+		if got, _ := v.executeNativeMethod("java/lang/Math", "pow", "(DD)D", []Value{DoubleValue(c.x), DoubleValue(c.y)}); !math.IsNaN(got.Double) {
+			t.Errorf("pow(%v, %v): got %v, want NaN", c.x, c.y, got.Double)
+		}
+	}
+	if got, _ := v.executeNativeMethod("java/lang/Math", "max", "(DD)D", []Value{DoubleValue(1), DoubleValue(math.NaN())}); !math.IsNaN(got.Double) {
+		t.Errorf("max(1, NaN): got %v", got.Double)
+	}
+	if _, err := v.executeNativeMethod("java/lang/Math", "floorMod", "(II)I", []Value{IntValue(1), IntValue(0)}); !isJavaException(err, "java/lang/ArithmeticException") {
+		t.Errorf("floorMod(1, 0): got %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestRegisterNative(t *testing.T) {
+	lib := classfile.NewBuilder("app/Lib", "java/lang/Object")
+	lib.AddMethod(classfile.AccStatic|AccNative, "add", "(II)I", nil)
+	lib.AddMethod(classfile.AccStatic|AccNative, "fail", "()V", nil)
+	b := classfile.NewBuilder("app/Main", "java/lang/Object")
+	add := b.Methodref("app/Lib", "add", "(II)I")
+	fail := b.Methodref("app/Lib", "fail", "()V")
+	b.AddMethod(classfile.AccStatic, "add", "()I", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code:     []byte{OpIconst2, OpIconst3, OpInvokestatic, byte(add >> 8), byte(add), OpIreturn},
+	})
+	b.AddMethod(classfile.AccStatic, "fail", "()V", &classfile.CodeAttribute{
+		Code: []byte{OpInvokestatic, byte(fail >> 8), byte(fail), OpReturn},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"app/Main": cf, "app/Lib": lib.Build()})
+
+	if _, err := v.executeMethod(cf, cf.FindMethodByName("add"), nil); err == nil {
+		t.Error("unregistered native: no error")
+	}
+
+	v.RegisterNative("app.Lib", "add", "(II)I", func(vm *VM, args []Value) (Value, error) {
+		return IntValue(args[0].Int + args[1].Int), nil
+	})
+	v.RegisterNative("app/Lib", "fail", "()V", func(vm *VM, args []Value) (Value, error) {
+		return Value{}, NewJavaExceptionMessage("java/lang/IllegalStateException", "failed")
+	})
+	if ret, err := v.executeMethod(cf, cf.FindMethodByName("add"), nil); err != nil || ret != IntValue(5) {
+		t.Errorf("add: got %v, %v, want 5", ret, err)
+	}
+	if _, err := v.executeMethod(cf, cf.FindMethodByName("fail"), nil); !isJavaException(err, "java/lang/IllegalStateException") {
+		t.Errorf("fail: got %v, want IllegalStateException", err)
+	}
+
+	// Registered natives replace built-in ones, for this VM only.
+	v.RegisterNative("java/lang/System", "nanoTime", "()J", func(vm *VM, args []Value) (Value, error) {
+		return LongValue(42), nil
+	})
+	if ret, err := v.executeNativeMethod("java/lang/System", "nanoTime", "()J", nil); err != nil || ret != LongValue(42) {
+		t.Errorf("replaced nanoTime: got %v, %v, want 42", ret, err)
+	}
+	if ret, err := NewVM(mapClassLoader{}).executeNativeMethod("java/lang/System", "nanoTime", "()J", nil); err != nil || ret == LongValue(42) {
+		t.Errorf("nanoTime of another VM: got %v, %v", ret, err)
+	}
+}
@@ -0,0 +1,13 @@
+package vm
+
+// init registers the natives of java.util.
+func init() {
+	builtinNatives.add(nativeRegistry{
+		"java/util/TimeZone.getSystemTimeZoneID:(Ljava/lang/String;)Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			return RefValue(vm.systemProperties()["user.timezone"]), nil
+		},
+		"java/util/TimeZone.getSystemGMTOffsetID:()Ljava/lang/String;": func(vm *VM, args []Value) (Value, error) {
+			return RefValue(gmtOffsetID(vm.timeZone())), nil
+		},
+	})
+}
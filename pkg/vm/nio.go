@@ -0,0 +1,558 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"unicode/utf8"
+)
+
+// java.nio.file is short-circuited: Path.of, Paths.get and File.toPath
+// return native paths, and the common Files methods act on the host file
+// system directly instead of running the JDK's file system providers. The
+// state of the native objects lives in hidden fields:
+//
+//	UnixPath:            _path (string)
+//	UnixDirectoryStream: _entries ([]Value of paths), _iterated (bool)
+//	directory iterator:  _entries, _pos (int)
+//
+// Like the file streams, Files only touches the host file system when
+// VM.FileAccess is set.
+const (
+	unixPathClass        = "sun/nio/fs/UnixPath"
+	unixDirStreamClass   = "sun/nio/fs/UnixDirectoryStream"
+	unixDirIteratorClass = "sun/nio/fs/UnixDirectoryStream$UnixDirectoryIterator"
+)
+
+// newPath returns a native path for p, with redundant slashes removed as
+// UnixPath.normalizeAndCheck does.
+func newPath(p string) Value {
+	for strings.Contains(p, "//") {
+		p = strings.ReplaceAll(p, "//", "/")
+	}
+	if len(p) > 1 {
+		p = strings.TrimSuffix(p, "/")
+	}
+	return RefValue(&JObject{ClassName: unixPathClass, Fields: map[string]Value{"_path": RefValue(p)}})
+}
+
+// pathString returns the string of a native path, or throws
+// NullPointerException for null.
+func pathString(v Value) (string, error) {
+	obj, ok := v.Ref.(*JObject)
+	if !ok || v.Type == TypeNull {
+		return "", NewJavaException("java/lang/NullPointerException")
+	}
+	if obj.ClassName == "java/io/File" {
+		return filePath(v)
+	}
+	s, ok := obj.Fields["_path"].Ref.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a native path", obj.ClassName)
+	}
+	return s, nil
+}
+
+// pathNames splits a path into its name elements.
+func pathNames(p string) []string {
+	p = strings.TrimPrefix(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// joinPath implements Path.of(first, more...): the non-empty strings are
+// joined with slashes.
+func joinPath(first string, more *JArray) (string, error) {
+	parts := []string{first}
+	for i := 0; more != nil && i < more.Len(); i++ {
+		s, ok := extractGoString(more.Get(i))
+		if !ok {
+			return "", NewJavaException("java/lang/NullPointerException")
+		}
+		parts = append(parts, s)
+	}
+	var nonEmpty []string
+	for _, s := range parts {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+	return strings.Join(nonEmpty, "/"), nil
+}
+
+// handlePathStatic handles Path.of and Paths.get. It reports false for
+// other methods.
+func (vm *VM) handlePathStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	if methodName != "of" && methodName != "get" || descriptor != "(Ljava/lang/String;[Ljava/lang/String;)Ljava/nio/file/Path;" {
+		return Value{}, false, nil
+	}
+	first, ok := extractGoString(args[0])
+	if !ok {
+		return Value{}, true, NewJavaException("java/lang/NullPointerException")
+	}
+	more, _ := args[1].Ref.(*JArray)
+	p, err := joinPath(first, more)
+	if err != nil {
+		return Value{}, true, err
+	}
+	return newPath(p), true, nil
+}
+
+// handlePathMethod handles the methods of native paths and directory
+// streams, and File.toPath. It reports false for other objects and
+// methods.
+func (vm *VM) handlePathMethod(obj *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch obj.ClassName {
+	case "java/io/File":
+		if methodName+":"+descriptor == "toPath:()Ljava/nio/file/Path;" {
+			p, err := filePath(RefValue(obj))
+			return newPath(p), true, err
+		}
+	case unixPathClass:
+		return vm.pathMethod(obj, methodName, descriptor, args)
+	case unixDirStreamClass:
+		switch methodName + ":" + descriptor {
+		case "iterator:()Ljava/util/Iterator;":
+			if _, done := obj.Fields["_iterated"]; done {
+				return Value{}, true, NewJavaExceptionMessage("java/lang/IllegalStateException", "Iterator already obtained")
+			}
+			obj.Fields["_iterated"] = IntValue(1)
+			return RefValue(&JObject{ClassName: unixDirIteratorClass, Fields: map[string]Value{"_entries": obj.Fields["_entries"], "_pos": IntValue(0)}}), true, nil
+		case "close:()V":
+			return Value{}, true, nil
+		}
+	case unixDirIteratorClass:
+		entries, _ := obj.Fields["_entries"].Ref.([]Value)
+		pos := int(obj.Fields["_pos"].Int)
+		switch methodName + ":" + descriptor {
+		case "hasNext:()Z":
+			return boolValue(pos < len(entries)), true, nil
+		case "next:()Ljava/lang/Object;":
+			if pos >= len(entries) {
+				return Value{}, true, NewJavaException("java/util/NoSuchElementException")
+			}
+			obj.Fields["_pos"] = IntValue(int32(pos + 1))
+			return entries[pos], true, nil
+		}
+	}
+	return Value{}, false, nil
+}
+
+func (vm *VM) pathMethod(obj *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
+	p, _ := obj.Fields["_path"].Ref.(string)
+	names := pathNames(p)
+	absolute := strings.HasPrefix(p, "/")
+	// other returns the path or string argument as a path string
+	other := func() (string, error) {
+		if s, ok := args[0].Ref.(string); ok {
+			return pathString(newPath(s))
+		}
+		return pathString(args[0])
+	}
+
+	switch methodName + ":" + descriptor {
+	case "toString:()Ljava/lang/String;":
+		return RefValue(p), true, nil
+	case "hashCode:()I":
+		return IntValue(stringHashCode(p)), true, nil
+	case "equals:(Ljava/lang/Object;)Z":
+		o, ok := args[0].Ref.(*JObject)
+		return boolValue(ok && o.ClassName == unixPathClass && o.Fields["_path"].Ref == p), true, nil
+	case "compareTo:(Ljava/nio/file/Path;)I", "compareTo:(Ljava/lang/Object;)I":
+		q, err := pathString(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		return IntValue(int32(compareChars(p, q))), true, nil
+	case "isAbsolute:()Z":
+		return boolValue(absolute), true, nil
+	case "getNameCount:()I":
+		if p == "" {
+			return IntValue(1), true, nil // the empty path has one empty name
+		}
+		return IntValue(int32(len(names))), true, nil
+	case "getName:(I)Ljava/nio/file/Path;":
+		i := int(args[0].Int)
+		if i < 0 || i >= len(names) {
+			return Value{}, true, NewJavaException("java/lang/IllegalArgumentException")
+		}
+		return newPath(names[i]), true, nil
+	case "getFileName:()Ljava/nio/file/Path;":
+		if p == "" {
+			return RefValue(obj), true, nil
+		}
+		if len(names) == 0 {
+			return NullValue(), true, nil
+		}
+		return newPath(names[len(names)-1]), true, nil
+	case "getParent:()Ljava/nio/file/Path;":
+		i := strings.LastIndexByte(p, '/')
+		switch {
+		case len(names) == 0 || i < 0:
+			return NullValue(), true, nil
+		case i == 0:
+			return newPath("/"), true, nil
+		}
+		return newPath(p[:i]), true, nil
+	case "getRoot:()Ljava/nio/file/Path;":
+		if absolute {
+			return newPath("/"), true, nil
+		}
+		return NullValue(), true, nil
+	case "resolve:(Ljava/lang/String;)Ljava/nio/file/Path;", "resolve:(Ljava/nio/file/Path;)Ljava/nio/file/Path;":
+		q, err := other()
+		if err != nil {
+			return Value{}, true, err
+		}
+		switch {
+		case strings.HasPrefix(q, "/") || p == "":
+			return newPath(q), true, nil
+		case q == "":
+			return RefValue(obj), true, nil
+		}
+		return newPath(p + "/" + q), true, nil
+	case "resolveSibling:(Ljava/lang/String;)Ljava/nio/file/Path;", "resolveSibling:(Ljava/nio/file/Path;)Ljava/nio/file/Path;":
+		q, err := other()
+		if err != nil {
+			return Value{}, true, err
+		}
+		parent, _, _ := vm.pathMethod(obj, "getParent", "()Ljava/nio/file/Path;", nil)
+		if parent.Type == TypeNull {
+			return newPath(q), true, nil
+		}
+		return vm.pathMethod(parent.Ref.(*JObject), "resolve", "(Ljava/lang/String;)Ljava/nio/file/Path;", []Value{RefValue(q)})
+	case "startsWith:(Ljava/lang/String;)Z", "startsWith:(Ljava/nio/file/Path;)Z", "endsWith:(Ljava/lang/String;)Z", "endsWith:(Ljava/nio/file/Path;)Z":
+		q, err := other()
+		if err != nil {
+			return Value{}, true, err
+		}
+		prefix := pathNames(q)
+		if len(prefix) > len(names) {
+			return boolValue(false), true, nil
+		}
+		if methodName == "startsWith" {
+			if strings.HasPrefix(q, "/") != absolute {
+				return boolValue(false), true, nil
+			}
+			return boolValue(strings.Join(names[:len(prefix)], "/") == strings.Join(prefix, "/")), true, nil
+		}
+		if strings.HasPrefix(q, "/") && (!absolute || len(prefix) != len(names)) {
+			return boolValue(false), true, nil
+		}
+		return boolValue(strings.Join(names[len(names)-len(prefix):], "/") == strings.Join(prefix, "/")), true, nil
+	case "normalize:()Ljava/nio/file/Path;":
+		clean := filepath.Clean(p)
+		if clean == "." {
+			clean = ""
+		}
+		return newPath(clean), true, nil
+	case "toAbsolutePath:()Ljava/nio/file/Path;":
+		if absolute {
+			return RefValue(obj), true, nil
+		}
+		dir := vm.systemProperties()["user.dir"]
+		if p == "" {
+			return newPath(dir), true, nil
+		}
+		return newPath(dir + "/" + p), true, nil
+	case "toFile:()Ljava/io/File;":
+		prefix := int32(0)
+		if absolute {
+			prefix = 1
+		}
+		return RefValue(&JObject{ClassName: "java/io/File", Fields: map[string]Value{"path": RefValue(p), "prefixLength": IntValue(prefix)}}), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// stringHashCode returns String.hashCode of s.
+func stringHashCode(s string) int32 {
+	var h int32
+	for _, c := range stringChars(s) {
+		h = 31*h + int32(c)
+	}
+	return h
+}
+
+// checkFileAccess throws SecurityException unless VM.FileAccess lets the
+// program perform action, a FilePermission action, on path.
+func (vm *VM) checkFileAccess(path, action string) error {
+	if vm.FileAccess {
+		return nil
+	}
+	return NewJavaExceptionMessage("java/lang/SecurityException",
+		fmt.Sprintf("access denied (\"java.io.FilePermission\" \"%s\" \"%s\")", path, action))
+}
+
+// fileSystemException converts a host file system error on path to the
+// java.nio.file exception the JDK throws for its errno.
+func fileSystemException(path string, err error) *JavaException {
+	// ENOTEMPTY is also an fs.ErrExist
+	switch {
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return NewJavaExceptionMessage("java/nio/file/DirectoryNotEmptyException", path)
+	case errors.Is(err, fs.ErrNotExist):
+		return NewJavaExceptionMessage("java/nio/file/NoSuchFileException", path)
+	case errors.Is(err, fs.ErrExist):
+		return NewJavaExceptionMessage("java/nio/file/FileAlreadyExistsException", path)
+	case errors.Is(err, fs.ErrPermission):
+		return NewJavaExceptionMessage("java/nio/file/AccessDeniedException", path)
+	case errors.Is(err, syscall.ENOTDIR):
+		return NewJavaExceptionMessage("java/nio/file/NotDirectoryException", path)
+	}
+	return NewJavaExceptionMessage("java/nio/file/FileSystemException", path+": "+errnoMessage(err))
+}
+
+// openOptionFlags converts an OpenOption[] of StandardOpenOption constants
+// to os.OpenFile flags for Files.write. No options means CREATE,
+// TRUNCATE_EXISTING and WRITE.
+func openOptionFlags(options Value) (int, error) {
+	arr, _ := options.Ref.(*JArray)
+	if arr == nil || arr.Len() == 0 {
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC, nil
+	}
+	flag := os.O_WRONLY
+	for i := 0; i < arr.Len(); i++ {
+		opt, ok := arr.Get(i).Ref.(*JObject)
+		if !ok {
+			return 0, NewJavaException("java/lang/NullPointerException")
+		}
+		switch name, _ := extractGoString(opt.Fields["name"]); name {
+		case "APPEND":
+			flag |= os.O_APPEND
+		case "CREATE":
+			flag |= os.O_CREATE
+		case "CREATE_NEW":
+			flag |= os.O_CREATE | os.O_EXCL
+		case "TRUNCATE_EXISTING":
+			flag |= os.O_TRUNC
+		case "SYNC", "DSYNC":
+			flag |= os.O_SYNC
+		case "WRITE", "NOFOLLOW_LINKS":
+		default:
+			return 0, NewJavaExceptionMessage("java/lang/UnsupportedOperationException", name+" not supported")
+		}
+	}
+	if flag&os.O_APPEND != 0 && flag&os.O_TRUNC != 0 {
+		return 0, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "READ + APPEND not allowed")
+	}
+	return flag, nil
+}
+
+// newStringList builds a java/util/ArrayList of strings with the JDK's
+// ArrayList.
+func (vm *VM) newStringList(elems []string) (Value, error) {
+	list := RefValue(&JObject{ClassName: "java/util/ArrayList", Fields: make(map[string]Value)})
+	cf, ctor, err := vm.resolveMethod("java/util/ArrayList", "<init>", "()V")
+	if err != nil {
+		return Value{}, err
+	}
+	if _, err := vm.executeMethod(cf, ctor, []Value{list}); err != nil {
+		return Value{}, err
+	}
+	addCf, add, err := vm.resolveMethod("java/util/ArrayList", "add", "(Ljava/lang/Object;)Z")
+	if err != nil {
+		return Value{}, err
+	}
+	for _, s := range elems {
+		if _, err := vm.executeMethod(addCf, add, []Value{list, RefValue(s)}); err != nil {
+			return Value{}, err
+		}
+	}
+	return list, nil
+}
+
+// handleFilesStatic handles the static methods of java/nio/file/Files. It
+// reports false for methods it does not handle.
+func (vm *VM) handleFilesStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	params := paramDescriptors(descriptor)
+	if len(params) == 0 || params[0] != "Ljava/nio/file/Path;" {
+		return Value{}, false, nil
+	}
+	p, err := pathString(args[0])
+	if err != nil {
+		return Value{}, true, err
+	}
+	action := "read"
+	switch methodName {
+	case "write", "writeString", "createFile", "createDirectory", "createDirectories":
+		action = "write"
+	case "delete", "deleteIfExists":
+		action = "delete"
+	}
+	if err := vm.checkFileAccess(p, action); err != nil {
+		return Value{}, true, err
+	}
+	// Files resolves relative paths against the working directory
+	name := p
+	if name == "" {
+		name = "."
+	}
+
+	switch methodName + ":" + descriptor {
+	case "exists:(Ljava/nio/file/Path;[Ljava/nio/file/LinkOption;)Z":
+		_, err := os.Stat(name)
+		return boolValue(err == nil), true, nil
+	case "notExists:(Ljava/nio/file/Path;[Ljava/nio/file/LinkOption;)Z":
+		_, err := os.Stat(name)
+		return boolValue(errors.Is(err, fs.ErrNotExist)), true, nil
+	case "isDirectory:(Ljava/nio/file/Path;[Ljava/nio/file/LinkOption;)Z":
+		info, err := os.Stat(name)
+		return boolValue(err == nil && info.IsDir()), true, nil
+	case "isRegularFile:(Ljava/nio/file/Path;[Ljava/nio/file/LinkOption;)Z":
+		info, err := os.Stat(name)
+		return boolValue(err == nil && info.Mode().IsRegular()), true, nil
+	case "isReadable:(Ljava/nio/file/Path;)Z":
+		return boolValue(syscall.Access(name, 4) == nil), true, nil
+	case "isWritable:(Ljava/nio/file/Path;)Z":
+		return boolValue(syscall.Access(name, 2) == nil), true, nil
+	case "isExecutable:(Ljava/nio/file/Path;)Z":
+		return boolValue(syscall.Access(name, 1) == nil), true, nil
+	case "size:(Ljava/nio/file/Path;)J":
+		info, err := os.Stat(name)
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return LongValue(info.Size()), true, nil
+
+	case "readAllBytes:(Ljava/nio/file/Path;)[B", "readString:(Ljava/nio/file/Path;)Ljava/lang/String;",
+		"readString:(Ljava/nio/file/Path;Ljava/nio/charset/Charset;)Ljava/lang/String;", "readAllLines:(Ljava/nio/file/Path;)Ljava/util/List;",
+		"readAllLines:(Ljava/nio/file/Path;Ljava/nio/charset/Charset;)Ljava/util/List;":
+		b, err := os.ReadFile(name)
+		if errors.Is(err, syscall.EISDIR) {
+			return Value{}, true, NewJavaExceptionMessage("java/io/IOException", "Is a directory")
+		}
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		if methodName == "readAllBytes" {
+			return RefValue(goBytesToArray(b)), true, nil
+		}
+		charset := "UTF-8"
+		if len(args) == 2 {
+			name, _ := charsetName(args[1])
+			if charset, _ = canonicalCharset(name); charset == "" {
+				return Value{}, true, NewJavaExceptionMessage("java/nio/charset/UnsupportedCharsetException", name)
+			}
+		}
+		// Unlike new String(byte[]), Files reports malformed input
+		if charset == "UTF-8" && !utf8.Valid(b) {
+			return Value{}, true, NewJavaExceptionMessage("java/nio/charset/MalformedInputException", "Input length = 1")
+		}
+		s := decodeString(b, charset)
+		if methodName == "readString" {
+			return RefValue(s), true, nil
+		}
+		// Lines end at \n, \r or \r\n, as for BufferedReader.readLine
+		s = strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+		lines := strings.Split(s, "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		list, err := vm.newStringList(lines)
+		return list, true, err
+
+	case "write:(Ljava/nio/file/Path;[B[Ljava/nio/file/OpenOption;)Ljava/nio/file/Path;",
+		"writeString:(Ljava/nio/file/Path;Ljava/lang/CharSequence;[Ljava/nio/file/OpenOption;)Ljava/nio/file/Path;",
+		"writeString:(Ljava/nio/file/Path;Ljava/lang/CharSequence;Ljava/nio/charset/Charset;[Ljava/nio/file/OpenOption;)Ljava/nio/file/Path;":
+		var b []byte
+		if methodName == "write" {
+			arr, ok := args[1].Ref.(*JArray)
+			if !ok {
+				return Value{}, true, NewJavaException("java/lang/NullPointerException")
+			}
+			b = byteArrayToGo(arr, 0, arr.Len())
+		} else {
+			if args[1].Type == TypeNull || args[1].Ref == nil {
+				return Value{}, true, NewJavaException("java/lang/NullPointerException")
+			}
+			charset := "UTF-8"
+			if len(args) == 4 {
+				name, _ := charsetName(args[2])
+				if charset, _ = canonicalCharset(name); charset == "" {
+					return Value{}, true, NewJavaExceptionMessage("java/nio/charset/UnsupportedCharsetException", name)
+				}
+			}
+			b = encodeString(vm.valueToString(args[1]), charset)
+		}
+		flag, err := openOptionFlags(args[len(args)-1])
+		if err != nil {
+			return Value{}, true, err
+		}
+		f, err := os.OpenFile(name, flag, 0666)
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		_, err = f.Write(b)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return args[0], true, nil
+
+	case "createFile:(Ljava/nio/file/Path;[Ljava/nio/file/attribute/FileAttribute;)Ljava/nio/file/Path;":
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0666)
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		f.Close()
+		return args[0], true, nil
+	case "createDirectory:(Ljava/nio/file/Path;[Ljava/nio/file/attribute/FileAttribute;)Ljava/nio/file/Path;":
+		if err := os.Mkdir(name, 0777); err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return args[0], true, nil
+	case "createDirectories:(Ljava/nio/file/Path;[Ljava/nio/file/attribute/FileAttribute;)Ljava/nio/file/Path;":
+		if err := os.MkdirAll(name, 0777); err != nil {
+			if info, serr := os.Stat(name); serr == nil && !info.IsDir() {
+				return Value{}, true, NewJavaExceptionMessage("java/nio/file/FileAlreadyExistsException", p)
+			}
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return args[0], true, nil
+	case "delete:(Ljava/nio/file/Path;)V":
+		if err := os.Remove(name); err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return Value{}, true, nil
+	case "deleteIfExists:(Ljava/nio/file/Path;)Z":
+		err := os.Remove(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return boolValue(false), true, nil
+		}
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		return boolValue(true), true, nil
+
+	case "newDirectoryStream:(Ljava/nio/file/Path;)Ljava/nio/file/DirectoryStream;":
+		entries, err := os.ReadDir(name)
+		if err != nil {
+			return Value{}, true, fileSystemException(p, err)
+		}
+		names := make([]string, len(entries))
+		for i, e := range entries {
+			names[i] = e.Name()
+		}
+		sort.Strings(names)
+		paths := make([]Value, len(names))
+		for i, n := range names {
+			if p == "" {
+				paths[i] = newPath(n)
+			} else {
+				paths[i] = newPath(p + "/" + n)
+			}
+		}
+		return RefValue(&JObject{ClassName: unixDirStreamClass, Fields: map[string]Value{"_entries": RefValue(paths)}}), true, nil
+	}
+	return Value{}, false, nil
+}
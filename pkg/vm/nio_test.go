@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestPaths(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	v.SetProperty("user.dir", "/work")
+	path := func(first string, more ...string) *JObject {
+		t.Helper()
+		arr := NewArray("Ljava/lang/String;", len(more))
+		for i, s := range more {
+			arr.Set(i, RefValue(s))
+		}
+		p, handled, err := v.handlePathStatic("of", "(Ljava/lang/String;[Ljava/lang/String;)Ljava/nio/file/Path;", []Value{RefValue(first), RefValue(arr)})
+		if !handled || err != nil {
+			t.Fatalf("Path.of(%q, %q): %v, %v", first, more, handled, err)
+		}
+		return p.Ref.(*JObject)
+	}
+	str := func(p *JObject, method string, args ...Value) string {
+		t.Helper()
+		desc := "()Ljava/nio/file/Path;"
+		if len(args) > 0 {
+			desc = "(Ljava/lang/String;)Ljava/nio/file/Path;"
+		}
+		ret, handled, err := v.handlePathMethod(p, method, desc, args)
+		if !handled || err != nil {
+			t.Fatalf("%s: %v, %v", method, handled, err)
+		}
+		if ret.Type == TypeNull {
+			return "null"
+		}
+		return v.valueToString(ret)
+	}
+
+	for _, c := range []struct {
+		got, want string
+	}{
+		{v.valueToString(RefValue(path("a//b/", "", "c"))), "a/b/c"},
+		{str(path("/usr/lib/x.jar"), "getFileName"), "x.jar"},
+		{str(path("/usr"), "getParent"), "/"},
+		{str(path("usr"), "getParent"), "null"},
+		{str(path("/"), "getFileName"), "null"},
+		{str(path("a/b"), "resolve", RefValue("c")), "a/b/c"},
+		{str(path("a/b"), "resolve", RefValue("/c")), "/c"},
+		{str(path("a/b"), "resolveSibling", RefValue("c")), "a/c"},
+		{str(path("a/./b/../c"), "normalize"), "a/c"},
+		{str(path("a/.."), "normalize"), ""},
+		{str(path("src"), "toAbsolutePath"), "/work/src"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+	if ret, _, _ := v.handlePathMethod(path("/a/b/c"), "startsWith", "(Ljava/lang/String;)Z", []Value{RefValue("/a/b")}); ret.Int != 1 {
+		t.Error("/a/b/c does not start with /a/b")
+	}
+	if ret, _, _ := v.handlePathMethod(path("/a/bc"), "startsWith", "(Ljava/lang/String;)Z", []Value{RefValue("/a/b")}); ret.Int != 0 {
+		t.Error("/a/bc starts with /a/b")
+	}
+	if ret, _, _ := v.handlePathMethod(path("/a/b/c"), "endsWith", "(Ljava/lang/String;)Z", []Value{RefValue("b/c")}); ret.Int != 1 {
+		t.Error("/a/b/c does not end with b/c")
+	}
+	if ret, _, _ := v.handlePathMethod(path("a/b"), "equals", "(Ljava/lang/Object;)Z", []Value{RefValue(path("a", "b"))}); ret.Int != 1 {
+		t.Error("a/b does not equal Path.of(a, b)")
+	}
+	if ret, _, _ := v.handlePathMethod(path("x/y"), "getNameCount", "()I", nil); ret.Int != 2 {
+		t.Errorf("getNameCount: got %d", ret.Int)
+	}
+}
+
+// filesClass calls Files.writeString(Path.of(name), text) and
+// Files.readString.
+func filesClass() *classfile.ClassFile {
+	b := classfile.NewBuilder("app/Files", "java/lang/Object")
+	of := b.InterfaceMethodref("java/nio/file/Path", "of", "(Ljava/lang/String;[Ljava/lang/String;)Ljava/nio/file/Path;")
+	write := b.Methodref("java/nio/file/Files", "writeString", "(Ljava/nio/file/Path;Ljava/lang/CharSequence;[Ljava/nio/file/OpenOption;)Ljava/nio/file/Path;")
+	read := b.Methodref("java/nio/file/Files", "readString", "(Ljava/nio/file/Path;)Ljava/lang/String;")
+	str := b.Class("java/lang/String")
+	opt := b.Class("java/nio/file/OpenOption")
+	// roundTrip(name, text) returns readString(writeString(Path.of(name), text))
+	b.AddMethod(classfile.AccStatic, "roundTrip", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;", &classfile.CodeAttribute{
+		MaxStack:  3,
+		MaxLocals: 2,
+		Code: []byte{
+			OpAload0, OpIconst0, OpAnewarray, byte(str >> 8), byte(str),
+			OpInvokestatic, byte(of >> 8), byte(of),
+			OpAload1, OpIconst0, OpAnewarray, byte(opt >> 8), byte(opt),
+			OpInvokestatic, byte(write >> 8), byte(write),
+			OpInvokestatic, byte(read >> 8), byte(read),
+			OpAreturn,
+		},
+	})
+	return b.Build()
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	cf := filesClass()
+	v := NewVM(mapClassLoader{"app/Files": cf})
+	name := filepath.Join(dir, "note.txt")
+
+	_, err := v.executeMethod(cf, cf.FindMethodByName("roundTrip"), []Value{RefValue(name), RefValue("héllo")})
+	if !isJavaException(err, "java/lang/SecurityException") {
+		t.Fatalf("without FileAccess: got %v", err)
+	}
+	v.FileAccess = true
+	got, err := v.executeMethod(cf, cf.FindMethodByName("roundTrip"), []Value{RefValue(name), RefValue("héllo")})
+	if err != nil || got.Ref != "héllo" {
+		t.Fatalf("roundTrip: got %v, %v", got.Ref, err)
+	}
+
+	files := func(method, desc string, args ...Value) (Value, error) {
+		t.Helper()
+		ret, handled, err := v.handleFilesStatic(method, desc, args)
+		if !handled {
+			t.Fatalf("Files.%s%s not handled", method, desc)
+		}
+		return ret, err
+	}
+	noLinks := RefValue(NewArray("Ljava/nio/file/LinkOption;", 0))
+	noAttrs := RefValue(NewArray("Ljava/nio/file/attribute/FileAttribute;", 0))
+	if ret, _ := files("exists", "(Ljava/nio/file/Path;[Ljava/nio/file/LinkOption;)Z", newPath(name), noLinks); ret.Int != 1 {
+		t.Error("exists: written file missing")
+	}
+	if ret, _ := files("size", "(Ljava/nio/file/Path;)J", newPath(name)); ret.Long != 6 {
+		t.Errorf("size: got %d, want 6", ret.Long)
+	}
+	if _, err := files("readAllBytes", "(Ljava/nio/file/Path;)[B", newPath(filepath.Join(dir, "none"))); !isJavaException(err, "java/nio/file/NoSuchFileException") {
+		t.Errorf("readAllBytes of a missing file: got %v", err)
+	}
+	if _, err := files("createFile", "(Ljava/nio/file/Path;[Ljava/nio/file/attribute/FileAttribute;)Ljava/nio/file/Path;", newPath(name), noAttrs); !isJavaException(err, "java/nio/file/FileAlreadyExistsException") {
+		t.Errorf("createFile over a file: got %v", err)
+	}
+	if _, err := files("createDirectories", "(Ljava/nio/file/Path;[Ljava/nio/file/attribute/FileAttribute;)Ljava/nio/file/Path;", newPath(dir+"/d/e"), noAttrs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files("delete", "(Ljava/nio/file/Path;)V", newPath(dir+"/d")); !isJavaException(err, "java/nio/file/DirectoryNotEmptyException") {
+		t.Errorf("delete of a non-empty directory: got %v", err)
+	}
+
+	// Appending with StandardOpenOption.APPEND
+	appendOpt := NewArray("Ljava/nio/file/OpenOption;", 1)
+	appendOpt.Set(0, RefValue(&JObject{ClassName: "java/nio/file/StandardOpenOption", Fields: map[string]Value{"name": RefValue("APPEND")}}))
+	if _, err := files("write", "(Ljava/nio/file/Path;[B[Ljava/nio/file/OpenOption;)Ljava/nio/file/Path;", newPath(name), RefValue(goBytesToArray([]byte("\r\nbye\n"))), RefValue(appendOpt)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(name); string(b) != "héllo\r\nbye\n" {
+		t.Errorf("after append: got %q", b)
+	}
+
+	stream, err := files("newDirectoryStream", "(Ljava/nio/file/Path;)Ljava/nio/file/DirectoryStream;", newPath(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, _, err := v.handlePathMethod(stream.Ref.(*JObject), "iterator", "()Ljava/util/Iterator;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []string
+	for {
+		more, _, _ := v.handlePathMethod(it.Ref.(*JObject), "hasNext", "()Z", nil)
+		if more.Int == 0 {
+			break
+		}
+		p, _, _ := v.handlePathMethod(it.Ref.(*JObject), "next", "()Ljava/lang/Object;", nil)
+		listed = append(listed, v.valueToString(p))
+	}
+	if len(listed) != 2 || listed[0] != dir+"/d" || listed[1] != name {
+		t.Errorf("directory stream: got %q", listed)
+	}
+	if _, _, err := v.handlePathMethod(stream.Ref.(*JObject), "iterator", "()Ljava/util/Iterator;", nil); !isJavaException(err, "java/lang/IllegalStateException") {
+		t.Errorf("second iterator: got %v", err)
+	}
+}
@@ -0,0 +1,339 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// NullPointerExceptions thrown by instructions are described as JEP 358
+// describes them: what the instruction failed to do, such as
+// `Cannot invoke "String.length()"`, and, where the bytecode shows it,
+// which expression was null, as in `because "<local1>" is null`. The
+// instructions only mark the exception; the interpreter, which knows the
+// method and pc, describes it as it starts to propagate.
+//
+// The null expression is found by stepping back over the straight-line
+// code before the instruction to the one that pushed the operand. Where
+// control flow merges, or an instruction's stack effect is not simple,
+// the message says only what failed.
+
+// nullOperandField marks an exception thrown by nullOperand until the
+// interpreter describes it.
+const nullOperandField = "_nullOperand"
+
+// maxNullDetail limits how deeply an expression such as "a.b[i].c" is
+// described.
+const maxNullDetail = 5
+
+// nullOperand returns the NullPointerException an instruction throws when
+// a reference it operates on is null.
+func nullOperand() *JavaException {
+	exc := NewJavaException("java/lang/NullPointerException")
+	exc.Object.Fields[nullOperandField] = IntValue(1)
+	return exc
+}
+
+// describeNullPointer gives exc, if nullOperand created it, the message
+// describing the instruction at pc of method that threw it.
+func describeNullPointer(cf *classfile.ClassFile, method *classfile.MethodInfo, pc int, exc *JavaException) {
+	if _, marked := exc.Object.Fields[nullOperandField]; !marked {
+		return
+	}
+	delete(exc.Object.Fields, nullOperandField)
+	n := nullPointerMessage{class: cf, method: method, code: method.Code.Code}
+	if msg := n.describe(pc); msg != "" {
+		exc.Object.Fields["detailMessage"] = RefValue(msg)
+	}
+}
+
+// nullPointerMessage describes null operands in the code of a method.
+type nullPointerMessage struct {
+	class  *classfile.ClassFile
+	method *classfile.MethodInfo
+	code   []byte
+	starts []int        // instruction offsets, in order
+	merges map[int]bool // offsets control may reach other than from the previous instruction
+}
+
+// describe returns the message for a null operand of the instruction at
+// pc, or "" if the instruction does not take a reference.
+func (n *nullPointerMessage) describe(pc int) string {
+	var action string
+	var depth int // of the null operand, from the top of the stack
+	switch op := n.code[pc]; {
+	case op >= OpIaload && op <= OpSaload:
+		action, depth = "Cannot load from "+arrayKind(op-OpIaload)+" array", 1
+	case op >= OpIastore && op <= OpSastore:
+		action, depth = "Cannot store to "+arrayKind(op-OpIastore)+" array", 2
+	case op == OpArraylength:
+		action = "Cannot read the array length"
+	case op == OpAthrow:
+		action = "Cannot throw exception"
+	case op == OpMonitorenter:
+		action = "Cannot enter synchronized block"
+	case op == OpMonitorexit:
+		action = "Cannot exit synchronized block"
+	case op == OpGetfield:
+		action = fmt.Sprintf("Cannot read field %q", n.fieldName(pc))
+	case op == OpPutfield:
+		action, depth = fmt.Sprintf("Cannot assign field %q", n.fieldName(pc)), 1
+	case op == OpInvokevirtual, op == OpInvokespecial, op == OpInvokeinterface:
+		ref, err := n.methodref(pc)
+		if err != nil {
+			return ""
+		}
+		action, depth = fmt.Sprintf("Cannot invoke %q", methodName(ref)), len(paramDescriptors(ref.Descriptor))
+	default:
+		return ""
+	}
+	if cause, quoted := n.source(pc, depth, maxNullDetail); cause != "" {
+		if quoted {
+			return fmt.Sprintf("%s because %q is null", action, cause)
+		}
+		return fmt.Sprintf("%s because %s is null", action, cause)
+	}
+	return action
+}
+
+// arrayKind names the element type of an array load or store, given its
+// offset from iaload or iastore, which list the types in the same order.
+func arrayKind(i byte) string {
+	return [...]string{"int", "long", "float", "double", "object", "byte/boolean", "char", "short"}[i]
+}
+
+// source describes the value depth entries below the top of the operand
+// stack before the instruction at pc, and reports whether the description
+// is an expression to quote rather than a phrase such as "the return value
+// of ...". It returns "" if the value's origin is unclear.
+func (n *nullPointerMessage) source(pc, depth, detail int) (string, bool) {
+	if detail == 0 {
+		return "", false
+	}
+	at := n.producer(pc, depth)
+	if at < 0 {
+		return "", false
+	}
+	op := n.code[at]
+	switch {
+	case op == OpAconstNull:
+		return "null", true
+	case op >= OpIconstM1 && op <= OpIconst5:
+		return fmt.Sprint(int(op) - OpIconst0), true
+	case op == OpBipush:
+		return fmt.Sprint(int8(n.code[at+1])), true
+	case op == OpSipush:
+		return fmt.Sprint(int16(binary.BigEndian.Uint16(n.code[at+1:]))), true
+	case op == OpIload, op == OpAload, op >= OpIload0 && op <= OpIload3, op >= OpAload0 && op <= OpAload3, op == OpWide:
+		index, _, ok := localVariable(n.code, at)
+		if !ok {
+			return "", false
+		}
+		return n.localName(index), true
+	case op == OpGetstatic:
+		ref, err := classfile.ResolveFieldref(n.class.ConstantPool, binary.BigEndian.Uint16(n.code[at+1:]))
+		if err != nil {
+			return "", false
+		}
+		return javaClassName(ref.ClassName) + "." + ref.FieldName, true
+	case op == OpGetfield:
+		name := n.fieldName(at)
+		if object, quoted := n.source(at, 0, detail-1); object != "" && quoted {
+			return object + "." + name, true
+		}
+		return name, true
+	case op == OpAaload:
+		array, quotedArray := n.source(at, 1, detail-1)
+		index, quotedIndex := n.source(at, 0, detail-1)
+		if array == "" || index == "" || !quotedArray || !quotedIndex {
+			return "", false
+		}
+		return array + "[" + index + "]", true
+	case op == OpCheckcast, op == OpDup:
+		return n.source(at, 0, detail)
+	case op == OpInvokevirtual, op == OpInvokespecial, op == OpInvokestatic, op == OpInvokeinterface:
+		ref, err := n.methodref(at)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("the return value of %q", methodName(ref)), false
+	}
+	return "", false
+}
+
+// producer returns the offset of the instruction that pushed the value
+// depth entries below the top of the operand stack before the instruction
+// at pc, or -1 if it cannot tell. Values that dup and checkcast pass on
+// are attributed to them.
+func (n *nullPointerMessage) producer(pc, depth int) int {
+	if n.starts == nil {
+		n.scan()
+	}
+	i := indexOf(n.starts, pc)
+	for ; i > 0 && !n.merges[n.starts[i]]; i-- {
+		at := n.starts[i-1]
+		pops, pushes, ok := n.stackEffect(at)
+		if !ok {
+			return -1
+		}
+		if depth < pushes {
+			return at
+		}
+		depth += pops - pushes
+	}
+	return -1
+}
+
+// scan finds the instruction boundaries of the code and the instructions
+// where control flow merges: jump targets, exception handlers and
+// instructions after ones that never fall through, such as goto.
+func (n *nullPointerMessage) scan() {
+	n.starts = []int{}
+	n.merges = map[int]bool{}
+	for pc := 0; pc < len(n.code); {
+		length, err := instructionLength(n.code, pc)
+		if err != nil {
+			break
+		}
+		n.starts = append(n.starts, pc)
+		ins := decodeInstruction(n.code, pc, pc+length)
+		for _, target := range ins.jumpTargets() {
+			n.merges[target] = true
+		}
+		switch op := n.code[pc]; {
+		case op == OpGoto, op == OpGotoW, op == OpJsr, op == OpJsrW, op == OpRet, op == OpAthrow,
+			op == OpTableswitch, op == OpLookupswitch, op >= OpIreturn && op <= OpReturn:
+			n.merges[pc+length] = true
+		}
+		pc += length
+	}
+	for _, h := range n.method.Code.ExceptionHandlers {
+		n.merges[int(h.HandlerPC)] = true
+	}
+}
+
+// indexOf returns the index of pc in starts, or 0 if it is not there.
+func indexOf(starts []int, pc int) int {
+	for i, start := range starts {
+		if start == pc {
+			return i
+		}
+	}
+	return 0
+}
+
+// stackEffect returns how many values the instruction at pc pops and
+// pushes, and false for instructions that rearrange the stack in ways
+// source does not follow, or never fall through.
+func (n *nullPointerMessage) stackEffect(pc int) (pops, pushes int, ok bool) {
+	switch op := n.code[pc]; {
+	case op == OpNop, op == OpIinc, op == OpWide && n.code[pc+1] == OpIinc:
+		return 0, 0, true
+	case op >= OpAconstNull && op <= OpLdc2W, op >= OpIload && op <= OpAload3, op == OpWide && n.code[pc+1] <= OpAload,
+		op == OpGetstatic, op == OpNew:
+		return 0, 1, true
+	case op >= OpIaload && op <= OpSaload:
+		return 2, 1, true
+	case op >= OpIstore && op <= OpAstore3, op == OpWide, op == OpPop,
+		op >= OpIfeq && op <= OpIfle, op == OpIfnull, op == OpIfnonnull,
+		op == OpPutstatic, op == OpMonitorenter, op == OpMonitorexit:
+		return 1, 0, true
+	case op >= OpIastore && op <= OpSastore:
+		return 3, 0, true
+	case op == OpDup:
+		return 1, 2, true
+	case op >= OpIadd && op <= OpDrem, op >= OpIshl && op <= OpLxor, op >= OpLcmp && op <= OpDcmpg:
+		return 2, 1, true
+	case op >= OpIneg && op <= OpDneg, op >= OpI2l && op <= OpI2s,
+		op == OpGetfield, op == OpNewarray, op == OpAnewarray, op == OpArraylength,
+		op == OpCheckcast, op == OpInstanceof:
+		return 1, 1, true
+	case op >= OpIfIcmpeq && op <= OpIfAcmpne, op == OpPutfield:
+		return 2, 0, true
+	case op == OpMultianewarray:
+		return int(n.code[pc+3]), 1, true
+	case op >= OpInvokevirtual && op <= OpInvokeinterface:
+		ref, err := n.methodref(pc)
+		if err != nil {
+			return 0, 0, false
+		}
+		pops = len(paramDescriptors(ref.Descriptor))
+		if op != OpInvokestatic {
+			pops++
+		}
+		if !isVoidReturn(ref.Descriptor) {
+			pushes = 1
+		}
+		return pops, pushes, true
+	}
+	return 0, 0, false
+}
+
+// methodref resolves the method an invoke instruction at pc calls.
+func (n *nullPointerMessage) methodref(pc int) (*classfile.MethodRefInfo, error) {
+	pool := n.class.ConstantPool
+	index := binary.BigEndian.Uint16(n.code[pc+1:])
+	if n.code[pc] == OpInvokeinterface {
+		return classfile.ResolveInterfaceMethodref(pool, index)
+	}
+	return classfile.ResolveMethodref(pool, index)
+}
+
+// fieldName returns the name of the field a field instruction at pc
+// accesses.
+func (n *nullPointerMessage) fieldName(pc int) string {
+	ref, err := classfile.ResolveFieldref(n.class.ConstantPool, binary.BigEndian.Uint16(n.code[pc+1:]))
+	if err != nil {
+		return "?"
+	}
+	return ref.FieldName
+}
+
+// localName names local variable index as HotSpot does for classes
+// compiled without local variable tables: "this", "<parameterN>" or
+// "<localN>".
+func (n *nullPointerMessage) localName(index int) string {
+	slot := 0
+	if n.method.AccessFlags&classfile.AccStatic == 0 {
+		if index == 0 {
+			return "this"
+		}
+		slot = 1
+	}
+	for i, param := range paramDescriptors(n.method.Descriptor) {
+		if slot == index {
+			return fmt.Sprintf("<parameter%d>", i+1)
+		}
+		slot++
+		if param == "J" || param == "D" {
+			slot++
+		}
+	}
+	return fmt.Sprintf("<local%d>", index)
+}
+
+// methodName formats a method as "Class.name(ParamType, ...)".
+func methodName(ref *classfile.MethodRefInfo) string {
+	var params []string
+	for _, param := range paramDescriptors(ref.Descriptor) {
+		params = append(params, javaTypeName(param))
+	}
+	return fmt.Sprintf("%s.%s(%s)", javaClassName(ref.ClassName), ref.MethodName, strings.Join(params, ", "))
+}
+
+// javaTypeName formats a field descriptor as a Java type, such as "int[]".
+func javaTypeName(descriptor string) string {
+	dims := strings.LastIndexByte(descriptor, '[') + 1
+	return javaClassName(descriptorClassName(descriptor[dims:])) + strings.Repeat("[]", dims)
+}
+
+// javaClassName formats an internal class name as a binary name, leaving
+// out the package of java.lang.Object and java.lang.String as HotSpot does.
+func javaClassName(className string) string {
+	if className == "java/lang/Object" || className == "java/lang/String" {
+		return strings.TrimPrefix(className, "java/lang/")
+	}
+	return strings.ReplaceAll(className, "/", ".")
+}
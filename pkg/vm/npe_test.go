@@ -0,0 +1,113 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestNullPointerMessages(t *testing.T) {
+	b := classfile.NewBuilder("app/Npe", "java/lang/Object")
+	b.AddField(classfile.AccStatic, "head", "Lapp/Npe;", nil)
+	b.AddField(0, "next", "Lapp/Npe;", nil)
+	b.AddField(0, "value", "I", nil)
+	head := b.Fieldref("app/Npe", "head", "Lapp/Npe;")
+	next := b.Fieldref("app/Npe", "next", "Lapp/Npe;")
+	value := b.Fieldref("app/Npe", "value", "I")
+	equals := b.Methodref("java/lang/String", "equals", "(Ljava/lang/Object;)Z")
+	length := b.Methodref("java/lang/String", "length", "()I")
+	nothing := b.Methodref("app/Npe", "nothing", "()Lapp/Npe;")
+	b.AddMethod(classfile.AccStatic, "nothing", "()Lapp/Npe;", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{OpAconstNull, OpAreturn},
+	})
+	methods := []struct {
+		name, descriptor string
+		code             []byte
+	}{
+		{"equals", "(Ljava/lang/String;Ljava/lang/Object;)Z", []byte{
+			OpAload0, OpAload1, OpInvokevirtual, byte(equals >> 8), byte(equals), OpIreturn,
+		}},
+		{"load", "(I)I", []byte{
+			OpAconstNull, OpAstore1, OpAload1, OpIload0, OpIaload, OpIreturn,
+		}},
+		{"store", "(J[B)V", []byte{
+			OpAload2, OpIconst0, OpIconst1, OpBastore, OpReturn,
+		}},
+		{"length", "([[I)I", []byte{
+			OpAload0, OpBipush, 7, OpAaload, OpArraylength, OpIreturn,
+		}},
+		{"static", "()I", []byte{
+			OpGetstatic, byte(head >> 8), byte(head), OpGetfield, byte(value >> 8), byte(value), OpIreturn,
+		}},
+		{"assign", "(Lapp/Npe;)V", []byte{
+			OpAload0, OpIconst1, OpPutfield, byte(value >> 8), byte(value), OpReturn,
+		}},
+		{"returned", "()I", []byte{
+			OpInvokestatic, byte(nothing >> 8), byte(nothing), OpGetfield, byte(value >> 8), byte(value), OpIreturn,
+		}},
+		{"strings", "([Ljava/lang/String;)I", []byte{
+			OpAload0, OpIconst2, OpAaload, OpInvokevirtual, byte(length >> 8), byte(length), OpIreturn,
+		}},
+		{"athrow", "()V", []byte{
+			OpAconstNull, OpAthrow,
+		}},
+		{"monitor", "(Ljava/lang/Object;)V", []byte{
+			OpAload0, OpDup, OpAstore1, OpMonitorenter, OpReturn,
+		}},
+		// merged(flag, s) calls (flag ? null : s).length(): either
+		// expression may have been null.
+		{"merged", "(ZLjava/lang/String;)I", []byte{
+			OpIload0, OpIfeq, 0, 7, // if !flag goto 8
+			OpAconstNull, OpGoto, 0, 4, // goto 9
+			OpAload1,                                                    // 8:
+			OpInvokevirtual, byte(length >> 8), byte(length), OpIreturn, // 9:
+		}},
+	}
+	for _, m := range methods {
+		b.AddMethod(classfile.AccStatic, m.name, m.descriptor, &classfile.CodeAttribute{MaxStack: 3, MaxLocals: 4, Code: m.code})
+	}
+	// chain() reads this.next.value.
+	b.AddMethod(0, "chain", "()I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code: []byte{
+			OpAload0, OpGetfield, byte(next >> 8), byte(next), OpGetfield, byte(value >> 8), byte(value), OpIreturn,
+		},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"app/Npe": cf})
+	npe := &JObject{ClassName: "app/Npe", Fields: map[string]Value{"next": NullValue(), "value": IntValue(0)}}
+
+	for _, tt := range []struct {
+		method string
+		args   []Value
+		want   string
+	}{
+		{"equals", []Value{NullValue(), RefValue("x")}, `Cannot invoke "String.equals(Object)" because "<parameter1>" is null`},
+		{"load", []Value{IntValue(0)}, `Cannot load from int array because "<local1>" is null`},
+		{"store", []Value{LongValue(0), NullValue()}, `Cannot store to byte/boolean array because "<parameter2>" is null`},
+		{"length", []Value{RefValue(NewArray("[I", 8))}, `Cannot read the array length because "<parameter1>[7]" is null`},
+		{"static", nil, `Cannot read field "value" because "app.Npe.head" is null`},
+		{"assign", []Value{NullValue()}, `Cannot assign field "value" because "<parameter1>" is null`},
+		{"returned", nil, `Cannot read field "value" because the return value of "app.Npe.nothing()" is null`},
+		{"strings", []Value{RefValue(NewArray("Ljava/lang/String;", 3))}, `Cannot invoke "String.length()" because "<parameter1>[2]" is null`},
+		{"athrow", nil, `Cannot throw exception because "null" is null`},
+		{"monitor", []Value{NullValue()}, `Cannot enter synchronized block because "<parameter1>" is null`},
+		{"merged", []Value{IntValue(1), RefValue("s")}, `Cannot invoke "String.length()"`},
+		{"chain", []Value{RefValue(npe)}, `Cannot read field "value" because "this.next" is null`},
+	} {
+		_, err := v.executeMethod(cf, cf.FindMethodByName(tt.method), tt.args)
+		if !isJavaException(err, "java/lang/NullPointerException") {
+			t.Errorf("%s: got %v, want NullPointerException", tt.method, err)
+			continue
+		}
+		exc := err.(*JavaException)
+		if msg, _ := exc.Message(); msg != tt.want {
+			t.Errorf("%s: got message %q, want %q", tt.method, msg, tt.want)
+		}
+		if _, marked := exc.Object.Fields[nullOperandField]; marked {
+			t.Errorf("%s: exception still marked", tt.method)
+		}
+	}
+}
@@ -0,0 +1,441 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// java.text.DecimalFormat is implemented natively with US symbols. The
+// parsed pattern is kept in the hidden _format field of the JObject.
+
+// decimalFormat holds the state of a DecimalFormat instance.
+type decimalFormat struct {
+	posPrefix, posSuffix string
+	negPrefix, negSuffix string
+	minInt               int
+	minFrac, maxFrac     int
+	groupingSize         int
+	groupingUsed         bool
+	multiplier           int
+	roundingMode         string
+	parseIntegerOnly     bool
+}
+
+// newDecimalFormat parses a DecimalFormat pattern such as "#,##0.00;(#,##0.00)".
+func newDecimalFormat(pattern string) (*decimalFormat, error) {
+	df := &decimalFormat{multiplier: 1, roundingMode: "HALF_EVEN"}
+	if err := df.applyPattern(pattern); err != nil {
+		return nil, err
+	}
+	return df, nil
+}
+
+func (df *decimalFormat) applyPattern(pattern string) error {
+	positive, negative, hasNegative := splitPattern(pattern)
+	prefix, number, suffix, err := splitAffixes(positive)
+	if err != nil {
+		return err
+	}
+	df.posPrefix, df.posSuffix = prefix, suffix
+	df.multiplier = 1
+	if strings.Contains(prefix+suffix, "%") {
+		df.multiplier = 100
+	} else if strings.Contains(prefix+suffix, "‰") {
+		df.multiplier = 1000
+	}
+
+	intPart, fracPart, _ := strings.Cut(number, ".")
+	if strings.ContainsAny(number, "E") {
+		return fmt.Errorf("DecimalFormat: scientific notation is not supported: %q", pattern)
+	}
+	df.minInt = strings.Count(intPart, "0")
+	df.minFrac = strings.Count(fracPart, "0")
+	df.maxFrac = df.minFrac + strings.Count(fracPart, "#")
+	df.groupingUsed = false
+	df.groupingSize = 0
+	if i := strings.LastIndex(intPart, ","); i >= 0 {
+		df.groupingUsed = true
+		df.groupingSize = len(intPart) - i - 1
+	}
+	if df.minInt == 0 && df.maxFrac == 0 {
+		df.minInt = 1
+	}
+
+	if hasNegative {
+		negPrefix, _, negSuffix, err := splitAffixes(negative)
+		if err != nil {
+			return err
+		}
+		df.negPrefix, df.negSuffix = negPrefix, negSuffix
+	} else {
+		df.negPrefix, df.negSuffix = "-"+prefix, suffix
+	}
+	return nil
+}
+
+// splitPattern splits a pattern at its unquoted ';' into positive and negative subpatterns.
+func splitPattern(pattern string) (string, string, bool) {
+	inQuote := false
+	for i, c := range pattern {
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+		case c == ';' && !inQuote:
+			return pattern[:i], pattern[i+1:], true
+		}
+	}
+	return pattern, "", false
+}
+
+// splitAffixes separates a subpattern into literal prefix, number part and
+// literal suffix, resolving quotes and the currency sign.
+func splitAffixes(sub string) (prefix, number, suffix string, err error) {
+	var pre, num, suf strings.Builder
+	state := 0 // 0=prefix, 1=number, 2=suffix
+	inQuote := false
+	runes := []rune(sub)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if c == '\'' {
+			if i+1 < len(runes) && runes[i+1] == '\'' {
+				i++ // '' is a literal quote
+			} else {
+				inQuote = !inQuote
+				continue
+			}
+		} else if !inQuote && strings.ContainsRune("#0,.", c) {
+			if state == 2 {
+				return "", "", "", fmt.Errorf("DecimalFormat: malformed pattern %q", sub)
+			}
+			state = 1
+			num.WriteRune(c)
+			continue
+		}
+		if c == '¤' && !inQuote {
+			c = '$'
+		}
+		if state == 0 {
+			pre.WriteRune(c)
+		} else {
+			state = 2
+			suf.WriteRune(c)
+		}
+	}
+	return pre.String(), num.String(), suf.String(), nil
+}
+
+// toPattern reconstructs a pattern equivalent to the current settings.
+func (df *decimalFormat) toPattern() string {
+	intPart := strings.Repeat("0", max(df.minInt, 1))
+	if df.groupingUsed && df.groupingSize > 0 {
+		intPart = "#," + strings.Repeat("#", max(df.groupingSize-df.minInt, 0)) + strings.Repeat("0", min(df.minInt, df.groupingSize))
+	}
+	frac := strings.Repeat("0", df.minFrac) + strings.Repeat("#", df.maxFrac-df.minFrac)
+	if frac != "" {
+		frac = "." + frac
+	}
+	return df.posPrefix + intPart + frac + df.posSuffix
+}
+
+// roundDigits rounds the exact decimal digits of a non-negative number to
+// scale fraction digits using the given RoundingMode name.
+func roundDigits(intDigits, fracDigits string, scale int, mode string, negative bool) (string, string) {
+	if len(fracDigits) <= scale {
+		return intDigits, fracDigits + strings.Repeat("0", scale-len(fracDigits))
+	}
+	kept := intDigits + fracDigits[:scale]
+	rest := fracDigits[scale:]
+	restZero := strings.Trim(rest, "0") == ""
+	first := rest[0]
+	lastOdd := (kept[len(kept)-1]-'0')%2 == 1
+
+	up := false
+	switch mode {
+	case "UP":
+		up = !restZero
+	case "DOWN":
+		up = false
+	case "CEILING":
+		up = !restZero && !negative
+	case "FLOOR":
+		up = !restZero && negative
+	case "HALF_UP":
+		up = first >= '5'
+	case "HALF_DOWN":
+		up = first > '5' || (first == '5' && strings.Trim(rest[1:], "0") != "")
+	default: // HALF_EVEN
+		up = first > '5' || (first == '5' && (strings.Trim(rest[1:], "0") != "" || lastOdd))
+	}
+
+	if up {
+		b := []byte(kept)
+		i := len(b) - 1
+		for ; i >= 0; i-- {
+			if b[i] == '9' {
+				b[i] = '0'
+				continue
+			}
+			b[i]++
+			break
+		}
+		kept = string(b)
+		if i < 0 {
+			kept = "1" + kept
+		}
+	}
+	return kept[:len(kept)-scale], kept[len(kept)-scale:]
+}
+
+// formatDigits renders a number given as exact decimal digits.
+func (df *decimalFormat) formatDigits(intDigits, fracDigits string, negative bool) string {
+	intDigits, fracDigits = roundDigits(intDigits, fracDigits, df.maxFrac, df.roundingMode, negative)
+	intDigits = strings.TrimLeft(intDigits, "0")
+	for len(fracDigits) > df.minFrac && fracDigits[len(fracDigits)-1] == '0' {
+		fracDigits = fracDigits[:len(fracDigits)-1]
+	}
+	if len(intDigits) < df.minInt {
+		intDigits = strings.Repeat("0", df.minInt-len(intDigits)) + intDigits
+	}
+
+	var sb strings.Builder
+	if df.groupingUsed && df.groupingSize > 0 {
+		for i, c := range intDigits {
+			if i > 0 && (len(intDigits)-i)%df.groupingSize == 0 {
+				sb.WriteByte(',')
+			}
+			sb.WriteRune(c)
+		}
+	} else {
+		sb.WriteString(intDigits)
+	}
+	if fracDigits != "" {
+		sb.WriteByte('.')
+		sb.WriteString(fracDigits)
+	}
+	if negative {
+		return df.negPrefix + sb.String() + df.negSuffix
+	}
+	return df.posPrefix + sb.String() + df.posSuffix
+}
+
+// formatDouble formats d using the exact binary value for rounding decisions.
+func (df *decimalFormat) formatDouble(d float64) string {
+	if math.IsNaN(d) {
+		return "NaN"
+	}
+	negative := math.Signbit(d)
+	d = math.Abs(d) * float64(df.multiplier)
+	if math.IsInf(d, 0) {
+		if negative {
+			return df.negPrefix + "∞" + df.negSuffix
+		}
+		return df.posPrefix + "∞" + df.posSuffix
+	}
+	exact := strconv.FormatFloat(d, 'f', 1074, 64)
+	intDigits, fracDigits, _ := strings.Cut(exact, ".")
+	return df.formatDigits(intDigits, strings.TrimRight(fracDigits, "0"), negative)
+}
+
+// formatLong formats an integral value exactly.
+func (df *decimalFormat) formatLong(n int64) string {
+	scaled := new(big.Int).Mul(big.NewInt(n), big.NewInt(int64(df.multiplier)))
+	negative := scaled.Sign() < 0
+	return df.formatDigits(scaled.Abs(scaled).String(), "", negative)
+}
+
+// parse parses the leading number of s, returning a long when the result is
+// integral and a double otherwise, which DecimalFormat.parse boxes.
+func (df *decimalFormat) parse(s string) (Value, bool) {
+	negative := false
+	switch {
+	case df.negPrefix != df.posPrefix && df.negPrefix != "" && strings.HasPrefix(s, df.negPrefix):
+		negative = true
+		s = s[len(df.negPrefix):]
+	case strings.HasPrefix(s, df.posPrefix):
+		s = s[len(df.posPrefix):]
+	default:
+		return Value{}, false
+	}
+	var num strings.Builder
+	seenPoint := false
+	for _, c := range s {
+		if c >= '0' && c <= '9' {
+			num.WriteRune(c)
+		} else if c == ',' && df.groupingUsed && !seenPoint {
+			continue
+		} else if c == '.' && !seenPoint && !df.parseIntegerOnly {
+			seenPoint = true
+			num.WriteRune(c)
+		} else {
+			break
+		}
+	}
+	if num.Len() == 0 {
+		return Value{}, false
+	}
+	d, err := strconv.ParseFloat(num.String(), 64)
+	if err != nil {
+		return Value{}, false
+	}
+	d /= float64(df.multiplier)
+	if negative {
+		d = -d
+	}
+	if d == math.Trunc(d) && math.Abs(d) < 1<<63 && !(d == 0 && negative) {
+		return LongValue(int64(d)), true
+	}
+	return DoubleValue(d), true
+}
+
+// numberFormatFactories maps NumberFormat factory methods to their US-locale patterns.
+var numberFormatFactories = map[string]string{
+	"getInstance":         "#,##0.###",
+	"getNumberInstance":   "#,##0.###",
+	"getIntegerInstance":  "#,##0",
+	"getPercentInstance":  "#,##0%",
+	"getCurrencyInstance": "¤#,##0.00;-¤#,##0.00",
+}
+
+// isNumberFormatClass reports whether className is handled by handleDecimalFormat.
+func isNumberFormatClass(className string) bool {
+	return className == "java/text/DecimalFormat" || className == "java/text/NumberFormat"
+}
+
+// newNumberFormat implements the static NumberFormat.getXxxInstance factories.
+func newNumberFormat(methodName string) (Value, bool) {
+	pattern, ok := numberFormatFactories[methodName]
+	if !ok {
+		return Value{}, false
+	}
+	df, _ := newDecimalFormat(pattern)
+	if methodName == "getIntegerInstance" {
+		df.parseIntegerOnly = true
+	}
+	obj := &JObject{ClassName: "java/text/DecimalFormat", Fields: map[string]Value{"_format": RefValue(df)}}
+	return RefValue(obj), true
+}
+
+// handleDecimalFormat handles DecimalFormat/NumberFormat instance methods natively.
+func (vm *VM) handleDecimalFormat(objectRef Value, methodName, descriptor string, args []Value) (Value, error) {
+	obj := objectRef.Ref.(*JObject)
+	df, _ := obj.Fields["_format"].Ref.(*decimalFormat)
+	if df == nil {
+		df, _ = newDecimalFormat("#,##0.###")
+		obj.Fields["_format"] = RefValue(df)
+	}
+
+	switch methodName + ":" + descriptor {
+	case "<init>:()V":
+		return Value{}, nil
+	case "<init>:(Ljava/lang/String;)V", "applyPattern:(Ljava/lang/String;)V":
+		pattern, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		if err := df.applyPattern(pattern); err != nil {
+			return Value{}, NewJavaException("java/lang/IllegalArgumentException")
+		}
+		return Value{}, nil
+	case "toPattern:()Ljava/lang/String;":
+		return RefValue(df.toPattern()), nil
+	case "format:(D)Ljava/lang/String;":
+		return RefValue(df.formatDouble(args[0].Double)), nil
+	case "format:(J)Ljava/lang/String;":
+		return RefValue(df.formatLong(args[0].Long)), nil
+	case "format:(Ljava/lang/Object;)Ljava/lang/String;":
+		num, ok := args[0].Ref.(*JObject)
+		if !ok {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Cannot format given Object as a Number")
+		}
+		val := num.Fields["value"]
+		switch num.ClassName {
+		case "java/lang/Integer", "java/lang/Short", "java/lang/Byte":
+			return RefValue(df.formatLong(int64(val.Int))), nil
+		case "java/lang/Long":
+			return RefValue(df.formatLong(val.Long)), nil
+		case "java/lang/Float":
+			return RefValue(df.formatDouble(float64(val.Float))), nil
+		case "java/lang/Double":
+			return RefValue(df.formatDouble(val.Double)), nil
+		}
+		return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Cannot format given Object as a Number")
+	case "parse:(Ljava/lang/String;)Ljava/lang/Number;":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		v, ok := df.parse(s)
+		if !ok {
+			return Value{}, NewJavaException("java/text/ParseException")
+		}
+		return vm.boxValue(v), nil
+	case "setMaximumFractionDigits:(I)V":
+		df.maxFrac = max(int(args[0].Int), 0)
+		df.minFrac = min(df.minFrac, df.maxFrac)
+		return Value{}, nil
+	case "setMinimumFractionDigits:(I)V":
+		df.minFrac = max(int(args[0].Int), 0)
+		df.maxFrac = max(df.maxFrac, df.minFrac)
+		return Value{}, nil
+	case "setMinimumIntegerDigits:(I)V":
+		df.minInt = max(int(args[0].Int), 0)
+		return Value{}, nil
+	case "setMaximumIntegerDigits:(I)V":
+		return Value{}, nil
+	case "getMaximumFractionDigits:()I":
+		return IntValue(int32(df.maxFrac)), nil
+	case "getMinimumFractionDigits:()I":
+		return IntValue(int32(df.minFrac)), nil
+	case "getMinimumIntegerDigits:()I":
+		return IntValue(int32(df.minInt)), nil
+	case "setGroupingUsed:(Z)V":
+		df.groupingUsed = args[0].Int != 0
+		if df.groupingUsed && df.groupingSize == 0 {
+			df.groupingSize = 3
+		}
+		return Value{}, nil
+	case "isGroupingUsed:()Z":
+		if df.groupingUsed {
+			return IntValue(1), nil
+		}
+		return IntValue(0), nil
+	case "setGroupingSize:(I)V":
+		df.groupingSize = int(args[0].Int)
+		return Value{}, nil
+	case "setParseIntegerOnly:(Z)V":
+		df.parseIntegerOnly = args[0].Int != 0
+		return Value{}, nil
+	case "setRoundingMode:(Ljava/math/RoundingMode;)V":
+		mode, ok := args[0].Ref.(*JObject)
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		name, _ := extractGoString(mode.Fields["name"])
+		if name == "UNNECESSARY" {
+			return Value{}, fmt.Errorf("DecimalFormat: RoundingMode.UNNECESSARY is not supported")
+		}
+		df.roundingMode = name
+		return Value{}, nil
+	case "setPositivePrefix:(Ljava/lang/String;)V", "setNegativePrefix:(Ljava/lang/String;)V",
+		"setPositiveSuffix:(Ljava/lang/String;)V", "setNegativeSuffix:(Ljava/lang/String;)V":
+		s, _ := extractGoString(args[0])
+		switch methodName {
+		case "setPositivePrefix":
+			df.posPrefix = s
+		case "setNegativePrefix":
+			df.negPrefix = s
+		case "setPositiveSuffix":
+			df.posSuffix = s
+		case "setNegativeSuffix":
+			df.negSuffix = s
+		}
+		return Value{}, nil
+	case "setMultiplier:(I)V":
+		df.multiplier = int(args[0].Int)
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("DecimalFormat: unsupported method %s:%s", methodName, descriptor)
+}
@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"io"
+	"testing"
+)
+
+func TestDecimalFormat(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	newFormat := func(pattern string) Value {
+		t.Helper()
+		ref := RefValue(&JObject{ClassName: "java/text/DecimalFormat", Fields: make(map[string]Value)})
+		if _, err := v.handleDecimalFormat(ref, "<init>", "(Ljava/lang/String;)V", []Value{RefValue(pattern)}); err != nil {
+			t.Fatalf("new DecimalFormat(%q): %v", pattern, err)
+		}
+		return ref
+	}
+
+	tests := []struct {
+		pattern string
+		arg     Value
+		want    string
+	}{
+		{"#,##0.00", DoubleValue(1234567.891), "1,234,567.89"},
+		{"#,##0.00", DoubleValue(-0.001), "-0.00"},
+		{"0.00", DoubleValue(0.125), "0.12"}, // HALF_EVEN
+		{"0.00", DoubleValue(0.135), "0.14"}, // 0.135 is slightly above the tie
+		{"#.##", DoubleValue(2.5), "2.5"},
+		{"000", LongValue(7), "007"},
+		{"#,##0", LongValue(-1234567), "-1,234,567"},
+		{"0.0%", DoubleValue(0.256), "25.6%"},
+		{"'#'0;(0)", LongValue(-5), "(5)"},
+		{"$#,##0.00", DoubleValue(9.995), "$9.99"},
+	}
+	for _, tt := range tests {
+		desc := "(D)Ljava/lang/String;"
+		if tt.arg.Type == TypeLong {
+			desc = "(J)Ljava/lang/String;"
+		}
+		got, err := v.handleDecimalFormat(newFormat(tt.pattern), "format", desc, []Value{tt.arg})
+		if err != nil {
+			t.Errorf("%q: %v", tt.pattern, err)
+			continue
+		}
+		if got.Ref != tt.want {
+			t.Errorf("%q.format(%v): got %q, want %q", tt.pattern, tt.arg, got.Ref, tt.want)
+		}
+	}
+
+	t.Run("NumberFormat factory and setters", func(t *testing.T) {
+		nf, ok := newNumberFormat("getInstance")
+		if !ok {
+			t.Fatal("getInstance not handled")
+		}
+		v.handleDecimalFormat(nf, "setMinimumFractionDigits", "(I)V", []Value{IntValue(2)})
+		got, _ := v.handleDecimalFormat(nf, "format", "(D)Ljava/lang/String;", []Value{DoubleValue(1234.5)})
+		if got.Ref != "1,234.50" {
+			t.Errorf("got %q, want %q", got.Ref, "1,234.50")
+		}
+	})
+
+	t.Run("parse", func(t *testing.T) {
+		got, err := v.handleDecimalFormat(newFormat("#,##0.##"), "parse", "(Ljava/lang/String;)Ljava/lang/Number;", []Value{RefValue("-1,234.5")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		obj := got.Ref.(*JObject)
+		if obj.ClassName != "java/lang/Double" || obj.Fields["value"].Double != -1234.5 {
+			t.Errorf("got %s %v", obj.ClassName, obj.Fields["value"])
+		}
+	})
+}
@@ -0,0 +1,485 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The parsing and formatting methods of Integer, Long, Float and Double
+// run natively: the JDK versions reach CharacterData, FloatingDecimal, the
+// string coders and the box caches, none of which a program needs to parse
+// "42". Their toString output is the one println and string concatenation
+// use.
+const (
+	integerClass = "java/lang/Integer"
+	longClass    = "java/lang/Long"
+	floatClass   = "java/lang/Float"
+	doubleClass  = "java/lang/Double"
+)
+
+// numberConstants are the static constants of the number classes, which
+// getstatic reads without initializing the class. javac inlines them, but
+// other compilers and generated bytecode may read them as fields.
+var numberConstants = map[string]map[string]Value{
+	integerClass: {
+		"MIN_VALUE": IntValue(math.MinInt32),
+		"MAX_VALUE": IntValue(math.MaxInt32),
+		"SIZE":      IntValue(32),
+		"BYTES":     IntValue(4),
+	},
+	longClass: {
+		"MIN_VALUE": LongValue(math.MinInt64),
+		"MAX_VALUE": LongValue(math.MaxInt64),
+		"SIZE":      IntValue(64),
+		"BYTES":     IntValue(8),
+	},
+	floatClass: {
+		"MIN_VALUE":         FloatValue(math.SmallestNonzeroFloat32),
+		"MAX_VALUE":         FloatValue(math.MaxFloat32),
+		"MIN_NORMAL":        FloatValue(0x1p-126),
+		"POSITIVE_INFINITY": FloatValue(float32(math.Inf(1))),
+		"NEGATIVE_INFINITY": FloatValue(float32(math.Inf(-1))),
+		"NaN":               FloatValue(float32(math.NaN())),
+		"MAX_EXPONENT":      IntValue(127),
+		"MIN_EXPONENT":      IntValue(-126),
+		"SIZE":              IntValue(32),
+		"BYTES":             IntValue(4),
+	},
+	doubleClass: {
+		"MIN_VALUE":         DoubleValue(math.SmallestNonzeroFloat64),
+		"MAX_VALUE":         DoubleValue(math.MaxFloat64),
+		"MIN_NORMAL":        DoubleValue(0x1p-1022),
+		"POSITIVE_INFINITY": DoubleValue(math.Inf(1)),
+		"NEGATIVE_INFINITY": DoubleValue(math.Inf(-1)),
+		"NaN":               DoubleValue(math.NaN()),
+		"MAX_EXPONENT":      IntValue(1023),
+		"MIN_EXPONENT":      IntValue(-1022),
+		"SIZE":              IntValue(64),
+		"BYTES":             IntValue(8),
+	},
+}
+
+// numberFormatException returns the NumberFormatException that
+// Integer.parseInt and Long.parseLong throw for s, which is malformed in
+// radix.
+func numberFormatException(s string, radix int32) *JavaException {
+	msg := `For input string: "` + s + `"`
+	if radix != 10 {
+		msg += fmt.Sprintf(" under radix %d", radix)
+	}
+	return NewJavaExceptionMessage("java/lang/NumberFormatException", msg)
+}
+
+// parseInteger parses str as a signed integer of bits bits in radix the
+// way Integer.parseInt and Long.parseLong do: an optional sign followed by
+// digits of any script that Character.digit accepts.
+func parseInteger(str Value, radix int32, bits int) (int64, error) {
+	s, ok := extractGoString(str)
+	if !ok {
+		return 0, NewJavaExceptionMessage("java/lang/NumberFormatException", "Cannot parse null string: null")
+	}
+	if radix < 2 {
+		return 0, NewJavaExceptionMessage("java/lang/NumberFormatException", fmt.Sprintf("radix %d less than Character.MIN_RADIX", radix))
+	}
+	if radix > 36 {
+		return 0, NewJavaExceptionMessage("java/lang/NumberFormatException", fmt.Sprintf("radix %d greater than Character.MAX_RADIX", radix))
+	}
+	chars := stringChars(s)
+	negative := false
+	if len(chars) > 0 && (chars[0] == '-' || chars[0] == '+') {
+		negative = chars[0] == '-'
+		chars = chars[1:]
+	}
+	if len(chars) == 0 {
+		return 0, numberFormatException(s, radix)
+	}
+	// Accumulate negatively, as the JDK does, so that the most negative
+	// value does not overflow.
+	limit := int64(-1) << (bits - 1)
+	if !negative {
+		limit++
+	}
+	var n int64
+	for _, c := range chars {
+		d := digitValue(rune(c))
+		if d < 0 || d >= radix || n < limit/int64(radix) {
+			return 0, numberFormatException(s, radix)
+		}
+		n *= int64(radix)
+		if n < limit+int64(d) {
+			return 0, numberFormatException(s, radix)
+		}
+		n -= int64(d)
+	}
+	if negative {
+		return n, nil
+	}
+	return -n, nil
+}
+
+// handleIntegerStatic implements Integer's parsing and formatting methods.
+// It reports false for the others, which run as bytecode.
+func (vm *VM) handleIntegerStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + descriptor {
+	case "parseInt(Ljava/lang/String;)I", "parseInt(Ljava/lang/String;I)I",
+		"valueOf(Ljava/lang/String;)Ljava/lang/Integer;", "valueOf(Ljava/lang/String;I)Ljava/lang/Integer;":
+		radix := int32(10)
+		if len(args) > 1 {
+			radix = args[1].Int
+		}
+		n, err := parseInteger(args[0], radix, 32)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if methodName == "valueOf" {
+			return vm.boxValue(IntValue(int32(n))), true, nil
+		}
+		return IntValue(int32(n)), true, nil
+	case "toString(I)Ljava/lang/String;":
+		return RefValue(strconv.Itoa(int(args[0].Int))), true, nil
+	case "toString(II)Ljava/lang/String;":
+		radix := int(args[1].Int)
+		if radix < 2 || radix > 36 {
+			radix = 10
+		}
+		return RefValue(strconv.FormatInt(int64(args[0].Int), radix)), true, nil
+	case "toHexString(I)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(uint32(args[0].Int)), 16)), true, nil
+	case "toOctalString(I)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(uint32(args[0].Int)), 8)), true, nil
+	case "toBinaryString(I)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(uint32(args[0].Int)), 2)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// handleLongStatic implements Long's parsing and formatting methods. It
+// reports false for the others, which run as bytecode.
+func (vm *VM) handleLongStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + descriptor {
+	case "parseLong(Ljava/lang/String;)J", "parseLong(Ljava/lang/String;I)J",
+		"valueOf(Ljava/lang/String;)Ljava/lang/Long;", "valueOf(Ljava/lang/String;I)Ljava/lang/Long;":
+		radix := int32(10)
+		if len(args) > 1 {
+			radix = args[1].Int
+		}
+		n, err := parseInteger(args[0], radix, 64)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if methodName == "valueOf" {
+			return vm.boxValue(LongValue(n)), true, nil
+		}
+		return LongValue(n), true, nil
+	case "toString(J)Ljava/lang/String;":
+		return RefValue(strconv.FormatInt(args[0].Long, 10)), true, nil
+	case "toString(JI)Ljava/lang/String;":
+		radix := int(args[1].Int)
+		if radix < 2 || radix > 36 {
+			radix = 10
+		}
+		return RefValue(strconv.FormatInt(args[0].Long, radix)), true, nil
+	case "toHexString(J)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(args[0].Long), 16)), true, nil
+	case "toOctalString(J)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(args[0].Long), 8)), true, nil
+	case "toBinaryString(J)Ljava/lang/String;":
+		return RefValue(strconv.FormatUint(uint64(args[0].Long), 2)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// floatingLiteral matches what Double.valueOf accepts after trimming:
+// NaN, Infinity, and decimal and hexadecimal floating-point literals with
+// an optional sign and type suffix.
+var floatingLiteral = regexp.MustCompile(`^[+-]?(NaN|Infinity|((\d+\.?\d*|\.\d+)([eE][+-]?\d+)?|0[xX]([0-9a-fA-F]+\.?[0-9a-fA-F]*|\.[0-9a-fA-F]+)[pP][+-]?\d+)[fFdD]?)$`)
+
+// parseFloating parses str as Double.parseDouble (bitSize 64) or
+// Float.parseFloat (bitSize 32) does. Both round the decimal value
+// directly to their own precision.
+func parseFloating(str Value, bitSize int) (float64, error) {
+	s, ok := extractGoString(str)
+	if !ok {
+		return 0, NewJavaException("java/lang/NullPointerException")
+	}
+	s = strings.TrimFunc(s, func(r rune) bool { return r <= ' ' })
+	if s == "" {
+		return 0, NewJavaExceptionMessage("java/lang/NumberFormatException", "empty String")
+	}
+	if !floatingLiteral.MatchString(s) {
+		return 0, NewJavaExceptionMessage("java/lang/NumberFormatException", `For input string: "`+s+`"`)
+	}
+	switch {
+	case strings.HasSuffix(s, "NaN"):
+		return math.NaN(), nil
+	case !strings.HasSuffix(s, "Infinity"):
+		s = strings.TrimRight(s, "fFdD")
+	}
+	// Out of range literals are infinities or zeros, which ParseFloat
+	// returns along with its range error.
+	f, _ := strconv.ParseFloat(s, bitSize)
+	return f, nil
+}
+
+// handleDoubleStatic implements Double's parsing and formatting methods.
+// It reports false for the others, which run as bytecode.
+func (vm *VM) handleDoubleStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + descriptor {
+	case "parseDouble(Ljava/lang/String;)D", "valueOf(Ljava/lang/String;)Ljava/lang/Double;":
+		d, err := parseFloating(args[0], 64)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if methodName == "valueOf" {
+			return vm.boxValue(DoubleValue(d)), true, nil
+		}
+		return DoubleValue(d), true, nil
+	case "valueOf(D)Ljava/lang/Double;":
+		return vm.boxValue(args[0]), true, nil
+	case "toString(D)Ljava/lang/String;":
+		return RefValue(formatDouble(args[0].Double)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// handleFloatStatic implements Float's parsing and formatting methods. It
+// reports false for the others, which run as bytecode.
+func (vm *VM) handleFloatStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + descriptor {
+	case "parseFloat(Ljava/lang/String;)F", "valueOf(Ljava/lang/String;)Ljava/lang/Float;":
+		f, err := parseFloating(args[0], 32)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if methodName == "valueOf" {
+			return vm.boxValue(FloatValue(float32(f))), true, nil
+		}
+		return FloatValue(float32(f)), true, nil
+	case "valueOf(F)Ljava/lang/Float;":
+		return vm.boxValue(args[0]), true, nil
+	case "toString(F)Ljava/lang/String;":
+		return RefValue(formatFloat(args[0].Float)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// insignificantDigits is FloatingDecimal.insignificantDigitsNumber: how many
+// low decimal digits of an integer 2^p2 ulps wide are noise.
+var insignificantDigits = [...]int{
+	0, 0, 0, 0, 1, 1, 1, 2, 2, 2, 3, 3, 3, 3,
+	4, 4, 4, 5, 5, 5, 6, 6, 6, 6, 7, 7, 7,
+	8, 8, 8, 9, 9, 9, 9, 10, 10, 10, 11, 11, 11,
+	12, 12, 12, 12, 13, 13, 13, 14, 14, 14,
+	15, 15, 15, 15, 16, 16, 16, 17, 17, 17,
+	18, 18, 18, 19,
+}
+
+// floatingDigits returns the decimal digits of v, a finite positive
+// float64 or widened float32 per bitSize, and the exponent placing the
+// point before them, as JDK 17's FloatingDecimal.dtoa picks them. They
+// are not always the shortest that round back to v (2^-44 gets a 17th
+// digit), and may end in zeros left by rounding up. JDK 19 changed the
+// algorithm, but the VM reports java.version 17. compatible selects the
+// digits of Double.toString and Float.toString; Formatter asks for at
+// least two digits.
+func floatingDigits(v float64, bitSize int, compatible bool) (digits []byte, decExp int) {
+	const expShift = 52
+	var fractBits uint64
+	var binExp, nSignificantBits int
+	if bitSize == 32 {
+		raw := math.Float32bits(float32(v))
+		fract := raw & (1<<23 - 1)
+		if binExp = int(raw>>23) & 0xFF; binExp == 0 { // subnormal
+			leadingZeros := bits.LeadingZeros32(fract)
+			shift := leadingZeros - (31 - 23)
+			fract <<= shift
+			binExp, nSignificantBits = 1-shift, 32-leadingZeros
+		} else {
+			fract |= 1 << 23
+			nSignificantBits = 24
+		}
+		fractBits, binExp = uint64(fract)<<(expShift-23), binExp-127
+	} else {
+		raw := math.Float64bits(v)
+		fractBits = raw & (1<<expShift - 1)
+		if binExp = int(raw>>expShift) & 0x7FF; binExp == 0 { // subnormal
+			leadingZeros := bits.LeadingZeros64(fractBits)
+			shift := leadingZeros - (63 - expShift)
+			fractBits <<= shift
+			binExp, nSignificantBits = 1-shift, 64-leadingZeros
+		} else {
+			fractBits |= 1 << expShift
+			nSignificantBits = expShift + 1
+		}
+		binExp -= 1023
+	}
+
+	tailZeros := bits.TrailingZeros64(fractBits)
+	nFractBits := expShift + 1 - tailZeros
+	nTinyBits := max(0, nFractBits-binExp-1)
+	if nTinyBits == 0 && binExp <= 62 {
+		// An integer that fits a long: its digits, rounding away those
+		// below the precision of v.
+		insignificant := 0
+		if p2 := binExp - nSignificantBits - 1; p2 > 1 && p2 < len(insignificantDigits) {
+			insignificant = insignificantDigits[p2]
+		}
+		value := fractBits
+		if binExp >= expShift {
+			value <<= binExp - expShift
+		} else {
+			value >>= expShift - binExp
+		}
+		if insignificant > 0 {
+			pow10 := pow5(insignificant) << insignificant
+			residue := value % pow10
+			if value /= pow10; residue >= pow10/2 {
+				value++
+			}
+		}
+		s := strconv.FormatUint(value, 10)
+		return []byte(strings.TrimRight(s, "0")), len(s) + insignificant
+	}
+
+	// v = B/S * 10^decExp with 1 <= B/S < 10, and M is half an ulp of v
+	// scaled like B. Each is kept as powers of 2 and 5.
+	decExp = estimateDecExp(fractBits, binExp)
+	b5 := max(0, -decExp)
+	b2 := b5 + nTinyBits + binExp
+	s5 := max(0, decExp)
+	s2 := s5 + nTinyBits
+	m5, m2 := b5, b2-nSignificantBits
+	fractBits >>= tailZeros
+	b2 -= nFractBits - 1
+	common := min(b2, s2)
+	b2, s2, m2 = b2-common, s2-common, m2-common
+	if nFractBits == 1 { // the ulp below a power of two is half as wide
+		m2--
+	}
+	if m2 < 0 {
+		b2, s2, m2 = b2-m2, s2-m2, 0
+	}
+
+	// Like the JDK, use int or long arithmetic, with their overflow, when
+	// B and 10*S fit in them.
+	pow5Bits := func(p5 int) int {
+		if p5 < 27 {
+			return bits.Len64(pow5(p5))
+		}
+		return p5 * 3
+	}
+	bBits, tenSBits := nFractBits+b2+pow5Bits(b5), s2+1+pow5Bits(s5+1)
+	var roundUp bool
+	switch {
+	case bBits < 32 && tenSBits < 32:
+		digits, decExp, roundUp = fixedDigits(int32(fractBits)*int32(pow5(b5))<<b2, int32(pow5(s5))<<s2, int32(pow5(m5))<<m2, decExp, compatible)
+	case bBits < 64 && tenSBits < 64:
+		digits, decExp, roundUp = fixedDigits(int64(fractBits)*int64(pow5(b5))<<b2, int64(pow5(s5))<<s2, int64(pow5(m5))<<m2, decExp, compatible)
+	default:
+		digits, decExp, roundUp = bigDigits(fractBits, b5, b2, s5, s2, m5, m2, decExp, compatible)
+	}
+	if roundUp {
+		i := len(digits) - 1
+		for ; i > 0 && digits[i] == '9'; i-- {
+			digits[i] = '0'
+		}
+		if digits[i] == '9' { // carried out of the first digit
+			digits[0] = '1'
+			decExp++
+		} else {
+			digits[i]++
+		}
+	}
+	return digits, decExp + 1
+}
+
+// pow5 returns 5^n for n < 28.
+func pow5(n int) uint64 {
+	p := uint64(1)
+	for ; n > 0; n-- {
+		p *= 5
+	}
+	return p
+}
+
+// estimateDecExp returns floor(log10(v)), or one more, for the normalized
+// fraction bits and binary exponent of v.
+func estimateDecExp(fractBits uint64, binExp int) int {
+	d2 := math.Float64frombits(0x3FF0000000000000 | fractBits&(1<<52-1))
+	return int(math.Floor((d2-1.5)*0.289529654 + 0.176091259 + float64(binExp)*0.301029995663981))
+}
+
+// fixedDigits develops the digits of b/s until the remainder is within m
+// of either neighbouring digit, as FloatingDecimal.dtoa does in int or
+// long arithmetic. It returns the digits, the corrected decimal exponent
+// and whether the last digit rounds up.
+func fixedDigits[T int32 | int64](b, s, m T, decExp int, compatible bool) ([]byte, int, bool) {
+	var digits []byte
+	tens := s * 10
+	q := b / s
+	b, m = 10*(b%s), m*10
+	low, high := b < m, b+m > tens
+	if q == 0 && !high { // the estimate was one too high
+		decExp--
+	} else {
+		digits = append(digits, byte('0'+q))
+	}
+	if !compatible || decExp < -3 || decExp >= 8 { // two digits or more, as scientific notation shows
+		low, high = false, false
+	}
+	for !low && !high {
+		q = b / s
+		b, m = 10*(b%s), m*10
+		if m > 0 {
+			low, high = b < m, b+m > tens
+		} else { // m overflowed, so it is surely far enough
+			low, high = true, true
+		}
+		digits = append(digits, byte('0'+q))
+	}
+	diff := b<<1 - tens
+	return digits, decExp, high && (!low || diff > 0 || diff == 0 && digits[len(digits)-1]&1 != 0)
+}
+
+// bigDigits is fixedDigits for B = fractBits*5^b5*2^b2, S = 5^s5*2^s2 and
+// M = 5^m5*2^m2 that need arbitrary precision.
+func bigDigits(fractBits uint64, b5, b2, s5, s2, m5, m2, decExp int, compatible bool) ([]byte, int, bool) {
+	pow52 := func(p5, p2 int) *big.Int {
+		n := new(big.Int).Exp(big.NewInt(5), big.NewInt(int64(p5)), nil)
+		return n.Lsh(n, uint(p2))
+	}
+	b := pow52(b5, b2)
+	b.Mul(b, new(big.Int).SetUint64(fractBits))
+	s, m, tens := pow52(s5, s2), pow52(m5+1, m2+1), pow52(s5+1, s2+1)
+	ten, q, sum := big.NewInt(10), new(big.Int), new(big.Int)
+	next := func() (digit byte, low, high bool) {
+		q.QuoRem(b, s, b)
+		b.Mul(b, ten)
+		return byte('0' + q.Int64()), b.Cmp(m) < 0, tens.Cmp(sum.Add(b, m)) <= 0
+	}
+	var digits []byte
+	digit, low, high := next()
+	if digit == '0' && !high { // the estimate was one too high
+		decExp--
+	} else {
+		digits = append(digits, digit)
+	}
+	if !compatible || decExp < -3 || decExp >= 8 { // two digits or more, as scientific notation shows
+		low, high = false, false
+	}
+	for !low && !high {
+		m.Mul(m, ten)
+		digit, low, high = next()
+		digits = append(digits, digit)
+	}
+	diff := 0
+	if high && low {
+		diff = b.Lsh(b, 1).Cmp(tens)
+	}
+	return digits, decExp, high && (!low || diff > 0 || diff == 0 && digits[len(digits)-1]&1 != 0)
+}
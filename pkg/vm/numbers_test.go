@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+func TestIntegerStatic(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	call := func(method, descriptor string, args ...Value) (Value, error) {
+		t.Helper()
+		ret, handled, err := v.handleIntegerStatic(method, descriptor, args)
+		if !handled {
+			t.Fatalf("%s%s is not handled", method, descriptor)
+		}
+		return ret, err
+	}
+	for _, c := range []struct {
+		s     string
+		radix int32
+		want  int32
+	}{
+		{"42", 10, 42},
+		{"+7", 10, 7},
+		{"-2147483648", 10, -1 << 31},
+		{"2147483647", 10, 1<<31 - 1},
+		{"ff", 16, 255},
+		{"-Zz", 36, -1295},
+		{"١٢", 10, 12},
+	} {
+		ret, err := call("parseInt", "(Ljava/lang/String;I)I", RefValue(c.s), IntValue(c.radix))
+		if err != nil || ret.Int != c.want {
+			t.Errorf("parseInt(%q, %d): got %d, %v", c.s, c.radix, ret.Int, err)
+		}
+	}
+	for _, c := range []struct {
+		s     Value
+		radix int32
+		msg   string
+	}{
+		{RefValue(""), 10, `For input string: ""`},
+		{RefValue("-"), 10, `For input string: "-"`},
+		{RefValue("2147483648"), 10, `For input string: "2147483648"`},
+		{RefValue("12a"), 8, `For input string: "12a" under radix 8`},
+		{RefValue(" 1"), 10, `For input string: " 1"`},
+		{NullValue(), 10, "Cannot parse null string: null"},
+		{RefValue("1"), 37, "radix 37 greater than Character.MAX_RADIX"},
+	} {
+		_, err := call("parseInt", "(Ljava/lang/String;I)I", c.s, IntValue(c.radix))
+		exc, ok := err.(*JavaException)
+		if msg, _ := exc.Message(); !ok || exc.Object.ClassName != "java/lang/NumberFormatException" || msg != c.msg {
+			t.Errorf("parseInt(%v, %d): got %v, want %q", c.s.Ref, c.radix, err, c.msg)
+		}
+	}
+
+	boxed, _ := call("valueOf", "(Ljava/lang/String;)Ljava/lang/Integer;", RefValue("-5"))
+	if obj := boxed.Ref.(*JObject); obj.ClassName != integerClass || obj.Fields["value"].Int != -5 {
+		t.Errorf("valueOf(\"-5\"): got %+v", obj)
+	}
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+		want               string
+	}{
+		{"toString", "(I)Ljava/lang/String;", []Value{IntValue(-12)}, "-12"},
+		{"toString", "(II)Ljava/lang/String;", []Value{IntValue(-255), IntValue(16)}, "-ff"},
+		{"toString", "(II)Ljava/lang/String;", []Value{IntValue(9), IntValue(99)}, "9"},
+		{"toHexString", "(I)Ljava/lang/String;", []Value{IntValue(-1)}, "ffffffff"},
+		{"toOctalString", "(I)Ljava/lang/String;", []Value{IntValue(8)}, "10"},
+		{"toBinaryString", "(I)Ljava/lang/String;", []Value{IntValue(-1 << 31)}, "10000000000000000000000000000000"},
+	} {
+		ret, err := call(c.method, c.descriptor, c.args...)
+		if got, _ := extractGoString(ret); err != nil || got != c.want {
+			t.Errorf("%s%v: got %q, %v, want %q", c.method, c.args, got, err, c.want)
+		}
+	}
+}
+
+func TestLongStatic(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	ret, handled, err := v.handleLongStatic("parseLong", "(Ljava/lang/String;)J", []Value{RefValue("-9223372036854775808")})
+	if !handled || err != nil || ret.Long != math.MinInt64 {
+		t.Errorf("parseLong(MIN_VALUE): got %d, %v", ret.Long, err)
+	}
+	if _, _, err := v.handleLongStatic("parseLong", "(Ljava/lang/String;)J", []Value{RefValue("9223372036854775808")}); !isJavaException(err, "java/lang/NumberFormatException") {
+		t.Errorf("parseLong overflow: got %v", err)
+	}
+	ret, _, _ = v.handleLongStatic("toHexString", "(J)Ljava/lang/String;", []Value{LongValue(-1)})
+	if s, _ := extractGoString(ret); s != "ffffffffffffffff" {
+		t.Errorf("toHexString(-1L): got %q", s)
+	}
+}
+
+func TestParseFloating(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	for _, c := range []struct {
+		s    string
+		want float64
+	}{
+		{"1.5", 1.5},
+		{"  -2.5e3d\n", -2500},
+		{".5f", 0.5},
+		{"5.", 5},
+		{"0x1.8p1", 3},
+		{"+Infinity", math.Inf(1)},
+		{"1e400", math.Inf(1)},
+		{"4.9e-324", math.SmallestNonzeroFloat64},
+	} {
+		if got, err := parseFloating(RefValue(c.s), 64); err != nil || got != c.want {
+			t.Errorf("parseDouble(%q): got %v, %v", c.s, got, err)
+		}
+	}
+	if got, _ := parseFloating(RefValue("-NaN"), 64); !math.IsNaN(got) {
+		t.Errorf("parseDouble(-NaN): got %v", got)
+	}
+	// Float.parseFloat rounds the decimal once, to float precision.
+	if got, _ := parseFloating(RefValue("1.00000017881393432617187499"), 32); float32(got) != 1.0000001 {
+		t.Errorf("parseFloat: got %v", float32(got))
+	}
+	for _, s := range []string{"", "1_000", "inf", "nan", "0x10", "1e", "١"} {
+		if _, err := parseFloating(RefValue(s), 64); !isJavaException(err, "java/lang/NumberFormatException") {
+			t.Errorf("parseDouble(%q): got %v", s, err)
+		}
+	}
+	if _, err := parseFloating(NullValue(), 64); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("parseDouble(null): got %v", err)
+	}
+
+	ret, _, _ := v.handleDoubleStatic("toString", "(D)Ljava/lang/String;", []Value{DoubleValue(math.SmallestNonzeroFloat64)})
+	if s, _ := extractGoString(ret); s != "4.9E-324" {
+		t.Errorf("Double.toString(MIN_VALUE): got %q", s)
+	}
+}
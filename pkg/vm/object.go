@@ -18,7 +18,174 @@ type JObject struct {
 	LambdaTarget *LambdaTarget
 }
 
-// JArray represents a JVM reference array.
+// JArray represents a JVM array. Primitive arrays are backed by a Go slice
+// of their element type, so stores narrow values as bastore, castore and
+// sastore do; reference arrays, and arrays whose component type was not
+// recorded, hold Values in Elements. Len, Get and Set work on either.
 type JArray struct {
-	Elements []Value
+	Component string  // component type descriptor, such as "I" or "Ljava/lang/String;"; "" if not recorded
+	Elements  []Value // reference arrays
+	Bytes     []int8  // byte[] and boolean[]
+	Chars     []uint16
+	Shorts    []int16
+	Ints      []int32
+	Longs     []int64
+	Floats    []float32
+	Doubles   []float64
+}
+
+// runtimeClassName returns the name of the class of the non-null
+// reference v as Class.getName returns it, with "/" separators: such as
+// "java/lang/String" or "[I". It returns "" for values the VM keeps as Go
+// values of no particular class.
+func runtimeClassName(v Value) string {
+	switch ref := v.Ref.(type) {
+	case string:
+		return "java/lang/String"
+	case *JObject:
+		return ref.ClassName
+	case *JArray:
+		if ref.Component == "" {
+			return "[Ljava/lang/Object;"
+		}
+		return "[" + ref.Component
+	}
+	return ""
+}
+
+// NewArray creates an array of length default elements of the component
+// type given by a field descriptor.
+func NewArray(component string, length int) *JArray {
+	arr := &JArray{Component: component}
+	switch component {
+	case "Z", "B":
+		arr.Bytes = make([]int8, length)
+	case "C":
+		arr.Chars = make([]uint16, length)
+	case "S":
+		arr.Shorts = make([]int16, length)
+	case "I":
+		arr.Ints = make([]int32, length)
+	case "J":
+		arr.Longs = make([]int64, length)
+	case "F":
+		arr.Floats = make([]float32, length)
+	case "D":
+		arr.Doubles = make([]float64, length)
+	default:
+		arr.Elements = make([]Value, length)
+		for i := range arr.Elements {
+			arr.Elements[i] = NullValue()
+		}
+	}
+	return arr
+}
+
+// Len returns the length of the array.
+func (a *JArray) Len() int {
+	switch a.Component {
+	case "Z", "B":
+		return len(a.Bytes)
+	case "C":
+		return len(a.Chars)
+	case "S":
+		return len(a.Shorts)
+	case "I":
+		return len(a.Ints)
+	case "J":
+		return len(a.Longs)
+	case "F":
+		return len(a.Floats)
+	case "D":
+		return len(a.Doubles)
+	}
+	return len(a.Elements)
+}
+
+// Get returns element i, which must be in range.
+func (a *JArray) Get(i int) Value {
+	switch a.Component {
+	case "Z", "B":
+		return IntValue(int32(a.Bytes[i]))
+	case "C":
+		return IntValue(int32(a.Chars[i]))
+	case "S":
+		return IntValue(int32(a.Shorts[i]))
+	case "I":
+		return IntValue(a.Ints[i])
+	case "J":
+		return LongValue(a.Longs[i])
+	case "F":
+		return FloatValue(a.Floats[i])
+	case "D":
+		return DoubleValue(a.Doubles[i])
+	}
+	return a.Elements[i]
+}
+
+// Set stores v as element i, which must be in range, narrowing ints to the
+// component type: booleans keep the low bit and bytes, chars and shorts
+// are truncated.
+func (a *JArray) Set(i int, v Value) {
+	switch a.Component {
+	case "Z":
+		a.Bytes[i] = int8(v.Int & 1)
+	case "B":
+		a.Bytes[i] = int8(v.Int)
+	case "C":
+		a.Chars[i] = uint16(v.Int)
+	case "S":
+		a.Shorts[i] = int16(v.Int)
+	case "I":
+		a.Ints[i] = v.Int
+	case "J":
+		a.Longs[i] = v.Long
+	case "F":
+		a.Floats[i] = v.Float
+	case "D":
+		a.Doubles[i] = v.Double
+	default:
+		a.Elements[i] = v
+	}
+}
+
+// copyElements copies length elements of src starting at srcPos to dest
+// starting at destPos. Ranges must be valid. Arrays with the same backing
+// copy as if through a temporary array, so overlapping ranges of one array
+// are safe.
+func copyElements(dest *JArray, destPos int, src *JArray, srcPos, length int) {
+	if len(dest.Component) == 1 && dest.Component == src.Component {
+		switch dest.Component {
+		case "Z", "B":
+			copy(dest.Bytes[destPos:destPos+length], src.Bytes[srcPos:])
+		case "C":
+			copy(dest.Chars[destPos:destPos+length], src.Chars[srcPos:])
+		case "S":
+			copy(dest.Shorts[destPos:destPos+length], src.Shorts[srcPos:])
+		case "I":
+			copy(dest.Ints[destPos:destPos+length], src.Ints[srcPos:])
+		case "J":
+			copy(dest.Longs[destPos:destPos+length], src.Longs[srcPos:])
+		case "F":
+			copy(dest.Floats[destPos:destPos+length], src.Floats[srcPos:])
+		case "D":
+			copy(dest.Doubles[destPos:destPos+length], src.Doubles[srcPos:])
+		}
+		return
+	}
+	if len(dest.Component) != 1 && len(src.Component) != 1 {
+		copy(dest.Elements[destPos:destPos+length], src.Elements[srcPos:srcPos+length])
+		return
+	}
+	// A typed array and one of unrecorded type: distinct arrays.
+	for i := 0; i < length; i++ {
+		dest.Set(destPos+i, src.Get(srcPos+i))
+	}
+}
+
+// Clone returns a shallow copy of the array, as Object.clone does.
+func (a *JArray) Clone() *JArray {
+	c := NewArray(a.Component, a.Len())
+	copyElements(c, 0, a, 0, a.Len())
+	return c
 }
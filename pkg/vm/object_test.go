@@ -117,3 +117,46 @@ func TestJObjectFields(t *testing.T) {
 		}
 	})
 }
+
+func TestJArrayTyped(t *testing.T) {
+	t.Run("default elements", func(t *testing.T) {
+		for _, tt := range []struct {
+			component string
+			want      Value
+		}{
+			{"Z", IntValue(0)}, {"B", IntValue(0)}, {"C", IntValue(0)}, {"S", IntValue(0)}, {"I", IntValue(0)},
+			{"J", LongValue(0)}, {"F", FloatValue(0)}, {"D", DoubleValue(0)},
+			{"Ljava/lang/String;", NullValue()}, {"[I", NullValue()},
+		} {
+			arr := NewArray(tt.component, 2)
+			if arr.Len() != 2 || arr.Get(1) != tt.want {
+				t.Errorf("new %s array: len %d, element %+v", tt.component, arr.Len(), arr.Get(1))
+			}
+		}
+	})
+
+	t.Run("stores narrow to the component type", func(t *testing.T) {
+		for _, tt := range []struct {
+			component string
+			in, want  int32
+		}{
+			{"Z", 3, 1}, {"B", 0x1FF, -1}, {"C", -1, 0xFFFF}, {"S", 0x18000, -0x8000}, {"I", -5, -5},
+		} {
+			arr := NewArray(tt.component, 1)
+			arr.Set(0, IntValue(tt.in))
+			if got := arr.Get(0).Int; got != tt.want {
+				t.Errorf("%s store of %#x: got %#x, want %#x", tt.component, tt.in, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("clone copies the elements", func(t *testing.T) {
+		arr := NewArray("D", 2)
+		arr.Set(1, DoubleValue(2.5))
+		c := arr.Clone()
+		c.Set(0, DoubleValue(1))
+		if c.Component != "D" || c.Get(1).Double != 2.5 || arr.Get(0).Double != 0 {
+			t.Errorf("clone: got %+v from %+v", c.Doubles, arr.Doubles)
+		}
+	})
+}
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+)
+
+// A Go panic in an instruction or a native method, such as a Frame
+// accessor's on bad bytecode or a bug in the VM, does not crash the
+// process: the interpreter recovers it and throws an InternalError from
+// the innermost activation, writing the Go stack to VM.Diagnostics.
+// Setting VM.NoRecover lets panics propagate, for debugging.
+
+// stackTypeError is the value checkOperandTypes panics with. It is a
+// debugging aid asked for with VM.CheckStackTypes, so it is never
+// recovered.
+type stackTypeError string
+
+// recovered returns the error to throw from the innermost activation for
+// the panic value r, panicking again if it should not be recovered.
+func (vm *VM) recovered(r interface{}) error {
+	var act *stackEntry
+	if len(vm.callStack) > 0 {
+		act = &vm.callStack[len(vm.callStack)-1]
+	}
+	if _, ok := r.(operandStackOverflow); ok && act != nil {
+		return NewJavaExceptionMessage("java/lang/VerifyError", fmt.Sprintf(
+			"%s.%s%s: pc %d: Operand stack overflow", act.className, act.method.Name, act.method.Descriptor, act.pc))
+	}
+	if _, ok := r.(stackTypeError); ok || vm.NoRecover {
+		panic(r)
+	}
+	if act == nil {
+		return vm.internalError("", r)
+	}
+	return vm.internalError(fmt.Sprintf("%s (PC=%d)", sourceLocation(act.class, act.method, act.pc), act.pc), r)
+}
+
+// internalError reports the recovered panic r at location, which is ""
+// when unknown, and returns the InternalError that replaces it.
+func (vm *VM) internalError(location string, r interface{}) *JavaException {
+	w := vm.Diagnostics
+	if w == nil {
+		w = vm.Stderr
+	}
+	if w == nil {
+		w = os.Stderr
+	}
+	if location != "" {
+		location = " at " + location
+	}
+	fmt.Fprintf(w, "gojvm: recovered panic%s: %v\n%s", location, r, debug.Stack())
+	return NewJavaExceptionMessage("java/lang/InternalError", fmt.Sprint(r))
+}
+
+// callNative runs a native method called from Go rather than from an
+// invoke instruction, whose panics the interpreter loop recovers.
+func (vm *VM) callNative(className, methodName, descriptor string, args []Value) (retVal Value, err error) {
+	if !vm.NoRecover {
+		defer func() {
+			if r := recover(); r != nil {
+				retVal, err = Value{}, vm.internalError(className+"."+methodName+descriptor, r)
+			}
+		}()
+	}
+	return vm.executeNativeMethod(className, methodName, descriptor, args)
+}
@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestRecoverPanics(t *testing.T) {
+	b := classfile.NewBuilder("Bad", "java/lang/Object")
+	// underflow adds with a single operand on the stack.
+	b.AddMethod(classfile.AccStatic, "underflow", "()I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpIconst1, OpIadd, OpIreturn},
+	})
+	// caught catches the InternalError underflow throws.
+	underflow := b.Methodref("Bad", "underflow", "()I")
+	b.AddMethod(classfile.AccStatic, "caught", "()I", &classfile.CodeAttribute{
+		MaxStack:          1,
+		Code:              []byte{OpInvokestatic, byte(underflow >> 8), byte(underflow), OpIreturn, OpPop, OpIconst2, OpIreturn},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 4, HandlerPC: 4, CatchType: b.Class("java/lang/InternalError")}},
+	})
+	cf := b.Build()
+	ob := classfile.NewBuilder("java/lang/Object", "")
+	ob.AddMethod(AccNative, "hashCode", "()I", nil)
+	object := ob.Build()
+	v := NewVM(mapClassLoader{"Bad": cf, "java/lang/Object": object})
+	var diagnostics bytes.Buffer
+	v.Diagnostics = &diagnostics
+
+	_, err := v.executeMethod(cf, cf.FindMethodByName("underflow"), nil)
+	if !isJavaException(err, "java/lang/InternalError") {
+		t.Fatalf("got %v, want InternalError", err)
+	}
+	if got := diagnostics.String(); !strings.HasPrefix(got, "gojvm: recovered panic at Bad.underflow(Unknown Source) (PC=1): ") || !strings.Contains(got, "goroutine") {
+		t.Errorf("diagnostic: got %q", got)
+	}
+	if len(v.callStack) != 0 || v.frameDepth != 0 {
+		t.Errorf("call stack not unwound: %d entries, depth %d", len(v.callStack), v.frameDepth)
+	}
+
+	ret, err := v.executeMethod(cf, cf.FindMethodByName("caught"), nil)
+	if err != nil || ret != IntValue(2) {
+		t.Errorf("caught: got %v, %v, want 2", ret, err)
+	}
+
+	// A native called with too few arguments
+	_, err = v.executeMethod(object, object.FindMethodByName("hashCode"), nil)
+	if !isJavaException(err, "java/lang/InternalError") {
+		t.Errorf("native: got %v, want InternalError", err)
+	}
+	if !strings.Contains(diagnostics.String(), "recovered panic at java/lang/Object.hashCode()I: ") {
+		t.Errorf("native diagnostic: got %q", diagnostics.String())
+	}
+
+	v.NoRecover = true
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("NoRecover: no panic")
+		}
+	}()
+	v.executeMethod(cf, cf.FindMethodByName("underflow"), nil)
+}
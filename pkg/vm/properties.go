@@ -0,0 +1,329 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// System properties live in a single VM-wide store that is created with
+// defaults on first use. System.getProperty/setProperty and the JDK's
+// saved-property lookups all read and write the same store, so a value
+// set by the program is visible everywhere afterwards.
+//
+// The locale, time zone and encoding defaults are taken from the host
+// environment the way the JDK does (LANG and friends, TZ), which makes
+// output depend on the machine. Embedders that need reproducible runs set
+// user.language, user.country, user.timezone and file.encoding with
+// SetProperty before executing; the natives that depend on them read the
+// store on every call. VM.Properties, if set before the store is first
+// used, overrides the defaults as a whole, and SetProperty (-D) overrides
+// both.
+//
+// System.getenv reads VM.Env, or the host environment if that is nil.
+
+// defaultProperties returns the properties every VM starts with.
+func defaultProperties() map[string]string {
+	osName := runtime.GOOS
+	switch osName {
+	case "linux":
+		osName = "Linux"
+	case "darwin":
+		osName = "Mac OS X"
+	case "windows":
+		osName = "Windows"
+	}
+	props := map[string]string{
+		"java.version":               "17",
+		"java.specification.version": "17",
+		"java.vendor":                "gojvm",
+		"java.vm.name":               "gojvm",
+		"java.class.version":         "61.0",
+		"java.io.tmpdir":             os.TempDir(),
+		"os.name":                    osName,
+		"os.arch":                    runtime.GOARCH,
+		"file.separator":             "/",
+		"path.separator":             ":",
+		"line.separator":             "\n",
+		"file.encoding":              "UTF-8",
+		"native.encoding":            "UTF-8",
+		"sun.jnu.encoding":           "UTF-8",
+		"stdout.encoding":            "UTF-8",
+		"stderr.encoding":            "UTF-8",
+		"user.timezone":              hostTimeZone(),
+	}
+	props["user.language"], props["user.country"] = hostLocale()
+	if dir, err := os.Getwd(); err == nil {
+		props["user.dir"] = dir
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		props["user.home"] = home
+	}
+	if u, err := user.Current(); err == nil {
+		props["user.name"] = u.Username
+	}
+	return props
+}
+
+// hostLocale returns the language and country of the POSIX locale in the
+// environment, such as "ja" and "JP" for LANG=ja_JP.UTF-8. The C and POSIX
+// locales, and an unset one, map to en_US as in the JDK.
+func hostLocale() (language, country string) {
+	locale := ""
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		if locale = os.Getenv(env); locale != "" {
+			break
+		}
+	}
+	if i := strings.IndexAny(locale, ".@"); i >= 0 {
+		locale = locale[:i]
+	}
+	if locale == "" || locale == "C" || locale == "POSIX" {
+		return "en", "US"
+	}
+	language, country, _ = strings.Cut(locale, "_")
+	return strings.ToLower(language), strings.ToUpper(country)
+}
+
+// hostTimeZone returns the zone ID of the host: TZ if set, otherwise the
+// zoneinfo file /etc/localtime links to, otherwise UTC.
+func hostTimeZone() string {
+	if tz := strings.TrimPrefix(os.Getenv("TZ"), ":"); tz != "" {
+		return tz
+	}
+	if target, err := filepath.EvalSymlinks("/etc/localtime"); err == nil {
+		if _, zone, ok := strings.Cut(target, "/zoneinfo/"); ok {
+			return zone
+		}
+	}
+	return "UTC"
+}
+
+// SetProperty sets a system property before or between executions, as
+// -Dkey=value does for java.
+func (vm *VM) SetProperty(key, value string) {
+	vm.systemProperties()[key] = value
+}
+
+// defaultCharset returns the canonical name of the charset given by
+// file.encoding, used by String.getBytes() and the other no-charset
+// conversions. Unsupported encodings fall back to UTF-8.
+func (vm *VM) defaultCharset() string {
+	if cs, ok := canonicalCharset(vm.systemProperties()["file.encoding"]); ok {
+		return cs
+	}
+	return "UTF-8"
+}
+
+// stdoutCharset returns the charset System.out encodes text in:
+// stdout.encoding, or the default charset if that is unset or unsupported.
+func (vm *VM) stdoutCharset() string {
+	if cs, ok := canonicalCharset(vm.systemProperties()["stdout.encoding"]); ok {
+		return cs
+	}
+	return vm.defaultCharset()
+}
+
+// stderrCharset returns the charset System.err encodes text in, as
+// stdoutCharset does for stderr.encoding.
+func (vm *VM) stderrCharset() string {
+	if cs, ok := canonicalCharset(vm.systemProperties()["stderr.encoding"]); ok {
+		return cs
+	}
+	return vm.defaultCharset()
+}
+
+// defaultLanguage returns the language of the default locale, user.language.
+func (vm *VM) defaultLanguage() string {
+	return vm.systemProperties()["user.language"]
+}
+
+// timeZone returns the default time zone named by user.timezone, which is
+// a tz database ID or a custom "GMT+hh:mm" one. Unknown IDs mean GMT, as
+// for TimeZone.getTimeZone.
+func (vm *VM) timeZone() *time.Location {
+	id := vm.systemProperties()["user.timezone"]
+	if loc, err := time.LoadLocation(id); err == nil {
+		return loc
+	}
+	if offset, ok := strings.CutPrefix(id, "GMT"); ok && len(offset) > 1 && (offset[0] == '+' || offset[0] == '-') {
+		hh, mm, _ := strings.Cut(offset[1:], ":")
+		h, errH := strconv.Atoi(hh)
+		m, errM := strconv.Atoi("0" + mm)
+		if errH == nil && errM == nil && h <= 23 && m <= 59 {
+			secs := (h*60 + m) * 60
+			if offset[0] == '-' {
+				secs = -secs
+			}
+			return time.FixedZone(id, secs)
+		}
+	}
+	return time.UTC
+}
+
+// gmtOffsetID formats the current offset of loc from GMT as a custom zone
+// ID such as "GMT+09:00".
+func gmtOffsetID(loc *time.Location) string {
+	_, secs := time.Now().In(loc).Zone()
+	sign := '+'
+	if secs < 0 {
+		sign, secs = '-', -secs
+	}
+	return fmt.Sprintf("GMT%c%02d:%02d", sign, secs/3600, secs/60%60)
+}
+
+// systemProperties returns the VM property store, creating it from the
+// defaults overridden by VM.Properties.
+func (vm *VM) systemProperties() map[string]string {
+	if vm.properties == nil {
+		vm.properties = defaultProperties()
+		for k, v := range vm.Properties {
+			vm.properties[k] = v
+		}
+	}
+	return vm.properties
+}
+
+// environment returns the variables System.getenv reads: VM.Env, or the
+// host environment if that is nil.
+func (vm *VM) environment() map[string]string {
+	if vm.Env != nil {
+		return vm.Env
+	}
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// propertyValue returns the value of key as a Java string, or null.
+func (vm *VM) propertyValue(key string) Value {
+	if v, ok := vm.systemProperties()[key]; ok {
+		return RefValue(v)
+	}
+	return NullValue()
+}
+
+// checkPropertyKey mirrors System.checkKey.
+func checkPropertyKey(key Value) (string, error) {
+	if key.Type == TypeNull || key.Ref == nil {
+		return "", NewJavaExceptionMessage("java/lang/NullPointerException", "key can't be null")
+	}
+	s, _ := extractGoString(key)
+	if s == "" {
+		return "", NewJavaExceptionMessage("java/lang/IllegalArgumentException", "key can't be empty")
+	}
+	return s, nil
+}
+
+// handleSystemProperty handles the static property accessors of
+// java/lang/System. It reports false for methods it does not handle.
+func (vm *VM) handleSystemProperty(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + ":" + descriptor {
+	case "getProperty:(Ljava/lang/String;)Ljava/lang/String;":
+		key, err := checkPropertyKey(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		return vm.propertyValue(key), true, nil
+
+	case "getProperty:(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;":
+		key, err := checkPropertyKey(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		if v := vm.propertyValue(key); v.Type != TypeNull {
+			return v, true, nil
+		}
+		return args[1], true, nil
+
+	case "setProperty:(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;":
+		key, err := checkPropertyKey(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		value, ok := extractGoString(args[1])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		prev := vm.propertyValue(key)
+		vm.systemProperties()[key] = value
+		return prev, true, nil
+
+	case "clearProperty:(Ljava/lang/String;)Ljava/lang/String;":
+		key, err := checkPropertyKey(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		prev := vm.propertyValue(key)
+		delete(vm.systemProperties(), key)
+		return prev, true, nil
+
+	case "getProperties:()Ljava/util/Properties;":
+		// A snapshot: changes to it do not reach the property store
+		return vm.newStringMap("java/util/Properties", vm.systemProperties()), true, nil
+
+	case "lineSeparator:()Ljava/lang/String;":
+		return RefValue(vm.systemProperties()["line.separator"]), true, nil
+
+	case "getenv:(Ljava/lang/String;)Ljava/lang/String;":
+		name, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		if v, ok := vm.environment()[name]; ok {
+			return RefValue(v), true, nil
+		}
+		return NullValue(), true, nil
+
+	case "getenv:()Ljava/util/Map;":
+		return vm.newStringMap("java/util/HashMap", vm.environment()), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// savedPropertiesMap builds the java/util/HashMap that the JDK keeps in
+// VM.savedProps from the property store, using the JDK's own HashMap so
+// that code reading it directly sees real entries. If HashMap cannot be
+// executed an empty map object is returned, for which lookups yield null.
+func (vm *VM) savedPropertiesMap() Value {
+	return vm.newStringMap("java/util/HashMap", vm.systemProperties())
+}
+
+// newStringMap builds an instance of the JDK map class className holding
+// entries, in key order, with its no-argument constructor and put. If the
+// class cannot be executed an empty object of the class is returned.
+func (vm *VM) newStringMap(className string, entries map[string]string) Value {
+	m := RefValue(&JObject{ClassName: className, Fields: make(map[string]Value)})
+	cf, ctor, err := vm.resolveMethod(className, "<init>", "()V")
+	if err != nil {
+		return m
+	}
+	if _, err := vm.executeMethod(cf, ctor, []Value{m}); err != nil {
+		return RefValue(&JObject{ClassName: className, Fields: make(map[string]Value)})
+	}
+	putCf, put, err := vm.resolveMethod(className, "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;")
+	if err != nil {
+		return m
+	}
+	keys := make([]string, 0, len(entries))
+	for k := range entries {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if _, err := vm.executeMethod(putCf, put, []Value{m, RefValue(k), RefValue(entries[k])}); err != nil {
+			break
+		}
+	}
+	return m
+}
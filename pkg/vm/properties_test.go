@@ -0,0 +1,219 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/native"
+)
+
+func TestSystemProperties(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	call := func(method, desc string, args ...Value) Value {
+		t.Helper()
+		got, handled, err := v.handleSystemProperty(method, desc, args)
+		if !handled || err != nil {
+			t.Fatalf("%s%s: handled=%v err=%v", method, desc, handled, err)
+		}
+		return got
+	}
+	const (
+		get1  = "(Ljava/lang/String;)Ljava/lang/String;"
+		get2  = "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;"
+		saved = "getSavedProperty"
+	)
+
+	if got := call("getProperty", get1, RefValue("line.separator")); got.Ref != "\n" {
+		t.Errorf("line.separator: got %q", got.Ref)
+	}
+	if got := call("getProperty", get1, RefValue("app.mode")); got.Type != TypeNull {
+		t.Errorf("unset property: got %v, want null", got.Ref)
+	}
+	if got := call("getProperty", get2, RefValue("app.mode"), RefValue("dev")); got.Ref != "dev" {
+		t.Errorf("default: got %v", got.Ref)
+	}
+
+	if prev := call("setProperty", get2, RefValue("app.mode"), RefValue("prod")); prev.Type != TypeNull {
+		t.Errorf("setProperty previous value: got %v, want null", prev.Ref)
+	}
+	if got := call("getProperty", get1, RefValue("app.mode")); got.Ref != "prod" {
+		t.Errorf("after set: got %v", got.Ref)
+	}
+	got, err := v.executeNativeMethod("jdk/internal/misc/VM", saved, get1, []Value{RefValue("app.mode")})
+	if err != nil || got.Ref != "prod" {
+		t.Errorf("getSavedProperty after set: got %v, %v", got.Ref, err)
+	}
+
+	if prev := call("clearProperty", get1, RefValue("app.mode")); prev.Ref != "prod" {
+		t.Errorf("clearProperty previous value: got %v", prev.Ref)
+	}
+	if got := call("getProperty", get1, RefValue("app.mode")); got.Type != TypeNull {
+		t.Errorf("after clear: got %v, want null", got.Ref)
+	}
+
+	if _, _, err := v.handleSystemProperty("getProperty", get1, []Value{NullValue()}); err == nil {
+		t.Error("getProperty(null) should throw")
+	}
+	if _, _, err := v.handleSystemProperty("setProperty", get2, []Value{RefValue(""), RefValue("x")}); err == nil {
+		t.Error("setProperty(\"\", ...) should throw")
+	}
+}
+
+func TestSavedPropertiesBootstrap(t *testing.T) {
+	// A stand-in HashMap whose put counts its calls in a static field.
+	b := classfile.NewBuilder("java/util/HashMap", "java/lang/Object")
+	b.AddField(classfile.AccStatic, "puts", "I", nil)
+	puts := b.Fieldref("java/util/HashMap", "puts", "I")
+	b.AddMethod(classfile.AccPublic, "<init>", "()V", &classfile.CodeAttribute{MaxLocals: 1, Code: []byte{0xb1}})
+	b.AddMethod(classfile.AccPublic, "put", "(Ljava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 3,
+		Code: []byte{
+			0xb2, byte(puts >> 8), byte(puts), // getstatic puts
+			0x04,                              // iconst_1
+			0x60,                              // iadd
+			0xb3, byte(puts >> 8), byte(puts), // putstatic puts
+			0x01, // aconst_null
+			0xb0, // areturn
+		},
+	})
+	v := NewVM(mapClassLoader{"java/util/HashMap": b.Build()})
+	v.Stdout = io.Discard
+
+	if _, err := v.executeNativeMethod("jdk/internal/misc/VM", "initialize", "()V", nil); err != nil {
+		t.Fatal(err)
+	}
+	saved := v.getStaticField("jdk/internal/misc/VM", "savedProps")
+	if obj, ok := saved.Ref.(*JObject); !ok || obj.ClassName != "java/util/HashMap" {
+		t.Fatalf("savedProps: got %v", saved.Ref)
+	}
+	if got := v.getStaticField("java/util/HashMap", "puts").Int; int(got) != len(v.systemProperties()) {
+		t.Errorf("savedProps received %d entries, want %d", got, len(v.systemProperties()))
+	}
+
+	// Without a runnable HashMap, savedProps is still a non-null map.
+	bare := NewVM(mapClassLoader{})
+	if _, err := bare.executeNativeMethod("jdk/internal/misc/VM", "initialize", "()V", nil); err != nil {
+		t.Fatal(err)
+	}
+	if bare.getStaticField("jdk/internal/misc/VM", "savedProps").Ref == nil {
+		t.Error("savedProps is null without a JDK HashMap")
+	}
+}
+
+func TestHostDefaults(t *testing.T) {
+	for _, tt := range []struct {
+		lcAll, lang       string
+		language, country string
+	}{
+		{"", "ja_JP.UTF-8", "ja", "JP"},
+		{"de_DE@euro", "ja_JP.UTF-8", "de", "DE"},
+		{"", "C.UTF-8", "en", "US"},
+		{"", "", "en", "US"},
+		{"", "fr", "fr", ""},
+	} {
+		t.Setenv("LC_ALL", tt.lcAll)
+		t.Setenv("LC_CTYPE", "")
+		t.Setenv("LANG", tt.lang)
+		if language, country := hostLocale(); language != tt.language || country != tt.country {
+			t.Errorf("LC_ALL=%q LANG=%q: got %s_%s, want %s_%s", tt.lcAll, tt.lang, language, country, tt.language, tt.country)
+		}
+	}
+	t.Setenv("TZ", ":Asia/Tokyo")
+	if got := hostTimeZone(); got != "Asia/Tokyo" {
+		t.Errorf("TZ: got %q", got)
+	}
+}
+
+func TestLocaleTimeZoneAndEncodingProperties(t *testing.T) {
+	var out bytes.Buffer
+	v := &VM{Stdout: &out}
+	v.SetProperty("user.language", "tr")
+	v.SetProperty("user.timezone", "GMT-05:30")
+	v.SetProperty("file.encoding", "ISO-8859-1")
+	v.SetProperty("stdout.encoding", "US-ASCII")
+
+	if got, _ := v.handleStringMethod("title", "toUpperCase", "()Ljava/lang/String;", nil); got.Ref != "TİTLE" {
+		t.Errorf("toUpperCase with user.language=tr: got %q", got.Ref)
+	}
+	got, _ := v.handleStringMethod("é", "getBytes", "()[B", nil)
+	if b := got.Ref.(*JArray); b.Len() != 1 || b.Get(0).Int != -23 {
+		t.Errorf("getBytes with file.encoding=ISO-8859-1: got %+v", b)
+	}
+	if _, _, err := v.handlePrintStream(nil, &native.PrintStream{Writer: &out}, "println", "(Ljava/lang/String;)V", []Value{RefValue("né")}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "n?\n" {
+		t.Errorf("println with stdout.encoding=US-ASCII: got %q", out.String())
+	}
+
+	id, err := v.executeNativeMethod("java/util/TimeZone", "getSystemTimeZoneID", "(Ljava/lang/String;)Ljava/lang/String;", []Value{NullValue()})
+	if err != nil || id.Ref != "GMT-05:30" {
+		t.Errorf("getSystemTimeZoneID: got %v, %v", id.Ref, err)
+	}
+	id, err = v.executeNativeMethod("java/util/TimeZone", "getSystemGMTOffsetID", "()Ljava/lang/String;", nil)
+	if err != nil || id.Ref != "GMT-05:30" {
+		t.Errorf("getSystemGMTOffsetID: got %v, %v", id.Ref, err)
+	}
+	v.SetProperty("user.timezone", "Nowhere/Special")
+	if loc := v.timeZone(); loc != time.UTC {
+		t.Errorf("unknown zone: got %v, want UTC", loc)
+	}
+
+	// Unsupported encodings fall back to UTF-8.
+	v.SetProperty("file.encoding", "EBCDIC")
+	v.SetProperty("stdout.encoding", "")
+	if cs := v.stdoutCharset(); cs != "UTF-8" {
+		t.Errorf("stdout charset: got %s", cs)
+	}
+}
+
+func TestPropertyOverridesAndEnv(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	v.Properties = map[string]string{"app.mode": "embedded", "line.separator": "\r\n"}
+	v.Env = map[string]string{"HOME": "/home/duke"}
+	v.SetProperty("app.mode", "flag")
+
+	call := func(method, desc string, args ...Value) (Value, error) {
+		got, handled, err := v.handleSystemProperty(method, desc, args)
+		if !handled {
+			t.Fatalf("%s%s not handled", method, desc)
+		}
+		return got, err
+	}
+	if got, _ := call("getProperty", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("app.mode")); got.Ref != "flag" {
+		t.Errorf("-D over VM.Properties: got %v", got.Ref)
+	}
+	if got, _ := call("lineSeparator", "()Ljava/lang/String;"); got.Ref != "\r\n" {
+		t.Errorf("VM.Properties over the defaults: got %q", got.Ref)
+	}
+	if got, _ := call("getProperty", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("file.separator")); got.Ref != "/" {
+		t.Errorf("default file.separator: got %v", got.Ref)
+	}
+
+	if got, err := call("getenv", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("HOME")); err != nil || got.Ref != "/home/duke" {
+		t.Errorf("getenv(HOME): got %v, %v", got.Ref, err)
+	}
+	if got, _ := call("getenv", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("PATH")); got.Type != TypeNull {
+		t.Errorf("getenv(PATH) outside VM.Env: got %v", got.Ref)
+	}
+	if _, err := call("getenv", "(Ljava/lang/String;)Ljava/lang/String;", NullValue()); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("getenv(null): got %v", err)
+	}
+	if got, _ := call("getenv", "()Ljava/util/Map;"); got.Ref.(*JObject).ClassName != "java/util/HashMap" {
+		t.Errorf("getenv(): got %v", got.Ref)
+	}
+	if got, _ := call("getProperties", "()Ljava/util/Properties;"); got.Ref.(*JObject).ClassName != "java/util/Properties" {
+		t.Errorf("getProperties(): got %v", got.Ref)
+	}
+
+	// Without VM.Env, getenv reads the host environment.
+	t.Setenv("GOJVM_TEST_VAR", "host")
+	v.Env = nil
+	if got, _ := call("getenv", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("GOJVM_TEST_VAR")); got.Ref != "host" {
+		t.Errorf("host getenv: got %v", got.Ref)
+	}
+}
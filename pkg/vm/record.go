@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// recordComponentField is a record component as seen by ObjectMethods:
+// the name and descriptor of the private field backing it.
+type recordComponentField struct {
+	Name       string
+	Descriptor string
+}
+
+// handleObjectMethods implements the java/lang/runtime/ObjectMethods
+// bootstrap that javac uses for the implicit toString, hashCode and equals
+// of records. The static arguments are the record class, the component
+// names joined by ';', and one getter handle per component.
+func (vm *VM) handleObjectMethods(frame *Frame, pool []classfile.ConstantPoolEntry, bsm classfile.BootstrapMethod, methodName, descriptor string) (Value, bool, error) {
+	if len(bsm.BootstrapArguments) < 2 {
+		return Value{}, false, fmt.Errorf("ObjectMethods: expected 2+ bootstrap args, got %d", len(bsm.BootstrapArguments))
+	}
+	recordClass, err := classfile.GetClassName(pool, bsm.BootstrapArguments[0])
+	if err != nil {
+		return Value{}, false, fmt.Errorf("ObjectMethods: record class: %w", err)
+	}
+	var components []recordComponentField
+	for _, idx := range bsm.BootstrapArguments[2:] {
+		mh, ok := pool[idx].(*classfile.ConstantMethodHandle)
+		if !ok {
+			return Value{}, false, fmt.Errorf("ObjectMethods: getter arg %d is not MethodHandle", idx)
+		}
+		fref, err := classfile.ResolveFieldref(pool, mh.ReferenceIndex)
+		if err != nil {
+			return Value{}, false, fmt.Errorf("ObjectMethods: %w", err)
+		}
+		components = append(components, recordComponentField{Name: fref.FieldName, Descriptor: fref.Descriptor})
+	}
+
+	paramCount, _ := countParams(descriptor)
+	args := make([]Value, paramCount)
+	for i := paramCount - 1; i >= 0; i-- {
+		args[i] = frame.Pop()
+	}
+	if len(args) == 0 {
+		return Value{}, false, fmt.Errorf("ObjectMethods: %s%s has no receiver", methodName, descriptor)
+	}
+	rec, ok := args[0].Ref.(*JObject)
+	if !ok {
+		return Value{}, false, NewJavaException("java/lang/NullPointerException")
+	}
+
+	switch methodName {
+	case "toString":
+		frame.Push(RefValue(vm.recordToString(recordClass, rec, components)))
+	case "hashCode":
+		h := int32(0)
+		for _, c := range components {
+			h = 31*h + vm.javaHashCode(rec.Fields[c.Name])
+		}
+		frame.Push(IntValue(h))
+	case "equals":
+		other, ok := args[1].Ref.(*JObject)
+		eq := ok && other.ClassName == rec.ClassName
+		for _, c := range components {
+			if !eq {
+				break
+			}
+			eq = vm.javaEquals(rec.Fields[c.Name], other.Fields[c.Name], c.Descriptor)
+		}
+		if eq {
+			frame.Push(IntValue(1))
+		} else {
+			frame.Push(IntValue(0))
+		}
+	default:
+		return Value{}, false, fmt.Errorf("ObjectMethods: unsupported method %s", methodName)
+	}
+	return Value{}, false, nil
+}
+
+// recordToString renders a record as "Point[x=1, y=2]".
+func (vm *VM) recordToString(recordClass string, rec *JObject, components []recordComponentField) string {
+	simpleName := recordClass[strings.LastIndex(recordClass, "/")+1:]
+	if cf, err := vm.ClassLoader.LoadClass(recordClass); err == nil && cf.IsNested() {
+		simpleName = cf.SimpleName()
+	}
+	parts := make([]string, len(components))
+	for i, c := range components {
+		parts[i] = c.Name + "=" + vm.stringValueOf(rec.Fields[c.Name], c.Descriptor)
+	}
+	return simpleName + "[" + strings.Join(parts, ", ") + "]"
+}
+
+// javaHashCode computes Objects.hashCode(v) for references and the
+// wrapper-class hashCode for primitives.
+func (vm *VM) javaHashCode(v Value) int32 {
+	switch v.Type {
+	case TypeInt:
+		return v.Int
+	case TypeLong:
+		return int32(v.Long ^ int64(uint64(v.Long)>>32))
+	case TypeFloat:
+		return int32(math.Float32bits(v.Float))
+	case TypeDouble:
+		bits := math.Float64bits(v.Double)
+		return int32(bits ^ bits>>32)
+	case TypeNull:
+		return 0
+	}
+	if str, ok := extractGoString(v); ok {
+		h, _ := vm.handleStringMethod(str, "hashCode", "()I", nil)
+		return h.Int
+	}
+	obj, ok := v.Ref.(*JObject)
+	if !ok {
+		return int32(reflect.ValueOf(v.Ref).Pointer() & 0x7FFFFFFF)
+	}
+	if inner, ok := obj.Fields["value"]; ok && strings.HasPrefix(obj.ClassName, "java/lang/") {
+		if obj.ClassName == "java/lang/Boolean" {
+			if inner.Int != 0 {
+				return 1231
+			}
+			return 1237
+		}
+		return vm.javaHashCode(inner)
+	}
+	if cf, m, err := vm.resolveMethod(obj.ClassName, "hashCode", "()I"); err == nil {
+		if ret, err := vm.executeMethod(cf, m, []Value{v}); err == nil {
+			return ret.Int
+		}
+	}
+	return int32(reflect.ValueOf(obj).Pointer() & 0x7FFFFFFF)
+}
+
+// javaEquals compares two component values: primitives as their wrapper's
+// equals would, references with Objects.equals.
+func (vm *VM) javaEquals(a, b Value, descriptor string) bool {
+	switch descriptor {
+	case "F":
+		return math.Float32bits(a.Float) == math.Float32bits(b.Float) || (a.Float != a.Float && b.Float != b.Float)
+	case "D":
+		return math.Float64bits(a.Double) == math.Float64bits(b.Double) || (a.Double != a.Double && b.Double != b.Double)
+	case "J":
+		return a.Long == b.Long
+	case "Z", "B", "C", "S", "I":
+		return a.Int == b.Int
+	}
+	if a.Type == TypeNull || a.Ref == nil {
+		return b.Type == TypeNull || b.Ref == nil
+	}
+	if b.Type == TypeNull || b.Ref == nil {
+		return false
+	}
+	if a.Ref == b.Ref {
+		return true
+	}
+	if sa, ok := extractGoString(a); ok {
+		sb, ok := extractGoString(b)
+		return ok && sa == sb
+	}
+	obj, ok := a.Ref.(*JObject)
+	if !ok {
+		return false
+	}
+	if inner, ok := obj.Fields["value"]; ok && strings.HasPrefix(obj.ClassName, "java/lang/") {
+		other, ok := b.Ref.(*JObject)
+		return ok && other.ClassName == obj.ClassName && reflect.DeepEqual(inner, other.Fields["value"])
+	}
+	if cf, m, err := vm.resolveMethod(obj.ClassName, "equals", "(Ljava/lang/Object;)Z"); err == nil {
+		if ret, err := vm.executeMethod(cf, m, []Value{a, b}); err == nil {
+			return ret.Int != 0
+		}
+	}
+	return false
+}
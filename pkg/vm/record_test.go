@@ -0,0 +1,100 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestRecordObjectMethods(t *testing.T) {
+	// record Point(int x, String name)
+	pool := make([]classfile.ConstantPoolEntry, 34)
+	pool[1] = &classfile.ConstantClass{NameIndex: 2}
+	pool[2] = &classfile.ConstantUtf8{Value: "Point"}
+	pool[3] = &classfile.ConstantUtf8{Value: "x"}
+	pool[4] = &classfile.ConstantUtf8{Value: "I"}
+	pool[5] = &classfile.ConstantUtf8{Value: "name"}
+	pool[6] = &classfile.ConstantUtf8{Value: "Ljava/lang/String;"}
+	pool[7] = &classfile.ConstantNameAndType{NameIndex: 3, DescriptorIndex: 4}
+	pool[8] = &classfile.ConstantFieldref{ClassIndex: 1, NameAndTypeIndex: 7}
+	pool[9] = &classfile.ConstantMethodHandle{ReferenceKind: 1, ReferenceIndex: 8} // REF_getField
+	pool[10] = &classfile.ConstantNameAndType{NameIndex: 5, DescriptorIndex: 6}
+	pool[11] = &classfile.ConstantFieldref{ClassIndex: 1, NameAndTypeIndex: 10}
+	pool[12] = &classfile.ConstantMethodHandle{ReferenceKind: 1, ReferenceIndex: 11}
+	pool[13] = &classfile.ConstantString{StringIndex: 14}
+	pool[14] = &classfile.ConstantUtf8{Value: "x;name"}
+	pool[15] = &classfile.ConstantUtf8{Value: "java/lang/runtime/ObjectMethods"}
+	pool[16] = &classfile.ConstantClass{NameIndex: 15}
+	pool[17] = &classfile.ConstantUtf8{Value: "bootstrap"}
+	pool[18] = &classfile.ConstantUtf8{Value: "(Ljava/lang/invoke/MethodHandles$Lookup;Ljava/lang/String;Ljava/lang/invoke/TypeDescriptor;Ljava/lang/Class;Ljava/lang/String;[Ljava/lang/invoke/MethodHandle;)Ljava/lang/Object;"}
+	pool[19] = &classfile.ConstantNameAndType{NameIndex: 17, DescriptorIndex: 18}
+	pool[20] = &classfile.ConstantMethodref{ClassIndex: 16, NameAndTypeIndex: 19}
+	pool[21] = &classfile.ConstantMethodHandle{ReferenceKind: 6, ReferenceIndex: 20}
+	pool[22] = &classfile.ConstantUtf8{Value: "toString"}
+	pool[23] = &classfile.ConstantUtf8{Value: "(LPoint;)Ljava/lang/String;"}
+	pool[24] = &classfile.ConstantNameAndType{NameIndex: 22, DescriptorIndex: 23}
+	pool[25] = &classfile.ConstantInvokeDynamic{BootstrapMethodAttrIndex: 0, NameAndTypeIndex: 24}
+	pool[26] = &classfile.ConstantUtf8{Value: "hashCode"}
+	pool[27] = &classfile.ConstantUtf8{Value: "(LPoint;)I"}
+	pool[28] = &classfile.ConstantNameAndType{NameIndex: 26, DescriptorIndex: 27}
+	pool[29] = &classfile.ConstantInvokeDynamic{BootstrapMethodAttrIndex: 0, NameAndTypeIndex: 28}
+	pool[30] = &classfile.ConstantUtf8{Value: "equals"}
+	pool[31] = &classfile.ConstantUtf8{Value: "(LPoint;Ljava/lang/Object;)Z"}
+	pool[32] = &classfile.ConstantNameAndType{NameIndex: 30, DescriptorIndex: 31}
+	pool[33] = &classfile.ConstantInvokeDynamic{BootstrapMethodAttrIndex: 0, NameAndTypeIndex: 32}
+
+	cf := &classfile.ClassFile{
+		ConstantPool:     pool,
+		ThisClass:        1,
+		BootstrapMethods: []classfile.BootstrapMethod{{MethodRef: 21, BootstrapArguments: []uint16{1, 13, 9, 12}}},
+	}
+	v := NewVM(mapClassLoader{"Point": cf})
+	v.Stdout = io.Discard
+
+	newPoint := func(x int32, name string) Value {
+		return RefValue(&JObject{ClassName: "Point", Fields: map[string]Value{"x": IntValue(x), "name": RefValue(name)}})
+	}
+	call := func(code []byte, locals ...Value) Value {
+		t.Helper()
+		frame := NewFrame(2, 4, code, cf)
+		for i, l := range locals {
+			frame.SetLocal(i, l)
+		}
+		for frame.PC < len(frame.Code) {
+			opcode := frame.Code[frame.PC]
+			frame.PC++
+			ret, hasReturn, err := v.executeInstruction(frame, opcode)
+			if err != nil {
+				t.Fatalf("PC=%d: %v", frame.PC-1, err)
+			}
+			if hasReturn {
+				return ret
+			}
+		}
+		t.Fatal("no return")
+		return Value{}
+	}
+
+	p := newPoint(3, "a")
+	toString := []byte{0x2A, 0xBA, 0x00, 25, 0x00, 0x00, 0xB0}     // aload_0; invokedynamic #25; areturn
+	hashCode := []byte{0x2A, 0xBA, 0x00, 29, 0x00, 0x00, 0xAC}     // aload_0; invokedynamic #29; ireturn
+	equals := []byte{0x2A, 0x2B, 0xBA, 0x00, 33, 0x00, 0x00, 0xAC} // aload_0; aload_1; invokedynamic #33; ireturn
+
+	if got := call(toString, p); got.Ref != "Point[x=3, name=a]" {
+		t.Errorf("toString: got %v", got.Ref)
+	}
+	// 31 * 3 + "a".hashCode()
+	if got := call(hashCode, p); got.Int != 31*3+97 {
+		t.Errorf("hashCode: got %d, want %d", got.Int, 31*3+97)
+	}
+	if got := call(equals, p, newPoint(3, "a")); got.Int != 1 {
+		t.Error("equal components should compare equal")
+	}
+	if got := call(equals, p, newPoint(4, "a")); got.Int != 0 {
+		t.Error("different components should not compare equal")
+	}
+	if got := call(equals, p, NullValue()); got.Int != 0 {
+		t.Error("equals(null) should be false")
+	}
+}
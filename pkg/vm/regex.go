@@ -0,0 +1,1245 @@
+package vm
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// java.util.regex is backed by Go's regexp: Pattern.compile translates the
+// Java syntax to RE2 and returns a native Pattern, whose matchers are
+// native too. String.matches, replaceAll, replaceFirst and split go
+// through the same patterns. The state of the native objects lives in
+// hidden fields:
+//
+//	Pattern: _regex (*javaRegex)
+//	Matcher: _regex, _pattern (the Pattern), _input (string),
+//	         _match ([]int or nil), _next and _append (byte offsets
+//	         into _input)
+//
+// RE2 has no backreferences, lookaround, atomic groups or possessive
+// quantifiers; patterns using them throw UnsupportedOperationException.
+const (
+	patternClass = "java/util/regex/Pattern"
+	matcherClass = "java/util/regex/Matcher"
+)
+
+// Pattern flags.
+const (
+	regexUnixLines             = 0x01
+	regexCaseInsensitive       = 0x02
+	regexComments              = 0x04
+	regexMultiline             = 0x08
+	regexLiteral               = 0x10
+	regexDotall                = 0x20
+	regexUnicodeCase           = 0x40
+	regexUnicodeCharacterClass = 0x100
+)
+
+// eolGroupPrefix starts the names of the empty groups that record where a
+// $ matched. Java group names cannot contain '_'.
+const eolGroupPrefix = "_eol"
+
+// javaRegex is a compiled Java pattern. full and prefix are anchored for
+// matches() and lookingAt(). after skips the first rune of its input and
+// captures the pattern's match as group 1, so that a search can start in
+// the middle of a string and still see the char before it. groups are the
+// RE2 groups of the Java groups, from group 0, and eols those recording
+// where a $ matched.
+type javaRegex struct {
+	source string
+	flags  int32
+	re     *regexp.Regexp
+	full   *regexp.Regexp
+	prefix *regexp.Regexp
+	after  *regexp.Regexp
+	groups []int
+	eols   []int
+}
+
+type regexKey struct {
+	source string
+	flags  int32
+}
+
+// regexCache holds the patterns compiled for String.matches, replaceAll,
+// replaceFirst and split, which programs tend to call in loops. It is
+// dropped whenever it reaches maxCachedRegexes.
+var (
+	regexCacheMu sync.Mutex
+	regexCache   = make(map[regexKey]*javaRegex)
+)
+
+const maxCachedRegexes = 256
+
+// cachedRegex compiles source, or returns it from the cache.
+func cachedRegex(source string) (*javaRegex, error) {
+	key := regexKey{source, 0}
+	regexCacheMu.Lock()
+	r, ok := regexCache[key]
+	regexCacheMu.Unlock()
+	if ok {
+		return r, nil
+	}
+	r, err := compileRegex(source, 0)
+	if err != nil {
+		return nil, err
+	}
+	regexCacheMu.Lock()
+	if len(regexCache) >= maxCachedRegexes {
+		regexCache = make(map[regexKey]*javaRegex)
+	}
+	regexCache[key] = r
+	regexCacheMu.Unlock()
+	return r, nil
+}
+
+// compileRegex compiles a Java pattern with Pattern flags.
+func compileRegex(source string, flags int32) (*javaRegex, error) {
+	translated, err := translateRegex(source, flags)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(translated)
+	if err != nil {
+		desc := err.Error()
+		if se, ok := err.(*syntax.Error); ok {
+			desc = string(se.Code)
+			desc = strings.ToUpper(desc[:1]) + desc[1:]
+		}
+		return nil, patternSyntaxException(desc, source, -1)
+	}
+	r := &javaRegex{
+		source: source,
+		flags:  flags,
+		re:     re,
+		full:   regexp.MustCompile(`\A(?:` + translated + `)\z`),
+		prefix: regexp.MustCompile(`\A(?:` + translated + `)`),
+		after:  regexp.MustCompile(`\A(?s:.)(?s:.*?)(` + translated + `)`),
+	}
+	for g, name := range re.SubexpNames() {
+		if strings.HasPrefix(name, eolGroupPrefix) {
+			r.eols = append(r.eols, g)
+		} else {
+			r.groups = append(r.groups, g)
+		}
+	}
+	return r, nil
+}
+
+// javaMatch converts a match of re, full or prefix to the groups of the
+// Java pattern. A $ before a line terminator matches the terminator too,
+// as RE2 has no lookahead; it is cut from the end of the match again.
+func (r *javaRegex) javaMatch(m []int) []int {
+	if m == nil || len(r.eols) == 0 {
+		return m
+	}
+	cut := -1
+	for _, g := range r.eols {
+		if m[2*g] >= 0 && (cut < 0 || m[2*g] < cut) {
+			cut = m[2*g]
+		}
+	}
+	java := make([]int, 0, 2*len(r.groups))
+	for _, g := range r.groups {
+		start, end := m[2*g], m[2*g+1]
+		if cut >= 0 && start >= 0 {
+			start, end = min(start, cut), min(end, cut)
+		}
+		java = append(java, start, end)
+	}
+	return java
+}
+
+// matchFull returns the match of r over all of input, as Matcher.matches
+// finds it, or nil.
+func (r *javaRegex) matchFull(input string) []int {
+	m := r.javaMatch(r.full.FindStringSubmatchIndex(input))
+	if m == nil || m[1] != len(input) {
+		return nil
+	}
+	return m
+}
+
+// groupCount returns the number of capturing groups of the Java pattern.
+func (r *javaRegex) groupCount() int {
+	return len(r.groups) - 1
+}
+
+// groupIndex returns the number of the named group, or -1.
+func (r *javaRegex) groupIndex(name string) int {
+	if strings.HasPrefix(name, eolGroupPrefix) {
+		return -1
+	}
+	return slices.Index(r.groups, r.re.SubexpIndex(name))
+}
+
+// patternSyntaxException builds a PatternSyntaxException, whose message
+// PatternSyntaxException.getMessage derives from desc, pattern and index.
+func patternSyntaxException(desc, pattern string, index int) *JavaException {
+	msg := desc
+	if index >= 0 {
+		msg += fmt.Sprintf(" near index %d", index)
+	}
+	msg += "\n" + pattern
+	if index >= 0 {
+		msg += "\n" + strings.Repeat(" ", index) + "^"
+	}
+	exc := NewJavaExceptionMessage("java/util/regex/PatternSyntaxException", msg)
+	exc.Object.Fields["desc"] = RefValue(desc)
+	exc.Object.Fields["pattern"] = RefValue(pattern)
+	exc.Object.Fields["index"] = IntValue(int32(index))
+	return exc
+}
+
+func unsupportedRegex(construct, pattern string) *JavaException {
+	return NewJavaExceptionMessage("java/lang/UnsupportedOperationException",
+		fmt.Sprintf("%s is not supported in regular expressions: %s", construct, pattern))
+}
+
+// Translations of the Java escapes that differ from RE2, as the body of a
+// character class. Java's \s includes \x0B, which RE2's does not.
+const (
+	javaSpaceClass      = `\t-\r `
+	javaHSpaceClass     = ` \t\xA0\x{1680}\x{180e}\x{2000}-\x{200a}\x{202f}\x{205f}\x{3000}`
+	javaVSpaceClass     = `\n\x0B\f\r\x{85}\x{2028}\x{2029}`
+	javaLineBreakRegexp = `(?:\r\n|[` + javaVSpaceClass + `])`
+)
+
+// posixClasses are the bodies of Java's ASCII-only \p{...} classes.
+var posixClasses = map[string]string{
+	"Lower":  `a-z`,
+	"Upper":  `A-Z`,
+	"ASCII":  `\x00-\x7F`,
+	"Alpha":  `a-zA-Z`,
+	"Digit":  `0-9`,
+	"Alnum":  `a-zA-Z0-9`,
+	"Punct":  `!-/:-@\[-` + "`" + `{-~`,
+	"Graph":  `!-~`,
+	"Print":  ` -~`,
+	"Blank":  ` \t`,
+	"Cntrl":  `\x00-\x1F\x7F`,
+	"XDigit": `0-9a-fA-F`,
+	"Space":  javaSpaceClass,
+
+	"javaLowerCase":     `\p{Ll}`,
+	"javaUpperCase":     `\p{Lu}`,
+	"javaDigit":         `\p{Nd}`,
+	"javaLetter":        `\p{L}`,
+	"javaLetterOrDigit": `\p{L}\p{Nd}`,
+	"javaAlphabetic":    `\p{L}\p{Nl}`,
+
+	"IsAlphabetic":  `\p{L}\p{Nl}`,
+	"IsLetter":      `\p{L}`,
+	"IsDigit":       `\p{Nd}`,
+	"IsUppercase":   `\p{Lu}`,
+	"IsLowercase":   `\p{Ll}`,
+	"IsPunctuation": `\p{P}`,
+	"IsControl":     `\p{Cc}`,
+}
+
+// propertyClass returns the class body of \p{name}, and false if RE2 has
+// no equivalent.
+func propertyClass(name string) (string, bool) {
+	if body, ok := posixClasses[name]; ok {
+		return body, true
+	}
+	// \p{Lu}, \p{IsLu}, \p{IsLatin}, \p{L} and the like name Unicode
+	// categories and scripts, which RE2 knows without the prefix.
+	name = strings.TrimPrefix(name, "Is")
+	if _, ok := unicode.Categories[name]; ok {
+		return `\p{` + name + `}`, true
+	}
+	if _, ok := unicode.Scripts[name]; ok {
+		return `\p{` + name + `}`, true
+	}
+	return "", false
+}
+
+// regexMode holds the Pattern flags in effect at a point of a pattern,
+// which inline flags change up to the end of their group.
+type regexMode struct {
+	comments, caseInsensitive, unicodeCase, multiline, dotall, unixLines bool
+}
+
+// foldASCII reports whether letters also match their other case in ASCII
+// only. RE2's (?i) folds all of Unicode, as UNICODE_CASE does, so such
+// letters are translated to classes instead.
+func (m regexMode) foldASCII() bool {
+	return m.caseInsensitive && !m.unicodeCase
+}
+
+// lineTerminators returns the class body of the chars that end a line.
+func (m regexMode) lineTerminators() string {
+	if m.unixLines {
+		return `\n`
+	}
+	return `\n\r\x{85}\x{2028}\x{2029}`
+}
+
+// dot returns the translation of '.', which does not match a line
+// terminator unless DOTALL is set.
+func (m regexMode) dot() string {
+	if m.dotall {
+		return `(?s:.)`
+	}
+	return `[^` + m.lineTerminators() + `]`
+}
+
+// dollar returns the translation of $ in multiline mode, or of $ and \Z
+// otherwise. marker is an empty group recording where it matched, for
+// the line terminators RE2's own $ cannot stop before.
+func (m regexMode) dollar(marker string, multiline bool) string {
+	switch {
+	case multiline && m.unixLines:
+		return `(?m:$)`
+	case multiline:
+		return `(?:(?m:$)|` + marker + `(?:\r\n|[\r\x{85}\x{2028}\x{2029}]))`
+	case m.unixLines:
+		return `(?:\z|` + marker + `\n\z)`
+	}
+	return `(?:\z|` + marker + `(?:\r\n|[` + m.lineTerminators() + `])\z)`
+}
+
+// translateRegex rewrites a Java pattern into RE2 syntax.
+func translateRegex(pattern string, flags int32) (string, error) {
+	mode := regexMode{
+		comments:        flags&regexComments != 0,
+		caseInsensitive: flags&regexCaseInsensitive != 0,
+		unicodeCase:     flags&(regexUnicodeCase|regexUnicodeCharacterClass) != 0,
+		multiline:       flags&regexMultiline != 0,
+		dotall:          flags&regexDotall != 0,
+		unixLines:       flags&regexUnixLines != 0,
+	}
+	var b strings.Builder
+	if mode.caseInsensitive && !mode.foldASCII() {
+		b.WriteString("(?i)")
+	}
+	if mode.multiline {
+		b.WriteString("(?m)") // for ^
+	}
+	if flags&regexLiteral != 0 {
+		writeLiteral(&b, pattern, mode.foldASCII(), false)
+		return b.String(), nil
+	}
+
+	eols := 0
+	marker := func() string {
+		eols++
+		return fmt.Sprintf("(?P<%s%d>)", eolGroupPrefix, eols)
+	}
+	var groups []regexMode // the mode outside each open group
+	inClass := 0           // nesting of character classes; Java allows unions like [a-c[x-z]]
+	quantified := false
+	for i := 0; i < len(pattern); {
+		c := pattern[i]
+		if mode.comments {
+			if strings.IndexByte(" \t\n\x0B\f\r", c) >= 0 {
+				i++
+				continue
+			}
+			if c == '#' && inClass == 0 {
+				for i < len(pattern) && pattern[i] != '\n' {
+					i++
+				}
+				continue
+			}
+		}
+		wasQuantified := quantified
+		quantified = false
+
+		switch {
+		case strings.HasPrefix(pattern[i:], `\Z`) && inClass == 0:
+			b.WriteString(mode.dollar(marker(), false))
+			i += 2
+			continue
+
+		case c == '\\':
+			n, err := translateEscape(&b, pattern, i, inClass > 0, mode.foldASCII())
+			if err != nil {
+				return "", err
+			}
+			i += n
+			continue
+
+		case inClass > 0:
+			switch {
+			case c == '[':
+				if strings.HasPrefix(pattern[i:], "[^") {
+					return "", unsupportedRegex("A negated nested character class", pattern)
+				}
+				inClass++
+				i++
+				continue
+			case c == ']':
+				inClass--
+				if inClass > 0 {
+					i++
+					continue
+				}
+			case strings.HasPrefix(pattern[i:], "&&"):
+				return "", unsupportedRegex("Character class intersection", pattern)
+			case mode.foldASCII() && c < utf8.RuneSelf:
+				// A char or a range, with the other case of its letters
+				lo, hi, n := rune(c), rune(c), 1
+				if i+2 < len(pattern) && pattern[i+1] == '-' && !strings.ContainsRune(`]\[`, rune(pattern[i+2])) {
+					r, size := utf8.DecodeRuneInString(pattern[i+2:])
+					hi, n = r, 2+size
+				}
+				b.WriteString(pattern[i : i+n])
+				b.WriteString(foldedRange(lo, hi))
+				i += n
+				continue
+			}
+
+		case c == '[':
+			inClass = 1
+			b.WriteByte(c)
+			i++
+			if i < len(pattern) && pattern[i] == '^' {
+				b.WriteByte('^')
+				i++
+			}
+			continue
+
+		case c == '.':
+			b.WriteString(mode.dot())
+			i++
+			continue
+
+		case c == '$':
+			b.WriteString(mode.dollar(marker(), mode.multiline))
+			i++
+			continue
+
+		case c == '(':
+			groups = append(groups, mode)
+			if !strings.HasPrefix(pattern[i:], "(?") {
+				break
+			}
+			rest := pattern[i+2:]
+			switch {
+			case strings.HasPrefix(rest, ":"):
+			case strings.HasPrefix(rest, "="), strings.HasPrefix(rest, "!"),
+				strings.HasPrefix(rest, "<="), strings.HasPrefix(rest, "<!"):
+				return "", unsupportedRegex("Lookaround", pattern)
+			case strings.HasPrefix(rest, ">"):
+				return "", unsupportedRegex("An atomic group", pattern)
+			case strings.HasPrefix(rest, "<"):
+				// Named groups have the same syntax in RE2.
+				if end := strings.IndexByte(rest, '>'); end > 0 {
+					b.WriteString(pattern[i : i+3+end])
+					i += 3 + end
+					continue
+				}
+			default:
+				n, closed, err := translateFlags(&b, pattern, i, &mode)
+				if err != nil {
+					return "", err
+				}
+				if closed { // (?flags) applies to the enclosing group
+					groups = groups[:len(groups)-1]
+				}
+				i += n
+				continue
+			}
+
+		case c == ')':
+			if len(groups) > 0 {
+				mode = groups[len(groups)-1]
+				groups = groups[:len(groups)-1]
+			}
+
+		case c == '*' || c == '+' || c == '?':
+			if wasQuantified {
+				if c == '+' {
+					return "", unsupportedRegex("A possessive quantifier", pattern)
+				}
+				if c == '?' { // reluctant
+					b.WriteByte(c)
+					i++
+					if i < len(pattern) && pattern[i] == '+' {
+						return "", patternSyntaxException("Dangling meta character '+'", pattern, i)
+					}
+					continue
+				}
+			}
+			quantified = true
+
+		case c == '{':
+			if end := strings.IndexByte(pattern[i:], '}'); end > 0 {
+				b.WriteString(pattern[i : i+end+1])
+				i += end + 1
+				quantified = true
+				continue
+			}
+
+		case mode.foldASCII() && isASCIILetter(rune(c)):
+			writeLiteral(&b, pattern[i:i+1], true, false)
+			i++
+			continue
+		}
+		b.WriteByte(c)
+		i++
+	}
+	return b.String(), nil
+}
+
+// translateFlags translates the inline flags group starting at pattern[i],
+// either (?idmsuxU-idmsuxU) or (?idmsuxU-idmsuxU:, and applies it to mode.
+// RE2 only learns of the flags that change its (?i), with UNICODE_CASE,
+// and its (?m), for ^. It returns the bytes consumed and whether the
+// group was closed.
+func translateFlags(b *strings.Builder, pattern string, i int, mode *regexMode) (int, bool, error) {
+	outer := *mode
+	on := true
+	for j := i + 2; j < len(pattern); j++ {
+		switch c := pattern[j]; c {
+		case 'i':
+			mode.caseInsensitive = on
+		case 'm':
+			mode.multiline = on
+		case 's':
+			mode.dotall = on
+		case 'x':
+			mode.comments = on
+		case 'd':
+			mode.unixLines = on
+		case 'u', 'U': // UNICODE_CHARACTER_CLASS implies UNICODE_CASE
+			mode.unicodeCase = on
+		case '-':
+			on = false
+		case ')', ':':
+			var set, clear string
+			for _, f := range []struct {
+				flag          string
+				before, after bool
+			}{
+				{"i", outer.caseInsensitive && !outer.foldASCII(), mode.caseInsensitive && !mode.foldASCII()},
+				{"m", outer.multiline, mode.multiline},
+			} {
+				switch {
+				case f.after && !f.before:
+					set += f.flag
+				case f.before && !f.after:
+					clear += f.flag
+				}
+			}
+			flags := set
+			if clear != "" {
+				flags += "-" + clear
+			}
+			switch {
+			case c == ':':
+				b.WriteString("(?" + flags + ":")
+			case flags != "":
+				b.WriteString("(?" + flags + ")")
+			}
+			return j - i + 1, c == ')', nil
+		default:
+			return 0, false, patternSyntaxException("Unknown inline modifier", pattern, j)
+		}
+	}
+	return 0, false, patternSyntaxException("Unknown group type", pattern, len(pattern))
+}
+
+func isASCIILetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// writeLiteral writes s as literal text, with each ASCII letter matching
+// both its cases if fold is set.
+func writeLiteral(b *strings.Builder, s string, fold, inClass bool) {
+	for _, r := range s {
+		switch {
+		case !fold || !isASCIILetter(r):
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		case inClass:
+			b.WriteRune(r)
+			b.WriteRune(r ^ 0x20)
+		default:
+			b.WriteString("[" + string(r) + string(r^0x20) + "]")
+		}
+	}
+}
+
+// foldedRange returns the class body of the other ASCII case of the
+// letters in [lo, hi].
+func foldedRange(lo, hi rune) string {
+	var b strings.Builder
+	for _, letters := range [][2]rune{{'a', 'z'}, {'A', 'Z'}} {
+		if l, h := max(lo, letters[0]), min(hi, letters[1]); l <= h {
+			b.WriteString(string(l^0x20) + "-" + string(h^0x20))
+		}
+	}
+	return b.String()
+}
+
+// translateEscape translates the escape sequence at pattern[i] and returns
+// its length.
+func translateEscape(b *strings.Builder, pattern string, i int, inClass, fold bool) (int, error) {
+	if i+1 >= len(pattern) {
+		return 0, patternSyntaxException("Unexpected internal error", pattern, len(pattern))
+	}
+	// class writes a translated class body, bracketed outside a class.
+	class := func(body string, negated bool) {
+		switch {
+		case inClass:
+			b.WriteString(body)
+		case negated:
+			b.WriteString("[^" + body + "]")
+		default:
+			b.WriteString("[" + body + "]")
+		}
+	}
+	c := pattern[i+1]
+	switch c {
+	case 'Q':
+		end := strings.Index(pattern[i+2:], `\E`)
+		if end < 0 {
+			writeLiteral(b, pattern[i+2:], fold, inClass)
+			return len(pattern) - i, nil
+		}
+		writeLiteral(b, pattern[i+2:i+2+end], fold, inClass)
+		return end + 4, nil
+	case 'E':
+		return 2, nil // a \E without \Q is ignored
+	case 's':
+		class(javaSpaceClass, false)
+	case 'S':
+		if inClass {
+			b.WriteString(`\S`)
+		} else {
+			class(javaSpaceClass, true)
+		}
+	case 'h', 'v':
+		body := javaHSpaceClass
+		if c == 'v' {
+			body = javaVSpaceClass
+		}
+		class(body, false)
+	case 'H', 'V':
+		if inClass {
+			return 0, unsupportedRegex(`\`+string(c)+" in a character class", pattern)
+		}
+		body := javaHSpaceClass
+		if c == 'V' {
+			body = javaVSpaceClass
+		}
+		class(body, true)
+	case 'R':
+		if inClass {
+			return 0, patternSyntaxException("Illegal/unsupported escape sequence", pattern, i+1)
+		}
+		b.WriteString(javaLineBreakRegexp)
+	case 'e':
+		b.WriteString(`\x1B`)
+	case 'c':
+		if i+2 >= len(pattern) {
+			return 0, patternSyntaxException("Illegal control escape sequence", pattern, i+2)
+		}
+		fmt.Fprintf(b, `\x{%x}`, pattern[i+2]^64)
+		return 3, nil
+	case '0':
+		n, j := 0, i+2
+		for ; j < len(pattern) && j < i+5 && pattern[j] >= '0' && pattern[j] <= '7'; j++ {
+			if n*8+int(pattern[j]-'0') > 0377 {
+				break
+			}
+			n = n*8 + int(pattern[j]-'0')
+		}
+		if j == i+2 {
+			return 0, patternSyntaxException("Illegal octal escape sequence", pattern, j)
+		}
+		fmt.Fprintf(b, `\x{%x}`, n)
+		return j - i, nil
+	case 'u':
+		r, n, ok := unicodeEscape(pattern[i:])
+		if !ok {
+			return 0, patternSyntaxException("Illegal Unicode escape sequence", pattern, i+2)
+		}
+		fmt.Fprintf(b, `\x{%x}`, r)
+		return n, nil
+	case 'p', 'P':
+		name, n := "", 2
+		switch {
+		case strings.HasPrefix(pattern[i+2:], "{"):
+			end := strings.IndexByte(pattern[i+2:], '}')
+			if end < 0 {
+				return 0, patternSyntaxException("Unclosed character family", pattern, len(pattern))
+			}
+			name, n = pattern[i+3:i+2+end], end+3
+		case i+2 < len(pattern):
+			name, n = pattern[i+2:i+3], 3
+		}
+		body, ok := propertyClass(name)
+		if !ok {
+			return 0, patternSyntaxException("Unknown character property name {"+name+"}", pattern, i+n-1)
+		}
+		if c == 'P' && inClass {
+			if !strings.HasPrefix(body, `\p{`) || strings.Count(body, `\`) > 1 {
+				return 0, unsupportedRegex(`\P{`+name+"} in a character class", pattern)
+			}
+			body = `\P` + body[2:]
+			b.WriteString(body)
+			return n, nil
+		}
+		class(body, c == 'P')
+		return n, nil
+	case 'k':
+		return 0, unsupportedRegex("A backreference", pattern)
+	case 'G', 'Z', 'X': // \Z is translated outside classes
+		return 0, unsupportedRegex(`\`+string(c), pattern)
+	case 'b':
+		if strings.HasPrefix(pattern[i+2:], "{") {
+			return 0, unsupportedRegex(`\b{g}`, pattern)
+		}
+		b.WriteString(`\b`)
+	default:
+		switch {
+		case c >= '1' && c <= '9':
+			if inClass {
+				return 0, patternSyntaxException("Illegal/unsupported escape sequence", pattern, i+1)
+			}
+			return 0, unsupportedRegex("A backreference", pattern)
+		case c >= utf8.RuneSelf:
+			// Java allows escaping any non-alphabetic char.
+			r, size := utf8.DecodeRuneInString(pattern[i+1:])
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			return 1 + size, nil
+		}
+		// \t, \n, \d, \w, \x.., \A, \z, \B and escaped punctuation read the
+		// same in RE2, which rejects the escapes Java rejects too.
+		b.WriteString(pattern[i : i+2])
+	}
+	return 2, nil
+}
+
+// unicodeEscape parses a \uXXXX escape at the start of s, combining a
+// surrogate pair written as two escapes. It returns the code point and the
+// bytes consumed.
+func unicodeEscape(s string) (rune, int, bool) {
+	hex4 := func(s string) (rune, bool) {
+		if len(s) < 6 || s[:2] != `\u` {
+			return 0, false
+		}
+		n, err := strconv.ParseUint(s[2:6], 16, 16)
+		return rune(n), err == nil
+	}
+	r, ok := hex4(s)
+	if !ok {
+		return 0, 0, false
+	}
+	if r >= 0xD800 && r < 0xDC00 {
+		if lo, ok := hex4(s[6:]); ok && lo >= 0xDC00 && lo < 0xE000 {
+			return (r-0xD800)<<10 + (lo - 0xDC00) + 0x10000, 12, true
+		}
+	}
+	return r, 6, true
+}
+
+// quotePattern implements Pattern.quote.
+func quotePattern(s string) string {
+	if !strings.Contains(s, `\E`) {
+		return `\Q` + s + `\E`
+	}
+	return `\Q` + strings.ReplaceAll(s, `\E`, `\E\\E\Q`) + `\E`
+}
+
+// quoteReplacement implements Matcher.quoteReplacement.
+func quoteReplacement(s string) string {
+	if !strings.ContainsAny(s, `\$`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' || s[i] == '$' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// expandReplacement appends the Java replacement string repl for the
+// match m of input to b: $n and ${name} insert groups and a backslash
+// quotes the next char.
+func expandReplacement(b *strings.Builder, r *javaRegex, input string, m []int, repl string) error {
+	groups := r.groupCount()
+	for i := 0; i < len(repl); i++ {
+		switch c := repl[i]; c {
+		case '\\':
+			i++
+			if i == len(repl) {
+				return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "character to be escaped is missing")
+			}
+			b.WriteByte(repl[i])
+		case '$':
+			i++
+			if i == len(repl) {
+				return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Illegal group reference: group index is missing")
+			}
+			var g int
+			if repl[i] == '{' {
+				end := strings.IndexByte(repl[i:], '}')
+				if end < 0 {
+					return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "named capturing group is missing trailing '}'")
+				}
+				name := repl[i+1 : i+end]
+				if name == "" {
+					return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "named capturing group has 0 length name")
+				}
+				if g = r.groupIndex(name); g < 0 {
+					return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "No group with name {"+name+"}")
+				}
+				i += end
+			} else {
+				if repl[i] < '0' || repl[i] > '9' {
+					return NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Illegal group reference")
+				}
+				g = int(repl[i] - '0')
+				if g > groups {
+					return NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException", fmt.Sprintf("No group %d", g))
+				}
+				// Further digits belong to the reference while it names a group.
+				for i+1 < len(repl) && repl[i+1] >= '0' && repl[i+1] <= '9' && g*10+int(repl[i+1]-'0') <= groups {
+					g = g*10 + int(repl[i+1]-'0')
+					i++
+				}
+			}
+			if m[2*g] >= 0 {
+				b.WriteString(input[m[2*g]:m[2*g+1]])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return nil
+}
+
+// replaceRegex implements replaceAll and replaceFirst.
+func replaceRegex(r *javaRegex, input, repl string, all bool) (string, error) {
+	n := 1
+	if all {
+		n = -1
+	}
+	matches := r.findAll(input, n)
+	if matches == nil {
+		return input, nil
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(input[last:m[0]])
+		if err := expandReplacement(&b, r, input, m, repl); err != nil {
+			return "", err
+		}
+		last = m[1]
+	}
+	b.WriteString(input[last:])
+	return b.String(), nil
+}
+
+// splitRegex implements Pattern.split and String.split.
+func splitRegex(r *javaRegex, input string, limit int) []string {
+	var parts []string
+	index := 0
+	limited := limit > 0
+	for _, m := range r.findAll(input, -1) {
+		if !limited || len(parts) < limit-1 {
+			// A zero-width match at the beginning never produces an
+			// empty leading substring.
+			if index == 0 && m[0] == 0 && m[1] == 0 {
+				continue
+			}
+			parts = append(parts, input[index:m[0]])
+			index = m[1]
+		} else if len(parts) == limit-1 {
+			parts = append(parts, input[index:])
+			index = m[1]
+		}
+	}
+	if index == 0 {
+		return []string{input}
+	}
+	if !limited || len(parts) < limit {
+		parts = append(parts, input[index:])
+	}
+	if limit == 0 {
+		for len(parts) > 0 && parts[len(parts)-1] == "" {
+			parts = parts[:len(parts)-1]
+		}
+	}
+	return parts
+}
+
+func stringArray(strs []string) *JArray {
+	arr := NewArray("Ljava/lang/String;", len(strs))
+	for i, s := range strs {
+		arr.Set(i, RefValue(s))
+	}
+	return arr
+}
+
+// charSequence returns the string of a CharSequence argument, or throws
+// NullPointerException for null.
+func (vm *VM) charSequence(v Value) (string, error) {
+	if v.Type == TypeNull || v.Ref == nil {
+		return "", NewJavaException("java/lang/NullPointerException")
+	}
+	if s, ok := v.Ref.(string); ok {
+		return s, nil
+	}
+	return vm.valueToString(v), nil
+}
+
+// regexArg compiles a pattern passed to a String method.
+func regexArg(v Value) (*javaRegex, error) {
+	source, ok := extractGoString(v)
+	if !ok {
+		return nil, NewJavaException("java/lang/NullPointerException")
+	}
+	return cachedRegex(source)
+}
+
+// handleStringRegex implements the String methods taking a regex:
+// matches, replaceAll, replaceFirst and split.
+func (vm *VM) handleStringRegex(str, methodName, descriptor string, args []Value) (Value, error) {
+	r, err := regexArg(args[0])
+	if err != nil {
+		return Value{}, err
+	}
+	switch methodName {
+	case "matches":
+		return boolValue(r.matchFull(str) != nil), nil
+	case "replaceAll", "replaceFirst":
+		repl, ok := extractGoString(args[1])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		s, err := replaceRegex(r, str, repl, methodName == "replaceAll")
+		if err != nil {
+			return Value{}, err
+		}
+		return RefValue(s), nil
+	default: // split
+		limit := 0
+		if descriptor == "(Ljava/lang/String;I)[Ljava/lang/String;" {
+			limit = int(args[1].Int)
+		}
+		return RefValue(stringArray(splitRegex(r, str, limit))), nil
+	}
+}
+
+func newPattern(r *javaRegex) Value {
+	return RefValue(&JObject{ClassName: patternClass, Fields: map[string]Value{"_regex": RefValue(r)}})
+}
+
+// handleRegexStatic implements the static methods of Pattern and Matcher.
+func (vm *VM) handleRegexStatic(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + ":" + descriptor {
+	case "compile:(Ljava/lang/String;)Ljava/util/regex/Pattern;",
+		"compile:(Ljava/lang/String;I)Ljava/util/regex/Pattern;":
+		source, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		var flags int32
+		if len(args) > 1 {
+			flags = args[1].Int
+		}
+		r, err := compileRegex(source, flags)
+		if err != nil {
+			return Value{}, true, err
+		}
+		return newPattern(r), true, nil
+	case "matches:(Ljava/lang/String;Ljava/lang/CharSequence;)Z":
+		r, err := regexArg(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		input, err := vm.charSequence(args[1])
+		if err != nil {
+			return Value{}, true, err
+		}
+		return boolValue(r.matchFull(input) != nil), true, nil
+	case "quote:(Ljava/lang/String;)Ljava/lang/String;":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		return RefValue(quotePattern(s)), true, nil
+	case "quoteReplacement:(Ljava/lang/String;)Ljava/lang/String;":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		return RefValue(quoteReplacement(s)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// handleRegexMethod implements the methods of native patterns and
+// matchers.
+func (vm *VM) handleRegexMethod(obj *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
+	r, ok := obj.Fields["_regex"].Ref.(*javaRegex)
+	if !ok {
+		return Value{}, false, nil
+	}
+	switch obj.ClassName {
+	case patternClass:
+		return vm.patternMethod(obj, r, methodName, descriptor, args)
+	case matcherClass:
+		return vm.matcherMethod(obj, r, methodName, descriptor, args)
+	}
+	return Value{}, false, nil
+}
+
+func (vm *VM) patternMethod(obj *JObject, r *javaRegex, methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + ":" + descriptor {
+	case "pattern:()Ljava/lang/String;", "toString:()Ljava/lang/String;":
+		return RefValue(r.source), true, nil
+	case "flags:()I":
+		return IntValue(r.flags), true, nil
+	case "matcher:(Ljava/lang/CharSequence;)Ljava/util/regex/Matcher;":
+		input, err := vm.charSequence(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		m := &JObject{ClassName: matcherClass, Fields: map[string]Value{
+			"_regex":   RefValue(r),
+			"_pattern": RefValue(obj),
+		}}
+		resetMatcher(m, input)
+		return RefValue(m), true, nil
+	case "split:(Ljava/lang/CharSequence;)[Ljava/lang/String;",
+		"split:(Ljava/lang/CharSequence;I)[Ljava/lang/String;":
+		input, err := vm.charSequence(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		limit := 0
+		if len(args) > 1 {
+			limit = int(args[1].Int)
+		}
+		return RefValue(stringArray(splitRegex(r, input, limit))), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// resetMatcher implements Matcher.reset(CharSequence).
+func resetMatcher(m *JObject, input string) {
+	m.Fields["_input"] = RefValue(input)
+	m.Fields["_match"] = NullValue()
+	m.Fields["_next"] = IntValue(0)
+	m.Fields["_append"] = IntValue(0)
+}
+
+// setMatch records the result of a match operation.
+func setMatch(m *JObject, input string, match []int) Value {
+	if match == nil {
+		m.Fields["_match"] = NullValue()
+		return boolValue(false)
+	}
+	m.Fields["_match"] = RefValue(match)
+	m.Fields["_next"] = IntValue(int32(nextSearch(input, match)))
+	return boolValue(true)
+}
+
+// find returns the first match of r in input starting at or after the
+// byte offset from. Anchors and word boundaries at from see the char
+// before it.
+func (r *javaRegex) find(input string, from int) []int {
+	if from == 0 {
+		return r.javaMatch(r.re.FindStringSubmatchIndex(input))
+	}
+	_, size := utf8.DecodeLastRuneInString(input[:from])
+	base := from - size
+	m := r.after.FindStringSubmatchIndex(input[base:])
+	if m == nil {
+		return nil
+	}
+	m = m[2:]
+	for i := range m {
+		if m[i] >= 0 {
+			m[i] += base
+		}
+	}
+	return r.javaMatch(m)
+}
+
+// nextSearch returns the byte offset at which Matcher.find looks for the
+// match after match: its end, or one char later if it is empty.
+func nextSearch(input string, match []int) int {
+	next := match[1]
+	if match[0] == match[1] {
+		_, size := utf8.DecodeRuneInString(input[next:])
+		next += max(size, 1)
+	}
+	return next
+}
+
+// findAll returns the first n matches of r in input, or all of them if n
+// is negative, as successive calls of Matcher.find report them. Unlike
+// Go's FindAll, an empty match right after a nonempty one is reported.
+func (r *javaRegex) findAll(input string, n int) [][]int {
+	var all [][]int
+	for from := 0; from <= len(input) && len(all) != n; {
+		m := r.find(input, from)
+		if m == nil {
+			break
+		}
+		all = append(all, m)
+		from = nextSearch(input, m)
+	}
+	return all
+}
+
+// byteOffset converts a char index into s to a byte offset.
+func byteOffset(s string, index int) int {
+	if isASCII(s) {
+		return index
+	}
+	return len(charsString(stringChars(s)[:index]))
+}
+
+func (vm *VM) matcherMethod(m *JObject, r *javaRegex, methodName, descriptor string, args []Value) (Value, bool, error) {
+	input, _ := m.Fields["_input"].Ref.(string)
+	match, _ := m.Fields["_match"].Ref.([]int)
+
+	// group resolves the group argument of group, start and end.
+	group := func() (int, error) {
+		if match == nil {
+			return 0, NewJavaExceptionMessage("java/lang/IllegalStateException", "No match found")
+		}
+		if len(args) == 0 {
+			return 0, nil
+		}
+		if name, ok := extractGoString(args[0]); ok {
+			g := r.groupIndex(name)
+			if g < 0 {
+				return 0, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "No group with name <"+name+">")
+			}
+			return g, nil
+		}
+		g := int(args[0].Int)
+		if g < 0 || g > r.groupCount() {
+			return 0, NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException", fmt.Sprintf("No group %d", g))
+		}
+		return g, nil
+	}
+
+	switch methodName + ":" + descriptor {
+	case "pattern:()Ljava/util/regex/Pattern;":
+		return m.Fields["_pattern"], true, nil
+	case "matches:()Z":
+		return setMatch(m, input, r.matchFull(input)), true, nil
+	case "lookingAt:()Z":
+		return setMatch(m, input, r.javaMatch(r.prefix.FindStringSubmatchIndex(input))), true, nil
+	case "find:()Z":
+		from := int(m.Fields["_next"].Int)
+		if from > len(input) {
+			return setMatch(m, input, nil), true, nil
+		}
+		return setMatch(m, input, r.find(input, from)), true, nil
+	case "find:(I)Z":
+		start := int(args[0].Int)
+		if start < 0 || start > charLength(input) {
+			return Value{}, true, NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException", "Illegal start index")
+		}
+		resetMatcher(m, input)
+		return setMatch(m, input, r.find(input, byteOffset(input, start))), true, nil
+	case "groupCount:()I":
+		return IntValue(int32(r.groupCount())), true, nil
+	case "group:()Ljava/lang/String;", "group:(I)Ljava/lang/String;", "group:(Ljava/lang/String;)Ljava/lang/String;":
+		g, err := group()
+		if err != nil {
+			return Value{}, true, err
+		}
+		if match[2*g] < 0 {
+			return NullValue(), true, nil
+		}
+		return RefValue(input[match[2*g]:match[2*g+1]]), true, nil
+	case "start:()I", "start:(I)I", "start:(Ljava/lang/String;)I",
+		"end:()I", "end:(I)I", "end:(Ljava/lang/String;)I":
+		g, err := group()
+		if err != nil {
+			return Value{}, true, err
+		}
+		offset := match[2*g]
+		if methodName == "end" {
+			offset = match[2*g+1]
+		}
+		return IntValue(int32(charIndex(input, offset))), true, nil
+	case "reset:()Ljava/util/regex/Matcher;":
+		resetMatcher(m, input)
+		return RefValue(m), true, nil
+	case "reset:(Ljava/lang/CharSequence;)Ljava/util/regex/Matcher;":
+		s, err := vm.charSequence(args[0])
+		if err != nil {
+			return Value{}, true, err
+		}
+		resetMatcher(m, s)
+		return RefValue(m), true, nil
+	case "replaceAll:(Ljava/lang/String;)Ljava/lang/String;",
+		"replaceFirst:(Ljava/lang/String;)Ljava/lang/String;":
+		repl, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		resetMatcher(m, input)
+		s, err := replaceRegex(r, input, repl, methodName == "replaceAll")
+		if err != nil {
+			return Value{}, true, err
+		}
+		return RefValue(s), true, nil
+	case "appendReplacement:(Ljava/lang/StringBuilder;Ljava/lang/String;)Ljava/util/regex/Matcher;",
+		"appendReplacement:(Ljava/lang/StringBuffer;Ljava/lang/String;)Ljava/util/regex/Matcher;":
+		if match == nil {
+			return Value{}, true, NewJavaExceptionMessage("java/lang/IllegalStateException", "No match available")
+		}
+		repl, ok := extractGoString(args[1])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		var b strings.Builder
+		b.WriteString(input[m.Fields["_append"].Int:match[0]])
+		if err := expandReplacement(&b, r, input, match, repl); err != nil {
+			return Value{}, true, err
+		}
+		if err := vm.appendToBuilder(args[0], b.String()); err != nil {
+			return Value{}, true, err
+		}
+		m.Fields["_append"] = IntValue(int32(match[1]))
+		return RefValue(m), true, nil
+	case "appendTail:(Ljava/lang/StringBuilder;)Ljava/lang/StringBuilder;",
+		"appendTail:(Ljava/lang/StringBuffer;)Ljava/lang/StringBuffer;":
+		if err := vm.appendToBuilder(args[0], input[m.Fields["_append"].Int:]); err != nil {
+			return Value{}, true, err
+		}
+		return args[0], true, nil
+	case "toString:()Ljava/lang/String;":
+		last := ""
+		if match != nil {
+			last = input[match[0]:match[1]]
+		}
+		return RefValue(fmt.Sprintf("java.util.regex.Matcher[pattern=%s region=0,%d lastmatch=%s]",
+			r.source, charLength(input), last)), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// appendToBuilder appends s to a StringBuilder or StringBuffer.
+func (vm *VM) appendToBuilder(sb Value, s string) error {
+	if sb.Type == TypeNull || sb.Ref == nil {
+		return NewJavaException("java/lang/NullPointerException")
+	}
+	_, _, err := vm.handleStringBuilder(sb, "append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;", []Value{RefValue(s)})
+	return err
+}
@@ -0,0 +1,308 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestStringRegex(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	call := func(str, method, desc string, args ...Value) Value {
+		t.Helper()
+		ret, err := v.handleStringMethod(str, method, desc, args)
+		if err != nil {
+			t.Fatalf("%q.%s: %v", str, method, err)
+		}
+		return ret
+	}
+	split := func(str, regex string, limit int) string {
+		t.Helper()
+		arr := call(str, "split", "(Ljava/lang/String;I)[Ljava/lang/String;", RefValue(regex), IntValue(int32(limit))).Ref.(*JArray)
+		parts := make([]string, arr.Len())
+		for i := range parts {
+			parts[i], _ = extractGoString(arr.Get(i))
+		}
+		return strings.Join(parts, "|")
+	}
+	for _, c := range []struct {
+		got, want string
+	}{
+		{split("a,b,,c,,", ",", 0), "a|b||c"},
+		{split("a,b,,c,,", ",", -1), "a|b||c||"},
+		{split("a,b,c", ",", 2), "a|b,c"},
+		{split("abc", "", 0), "a|b|c"},
+		{split(" a  b ", `\s+`, 0), "|a|b"},
+		{split("", ",", 0), ""},
+		{split("x1y22z", `\d+`, 0), "x|y|z"},
+		{split("abc", "b*", 0), "a||c"},
+		{call("hello world", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue(`(\w+) (\w+)`), RefValue(`$2 $1`)).Ref.(string), "world hello"},
+		{call("a.b.c", "replaceFirst", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue(`\.`), RefValue(`\$`)).Ref.(string), "a$b.c"},
+		{call("2024-01-15", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue(`(?<y>\d{4})-(?<m>\d\d)-(?<d>\d\d)`), RefValue(`${d}/${m}/${y}`)).Ref.(string), "15/01/2024"},
+		// An empty match right after a nonempty one counts, as in Java.
+		{call("aaa", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue("a*"), RefValue("X")).Ref.(string), "XX"},
+		{call("aab", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue("a*"), RefValue("-")).Ref.(string), "--b-"},
+		{call("abc", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue("x*"), RefValue("-")).Ref.(string), "-a-b-c-"},
+		{call("a b", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue(`\b`), RefValue("|")).Ref.(string), "|a| |b|"},
+		{call("a\nb", "replaceAll", "(Ljava/lang/String;Ljava/lang/String;)Ljava/lang/String;",
+			RefValue("(?m)^"), RefValue(">")).Ref.(string), ">a\n>b"},
+	} {
+		if c.got != c.want {
+			t.Errorf("got %q, want %q", c.got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		str, regex string
+		want       bool
+	}{
+		{"abc123", `[a-z]+\d+`, true},
+		{"abc123x", `[a-z]+\d+`, false},
+		{"ab", "a|ab", true},
+		{"Hello", `\p{Upper}\p{Lower}+`, true},
+		{"x\x0By", `x\sy`, true},
+		{"a-b", `[\w[-]]+`, true},
+		{"ÄÖ", `\p{IsLatin}+`, true},
+		{"αβ", `\p{InGreek}+`, false},
+	} {
+		ret, err := v.handleStringMethod(c.str, "matches", "(Ljava/lang/String;)Z", []Value{RefValue(c.regex)})
+		if c.regex == `\p{InGreek}+` {
+			// Unicode blocks have no RE2 equivalent.
+			if !isJavaException(err, "java/util/regex/PatternSyntaxException") {
+				t.Errorf("%q: got %v, want PatternSyntaxException", c.regex, err)
+			}
+			continue
+		}
+		if err != nil || (ret.Int != 0) != c.want {
+			t.Errorf("%q.matches(%q): got %v, %v", c.str, c.regex, ret, err)
+		}
+	}
+}
+
+func TestRegexErrors(t *testing.T) {
+	for _, c := range []struct {
+		regex, exception string
+	}{
+		{`(a`, "java/util/regex/PatternSyntaxException"},
+		{`a**`, "java/util/regex/PatternSyntaxException"},
+		{`(a)\1`, "java/lang/UnsupportedOperationException"},
+		{`a(?=b)`, "java/lang/UnsupportedOperationException"},
+		{`a++`, "java/lang/UnsupportedOperationException"},
+		{`(?>a)`, "java/lang/UnsupportedOperationException"},
+		{`[a-z&&[^e]]`, "java/lang/UnsupportedOperationException"},
+	} {
+		if _, err := compileRegex(c.regex, 0); !isJavaException(err, c.exception) {
+			t.Errorf("%q: got %v, want %s", c.regex, err, c.exception)
+		}
+	}
+
+	r, _ := compileRegex(`(a)(b)?`, 0)
+	for _, c := range []struct {
+		repl, exception string
+	}{
+		{`$3`, "java/lang/IndexOutOfBoundsException"},
+		{`${x}`, "java/lang/IllegalArgumentException"},
+		{`\`, "java/lang/IllegalArgumentException"},
+		{`$`, "java/lang/IllegalArgumentException"},
+	} {
+		if _, err := replaceRegex(r, "a", c.repl, true); !isJavaException(err, c.exception) {
+			t.Errorf("replacement %q: got %v, want %s", c.repl, err, c.exception)
+		}
+	}
+	// An unmatched group inserts nothing, and $12 means $1 then 2.
+	if s, err := replaceRegex(r, "xa", "<$2$12>", true); err != nil || s != "x<a2>" {
+		t.Errorf("replaceAll: got %q, %v", s, err)
+	}
+}
+
+func TestRegexTranslation(t *testing.T) {
+	for _, c := range []struct {
+		regex string
+		flags int32
+		input string
+		want  bool
+	}{
+		{"HELLO", regexCaseInsensitive, "hello", true},
+		{"a.b", 0, "a\nb", false},
+		{"a.b", regexDotall, "a\nb", true},
+		{"a b # comment\n c", regexComments, "abc", true},
+		{"a(?x) b", 0, "ab", true},
+		{"a+b", regexLiteral, "a+b", true},
+		{`\Qa+b\E+`, 0, "a+ba+b", false},
+		{`\Qa+b\E+`, 0, "a+bb", true},
+		{`é\x41\0101`, 0, "éAA", true},
+		{`😀`, 0, "\U0001F600", true},
+		{`a\R+b`, 0, "a\r\n\nb", true},
+		{`\h\v`, 0, "\t\n", true},
+		{`\pL\PL`, 0, "a1", true},
+
+		// Without DOTALL, . matches no line terminator.
+		{"a.b", 0, "a\rb", false},
+		{"a.b", 0, "a\u2028b", false},
+		{"a.b", regexUnixLines, "a\rb", true},
+		{"a(?s:.)b", 0, "a\rb", true},
+
+		// Without UNICODE_CASE, only ASCII letters match their other case.
+		{`(?i)é`, 0, "É", false},
+		{`(?iu)é`, 0, "É", true},
+		{`é`, regexCaseInsensitive | regexUnicodeCase, "É", true},
+		{`(?i)k`, 0, "\u212A", false},
+		{`[a-c][^x]`, regexCaseInsensitive, "BY", true},
+		{`[^a-c]`, regexCaseInsensitive, "B", false},
+		{`(?i)\Qab\E`, 0, "AB", true},
+		{`(?i:a)b`, 0, "Ab", true},
+		{`(?i:a)b`, 0, "AB", false},
+		{`(?<name>a)k`, regexCaseInsensitive, "AK", true},
+
+		// matches() must consume the line terminator a $ stops before.
+		{"abc$", 0, "abc", true},
+		{"abc$", 0, "abc\n", false},
+	} {
+		r, err := compileRegex(c.regex, c.flags)
+		if err != nil {
+			t.Errorf("%q: %v", c.regex, err)
+			continue
+		}
+		if got := r.matchFull(c.input) != nil; got != c.want {
+			t.Errorf("%q with flags %#x matches %q: got %v", c.regex, c.flags, c.input, got)
+		}
+	}
+	if got := quotePattern(`a\Eb`); got != `\Qa\E\\E\Qb\E` {
+		t.Errorf("quote: got %q", got)
+	}
+	if r, _ := compileRegex(quotePattern(`a\E.b`), 0); r.matchFull(`a\E.b`) == nil {
+		t.Error("quoted pattern does not match itself")
+	}
+	if got := quoteReplacement(`$1\x`); got != `\$1\\x` {
+		t.Errorf("quoteReplacement: got %q", got)
+	}
+}
+
+func TestRegexLineTerminators(t *testing.T) {
+	for _, c := range []struct {
+		regex, input, repl, want string
+	}{
+		{"c$", "abc\n", "X", "abX\n"},
+		{"c$", "abc\r\n", "X", "abX\r\n"},
+		{"c$", "abc\nd", "X", "abc\nd"},
+		{"$", "ab\n", "X", "abX\nX"},
+		{`(\w+)$`, "ab\u0085", "<$1>", "<ab>\u0085"},
+		{`c\Z`, "abc\n", "X", "abX\n"},
+		{"(?m)c$", "c\nc\rc\u2028c", "X", "X\nX\rX\u2028X"},
+		{"(?m)^c$", "c\nc", "X", "X\nX"},
+		{"(?d)c$", "c\r", "X", "c\r"},
+	} {
+		r, err := compileRegex(c.regex, 0)
+		if err != nil {
+			t.Errorf("%q: %v", c.regex, err)
+			continue
+		}
+		if got, err := replaceRegex(r, c.input, c.repl, true); err != nil || got != c.want {
+			t.Errorf("%q.replaceAll(%q, %q): got %q, %v, want %q", c.input, c.regex, c.repl, got, err, c.want)
+		}
+	}
+
+	// The terminator is no part of the match or of any group.
+	r, _ := compileRegex(`(b$)`, 0)
+	m := r.find("ab\r\n", 0)
+	if len(m) != 4 || m[0] != 1 || m[1] != 2 || m[2] != 1 || m[3] != 2 || r.groupCount() != 1 {
+		t.Errorf("find: got %v with %d groups", m, r.groupCount())
+	}
+}
+
+// regexMatcherClass returns the matcher of Pattern.compile(regex) over input.
+func regexMatcherClass() *classfile.ClassFile {
+	b := classfile.NewBuilder("app/Regex", "java/lang/Object")
+	compile := b.Methodref("java/util/regex/Pattern", "compile", "(Ljava/lang/String;)Ljava/util/regex/Pattern;")
+	matcher := b.Methodref("java/util/regex/Pattern", "matcher", "(Ljava/lang/CharSequence;)Ljava/util/regex/Matcher;")
+	b.AddMethod(classfile.AccStatic, "matcher", "(Ljava/lang/String;Ljava/lang/String;)Ljava/util/regex/Matcher;", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 2,
+		Code: []byte{
+			OpAload0, OpInvokestatic, byte(compile >> 8), byte(compile),
+			OpAload1, OpInvokevirtual, byte(matcher >> 8), byte(matcher),
+			OpAreturn,
+		},
+	})
+	return b.Build()
+}
+
+func TestMatcher(t *testing.T) {
+	cf := regexMatcherClass()
+	v := NewVM(mapClassLoader{"app/Regex": cf})
+	ret, err := v.executeMethod(cf, cf.FindMethodByName("matcher"), []Value{RefValue(`(?<key>\w+)=(\d*)`), RefValue("a=1é, b=, c=33")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := ret.Ref.(*JObject)
+	call := func(method, desc string, args ...Value) Value {
+		t.Helper()
+		ret, handled, err := v.handleRegexMethod(m, method, desc, args)
+		if !handled || err != nil {
+			t.Fatalf("%s%s: %v, %v", method, desc, handled, err)
+		}
+		return ret
+	}
+
+	var found []string
+	for call("find", "()Z").Int != 0 {
+		found = append(found, fmt.Sprintf("%s:%s@%d-%d",
+			v.valueToString(call("group", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("key"))),
+			v.valueToString(call("group", "(I)Ljava/lang/String;", IntValue(2))),
+			call("start", "()I").Int, call("end", "(I)I", IntValue(1)).Int))
+	}
+	if got, want := strings.Join(found, " "), "a:1@0-1 b:@6-7 c:33@10-11"; got != want {
+		t.Errorf("find: got %q, want %q", got, want)
+	}
+	if _, _, err := v.handleRegexMethod(m, "group", "()Ljava/lang/String;", nil); !isJavaException(err, "java/lang/IllegalStateException") {
+		t.Errorf("group after a failed find: got %v", err)
+	}
+
+	if call("find", "(I)Z", IntValue(7)).Int == 0 || v.valueToString(call("group", "()Ljava/lang/String;")) != "c=33" {
+		t.Error("find(7) does not find c=33")
+	}
+	if call("groupCount", "()I").Int != 2 {
+		t.Error("groupCount is not 2")
+	}
+	if call("lookingAt", "()Z").Int == 0 || call("matches", "()Z").Int != 0 {
+		t.Error("lookingAt or matches")
+	}
+
+	sb := RefValue(&JObject{ClassName: "java/lang/StringBuilder", Fields: map[string]Value{"_buffer": RefValue([]byte{})}})
+	call("reset", "()Ljava/util/regex/Matcher;")
+	for call("find", "()Z").Int != 0 {
+		call("appendReplacement", "(Ljava/lang/StringBuilder;Ljava/lang/String;)Ljava/util/regex/Matcher;", sb, RefValue("[$2]"))
+	}
+	call("appendTail", "(Ljava/lang/StringBuilder;)Ljava/lang/StringBuilder;", sb)
+	if got := v.valueToString(sb); got != "[1]é, [], [33]" {
+		t.Errorf("appendReplacement: got %q", got)
+	}
+	if got := v.valueToString(call("replaceFirst", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("${key}"))); got != "aé, b=, c=33" {
+		t.Errorf("replaceFirst: got %q", got)
+	}
+	if got := v.valueToString(RefValue(m)); !strings.HasPrefix(got, "java.util.regex.Matcher[pattern=") {
+		t.Errorf("toString: got %q", got)
+	}
+
+	ret, err = v.executeMethod(cf, cf.FindMethodByName("matcher"), []Value{RefValue("a*"), RefValue("aab")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = ret.Ref.(*JObject)
+	found = nil
+	for call("find", "()Z").Int != 0 {
+		found = append(found, fmt.Sprintf("%s@%d", v.valueToString(call("group", "()Ljava/lang/String;")), call("start", "()I").Int))
+	}
+	if got, want := strings.Join(found, " "), "aa@0 @2 @3"; got != want {
+		t.Errorf("find empty matches: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,99 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Values carry their type, but instructions read the field for the type
+// they expect, so an int popped where a long was pushed silently reads as
+// zero. With VM.CheckStackTypes set, the operands of each instruction are
+// checked against operandTypes before it runs, and a mismatch panics with
+// the method and pc of the instruction. This is a debugging aid for the
+// interpreter and for hand-written bytecode; it slows execution down.
+
+// operandTypes lists, for instructions with fixed operand types, the types
+// of the operands they pop, deepest first: I int, J long, F float,
+// D double, A reference or null, a reference, null or returnAddress.
+var operandTypes = [256]string{
+	// array loads
+	0x2e: "AI", 0x2f: "AI", 0x30: "AI", 0x31: "AI", 0x32: "AI", 0x33: "AI", 0x34: "AI", 0x35: "AI",
+	// stores
+	0x36: "I", 0x37: "J", 0x38: "F", 0x39: "D", 0x3a: "a",
+	0x3b: "I", 0x3c: "I", 0x3d: "I", 0x3e: "I",
+	0x3f: "J", 0x40: "J", 0x41: "J", 0x42: "J",
+	0x43: "F", 0x44: "F", 0x45: "F", 0x46: "F",
+	0x47: "D", 0x48: "D", 0x49: "D", 0x4a: "D",
+	0x4b: "a", 0x4c: "a", 0x4d: "a", 0x4e: "a",
+	// array stores
+	0x4f: "AII", 0x50: "AIJ", 0x51: "AIF", 0x52: "AID", 0x53: "AIA", 0x54: "AII", 0x55: "AII", 0x56: "AII",
+	// arithmetic
+	0x60: "II", 0x61: "JJ", 0x62: "FF", 0x63: "DD",
+	0x64: "II", 0x65: "JJ", 0x66: "FF", 0x67: "DD",
+	0x68: "II", 0x69: "JJ", 0x6a: "FF", 0x6b: "DD",
+	0x6c: "II", 0x6d: "JJ", 0x6e: "FF", 0x6f: "DD",
+	0x70: "II", 0x71: "JJ", 0x72: "FF", 0x73: "DD",
+	0x74: "I", 0x75: "J", 0x76: "F", 0x77: "D",
+	0x78: "II", 0x79: "JI", 0x7a: "II", 0x7b: "JI", 0x7c: "II", 0x7d: "JI",
+	0x7e: "II", 0x7f: "JJ", 0x80: "II", 0x81: "JJ", 0x82: "II", 0x83: "JJ",
+	// conversions
+	0x85: "I", 0x86: "I", 0x87: "I", 0x88: "J", 0x89: "J", 0x8a: "J",
+	0x8b: "F", 0x8c: "F", 0x8d: "F", 0x8e: "D", 0x8f: "D", 0x90: "D",
+	0x91: "I", 0x92: "I", 0x93: "I",
+	// comparisons and branches
+	0x94: "JJ", 0x95: "FF", 0x96: "FF", 0x97: "DD", 0x98: "DD",
+	0x99: "I", 0x9a: "I", 0x9b: "I", 0x9c: "I", 0x9d: "I", 0x9e: "I",
+	0x9f: "II", 0xa0: "II", 0xa1: "II", 0xa2: "II", 0xa3: "II", 0xa4: "II",
+	0xa5: "AA", 0xa6: "AA", 0xaa: "I", 0xab: "I",
+	0xc6: "A", 0xc7: "A",
+	// returns
+	0xac: "I", 0xad: "J", 0xae: "F", 0xaf: "D", 0xb0: "A",
+	// objects and arrays
+	0xbc: "I", 0xbd: "I", 0xbe: "A", 0xbf: "A", 0xc0: "A", 0xc1: "A", 0xc2: "A", 0xc3: "A",
+}
+
+// operandTypeNames names the operand type codes of operandTypes.
+var operandTypeNames = map[byte]string{
+	'I': "int", 'J': "long", 'F': "float", 'D': "double",
+	'A': "reference", 'a': "reference or returnAddress",
+}
+
+// matchesOperandType reports whether v has the operand type code t.
+func matchesOperandType(v Value, t byte) bool {
+	switch t {
+	case 'I':
+		return v.Type == TypeInt
+	case 'J':
+		return v.Type == TypeLong
+	case 'F':
+		return v.Type == TypeFloat
+	case 'D':
+		return v.Type == TypeDouble
+	case 'A':
+		return v.Type == TypeRef || v.Type == TypeNull
+	}
+	return v.Type == TypeRef || v.Type == TypeNull || v.Type == TypeReturnAddress
+}
+
+// checkOperandTypes panics if the operands of the instruction at pc do not
+// have the types it expects.
+func checkOperandTypes(cf *classfile.ClassFile, method *classfile.MethodInfo, frame *Frame, pc int, opcode byte) {
+	want := operandTypes[opcode]
+	if want == "" {
+		return
+	}
+	fail := func(problem string) {
+		className, _ := cf.ClassName()
+		panic(stackTypeError(fmt.Sprintf("operand stack type check: %s.%s%s pc %d: %s %s", className, method.Name, method.Descriptor, pc, OpcodeName(opcode), problem)))
+	}
+	if frame.SP < len(want) {
+		fail(fmt.Sprintf("needs %d operands, found %d", len(want), frame.SP))
+	}
+	operands := frame.OperandStack[frame.SP-len(want) : frame.SP]
+	for i := range want {
+		if !matchesOperandType(operands[i], want[i]) {
+			fail(fmt.Sprintf("operand %d: expects %s, found %s", i+1, operandTypeNames[want[i]], operands[i].Type))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestCheckStackTypes(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor string
+		code       []byte
+		want       string // in the panic, or "" if the types are right
+	}{
+		{"iadd", "()I", []byte{OpIconst1, OpIconst1, OpIadd, OpIreturn}, ""},
+		{"lshl takes an int shift", "()J", []byte{OpLconst1, OpIconst1, OpLshl, OpLreturn}, ""},
+		{"astore of a returnAddress", "()V", []byte{OpJsr, 0, 4, OpReturn, OpAstore1, OpRet, 1}, ""},
+		{"ladd of ints", "()J", []byte{OpIconst1, OpIconst1, OpLadd, OpLreturn}, "Types.m()J pc 2: ladd operand 1: expects long, found int"},
+		{"iaload of null index", "()I", []byte{OpAconstNull, OpAconstNull, OpIaload, OpIreturn}, "pc 2: iaload operand 2: expects int, found null"},
+		{"istore of a float", "()V", []byte{OpFconst0, OpIstore0, OpReturn}, "pc 1: istore_0 operand 1: expects int, found float"},
+		{"missing operand", "()I", []byte{OpIconst1, OpIadd, OpIreturn}, "pc 1: iadd needs 2 operands, found 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := classfile.NewBuilder("Types", "java/lang/Object")
+			b.AddMethod(classfile.AccStatic, "m", tt.descriptor, &classfile.CodeAttribute{MaxStack: 2, MaxLocals: 2, Code: tt.code})
+			cf := b.Build()
+			v := NewVM(mapClassLoader{"Types": cf})
+			v.CheckStackTypes = true
+
+			var got string
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						got = fmt.Sprint(r)
+					}
+				}()
+				if _, err := v.executeMethod(cf, cf.FindMethodByName("m"), nil); err != nil {
+					t.Fatal(err)
+				}
+			}()
+			if tt.want == "" {
+				if got != "" {
+					t.Errorf("unexpected panic: %s", got)
+				}
+				return
+			}
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("got panic %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
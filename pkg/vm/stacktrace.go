@@ -0,0 +1,206 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// stackEntry is a method activation on the VM call stack.
+type stackEntry struct {
+	class      *classfile.ClassFile
+	method     *classfile.MethodInfo
+	frame      *Frame
+	className  string
+	decoded    *decodedCode
+	pc         int  // pc of the instruction being executed
+	pushResult bool // whether the caller takes a result when this returns
+}
+
+// sourceLocation formats a code location like StackTraceElement.toString:
+// "Foo.main(Foo.java:12)", "Foo.main(Foo.java)" without line numbers and
+// "Foo.main(Unknown Source)" without a SourceFile attribute.
+func sourceLocation(cf *classfile.ClassFile, method *classfile.MethodInfo, pc int) string {
+	className, _ := cf.ClassName()
+	loc := "Unknown Source"
+	if cf.SourceFile != "" {
+		loc = cf.SourceFile
+		if method.Code != nil {
+			if line := method.Code.LineNumber(pc); line >= 0 {
+				loc = fmt.Sprintf("%s:%d", loc, line)
+			}
+		}
+	}
+	return fmt.Sprintf("%s.%s(%s)", strings.ReplaceAll(className, "/", "."), method.Name, loc)
+}
+
+// location returns the source location of the instruction the frame is
+// executing. PC has already moved past the opcode, and possibly its
+// operands, which still belong to the same source line.
+func (e stackEntry) location() string {
+	return sourceLocation(e.class, e.method, e.frame.PC-1)
+}
+
+// captureStackTrace returns the locations of the current call stack,
+// innermost first.
+func (vm *VM) captureStackTrace() []string {
+	return vm.stackTraceFrom(len(vm.callStack) - 1)
+}
+
+// stackTraceFrom returns the locations of the call stack from the
+// activation at top outwards.
+func (vm *VM) stackTraceFrom(top int) []string {
+	trace := make([]string, 0, top+1)
+	for i := top; i >= 0; i-- {
+		trace = append(trace, vm.callStack[i].location())
+	}
+	return trace
+}
+
+// fillInStackTrace records the current call stack as the trace of exc, as
+// Throwable's constructors do. The constructors and fillInStackTrace
+// methods of exc that are running are left out, so the trace starts where
+// exc was created.
+func (vm *VM) fillInStackTrace(exc *JObject) {
+	top := len(vm.callStack) - 1
+	for top >= 0 {
+		e := vm.callStack[top]
+		if e.method.Name != "<init>" && e.method.Name != "fillInStackTrace" ||
+			len(e.frame.LocalVars) == 0 || e.frame.LocalVars[0].Ref != exc {
+			break
+		}
+		top--
+	}
+	exc.Fields["_stackTrace"] = RefValue(vm.stackTraceFrom(top))
+}
+
+// recordStackTrace is called as exc propagates out of each activation.
+// When exc is first thrown, it attaches the current call stack unless a
+// trace was recorded when exc was created. Rethrowing keeps the original
+// trace as in Java.
+func (vm *VM) recordStackTrace(exc *JavaException) {
+	if _, ok := exc.Object.Fields["_thrown"]; ok {
+		return
+	}
+	exc.Object.Fields["_thrown"] = IntValue(1)
+	if _, ok := exc.Object.Fields["_stackTrace"]; !ok {
+		exc.Object.Fields["_stackTrace"] = RefValue(vm.captureStackTrace())
+	}
+	if vm.metrics != nil {
+		vm.metrics.countException(exc.Object.ClassName)
+	}
+	if vm.Trace != nil {
+		vm.Trace.throw(vm.traceThread(), exc.Object.ClassName)
+	}
+}
+
+// StackTrace returns the locations the exception was created or thrown
+// at, innermost first, or nil if it never passed through a Java frame.
+func (e *JavaException) StackTrace() []string {
+	return stackTraceOf(e.Object)
+}
+
+// stackTraceOf returns the recorded trace of the Throwable exc.
+func stackTraceOf(exc *JObject) []string {
+	trace, _ := exc.Fields["_stackTrace"].Ref.([]string)
+	return trace
+}
+
+// causeOf returns the cause of the Throwable exc, or nil if it has none.
+// The JDK's Throwable marks an unset cause by pointing it at itself.
+func causeOf(exc *JObject) *JObject {
+	if cause, ok := exc.Fields["cause"].Ref.(*JObject); ok && cause != exc {
+		return cause
+	}
+	return nil
+}
+
+// suppressedOf returns the exceptions suppressed by the Throwable exc, in
+// the order they were added.
+func suppressedOf(exc *JObject) []*JObject {
+	suppressed, _ := exc.Fields["_suppressed"].Ref.([]*JObject)
+	return suppressed
+}
+
+// PrintStackTrace writes the exception, its trace, its suppressed
+// exceptions and its causes in the format of an uncaught exception
+// reported by the java launcher.
+func (e *JavaException) PrintStackTrace(w io.Writer) {
+	e.printStackTrace(w, describeThrowable)
+}
+
+// ReportUncaught writes err, an error returned by Execute, as the java
+// launcher reports an exception thrown out of main, and reports whether
+// err was one. Unlike PrintStackTrace, it describes exceptions with their
+// toString method, which their class may override.
+func (vm *VM) ReportUncaught(w io.Writer, err error) bool {
+	var exc *JavaException
+	if !errors.As(err, &exc) {
+		return false
+	}
+	exc.printStackTrace(w, func(obj *JObject) string {
+		return vm.valueToString(RefValue(obj))
+	})
+	return true
+}
+
+// describeThrowable formats a Throwable as the default toString does.
+func describeThrowable(exc *JObject) string {
+	desc := strings.ReplaceAll(exc.ClassName, "/", ".")
+	if msg, ok := extractGoString(exc.Fields["detailMessage"]); ok {
+		desc += ": " + msg
+	}
+	return desc
+}
+
+// printStackTrace writes the exception, described by describe, with its
+// suppressed exceptions and causes as Throwable.printStackTrace does.
+func (e *JavaException) printStackTrace(w io.Writer, describe func(*JObject) string) {
+	fmt.Fprintf(w, "Exception in thread \"main\" %s\n", describe(e.Object))
+	trace := e.StackTrace()
+	for _, loc := range trace {
+		fmt.Fprintf(w, "\tat %s\n", loc)
+	}
+	seen := map[*JObject]bool{e.Object: true}
+	for _, s := range suppressedOf(e.Object) {
+		printEnclosedTrace(w, s, trace, "Suppressed: ", "\t", seen, describe)
+	}
+	if cause := causeOf(e.Object); cause != nil {
+		printEnclosedTrace(w, cause, trace, "Caused by: ", "", seen, describe)
+	}
+}
+
+// printEnclosedTrace writes exc, a suppressed exception or cause of the
+// exception whose trace is enclosing, and in turn the exceptions it
+// encloses. The frames its trace shares with enclosing are abbreviated to
+// "... n more". Suppressed exceptions are indented one more tab than the
+// exception that suppressed them.
+func printEnclosedTrace(w io.Writer, exc *JObject, enclosing []string, caption, prefix string, seen map[*JObject]bool, describe func(*JObject) string) {
+	if seen[exc] {
+		fmt.Fprintf(w, "%s%s[CIRCULAR REFERENCE: %s]\n", prefix, caption, describe(exc))
+		return
+	}
+	seen[exc] = true
+	trace := stackTraceOf(exc)
+	m, n := len(trace)-1, len(enclosing)-1
+	for m >= 0 && n >= 0 && trace[m] == enclosing[n] {
+		m--
+		n--
+	}
+	fmt.Fprintf(w, "%s%s%s\n", prefix, caption, describe(exc))
+	for _, loc := range trace[:m+1] {
+		fmt.Fprintf(w, "%s\tat %s\n", prefix, loc)
+	}
+	if common := len(trace) - 1 - m; common > 0 {
+		fmt.Fprintf(w, "%s\t... %d more\n", prefix, common)
+	}
+	for _, s := range suppressedOf(exc) {
+		printEnclosedTrace(w, s, trace, "Suppressed: ", prefix+"\t", seen, describe)
+	}
+	if cause := causeOf(exc); cause != nil {
+		printEnclosedTrace(w, cause, trace, "Caused by: ", prefix, seen, describe)
+	}
+}
@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestStackTraceLocations(t *testing.T) {
+	b := classfile.NewBuilder("app/Gen", "java/lang/Object")
+	fail := b.Methodref("app/Gen", "fail", "()V")
+	b.AddMethod(classfile.AccStatic, "main", "()V", &classfile.CodeAttribute{
+		Code:        []byte{0xb8, byte(fail >> 8), byte(fail), 0xb1}, // invokestatic fail; return
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 3}, {StartPC: 3, Line: 4}},
+	})
+	b.AddMethod(classfile.AccStatic, "fail", "()V", &classfile.CodeAttribute{
+		MaxStack:    1,
+		Code:        []byte{0x00, 0x01, 0xbf}, // nop; aconst_null; athrow
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 7}, {StartPC: 1, Line: 8}},
+	})
+	b.AddMethod(classfile.AccStatic, "broken", "()V", &classfile.CodeAttribute{Code: []byte{0xff}})
+	cf := b.Build()
+	cf.SourceFile = "Gen.java"
+	v := NewVM(mapClassLoader{"app/Gen": cf})
+	v.Stdout = io.Discard
+
+	_, err := v.executeMethod(cf, cf.FindMethod("main", "()V"), nil)
+	exc, ok := err.(*JavaException)
+	if !ok {
+		t.Fatalf("expected JavaException, got %v", err)
+	}
+	want := []string{"app.Gen.fail(Gen.java:8)", "app.Gen.main(Gen.java:3)"}
+	if got := exc.StackTrace(); !reflect.DeepEqual(got, want) {
+		t.Errorf("stack trace: got %q, want %q", got, want)
+	}
+	var out bytes.Buffer
+	exc.PrintStackTrace(&out)
+	if got := out.String(); got != "Exception in thread \"main\" java.lang.NullPointerException: Cannot throw exception because \"null\" is null\n\tat app.Gen.fail(Gen.java:8)\n\tat app.Gen.main(Gen.java:3)\n" {
+		t.Errorf("PrintStackTrace: got %q", got)
+	}
+	if len(v.callStack) != 0 {
+		t.Errorf("call stack not unwound: %d entries left", len(v.callStack))
+	}
+
+	_, err = v.executeMethod(cf, cf.FindMethod("broken", "()V"), nil)
+	if err == nil || !strings.Contains(err.Error(), "at app.Gen.broken(Gen.java) (PC=0)") {
+		t.Errorf("interpreter error: got %v", err)
+	}
+	cf.SourceFile = ""
+	if loc := sourceLocation(cf, cf.FindMethod("fail", "()V"), 2); loc != "app.Gen.fail(Unknown Source)" {
+		t.Errorf("without SourceFile: got %s", loc)
+	}
+}
+
+func TestReportUncaught(t *testing.T) {
+	classes := throwableClasses()
+	cf := classes["Main"]
+	v := NewVM(classes)
+	_, err := v.executeMethod(cf, cf.FindMethodByName("failCustom"), nil)
+
+	// The description comes from the overridden getMessage, and the
+	// exception may arrive wrapped.
+	var out bytes.Buffer
+	if !v.ReportUncaught(&out, fmt.Errorf("running: %w", err)) {
+		t.Fatalf("not reported as an exception: %v", err)
+	}
+	if got, want := out.String(), "Exception in thread \"main\" Custom: custom\n\tat Main.failCustom(Unknown Source)\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if v.ReportUncaught(&out, errors.New("main method not found")) || out.Len() != 0 {
+		t.Errorf("other errors: reported %q", out.String())
+	}
+}
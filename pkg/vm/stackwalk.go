@@ -0,0 +1,210 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Thread.getStackTrace and StackWalker expose the interpreter's call
+// stack. The VM creates the StackTraceElement and StackWalker.StackFrame
+// objects that describe its activations, and the StackWalker objects that
+// walk them, and implements their methods natively, so they work without
+// the JDK's classes. Only walk needs the JDK, for the Stream it passes to
+// its function.
+//
+// StackWalker options are not checked: every walker retains class
+// references, and none shows reflection or hidden frames, which the VM
+// does not have.
+
+const (
+	stackTraceElementClass = "java/lang/StackTraceElement"
+	stackWalkerClass       = "java/lang/StackWalker"
+	stackFrameClass        = "java/lang/StackFrameInfo" // the JDK's StackWalker.StackFrame implementation
+)
+
+// stackTraceElement creates a StackTraceElement. fileName is "" and
+// lineNumber negative when they are unknown.
+func stackTraceElement(className, methodName, fileName string, lineNumber int) *JObject {
+	file := NullValue()
+	if fileName != "" {
+		file = RefValue(fileName)
+	}
+	return &JObject{
+		ClassName: stackTraceElementClass,
+		Fields: map[string]Value{
+			"declaringClass": RefValue(strings.ReplaceAll(className, "/", ".")),
+			"methodName":     RefValue(methodName),
+			"fileName":       file,
+			"lineNumber":     IntValue(int32(lineNumber)),
+		},
+	}
+}
+
+// element returns the StackTraceElement describing the activation.
+func (e stackEntry) element() *JObject {
+	line := -1
+	if e.method.Code != nil {
+		line = e.method.Code.LineNumber(e.pc)
+	}
+	return stackTraceElement(e.className, e.method.Name, e.class.SourceFile, line)
+}
+
+// threadStackTrace returns the StackTraceElement[] that
+// Thread.getStackTrace returns for the current thread: the call stack,
+// innermost first, below a frame for getStackTrace itself.
+func (vm *VM) threadStackTrace() Value {
+	arr := NewArray("L"+stackTraceElementClass+";", len(vm.callStack)+1)
+	arr.Elements[0] = RefValue(stackTraceElement("java/lang/Thread", "getStackTrace", "Thread.java", -1))
+	for i := range vm.callStack {
+		arr.Elements[len(vm.callStack)-i] = RefValue(vm.callStack[i].element())
+	}
+	return RefValue(arr)
+}
+
+// stackFrames returns StackWalker.StackFrame objects for the call stack,
+// innermost first.
+func (vm *VM) stackFrames() []Value {
+	frames := make([]Value, len(vm.callStack))
+	for i := range vm.callStack {
+		e := vm.callStack[i]
+		frames[len(vm.callStack)-1-i] = RefValue(&JObject{
+			ClassName: stackFrameClass,
+			Fields: map[string]Value{
+				"_class":      RefValue(e.className),
+				"_descriptor": RefValue(e.method.Descriptor),
+				"_bci":        IntValue(int32(e.pc)),
+				"_element":    RefValue(e.element()),
+			},
+		})
+	}
+	return frames
+}
+
+// elementString formats a StackTraceElement as its toString does for
+// classes of the application class loader.
+func elementString(elem *JObject) string {
+	className, _ := extractGoString(elem.Fields["declaringClass"])
+	methodName, _ := extractGoString(elem.Fields["methodName"])
+	fileName, hasFile := extractGoString(elem.Fields["fileName"])
+	line := elem.Fields["lineNumber"].Int
+	var loc string
+	switch {
+	case line == -2:
+		loc = "Native Method"
+	case !hasFile:
+		loc = "Unknown Source"
+	case line >= 0:
+		loc = fmt.Sprintf("%s:%d", fileName, line)
+	default:
+		loc = fileName
+	}
+	return fmt.Sprintf("%s.%s(%s)", className, methodName, loc)
+}
+
+// handleStackMethod handles the methods of StackTraceElement, StackWalker
+// and StackWalker.StackFrame objects, reporting false for other objects
+// and methods.
+func (vm *VM) handleStackMethod(obj *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch obj.ClassName {
+	case stackTraceElementClass:
+		switch methodName + ":" + descriptor {
+		case "getClassName:()Ljava/lang/String;":
+			return obj.Fields["declaringClass"], true, nil
+		case "getMethodName:()Ljava/lang/String;":
+			return obj.Fields["methodName"], true, nil
+		case "getFileName:()Ljava/lang/String;":
+			return obj.Fields["fileName"], true, nil
+		case "getLineNumber:()I":
+			return obj.Fields["lineNumber"], true, nil
+		case "isNativeMethod:()Z":
+			if obj.Fields["lineNumber"].Int == -2 {
+				return IntValue(1), true, nil
+			}
+			return IntValue(0), true, nil
+		case "getModuleName:()Ljava/lang/String;", "getModuleVersion:()Ljava/lang/String;", "getClassLoaderName:()Ljava/lang/String;":
+			return NullValue(), true, nil
+		case "toString:()Ljava/lang/String;":
+			return RefValue(elementString(obj)), true, nil
+		}
+	case stackFrameClass:
+		elem, _ := obj.Fields["_element"].Ref.(*JObject)
+		switch methodName + ":" + descriptor {
+		case "getDeclaringClass:()Ljava/lang/Class;":
+			className, _ := extractGoString(obj.Fields["_class"])
+			return vm.classObject(className), true, nil
+		case "getDescriptor:()Ljava/lang/String;":
+			return obj.Fields["_descriptor"], true, nil
+		case "getByteCodeIndex:()I":
+			return obj.Fields["_bci"], true, nil
+		case "toStackTraceElement:()Ljava/lang/StackTraceElement;":
+			return RefValue(elem), true, nil
+		}
+		return vm.handleStackMethod(elem, methodName, descriptor, args)
+	case stackWalkerClass:
+		switch methodName + ":" + descriptor {
+		case "forEach:(Ljava/util/function/Consumer;)V":
+			for _, frame := range vm.stackFrames() {
+				if _, err := vm.callFunction(args[0], "accept", "(Ljava/lang/Object;)V", frame); err != nil {
+					return Value{}, true, err
+				}
+			}
+			return Value{}, true, nil
+		case "walk:(Ljava/util/function/Function;)Ljava/lang/Object;":
+			ret, err := vm.walkStack(args[0])
+			return ret, true, err
+		case "getCallerClass:()Ljava/lang/Class;":
+			// The caller of the method that called getCallerClass
+			if len(vm.callStack) < 2 {
+				return Value{}, true, NewJavaExceptionMessage("java/lang/IllegalCallerException", "no caller frame")
+			}
+			return vm.classObject(vm.callStack[len(vm.callStack)-2].className), true, nil
+		}
+	}
+	return Value{}, false, nil
+}
+
+// handleStackWalkerStatic handles the StackWalker.getInstance factories,
+// reporting false for other methods.
+func (vm *VM) handleStackWalkerStatic(methodName string) (Value, bool) {
+	if methodName != "getInstance" {
+		return Value{}, false
+	}
+	return RefValue(&JObject{ClassName: stackWalkerClass, Fields: make(map[string]Value)}), true
+}
+
+// walkStack implements StackWalker.walk: it applies fn to a Stream of the
+// frames of the call stack, which it creates with Arrays.stream.
+func (vm *VM) walkStack(fn Value) (Value, error) {
+	frames := vm.stackFrames()
+	arr := NewArray("Ljava/lang/StackWalker$StackFrame;", len(frames))
+	copy(arr.Elements, frames)
+	if err := vm.ensureInitialized("java/util/Arrays"); err != nil {
+		return Value{}, err
+	}
+	cf, method, err := vm.resolveMethod("java/util/Arrays", "stream", "([Ljava/lang/Object;)Ljava/util/stream/Stream;")
+	if err != nil {
+		return Value{}, fmt.Errorf("StackWalker.walk: %w", err)
+	}
+	stream, err := vm.executeMethod(cf, method, []Value{RefValue(arr)})
+	if err != nil {
+		return Value{}, err
+	}
+	return vm.callFunction(fn, "apply", "(Ljava/lang/Object;)Ljava/lang/Object;", stream)
+}
+
+// callFunction calls the single abstract method methodName of the
+// functional interface object fn, which may be a lambda.
+func (vm *VM) callFunction(fn Value, methodName, descriptor string, args ...Value) (Value, error) {
+	obj, ok := fn.Ref.(*JObject)
+	if !ok || fn.Type != TypeRef {
+		return Value{}, NewJavaException("java/lang/NullPointerException")
+	}
+	if obj.LambdaTarget != nil {
+		return vm.invokeLambda(obj.LambdaTarget, descriptor, args)
+	}
+	cf, method, err := vm.resolveMethod(obj.ClassName, methodName, descriptor)
+	if err != nil {
+		return Value{}, err
+	}
+	return vm.executeMethod(cf, method, append([]Value{fn}, args...))
+}
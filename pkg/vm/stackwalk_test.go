@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestThreadGetStackTrace(t *testing.T) {
+	b := classfile.NewBuilder("app/Walk", "java/lang/Object")
+	currentThread := b.Methodref("java/lang/Thread", "currentThread", "()Ljava/lang/Thread;")
+	getStackTrace := b.Methodref("java/lang/Thread", "getStackTrace", "()[Ljava/lang/StackTraceElement;")
+	trace := b.Methodref("app/Walk", "trace", "()[Ljava/lang/StackTraceElement;")
+	b.AddMethod(classfile.AccStatic, "trace", "()[Ljava/lang/StackTraceElement;", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code: []byte{
+			OpInvokestatic, byte(currentThread >> 8), byte(currentThread),
+			OpInvokevirtual, byte(getStackTrace >> 8), byte(getStackTrace), OpAreturn,
+		},
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 5}},
+	})
+	b.AddMethod(classfile.AccStatic, "main", "()[Ljava/lang/StackTraceElement;", &classfile.CodeAttribute{
+		MaxStack:    1,
+		Code:        []byte{OpNop, OpInvokestatic, byte(trace >> 8), byte(trace), OpAreturn},
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 9}, {StartPC: 1, Line: 10}},
+	})
+	cf := b.Build()
+	cf.SourceFile = "Walk.java"
+	v := NewVM(mapClassLoader{"app/Walk": cf})
+
+	ret, err := v.executeMethod(cf, cf.FindMethodByName("main"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arr, ok := ret.Ref.(*JArray)
+	if !ok {
+		t.Fatalf("got %v, want StackTraceElement[]", ret)
+	}
+	want := []string{
+		"java.lang.Thread.getStackTrace(Thread.java)",
+		"app.Walk.trace(Walk.java:5)",
+		"app.Walk.main(Walk.java:10)",
+	}
+	if len(arr.Elements) != len(want) {
+		t.Fatalf("got %d elements, want %d", len(arr.Elements), len(want))
+	}
+	for i, w := range want {
+		if got := v.valueToString(arr.Elements[i]); got != w {
+			t.Errorf("element %d: got %s, want %s", i, got, w)
+		}
+	}
+
+	elem := arr.Elements[2].Ref.(*JObject)
+	for _, tt := range []struct {
+		method, descriptor string
+		want               Value
+	}{
+		{"getClassName", "()Ljava/lang/String;", RefValue("app.Walk")},
+		{"getMethodName", "()Ljava/lang/String;", RefValue("main")},
+		{"getFileName", "()Ljava/lang/String;", RefValue("Walk.java")},
+		{"getLineNumber", "()I", IntValue(10)},
+		{"isNativeMethod", "()Z", IntValue(0)},
+	} {
+		got, handled, err := v.handleStackMethod(elem, tt.method, tt.descriptor, nil)
+		if !handled || err != nil || got != tt.want {
+			t.Errorf("%s: got %v (handled %v, err %v), want %v", tt.method, got, handled, err, tt.want)
+		}
+	}
+
+	if s := elementString(stackTraceElement("app/Walk", "run", "", 3)); s != "app.Walk.run(Unknown Source)" {
+		t.Errorf("without file name: got %s", s)
+	}
+	if s := elementString(stackTraceElement("app/Walk", "run", "", -2)); s != "app.Walk.run(Native Method)" {
+		t.Errorf("native method: got %s", s)
+	}
+}
+
+func TestStackWalker(t *testing.T) {
+	b := classfile.NewBuilder("app/Walker", "java/lang/Object")
+	getInstance := b.Methodref("java/lang/StackWalker", "getInstance", "()Ljava/lang/StackWalker;")
+	getCallerClass := b.Methodref("java/lang/StackWalker", "getCallerClass", "()Ljava/lang/Class;")
+	forEach := b.Methodref("java/lang/StackWalker", "forEach", "(Ljava/util/function/Consumer;)V")
+	caller := b.Methodref("app/Walker", "caller", "()Ljava/lang/Class;")
+	visit := b.Methodref("app/Walker", "visit", "(Ljava/util/function/Consumer;)V")
+	b.AddMethod(classfile.AccStatic, "caller", "()Ljava/lang/Class;", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code: []byte{
+			OpInvokestatic, byte(getInstance >> 8), byte(getInstance),
+			OpInvokevirtual, byte(getCallerClass >> 8), byte(getCallerClass), OpAreturn,
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "callCaller", "()Ljava/lang/Class;", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{OpInvokestatic, byte(caller >> 8), byte(caller), OpAreturn},
+	})
+	b.AddMethod(classfile.AccStatic, "visit", "(Ljava/util/function/Consumer;)V", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpInvokestatic, byte(getInstance >> 8), byte(getInstance), OpAload0,
+			OpInvokevirtual, byte(forEach >> 8), byte(forEach), OpReturn,
+		},
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 20}},
+	})
+	b.AddMethod(classfile.AccStatic, "main", "(Ljava/util/function/Consumer;)V", &classfile.CodeAttribute{
+		MaxStack:    1,
+		MaxLocals:   1,
+		Code:        []byte{OpAload0, OpInvokestatic, byte(visit >> 8), byte(visit), OpReturn},
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 30}},
+	})
+	cf := b.Build()
+	cf.SourceFile = "Walker.java"
+
+	// Collect is a Consumer that counts the frames it accepts and keeps
+	// the last one.
+	cb := classfile.NewBuilder("app/Collect", "java/lang/Object")
+	cb.AddInterface("java/util/function/Consumer")
+	cb.AddField(0, "count", "I", nil)
+	cb.AddField(0, "last", "Ljava/lang/Object;", nil)
+	count := cb.Fieldref("app/Collect", "count", "I")
+	last := cb.Fieldref("app/Collect", "last", "Ljava/lang/Object;")
+	cb.AddMethod(0, "accept", "(Ljava/lang/Object;)V", &classfile.CodeAttribute{
+		MaxStack:  3,
+		MaxLocals: 2,
+		Code: []byte{
+			OpAload0, OpAload1, OpPutfield, byte(last >> 8), byte(last),
+			OpAload0, OpDup, OpGetfield, byte(count >> 8), byte(count), OpIconst1, OpIadd,
+			OpPutfield, byte(count >> 8), byte(count), OpReturn,
+		},
+	})
+	collectClass := cb.Build()
+	v := NewVM(mapClassLoader{"app/Walker": cf, "app/Collect": collectClass})
+
+	got, err := v.executeMethod(cf, cf.FindMethodByName("callCaller"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Ref != v.classObject("app/Walker").Ref {
+		t.Errorf("getCallerClass: got %v, want app.Walker", got)
+	}
+	_, err = v.executeMethod(cf, cf.FindMethodByName("caller"), nil)
+	if !isJavaException(err, "java/lang/IllegalCallerException") {
+		t.Errorf("getCallerClass without caller: got %v, want IllegalCallerException", err)
+	}
+
+	collect := &JObject{ClassName: "app/Collect", Fields: map[string]Value{"count": IntValue(0), "last": NullValue()}}
+	if _, err := v.executeMethod(cf, cf.FindMethodByName("main"), []Value{RefValue(collect)}); err != nil {
+		t.Fatal(err)
+	}
+	if n := collect.Fields["count"].Int; n != 2 {
+		t.Errorf("forEach visited %d frames, want 2", n)
+	}
+	frame, ok := collect.Fields["last"].Ref.(*JObject)
+	if !ok {
+		t.Fatalf("last frame: got %v", collect.Fields["last"])
+	}
+	if s := v.valueToString(RefValue(frame)); s != "app.Walker.main(Walker.java:30)" {
+		t.Errorf("last frame: got %s", s)
+	}
+	for _, tt := range []struct {
+		method, descriptor string
+		want               Value
+	}{
+		{"getMethodName", "()Ljava/lang/String;", RefValue("main")},
+		{"getDescriptor", "()Ljava/lang/String;", RefValue("(Ljava/util/function/Consumer;)V")},
+		{"getByteCodeIndex", "()I", IntValue(1)},
+		{"getDeclaringClass", "()Ljava/lang/Class;", v.classObject("app/Walker")},
+	} {
+		got, handled, err := v.handleStackMethod(frame, tt.method, tt.descriptor, nil)
+		if !handled || err != nil || got != tt.want {
+			t.Errorf("%s: got %v (handled %v, err %v), want %v", tt.method, got, handled, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package vm
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// System.in reads VM.Stdin. It is a native stream like the in-memory ones,
+// recorded in _stream as stdinStream, which is not a class name, so that
+// only the VM's own object reads the process input. Reads go through a
+// bufio.Reader created on first use, so Stdin must be set before the
+// program reads it.
+//
+// InputStreamReader and BufferedReader are implemented natively too, on
+// top of any InputStream or Reader, so that programs reading lines from
+// System.in run without the JDK's charset machinery:
+//
+//	InputStreamReader: _in (the InputStream), _latin1 (bool), _low (a
+//	                   pending low surrogate, 0 if none)
+//	BufferedReader:    _in (the Reader), _skipLF (after a '\r')
+const stdinStream = "<stdin>"
+
+// stdinObject returns the object of System.in.
+func (vm *VM) stdinObject() Value {
+	if vm.stdinObj == nil {
+		vm.stdinObj = &JObject{
+			ClassName: "java/io/BufferedInputStream",
+			Fields:    map[string]Value{"_stream": RefValue(stdinStream)},
+		}
+	}
+	return RefValue(vm.stdinObj)
+}
+
+// stdinReader returns the buffered reader of VM.Stdin.
+func (vm *VM) stdinReader() *bufio.Reader {
+	if vm.stdin == nil {
+		in := vm.Stdin
+		if in == nil {
+			in = eofReader{}
+		}
+		vm.stdin = bufio.NewReader(in)
+	}
+	return vm.stdin
+}
+
+// eofReader is an empty input.
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+
+// stdinError converts an error reading VM.Stdin to an IOException.
+func stdinError(err error) error {
+	return NewJavaExceptionMessage("java/io/IOException", err.Error())
+}
+
+func (vm *VM) handleStdin(methodName, descriptor string, args []Value) (Value, error) {
+	r := vm.stdinReader()
+	switch methodName + ":" + descriptor {
+	case "read:()I":
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return IntValue(-1), nil
+		}
+		if err != nil {
+			return Value{}, stdinError(err)
+		}
+		return IntValue(int32(c)), nil
+	case "read:([BII)I", "readNBytes:([BII)I":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		b := make([]byte, args[2].Int)
+		if len(b) == 0 {
+			return IntValue(0), nil
+		}
+		var n int
+		if methodName == "read" {
+			// Return what one read delivers, as for a terminal.
+			n, err = r.Read(b)
+		} else {
+			n, err = io.ReadFull(r, b)
+		}
+		if n == 0 && err == io.EOF {
+			if methodName == "readNBytes" {
+				return IntValue(0), nil
+			}
+			return IntValue(-1), nil
+		}
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return Value{}, stdinError(err)
+		}
+		for i := 0; i < n; i++ {
+			arr.Set(int(args[1].Int)+i, IntValue(int32(int8(b[i]))))
+		}
+		return IntValue(int32(n)), nil
+	case "read:([B)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		n := int32(args[0].Ref.(*JArray).Len())
+		return vm.handleStdin("read", "([BII)I", []Value{args[0], IntValue(0), IntValue(n)})
+	case "readAllBytes:()[B":
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return Value{}, stdinError(err)
+		}
+		return RefValue(goBytesToArray(b)), nil
+	case "readNBytes:(I)[B":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "len < 0")
+		}
+		b := make([]byte, args[0].Int)
+		n, err := io.ReadFull(r, b)
+		if err != nil && err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return Value{}, stdinError(err)
+		}
+		return RefValue(goBytesToArray(b[:n])), nil
+	case "skip:(J)J":
+		if args[0].Long <= 0 {
+			return LongValue(0), nil
+		}
+		n, err := io.CopyN(io.Discard, r, args[0].Long)
+		if err != nil && err != io.EOF {
+			return Value{}, stdinError(err)
+		}
+		return LongValue(n), nil
+	case "available:()I":
+		return IntValue(int32(r.Buffered())), nil
+	case "markSupported:()Z":
+		return IntValue(0), nil
+	case "close:()V":
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("System.in: unsupported method %s:%s", methodName, descriptor)
+}
+
+// streamAvailable returns what available() reports for an arbitrary
+// InputStream value, or 0 if it cannot tell.
+func (vm *VM) streamAvailable(in Value) int32 {
+	if cls := nativeStreamClassOf(in, ""); cls != "" {
+		if v, err := vm.handleNativeStream(cls, in, "available", "()I", nil); err == nil {
+			return v.Int
+		}
+	}
+	return 0
+}
+
+// readFromReader reads one char from an arbitrary Reader value, returning
+// -1 at end of stream.
+func (vm *VM) readFromReader(in Value) (int32, error) {
+	if in.Type == TypeNull || in.Ref == nil {
+		return 0, NewJavaException("java/lang/NullPointerException")
+	}
+	if cls := nativeStreamClassOf(in, ""); cls != "" {
+		v, err := vm.handleNativeStream(cls, in, "read", "()I", nil)
+		return v.Int, err
+	}
+	obj, ok := in.Ref.(*JObject)
+	if !ok {
+		return 0, fmt.Errorf("reader: cannot read from %T", in.Ref)
+	}
+	cf, method, err := vm.resolveMethod(obj.ClassName, "read", "()I")
+	if err != nil {
+		return 0, err
+	}
+	v, err := vm.executeMethod(cf, method, []Value{in})
+	return v.Int, err
+}
+
+// readerReady reports whether an arbitrary Reader value can be read
+// without blocking, as far as the VM can tell.
+func (vm *VM) readerReady(in Value) bool {
+	if cls := nativeStreamClassOf(in, ""); cls != "" {
+		v, err := vm.handleNativeStream(cls, in, "ready", "()Z", nil)
+		return err == nil && v.Int != 0
+	}
+	return false
+}
+
+// readChars implements read(char[], int, int) for a reader whose read()
+// is readChar and ready() is ready: it blocks for the first char only.
+func readChars(args []Value, readChar func() (int32, error), ready func() bool) (Value, error) {
+	arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+	if err != nil {
+		return Value{}, err
+	}
+	off, n := int(args[1].Int), int(args[2].Int)
+	if n == 0 {
+		return IntValue(0), nil
+	}
+	i := 0
+	for i < n && (i == 0 || ready()) {
+		c, err := readChar()
+		if err != nil {
+			return Value{}, err
+		}
+		if c < 0 {
+			break
+		}
+		arr.Set(off+i, IntValue(c))
+		i++
+	}
+	if i == 0 {
+		return IntValue(-1), nil
+	}
+	return IntValue(int32(i)), nil
+}
+
+// readIntoCharBuffer implements Readable.read(CharBuffer) with the
+// reader's read(char[], int, int), putting what it reads into the buffer.
+func (vm *VM) readIntoCharBuffer(buffer Value, read func(args []Value) (Value, error)) (Value, error) {
+	obj, ok := buffer.Ref.(*JObject)
+	if !ok {
+		return Value{}, NewJavaException("java/lang/NullPointerException")
+	}
+	cf, remaining, err := vm.resolveMethod(obj.ClassName, "remaining", "()I")
+	if err != nil {
+		return Value{}, err
+	}
+	n, err := vm.executeMethod(cf, remaining, []Value{buffer})
+	if err != nil {
+		return Value{}, err
+	}
+	chars := NewArray("C", int(n.Int))
+	count, err := read([]Value{RefValue(chars), IntValue(0), n})
+	if err != nil || count.Int <= 0 {
+		return count, err
+	}
+	cf, put, err := vm.resolveMethod(obj.ClassName, "put", "([CII)Ljava/nio/CharBuffer;")
+	if err != nil {
+		return Value{}, err
+	}
+	if _, err := vm.executeMethod(cf, put, []Value{buffer, RefValue(chars), IntValue(0), count}); err != nil {
+		return Value{}, err
+	}
+	return count, nil
+}
+
+func (vm *VM) handleInputStreamReader(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	in := obj.Fields["_in"]
+	readChar := func() (int32, error) {
+		if low := obj.Fields["_low"].Int; low != 0 {
+			obj.Fields["_low"] = IntValue(0)
+			return low, nil
+		}
+		c, err := vm.readFromStream(in)
+		if err != nil || c < 0 || c < utf8.RuneSelf || obj.Fields["_latin1"].Int != 0 {
+			return c, err
+		}
+		// Collect the rest of a UTF-8 sequence; malformed input decodes
+		// to U+FFFD.
+		b := []byte{byte(c)}
+		for len(b) < utf8.UTFMax && !utf8.FullRune(b) {
+			c, err := vm.readFromStream(in)
+			if err != nil {
+				return 0, err
+			}
+			if c < 0 {
+				break
+			}
+			b = append(b, byte(c))
+		}
+		r, _ := utf8.DecodeRune(b)
+		if r1, r2 := utf16.EncodeRune(r); r1 != utf8.RuneError {
+			obj.Fields["_low"] = IntValue(r2)
+			return r1, nil
+		}
+		return int32(r), nil
+	}
+	ready := func() bool {
+		return obj.Fields["_low"].Int != 0 || vm.streamAvailable(in) > 0
+	}
+
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/io/InputStream;)V",
+		"<init>:(Ljava/io/InputStream;Ljava/lang/String;)V",
+		"<init>:(Ljava/io/InputStream;Ljava/nio/charset/Charset;)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		obj.Fields["_in"] = args[0]
+		obj.Fields["_low"] = IntValue(0)
+		obj.Fields["_latin1"] = IntValue(0)
+		if len(args) == 2 {
+			// Charset objects need the JDK; only names are honoured.
+			if name, ok := extractGoString(args[1]); ok {
+				switch name {
+				case "ISO-8859-1", "ISO8859_1", "latin1", "US-ASCII", "ASCII":
+					obj.Fields["_latin1"] = IntValue(1)
+				case "UTF-8", "UTF8", "utf-8", "utf8":
+				default:
+					return Value{}, NewJavaExceptionMessage("java/io/UnsupportedEncodingException", name)
+				}
+			}
+		}
+		obj.Fields["_stream"] = RefValue("java/io/InputStreamReader")
+		return Value{}, nil
+	case "read:()I":
+		c, err := readChar()
+		return IntValue(c), err
+	case "read:([CII)I":
+		return readChars(args, readChar, ready)
+	case "read:([C)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		return readChars([]Value{args[0], IntValue(0), IntValue(int32(args[0].Ref.(*JArray).Len()))}, readChar, ready)
+	case "read:(Ljava/nio/CharBuffer;)I":
+		return vm.readIntoCharBuffer(args[0], func(args []Value) (Value, error) {
+			return readChars(args, readChar, ready)
+		})
+	case "ready:()Z":
+		if ready() {
+			return IntValue(1), nil
+		}
+		return IntValue(0), nil
+	case "getEncoding:()Ljava/lang/String;":
+		if obj.Fields["_latin1"].Int != 0 {
+			return RefValue("ISO8859_1"), nil
+		}
+		return RefValue("UTF8"), nil
+	case "markSupported:()Z":
+		return IntValue(0), nil
+	case "close:()V":
+		if cls := nativeStreamClassOf(in, ""); cls != "" {
+			return vm.handleNativeStream(cls, in, "close", "()V", nil)
+		}
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("InputStreamReader: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleBufferedReader(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	in := obj.Fields["_in"]
+	// readChar reads a char, dropping a '\n' that follows a '\r' readLine
+	// has ended a line at.
+	readChar := func() (int32, error) {
+		c, err := vm.readFromReader(in)
+		if err == nil && c == '\n' && obj.Fields["_skipLF"].Int != 0 {
+			c, err = vm.readFromReader(in)
+		}
+		obj.Fields["_skipLF"] = IntValue(0)
+		return c, err
+	}
+	ready := func() bool { return vm.readerReady(in) }
+
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/io/Reader;)V", "<init>:(Ljava/io/Reader;I)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		if len(args) == 2 && args[1].Int <= 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Buffer size <= 0")
+		}
+		obj.Fields["_in"] = args[0]
+		obj.Fields["_skipLF"] = IntValue(0)
+		obj.Fields["_stream"] = RefValue("java/io/BufferedReader")
+		return Value{}, nil
+	case "readLine:()Ljava/lang/String;":
+		var line []uint16
+		for {
+			c, err := readChar()
+			if err != nil {
+				return Value{}, err
+			}
+			switch {
+			case c < 0 && line == nil:
+				return NullValue(), nil
+			case c < 0 || c == '\n':
+				return RefValue(charsString(line)), nil
+			case c == '\r':
+				obj.Fields["_skipLF"] = IntValue(1)
+				return RefValue(charsString(line)), nil
+			}
+			line = append(line, uint16(c))
+		}
+	case "read:()I":
+		c, err := readChar()
+		return IntValue(c), err
+	case "read:([CII)I":
+		return readChars(args, readChar, ready)
+	case "read:([C)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		return readChars([]Value{args[0], IntValue(0), IntValue(int32(args[0].Ref.(*JArray).Len()))}, readChar, ready)
+	case "read:(Ljava/nio/CharBuffer;)I":
+		return vm.readIntoCharBuffer(args[0], func(args []Value) (Value, error) {
+			return readChars(args, readChar, ready)
+		})
+	case "skip:(J)J":
+		if args[0].Long < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "skip value is negative")
+		}
+		var n int64
+		for n < args[0].Long {
+			c, err := readChar()
+			if err != nil {
+				return Value{}, err
+			}
+			if c < 0 {
+				break
+			}
+			n++
+		}
+		return LongValue(n), nil
+	case "ready:()Z":
+		if ready() {
+			return IntValue(1), nil
+		}
+		return IntValue(0), nil
+	case "markSupported:()Z":
+		return IntValue(0), nil
+	case "close:()V":
+		if cls := nativeStreamClassOf(in, ""); cls != "" {
+			return vm.handleNativeStream(cls, in, "close", "()V", nil)
+		}
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("BufferedReader: unsupported method %s:%s", methodName, descriptor)
+}
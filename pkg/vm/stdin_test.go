@@ -0,0 +1,125 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func stdinClass() *classfile.ClassFile {
+	b := classfile.NewBuilder("app/Input", "java/lang/Object")
+	in := b.Fieldref("java/lang/System", "in", "Ljava/io/InputStream;")
+	read := b.Methodref("java/io/InputStream", "read", "()I")
+	isr := b.Class("java/io/InputStreamReader")
+	isrInit := b.Methodref("java/io/InputStreamReader", "<init>", "(Ljava/io/InputStream;)V")
+	br := b.Class("java/io/BufferedReader")
+	brInit := b.Methodref("java/io/BufferedReader", "<init>", "(Ljava/io/Reader;)V")
+	readLine := b.Methodref("java/io/BufferedReader", "readLine", "()Ljava/lang/String;")
+	readChar := b.Methodref("java/io/BufferedReader", "read", "()I")
+	b.AddMethod(classfile.AccStatic, "read", "()I", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{OpGetstatic, byte(in >> 8), byte(in), OpInvokevirtual, byte(read >> 8), byte(read), OpIreturn},
+	})
+	// open returns new BufferedReader(new InputStreamReader(System.in)).
+	b.AddMethod(classfile.AccStatic, "open", "()Ljava/io/BufferedReader;", &classfile.CodeAttribute{
+		MaxStack: 5,
+		Code: []byte{
+			OpNew, byte(br >> 8), byte(br), OpDup,
+			OpNew, byte(isr >> 8), byte(isr), OpDup,
+			OpGetstatic, byte(in >> 8), byte(in),
+			OpInvokespecial, byte(isrInit >> 8), byte(isrInit),
+			OpInvokespecial, byte(brInit >> 8), byte(brInit),
+			OpAreturn,
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "readLine", "(Ljava/io/BufferedReader;)Ljava/lang/String;", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpAload0, OpInvokevirtual, byte(readLine >> 8), byte(readLine), OpAreturn},
+	})
+	b.AddMethod(classfile.AccStatic, "readChar", "(Ljava/io/BufferedReader;)I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpAload0, OpInvokevirtual, byte(readChar >> 8), byte(readChar), OpIreturn},
+	})
+	return b.Build()
+}
+
+func TestStdinReadLine(t *testing.T) {
+	cf := stdinClass()
+	v := NewVM(mapClassLoader{"app/Input": cf})
+	v.Stdin = strings.NewReader("héllo\r\nworld\n\nlast")
+
+	reader, err := v.executeMethod(cf, cf.FindMethodByName("open"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for {
+		line, err := v.executeMethod(cf, cf.FindMethodByName("readLine"), []Value{reader})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line.Type == TypeNull {
+			break
+		}
+		s, _ := extractGoString(line)
+		lines = append(lines, s)
+	}
+	if got, want := strings.Join(lines, "|"), "héllo|world||last"; got != want {
+		t.Errorf("lines: got %q, want %q", got, want)
+	}
+}
+
+func TestStdinRead(t *testing.T) {
+	cf := stdinClass()
+	v := NewVM(mapClassLoader{"app/Input": cf})
+	v.Stdin = strings.NewReader("A")
+	for _, want := range []int32{'A', -1, -1} {
+		if got, err := v.executeMethod(cf, cf.FindMethodByName("read"), nil); err != nil || got.Int != want {
+			t.Errorf("read: got %v, %v, want %d", got, err, want)
+		}
+	}
+
+	// Supplementary characters read as surrogate pairs.
+	v = NewVM(mapClassLoader{"app/Input": cf})
+	v.Stdin = strings.NewReader("\U0001F600!")
+	reader, err := v.executeMethod(cf, cf.FindMethodByName("open"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []int32{0xD83D, 0xDE00, '!', -1} {
+		if got, err := v.executeMethod(cf, cf.FindMethodByName("readChar"), []Value{reader}); err != nil || got.Int != want {
+			t.Errorf("readChar: got %v, %v, want %#x", got, err, want)
+		}
+	}
+
+	// Without Stdin the input is empty.
+	v = NewVM(mapClassLoader{"app/Input": cf})
+	v.Stdin = nil
+	if got, err := v.executeMethod(cf, cf.FindMethodByName("read"), nil); err != nil || got.Int != -1 {
+		t.Errorf("read without Stdin: got %v, %v", got, err)
+	}
+}
+
+func TestStdinReadArray(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	v.Stdin = strings.NewReader("abcdef")
+	in := v.stdinObject()
+	buf := NewArray("B", 4)
+	n, err := v.handleNativeStream(stdinStream, in, "read", "([BII)I", []Value{RefValue(buf), IntValue(1), IntValue(3)})
+	if err != nil || n.Int != 3 || string(byteArrayToGo(buf, 1, 3)) != "abc" {
+		t.Errorf("read: got %v, %v, %q", n, err, byteArrayToGo(buf, 0, 4))
+	}
+	if n, _ := v.handleNativeStream(stdinStream, in, "available", "()I", nil); n.Int != 3 {
+		t.Errorf("available: got %d, want 3", n.Int)
+	}
+	rest, err := v.handleNativeStream(stdinStream, in, "readAllBytes", "()[B", nil)
+	if arr, ok := rest.Ref.(*JArray); err != nil || !ok || string(byteArrayToGo(arr, 0, arr.Len())) != "def" {
+		t.Errorf("readAllBytes: got %v, %v", rest, err)
+	}
+	if n, _ := v.handleNativeStream(stdinStream, in, "read", "([BII)I", []Value{RefValue(buf), IntValue(0), IntValue(4)}); n.Int != -1 {
+		t.Errorf("read at end: got %d, want -1", n.Int)
+	}
+}
@@ -0,0 +1,1133 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/native"
+)
+
+// In-memory stream classes implemented natively. Like StringBuilder, their
+// state lives in hidden "_"-prefixed fields of the JObject:
+//
+//	ByteArrayOutputStream: _buffer []byte
+//	ByteArrayInputStream:  _buffer []byte, _pos, _mark, _limit (int)
+//	StringReader:          _chars []uint16, _pos, _mark (int)
+//	StringWriter:          _buffer (*strings.Builder)
+//
+// Every native stream also records its class in _stream so that user
+// subclasses keep dispatching to the native implementation of the methods
+// they do not override.
+var nativeStreamClasses = map[string]bool{
+	"java/io/ByteArrayOutputStream": true,
+	"java/io/ByteArrayInputStream":  true,
+	"java/io/StringReader":          true,
+	"java/io/StringWriter":          true,
+	"java/io/DataOutputStream":      true,
+	"java/io/DataInputStream":       true,
+	"java/io/ObjectOutputStream":    true,
+	"java/io/ObjectInputStream":     true,
+	"java/io/InputStreamReader":     true, // in stdin.go
+	"java/io/BufferedReader":        true, // in stdin.go
+	"java/io/FileInputStream":       true, // in files.go
+	"java/io/FileOutputStream":      true, // in files.go
+	"java/io/RandomAccessFile":      true, // in files.go
+}
+
+// nativeStreamClassOf returns the native stream class backing objectRef, or ""
+// if the receiver is not a natively implemented stream. refClass is the class
+// named by the method reference, which covers user subclasses whose
+// constructors chain to a native stream constructor via invokespecial.
+func nativeStreamClassOf(objectRef Value, refClass string) string {
+	obj, ok := objectRef.Ref.(*JObject)
+	if !ok {
+		return ""
+	}
+	if nativeStreamClasses[obj.ClassName] {
+		return obj.ClassName
+	}
+	if nativeStreamClasses[refClass] {
+		return refClass
+	}
+	if name, ok := obj.Fields["_stream"].Ref.(string); ok {
+		return name
+	}
+	return ""
+}
+
+// overridesStreamMethod reports whether a class between className and the
+// native stream class streamClass declares methodName, so that a call
+// dispatched from className runs the user's bytecode rather than the
+// native method. Objects that are not instances of streamClass, such as
+// System.in, have no overrides. The answer is cached, since every call on
+// a stream asks.
+func (vm *VM) overridesStreamMethod(className, streamClass, methodName, descriptor string) bool {
+	key := className + " " + streamClass + "." + methodName + descriptor
+	if overridden, ok := vm.streamOverrides.Load(key); ok {
+		return overridden.(bool)
+	}
+	overridden := false
+	for name := className; name != streamClass; {
+		if name == "" {
+			overridden = false
+			break
+		}
+		cf, err := vm.ClassLoader.LoadClass(name)
+		if err != nil {
+			overridden = false
+			break
+		}
+		if cf.FindMethod(methodName, descriptor) != nil {
+			overridden = true
+		}
+		name = cf.SuperClassName()
+	}
+	vm.streamOverrides.Store(key, overridden)
+	return overridden
+}
+
+// byteArrayToGo copies the elements of a Java byte[] into a Go byte slice.
+func byteArrayToGo(arr *JArray, off, length int) []byte {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = byte(arr.Get(off + i).Int)
+	}
+	return b
+}
+
+// goBytesToArray creates a Java byte[] from a Go byte slice.
+func goBytesToArray(b []byte) *JArray {
+	arr := NewArray("B", len(b))
+	for i, c := range b {
+		arr.Bytes[i] = int8(c)
+	}
+	return arr
+}
+
+// arrayRange validates the (array, off, len) triple used by read/write
+// overloads and returns the array.
+func arrayRange(arrRef Value, off, length int32) (*JArray, error) {
+	if arrRef.Type == TypeNull || arrRef.Ref == nil {
+		return nil, NewJavaException("java/lang/NullPointerException")
+	}
+	arr, ok := arrRef.Ref.(*JArray)
+	if !ok {
+		return nil, fmt.Errorf("stream: argument is not an array")
+	}
+	if off < 0 || length < 0 || int(off)+int(length) > arr.Len() {
+		return nil, NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException",
+			fmt.Sprintf("Range [%d, %d + %d) out of bounds for length %d", off, off, length, arr.Len()))
+	}
+	return arr, nil
+}
+
+// handleNativeStream handles method calls on natively implemented streams.
+func (vm *VM) handleNativeStream(streamClass string, objectRef Value, methodName, descriptor string, args []Value) (Value, error) {
+	obj := objectRef.Ref.(*JObject)
+	switch streamClass {
+	case "java/io/ByteArrayOutputStream":
+		return vm.handleByteArrayOutputStream(obj, objectRef, methodName, descriptor, args)
+	case "java/io/ByteArrayInputStream":
+		return vm.handleByteArrayInputStream(obj, methodName, descriptor, args)
+	case "java/io/StringReader":
+		return vm.handleStringReader(obj, methodName, descriptor, args)
+	case "java/io/StringWriter":
+		return vm.handleStringWriter(obj, objectRef, methodName, descriptor, args)
+	case "java/io/DataOutputStream":
+		return vm.handleDataOutputStream(obj, methodName, descriptor, args)
+	case "java/io/DataInputStream":
+		return vm.handleDataInputStream(obj, methodName, descriptor, args)
+	case "java/io/ObjectOutputStream":
+		return vm.handleObjectOutputStream(obj, methodName, descriptor, args)
+	case "java/io/ObjectInputStream":
+		return vm.handleObjectInputStream(obj, methodName, descriptor, args)
+	case "java/io/InputStreamReader":
+		return vm.handleInputStreamReader(obj, methodName, descriptor, args)
+	case "java/io/BufferedReader":
+		return vm.handleBufferedReader(obj, methodName, descriptor, args)
+	case "java/io/FileInputStream":
+		return vm.handleFileInputStream(obj, methodName, descriptor, args)
+	case "java/io/FileOutputStream":
+		return vm.handleFileOutputStream(obj, methodName, descriptor, args)
+	case "java/io/RandomAccessFile":
+		return vm.handleRandomAccessFile(obj, methodName, descriptor, args)
+	case stdinStream:
+		return vm.handleStdin(methodName, descriptor, args)
+	}
+	return Value{}, fmt.Errorf("stream: unsupported class %s", streamClass)
+}
+
+func (vm *VM) handleByteArrayOutputStream(obj *JObject, objectRef Value, methodName, descriptor string, args []Value) (Value, error) {
+	buf, _ := obj.Fields["_buffer"].Ref.([]byte)
+
+	switch methodName + ":" + descriptor {
+	case "<init>:()V":
+		obj.Fields["_buffer"] = RefValue([]byte{})
+		obj.Fields["_stream"] = RefValue("java/io/ByteArrayOutputStream")
+		return Value{}, nil
+	case "<init>:(I)V":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", fmt.Sprintf("Negative initial size: %d", args[0].Int))
+		}
+		obj.Fields["_buffer"] = RefValue(make([]byte, 0, args[0].Int))
+		obj.Fields["_stream"] = RefValue("java/io/ByteArrayOutputStream")
+		return Value{}, nil
+	case "write:(I)V":
+		obj.Fields["_buffer"] = RefValue(append(buf, byte(args[0].Int)))
+		return Value{}, nil
+	case "write:([BII)V":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		obj.Fields["_buffer"] = RefValue(append(buf, byteArrayToGo(arr, int(args[1].Int), int(args[2].Int))...))
+		return Value{}, nil
+	case "write:([B)V", "writeBytes:([B)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		arr := args[0].Ref.(*JArray)
+		obj.Fields["_buffer"] = RefValue(append(buf, byteArrayToGo(arr, 0, arr.Len())...))
+		return Value{}, nil
+	case "writeTo:(Ljava/io/OutputStream;)V":
+		return Value{}, vm.writeToStream(args[0], buf)
+	case "toByteArray:()[B":
+		return RefValue(goBytesToArray(buf)), nil
+	case "size:()I":
+		return IntValue(int32(len(buf))), nil
+	case "reset:()V":
+		obj.Fields["_buffer"] = RefValue(buf[:0])
+		return Value{}, nil
+	case "toString:()Ljava/lang/String;",
+		"toString:(Ljava/lang/String;)Ljava/lang/String;",
+		"toString:(Ljava/nio/charset/Charset;)Ljava/lang/String;":
+		charset := vm.defaultCharset()
+		if len(args) > 0 {
+			name, ok := extractGoString(args[0])
+			if !ok {
+				name, ok = charsetName(args[0])
+			}
+			if !ok {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			if charset, ok = canonicalCharset(name); !ok {
+				return Value{}, NewJavaExceptionMessage("java/io/UnsupportedEncodingException", name)
+			}
+		}
+		return RefValue(decodeString(buf, charset)), nil
+	case "flush:()V", "close:()V":
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("ByteArrayOutputStream: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleByteArrayInputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	buf, _ := obj.Fields["_buffer"].Ref.([]byte)
+	pos := int(obj.Fields["_pos"].Int)
+	limit := int(obj.Fields["_limit"].Int)
+	// The constructor takes a negative offset, which Java only notices
+	// when it reads the array.
+	outOfBounds := func() error {
+		return NewJavaExceptionMessage("java/lang/ArrayIndexOutOfBoundsException",
+			fmt.Sprintf("Index %d out of bounds for length %d", pos, len(buf)))
+	}
+
+	switch methodName + ":" + descriptor {
+	case "<init>:([B)V", "<init>:([BII)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		arr := args[0].Ref.(*JArray)
+		// The stream shares the caller's array in Java; a snapshot is
+		// sufficient for the usual build-then-read pattern.
+		data := byteArrayToGo(arr, 0, arr.Len())
+		start, end := 0, len(data)
+		if len(args) == 3 {
+			start = int(args[1].Int)
+			end = start + int(args[2].Int)
+			if end > len(data) {
+				end = len(data)
+			}
+			if start > end {
+				start = end
+			}
+		}
+		obj.Fields["_buffer"] = RefValue(data)
+		obj.Fields["_pos"] = IntValue(int32(start))
+		obj.Fields["_mark"] = IntValue(int32(start))
+		obj.Fields["_limit"] = IntValue(int32(end))
+		obj.Fields["_stream"] = RefValue("java/io/ByteArrayInputStream")
+		return Value{}, nil
+	case "read:()I":
+		if pos >= limit {
+			return IntValue(-1), nil
+		}
+		if pos < 0 {
+			return Value{}, outOfBounds()
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + 1))
+		return IntValue(int32(buf[pos])), nil
+	case "read:([BII)I", "readNBytes:([BII)I":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		n := int(args[2].Int)
+		if pos >= limit {
+			if methodName == "readNBytes" || n == 0 {
+				return IntValue(0), nil
+			}
+			return IntValue(-1), nil
+		}
+		if n > limit-pos {
+			n = limit - pos
+		}
+		if n > 0 && pos < 0 {
+			return Value{}, outOfBounds()
+		}
+		off := int(args[1].Int)
+		for i := 0; i < n; i++ {
+			arr.Set(off+i, IntValue(int32(int8(buf[pos+i]))))
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + n))
+		return IntValue(int32(n)), nil
+	case "read:([B)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		n := int32(args[0].Ref.(*JArray).Len())
+		return vm.handleByteArrayInputStream(obj, "read", "([BII)I", []Value{args[0], IntValue(0), IntValue(n)})
+	case "readAllBytes:()[B":
+		if pos >= limit {
+			return RefValue(goBytesToArray(nil)), nil
+		}
+		if pos < 0 {
+			return Value{}, outOfBounds()
+		}
+		obj.Fields["_pos"] = IntValue(int32(limit))
+		return RefValue(goBytesToArray(buf[pos:limit])), nil
+	case "readNBytes:(I)[B":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "len < 0")
+		}
+		n := int(args[0].Int)
+		if n > limit-pos {
+			n = limit - pos
+		}
+		if n <= 0 {
+			return RefValue(goBytesToArray(nil)), nil
+		}
+		if pos < 0 {
+			return Value{}, outOfBounds()
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + n))
+		return RefValue(goBytesToArray(buf[pos : pos+n])), nil
+	case "skip:(J)J":
+		n := args[0].Long
+		if n < 0 {
+			n = 0
+		}
+		if n > int64(limit-pos) {
+			n = int64(limit - pos)
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + int(n)))
+		return LongValue(n), nil
+	case "available:()I":
+		return IntValue(int32(limit - pos)), nil
+	case "markSupported:()Z":
+		return IntValue(1), nil
+	case "mark:(I)V":
+		obj.Fields["_mark"] = IntValue(int32(pos))
+		return Value{}, nil
+	case "reset:()V":
+		obj.Fields["_pos"] = obj.Fields["_mark"]
+		return Value{}, nil
+	case "close:()V":
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("ByteArrayInputStream: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleStringReader(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	chars, _ := obj.Fields["_chars"].Ref.([]uint16)
+	pos := int(obj.Fields["_pos"].Int)
+
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/lang/String;)V":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		obj.Fields["_chars"] = RefValue(stringChars(s))
+		obj.Fields["_pos"] = IntValue(0)
+		obj.Fields["_mark"] = IntValue(0)
+		obj.Fields["_stream"] = RefValue("java/io/StringReader")
+		return Value{}, nil
+	case "read:()I":
+		if pos >= len(chars) {
+			return IntValue(-1), nil
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + 1))
+		return IntValue(int32(chars[pos])), nil
+	case "read:([CII)I":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		n := int(args[2].Int)
+		if n == 0 {
+			return IntValue(0), nil
+		}
+		if pos >= len(chars) {
+			return IntValue(-1), nil
+		}
+		if n > len(chars)-pos {
+			n = len(chars) - pos
+		}
+		off := int(args[1].Int)
+		for i := 0; i < n; i++ {
+			arr.Set(off+i, IntValue(int32(chars[pos+i])))
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + n))
+		return IntValue(int32(n)), nil
+	case "read:([C)I":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		n := int32(args[0].Ref.(*JArray).Len())
+		return vm.handleStringReader(obj, "read", "([CII)I", []Value{args[0], IntValue(0), IntValue(n)})
+	case "skip:(J)J":
+		// Negative skips move backwards, but never before the start.
+		n := args[0].Long
+		if n > int64(len(chars)-pos) {
+			n = int64(len(chars) - pos)
+		}
+		if n < int64(-pos) {
+			n = int64(-pos)
+		}
+		obj.Fields["_pos"] = IntValue(int32(pos + int(n)))
+		return LongValue(n), nil
+	case "ready:()Z", "markSupported:()Z":
+		return IntValue(1), nil
+	case "mark:(I)V":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Read-ahead limit < 0")
+		}
+		obj.Fields["_mark"] = IntValue(int32(pos))
+		return Value{}, nil
+	case "reset:()V":
+		obj.Fields["_pos"] = obj.Fields["_mark"]
+		return Value{}, nil
+	case "close:()V":
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("StringReader: unsupported method %s:%s", methodName, descriptor)
+}
+
+func (vm *VM) handleStringWriter(obj *JObject, objectRef Value, methodName, descriptor string, args []Value) (Value, error) {
+	buf, _ := obj.Fields["_buffer"].Ref.(*strings.Builder)
+
+	switch methodName + ":" + descriptor {
+	case "<init>:()V":
+		obj.Fields["_buffer"] = RefValue(&strings.Builder{})
+		obj.Fields["_stream"] = RefValue("java/io/StringWriter")
+		return Value{}, nil
+	case "<init>:(I)V":
+		if args[0].Int < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Negative buffer size")
+		}
+		buf := &strings.Builder{}
+		buf.Grow(int(args[0].Int))
+		obj.Fields["_buffer"] = RefValue(buf)
+		obj.Fields["_stream"] = RefValue("java/io/StringWriter")
+		return Value{}, nil
+	case "write:(I)V":
+		buf.WriteString(charsString([]uint16{uint16(args[0].Int)}))
+		return Value{}, nil
+	case "write:(Ljava/lang/String;)V":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		buf.WriteString(s)
+		return Value{}, nil
+	case "write:(Ljava/lang/String;II)V":
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		units := stringChars(s)
+		off, n := int(args[1].Int), int(args[2].Int)
+		if off < 0 || n < 0 || off+n > len(units) {
+			return Value{}, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("start %d, end %d, length %d", off, off+n, len(units)))
+		}
+		buf.WriteString(charsString(units[off : off+n]))
+		return Value{}, nil
+	case "write:([CII)V":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		buf.WriteString(charsString(arrayChars(arr, int(args[1].Int), int(args[2].Int))))
+		return Value{}, nil
+	case "write:([C)V":
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		n := int32(args[0].Ref.(*JArray).Len())
+		return vm.handleStringWriter(obj, objectRef, "write", "([CII)V", []Value{args[0], IntValue(0), IntValue(n)})
+	case "append:(C)Ljava/io/StringWriter;", "append:(C)Ljava/io/Writer;", "append:(C)Ljava/lang/Appendable;":
+		buf.WriteString(charsString([]uint16{uint16(args[0].Int)}))
+		return objectRef, nil
+	case "append:(Ljava/lang/CharSequence;)Ljava/io/StringWriter;",
+		"append:(Ljava/lang/CharSequence;)Ljava/io/Writer;",
+		"append:(Ljava/lang/CharSequence;)Ljava/lang/Appendable;":
+		// append(null) writes "null", like the JDK
+		buf.WriteString(vm.valueToString(args[0]))
+		return objectRef, nil
+	case "append:(Ljava/lang/CharSequence;II)Ljava/io/StringWriter;",
+		"append:(Ljava/lang/CharSequence;II)Ljava/io/Writer;",
+		"append:(Ljava/lang/CharSequence;II)Ljava/lang/Appendable;":
+		s := vm.valueToString(args[0])
+		units := stringChars(s)
+		start, end := int(args[1].Int), int(args[2].Int)
+		if start < 0 || start > end || end > len(units) {
+			return Value{}, NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException",
+				fmt.Sprintf("begin %d, end %d, length %d", start, end, len(units)))
+		}
+		buf.WriteString(charsString(units[start:end]))
+		return objectRef, nil
+	case "toString:()Ljava/lang/String;":
+		return RefValue(buf.String()), nil
+	case "flush:()V", "close:()V":
+		return Value{}, nil
+	}
+	return Value{}, fmt.Errorf("StringWriter: unsupported method %s:%s", methodName, descriptor)
+}
+
+// writeToStream writes bytes to an arbitrary OutputStream value: native
+// streams are written directly, System.out-style PrintStreams go to their
+// Writer, and any other object receives write(int) calls per byte.
+func (vm *VM) writeToStream(out Value, b []byte) error {
+	if out.Type == TypeNull || out.Ref == nil {
+		return NewJavaException("java/lang/NullPointerException")
+	}
+	if ps, ok := out.Ref.(*native.PrintStream); ok {
+		_, err := ps.Writer.Write(b)
+		return err
+	}
+	if cls := nativeStreamClassOf(out, ""); cls != "" {
+		_, err := vm.handleNativeStream(cls, out, "write", "([BII)V", []Value{RefValue(goBytesToArray(b)), IntValue(0), IntValue(int32(len(b)))})
+		return err
+	}
+	obj, ok := out.Ref.(*JObject)
+	if !ok {
+		return fmt.Errorf("stream: cannot write to %T", out.Ref)
+	}
+	cf, method, err := vm.resolveMethod(obj.ClassName, "write", "(I)V")
+	if err != nil {
+		return err
+	}
+	for _, c := range b {
+		if _, err := vm.executeMethod(cf, method, []Value{out, IntValue(int32(c))}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readFromStream reads one byte from an arbitrary InputStream value,
+// returning -1 at end of stream.
+func (vm *VM) readFromStream(in Value) (int32, error) {
+	if in.Type == TypeNull || in.Ref == nil {
+		return 0, NewJavaException("java/lang/NullPointerException")
+	}
+	if cls := nativeStreamClassOf(in, ""); cls != "" {
+		v, err := vm.handleNativeStream(cls, in, "read", "()I", nil)
+		return v.Int, err
+	}
+	obj, ok := in.Ref.(*JObject)
+	if !ok {
+		return 0, fmt.Errorf("stream: cannot read from %T", in.Ref)
+	}
+	cf, method, err := vm.resolveMethod(obj.ClassName, "read", "()I")
+	if err != nil {
+		return 0, err
+	}
+	v, err := vm.executeMethod(cf, method, []Value{in})
+	return v.Int, err
+}
+
+// readFullyFromStream reads exactly n bytes, throwing EOFException if the
+// stream ends first.
+func (vm *VM) readFullyFromStream(in Value, n int) ([]byte, error) {
+	b := make([]byte, n)
+	for i := range b {
+		c, err := vm.readFromStream(in)
+		if err != nil {
+			return nil, err
+		}
+		if c < 0 {
+			return nil, NewJavaException("java/io/EOFException")
+		}
+		b[i] = byte(c)
+	}
+	return b, nil
+}
+
+// encodeModifiedUTF8 encodes s in the modified UTF-8 format used by
+// DataOutput.writeUTF: U+0000 takes two bytes and supplementary characters
+// are written as surrogate pairs.
+func encodeModifiedUTF8(s string) []byte {
+	var b []byte
+	for _, c := range stringChars(s) {
+		switch {
+		case c >= 0x0001 && c <= 0x007F:
+			b = append(b, byte(c))
+		case c <= 0x07FF:
+			b = append(b, byte(0xC0|(c>>6)), byte(0x80|(c&0x3F)))
+		default:
+			b = append(b, byte(0xE0|(c>>12)), byte(0x80|((c>>6)&0x3F)), byte(0x80|(c&0x3F)))
+		}
+	}
+	return b
+}
+
+// decodeModifiedUTF8 is the inverse of encodeModifiedUTF8.
+func decodeModifiedUTF8(b []byte) (string, error) {
+	units := make([]uint16, 0, len(b))
+	for i := 0; i < len(b); {
+		c := b[i]
+		switch {
+		case c < 0x80:
+			units = append(units, uint16(c))
+			i++
+		case c&0xE0 == 0xC0:
+			if i+1 >= len(b) || b[i+1]&0xC0 != 0x80 {
+				return "", NewJavaException("java/io/UTFDataFormatException")
+			}
+			units = append(units, uint16(c&0x1F)<<6|uint16(b[i+1]&0x3F))
+			i += 2
+		case c&0xF0 == 0xE0:
+			if i+2 >= len(b) || b[i+1]&0xC0 != 0x80 || b[i+2]&0xC0 != 0x80 {
+				return "", NewJavaException("java/io/UTFDataFormatException")
+			}
+			units = append(units, uint16(c&0x0F)<<12|uint16(b[i+1]&0x3F)<<6|uint16(b[i+2]&0x3F))
+			i += 3
+		default:
+			return "", NewJavaException("java/io/UTFDataFormatException")
+		}
+	}
+	return charsString(units), nil
+}
+
+// encodeDataOutput encodes the argument of a DataOutput write method in
+// big-endian binary form. ok is false for methods that are not primitive writes.
+func encodeDataOutput(methodName string, args []Value) (b []byte, ok bool, err error) {
+	switch methodName {
+	case "writeBoolean":
+		if args[0].Int != 0 {
+			return []byte{1}, true, nil
+		}
+		return []byte{0}, true, nil
+	case "writeByte":
+		return []byte{byte(args[0].Int)}, true, nil
+	case "writeShort", "writeChar":
+		return binary.BigEndian.AppendUint16(nil, uint16(args[0].Int)), true, nil
+	case "writeInt":
+		return binary.BigEndian.AppendUint32(nil, uint32(args[0].Int)), true, nil
+	case "writeLong":
+		return binary.BigEndian.AppendUint64(nil, uint64(args[0].Long)), true, nil
+	case "writeFloat":
+		f := args[0].Float
+		bits := math.Float32bits(f)
+		if f != f {
+			bits = 0x7fc00000 // floatToIntBits canonical NaN
+		}
+		return binary.BigEndian.AppendUint32(nil, bits), true, nil
+	case "writeDouble":
+		d := args[0].Double
+		bits := math.Float64bits(d)
+		if d != d {
+			bits = 0x7ff8000000000000 // doubleToLongBits canonical NaN
+		}
+		return binary.BigEndian.AppendUint64(nil, bits), true, nil
+	case "writeBytes", "writeChars", "writeUTF":
+		s, isStr := extractGoString(args[0])
+		if !isStr {
+			return nil, true, NewJavaException("java/lang/NullPointerException")
+		}
+		units := stringChars(s)
+		switch methodName {
+		case "writeBytes":
+			for _, c := range units {
+				b = append(b, byte(c))
+			}
+		case "writeChars":
+			for _, c := range units {
+				b = binary.BigEndian.AppendUint16(b, c)
+			}
+		case "writeUTF":
+			enc := encodeModifiedUTF8(s)
+			if len(enc) > 65535 {
+				return nil, true, NewJavaException("java/io/UTFDataFormatException")
+			}
+			b = binary.BigEndian.AppendUint16(b, uint16(len(enc)))
+			b = append(b, enc...)
+		}
+		return b, true, nil
+	}
+	return nil, false, nil
+}
+
+// decodeDataInput reads the value for a DataInput read method using next to
+// fetch exactly n bytes. ok is false for methods that are not primitive reads.
+func decodeDataInput(methodName string, next func(n int) ([]byte, error)) (v Value, ok bool, err error) {
+	var b []byte
+	switch methodName {
+	case "readBoolean", "readByte", "readUnsignedByte":
+		if b, err = next(1); err != nil {
+			return Value{}, true, err
+		}
+		switch methodName {
+		case "readBoolean":
+			if b[0] != 0 {
+				return IntValue(1), true, nil
+			}
+			return IntValue(0), true, nil
+		case "readByte":
+			return IntValue(int32(int8(b[0]))), true, nil
+		}
+		return IntValue(int32(b[0])), true, nil
+	case "readShort", "readUnsignedShort", "readChar":
+		if b, err = next(2); err != nil {
+			return Value{}, true, err
+		}
+		u := binary.BigEndian.Uint16(b)
+		if methodName == "readShort" {
+			return IntValue(int32(int16(u))), true, nil
+		}
+		return IntValue(int32(u)), true, nil
+	case "readInt", "readFloat":
+		if b, err = next(4); err != nil {
+			return Value{}, true, err
+		}
+		u := binary.BigEndian.Uint32(b)
+		if methodName == "readFloat" {
+			return FloatValue(math.Float32frombits(u)), true, nil
+		}
+		return IntValue(int32(u)), true, nil
+	case "readLong", "readDouble":
+		if b, err = next(8); err != nil {
+			return Value{}, true, err
+		}
+		u := binary.BigEndian.Uint64(b)
+		if methodName == "readDouble" {
+			return DoubleValue(math.Float64frombits(u)), true, nil
+		}
+		return LongValue(int64(u)), true, nil
+	case "readUTF":
+		if b, err = next(2); err != nil {
+			return Value{}, true, err
+		}
+		if b, err = next(int(binary.BigEndian.Uint16(b))); err != nil {
+			return Value{}, true, err
+		}
+		s, err := decodeModifiedUTF8(b)
+		if err != nil {
+			return Value{}, true, err
+		}
+		return RefValue(s), true, nil
+	}
+	return Value{}, false, nil
+}
+
+// Wrapper streams. Their hidden fields are:
+//
+//	DataOutputStream:   _out (stream), _written (int)
+//	DataInputStream:    _in (stream)
+//	ObjectOutputStream: _out (stream), _block []byte (pending block data)
+//	ObjectInputStream:  _in (stream), _blockRemaining (int)
+//
+// Object streams implement the stream header and block-data framing of
+// primitive values; object graphs beyond null and String are not supported.
+const (
+	objectStreamMagic   = 0xACED
+	objectStreamVersion = 5
+	tcNull              = 0x70
+	tcString            = 0x74
+	tcBlockData         = 0x77
+	tcBlockDataLong     = 0x7A
+	maxBlockDataSize    = 1024
+)
+
+func (vm *VM) handleDataOutputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	out := obj.Fields["_out"]
+	written := obj.Fields["_written"].Int
+
+	if methodName == "<init>" && descriptor == "(Ljava/io/OutputStream;)V" {
+		obj.Fields["_out"] = args[0]
+		obj.Fields["_written"] = IntValue(0)
+		obj.Fields["_stream"] = RefValue("java/io/DataOutputStream")
+		return Value{}, nil
+	}
+
+	var b []byte
+	switch methodName + ":" + descriptor {
+	case "write:(I)V":
+		b = []byte{byte(args[0].Int)}
+	case "write:([BII)V":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		b = byteArrayToGo(arr, int(args[1].Int), int(args[2].Int))
+	case "write:([B)V":
+		arr, err := arrayRange(args[0], 0, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		b = byteArrayToGo(arr, 0, arr.Len())
+	case "size:()I":
+		return IntValue(written), nil
+	case "flush:()V", "close:()V":
+		if cls := nativeStreamClassOf(out, ""); cls != "" {
+			_, err := vm.handleNativeStream(cls, out, methodName, descriptor, nil)
+			return Value{}, err
+		}
+		return Value{}, nil
+	default:
+		enc, ok, err := encodeDataOutput(methodName, args)
+		if !ok {
+			return Value{}, fmt.Errorf("DataOutputStream: unsupported method %s:%s", methodName, descriptor)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		b = enc
+	}
+	if err := vm.writeToStream(out, b); err != nil {
+		return Value{}, err
+	}
+	// size() saturates at Integer.MAX_VALUE like the JDK counter.
+	if total := int64(written) + int64(len(b)); total > math.MaxInt32 {
+		written = math.MaxInt32
+	} else {
+		written = int32(total)
+	}
+	obj.Fields["_written"] = IntValue(written)
+	return Value{}, nil
+}
+
+func (vm *VM) handleDataInputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	in := obj.Fields["_in"]
+
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/io/InputStream;)V":
+		obj.Fields["_in"] = args[0]
+		obj.Fields["_stream"] = RefValue("java/io/DataInputStream")
+		return Value{}, nil
+	case "read:()I":
+		c, err := vm.readFromStream(in)
+		return IntValue(c), err
+	case "read:([B)I", "read:([BII)I":
+		off, n := int32(0), int32(0)
+		if len(args) == 3 {
+			off, n = args[1].Int, args[2].Int
+		} else if arr, ok := args[0].Ref.(*JArray); ok {
+			n = int32(arr.Len())
+		}
+		arr, err := arrayRange(args[0], off, n)
+		if err != nil {
+			return Value{}, err
+		}
+		if n == 0 {
+			return IntValue(0), nil
+		}
+		count := int32(0)
+		for count < n {
+			c, err := vm.readFromStream(in)
+			if err != nil {
+				return Value{}, err
+			}
+			if c < 0 {
+				break
+			}
+			arr.Set(int(off+count), IntValue(int32(int8(c))))
+			count++
+		}
+		if count == 0 {
+			return IntValue(-1), nil
+		}
+		return IntValue(count), nil
+	case "readFully:([B)V", "readFully:([BII)V":
+		off, n := int32(0), int32(0)
+		if len(args) == 3 {
+			off, n = args[1].Int, args[2].Int
+		} else if arr, ok := args[0].Ref.(*JArray); ok {
+			n = int32(arr.Len())
+		}
+		arr, err := arrayRange(args[0], off, n)
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := vm.readFullyFromStream(in, int(n))
+		if err != nil {
+			return Value{}, err
+		}
+		for i, c := range b {
+			arr.Set(int(off)+i, IntValue(int32(int8(c))))
+		}
+		return Value{}, nil
+	case "skipBytes:(I)I":
+		count := int32(0)
+		for count < args[0].Int {
+			c, err := vm.readFromStream(in)
+			if err != nil {
+				return Value{}, err
+			}
+			if c < 0 {
+				break
+			}
+			count++
+		}
+		return IntValue(count), nil
+	case "available:()I", "close:()V":
+		if cls := nativeStreamClassOf(in, ""); cls != "" {
+			return vm.handleNativeStream(cls, in, methodName, descriptor, nil)
+		}
+		return IntValue(0), nil
+	}
+	v, ok, err := decodeDataInput(methodName, func(n int) ([]byte, error) {
+		return vm.readFullyFromStream(in, n)
+	})
+	if !ok {
+		return Value{}, fmt.Errorf("DataInputStream: unsupported method %s:%s", methodName, descriptor)
+	}
+	return v, err
+}
+
+// flushBlockData writes pending primitive data of an ObjectOutputStream as a
+// TC_BLOCKDATA or TC_BLOCKDATALONG record.
+func (vm *VM) flushBlockData(obj *JObject) error {
+	block, _ := obj.Fields["_block"].Ref.([]byte)
+	if len(block) == 0 {
+		return nil
+	}
+	var header []byte
+	if len(block) <= 0xFF {
+		header = []byte{tcBlockData, byte(len(block))}
+	} else {
+		header = binary.BigEndian.AppendUint32([]byte{tcBlockDataLong}, uint32(len(block)))
+	}
+	obj.Fields["_block"] = RefValue([]byte{})
+	return vm.writeToStream(obj.Fields["_out"], append(header, block...))
+}
+
+func (vm *VM) handleObjectOutputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	out := obj.Fields["_out"]
+	block, _ := obj.Fields["_block"].Ref.([]byte)
+
+	var b []byte
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/io/OutputStream;)V":
+		obj.Fields["_out"] = args[0]
+		obj.Fields["_block"] = RefValue([]byte{})
+		obj.Fields["_stream"] = RefValue("java/io/ObjectOutputStream")
+		header := binary.BigEndian.AppendUint16(nil, objectStreamMagic)
+		header = binary.BigEndian.AppendUint16(header, objectStreamVersion)
+		return Value{}, vm.writeToStream(args[0], header)
+	case "writeObject:(Ljava/lang/Object;)V":
+		if err := vm.flushBlockData(obj); err != nil {
+			return Value{}, err
+		}
+		if args[0].Type == TypeNull || args[0].Ref == nil {
+			return Value{}, vm.writeToStream(out, []byte{tcNull})
+		}
+		s, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, fmt.Errorf("ObjectOutputStream.writeObject: only null and String are supported")
+		}
+		enc := encodeModifiedUTF8(s)
+		if len(enc) > 65535 {
+			return Value{}, fmt.Errorf("ObjectOutputStream.writeObject: long strings are not supported")
+		}
+		rec := binary.BigEndian.AppendUint16([]byte{tcString}, uint16(len(enc)))
+		return Value{}, vm.writeToStream(out, append(rec, enc...))
+	case "write:(I)V":
+		b = []byte{byte(args[0].Int)}
+	case "write:([BII)V":
+		arr, err := arrayRange(args[0], args[1].Int, args[2].Int)
+		if err != nil {
+			return Value{}, err
+		}
+		b = byteArrayToGo(arr, int(args[1].Int), int(args[2].Int))
+	case "write:([B)V":
+		arr, err := arrayRange(args[0], 0, 0)
+		if err != nil {
+			return Value{}, err
+		}
+		b = byteArrayToGo(arr, 0, arr.Len())
+	case "flush:()V", "close:()V":
+		if err := vm.flushBlockData(obj); err != nil {
+			return Value{}, err
+		}
+		if cls := nativeStreamClassOf(out, ""); cls != "" {
+			_, err := vm.handleNativeStream(cls, out, methodName, descriptor, nil)
+			return Value{}, err
+		}
+		return Value{}, nil
+	default:
+		enc, ok, err := encodeDataOutput(methodName, args)
+		if !ok {
+			return Value{}, fmt.Errorf("ObjectOutputStream: unsupported method %s:%s", methodName, descriptor)
+		}
+		if err != nil {
+			return Value{}, err
+		}
+		b = enc
+	}
+	block = append(block, b...)
+	obj.Fields["_block"] = RefValue(block)
+	if len(block) >= maxBlockDataSize {
+		return Value{}, vm.flushBlockData(obj)
+	}
+	return Value{}, nil
+}
+
+// readBlockData reads n bytes of primitive data from an ObjectInputStream,
+// consuming block data headers as needed.
+func (vm *VM) readBlockData(obj *JObject, n int) ([]byte, error) {
+	in := obj.Fields["_in"]
+	b := make([]byte, 0, n)
+	for len(b) < n {
+		remaining := int(obj.Fields["_blockRemaining"].Int)
+		if remaining == 0 {
+			tc, err := vm.readFromStream(in)
+			if err != nil {
+				return nil, err
+			}
+			switch tc {
+			case tcBlockData:
+				hdr, err := vm.readFullyFromStream(in, 1)
+				if err != nil {
+					return nil, err
+				}
+				remaining = int(hdr[0])
+			case tcBlockDataLong:
+				hdr, err := vm.readFullyFromStream(in, 4)
+				if err != nil {
+					return nil, err
+				}
+				remaining = int(binary.BigEndian.Uint32(hdr))
+			default:
+				// End of stream or an object record where primitive data was expected.
+				return nil, NewJavaException("java/io/EOFException")
+			}
+		}
+		take := n - len(b)
+		if take > remaining {
+			take = remaining
+		}
+		chunk, err := vm.readFullyFromStream(in, take)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, chunk...)
+		obj.Fields["_blockRemaining"] = IntValue(int32(remaining - take))
+	}
+	return b, nil
+}
+
+func (vm *VM) handleObjectInputStream(obj *JObject, methodName, descriptor string, args []Value) (Value, error) {
+	in := obj.Fields["_in"]
+
+	switch methodName + ":" + descriptor {
+	case "<init>:(Ljava/io/InputStream;)V":
+		obj.Fields["_in"] = args[0]
+		obj.Fields["_blockRemaining"] = IntValue(0)
+		obj.Fields["_stream"] = RefValue("java/io/ObjectInputStream")
+		header, err := vm.readFullyFromStream(args[0], 4)
+		if err != nil {
+			return Value{}, err
+		}
+		if binary.BigEndian.Uint16(header) != objectStreamMagic || binary.BigEndian.Uint16(header[2:]) != objectStreamVersion {
+			return Value{}, NewJavaException("java/io/StreamCorruptedException")
+		}
+		return Value{}, nil
+	case "readObject:()Ljava/lang/Object;":
+		if obj.Fields["_blockRemaining"].Int != 0 {
+			return Value{}, NewJavaException("java/io/OptionalDataException")
+		}
+		tc, err := vm.readFromStream(in)
+		if err != nil {
+			return Value{}, err
+		}
+		switch tc {
+		case -1:
+			return Value{}, NewJavaException("java/io/EOFException")
+		case tcNull:
+			return NullValue(), nil
+		case tcString:
+			hdr, err := vm.readFullyFromStream(in, 2)
+			if err != nil {
+				return Value{}, err
+			}
+			enc, err := vm.readFullyFromStream(in, int(binary.BigEndian.Uint16(hdr)))
+			if err != nil {
+				return Value{}, err
+			}
+			s, err := decodeModifiedUTF8(enc)
+			if err != nil {
+				return Value{}, err
+			}
+			return RefValue(s), nil
+		}
+		return Value{}, fmt.Errorf("ObjectInputStream.readObject: unsupported type code 0x%02X", tc)
+	case "read:()I":
+		b, err := vm.readBlockData(obj, 1)
+		if err != nil {
+			if javaExc, ok := err.(*JavaException); ok && javaExc.Object.ClassName == "java/io/EOFException" {
+				return IntValue(-1), nil
+			}
+			return Value{}, err
+		}
+		return IntValue(int32(b[0])), nil
+	case "readFully:([B)V", "readFully:([BII)V":
+		off, n := int32(0), int32(0)
+		if len(args) == 3 {
+			off, n = args[1].Int, args[2].Int
+		} else if arr, ok := args[0].Ref.(*JArray); ok {
+			n = int32(arr.Len())
+		}
+		arr, err := arrayRange(args[0], off, n)
+		if err != nil {
+			return Value{}, err
+		}
+		b, err := vm.readBlockData(obj, int(n))
+		if err != nil {
+			return Value{}, err
+		}
+		for i, c := range b {
+			arr.Set(int(off)+i, IntValue(int32(int8(c))))
+		}
+		return Value{}, nil
+	case "available:()I":
+		return obj.Fields["_blockRemaining"], nil
+	case "close:()V":
+		if cls := nativeStreamClassOf(in, ""); cls != "" {
+			return vm.handleNativeStream(cls, in, methodName, descriptor, nil)
+		}
+		return Value{}, nil
+	}
+	v, ok, err := decodeDataInput(methodName, func(n int) ([]byte, error) {
+		return vm.readBlockData(obj, n)
+	})
+	if !ok {
+		return Value{}, fmt.Errorf("ObjectInputStream: unsupported method %s:%s", methodName, descriptor)
+	}
+	return v, err
+}
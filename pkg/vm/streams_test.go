@@ -0,0 +1,288 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func newStreamObject(t *testing.T, v *VM, className, initDesc string, args ...Value) Value {
+	t.Helper()
+	ref := RefValue(&JObject{ClassName: className, Fields: make(map[string]Value)})
+	if _, err := v.handleNativeStream(className, ref, "<init>", initDesc, args); err != nil {
+		t.Fatalf("%s.<init>%s: %v", className, initDesc, err)
+	}
+	return ref
+}
+
+func TestByteArrayStreams(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+
+	t.Run("output stream collects bytes", func(t *testing.T) {
+		out := newStreamObject(t, v, "java/io/ByteArrayOutputStream", "()V")
+		v.handleNativeStream("java/io/ByteArrayOutputStream", out, "write", "(I)V", []Value{IntValue('h')})
+		src := goBytesToArray([]byte("xiy"))
+		v.handleNativeStream("java/io/ByteArrayOutputStream", out, "write", "([BII)V", []Value{RefValue(src), IntValue(1), IntValue(1)})
+
+		size, _ := v.handleNativeStream("java/io/ByteArrayOutputStream", out, "size", "()I", nil)
+		if size.Int != 2 {
+			t.Errorf("size: got %d, want 2", size.Int)
+		}
+		if got := v.valueToString(out); got != "hi" {
+			t.Errorf("toString: got %q, want %q", got, "hi")
+		}
+	})
+
+	t.Run("write out of range throws", func(t *testing.T) {
+		out := newStreamObject(t, v, "java/io/ByteArrayOutputStream", "()V")
+		src := goBytesToArray([]byte("ab"))
+		_, err := v.handleNativeStream("java/io/ByteArrayOutputStream", out, "write", "([BII)V", []Value{RefValue(src), IntValue(1), IntValue(5)})
+		javaExc, ok := err.(*JavaException)
+		if !ok || javaExc.Object.ClassName != "java/lang/IndexOutOfBoundsException" {
+			t.Errorf("expected IndexOutOfBoundsException, got %v", err)
+		}
+	})
+
+	t.Run("input stream reads then hits EOF", func(t *testing.T) {
+		in := newStreamObject(t, v, "java/io/ByteArrayInputStream", "([B)V", RefValue(goBytesToArray([]byte{0xFF, 7})))
+		want := []int32{255, 7, -1}
+		for i, w := range want {
+			got, err := v.handleNativeStream("java/io/ByteArrayInputStream", in, "read", "()I", nil)
+			if err != nil {
+				t.Fatalf("read %d: %v", i, err)
+			}
+			if got.Int != w {
+				t.Errorf("read %d: got %d, want %d", i, got.Int, w)
+			}
+		}
+	})
+
+	t.Run("input stream mark and reset", func(t *testing.T) {
+		in := newStreamObject(t, v, "java/io/ByteArrayInputStream", "([BII)V", RefValue(goBytesToArray([]byte("abcd"))), IntValue(1), IntValue(2))
+		v.handleNativeStream("java/io/ByteArrayInputStream", in, "mark", "(I)V", []Value{IntValue(0)})
+		buf := NewArray("B", 4)
+		n, _ := v.handleNativeStream("java/io/ByteArrayInputStream", in, "read", "([B)I", []Value{RefValue(buf)})
+		if n.Int != 2 || buf.Get(0).Int != 'b' || buf.Get(1).Int != 'c' {
+			t.Errorf("read([B): got n=%d %v", n.Int, buf.Bytes[:2])
+		}
+		v.handleNativeStream("java/io/ByteArrayInputStream", in, "reset", "()V", nil)
+		avail, _ := v.handleNativeStream("java/io/ByteArrayInputStream", in, "available", "()I", nil)
+		if avail.Int != 2 {
+			t.Errorf("available after reset: got %d, want 2", avail.Int)
+		}
+	})
+
+	t.Run("negative offset throws on read", func(t *testing.T) {
+		in := newStreamObject(t, v, "java/io/ByteArrayInputStream", "([BII)V", RefValue(goBytesToArray([]byte("abcd"))), IntValue(-1), IntValue(2))
+		for _, m := range []struct{ name, desc string }{{"read", "()I"}, {"readAllBytes", "()[B"}} {
+			_, err := v.handleNativeStream("java/io/ByteArrayInputStream", in, m.name, m.desc, nil)
+			if !isJavaException(err, "java/lang/ArrayIndexOutOfBoundsException") {
+				t.Errorf("%s: expected ArrayIndexOutOfBoundsException, got %v", m.name, err)
+			}
+		}
+	})
+}
+
+func TestNativeStreamSubclass(t *testing.T) {
+	// class Doubling extends ByteArrayOutputStream {
+	//     public void write(int b) { super.write(b); super.write(b); }
+	//     static String run() { OutputStream out = new Doubling(); out.write('x'); return out.toString(); }
+	// }
+	const baos = "java/io/ByteArrayOutputStream"
+	b := classfile.NewBuilder("app/Doubling", baos)
+	self := b.Class("app/Doubling")
+	init := b.Methodref("app/Doubling", "<init>", "()V")
+	superInit := b.Methodref(baos, "<init>", "()V")
+	superWrite := b.Methodref(baos, "write", "(I)V")
+	write := b.Methodref("java/io/OutputStream", "write", "(I)V")
+	toString := b.Methodref("java/lang/Object", "toString", "()Ljava/lang/String;")
+	b.AddMethod(classfile.AccPublic, "<init>", "()V", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpAload0, OpInvokespecial, byte(superInit >> 8), byte(superInit), OpReturn},
+	})
+	b.AddMethod(classfile.AccPublic, "write", "(I)V", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 2,
+		Code: []byte{
+			OpAload0, OpIload1, OpInvokespecial, byte(superWrite >> 8), byte(superWrite),
+			OpAload0, OpIload1, OpInvokespecial, byte(superWrite >> 8), byte(superWrite),
+			OpReturn,
+		},
+	})
+	b.AddMethod(classfile.AccStatic, "run", "()Ljava/lang/String;", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpNew, byte(self >> 8), byte(self), OpDup,
+			OpInvokespecial, byte(init >> 8), byte(init), OpAstore0,
+			OpAload0, OpBipush, 'x', OpInvokevirtual, byte(write >> 8), byte(write),
+			OpAload0, OpInvokevirtual, byte(toString >> 8), byte(toString),
+			OpAreturn,
+		},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"app/Doubling": cf})
+	ret, err := v.executeMethod(cf, cf.FindMethodByName("run"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.valueToString(ret); got != "xx" {
+		t.Errorf("overridden write: got %q, want %q", got, "xx")
+	}
+}
+
+func TestStringReaderWriter(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+
+	t.Run("reader returns UTF-16 units", func(t *testing.T) {
+		r := newStreamObject(t, v, "java/io/StringReader", "(Ljava/lang/String;)V", RefValue("aé"))
+		want := []int32{'a', 0xE9, -1}
+		for i, w := range want {
+			got, _ := v.handleNativeStream("java/io/StringReader", r, "read", "()I", nil)
+			if got.Int != w {
+				t.Errorf("read %d: got %d, want %d", i, got.Int, w)
+			}
+		}
+	})
+
+	t.Run("writer append chain and toString", func(t *testing.T) {
+		w := newStreamObject(t, v, "java/io/StringWriter", "()V")
+		ret, err := v.handleNativeStream("java/io/StringWriter", w, "append", "(Ljava/lang/CharSequence;)Ljava/io/StringWriter;", []Value{RefValue("ab")})
+		if err != nil {
+			t.Fatalf("append: %v", err)
+		}
+		if ret.Ref != w.Ref {
+			t.Error("append should return the receiver")
+		}
+		v.handleNativeStream("java/io/StringWriter", w, "write", "(I)V", []Value{IntValue('c')})
+		v.handleNativeStream("java/io/StringWriter", w, "append", "(Ljava/lang/CharSequence;)Ljava/io/Writer;", []Value{NullValue()})
+		if got := v.valueToString(w); got != "abcnull" {
+			t.Errorf("toString: got %q, want %q", got, "abcnull")
+		}
+	})
+
+	t.Run("writer rejects a negative size", func(t *testing.T) {
+		w := RefValue(&JObject{ClassName: "java/io/StringWriter", Fields: make(map[string]Value)})
+		_, err := v.handleNativeStream("java/io/StringWriter", w, "<init>", "(I)V", []Value{IntValue(-1)})
+		var exc *JavaException
+		if !errors.As(err, &exc) || exc.Object.ClassName != "java/lang/IllegalArgumentException" || exc.Object.Fields["detailMessage"].Ref != "Negative buffer size" {
+			t.Errorf("StringWriter(-1): got %v", err)
+		}
+	})
+}
+
+func TestOverridesStreamMethodIsCached(t *testing.T) {
+	b := classfile.NewBuilder("UpperWriter", "java/io/StringWriter")
+	b.AddMethod(classfile.AccPublic, "write", "(I)V", &classfile.CodeAttribute{Code: []byte{OpReturn}})
+	v := NewVM(mapClassLoader{"UpperWriter": b.Build()})
+	for _, loader := range []ClassLoader{v.ClassLoader, mapClassLoader{}} {
+		v.ClassLoader = loader // the second round must not load anything
+		if !v.overridesStreamMethod("UpperWriter", "java/io/StringWriter", "write", "(I)V") {
+			t.Error("write(I) should be overridden")
+		}
+		if v.overridesStreamMethod("UpperWriter", "java/io/StringWriter", "flush", "()V") {
+			t.Error("flush() should not be overridden")
+		}
+	}
+}
+
+func TestDataStreamsRoundTrip(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	const baos = "java/io/ByteArrayOutputStream"
+	const dos = "java/io/DataOutputStream"
+	const dis = "java/io/DataInputStream"
+
+	buf := newStreamObject(t, v, baos, "()V")
+	out := newStreamObject(t, v, dos, "(Ljava/io/OutputStream;)V", buf)
+	writes := []struct {
+		method, desc string
+		arg          Value
+	}{
+		{"writeInt", "(I)V", IntValue(-2)},
+		{"writeLong", "(J)V", LongValue(1 << 40)},
+		{"writeDouble", "(D)V", DoubleValue(2.5)},
+		{"writeBoolean", "(Z)V", IntValue(1)},
+		{"writeUTF", "(Ljava/lang/String;)V", RefValue("h\x00é")},
+		{"writeShort", "(I)V", IntValue(-3)},
+	}
+	for _, w := range writes {
+		if _, err := v.handleNativeStream(dos, out, w.method, w.desc, []Value{w.arg}); err != nil {
+			t.Fatalf("%s: %v", w.method, err)
+		}
+	}
+
+	size, _ := v.handleNativeStream(dos, out, "size", "()I", nil)
+	// 4 + 8 + 8 + 1 + (2 + 1 + 2 + 2) + 2
+	if size.Int != 30 {
+		t.Errorf("size: got %d, want 30", size.Int)
+	}
+
+	bytes, _ := v.handleNativeStream(baos, buf, "toByteArray", "()[B", nil)
+	in := newStreamObject(t, v, dis, "(Ljava/io/InputStream;)V",
+		newStreamObject(t, v, "java/io/ByteArrayInputStream", "([B)V", bytes))
+
+	check := func(method, desc string, want Value) {
+		t.Helper()
+		got, err := v.handleNativeStream(dis, in, method, desc, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		if got.Type != want.Type || got.Int != want.Int || got.Long != want.Long || got.Double != want.Double || got.Ref != want.Ref {
+			t.Errorf("%s: got %+v, want %+v", method, got, want)
+		}
+	}
+	check("readInt", "()I", IntValue(-2))
+	check("readLong", "()J", LongValue(1<<40))
+	check("readDouble", "()D", DoubleValue(2.5))
+	check("readBoolean", "()Z", IntValue(1))
+	check("readUTF", "()Ljava/lang/String;", RefValue("h\x00é"))
+	check("readUnsignedShort", "()I", IntValue(0xFFFD))
+
+	_, err := v.handleNativeStream(dis, in, "readByte", "()B", nil)
+	if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/io/EOFException" {
+		t.Errorf("read past end: expected EOFException, got %v", err)
+	}
+}
+
+func TestObjectStreamHeader(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	const baos = "java/io/ByteArrayOutputStream"
+	const oos = "java/io/ObjectOutputStream"
+	const ois = "java/io/ObjectInputStream"
+
+	buf := newStreamObject(t, v, baos, "()V")
+	out := newStreamObject(t, v, oos, "(Ljava/io/OutputStream;)V", buf)
+	v.handleNativeStream(oos, out, "writeInt", "(I)V", []Value{IntValue(7)})
+	v.handleNativeStream(oos, out, "writeObject", "(Ljava/lang/Object;)V", []Value{RefValue("hi")})
+	v.handleNativeStream(oos, out, "close", "()V", nil)
+
+	bytes, _ := v.handleNativeStream(baos, buf, "toByteArray", "()[B", nil)
+	arr := bytes.Ref.(*JArray)
+	want := []byte{0xAC, 0xED, 0x00, 0x05, 0x77, 0x04, 0, 0, 0, 7, 0x74, 0x00, 0x02, 'h', 'i'}
+	if got := byteArrayToGo(arr, 0, arr.Len()); string(got) != string(want) {
+		t.Fatalf("serialized bytes:\ngot  % X\nwant % X", got, want)
+	}
+
+	in := newStreamObject(t, v, ois, "(Ljava/io/InputStream;)V",
+		newStreamObject(t, v, "java/io/ByteArrayInputStream", "([B)V", bytes))
+	n, err := v.handleNativeStream(ois, in, "readInt", "()I", nil)
+	if err != nil || n.Int != 7 {
+		t.Errorf("readInt: got %d, %v", n.Int, err)
+	}
+	s, err := v.handleNativeStream(ois, in, "readObject", "()Ljava/lang/Object;", nil)
+	if err != nil || s.Ref != "hi" {
+		t.Errorf("readObject: got %v, %v", s.Ref, err)
+	}
+
+	t.Run("bad header", func(t *testing.T) {
+		src := newStreamObject(t, v, "java/io/ByteArrayInputStream", "([B)V", RefValue(goBytesToArray([]byte{0xCA, 0xFE, 0, 5})))
+		ref := RefValue(&JObject{ClassName: ois, Fields: make(map[string]Value)})
+		_, err := v.handleNativeStream(ois, ref, "<init>", "(Ljava/io/InputStream;)V", []Value{src})
+		if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/io/StreamCorruptedException" {
+			t.Errorf("expected StreamCorruptedException, got %v", err)
+		}
+	})
+}
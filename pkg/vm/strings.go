@@ -0,0 +1,400 @@
+package vm
+
+import (
+	"encoding/binary"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+	"unsafe"
+)
+
+// Java strings are sequences of UTF-16 chars, while the VM keeps them as
+// Go strings holding UTF-8. The functions in this file are the single
+// conversion layer between the two and between strings and byte arrays
+// in a named charset. String methods that index by char use the Go string
+// directly while it is ASCII, where bytes and chars coincide, and fall back
+// to a char view otherwise. Both are cached for long strings, so that a
+// loop calling charAt or length scans the string once, not on every call.
+
+// stringView is what a scan of a string found: whether it is ASCII, and
+// otherwise its UTF-16 chars, which callers must not modify.
+type stringView struct {
+	s     string
+	ascii bool
+	chars []uint16
+}
+
+// viewCacheMin is the shortest string whose view is cached; shorter ones
+// are cheaper to scan again than to look up.
+const viewCacheMin = 64
+
+// stringViews caches the views of recently scanned long strings. An entry
+// is found by the address and length of the string's bytes, which identify
+// the string while the entry keeps it alive.
+var stringViews [256]atomic.Pointer[stringView]
+
+// viewOf returns the view of s, from the cache if it holds one.
+func viewOf(s string) *stringView {
+	slot := &stringViews[(uintptr(unsafe.Pointer(unsafe.StringData(s)))>>4^uintptr(len(s)))%uintptr(len(stringViews))]
+	if v := slot.Load(); v != nil && len(v.s) == len(s) && unsafe.StringData(v.s) == unsafe.StringData(s) {
+		return v
+	}
+	v := &stringView{s: s, ascii: scanASCII(s)}
+	if !v.ascii {
+		v.chars = stringChars(s)
+	}
+	slot.Store(v)
+	return v
+}
+
+// scanASCII reports whether every byte of s is ASCII.
+func scanASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// isASCII reports whether every char of s is a single byte.
+func isASCII(s string) bool {
+	if len(s) < viewCacheMin {
+		return scanASCII(s)
+	}
+	return viewOf(s).ascii
+}
+
+// stringChars returns the UTF-16 chars of s in a new slice.
+func stringChars(s string) []uint16 {
+	return utf16.Encode([]rune(s))
+}
+
+// charsOf returns the UTF-16 chars of s like stringChars, but possibly
+// shared through the cache: callers must not modify them.
+func charsOf(s string) []uint16 {
+	if len(s) < viewCacheMin {
+		return stringChars(s)
+	}
+	if v := viewOf(s); !v.ascii {
+		return v.chars
+	}
+	return stringChars(s)
+}
+
+// charsString returns the string of UTF-16 chars. Unpaired surrogates
+// become U+FFFD, since a Go string cannot hold them.
+func charsString(chars []uint16) string {
+	return string(utf16.Decode(chars))
+}
+
+// charLength returns String.length() of s.
+func charLength(s string) int {
+	if isASCII(s) {
+		return len(s)
+	}
+	return len(charsOf(s))
+}
+
+// bytesCharLength returns the number of UTF-16 chars in UTF-8 bytes
+// without converting them to a string.
+func bytesCharLength(b []byte) int {
+	n := 0
+	for len(b) > 0 {
+		if b[0] < utf8.RuneSelf {
+			n++
+			b = b[1:]
+			continue
+		}
+		r, size := utf8.DecodeRune(b)
+		if r >= 0x10000 {
+			n += 2 // a surrogate pair
+		} else {
+			n++
+		}
+		b = b[size:]
+	}
+	return n
+}
+
+// charOffset converts a char index into UTF-8 bytes to a byte offset. An
+// index inside a surrogate pair, which b cannot split, maps past the pair.
+func charOffset(b []byte, index int) int {
+	n := 0
+	for i := 0; i < len(b); {
+		if n >= index {
+			return i
+		}
+		if b[i] < utf8.RuneSelf {
+			n++
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRune(b[i:])
+		if r >= 0x10000 {
+			n += 2
+		} else {
+			n++
+		}
+		i += size
+	}
+	return len(b)
+}
+
+// charIndex converts a byte offset into s to a char index. Negative
+// offsets, as returned by failed searches, are passed through.
+func charIndex(s string, byteOffset int) int {
+	if byteOffset < 0 || isASCII(s) || isASCII(s[:byteOffset]) {
+		return byteOffset
+	}
+	return len(stringChars(s[:byteOffset]))
+}
+
+// indexCodePoint returns String.indexOf(int) of s: the char index of the
+// first occurrence of the code point ch, which may also be a lone
+// surrogate matching half of a pair, or -1.
+func indexCodePoint(s string, ch int32) int {
+	if ch >= 0 && ch < utf8.RuneSelf {
+		return charIndex(s, strings.IndexByte(s, byte(ch)))
+	}
+	chars := stringChars(s)
+	if ch >= 0 && ch <= 0xFFFF {
+		for i, c := range chars {
+			if int32(c) == ch {
+				return i
+			}
+		}
+		return -1
+	}
+	if ch > utf8.MaxRune {
+		return -1
+	}
+	hi, lo := utf16.EncodeRune(rune(ch))
+	for i := 0; i+1 < len(chars); i++ {
+		if rune(chars[i]) == hi && rune(chars[i+1]) == lo {
+			return i
+		}
+	}
+	return -1
+}
+
+// lastIndexCodePoint returns String.lastIndexOf(int, int) of s: the char
+// index of the last occurrence of the code point ch at or before from, or
+// -1.
+func lastIndexCodePoint(s string, ch int32, from int) int {
+	chars := stringChars(s)
+	from = min(from, len(chars)-1)
+	if ch >= 0 && ch <= 0xFFFF {
+		for i := from; i >= 0; i-- {
+			if int32(chars[i]) == ch {
+				return i
+			}
+		}
+		return -1
+	}
+	if ch > utf8.MaxRune {
+		return -1
+	}
+	hi, lo := utf16.EncodeRune(rune(ch))
+	for i := min(from, len(chars)-2); i >= 0; i-- {
+		if rune(chars[i]) == hi && rune(chars[i+1]) == lo {
+			return i
+		}
+	}
+	return -1
+}
+
+// isJavaWhitespace reports Character.isWhitespace: Unicode space
+// separators other than the non-breaking ones, and the ASCII and
+// information separator controls.
+func isJavaWhitespace(r rune) bool {
+	switch r {
+	case '\u00A0', '\u2007', '\u202F':
+		return false
+	case '\t', '\n', '\v', '\f', '\r', 0x1C, 0x1D, 0x1E, 0x1F:
+		return true
+	}
+	return unicode.In(r, unicode.Zs, unicode.Zl, unicode.Zp)
+}
+
+// foldChar compares chars as String.regionMatches with ignoreCase does:
+// equal after upper-casing, or after lower-casing the upper case.
+func foldChar(c uint16) rune {
+	return unicode.ToLower(unicode.ToUpper(rune(c)))
+}
+
+// regionMatches implements String.regionMatches.
+func regionMatches(s string, ignoreCase bool, toffset int, other string, ooffset, length int) bool {
+	a, b := stringChars(s), stringChars(other)
+	if toffset < 0 || ooffset < 0 || toffset > len(a)-length || ooffset > len(b)-length {
+		return false
+	}
+	for i := 0; i < length; i++ {
+		c1, c2 := a[toffset+i], b[ooffset+i]
+		if c1 != c2 && (!ignoreCase || foldChar(c1) != foldChar(c2)) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareCharsIgnoreCase implements String.compareToIgnoreCase.
+func compareCharsIgnoreCase(a, b string) int {
+	ac, bc := stringChars(a), stringChars(b)
+	for i := 0; i < min(len(ac), len(bc)); i++ {
+		if c1, c2 := foldChar(ac[i]), foldChar(bc[i]); c1 != c2 {
+			return int(c1 - c2)
+		}
+	}
+	return len(ac) - len(bc)
+}
+
+// compareChars returns String.compareTo: the difference of the first
+// differing chars of a and b, or else of their lengths. Comparing by char
+// orders supplementary characters below U+E000..U+FFFF, unlike comparing
+// UTF-8 bytes.
+func compareChars(a, b string) int {
+	if isASCII(a) && isASCII(b) {
+		for i := 0; i < len(a) && i < len(b); i++ {
+			if a[i] != b[i] {
+				return int(a[i]) - int(b[i])
+			}
+		}
+		return len(a) - len(b)
+	}
+	ca, cb := stringChars(a), stringChars(b)
+	for i := 0; i < len(ca) && i < len(cb); i++ {
+		if ca[i] != cb[i] {
+			return int(ca[i]) - int(cb[i])
+		}
+	}
+	return len(ca) - len(cb)
+}
+
+// charArray returns a Java char[] holding the chars of s.
+func charArray(s string) *JArray {
+	return &JArray{Component: "C", Chars: stringChars(s)}
+}
+
+// arrayChars returns length chars of a Java char[] starting at off.
+func arrayChars(arr *JArray, off, length int) []uint16 {
+	chars := make([]uint16, length)
+	if arr.Component == "C" {
+		copy(chars, arr.Chars[off:])
+		return chars
+	}
+	for i := range chars {
+		chars[i] = uint16(arr.Get(off + i).Int)
+	}
+	return chars
+}
+
+// canonicalCharset returns the canonical name of a supported charset,
+// accepting the aliases Java accepts, case-insensitively.
+func canonicalCharset(name string) (string, bool) {
+	switch strings.ToUpper(strings.ReplaceAll(name, "_", "-")) {
+	case "UTF-8", "UTF8":
+		return "UTF-8", true
+	case "ISO-8859-1", "ISO8859-1", "LATIN1", "ISO-LATIN-1", "8859-1":
+		return "ISO-8859-1", true
+	case "US-ASCII", "ASCII":
+		return "US-ASCII", true
+	case "UTF-16", "UTF16":
+		return "UTF-16", true
+	case "UTF-16BE", "UNICODEBIGUNMARKED":
+		return "UTF-16BE", true
+	case "UTF-16LE", "UNICODELITTLEUNMARKED":
+		return "UTF-16LE", true
+	}
+	return "", false
+}
+
+// charsetName returns the name of a java.nio.charset.Charset object.
+func charsetName(charset Value) (string, bool) {
+	obj, ok := charset.Ref.(*JObject)
+	if !ok {
+		return "", false
+	}
+	return extractGoString(obj.Fields["name"])
+}
+
+// encodeString encodes s in a charset returned by canonicalCharset. Chars
+// the charset cannot represent are replaced by '?', as String.getBytes does.
+func encodeString(s, charset string) []byte {
+	switch charset {
+	case "UTF-8":
+		return []byte(s)
+	case "ISO-8859-1", "US-ASCII":
+		limit := rune(0xFF)
+		if charset == "US-ASCII" {
+			limit = 0x7F
+		}
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > limit {
+				r = '?'
+			}
+			b = append(b, byte(r))
+		}
+		return b
+	}
+	chars := stringChars(s)
+	b := make([]byte, 0, 2*len(chars)+2)
+	var order binary.AppendByteOrder = binary.BigEndian
+	switch charset {
+	case "UTF-16":
+		b = append(b, 0xFE, 0xFF) // big-endian byte order mark
+	case "UTF-16LE":
+		order = binary.LittleEndian
+	}
+	for _, c := range chars {
+		b = order.AppendUint16(b, c)
+	}
+	return b
+}
+
+// decodeString decodes b from a charset returned by canonicalCharset.
+// Malformed input is replaced by U+FFFD, as new String(byte[]) does.
+func decodeString(b []byte, charset string) string {
+	switch charset {
+	case "UTF-8":
+		if utf8.Valid(b) {
+			return string(b)
+		}
+		var sb strings.Builder
+		for len(b) > 0 {
+			r, n := utf8.DecodeRune(b)
+			sb.WriteRune(r) // RuneError for each malformed byte
+			b = b[n:]
+		}
+		return sb.String()
+	case "ISO-8859-1", "US-ASCII":
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+			if charset == "US-ASCII" && c > 0x7F {
+				runes[i] = utf8.RuneError
+			}
+		}
+		return string(runes)
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	switch {
+	case charset == "UTF-16LE":
+		order = binary.LittleEndian
+	case charset == "UTF-16" && len(b) >= 2 && b[0] == 0xFF && b[1] == 0xFE:
+		order, b = binary.LittleEndian, b[2:]
+	case charset == "UTF-16" && len(b) >= 2 && b[0] == 0xFE && b[1] == 0xFF:
+		b = b[2:]
+	}
+	chars := make([]uint16, 0, len(b)/2+1)
+	for i := 0; i+1 < len(b); i += 2 {
+		chars = append(chars, order.Uint16(b[i:]))
+	}
+	if len(b)%2 != 0 {
+		chars = append(chars, utf8.RuneError) // truncated last char
+	}
+	return charsString(chars)
+}
@@ -0,0 +1,299 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestCharsetRoundTrip(t *testing.T) {
+	for _, s := range []string{"", "plain ascii", "naïve café", "日本語", "emoji 😀 pair", "\x00nul"} {
+		for _, charset := range []string{"UTF-8", "UTF-16", "UTF-16BE", "UTF-16LE"} {
+			if got := decodeString(encodeString(s, charset), charset); got != s {
+				t.Errorf("%s round-trip of %q: got %q", charset, s, got)
+			}
+		}
+		if got := charsString(stringChars(s)); got != s {
+			t.Errorf("char round-trip of %q: got %q", s, got)
+		}
+	}
+
+	tests := []struct {
+		s, charset string
+		want       []byte
+	}{
+		{"é😀", "UTF-8", []byte{0xC3, 0xA9, 0xF0, 0x9F, 0x98, 0x80}},
+		{"é😀", "UTF-16", []byte{0xFE, 0xFF, 0x00, 0xE9, 0xD8, 0x3D, 0xDE, 0x00}},
+		{"é😀", "UTF-16LE", []byte{0xE9, 0x00, 0x3D, 0xD8, 0x00, 0xDE}},
+		{"é😀", "ISO-8859-1", []byte{0xE9, '?'}},
+		{"é😀", "US-ASCII", []byte{'?', '?'}},
+	}
+	for _, tt := range tests {
+		if got := encodeString(tt.s, tt.charset); !bytes.Equal(got, tt.want) {
+			t.Errorf("encode %q in %s: got % X, want % X", tt.s, tt.charset, got, tt.want)
+		}
+	}
+
+	if got := decodeString([]byte{'a', 0xFF, 'b'}, "UTF-8"); got != "a�b" {
+		t.Errorf("malformed UTF-8: got %q", got)
+	}
+	if got := decodeString([]byte{0xE9}, "ISO-8859-1"); got != "é" {
+		t.Errorf("Latin-1: got %q", got)
+	}
+	if got := decodeString([]byte{0xFF, 0xFE, 'h', 0, 'i', 0}, "UTF-16"); got != "hi" {
+		t.Errorf("UTF-16 with little-endian BOM: got %q", got)
+	}
+	if name, ok := canonicalCharset("utf8"); !ok || name != "UTF-8" {
+		t.Errorf("canonicalCharset(utf8) = %q, %v", name, ok)
+	}
+	if _, ok := canonicalCharset("EBCDIC"); ok {
+		t.Error("EBCDIC should be unsupported")
+	}
+}
+
+func TestStringMethodsUseChars(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	call := func(s, method, descriptor string, args ...Value) Value {
+		t.Helper()
+		got, err := v.handleStringMethod(s, method, descriptor, args)
+		if err != nil {
+			t.Fatalf("%s.%s: %v", s, method, err)
+		}
+		return got
+	}
+	s := "h€llo😀!" // € is one char, 😀 a surrogate pair
+
+	if got := call(s, "length", "()I").Int; got != 8 {
+		t.Errorf("length: got %d, want 8", got)
+	}
+	if got := call(s, "charAt", "(I)C", IntValue(1)).Int; got != 0x20AC {
+		t.Errorf("charAt(1): got %#x", got)
+	}
+	if got := call(s, "charAt", "(I)C", IntValue(6)).Int; got != 0xDE00 {
+		t.Errorf("charAt(6): got %#x, want low surrogate", got)
+	}
+	if got := call(s, "substring", "(II)Ljava/lang/String;", IntValue(1), IntValue(3)).Ref; got != "€l" {
+		t.Errorf("substring(1, 3): got %q", got)
+	}
+	if got := call(s, "substring", "(I)Ljava/lang/String;", IntValue(5)).Ref; got != "😀!" {
+		t.Errorf("substring(5): got %q", got)
+	}
+	if got := call(s, "indexOf", "(Ljava/lang/String;)I", RefValue("!")).Int; got != 7 {
+		t.Errorf("indexOf(!): got %d", got)
+	}
+	if got := call(s, "indexOf", "(I)I", IntValue('l')).Int; got != 2 {
+		t.Errorf("indexOf('l'): got %d", got)
+	}
+	if got := call(s, "indexOf", "(I)I", IntValue(0x1F600)).Int; got != 5 {
+		t.Errorf("indexOf(U+1F600): got %d, want 5", got)
+	}
+	if got := call(s, "indexOf", "(I)I", IntValue(0xDE00)).Int; got != 6 {
+		t.Errorf("indexOf(low surrogate): got %d, want 6", got)
+	}
+	if got := call(s, "indexOf", "(I)I", IntValue(0xFFFD)).Int; got != -1 {
+		t.Errorf("indexOf(U+FFFD): got %d, want -1", got)
+	}
+	if got := call(s, "replace", "(CC)Ljava/lang/String;", IntValue(0x20AC), IntValue('e')).Ref; got != "hello😀!" {
+		t.Errorf("replace('€', 'e'): got %q", got)
+	}
+	for _, tt := range []struct {
+		a, b string
+		want int32
+	}{
+		{"abc", "abd", -1},
+		{"ab", "abc", -1},
+		{"b", "a", 1},
+		{"a", "z", -25},
+		{"\uffff", "😀", 0xFFFF - 0xD83D}, // UTF-8 orders these the other way
+		{"h€", "h", 1},
+	} {
+		if got := call(tt.a, "compareTo", "(Ljava/lang/String;)I", RefValue(tt.b)).Int; got != tt.want {
+			t.Errorf("%q.compareTo(%q): got %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+	if got := call("😀", "hashCode", "()I").Int; got != 0xD83D*31+0xDE00 {
+		t.Errorf("hashCode: got %d", got)
+	}
+
+	chars := call(s, "toCharArray", "()[C").Ref.(*JArray)
+	if chars.Len() != 8 || chars.Get(5).Int != 0xD83D {
+		t.Errorf("toCharArray: got %+v", chars)
+	}
+	if got := charsString(arrayChars(chars, 0, chars.Len())); got != s {
+		t.Errorf("char[] round-trip: got %q", got)
+	}
+
+	utf8Bytes := call("é", "getBytes", "()[B").Ref.(*JArray)
+	if utf8Bytes.Len() != 2 || utf8Bytes.Get(0).Int != -61 {
+		t.Errorf("getBytes(): got %+v, want signed UTF-8 bytes", utf8Bytes)
+	}
+	latin1 := call("é", "getBytes", "(Ljava/lang/String;)[B", RefValue("ISO-8859-1")).Ref.(*JArray)
+	if latin1.Len() != 1 || latin1.Get(0).Int != -23 {
+		t.Errorf("getBytes(ISO-8859-1): got %+v", latin1)
+	}
+	charset := RefValue(&JObject{ClassName: "sun/nio/cs/UTF_16LE", Fields: map[string]Value{"name": RefValue("UTF-16LE")}})
+	if le := call("é", "getBytes", "(Ljava/nio/charset/Charset;)[B", charset).Ref.(*JArray); le.Len() != 2 {
+		t.Errorf("getBytes(UTF_16LE): got %+v", le)
+	}
+	_, err := v.handleStringMethod("x", "getBytes", "(Ljava/lang/String;)[B", []Value{RefValue("EBCDIC")})
+	if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/io/UnsupportedEncodingException" {
+		t.Errorf("unknown charset: got %v", err)
+	}
+}
+
+func TestExtractGoStringUTF16Coder(t *testing.T) {
+	// "a😀" as a JDK String with the UTF16 coder: little-endian char pairs.
+	raw := []byte{'a', 0, 0x3D, 0xD8, 0x00, 0xDE}
+	obj := &JObject{ClassName: "java/lang/String", Fields: map[string]Value{
+		"value": RefValue(goBytesToArray(raw)),
+		"coder": IntValue(1),
+	}}
+	if got, ok := extractGoString(RefValue(obj)); !ok || got != "a😀" {
+		t.Errorf("got %q, %v", got, ok)
+	}
+}
+
+func TestStringSearchAndCompare(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	call := func(s, method, descriptor string, args ...Value) Value {
+		t.Helper()
+		got, err := v.handleStringMethod(s, method, descriptor, args)
+		if err != nil {
+			t.Fatalf("%s.%s: %v", s, method, err)
+		}
+		return got
+	}
+	s := "a€a😀a€"
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+		want               int32
+	}{
+		{"indexOf", "(II)I", []Value{IntValue('a'), IntValue(1)}, 2},
+		{"indexOf", "(II)I", []Value{IntValue('a'), IntValue(-3)}, 0},
+		{"indexOf", "(II)I", []Value{IntValue('a'), IntValue(99)}, -1},
+		{"indexOf", "(Ljava/lang/String;I)I", []Value{RefValue("a€"), IntValue(1)}, 5},
+		{"indexOf", "(Ljava/lang/String;I)I", []Value{RefValue(""), IntValue(99)}, 7},
+		{"lastIndexOf", "(I)I", []Value{IntValue('a')}, 5},
+		{"lastIndexOf", "(II)I", []Value{IntValue('a'), IntValue(4)}, 2},
+		{"lastIndexOf", "(I)I", []Value{IntValue(0x1F600)}, 3},
+		{"lastIndexOf", "(Ljava/lang/String;)I", []Value{RefValue("a€")}, 5},
+		{"lastIndexOf", "(Ljava/lang/String;I)I", []Value{RefValue("a€"), IntValue(4)}, 0},
+		{"lastIndexOf", "(Ljava/lang/String;I)I", []Value{RefValue("a"), IntValue(-1)}, -1},
+		{"codePointAt", "(I)I", []Value{IntValue(3)}, 0x1F600},
+		{"codePointAt", "(I)I", []Value{IntValue(4)}, 0xDE00},
+		{"codePointCount", "(II)I", []Value{IntValue(0), IntValue(7)}, 6},
+		{"compareToIgnoreCase", "(Ljava/lang/String;)I", []Value{RefValue("A€A😀A€")}, 0},
+		{"compareToIgnoreCase", "(Ljava/lang/String;)I", []Value{RefValue("B")}, -1},
+	} {
+		if got := call(s, c.method, c.descriptor, c.args...).Int; got != c.want {
+			t.Errorf("%s%s%v: got %d, want %d", c.method, c.descriptor, c.args, got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		got  Value
+		want bool
+	}{
+		{call("Straße", "equalsIgnoreCase", "(Ljava/lang/Object;)Z", RefValue("STRAßE")), true},
+		{call("a", "equalsIgnoreCase", "(Ljava/lang/Object;)Z", NullValue()), false},
+		{call("Hello", "regionMatches", "(ILjava/lang/String;II)Z", IntValue(1), RefValue("jell"), IntValue(1), IntValue(3)), true},
+		{call("Hello", "regionMatches", "(ZILjava/lang/String;II)Z", IntValue(1), IntValue(0), RefValue("HE"), IntValue(0), IntValue(2)), true},
+		{call("Hello", "regionMatches", "(ILjava/lang/String;II)Z", IntValue(4), RefValue("oo"), IntValue(0), IntValue(2)), false},
+		{call("  \t", "isBlank", "()Z"), true},
+	} {
+		if (c.got.Int != 0) != c.want {
+			t.Errorf("got %v, want %v", c.got, c.want)
+		}
+	}
+
+	for _, c := range []struct {
+		got  Value
+		want string
+	}{
+		{call("  x  ", "strip", "()Ljava/lang/String;"), "x  "},
+		{call(" x ", "stripLeading", "()Ljava/lang/String;"), "x "},
+		{call(" x ", "stripTrailing", "()Ljava/lang/String;"), " x"},
+		{call("ab", "repeat", "(I)Ljava/lang/String;", IntValue(3)), "ababab"},
+		{call("ab", "concat", "(Ljava/lang/String;)Ljava/lang/String;", RefValue("c")), "abc"},
+	} {
+		if got, _ := extractGoString(c.got); got != c.want {
+			t.Errorf("got %q, want %q", got, c.want)
+		}
+	}
+
+	if _, err := v.handleStringMethod("x", "repeat", "(I)Ljava/lang/String;", []Value{IntValue(-1)}); !isJavaException(err, "java/lang/IllegalArgumentException") {
+		t.Errorf("repeat(-1): got %v", err)
+	}
+	if _, err := v.handleStringMethod("x", "codePointAt", "(I)I", []Value{IntValue(1)}); !isJavaException(err, "java/lang/StringIndexOutOfBoundsException") {
+		t.Errorf("codePointAt(1): got %v", err)
+	}
+}
+
+func TestStringJoinAndFormat(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	parts := NewArray("Ljava/lang/CharSequence;", 3)
+	parts.Elements[0], parts.Elements[2] = RefValue("a"), RefValue("c")
+	got, err := v.handleStringStatic("join", "(Ljava/lang/CharSequence;[Ljava/lang/CharSequence;)Ljava/lang/String;",
+		[]Value{RefValue(", "), RefValue(parts)})
+	if s, _ := extractGoString(got); err != nil || s != "a, null, c" {
+		t.Errorf("join: got %q, %v", s, err)
+	}
+
+	args := NewArray("Ljava/lang/Object;", 2)
+	args.Elements[0], args.Elements[1] = RefValue("x"), v.boxValue(IntValue(7))
+	got, err = v.handleStringStatic("format", "(Ljava/util/Locale;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/String;",
+		[]Value{NullValue(), RefValue("%s=%03d"), RefValue(args)})
+	if s, _ := extractGoString(got); err != nil || s != "x=007" {
+		t.Errorf("format: got %q, %v", s, err)
+	}
+	got, err = v.handleStringMethod("%s-%s", "formatted", "([Ljava/lang/Object;)Ljava/lang/String;", []Value{RefValue(args)})
+	if s, _ := extractGoString(got); err != nil || s != "x-7" {
+		t.Errorf("formatted: got %q, %v", s, err)
+	}
+}
+
+// Long strings index through a cached view, which must follow the string
+// and not just its bytes' address.
+func TestLongStringCharAt(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	s := strings.Repeat("a€😀", 40)
+	// s[:len(s)/2] starts at the same address as s.
+	for _, str := range []string{s, s[:len(s)/2], s[4:], strings.Repeat("x", 100)} {
+		chars := stringChars(str)
+		for i := range chars {
+			got, err := v.handleStringMethod(str, "charAt", "(I)C", []Value{IntValue(int32(i))})
+			if err != nil || got.Int != int32(chars[i]) {
+				t.Fatalf("charAt(%d) of %d bytes: got %d, %v, want %d", i, len(str), got.Int, err, chars[i])
+			}
+		}
+		if got := charLength(str); got != len(chars) {
+			t.Errorf("length of %d bytes: got %d, want %d", len(str), got, len(chars))
+		}
+	}
+}
+
+func BenchmarkStringCharAt(b *testing.B) {
+	v := &VM{Stdout: io.Discard}
+	for _, s := range []string{strings.Repeat("ascii text ", 1000), strings.Repeat("naïve café ", 1000)} {
+		n := charLength(s)
+		b.Run(fmt.Sprintf("String/ascii=%v", isASCII(s)), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < n; j++ {
+					v.handleStringMethod(s, "charAt", "(I)C", []Value{IntValue(int32(j))})
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("StringBuilder/ascii=%v", isASCII(s)), func(b *testing.B) {
+			sb := RefValue(&JObject{ClassName: "java/lang/StringBuilder", Fields: map[string]Value{}})
+			v.handleStringBuilder(sb, "<init>", "(Ljava/lang/String;)V", []Value{RefValue(s)})
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < n; j++ {
+					v.handleStringBuilder(sb, "charAt", "(I)C", []Value{IntValue(int32(j))})
+				}
+			}
+		})
+	}
+}
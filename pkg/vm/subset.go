@@ -0,0 +1,170 @@
+package vm
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// A self-contained gojvm binary cannot reasonably ship all of java.base,
+// so it embeds the part that programs can reach: ClassClosure finds it,
+// WriteSubset packs it into a jar and NewZipClassLoader loads from that.
+
+// RuntimeClasses are classes the VM names itself, mostly the exceptions it
+// throws, so programs can reach them without referring to them.
+var RuntimeClasses = []string{
+	"java/lang/Object",
+	"java/lang/String",
+	"java/lang/System",
+	"java/lang/Thread",
+	"java/lang/Throwable",
+	"java/io/EOFException",
+	"java/io/FileNotFoundException",
+	"java/io/IOException",
+	"java/io/OptionalDataException",
+	"java/io/StreamCorruptedException",
+	"java/io/UTFDataFormatException",
+	"java/io/UnsupportedEncodingException",
+	"java/lang/ArithmeticException",
+	"java/lang/ArrayIndexOutOfBoundsException",
+	"java/lang/ArrayStoreException",
+	"java/lang/ClassCastException",
+	"java/lang/ExceptionInInitializerError",
+	"java/lang/IllegalArgumentException",
+	"java/lang/IllegalCallerException",
+	"java/lang/IllegalMonitorStateException",
+	"java/lang/IllegalStateException",
+	"java/lang/IncompatibleClassChangeError",
+	"java/lang/IndexOutOfBoundsException",
+	"java/lang/InstantiationException",
+	"java/lang/InternalError",
+	"java/lang/NegativeArraySizeException",
+	"java/lang/NoClassDefFoundError",
+	"java/lang/NullPointerException",
+	"java/lang/NumberFormatException",
+	"java/lang/OutOfMemoryError",
+	"java/lang/SecurityException",
+	"java/lang/StackOverflowError",
+	"java/lang/StringIndexOutOfBoundsException",
+	"java/lang/UnsupportedClassVersionError",
+	"java/lang/UnsupportedOperationException",
+	"java/lang/VerifyError",
+	"java/nio/charset/MalformedInputException",
+	"java/nio/charset/UnsupportedCharsetException",
+	"java/nio/file/AccessDeniedException",
+	"java/nio/file/DirectoryNotEmptyException",
+	"java/nio/file/FileAlreadyExistsException",
+	"java/nio/file/FileSystemException",
+	"java/nio/file/NoSuchFileException",
+	"java/nio/file/NotDirectoryException",
+	"java/text/ParseException",
+	"java/util/FormatFlagsConversionMismatchException",
+	"java/util/IllegalFormatCodePointException",
+	"java/util/IllegalFormatConversionException",
+	"java/util/IllegalFormatPrecisionException",
+	"java/util/MissingFormatArgumentException",
+	"java/util/MissingFormatWidthException",
+	"java/util/NoSuchElementException",
+	"java/util/UnknownFormatConversionException",
+	"java/util/regex/PatternSyntaxException",
+}
+
+// ClassClosure returns the sorted names of the roots and of every class
+// they reach through constant pool references, which include superclasses
+// and interfaces, and through field and method descriptors. Array classes
+// contribute their element class. Classes the loader cannot
+// find are left out, since code may name classes it never uses.
+func ClassClosure(loader ClassLoader, roots ...string) []string {
+	seen := make(map[string]bool)
+	var found []string
+	queue := append([]string(nil), roots...)
+	for len(queue) > 0 {
+		name := strings.TrimLeft(queue[0], "[")
+		queue = queue[1:]
+		if strings.HasPrefix(name, "L") && strings.HasSuffix(name, ";") {
+			name = name[1 : len(name)-1]
+		}
+		if len(name) <= 1 || seen[name] { // primitive array elements are one letter
+			continue
+		}
+		seen[name] = true
+		cf, err := loader.LoadClass(name)
+		if err != nil {
+			continue
+		}
+		found = append(found, name)
+		for _, c := range cf.ConstantPool {
+			switch c := c.(type) {
+			case *classfile.ConstantClass:
+				if ref, err := classfile.GetUtf8(cf.ConstantPool, c.NameIndex); err == nil {
+					queue = append(queue, ref)
+				}
+			case *classfile.ConstantNameAndType:
+				if desc, err := classfile.GetUtf8(cf.ConstantPool, c.DescriptorIndex); err == nil {
+					queue = append(queue, descriptorClasses(desc)...)
+				}
+			case *classfile.ConstantMethodType:
+				if desc, err := classfile.GetUtf8(cf.ConstantPool, c.DescriptorIndex); err == nil {
+					queue = append(queue, descriptorClasses(desc)...)
+				}
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// descriptorClasses returns the class names in a field or method descriptor.
+func descriptorClasses(desc string) []string {
+	var out []string
+	for {
+		start := strings.IndexByte(desc, 'L')
+		if start < 0 {
+			return out
+		}
+		end := strings.IndexByte(desc[start:], ';')
+		if end < 0 {
+			return out
+		}
+		out = append(out, desc[start+1:start+end])
+		desc = desc[start+end+1:]
+	}
+}
+
+// WriteSubset writes a jar holding the named classes of the jmod, as
+// NewZipClassLoader expects. Entries are copied without recompressing.
+func (cl *JmodClassLoader) WriteSubset(w io.Writer, names []string) error {
+	if err := cl.ensureZipReader(); err != nil {
+		return err
+	}
+	entries := make(map[string]*zip.File, len(cl.zipReader.File))
+	for _, f := range cl.zipReader.File {
+		entries[f.Name] = f
+	}
+
+	zw := zip.NewWriter(w)
+	for _, name := range names {
+		f, ok := entries["classes/"+name+".class"]
+		if !ok {
+			return fmt.Errorf("jmod: class %s not found in %s", name, cl.JmodPath)
+		}
+		header := f.FileHeader
+		header.Name = name + ".class"
+		dst, err := zw.CreateRaw(&header)
+		if err != nil {
+			return err
+		}
+		src, err := f.OpenRaw()
+		if err != nil {
+			return fmt.Errorf("jmod: opening %s: %w", f.Name, err)
+		}
+		if _, err := io.Copy(dst, src); err != nil {
+			return fmt.Errorf("jmod: copying %s: %w", f.Name, err)
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestClassClosure(t *testing.T) {
+	classes := mapClassLoader{}
+	for _, name := range []string{"java/lang/Object", "lib/Param", "lib/Element", "lib/Iface", "lib/Unused"} {
+		classes[name] = classfile.NewBuilder(name, "java/lang/Object").Build()
+	}
+	classes["java/lang/Object"] = classfile.NewBuilder("java/lang/Object", "").Build()
+
+	base := classfile.NewBuilder("lib/Base", "java/lang/Object")
+	base.Class("lib/Missing") // named but never loadable
+	classes["lib/Base"] = base.Build()
+
+	b := classfile.NewBuilder("app/Main", "lib/Base")
+	b.AddInterface("lib/Iface")
+	b.Class("[[Llib/Element;")
+	b.Class("[I")
+	b.Methodref("lib/Base", "m", "(ILlib/Param;)[Ljava/lang/Object;")
+	classes["app/Main"] = b.Build()
+
+	got := ClassClosure(classes, "app/Main")
+	want := []string{"app/Main", "java/lang/Object", "lib/Base", "lib/Element", "lib/Iface", "lib/Param"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestWriteSubsetAndZipClassLoader(t *testing.T) {
+	// A jmod is a zip with a four-byte header and classes under classes/.
+	var jmod bytes.Buffer
+	jmod.WriteString("JM\x01\x00")
+	zw := zip.NewWriter(&jmod)
+	for _, name := range []string{"java/lang/Object", "java/lang/String", "java/util/List"} {
+		data, err := classfile.NewBuilder(name, "java/lang/Object").Build().Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := zw.Create("classes/" + name + ".class")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "java.base.jmod")
+	if err := os.WriteFile(path, jmod.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var jar bytes.Buffer
+	if err := NewJmodClassLoader(path).WriteSubset(&jar, []string{"java/lang/Object", "java/lang/String"}); err != nil {
+		t.Fatal(err)
+	}
+	cl, err := NewZipClassLoader(jar.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Len() != 2 {
+		t.Errorf("Len: got %d, want 2", cl.Len())
+	}
+	cf, err := cl.LoadClass("java/lang/String")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := cf.ClassName(); name != "java/lang/String" {
+		t.Errorf("loaded %s", name)
+	}
+	if again, _ := cl.LoadClass("java/lang/String"); again != cf {
+		t.Error("second load was not cached")
+	}
+	if _, err := cl.LoadClass("java/util/List"); err == nil {
+		t.Error("class outside the subset was loaded")
+	}
+
+	if err := NewJmodClassLoader(path).WriteSubset(&jar, []string{"java/lang/Missing"}); err == nil {
+		t.Error("missing class: expected an error")
+	}
+}
+
+// Every exception the VM throws by name must be embedded with java.base.
+func TestRuntimeClassesCoverThrownExceptions(t *testing.T) {
+	listed := make(map[string]bool)
+	for _, name := range RuntimeClasses {
+		listed[name] = true
+	}
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var missing []string
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		src, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, call := range []string{`NewJavaException("`, `NewJavaExceptionMessage("`} {
+			for _, part := range strings.Split(string(src), call)[1:] {
+				name, _, _ := strings.Cut(part, `"`)
+				// Names built at run time, such as "java/util/"+class,
+				// are checked where their parts are listed.
+				if !listed[name] && !strings.HasSuffix(name, "/") {
+					missing = append(missing, name+" ("+file+")")
+				}
+			}
+		}
+	}
+	sort.Strings(missing)
+	if len(missing) > 0 {
+		t.Errorf("not in RuntimeClasses: %v", missing)
+	}
+}
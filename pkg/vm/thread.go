@@ -0,0 +1,56 @@
+package vm
+
+// The VM runs everything on a single Java thread. Its Thread object and
+// the application class loader object are created on first use so that
+// repeated calls observe the same identities.
+
+// appClassLoaderClass is the class of the object returned as the system
+// (application) class loader.
+const appClassLoaderClass = "jdk/internal/loader/ClassLoaders$AppClassLoader"
+
+// currentThread returns the Thread object of the main thread.
+func (vm *VM) currentThread() Value {
+	if vm.mainThread == nil {
+		vm.mainThread = &JObject{
+			ClassName: "java/lang/Thread",
+			Fields: map[string]Value{
+				"name":               RefValue("main"),
+				"contextClassLoader": vm.appClassLoader(),
+			},
+		}
+	}
+	return RefValue(vm.mainThread)
+}
+
+// appClassLoader returns the object standing in for the application class
+// loader, which loads user classes through vm.ClassLoader.
+func (vm *VM) appClassLoader() Value {
+	if vm.appLoader == nil {
+		vm.appLoader = &JObject{ClassName: appClassLoaderClass, Fields: make(map[string]Value)}
+	}
+	return RefValue(vm.appLoader)
+}
+
+// handleThreadMethod handles Thread instance methods that must work even
+// when the JDK's Thread class cannot be executed. It reports false for
+// methods it does not handle.
+func (vm *VM) handleThreadMethod(thread *JObject, methodName, descriptor string, args []Value) (Value, bool) {
+	switch methodName + ":" + descriptor {
+	case "getContextClassLoader:()Ljava/lang/ClassLoader;":
+		loader, ok := thread.Fields["contextClassLoader"]
+		if !ok {
+			return vm.appClassLoader(), true
+		}
+		return loader, true
+	case "setContextClassLoader:(Ljava/lang/ClassLoader;)V":
+		thread.Fields["contextClassLoader"] = args[0]
+		return Value{}, true
+	case "getStackTrace:()[Ljava/lang/StackTraceElement;":
+		return vm.threadStackTrace(), true
+	case "getName:()Ljava/lang/String;":
+		if name, ok := thread.Fields["name"]; ok {
+			return name, true
+		}
+	}
+	return Value{}, false
+}
@@ -0,0 +1,38 @@
+package vm
+
+import (
+	"io"
+	"testing"
+)
+
+func TestThreadContextClassLoader(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+
+	thread, err := v.executeNativeMethod("java/lang/Thread", "currentThread", "()Ljava/lang/Thread;", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := v.executeNativeMethod("java/lang/Thread", "currentThread", "()Ljava/lang/Thread;", nil)
+	if thread.Ref != again.Ref {
+		t.Error("currentThread should return the same Thread object")
+	}
+
+	obj := thread.Ref.(*JObject)
+	loader, ok := v.handleThreadMethod(obj, "getContextClassLoader", "()Ljava/lang/ClassLoader;", nil)
+	if !ok || loader.Ref != v.appClassLoader().Ref {
+		t.Errorf("default context loader: got %v, want the application loader", loader.Ref)
+	}
+
+	custom := RefValue(&JObject{ClassName: "MyLoader", Fields: map[string]Value{}})
+	v.handleThreadMethod(obj, "setContextClassLoader", "(Ljava/lang/ClassLoader;)V", []Value{custom})
+	loader, _ = v.handleThreadMethod(obj, "getContextClassLoader", "()Ljava/lang/ClassLoader;", nil)
+	if loader.Ref != custom.Ref {
+		t.Errorf("after set: got %v, want the custom loader", loader.Ref)
+	}
+
+	v.handleThreadMethod(obj, "setContextClassLoader", "(Ljava/lang/ClassLoader;)V", []Value{NullValue()})
+	loader, _ = v.handleThreadMethod(obj, "getContextClassLoader", "()Ljava/lang/ClassLoader;", nil)
+	if loader.Type != TypeNull {
+		t.Errorf("after set(null): got %+v, want null", loader)
+	}
+}
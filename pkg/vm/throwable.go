@@ -0,0 +1,196 @@
+package vm
+
+import (
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Exceptions keep their message in the detailMessage field, as
+// java.lang.Throwable does, so that messages set by the VM and by Java
+// code look the same. The JDK's Throwable cannot always run, and without
+// the JDK its classes cannot even be loaded, so its standard constructors
+// and the methods for its message, cause and suppressed exceptions are
+// implemented natively.
+// They are used where method resolution ends in java.lang.Throwable or
+// fails, so subclasses that override getMessage or toString still run
+// their own code. The constructors record the stack trace, as
+// fillInStackTrace does.
+
+// isThrowable reports whether className is java.lang.Throwable or a
+// subclass.
+func (vm *VM) isThrowable(className string) bool {
+	return vm.extendsJavaClass(className, "java/lang/Throwable", "Exception", "Error")
+}
+
+// isError reports whether className is java.lang.Error or a subclass.
+func (vm *VM) isError(className string) bool {
+	return vm.extendsJavaClass(className, "java/lang/Error", "Error")
+}
+
+// extendsJavaClass reports whether className is the JDK class base or a
+// subclass. Classes whose superclass chain cannot be loaded are taken to
+// extend base if the chain reaches a java.* class with one of the name
+// suffixes of base's subclasses.
+func (vm *VM) extendsJavaClass(className, base string, suffixes ...string) bool {
+	for current := className; current != ""; {
+		if current == base {
+			return true
+		}
+		cf, err := vm.ClassLoader.LoadClass(current)
+		if err != nil {
+			if !strings.HasPrefix(current, "java/") {
+				return false
+			}
+			for _, suffix := range suffixes {
+				if strings.HasSuffix(current, suffix) {
+					return true
+				}
+			}
+			return false
+		}
+		current = cf.SuperClassName()
+	}
+	return false
+}
+
+// throwableNative reports whether a call on an object of class className
+// that resolved to cf, or failed to resolve with err, should be handled by
+// handleThrowableMethod.
+func (vm *VM) throwableNative(className string, cf *classfile.ClassFile, err error) bool {
+	if err == nil {
+		if name, _ := cf.ClassName(); name != "java/lang/Throwable" {
+			return false
+		}
+	}
+	return vm.isThrowable(className)
+}
+
+// handleThrowableMethod handles the Throwable constructors and methods
+// that concern its message, cause and suppressed exceptions. It reports
+// false for methods it does not handle.
+func (vm *VM) handleThrowableMethod(exc *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName + ":" + descriptor {
+	case "<init>:()V":
+		vm.fillInStackTrace(exc)
+		return Value{}, true, nil
+	case "<init>:(Ljava/lang/String;)V":
+		vm.fillInStackTrace(exc)
+		exc.Fields["detailMessage"] = args[0]
+		return Value{}, true, nil
+	case "<init>:(Ljava/lang/String;Ljava/lang/Throwable;)V":
+		vm.fillInStackTrace(exc)
+		exc.Fields["detailMessage"] = args[0]
+		exc.Fields["cause"] = args[1]
+		return Value{}, true, nil
+	case "<init>:(Ljava/lang/String;Ljava/lang/Throwable;ZZ)V":
+		// Throwable(message, cause, enableSuppression, writableStackTrace)
+		if args[3].Int != 0 {
+			vm.fillInStackTrace(exc)
+		}
+		if args[2].Int == 0 {
+			exc.Fields["_suppressionDisabled"] = IntValue(1)
+		}
+		exc.Fields["detailMessage"] = args[0]
+		exc.Fields["cause"] = args[1]
+		return Value{}, true, nil
+	case "<init>:(Ljava/lang/Throwable;)V":
+		// The message of Throwable(cause) is cause.toString()
+		vm.fillInStackTrace(exc)
+		exc.Fields["cause"] = args[0]
+		if args[0].Type == TypeRef && args[0].Ref != nil {
+			exc.Fields["detailMessage"] = RefValue(vm.valueToString(args[0]))
+		}
+		return Value{}, true, nil
+	case "getCause:()Ljava/lang/Throwable;":
+		if cause := causeOf(exc); cause != nil {
+			return RefValue(cause), true, nil
+		}
+		return NullValue(), true, nil
+	case "initCause:(Ljava/lang/Throwable;)Ljava/lang/Throwable;":
+		// The cause can be set once, by a constructor or by initCause
+		if cause, set := exc.Fields["cause"]; set && cause.Ref != exc {
+			with := "a null"
+			if args[0].Type == TypeRef && args[0].Ref != nil {
+				with = vm.valueToString(args[0])
+			}
+			failure := NewJavaExceptionMessage("java/lang/IllegalStateException", "Can't overwrite cause with "+with)
+			failure.Object.Fields["cause"] = RefValue(exc)
+			return Value{}, true, failure
+		}
+		if args[0].Ref == exc {
+			failure := NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Self-causation not permitted")
+			failure.Object.Fields["cause"] = RefValue(exc)
+			return Value{}, true, failure
+		}
+		exc.Fields["cause"] = args[0]
+		return RefValue(exc), true, nil
+	case "addSuppressed:(Ljava/lang/Throwable;)V":
+		suppressed, ok := args[0].Ref.(*JObject)
+		switch {
+		case suppressed == exc:
+			failure := NewJavaExceptionMessage("java/lang/IllegalArgumentException", "Self-suppression not permitted")
+			failure.Object.Fields["cause"] = RefValue(exc)
+			return Value{}, true, failure
+		case !ok || args[0].Type != TypeRef:
+			return Value{}, true, NewJavaExceptionMessage("java/lang/NullPointerException", "Cannot suppress a null exception.")
+		}
+		if _, disabled := exc.Fields["_suppressionDisabled"]; !disabled {
+			exc.Fields["_suppressed"] = RefValue(append(suppressedOf(exc), suppressed))
+		}
+		return Value{}, true, nil
+	case "getSuppressed:()[Ljava/lang/Throwable;":
+		list := suppressedOf(exc)
+		arr := NewArray("Ljava/lang/Throwable;", len(list))
+		for i, s := range list {
+			arr.Elements[i] = RefValue(s)
+		}
+		return RefValue(arr), true, nil
+	case "getMessage:()Ljava/lang/String;":
+		return detailMessage(exc), true, nil
+	case "getLocalizedMessage:()Ljava/lang/String;":
+		msg, err := vm.callThrowable(exc, "getMessage")
+		return msg, true, err
+	case "toString:()Ljava/lang/String;":
+		s, err := vm.throwableString(exc)
+		return RefValue(s), true, err
+	}
+	return Value{}, false, nil
+}
+
+// detailMessage returns the message of exc, or null.
+func detailMessage(exc *JObject) Value {
+	if msg, ok := exc.Fields["detailMessage"]; ok {
+		return msg
+	}
+	return NullValue()
+}
+
+// callThrowable calls the String method methodName on exc, running an
+// override if its class has one.
+func (vm *VM) callThrowable(exc *JObject, methodName string) (Value, error) {
+	const descriptor = "()Ljava/lang/String;"
+	cf, method, err := vm.resolveMethod(exc.ClassName, methodName, descriptor)
+	if vm.throwableNative(exc.ClassName, cf, err) {
+		ret, _, err := vm.handleThrowableMethod(exc, methodName, descriptor, nil)
+		return ret, err
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	return vm.executeMethod(cf, method, []Value{RefValue(exc)})
+}
+
+// throwableString formats exc as Throwable.toString does: its class name,
+// followed by its localized message if it has one.
+func (vm *VM) throwableString(exc *JObject) (string, error) {
+	name := strings.ReplaceAll(exc.ClassName, "/", ".")
+	msg, err := vm.callThrowable(exc, "getLocalizedMessage")
+	if err != nil {
+		return "", err
+	}
+	if s, ok := extractGoString(msg); ok {
+		return name + ": " + s, nil
+	}
+	return name, nil
+}
@@ -0,0 +1,404 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// throwableClasses builds AppException, a RuntimeException with a
+// message constructor; Custom, an Exception overriding getMessage; and
+// Main, whose static methods construct, query and throw them. The JDK's
+// classes are not available, so Throwable runs natively.
+func throwableClasses() mapClassLoader {
+	op := func(code byte, index uint16) []byte { return []byte{code, byte(index >> 8), byte(index)} }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	app := classfile.NewBuilder("AppException", "java/lang/RuntimeException")
+	super := app.Methodref("java/lang/RuntimeException", "<init>", "(Ljava/lang/String;)V")
+	app.AddMethod(classfile.AccPublic, "<init>", "(Ljava/lang/String;)V", &classfile.CodeAttribute{
+		MaxStack: 2, MaxLocals: 2,
+		Code: cat([]byte{OpAload0, OpAload1}, op(OpInvokespecial, super), []byte{OpReturn}),
+	})
+
+	custom := classfile.NewBuilder("Custom", "java/lang/Exception")
+	super = custom.Methodref("java/lang/Exception", "<init>", "()V")
+	custom.AddMethod(classfile.AccPublic, "<init>", "()V", &classfile.CodeAttribute{
+		MaxStack: 1, MaxLocals: 1,
+		Code: cat([]byte{OpAload0}, op(OpInvokespecial, super), []byte{OpReturn}),
+	})
+	custom.AddMethod(classfile.AccPublic, "getMessage", "()Ljava/lang/String;", &classfile.CodeAttribute{
+		MaxStack: 1, MaxLocals: 1,
+		Code: cat(op(OpLdcW, custom.String("custom")), []byte{OpAreturn}),
+	})
+
+	b := classfile.NewBuilder("Main", "java/lang/Object")
+	newApp := cat(op(OpNew, b.Class("AppException")), []byte{OpDup}, op(OpLdcW, b.String("boom")),
+		op(OpInvokespecial, b.Methodref("AppException", "<init>", "(Ljava/lang/String;)V")))
+	newCustom := cat(op(OpNew, b.Class("Custom")), []byte{OpDup},
+		op(OpInvokespecial, b.Methodref("Custom", "<init>", "()V")))
+	call := func(class, name string) []byte {
+		return op(OpInvokevirtual, b.Methodref(class, name, "()Ljava/lang/String;"))
+	}
+	methods := map[string][]byte{
+		"message":   cat(newApp, call("AppException", "getMessage")),
+		"localized": cat(newApp, call("java/lang/Throwable", "getLocalizedMessage")),
+		"string":    cat(newApp, call("java/lang/Object", "toString")),
+		"noMessage": cat(op(OpNew, b.Class("java/lang/IllegalStateException")), []byte{OpDup},
+			op(OpInvokespecial, b.Methodref("java/lang/IllegalStateException", "<init>", "()V")),
+			call("java/lang/IllegalStateException", "getMessage")),
+		"wrapped": cat(op(OpNew, b.Class("java/lang/RuntimeException")), []byte{OpDup}, newApp,
+			op(OpInvokespecial, b.Methodref("java/lang/RuntimeException", "<init>", "(Ljava/lang/Throwable;)V")),
+			call("java/lang/RuntimeException", "getMessage")),
+		"custom":      cat(newCustom, call("Custom", "toString")),
+		"customLocal": cat(newCustom, call("Custom", "getLocalizedMessage")),
+	}
+	for name, code := range methods {
+		b.AddMethod(classfile.AccStatic, name, "()Ljava/lang/String;", &classfile.CodeAttribute{
+			MaxStack: 5, Code: append(code, OpAreturn),
+		})
+	}
+	b.AddMethod(classfile.AccStatic, "fail", "()V", &classfile.CodeAttribute{
+		MaxStack: 3, Code: append(newApp, OpAthrow),
+	})
+	b.AddMethod(classfile.AccStatic, "failCustom", "()V", &classfile.CodeAttribute{
+		MaxStack: 2, Code: append(newCustom, OpAthrow),
+	})
+	return mapClassLoader{"Main": b.Build(), "AppException": app.Build(), "Custom": custom.Build()}
+}
+
+func TestThrowableMessages(t *testing.T) {
+	classes := throwableClasses()
+	cf := classes["Main"]
+	tests := []struct {
+		method string
+		want   string // "null" for a null message
+	}{
+		{"message", "boom"},
+		{"localized", "boom"},
+		{"string", "AppException: boom"},
+		{"noMessage", "null"},
+		{"wrapped", "AppException: boom"},
+		{"custom", "Custom: custom"},
+		{"customLocal", "custom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			v := NewVM(classes)
+			got, err := v.executeMethod(cf, cf.FindMethodByName(tt.method), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s := v.valueToString(got); s != tt.want {
+				t.Errorf("got %q, want %q", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestUncaughtExceptionMessage(t *testing.T) {
+	classes := throwableClasses()
+	cf := classes["Main"]
+	_, err := NewVM(classes).executeMethod(cf, cf.FindMethodByName("fail"), nil)
+	exc, ok := err.(*JavaException)
+	if !ok {
+		t.Fatalf("got %v, want AppException", err)
+	}
+	if msg, ok := exc.Message(); !ok || msg != "boom" {
+		t.Errorf("Message: got %q, %v", msg, ok)
+	}
+	if got := exc.Error(); got != "JavaException: AppException: boom" {
+		t.Errorf("Error: got %q", got)
+	}
+	var buf bytes.Buffer
+	exc.PrintStackTrace(&buf)
+	if first, _, _ := strings.Cut(buf.String(), "\n"); first != `Exception in thread "main" AppException: boom` {
+		t.Errorf("PrintStackTrace: got %q", first)
+	}
+}
+
+// causeClasses adds Chain to throwableClasses. Chain.main calls chain,
+// which wraps the AppException thrown by inner in a RuntimeException; the
+// other methods use initCause.
+func causeClasses() mapClassLoader {
+	classes := throwableClasses()
+	op := func(code byte, index uint16) []byte { return []byte{code, byte(index >> 8), byte(index)} }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	b := classfile.NewBuilder("Chain", "java/lang/Object")
+	newApp := cat(op(OpNew, b.Class("AppException")), []byte{OpDup}, op(OpLdcW, b.String("boom")),
+		op(OpInvokespecial, b.Methodref("AppException", "<init>", "(Ljava/lang/String;)V")))
+	newISE := func(init string, args ...byte) []byte {
+		return cat(op(OpNew, b.Class("java/lang/IllegalStateException")), []byte{OpDup}, args,
+			op(OpInvokespecial, b.Methodref("java/lang/IllegalStateException", "<init>", init)))
+	}
+	initCause := op(OpInvokevirtual, b.Methodref("java/lang/Throwable", "initCause", "(Ljava/lang/Throwable;)Ljava/lang/Throwable;"))
+
+	b.AddMethod(classfile.AccStatic, "main", "()V", &classfile.CodeAttribute{
+		Code:        cat(op(OpInvokestatic, b.Methodref("Chain", "chain", "()V")), []byte{OpReturn}),
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 20}},
+	})
+	b.AddMethod(classfile.AccStatic, "chain", "()V", &classfile.CodeAttribute{
+		MaxStack: 4, MaxLocals: 1,
+		Code: cat(
+			op(OpInvokestatic, b.Methodref("Chain", "inner", "()V")), []byte{OpReturn}, // 0: inner(); return
+			[]byte{OpAstore0}, op(OpNew, b.Class("java/lang/RuntimeException")), []byte{OpDup}, // 4: catch (AppException e)
+			op(OpLdcW, b.String("wrapped")), []byte{OpAload0}, // throw new RuntimeException("wrapped", e)
+			op(OpInvokespecial, b.Methodref("java/lang/RuntimeException", "<init>", "(Ljava/lang/String;Ljava/lang/Throwable;)V")),
+			[]byte{OpAthrow},
+		),
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 3, HandlerPC: 4, CatchType: b.Class("AppException")}},
+		LineNumbers:       []classfile.LineNumber{{StartPC: 0, Line: 10}, {StartPC: 4, Line: 11}},
+	})
+	b.AddMethod(classfile.AccStatic, "inner", "()V", &classfile.CodeAttribute{
+		MaxStack: 3, Code: append(newApp, OpAthrow),
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 3}},
+	})
+	// new IllegalStateException("outer").initCause(new AppException("boom")).getCause().getMessage()
+	b.AddMethod(classfile.AccStatic, "initCause", "()Ljava/lang/String;", &classfile.CodeAttribute{
+		MaxStack: 6,
+		Code: cat(newISE("(Ljava/lang/String;)V", op(OpLdcW, b.String("outer"))...), newApp, initCause,
+			op(OpInvokevirtual, b.Methodref("java/lang/Throwable", "getCause", "()Ljava/lang/Throwable;")),
+			op(OpInvokevirtual, b.Methodref("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")),
+			[]byte{OpAreturn}),
+	})
+	// new IllegalStateException("outer", null).initCause(new AppException("boom"))
+	b.AddMethod(classfile.AccStatic, "overwrite", "()V", &classfile.CodeAttribute{
+		MaxStack: 6,
+		Code: cat(newISE("(Ljava/lang/String;Ljava/lang/Throwable;)V", cat(op(OpLdcW, b.String("outer")), []byte{OpAconstNull})...),
+			newApp, initCause, []byte{OpReturn}),
+	})
+	// e = new IllegalStateException(); e.initCause(e)
+	b.AddMethod(classfile.AccStatic, "self", "()V", &classfile.CodeAttribute{
+		MaxStack: 3, Code: cat(newISE("()V"), []byte{OpDup}, initCause, []byte{OpReturn}),
+	})
+	cf := b.Build()
+	cf.SourceFile = "Chain.java"
+	classes["Chain"] = cf
+	return classes
+}
+
+func TestCauseChain(t *testing.T) {
+	classes := causeClasses()
+	cf := classes["Chain"]
+	v := NewVM(classes)
+	_, err := v.executeMethod(cf, cf.FindMethodByName("main"), nil)
+	exc, ok := err.(*JavaException)
+	if !ok {
+		t.Fatalf("got %v, want RuntimeException", err)
+	}
+	if cause := exc.Cause(); cause == nil || cause.Object.ClassName != "AppException" {
+		t.Fatalf("cause: got %v", cause)
+	}
+
+	// The cause was created in inner, not in AppException's constructor,
+	// and shares main's frame with the exception it caused.
+	var out bytes.Buffer
+	v.ReportUncaught(&out, err)
+	want := `Exception in thread "main" java.lang.RuntimeException: wrapped
+	at Chain.chain(Chain.java:11)
+	at Chain.main(Chain.java:20)
+Caused by: AppException: boom
+	at Chain.inner(Chain.java:3)
+	at Chain.chain(Chain.java:10)
+	... 1 more
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestInitCause(t *testing.T) {
+	classes := causeClasses()
+	cf := classes["Chain"]
+
+	got, err := NewVM(classes).executeMethod(cf, cf.FindMethodByName("initCause"), nil)
+	if s, _ := extractGoString(got); err != nil || s != "boom" {
+		t.Errorf("getCause().getMessage(): got %q, %v", s, err)
+	}
+
+	tests := []struct {
+		method, class, message string
+	}{
+		{"overwrite", "java/lang/IllegalStateException", "Can't overwrite cause with AppException: boom"},
+		{"self", "java/lang/IllegalArgumentException", "Self-causation not permitted"},
+	}
+	for _, tt := range tests {
+		_, err := NewVM(classes).executeMethod(cf, cf.FindMethodByName(tt.method), nil)
+		if !isJavaException(err, tt.class) {
+			t.Errorf("%s: got %v, want %s", tt.method, err, tt.class)
+			continue
+		}
+		if msg, _ := err.(*JavaException).Message(); msg != tt.message {
+			t.Errorf("%s: got message %q, want %q", tt.method, msg, tt.message)
+		}
+	}
+}
+
+func TestCircularCause(t *testing.T) {
+	a := NewJavaExceptionMessage("java/lang/RuntimeException", "a")
+	b := NewJavaExceptionMessage("java/lang/IllegalStateException", "b")
+	a.Object.Fields["cause"] = RefValue(b.Object)
+	b.Object.Fields["cause"] = RefValue(a.Object)
+	var out bytes.Buffer
+	a.PrintStackTrace(&out)
+	want := "Exception in thread \"main\" java.lang.RuntimeException: a\n" +
+		"Caused by: java.lang.IllegalStateException: b\n" +
+		"Caused by: [CIRCULAR REFERENCE: java.lang.RuntimeException: a]\n"
+	if got := out.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+// A try-with-resources statement whose body and close both throw, as
+// compiled by javac: the exception from close is added to the one from
+// the body, which propagates.
+func TestSuppressedExceptions(t *testing.T) {
+	classes := throwableClasses()
+	op := func(code byte, index uint16) []byte { return []byte{code, byte(index >> 8), byte(index)} }
+	cat := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+
+	b := classfile.NewBuilder("Twr", "java/lang/Object")
+	b.AddMethod(classfile.AccStatic, "main", "()V", &classfile.CodeAttribute{
+		Code:        cat(op(OpInvokestatic, b.Methodref("Twr", "run", "()V")), []byte{OpReturn}),
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 20}},
+	})
+	b.AddMethod(classfile.AccStatic, "run", "()V", &classfile.CodeAttribute{
+		MaxStack: 3, MaxLocals: 2,
+		Code: cat(
+			op(OpNew, b.Class("AppException")), []byte{OpDup}, op(OpLdcW, b.String("boom")), // 0: throw new AppException("boom")
+			op(OpInvokespecial, b.Methodref("AppException", "<init>", "(Ljava/lang/String;)V")), []byte{OpAthrow},
+			[]byte{OpAstore0}, op(OpInvokestatic, b.Methodref("Twr", "close", "()V")), // 11: catch (t) { close()
+			[]byte{OpAload0, OpAthrow},            // 15: throw t }
+			[]byte{OpAstore1, OpAload0, OpAload1}, // 17: catch (x) { t.addSuppressed(x)
+			op(OpInvokevirtual, b.Methodref("java/lang/Throwable", "addSuppressed", "(Ljava/lang/Throwable;)V")),
+			[]byte{OpAload0, OpAthrow}, // 23: throw t }
+		),
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 11, HandlerPC: 11}, {StartPC: 12, EndPC: 15, HandlerPC: 17}},
+		LineNumbers:       []classfile.LineNumber{{StartPC: 0, Line: 5}, {StartPC: 11, Line: 6}},
+	})
+	b.AddMethod(classfile.AccStatic, "close", "()V", &classfile.CodeAttribute{
+		MaxStack: 3,
+		Code: cat(op(OpNew, b.Class("java/lang/IllegalStateException")), []byte{OpDup}, op(OpLdcW, b.String("close failed")),
+			op(OpInvokespecial, b.Methodref("java/lang/IllegalStateException", "<init>", "(Ljava/lang/String;)V")), []byte{OpAthrow}),
+		LineNumbers: []classfile.LineNumber{{StartPC: 0, Line: 9}},
+	})
+	cf := b.Build()
+	cf.SourceFile = "Twr.java"
+	classes["Twr"] = cf
+
+	v := NewVM(classes)
+	_, err := v.executeMethod(cf, cf.FindMethodByName("main"), nil)
+	exc, ok := err.(*JavaException)
+	if !ok || exc.Object.ClassName != "AppException" {
+		t.Fatalf("got %v, want AppException", err)
+	}
+	got, _, err := v.handleThrowableMethod(exc.Object, "getSuppressed", "()[Ljava/lang/Throwable;", nil)
+	if arr, ok := got.Ref.(*JArray); err != nil || !ok || arr.Len() != 1 || arr.Get(0).Ref.(*JObject).ClassName != "java/lang/IllegalStateException" {
+		t.Fatalf("getSuppressed: got %+v, %v", got.Ref, err)
+	}
+
+	var out bytes.Buffer
+	v.ReportUncaught(&out, exc)
+	want := `Exception in thread "main" AppException: boom
+	at Twr.run(Twr.java:5)
+	at Twr.main(Twr.java:20)
+	Suppressed: java.lang.IllegalStateException: close failed
+		at Twr.close(Twr.java:9)
+		at Twr.run(Twr.java:6)
+		... 1 more
+`
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestAddSuppressed(t *testing.T) {
+	v := NewVM(mapClassLoader{})
+	add := func(exc *JObject, suppressed Value) error {
+		_, handled, err := v.handleThrowableMethod(exc, "addSuppressed", "(Ljava/lang/Throwable;)V", []Value{suppressed})
+		if !handled {
+			t.Fatal("addSuppressed not handled")
+		}
+		return err
+	}
+	exc := NewJavaException("java/lang/RuntimeException").Object
+	if err := add(exc, RefValue(exc)); !isJavaException(err, "java/lang/IllegalArgumentException") {
+		t.Errorf("self-suppression: got %v", err)
+	}
+	if err := add(exc, NullValue()); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("null: got %v", err)
+	}
+	if len(suppressedOf(exc)) != 0 {
+		t.Errorf("failed additions were recorded: %v", suppressedOf(exc))
+	}
+
+	// Throwable(message, cause, false, true) disables suppression
+	disabled := NewJavaException("java/lang/RuntimeException").Object
+	v.handleThrowableMethod(disabled, "<init>", "(Ljava/lang/String;Ljava/lang/Throwable;ZZ)V",
+		[]Value{NullValue(), NullValue(), IntValue(0), IntValue(1)})
+	if err := add(disabled, RefValue(exc)); err != nil || len(suppressedOf(disabled)) != 0 {
+		t.Errorf("suppression disabled: got %v, %d suppressed", err, len(suppressedOf(disabled)))
+	}
+}
+
+func TestExceptionDetailMessages(t *testing.T) {
+	b := classfile.NewBuilder("Details", "java/lang/Object")
+	getMessage := b.Methodref("java/lang/Throwable", "getMessage", "()Ljava/lang/String;")
+	charAt := b.Methodref("java/lang/String", "charAt", "(I)C")
+	substring := b.Methodref("java/lang/String", "substring", "(II)Ljava/lang/String;")
+	objectClass := b.Class("java/lang/Object")
+	stringArray := b.Class("[Ljava/lang/String;")
+	hello := b.String("hello")
+	// Each method runs code that throws and returns the message of what
+	// it caught.
+	for _, m := range []struct {
+		name string
+		code []byte
+	}{
+		{"divide", []byte{OpIconst1, OpIconst0, OpIdiv, OpPop}},
+		{"load", []byte{OpIconst3, OpNewarray, 10, OpIconst5, OpIaload, OpPop}},
+		{"negative", []byte{OpIconstM1, OpAnewarray, byte(objectClass >> 8), byte(objectClass), OpPop}},
+		{"multi", []byte{OpIconst2, OpIconstM1, OpMultianewarray, byte(stringArray >> 8), byte(stringArray), 1, OpPop}},
+		{"store", []byte{
+			OpIconst1, OpAnewarray, byte(stringArray >> 8), byte(stringArray), OpIconst0,
+			OpNew, byte(objectClass >> 8), byte(objectClass), OpAastore,
+		}},
+		{"charAt", []byte{OpLdc, byte(hello), OpBipush, 9, OpInvokevirtual, byte(charAt >> 8), byte(charAt), OpPop}},
+		{"substring", []byte{
+			OpLdc, byte(hello), OpIconst2, OpIconst1, OpInvokevirtual, byte(substring >> 8), byte(substring), OpPop,
+		}},
+	} {
+		code := append(m.code, OpAconstNull, OpAreturn)
+		handler := len(code)
+		code = append(code, OpInvokevirtual, byte(getMessage>>8), byte(getMessage), OpAreturn)
+		b.AddMethod(classfile.AccStatic, m.name, "()Ljava/lang/String;", &classfile.CodeAttribute{
+			MaxStack:          5,
+			Code:              code,
+			ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: uint16(handler - 2), HandlerPC: uint16(handler)}},
+		})
+	}
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Details": cf})
+
+	for _, tt := range []struct{ method, want string }{
+		{"divide", "/ by zero"},
+		{"load", "Index 5 out of bounds for length 3"},
+		{"negative", "-1"},
+		{"multi", "-1"},
+		{"store", "java.lang.Object"},
+		{"charAt", "String index out of range: 9"},
+		{"substring", "begin 2, end 1, length 5"},
+	} {
+		got, err := v.executeMethod(cf, cf.FindMethodByName(tt.method), nil)
+		if err != nil {
+			t.Errorf("%s: %v", tt.method, err)
+			continue
+		}
+		if msg, _ := extractGoString(got); msg != tt.want {
+			t.Errorf("%s: got message %q, want %q", tt.method, msg, tt.want)
+		}
+	}
+}
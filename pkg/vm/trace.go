@@ -0,0 +1,269 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Setting VM.Trace records every method call, return, executed instruction
+// and thrown exception to a compact binary file, which TraceReader reads
+// back for offline inspection ("gojvm trace-view"). The file starts with
+// traceMagic and is followed by records, each a kind byte and uvarint
+// operands:
+//
+//	traceString       length, bytes       defines the next string id
+//	traceCall         thread, method      method is a string id
+//	traceReturn       thread              normal or exceptional exit
+//	traceInstruction  thread, pc, opcode  opcode is a single byte
+//	traceThrow        thread, class       exception class string id
+//
+// Threads are identified by the string id of their name. Strings are
+// written once, before their first use, so a long trace of a loop costs a
+// few bytes per instruction.
+
+const traceMagic = "GJTR\x01"
+
+const (
+	traceString byte = iota + 1
+	traceCall
+	traceReturn
+	traceInstruction
+	traceThrow
+)
+
+// TraceWriter encodes trace events to an underlying writer. The first
+// write error is kept and returned by Flush; events after it are dropped.
+type TraceWriter struct {
+	w       *bufio.Writer
+	err     error
+	strings map[string]uint64
+	methods map[methodKey]uint64
+	threads map[*JObject]uint64
+	buf     []byte
+}
+
+// NewTraceWriter returns a TraceWriter writing to w. Call Flush when
+// execution is done.
+func NewTraceWriter(w io.Writer) *TraceWriter {
+	t := &TraceWriter{
+		w:       bufio.NewWriter(w),
+		strings: make(map[string]uint64),
+		methods: make(map[methodKey]uint64),
+		threads: make(map[*JObject]uint64),
+	}
+	_, t.err = t.w.WriteString(traceMagic)
+	return t
+}
+
+// Flush writes buffered events and returns the first error encountered.
+func (t *TraceWriter) Flush() error {
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	return t.err
+}
+
+// record writes one record of the given kind.
+func (t *TraceWriter) record(kind byte, operands ...uint64) {
+	t.buf = append(t.buf[:0], kind)
+	for _, op := range operands {
+		t.buf = binary.AppendUvarint(t.buf, op)
+	}
+	t.write(t.buf)
+}
+
+func (t *TraceWriter) write(b []byte) {
+	if t.err == nil {
+		_, t.err = t.w.Write(b)
+	}
+}
+
+// stringID returns the id of s, defining it first if it is new.
+func (t *TraceWriter) stringID(s string) uint64 {
+	id, ok := t.strings[s]
+	if !ok {
+		id = uint64(len(t.strings))
+		t.strings[s] = id
+		t.record(traceString, uint64(len(s)))
+		t.write([]byte(s))
+	}
+	return id
+}
+
+func (t *TraceWriter) threadID(thread *JObject) uint64 {
+	id, ok := t.threads[thread]
+	if !ok {
+		name := "main"
+		if thread != nil {
+			if s, ok := extractGoString(thread.Fields["name"]); ok {
+				name = s
+			}
+		}
+		id = t.stringID(name)
+		t.threads[thread] = id
+	}
+	return id
+}
+
+func (t *TraceWriter) call(thread *JObject, class, name, descriptor string) {
+	key := methodKey{class, name, descriptor}
+	id, ok := t.methods[key]
+	if !ok {
+		id = t.stringID(class + "." + name + ":" + descriptor)
+		t.methods[key] = id
+	}
+	t.record(traceCall, t.threadID(thread), id)
+}
+
+func (t *TraceWriter) ret(thread *JObject) {
+	t.record(traceReturn, t.threadID(thread))
+}
+
+func (t *TraceWriter) instruction(thread *JObject, pc int, opcode byte) {
+	t.record(traceInstruction, t.threadID(thread), uint64(pc))
+	t.write([]byte{opcode})
+}
+
+func (t *TraceWriter) throw(thread *JObject, class string) {
+	t.record(traceThrow, t.threadID(thread), t.stringID(class))
+}
+
+// traceThread returns the thread to attribute trace events to.
+func (vm *VM) traceThread() *JObject {
+	thread, _ := vm.currentThread().Ref.(*JObject)
+	return thread
+}
+
+// TraceEventKind is the kind of a TraceEvent.
+type TraceEventKind byte
+
+const (
+	TraceCall        = TraceEventKind(traceCall)
+	TraceReturn      = TraceEventKind(traceReturn)
+	TraceInstruction = TraceEventKind(traceInstruction)
+	TraceThrow       = TraceEventKind(traceThrow)
+)
+
+// TraceEvent is one event read from a trace.
+type TraceEvent struct {
+	Kind   TraceEventKind
+	Thread string // name of the executing thread
+	Method string // "class.name:descriptor" of the executing method, the callee for TraceCall
+	Depth  int    // number of enclosing calls on the thread
+	PC     int    // TraceInstruction only
+	Opcode byte   // TraceInstruction only
+	Class  string // exception class, TraceThrow only
+}
+
+// TraceReader decodes a trace written by TraceWriter, tracking the call
+// stack of each thread so that every event carries its method and depth.
+type TraceReader struct {
+	r       *bufio.Reader
+	strings []string
+	stacks  map[string][]string // thread -> active methods, outermost first
+}
+
+// NewTraceReader returns a reader for the trace in r.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(traceMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != traceMagic {
+		return nil, errors.New("trace: not a gojvm trace file")
+	}
+	return &TraceReader{r: br, stacks: make(map[string][]string)}, nil
+}
+
+// Next returns the next event, or io.EOF at the end of the trace.
+func (tr *TraceReader) Next() (TraceEvent, error) {
+	for {
+		kind, err := tr.r.ReadByte()
+		if err != nil {
+			return TraceEvent{}, err // io.EOF between records is a clean end
+		}
+		if kind == traceString {
+			n, err := tr.uvarint()
+			if err != nil {
+				return TraceEvent{}, err
+			}
+			b := make([]byte, n)
+			if _, err := io.ReadFull(tr.r, b); err != nil {
+				return TraceEvent{}, truncated(err)
+			}
+			tr.strings = append(tr.strings, string(b))
+			continue
+		}
+
+		thread, err := tr.str()
+		if err != nil {
+			return TraceEvent{}, err
+		}
+		stack := tr.stacks[thread]
+		ev := TraceEvent{Kind: TraceEventKind(kind), Thread: thread, Depth: len(stack)}
+		if len(stack) > 0 {
+			ev.Method = stack[len(stack)-1]
+		}
+		switch kind {
+		case traceCall:
+			if ev.Method, err = tr.str(); err != nil {
+				return TraceEvent{}, err
+			}
+			tr.stacks[thread] = append(stack, ev.Method)
+		case traceReturn:
+			if len(stack) > 0 {
+				tr.stacks[thread] = stack[:len(stack)-1]
+			}
+			ev.Depth--
+		case traceInstruction:
+			pc, err := tr.uvarint()
+			if err != nil {
+				return TraceEvent{}, err
+			}
+			op, err := tr.r.ReadByte()
+			if err != nil {
+				return TraceEvent{}, truncated(err)
+			}
+			ev.PC, ev.Opcode, ev.Depth = int(pc), op, ev.Depth-1
+		case traceThrow:
+			if ev.Class, err = tr.str(); err != nil {
+				return TraceEvent{}, err
+			}
+			ev.Depth--
+		default:
+			return TraceEvent{}, fmt.Errorf("trace: unknown record kind %d", kind)
+		}
+		if ev.Depth < 0 { // tracing started inside a call
+			ev.Depth = 0
+		}
+		return ev, nil
+	}
+}
+
+func (tr *TraceReader) uvarint() (uint64, error) {
+	v, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return 0, truncated(err)
+	}
+	return v, nil
+}
+
+func (tr *TraceReader) str() (string, error) {
+	id, err := tr.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if id >= uint64(len(tr.strings)) {
+		return "", fmt.Errorf("trace: undefined string %d", id)
+	}
+	return tr.strings[id], nil
+}
+
+// truncated reports an end of input inside a record as unexpected.
+func truncated(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
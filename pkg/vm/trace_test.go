@@ -0,0 +1,94 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	b := classfile.NewBuilder("app/Main", "java/lang/Object")
+	fail := b.Methodref("app/Main", "fail", "()V")
+	b.AddMethod(classfile.AccStatic, "run", "()V", &classfile.CodeAttribute{
+		Code: []byte{0x00, 0xb8, byte(fail >> 8), byte(fail), 0xb1}, // nop; invokestatic fail; return
+	})
+	b.AddMethod(classfile.AccStatic, "fail", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{0x01, 0xbf}, // aconst_null; athrow
+	})
+	cf := b.Build()
+
+	var buf bytes.Buffer
+	v := NewVM(mapClassLoader{"app/Main": cf})
+	v.Stdout = io.Discard
+	v.Trace = NewTraceWriter(&buf)
+	if _, err := v.executeMethod(cf, cf.FindMethod("run", "()V"), nil); err == nil {
+		t.Fatal("run: expected an exception")
+	}
+	if err := v.Trace.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewTraceReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		ev, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Thread != "main" {
+			t.Errorf("thread: got %q", ev.Thread)
+		}
+		line := fmt.Sprintf("%d %d %s", ev.Depth, ev.Kind, ev.Method)
+		switch ev.Kind {
+		case TraceInstruction:
+			line += fmt.Sprintf(" %d %s", ev.PC, OpcodeName(ev.Opcode))
+		case TraceThrow:
+			line += " " + ev.Class
+		}
+		got = append(got, line)
+	}
+	want := []string{
+		"0 2 app/Main.run:()V",
+		"0 4 app/Main.run:()V 0 nop",
+		"0 4 app/Main.run:()V 1 invokestatic",
+		"1 2 app/Main.fail:()V",
+		"1 4 app/Main.fail:()V 0 aconst_null",
+		"1 4 app/Main.fail:()V 1 athrow",
+		"1 5 app/Main.fail:()V java/lang/NullPointerException",
+		"1 3 app/Main.fail:()V",
+		"0 3 app/Main.run:()V",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	tr, _ = NewTraceReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	for err == nil {
+		_, err = tr.Next()
+	}
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated trace: got %v", err)
+	}
+	if _, err := NewTraceReader(strings.NewReader("not a trace")); err == nil {
+		t.Error("expected an error for a foreign file")
+	}
+}
+
+func TestOpcodeName(t *testing.T) {
+	for op, want := range map[byte]string{0x00: "nop", 0x84: "iinc", 0xb6: "invokevirtual", 0xc4: "wide", 0xd0: "0xD0"} {
+		if got := OpcodeName(op); got != want {
+			t.Errorf("OpcodeName(%#x) = %q, want %q", op, got, want)
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package vm
+
+import (
+	"cmp"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// unsafeClass is the class whose natives handleUnsafe implements.
+const unsafeClass = "jdk/internal/misc/Unsafe"
+
+// Off-heap memory handed out by Unsafe.allocateMemory is a byte slice per
+// block, at bump-allocated addresses. Addresses start at offHeapBase so
+// that 0 stays an invalid address; freed addresses are not reused, so an
+// address outside the live blocks never reads another block.
+const (
+	offHeapBase  = 0x1000
+	offHeapAlign = 8
+)
+
+// offHeapBlock is a live block of off-heap memory.
+type offHeapBlock struct {
+	addr int64
+	mem  []byte
+}
+
+// unsafeAccessSizes maps the type part of Unsafe get/put method names to
+// the size of the value in off-heap memory.
+var unsafeAccessSizes = map[string]int{
+	"Boolean": 1, "Byte": 1, "Short": 2, "Char": 2,
+	"Int": 4, "Float": 4, "Long": 8, "Double": 8, "Reference": 0,
+}
+
+// unsafeAccessDescriptors maps the same type names to field descriptors,
+// by which stores into fields are narrowed as putfield narrows them.
+var unsafeAccessDescriptors = map[string]string{
+	"Boolean": "Z", "Byte": "B", "Short": "S", "Char": "C",
+	"Int": "I", "Float": "F", "Long": "J", "Double": "D", "Reference": "Ljava/lang/Object;",
+}
+
+// unsafeAccessType returns the value type of a get/put method name such
+// as "getIntVolatile" or "putReferenceRelease", or "" for other methods.
+// Memory ordering suffixes are irrelevant on the single-threaded VM.
+func unsafeAccessType(name string) string {
+	for _, suffix := range []string{"Volatile", "Acquire", "Release", "Opaque"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	if _, ok := unsafeAccessSizes[name]; ok {
+		return name
+	}
+	return ""
+}
+
+// handleUnsafe implements jdk.internal.misc.Unsafe natives for instance
+// allocation, object/array/off-heap access and compare-and-set. args[0]
+// is the Unsafe receiver. It reports false for methods it does not handle.
+func (vm *VM) handleUnsafe(methodName, descriptor string, args []Value) (Value, bool, error) {
+	switch methodName {
+	case "allocateInstance":
+		v, err := vm.unsafeAllocateInstance(args[1])
+		return v, true, err
+	case "arrayBaseOffset0", "arrayBaseOffset":
+		return IntValue(arrayBaseOffset), true, nil
+	case "arrayIndexScale0", "arrayIndexScale":
+		return IntValue(int32(arrayIndexScale(classObjectName(args[1])))), true, nil
+	case "objectFieldOffset1":
+		name, _ := extractGoString(args[2])
+		off := vm.objectFieldOffset(classObjectName(args[1]), name)
+		if off < 0 {
+			return Value{}, true, NewJavaException("java/lang/InternalError")
+		}
+		return LongValue(off), true, nil
+	case "objectFieldOffset0", "staticFieldOffset0":
+		className, name, ok := reflectedField(args[1])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		off := vm.objectFieldOffset(className, name)
+		if methodName == "staticFieldOffset0" {
+			off = vm.staticFieldOffset(className, name)
+		}
+		if off < 0 {
+			return Value{}, true, NewJavaException("java/lang/IllegalArgumentException")
+		}
+		return LongValue(off), true, nil
+	case "staticFieldBase0":
+		className, _, ok := reflectedField(args[1])
+		if !ok {
+			return Value{}, true, NewJavaException("java/lang/NullPointerException")
+		}
+		return vm.classObject(className), true, nil
+	case "allocateMemory0":
+		addr, err := vm.allocateMemory(args[1].Long)
+		return LongValue(addr), true, err
+	case "reallocateMemory0":
+		addr, err := vm.allocateMemory(args[2].Long)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if old := args[1].Long; old != 0 {
+			if i := vm.offHeapIndex(old); i >= 0 && vm.offHeap[i].addr == old {
+				mem, _ := vm.offHeapSlice(addr, args[2].Long)
+				copy(mem, vm.offHeap[i].mem)
+				vm.offHeap = slices.Delete(vm.offHeap, i, i+1)
+			}
+		}
+		return LongValue(addr), true, nil
+	case "freeMemory0":
+		if i := vm.offHeapIndex(args[1].Long); i >= 0 && vm.offHeap[i].addr == args[1].Long {
+			vm.offHeap = slices.Delete(vm.offHeap, i, i+1)
+		}
+		return Value{}, true, nil
+	case "setMemory0":
+		mem, err := vm.offHeapSlice(args[2].Long, args[3].Long)
+		if err != nil {
+			return Value{}, true, err
+		}
+		for i := range mem {
+			mem[i] = byte(args[4].Int)
+		}
+		return Value{}, true, nil
+	case "copyMemory0":
+		src, err := vm.offHeapSlice(args[2].Long, args[5].Long)
+		if err != nil {
+			return Value{}, true, err
+		}
+		dst, err := vm.offHeapSlice(args[4].Long, args[5].Long)
+		if err != nil {
+			return Value{}, true, err
+		}
+		copy(dst, src)
+		return Value{}, true, nil
+	case "compareAndSetInt", "compareAndSetLong", "compareAndSetReference":
+		typ := strings.TrimPrefix(methodName, "compareAndSet")
+		cur, err := vm.unsafeGet(typ, args[1], args[2].Long)
+		if err != nil {
+			return Value{}, true, err
+		}
+		if !sameValue(cur, args[3]) {
+			return IntValue(0), true, nil
+		}
+		return IntValue(1), true, vm.unsafePut(typ, args[1], args[2].Long, args[4])
+	case "compareAndExchangeInt", "compareAndExchangeLong", "compareAndExchangeReference":
+		// The witness value is returned whether or not the exchange
+		// happens; the JDK builds the sub-int and VarHandle exchanges on
+		// these.
+		typ := strings.TrimPrefix(methodName, "compareAndExchange")
+		cur, err := vm.unsafeGet(typ, args[1], args[2].Long)
+		if err != nil || !sameValue(cur, args[3]) {
+			return cur, true, err
+		}
+		return cur, true, vm.unsafePut(typ, args[1], args[2].Long, args[4])
+	}
+
+	// get<Type>(Object, long) and put<Type>(Object, long, value); the
+	// single-address forms are Java wrappers passing a null base.
+	if strings.HasPrefix(methodName, "get") && strings.HasPrefix(descriptor, "(Ljava/lang/Object;J)") {
+		if typ := unsafeAccessType(methodName[3:]); typ != "" {
+			v, err := vm.unsafeGet(typ, args[1], args[2].Long)
+			return v, true, err
+		}
+	}
+	if strings.HasPrefix(methodName, "put") && strings.HasPrefix(descriptor, "(Ljava/lang/Object;J") {
+		if typ := unsafeAccessType(methodName[3:]); typ != "" {
+			return Value{}, true, vm.unsafePut(typ, args[1], args[2].Long, args[3])
+		}
+	}
+	return Value{}, false, nil
+}
+
+// unsafeAllocateInstance creates an instance without running a constructor.
+func (vm *VM) unsafeAllocateInstance(classRef Value) (Value, error) {
+	cf := vm.classFileOfClassObject(classRef)
+	if cf == nil || cf.AccessFlags&(AccAbstract|AccInterface) != 0 {
+		return Value{}, NewJavaExceptionMessage("java/lang/InstantiationException", strings.ReplaceAll(classObjectName(classRef), "/", "."))
+	}
+	name := classObjectName(classRef)
+	if err := vm.ensureInitialized(name); err != nil {
+		return Value{}, err
+	}
+	return RefValue(&JObject{ClassName: name, Fields: make(map[string]Value)}), nil
+}
+
+// allocateMemory reserves size bytes of zeroed off-heap memory. Sizes
+// over the array limit throw OutOfMemoryError, as a failed malloc does.
+func (vm *VM) allocateMemory(size int64) (int64, error) {
+	if size < 0 {
+		return 0, NewJavaException("java/lang/IllegalArgumentException")
+	}
+	if size > vm.maxArrayBytes() {
+		return 0, NewJavaExceptionMessage("java/lang/OutOfMemoryError", fmt.Sprintf("Unable to allocate %d bytes", size))
+	}
+	addr := max(vm.offHeapNext, offHeapBase)
+	vm.offHeapNext = addr + (max(size, 1)+offHeapAlign-1)&^(offHeapAlign-1)
+	vm.offHeap = append(vm.offHeap, offHeapBlock{addr: addr, mem: make([]byte, size)})
+	return addr, nil
+}
+
+// offHeapIndex returns the index of the last live block starting at or
+// before addr, or -1.
+func (vm *VM) offHeapIndex(addr int64) int {
+	i, found := slices.BinarySearchFunc(vm.offHeap, addr, func(b offHeapBlock, addr int64) int {
+		return cmp.Compare(b.addr, addr)
+	})
+	if !found {
+		i--
+	}
+	return i
+}
+
+// offHeapSlice returns the off-heap bytes [addr, addr+size), which must
+// lie in one block.
+func (vm *VM) offHeapSlice(addr, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	if i := vm.offHeapIndex(addr); i >= 0 {
+		if mem, start := vm.offHeap[i].mem, addr-vm.offHeap[i].addr; size > 0 && start+size <= int64(len(mem)) {
+			return mem[start : start+size], nil
+		}
+	}
+	return nil, fmt.Errorf("Unsafe: address 0x%x (+%d) is outside allocated memory", addr, size)
+}
+
+// unsafeGet reads a value of typ at base+offset: a field of an object, a
+// static field when base is a Class mirror from staticFieldBase, an array
+// element, or off-heap memory when base is null.
+func (vm *VM) unsafeGet(typ string, base Value, offset int64) (Value, error) {
+	switch b := base.Ref.(type) {
+	case *JArray:
+		return unsafeArrayGet(typ, b, offset)
+	case *JObject:
+		if b.ClassName == "java/lang/Class" && offset >= staticFieldOffsetBase {
+			className := classObjectName(base)
+			name, ok := vm.layoutOf(className).staticFields[offset]
+			if !ok {
+				return Value{}, fmt.Errorf("Unsafe: no static field at offset %d of %s", offset, className)
+			}
+			if v, ok := vm.getStaticFieldOk(className, name); ok {
+				return v, nil
+			}
+			return unsafeZero(typ), nil
+		}
+		name, ok := vm.layoutOf(b.ClassName).fields[offset]
+		if !ok {
+			return Value{}, fmt.Errorf("Unsafe: no field at offset %d of %s", offset, b.ClassName)
+		}
+		if v, ok := b.Fields[name]; ok {
+			return v, nil
+		}
+		return unsafeZero(typ), nil
+	case nil:
+		return vm.offHeapGet(typ, offset)
+	}
+	return Value{}, fmt.Errorf("Unsafe: unsupported base object %T", base.Ref)
+}
+
+// unsafePut writes v as typ at base+offset; see unsafeGet.
+func (vm *VM) unsafePut(typ string, base Value, offset int64, v Value) error {
+	switch b := base.Ref.(type) {
+	case *JArray:
+		return unsafeArrayPut(typ, b, offset, v)
+	case *JObject:
+		if b.ClassName == "java/lang/Class" && offset >= staticFieldOffsetBase {
+			className := classObjectName(base)
+			name, ok := vm.layoutOf(className).staticFields[offset]
+			if !ok {
+				return fmt.Errorf("Unsafe: no static field at offset %d of %s", offset, className)
+			}
+			vm.setStaticField(className, name, fieldValue(unsafeAccessDescriptors[typ], v))
+			return nil
+		}
+		name, ok := vm.layoutOf(b.ClassName).fields[offset]
+		if !ok {
+			return fmt.Errorf("Unsafe: no field at offset %d of %s", offset, b.ClassName)
+		}
+		b.Fields[name] = fieldValue(unsafeAccessDescriptors[typ], v)
+		return nil
+	case nil:
+		return vm.offHeapPut(typ, offset, v)
+	}
+	return fmt.Errorf("Unsafe: unsupported base object %T", base.Ref)
+}
+
+// unsafeArrayGet reads a value of typ at offset in arr. An access of the
+// array's own element type reads one element; any other, such as getLong
+// on a byte[], composes the value from the little-endian bytes of the
+// elements it spans, as it would read the array's memory.
+func unsafeArrayGet(typ string, arr *JArray, offset int64) (Value, error) {
+	elem := unsafeElementType(arr, typ)
+	if typ == elem || typ == "Reference" || elem == "Reference" {
+		i, err := unsafeArrayIndex(elem, arr, offset)
+		if err != nil {
+			return Value{}, err
+		}
+		return arr.Get(int(i)), nil
+	}
+	mem := make([]byte, unsafeAccessSizes[typ])
+	if err := unsafeArrayBytes(elem, arr, offset, mem, false); err != nil {
+		return Value{}, err
+	}
+	return decodeUnsafe(typ, mem), nil
+}
+
+// unsafeArrayPut writes v as typ at offset in arr; see unsafeArrayGet.
+func unsafeArrayPut(typ string, arr *JArray, offset int64, v Value) error {
+	elem := unsafeElementType(arr, typ)
+	if typ == elem || typ == "Reference" || elem == "Reference" {
+		i, err := unsafeArrayIndex(elem, arr, offset)
+		if err != nil {
+			return err
+		}
+		arr.Set(int(i), v)
+		return nil
+	}
+	mem := make([]byte, unsafeAccessSizes[typ])
+	encodeUnsafe(typ, mem, v)
+	return unsafeArrayBytes(elem, arr, offset, mem, true)
+}
+
+// unsafeElementType returns the Unsafe access type of the elements of arr,
+// or typ, the type accessed, if arr does not record its component type.
+func unsafeElementType(arr *JArray, typ string) string {
+	if arr.Component == "" {
+		return typ
+	}
+	for typ, desc := range unsafeAccessDescriptors {
+		if desc == arr.Component && typ != "Reference" {
+			return typ
+		}
+	}
+	return "Reference"
+}
+
+// unsafeArrayBytes copies the bytes [offset, offset+len(mem)) of the
+// primitive array arr, whose elements are of type elem, into mem, or from
+// mem into the array if store is set.
+func unsafeArrayBytes(elem string, arr *JArray, offset int64, mem []byte, store bool) error {
+	scale := int64(unsafeAccessSizes[elem])
+	rel := offset - arrayBaseOffset
+	end := rel + int64(len(mem))
+	switch {
+	case rel < 0:
+		return arrayIndexOutOfBounds(int((rel-scale+1)/scale), arr.Len())
+	case end > int64(arr.Len())*scale:
+		return arrayIndexOutOfBounds(int((end-1)/scale), arr.Len())
+	}
+	buf := make([]byte, scale)
+	for pos := rel; pos < end; {
+		i := int(pos / scale)
+		encodeUnsafe(elem, buf, arr.Get(i))
+		var n int
+		if store {
+			n = copy(buf[pos%scale:], mem[pos-rel:])
+			arr.Set(i, decodeUnsafe(elem, buf))
+		} else {
+			n = copy(mem[pos-rel:], buf[pos%scale:])
+		}
+		pos += int64(n)
+	}
+	return nil
+}
+
+// unsafeArrayIndex converts an offset computed from arrayBaseOffset and
+// arrayIndexScale to the index of an element of type elem.
+func unsafeArrayIndex(elem string, arr *JArray, offset int64) (int64, error) {
+	scale := int64(unsafeAccessSizes[elem])
+	if scale == 0 {
+		scale = referenceSize
+	}
+	rel := offset - arrayBaseOffset
+	if rel%scale != 0 {
+		return 0, fmt.Errorf("Unsafe: misaligned %s array offset %d", elem, offset)
+	}
+	if rel < 0 || rel/scale >= int64(arr.Len()) {
+		return 0, arrayIndexOutOfBounds(int(rel/scale), arr.Len())
+	}
+	return rel / scale, nil
+}
+
+func unsafeZero(typ string) Value {
+	switch typ {
+	case "Reference":
+		return NullValue()
+	case "Long":
+		return LongValue(0)
+	case "Float":
+		return FloatValue(0)
+	case "Double":
+		return DoubleValue(0)
+	}
+	return IntValue(0)
+}
+
+// offHeapGet reads a little-endian value, matching StringUTF16.isBigEndian.
+func (vm *VM) offHeapGet(typ string, addr int64) (Value, error) {
+	size := unsafeAccessSizes[typ]
+	if size == 0 {
+		return Value{}, fmt.Errorf("Unsafe: cannot read a reference from off-heap memory")
+	}
+	mem, err := vm.offHeapSlice(addr, int64(size))
+	if err != nil {
+		return Value{}, err
+	}
+	return decodeUnsafe(typ, mem), nil
+}
+
+func (vm *VM) offHeapPut(typ string, addr int64, v Value) error {
+	size := unsafeAccessSizes[typ]
+	if size == 0 {
+		return fmt.Errorf("Unsafe: cannot store a reference in off-heap memory")
+	}
+	mem, err := vm.offHeapSlice(addr, int64(size))
+	if err != nil {
+		return err
+	}
+	encodeUnsafe(typ, mem, v)
+	return nil
+}
+
+// decodeUnsafe returns the primitive of typ held in the little-endian
+// bytes mem.
+func decodeUnsafe(typ string, mem []byte) Value {
+	switch typ {
+	case "Boolean":
+		return IntValue(int32(mem[0] & 1))
+	case "Byte":
+		return IntValue(int32(int8(mem[0])))
+	case "Short":
+		return IntValue(int32(int16(binary.LittleEndian.Uint16(mem))))
+	case "Char":
+		return IntValue(int32(binary.LittleEndian.Uint16(mem)))
+	case "Int":
+		return IntValue(int32(binary.LittleEndian.Uint32(mem)))
+	case "Float":
+		return FloatValue(math.Float32frombits(binary.LittleEndian.Uint32(mem)))
+	case "Long":
+		return LongValue(int64(binary.LittleEndian.Uint64(mem)))
+	default: // Double
+		return DoubleValue(math.Float64frombits(binary.LittleEndian.Uint64(mem)))
+	}
+}
+
+// encodeUnsafe stores v as a little-endian primitive of typ in mem.
+func encodeUnsafe(typ string, mem []byte, v Value) {
+	switch typ {
+	case "Boolean", "Byte":
+		mem[0] = byte(v.Int)
+	case "Short", "Char":
+		binary.LittleEndian.PutUint16(mem, uint16(v.Int))
+	case "Int":
+		binary.LittleEndian.PutUint32(mem, uint32(v.Int))
+	case "Float":
+		binary.LittleEndian.PutUint32(mem, math.Float32bits(v.Float))
+	case "Long":
+		binary.LittleEndian.PutUint64(mem, uint64(v.Long))
+	case "Double":
+		binary.LittleEndian.PutUint64(mem, math.Float64bits(v.Double))
+	}
+}
+
+// sameValue compares two values as compare-and-set does: primitives by
+// value, references by identity.
+func sameValue(a, b Value) bool {
+	switch {
+	case a.Type == TypeNull || a.Ref == nil && a.Type == TypeRef:
+		return b.Type == TypeNull || b.Type == TypeRef && b.Ref == nil
+	case a.Type == TypeRef:
+		return b.Type == TypeRef && a.Ref == b.Ref
+	case a.Type == TypeLong:
+		return a.Long == b.Long
+	}
+	return a.Int == b.Int
+}
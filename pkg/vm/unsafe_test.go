@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"io"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestUnsafe(t *testing.T) {
+	b := classfile.NewBuilder("Point", "java/lang/Object")
+	b.AddField(0, "x", "I", nil)
+	b.AddField(0, "flag", "B", nil)
+	b.AddField(0, "next", "Ljava/lang/Object;", nil)
+	point := b.Build()
+	iface := classfile.NewBuilder("Shape", "java/lang/Object")
+	iface.SetAccessFlags(classfile.AccPublic | AccInterface | classfile.AccAbstract)
+	v := NewVM(mapClassLoader{"Point": point, "Shape": iface.Build()})
+	v.Stdout = io.Discard
+	unsafe := RefValue(&JObject{ClassName: unsafeClass, Fields: map[string]Value{}})
+	call := func(method, desc string, args ...Value) Value {
+		t.Helper()
+		got, err := v.executeNativeMethod(unsafeClass, method, desc, append([]Value{unsafe}, args...))
+		if err != nil {
+			t.Fatalf("%s%s: %v", method, desc, err)
+		}
+		return got
+	}
+
+	t.Run("allocateInstance", func(t *testing.T) {
+		obj := call("allocateInstance", "(Ljava/lang/Class;)Ljava/lang/Object;", v.classObject("Point"))
+		if o, ok := obj.Ref.(*JObject); !ok || o.ClassName != "Point" || len(o.Fields) != 0 {
+			t.Errorf("got %+v", obj.Ref)
+		}
+		_, err := v.executeNativeMethod(unsafeClass, "allocateInstance", "(Ljava/lang/Class;)Ljava/lang/Object;",
+			[]Value{unsafe, v.classObject("Shape")})
+		if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/InstantiationException" {
+			t.Errorf("interface: got %v", err)
+		}
+	})
+
+	t.Run("object fields", func(t *testing.T) {
+		obj := RefValue(&JObject{ClassName: "Point", Fields: map[string]Value{}})
+		off := call("objectFieldOffset1", "(Ljava/lang/Class;Ljava/lang/String;)J", v.classObject("Point"), RefValue("x"))
+		if again := call("objectFieldOffset1", "(Ljava/lang/Class;Ljava/lang/String;)J", v.classObject("Point"), RefValue("x")); again.Long != off.Long {
+			t.Errorf("offset not stable: %d != %d", off.Long, again.Long)
+		}
+		if got := call("getInt", "(Ljava/lang/Object;J)I", obj, off); got.Int != 0 {
+			t.Errorf("unset field: got %d", got.Int)
+		}
+		call("putInt", "(Ljava/lang/Object;JI)V", obj, off, IntValue(7))
+		if got := call("getIntVolatile", "(Ljava/lang/Object;J)I", obj, off); got.Int != 7 {
+			t.Errorf("after putInt: got %d", got.Int)
+		}
+		if ok := call("compareAndSetInt", "(Ljava/lang/Object;JII)Z", obj, off, IntValue(1), IntValue(9)); ok.Int != 0 {
+			t.Error("CAS with a stale expected value succeeded")
+		}
+		if ok := call("compareAndSetInt", "(Ljava/lang/Object;JII)Z", obj, off, IntValue(7), IntValue(9)); ok.Int != 1 {
+			t.Error("CAS with the current value failed")
+		}
+		if x := obj.Ref.(*JObject).Fields["x"]; x.Int != 9 {
+			t.Errorf("field x: got %d, want 9", x.Int)
+		}
+	})
+
+	t.Run("compare and exchange", func(t *testing.T) {
+		obj := RefValue(&JObject{ClassName: "Point", Fields: map[string]Value{}})
+		offset := func(name string) Value {
+			return call("objectFieldOffset1", "(Ljava/lang/Class;Ljava/lang/String;)J", v.classObject("Point"), RefValue(name))
+		}
+		x, next := offset("x"), offset("next")
+		if got := call("compareAndExchangeInt", "(Ljava/lang/Object;JII)I", obj, x, IntValue(0), IntValue(5)); got.Int != 0 {
+			t.Errorf("witness of a successful exchange: got %d, want 0", got.Int)
+		}
+		if got := call("compareAndExchangeInt", "(Ljava/lang/Object;JII)I", obj, x, IntValue(0), IntValue(6)); got.Int != 5 {
+			t.Errorf("witness of a failed exchange: got %d, want 5", got.Int)
+		}
+		if got := obj.Ref.(*JObject).Fields["x"]; got.Int != 5 {
+			t.Errorf("failed exchange stored %d", got.Int)
+		}
+		target := RefValue(&JObject{ClassName: "Point", Fields: map[string]Value{}})
+		if got := call("compareAndExchangeReference", "(Ljava/lang/Object;JLjava/lang/Object;Ljava/lang/Object;)Ljava/lang/Object;",
+			obj, next, NullValue(), target); got.Ref != nil {
+			t.Errorf("witness of the unset reference: got %v", got.Ref)
+		}
+		if got := call("getReferenceAcquire", "(Ljava/lang/Object;J)Ljava/lang/Object;", obj, next); got.Ref != target.Ref {
+			t.Errorf("getReferenceAcquire: got %v", got.Ref)
+		}
+
+		// Stores narrow to the access type, as putfield narrows to the
+		// field type.
+		call("putByte", "(Ljava/lang/Object;JB)V", obj, offset("flag"), IntValue(0x1FF))
+		if got := call("getByte", "(Ljava/lang/Object;J)B", obj, offset("flag")); got.Int != -1 {
+			t.Errorf("getByte after putByte(0x1FF): got %d", got.Int)
+		}
+		if !v.implementsNative(unsafeClass, "compareAndExchangeLong", "(Ljava/lang/Object;JJJ)J") {
+			t.Error("compareAndExchangeLong is not reported as implemented")
+		}
+	})
+
+	t.Run("arrays", func(t *testing.T) {
+		ints := NewArray("I", 2)
+		call("putInt", "(Ljava/lang/Object;JI)V", RefValue(ints), LongValue(arrayBaseOffset+4), IntValue(7))
+		if ints.Ints[1] != 7 {
+			t.Errorf("putInt on int[]: got %v", ints.Ints)
+		}
+
+		// Wider accesses span several elements, as
+		// ByteArrayAccess and VarHandle views of byte[] make them.
+		bytes := NewArray("B", 10)
+		call("putLong", "(Ljava/lang/Object;JJ)V", RefValue(bytes), LongValue(arrayBaseOffset+1), LongValue(0x0102030405060708))
+		want := []int8{0, 8, 7, 6, 5, 4, 3, 2, 1, 0}
+		for i, b := range want {
+			if bytes.Bytes[i] != b {
+				t.Fatalf("putLong on byte[]: got %v, want %v", bytes.Bytes, want)
+			}
+		}
+		if got := call("getLong", "(Ljava/lang/Object;J)J", RefValue(bytes), LongValue(arrayBaseOffset+1)); got.Long != 0x0102030405060708 {
+			t.Errorf("getLong on byte[]: got %#x", got.Long)
+		}
+		if got := call("getInt", "(Ljava/lang/Object;J)I", RefValue(bytes), LongValue(arrayBaseOffset+5)); got.Int != 0x01020304 {
+			t.Errorf("getInt on byte[]: got %#x", got.Int)
+		}
+		bytes.Bytes[3] = -1
+		if got := call("getShort", "(Ljava/lang/Object;J)S", RefValue(bytes), LongValue(arrayBaseOffset+2)); got.Int != -249 {
+			t.Errorf("getShort on byte[]: got %d", got.Int)
+		}
+		if _, err := v.executeNativeMethod(unsafeClass, "getLong", "(Ljava/lang/Object;J)J",
+			[]Value{unsafe, RefValue(bytes), LongValue(arrayBaseOffset + 3)}); !isJavaException(err, "java/lang/ArrayIndexOutOfBoundsException") {
+			t.Errorf("getLong past the end of byte[]: got %v", err)
+		}
+
+		// And narrower ones read part of an element.
+		longs := NewArray("J", 1)
+		longs.Longs[0] = -2
+		if got := call("getInt", "(Ljava/lang/Object;J)I", RefValue(longs), LongValue(arrayBaseOffset+4)); got.Int != -1 {
+			t.Errorf("getInt of the high half of a long: got %d", got.Int)
+		}
+	})
+
+	t.Run("off-heap memory", func(t *testing.T) {
+		addr := call("allocateMemory0", "(J)J", LongValue(16)).Long
+		if addr == 0 {
+			t.Fatal("allocateMemory0 returned 0")
+		}
+		call("putLong", "(Ljava/lang/Object;JJ)V", NullValue(), LongValue(addr), LongValue(-2))
+		call("putByte", "(Ljava/lang/Object;JB)V", NullValue(), LongValue(addr+8), IntValue(-1))
+		if got := call("getLong", "(Ljava/lang/Object;J)J", NullValue(), LongValue(addr)); got.Long != -2 {
+			t.Errorf("getLong: got %d", got.Long)
+		}
+		if got := call("getInt", "(Ljava/lang/Object;J)I", NullValue(), LongValue(addr)); got.Int != -2 {
+			t.Errorf("getInt of the low half: got %d", got.Int)
+		}
+		if got := call("getByte", "(Ljava/lang/Object;J)B", NullValue(), LongValue(addr+8)); got.Int != -1 {
+			t.Errorf("getByte: got %d", got.Int)
+		}
+		call("setMemory0", "(Ljava/lang/Object;JJB)V", NullValue(), LongValue(addr), LongValue(4), IntValue(0))
+		if got := call("getInt", "(Ljava/lang/Object;J)I", NullValue(), LongValue(addr)); got.Int != 0 {
+			t.Errorf("after setMemory0: got %d", got.Int)
+		}
+		moved := call("reallocateMemory0", "(JJ)J", LongValue(addr), LongValue(32)).Long
+		if got := call("getByte", "(Ljava/lang/Object;J)B", NullValue(), LongValue(moved+8)); got.Int != -1 {
+			t.Errorf("reallocateMemory0 lost contents: got %d", got.Int)
+		}
+		if _, err := v.executeNativeMethod(unsafeClass, "getLong", "(Ljava/lang/Object;J)J", []Value{unsafe, NullValue(), LongValue(moved + 28)}); err == nil {
+			t.Error("reading past the end of a block should fail")
+		}
+		call("freeMemory0", "(J)V", LongValue(moved))
+
+		for _, addr := range []int64{8, moved} {
+			if _, err := v.executeNativeMethod(unsafeClass, "getInt", "(Ljava/lang/Object;J)I", []Value{unsafe, NullValue(), LongValue(addr)}); err == nil {
+				t.Errorf("reading unallocated address %#x should fail", addr)
+			}
+		}
+		for size, want := range map[int64]string{-1: "java/lang/IllegalArgumentException", 1 << 62: "java/lang/OutOfMemoryError"} {
+			if _, err := v.executeNativeMethod(unsafeClass, "allocateMemory0", "(J)J", []Value{unsafe, LongValue(size)}); !isJavaException(err, want) {
+				t.Errorf("allocateMemory0(%d): got %v, want %s", size, err, want)
+			}
+		}
+	})
+}
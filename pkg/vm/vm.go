@@ -1,13 +1,20 @@
 package vm
 
 import (
+	"bufio"
+	"cmp"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"os"
-	"reflect"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/daimatz/gojvm/pkg/classfile"
 	"github.com/daimatz/gojvm/pkg/native"
@@ -22,31 +29,92 @@ const AccNative = 0x0100
 // AccAbstract is the access flag for abstract methods.
 const AccAbstract = 0x0400
 
+// AccSynchronized is the access flag for synchronized methods.
+const AccSynchronized = 0x0020
+
+// AccInterface is the access flag for interfaces.
+const AccInterface = 0x0200
+
+// AccVarargs is the access flag for variable arity methods.
+const AccVarargs = 0x0080
+
 // VM is the virtual machine that executes Java bytecode.
 type VM struct {
-	ClassLoader        ClassLoader
-	Stdout             io.Writer
-	frameDepth         int
-	staticFields       map[string]map[string]Value // className -> fieldName -> Value
-	initializedClasses map[string]bool             // <clinit> done
+	ClassLoader      ClassLoader
+	Stdin            io.Reader // read by System.in; set before the program reads it
+	Stdout           io.Writer
+	Stderr           io.Writer         // written by System.err and for uncaught exceptions
+	OpcodeFallback   OpcodeFallback    // called for unimplemented opcodes, if set
+	NativeFallback   NativeFallback    // called for unimplemented native methods, if set
+	Trace            *TraceWriter      // records calls and executed instructions, if set
+	EnablePreview    bool              // accept class files compiled with preview features
+	CheckReturns     bool              // check returned values against method descriptors
+	CheckStackTypes  bool              // panic when an instruction's operands have the wrong types
+	MaxInstructions  uint64            // stop after this many instructions in all, if nonzero
+	CallInstructions uint64            // stop after this many instructions per Execute or WarmUp, if nonzero
+	MaxArrayBytes    int64             // throw OutOfMemoryError for larger arrays; 1GiB if zero
+	NoRecover        bool              // let Go panics in instructions and natives propagate
+	Diagnostics      io.Writer         // receives the Go stacks of recovered panics; Stderr if nil
+	Properties       map[string]string // system properties over the defaults; read when the store is first used
+	Env              map[string]string // environment for System.getenv; the host environment if nil
+	FileAccess       bool              // let programs open host files with the file streams
+	Clock            Clock             // time source of System.currentTimeMillis and nanoTime; the host clock if nil
+	frameDepth       int
+	staticFields     map[string]map[string]Value // className -> fieldName -> Value
+	classInits       map[string]*classInit       // className -> initialization state
+	initMu           sync.Mutex                  // guards classInits
+	initCond         *sync.Cond                  // signalled when a class initialization finishes
+	linked           sync.Map                    // className -> error from linkClass, nil if it passed
+	streamOverrides  sync.Map                    // "class stream.methodDescriptor" -> overridesStreamMethod
+	classObjects     map[string]*JObject         // canonical java/lang/Class mirrors
+	boxCache         map[string]map[int64]Value  // wrapper class -> value -> box shared by valueOf
+	monitors         map[interface{}]*monitor    // object -> monitor, while owned
+	monitorMu        sync.Mutex                  // guards monitors
+	monitorCond      *sync.Cond                  // signalled when a monitor is released
+	mainThread       *JObject                    // java/lang/Thread of the only thread
+	appLoader        *JObject                    // application class loader object
+	module           *JObject                    // java/lang/Module of the unnamed module
+	packages         map[string]*JObject         // package name -> java/lang/Package
+	properties       map[string]string           // system properties
+	callStack        []stackEntry                // active Java frames, outermost first
+	running          *Frame                      // frame the innermost interpreter loop is running
+	pending          callRequest                 // call left by an invoke instruction
+	layouts          map[string]*classLayout     // className -> field offsets
+	dynamicConstants map[string]*Value           // "class#index" -> resolved condy, nil while resolving
+	fieldOwners      map[string]string           // "class.field" -> declaring class of a static field
+	offHeap          []offHeapBlock              // live Unsafe.allocateMemory blocks by address
+	offHeapNext      int64                       // address of the next off-heap block
+	decoded          decodeCache                 // method code -> decoded form
+	metrics          *Metrics                    // execution counters, nil unless enabled
+	budget           budget                      // instructions counted against the budgets
+	frames           framePool                   // released frames for reuse
+	natives          nativeRegistry              // natives added with RegisterNative
+	stdin            *bufio.Reader               // buffers Stdin
+	stdinObj         *JObject                    // System.in
 }
 
 // NewVM creates a new VM with the given class loader.
 func NewVM(cl ClassLoader) *VM {
 	return &VM{
-		ClassLoader:        cl,
-		Stdout:             os.Stdout,
-		staticFields:       make(map[string]map[string]Value),
-		initializedClasses: make(map[string]bool),
+		ClassLoader:  cl,
+		Stdin:        os.Stdin,
+		Stdout:       os.Stdout,
+		Stderr:       os.Stderr,
+		staticFields: make(map[string]map[string]Value),
+		classObjects: make(map[string]*JObject),
 	}
 }
 
 // Execute finds and executes the main method of the given class.
 func (vm *VM) Execute(mainClassName string) error {
+	vm.startCall()
 	cf, err := vm.ClassLoader.LoadClass(mainClassName)
 	if err != nil {
 		return err
 	}
+	if err := vm.linkClass(mainClassName); err != nil {
+		return err
+	}
 
 	method := cf.FindMethod("main", "([Ljava/lang/String;)V")
 	if method == nil {
@@ -64,10 +132,15 @@ func (vm *VM) Execute(mainClassName string) error {
 
 // executeMethod executes a method with the given arguments and returns its return value.
 func (vm *VM) executeMethod(cf *classfile.ClassFile, method *classfile.MethodInfo, args []Value) (Value, error) {
+	if vm.metrics != nil {
+		className, _ := cf.ClassName()
+		vm.metrics.countCall(className, method.Name, method.Descriptor)
+	}
+
 	// Check for native method
 	if method.AccessFlags&AccNative != 0 {
 		className, _ := cf.ClassName()
-		return vm.executeNativeMethod(className, method.Name, method.Descriptor, args)
+		return vm.callNative(className, method.Name, method.Descriptor, args)
 	}
 
 	// Check for abstract method
@@ -80,13 +153,153 @@ func (vm *VM) executeMethod(cf *classfile.ClassFile, method *classfile.MethodInf
 		return Value{}, fmt.Errorf("method %s has no Code attribute", method.Name)
 	}
 
-	vm.frameDepth++
-	if vm.frameDepth > maxFrameDepth {
-		return Value{}, fmt.Errorf("stack overflow: frame depth exceeded %d", maxFrameDepth)
+	return vm.interpret(cf, method, args)
+}
+
+// interpret runs a bytecode method. Invoke instructions that call another
+// bytecode method do not recurse: they leave the call in vm.pending, and
+// the loop pushes an activation for it onto vm.callStack and carries on
+// with the callee, returning to the caller when the callee returns or
+// throws. Java recursion therefore uses no Go stack. Calls the VM makes
+// itself, for <clinit>, natives, lambdas and the like, still go through
+// executeMethod and run in a loop of their own above the caller's.
+func (vm *VM) interpret(cf *classfile.ClassFile, method *classfile.MethodInfo, args []Value) (Value, error) {
+	base := len(vm.callStack)
+	running := vm.running
+	defer func() {
+		// Unwind whatever a panic left behind.
+		for len(vm.callStack) > base {
+			vm.exit()
+		}
+		vm.running = running
+	}()
+
+	if err := vm.enter(cf, method, args); err != nil {
+		return Value{}, err
+	}
+	var err error
+	for {
+		var retVal Value
+		var done bool
+		if retVal, done, err = vm.run(base, err); done {
+			return retVal, err
+		}
+	}
+}
+
+// run executes the activations above base until the one at base returns
+// or throws, which it reports as done. If throw is not nil, the innermost
+// activation throws it first. A panic stops run, which returns the error
+// to throw from the innermost activation instead, not done: a VerifyError
+// for an instruction that overflows the operand stack, which verified
+// code cannot do, and an InternalError for anything else.
+func (vm *VM) run(base int, throw error) (retVal Value, done bool, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = vm.recovered(r)
+			retVal, done = Value{}, len(vm.callStack) <= base
+		}
+	}()
+	err = throw
+	for {
+		// The innermost activation. The call stack may be reallocated by
+		// anything that calls Java code, so act is looked up again after
+		// executing an instruction.
+		act := &vm.callStack[len(vm.callStack)-1]
+		vm.running = act.frame
+		frame := act.frame
+		var retVal Value
+		returning := err == nil && frame.PC >= len(frame.Code) // fell off the end of a void method
+		located := false                                       // whether err already names its location
+		if !returning && err == nil {
+			opcode := frame.Code[frame.PC]
+			act.pc = frame.PC
+			frame.PC++
+			if vm.metrics != nil {
+				atomic.AddUint64(&vm.metrics.instructions, 1)
+			}
+			if vm.limited() {
+				if err = vm.chargeInstruction(); err != nil {
+					err = fmt.Errorf("at %s (PC=%d): %w", sourceLocation(act.class, act.method, act.pc), act.pc, err)
+					located = true
+				}
+			}
+			if err == nil {
+				if vm.Trace != nil {
+					vm.Trace.instruction(vm.traceThread(), act.pc, opcode)
+				}
+				if vm.CheckStackTypes {
+					checkOperandTypes(act.class, act.method, frame, act.pc, opcode)
+				}
+				if act.decoded != nil && act.decoded.at[act.pc] != nil {
+					vm.executeDecoded(frame, act.decoded.at[act.pc])
+					continue
+				}
+				retVal, returning, err = vm.executeInstruction(frame, opcode)
+				act = &vm.callStack[len(vm.callStack)-1]
+			}
+			if err == nil && vm.pending.method != nil {
+				call := vm.pending
+				vm.pending = callRequest{}
+				if err = vm.enter(call.class, call.method, call.args); err == nil {
+					vm.callStack[len(vm.callStack)-1].pushResult = call.pushResult
+					continue
+				}
+			}
+			if err == nil && returning && vm.CheckReturns {
+				if err = checkReturn(opcode, retVal, act.method.Descriptor); err != nil {
+					err = fmt.Errorf("at %s (PC=%d): %s.%s%s: %w", sourceLocation(act.class, act.method, act.pc), act.pc, act.className, act.method.Name, act.method.Descriptor, err)
+					located = true
+				}
+			}
+		}
+
+		// An exception propagates out of activations until one handles
+		// it, and other errors out of all of them, naming each location.
+		for err != nil {
+			if javaExc, ok := err.(*JavaException); ok {
+				describeNullPointer(act.class, act.method, act.pc, javaExc)
+				vm.recordStackTrace(javaExc)
+				if handler := vm.findExceptionHandler(act.method.Code, act.pc, javaExc, act.class); handler != nil {
+					act.frame.SP = 0
+					act.frame.Push(RefValue(javaExc.Object))
+					act.frame.PC = int(handler.HandlerPC)
+					err = nil
+					returning = false
+					break
+				}
+			} else if !located {
+				err = vm.locateError(act, err)
+			}
+			vm.exit()
+			if len(vm.callStack) == base {
+				return Value{}, true, err
+			}
+			act = &vm.callStack[len(vm.callStack)-1]
+			located = false
+		}
+
+		if returning && err == nil {
+			pushResult := act.pushResult
+			vm.exit()
+			if len(vm.callStack) == base {
+				return retVal, true, nil
+			}
+			if pushResult {
+				vm.callStack[len(vm.callStack)-1].frame.Push(retVal)
+			}
+		}
+	}
+}
+
+// enter pushes an activation of a bytecode method onto the call stack.
+func (vm *VM) enter(cf *classfile.ClassFile, method *classfile.MethodInfo, args []Value) error {
+	if vm.frameDepth >= maxFrameDepth {
+		return NewJavaException("java/lang/StackOverflowError")
 	}
-	defer func() { vm.frameDepth-- }()
+	vm.frameDepth++
 
-	frame := NewFrame(method.Code.MaxLocals, method.Code.MaxStack, method.Code.Code, cf)
+	frame := vm.newFrame(method.Code.MaxLocals, method.Code.MaxStack, method.Code.Code, cf)
 
 	// Set arguments into local variables.
 	// Long and double values occupy two slots per JVM spec.
@@ -100,230 +313,238 @@ func (vm *VM) executeMethod(cf *classfile.ClassFile, method *classfile.MethodInf
 	}
 
 	className, _ := cf.ClassName()
-	// Execution loop
-	for frame.PC < len(frame.Code) {
-		opcode := frame.Code[frame.PC]
-		instructionPC := frame.PC
-		frame.PC++
+	vm.callStack = append(vm.callStack, stackEntry{class: cf, method: method, frame: frame, className: className})
+	act := &vm.callStack[len(vm.callStack)-1]
 
-		retVal, hasReturn, err := vm.executeInstruction(frame, opcode)
-		if err != nil {
-			javaExc, isJavaExc := err.(*JavaException)
-			if !isJavaExc {
-				return Value{}, fmt.Errorf("in %s.%s:%s at PC=%d: %w", className, method.Name, method.Descriptor, instructionPC, err)
-			}
-			// Search exception table for matching handler
-			handler := vm.findExceptionHandler(method.Code, instructionPC, javaExc, cf)
-			if handler != nil {
-				frame.SP = 0
-				frame.Push(RefValue(javaExc.Object))
-				frame.PC = int(handler.HandlerPC)
-				continue
-			}
-			// No handler found, propagate
-			return Value{}, javaExc
-		}
-		if hasReturn {
-			return retVal, nil
-		}
+	if vm.Trace != nil {
+		vm.Trace.call(vm.traceThread(), className, method.Name, method.Descriptor)
 	}
 
-	// Fell off the end of the method (implicit return for void methods)
-	return Value{}, nil
-}
-
-// executeNativeMethod dispatches native method calls.
-func (vm *VM) executeNativeMethod(className, methodName, descriptor string, args []Value) (Value, error) {
-	key := className + "." + methodName + ":" + descriptor
-
-	switch key {
-	case "java/lang/Object.hashCode:()I":
-		obj, ok := args[0].Ref.(*JObject)
-		if !ok {
-			return Value{}, fmt.Errorf("Object.hashCode: receiver is not a JObject")
-		}
-		hash := int32(reflect.ValueOf(obj).Pointer() & 0x7FFFFFFF)
-		return IntValue(hash), nil
-
-	case "java/lang/Object.getClass:()Ljava/lang/Class;":
-		obj, ok := args[0].Ref.(*JObject)
-		if !ok {
-			return Value{}, fmt.Errorf("Object.getClass: receiver is not a JObject")
-		}
-		classObj := &JObject{
-			ClassName: "java/lang/Class",
-			Fields:    map[string]Value{"name": RefValue(obj.ClassName)},
-		}
-		return RefValue(classObj), nil
-
-	case "java/lang/Class.getPrimitiveClass:(Ljava/lang/String;)Ljava/lang/Class;":
-		name := ""
-		if args[0].Ref != nil {
-			name = fmt.Sprintf("%v", args[0].Ref)
+	if method.AccessFlags&AccSynchronized != 0 {
+		lock := vm.classObject(className)
+		if method.AccessFlags&classfile.AccStatic == 0 && len(args) > 0 {
+			lock = args[0]
 		}
-		classObj := &JObject{
-			ClassName: "java/lang/Class",
-			Fields:    map[string]Value{"name": RefValue(name)},
+		if err := vm.monitorEnter(frame, lock); err != nil {
+			vm.exit()
+			return err
 		}
-		return RefValue(classObj), nil
-
-	case "java/lang/Class.desiredAssertionStatus0:(Ljava/lang/Class;)Z",
-		"java/lang/Class.desiredAssertionStatus:()Z":
-		return IntValue(0), nil
-
-	case "jdk/internal/misc/VM.getSavedProperty:(Ljava/lang/String;)Ljava/lang/String;":
-		return NullValue(), nil
-
-	case "jdk/internal/misc/CDS.initializeFromArchive:(Ljava/lang/Class;)V":
-		return Value{}, nil
-
-	case "jdk/internal/misc/CDS.isDumpingClassList0:()Z",
-		"jdk/internal/misc/CDS.isDumpingArchive0:()Z",
-		"jdk/internal/misc/CDS.isSharingEnabled0:()Z":
-		return IntValue(0), nil
-
-	case "jdk/internal/misc/CDS.getRandomSeedForDumping:()J":
-		return LongValue(0), nil
-
-	case "java/lang/Float.floatToRawIntBits:(F)I":
-		return IntValue(int32(math.Float32bits(args[0].Float))), nil
-
-	case "java/lang/Double.doubleToRawLongBits:(D)J":
-		return LongValue(int64(math.Float64bits(args[0].Double))), nil
-
-	case "java/lang/Double.longBitsToDouble:(J)D":
-		return DoubleValue(math.Float64frombits(uint64(args[0].Long))), nil
-
-	case "java/lang/Math.sqrt:(D)D":
-		return DoubleValue(math.Sqrt(args[0].Double)), nil
-
-	case "java/lang/Math.pow:(DD)D":
-		return DoubleValue(math.Pow(args[0].Double, args[1].Double)), nil
-
-	case "java/lang/StrictMath.sqrt:(D)D":
-		return DoubleValue(math.Sqrt(args[0].Double)), nil
-	case "java/lang/StrictMath.floor:(D)D",
-		"java/lang/Math.floor:(D)D":
-		return DoubleValue(math.Floor(args[0].Double)), nil
-	case "java/lang/StrictMath.ceil:(D)D",
-		"java/lang/Math.ceil:(D)D":
-		return DoubleValue(math.Ceil(args[0].Double)), nil
-
-	case "java/lang/System.registerNatives:()V",
-		"java/lang/Object.registerNatives:()V",
-		"java/lang/Class.registerNatives:()V":
-		return Value{}, nil
-
-	case "java/lang/Throwable.fillInStackTrace:(I)Ljava/lang/Throwable;":
-		// Return this (args[0]) — stack trace recording not supported
-		return args[0], nil
-
-	case "java/lang/Float.isNaN:(F)Z":
-		return IntValue(0), nil
-
-	case "java/lang/String.intern:()Ljava/lang/String;":
-		return args[0], nil
-
-	case "jdk/internal/misc/Unsafe.getUnsafe:()Ljdk/internal/misc/Unsafe;":
-		obj := &JObject{ClassName: "jdk/internal/misc/Unsafe", Fields: make(map[string]Value)}
-		return RefValue(obj), nil
-
-	case "jdk/internal/misc/Unsafe.storeFence:()V":
-		return Value{}, nil
-
-	case "java/lang/Class.isArray:()Z":
-		return IntValue(0), nil
+	}
 
-	case "java/lang/Class.isPrimitive:()Z":
-		return IntValue(0), nil
+	act.decoded = vm.decodedCode(method.Code)
+	if act.decoded != nil && act.decoded.err != nil {
+		err := act.decoded.err
+		vm.exit()
+		return NewJavaExceptionMessage("java/lang/VerifyError", fmt.Sprintf("%s.%s%s: %v", className, method.Name, method.Descriptor, err))
+	}
+	return nil
+}
 
-	case "jdk/internal/misc/Unsafe.arrayBaseOffset:(Ljava/lang/Class;)I":
-		return IntValue(0), nil
+// exit pops the innermost activation off the call stack. Monitors it
+// still holds, whether it returned or threw, are released.
+func (vm *VM) exit() {
+	frame := vm.callStack[len(vm.callStack)-1].frame
+	vm.releaseFrameMonitors(frame)
+	if vm.Trace != nil {
+		vm.Trace.ret(vm.traceThread())
+	}
+	vm.callStack[len(vm.callStack)-1] = stackEntry{}
+	vm.callStack = vm.callStack[:len(vm.callStack)-1]
+	vm.releaseFrame(frame)
+	vm.frameDepth--
+}
 
-	case "jdk/internal/misc/Unsafe.arrayIndexScale:(Ljava/lang/Class;)I":
-		return IntValue(1), nil
+// locateError wraps an error other than a Java exception with the
+// location of the activation it leaves.
+func (vm *VM) locateError(act *stackEntry, err error) error {
+	var unknown *UnknownOpcodeError
+	if errors.As(err, &unknown) && unknown.Method == "" {
+		unknown.Method = fmt.Sprintf("%s.%s%s", act.className, act.method.Name, act.method.Descriptor)
+		unknown.StackDepth = act.frame.SP
+		unknown.Context = disassembleAround(act.frame.Code, unknown.PC, act.class.ConstantPool, disassemblyWindow)
+	}
+	return fmt.Errorf("at %s (PC=%d): %w", sourceLocation(act.class, act.method, act.pc), act.pc, err)
+}
 
-	case "jdk/internal/misc/Unsafe.objectFieldOffset1:(Ljava/lang/Class;Ljava/lang/String;)J":
-		return LongValue(0), nil
+// callRequest is a call of a bytecode method left by an invoke
+// instruction for the interpreter loop to run.
+type callRequest struct {
+	class      *classfile.ClassFile
+	method     *classfile.MethodInfo
+	args       []Value
+	pushResult bool // whether the result is pushed onto the caller's stack
+}
 
-	case "jdk/internal/misc/VM.initialize:()V":
-		// Set savedProps to an empty HashMap so getSavedProperty doesn't NPE
-		propsObj := &JObject{ClassName: "java/util/HashMap", Fields: make(map[string]Value)}
-		vm.setStaticField("jdk/internal/misc/VM", "savedProps", RefValue(propsObj))
-		return Value{}, nil
+// invoke calls a resolved method for an invoke instruction of frame and
+// pushes its result, if it has one. A bytecode method called from the
+// frame the innermost interpreter loop is running is left to that loop;
+// anything else, such as a native method or a call from a frame run
+// outside a loop, is called directly.
+func (vm *VM) invoke(frame *Frame, cf *classfile.ClassFile, method *classfile.MethodInfo, args []Value, descriptor string) (Value, bool, error) {
+	pushResult := !isVoidReturn(descriptor)
+	if frame == vm.running && method.AccessFlags&(AccNative|AccAbstract) == 0 && method.Code != nil {
+		if vm.metrics != nil {
+			className, _ := cf.ClassName()
+			vm.metrics.countCall(className, method.Name, method.Descriptor)
+		}
+		vm.pending = callRequest{class: cf, method: method, args: args, pushResult: pushResult}
+		return Value{}, false, nil
+	}
+	retVal, err := vm.executeMethod(cf, method, args)
+	if err != nil {
+		return Value{}, false, err
+	}
+	if pushResult {
+		frame.Push(retVal)
+	}
+	return Value{}, false, nil
+}
 
-	case "java/lang/StringUTF16.isBigEndian:()Z":
-		return IntValue(0), nil
+// checkReturn reports whether a return instruction and the value it
+// returned agree with the return type of descriptor, as the verifier would
+// ensure. It checks value categories only, not reference types.
+func checkReturn(opcode byte, v Value, descriptor string) error {
+	ret := descriptor[strings.LastIndexByte(descriptor, ')')+1:]
+	var want byte
+	var types []ValueType
+	switch ret[0] {
+	case 'V':
+		want = OpReturn
+	case 'Z', 'B', 'C', 'S', 'I':
+		want, types = OpIreturn, []ValueType{TypeInt}
+	case 'J':
+		want, types = OpLreturn, []ValueType{TypeLong}
+	case 'F':
+		want, types = OpFreturn, []ValueType{TypeFloat}
+	case 'D':
+		want, types = OpDreturn, []ValueType{TypeDouble}
+	default:
+		want, types = OpAreturn, []ValueType{TypeRef, TypeNull}
+	}
+	if opcode != want {
+		return fmt.Errorf("%s in a method returning %s", OpcodeName(opcode), ret)
+	}
+	if opcode == OpReturn {
+		return nil
+	}
+	for _, t := range types {
+		if v.Type == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s of %s value in a method returning %s", OpcodeName(opcode), v.Type, ret)
+}
 
-	case "java/lang/System.arraycopy:(Ljava/lang/Object;ILjava/lang/Object;II)V":
-		return vm.nativeArraycopy(args)
+// primitiveDescriptors maps the names of the primitive pseudo-classes
+// returned by Class.getPrimitiveClass to their descriptors.
+var primitiveDescriptors = map[string]string{
+	"boolean": "Z", "byte": "B", "char": "C", "short": "S",
+	"int": "I", "long": "J", "float": "F", "double": "D", "void": "V",
+}
 
-	case "java/lang/Class.forName0:(Ljava/lang/String;ZLjava/lang/ClassLoader;Ljava/lang/Class;)Ljava/lang/Class;":
-		name := ""
-		if args[0].Ref != nil {
-			name = fmt.Sprintf("%v", args[0].Ref)
-		}
-		classObj := &JObject{
+// classObject returns the canonical java/lang/Class object for name, which
+// is an internal class name ("java/lang/String"), an array descriptor
+// ("[I") or a primitive name ("int"). The same object is returned for the
+// same name so that class literals compare equal with ==.
+func (vm *VM) classObject(name string) Value {
+	if vm.classObjects == nil {
+		vm.classObjects = make(map[string]*JObject)
+	}
+	obj, ok := vm.classObjects[name]
+	if !ok {
+		obj = &JObject{
 			ClassName: "java/lang/Class",
 			Fields:    map[string]Value{"name": RefValue(name)},
 		}
-		return RefValue(classObj), nil
-
-	case "java/lang/Object.notifyAll:()V",
-		"java/lang/Object.notify:()V":
-		return Value{}, nil
-
-	case "java/lang/Thread.currentThread:()Ljava/lang/Thread;":
-		obj := &JObject{ClassName: "java/lang/Thread", Fields: make(map[string]Value)}
-		return RefValue(obj), nil
-
-	case "java/lang/Thread.setPriority:(I)V":
-		return Value{}, nil
-
-	case "java/lang/Runtime.maxMemory:()J":
-		return LongValue(256 * 1024 * 1024), nil
-
-	case "java/lang/System.nanoTime:()J":
-		return LongValue(0), nil
-
-	case "jdk/internal/misc/Unsafe.compareAndSetInt:(Ljava/lang/Object;JII)Z":
-		return IntValue(1), nil // pretend CAS always succeeds
-
-	case "jdk/internal/misc/Unsafe.compareAndSetLong:(Ljava/lang/Object;JJJ)Z":
-		return IntValue(1), nil
-
-	case "jdk/internal/misc/Unsafe.compareAndSetReference:(Ljava/lang/Object;JLjava/lang/Object;Ljava/lang/Object;)Z":
-		return IntValue(1), nil
+		vm.classObjects[name] = obj
+	}
+	return RefValue(obj)
+}
 
-	case "jdk/internal/misc/Unsafe.getIntVolatile:(Ljava/lang/Object;J)I":
-		return IntValue(0), nil
+// classObjectName returns the name recorded in a java/lang/Class object.
+func classObjectName(classRef Value) string {
+	if obj, ok := classRef.Ref.(*JObject); ok {
+		name, _ := obj.Fields["name"].Ref.(string)
+		return name
+	}
+	return ""
+}
 
-	case "jdk/internal/misc/Unsafe.getReferenceVolatile:(Ljava/lang/Object;J)Ljava/lang/Object;":
-		return NullValue(), nil
+// descriptorClassName converts a field descriptor to the name used by
+// classObject: "I" -> "int", "Ljava/lang/String;" -> "java/lang/String",
+// and array descriptors are returned unchanged.
+func descriptorClassName(desc string) string {
+	for name, d := range primitiveDescriptors {
+		if d == desc {
+			return name
+		}
+	}
+	if strings.HasPrefix(desc, "L") && strings.HasSuffix(desc, ";") {
+		return desc[1 : len(desc)-1]
+	}
+	return desc
+}
 
-	case "jdk/internal/misc/Unsafe.putReferenceVolatile:(Ljava/lang/Object;JLjava/lang/Object;)V":
-		return Value{}, nil
+// classDescriptor converts a class name as found in CONSTANT_Class entries
+// to a field descriptor: "java/lang/String" -> "Ljava/lang/String;", and
+// array descriptors are returned unchanged.
+func classDescriptor(name string) string {
+	if name == "" || strings.HasPrefix(name, "[") {
+		return name
+	}
+	return "L" + name + ";"
+}
 
-	case "jdk/internal/misc/Unsafe.getObjectSize:(Ljava/lang/Object;)J":
-		return LongValue(16), nil
+// nestHostOf returns the nest host of className. A NestHost claim is only
+// honoured if the host lists the class in its NestMembers; otherwise the
+// class is treated as the host of its own nest, as the JVM does.
+func (vm *VM) nestHostOf(className string) string {
+	cf, err := vm.ClassLoader.LoadClass(className)
+	if err != nil {
+		return className
+	}
+	host := cf.NestHostName()
+	if host == className {
+		return className
+	}
+	hostCf, err := vm.ClassLoader.LoadClass(host)
+	if err != nil || !hostCf.HasNestMember(className) {
+		return className
+	}
+	return host
+}
 
-	case "java/lang/Class.getComponentType:()Ljava/lang/Class;":
-		return NullValue(), nil
+// classFileOfClassObject loads the class file behind a java/lang/Class
+// object, or returns nil for primitives, arrays and unknown classes.
+func (vm *VM) classFileOfClassObject(classRef Value) *classfile.ClassFile {
+	name := classObjectName(classRef)
+	if name == "" || strings.HasPrefix(name, "[") {
+		return nil
+	}
+	if _, ok := primitiveDescriptors[name]; ok {
+		return nil
+	}
+	cf, err := vm.ClassLoader.LoadClass(name)
+	if err != nil {
+		return nil
+	}
+	return cf
+}
 
-	case "java/lang/Class.isAssignableFrom:(Ljava/lang/Class;)Z":
-		return IntValue(1), nil
+// executeNativeMethod dispatches native method calls.
+func (vm *VM) executeNativeMethod(className, methodName, descriptor string, args []Value) (Value, error) {
+	if fn, ok := vm.natives[nativeKey(className, methodName, descriptor)]; ok {
+		return fn(vm, args)
+	}
 
-	case "jdk/internal/reflect/Reflection.getCallerClass:()Ljava/lang/Class;":
-		classObj := &JObject{
-			ClassName: "java/lang/Class",
-			Fields:    map[string]Value{"name": RefValue("java/lang/Object")},
+	if className == unsafeClass {
+		if retVal, handled, err := vm.handleUnsafe(methodName, descriptor, args); handled {
+			return retVal, err
 		}
-		return RefValue(classObj), nil
+	}
 
-	case "java/lang/reflect/Array.newArray:(Ljava/lang/Class;I)Ljava/lang/Object;":
-		length := int(args[1].Int)
-		arr := &JArray{Elements: make([]Value, length)}
-		return RefValue(arr), nil
+	if fn := builtinNatives[nativeKey(className, methodName, descriptor)]; fn != nil {
+		return fn(vm, args)
 	}
 
 	// registerNatives pattern
@@ -335,44 +556,167 @@ func (vm *VM) executeNativeMethod(className, methodName, descriptor string, args
 		return Value{}, nil
 	}
 
+	if vm.NativeFallback != nil {
+		ret, err := vm.NativeFallback(vm, className, methodName, descriptor, args)
+		if !errors.Is(err, ErrUnimplemented) {
+			return ret, err
+		}
+	}
 	return Value{}, fmt.Errorf("native method not implemented: %s.%s:%s", className, methodName, descriptor)
 }
 
-// ensureInitialized runs <clinit> for a class if it hasn't been run yet.
-func (vm *VM) ensureInitialized(className string) error {
-	if vm.initializedClasses[className] {
+// checkPreview rejects a class file compiled with preview features unless
+// EnablePreview is set, throwing UnsupportedClassVersionError as java does
+// without --enable-preview. Preview classes of any major version are
+// accepted when it is set: the VM has no version-specific behaviour to
+// switch on, and what they use is checked as they run.
+func (vm *VM) checkPreview(className string, cf *classfile.ClassFile) error {
+	if !cf.UsesPreview() || vm.EnablePreview {
 		return nil
 	}
-	vm.initializedClasses[className] = true // set before to prevent recursion
+	return NewJavaExceptionMessage("java/lang/UnsupportedClassVersionError", fmt.Sprintf(
+		"Preview features are not enabled for %s (class file version %d.%d). Try running with '--enable-preview'",
+		strings.ReplaceAll(className, "/", "."), cf.MajorVersion, cf.MinorVersion))
+}
 
+// linkClass rejects className, once and for all, if it cannot be linked:
+// it uses preview features that are not enabled, or a sealed supertype
+// does not permit it. It runs wherever a class is resolved, not only when
+// it is initialized, so that such a class fails even if it is only cast to
+// or named. Classes that cannot be loaded pass; using them fails
+// elsewhere. Array classes check their element class.
+func (vm *VM) linkClass(className string) error {
+	className = strings.TrimLeft(className, "[")
+	if strings.HasPrefix(className, "L") && strings.HasSuffix(className, ";") {
+		className = className[1 : len(className)-1]
+	}
+	if len(className) <= 1 || vm.ClassLoader == nil { // primitive element types, or nothing to load from
+		return nil
+	}
+	if err, done := vm.linked.Load(className); done {
+		err, _ := err.(error)
+		return err
+	}
 	cf, err := vm.ClassLoader.LoadClass(className)
 	if err != nil {
-		vm.initializedClasses[className] = false
-		return nil // class not found is OK for initialization
+		return nil
+	}
+	err = vm.checkPreview(className, cf)
+	if err == nil {
+		err = vm.checkPermittedSubclass(className, cf)
 	}
+	vm.linked.Store(className, err)
+	return err
+}
 
-	// Initialize superclass first
-	superName := cf.SuperClassName()
-	if superName != "" {
-		if err := vm.ensureInitialized(superName); err != nil {
-			return err
+// checkPermittedSubclass verifies that every sealed direct superclass or
+// superinterface of cf lists it in PermittedSubclasses, throwing
+// IncompatibleClassChangeError otherwise.
+func (vm *VM) checkPermittedSubclass(className string, cf *classfile.ClassFile) error {
+	supers := make([]string, 0, len(cf.Interfaces)+1)
+	if name := cf.SuperClassName(); name != "" {
+		supers = append(supers, name)
+	}
+	for _, idx := range cf.Interfaces {
+		if name, err := classfile.GetClassName(cf.ConstantPool, idx); err == nil {
+			supers = append(supers, name)
 		}
 	}
-
-	// Run <clinit> if present
-	clinit := cf.FindMethod("<clinit>", "()V")
-	if clinit != nil {
-		_, err := vm.executeMethod(cf, clinit, nil)
+	for _, name := range supers {
+		superCf, err := vm.ClassLoader.LoadClass(name)
 		if err != nil {
-			if _, ok := err.(*JavaException); ok {
-				return err // JavaException はそのまま伝播
-			}
-			return fmt.Errorf("error in <clinit> of %s: %w", className, err)
+			continue
+		}
+		if !superCf.Permits(className) {
+			return NewJavaExceptionMessage("java/lang/IncompatibleClassChangeError", fmt.Sprintf(
+				"class %s cannot inherit from sealed class %s", className, name))
 		}
 	}
 	return nil
 }
 
+// prepareStaticFields gives each static field of a class that has not been
+// set yet the default value of its type (JVMS §5.4.2), and seeds fields
+// that carry a ConstantValue attribute with it.
+func (vm *VM) prepareStaticFields(className string, cf *classfile.ClassFile) {
+	for _, field := range cf.Fields {
+		if field.AccessFlags&classfile.AccStatic == 0 {
+			continue
+		}
+		if _, ok := vm.getStaticFieldOk(className, field.Name); !ok {
+			vm.setStaticField(className, field.Name, defaultValueForDescriptor(field.Descriptor))
+		}
+		if field.ConstantValue == nil {
+			continue
+		}
+		var val Value
+		switch c := field.ConstantValue.(type) {
+		case *classfile.ConstantInteger:
+			val = IntValue(c.Value)
+		case *classfile.ConstantFloat:
+			val = FloatValue(c.Value)
+		case *classfile.ConstantLong:
+			val = LongValue(c.Value)
+		case *classfile.ConstantDouble:
+			val = DoubleValue(c.Value)
+		case *classfile.ConstantString:
+			str, err := classfile.GetUtf8(cf.ConstantPool, c.StringIndex)
+			if err != nil {
+				continue
+			}
+			val = RefValue(str)
+		default:
+			continue
+		}
+		vm.setStaticField(className, field.Name, fieldValue(field.Descriptor, val))
+	}
+}
+
+// staticFieldOwner resolves a static field reference to the class that
+// declares the field (JVMS §5.4.3.2): the named class, then its
+// superinterfaces, then its superclasses. A field that cannot be found,
+// as for classes the loader does not have, is taken to be declared by the
+// named class itself.
+func (vm *VM) staticFieldOwner(className, fieldName string) string {
+	key := className + "." + fieldName
+	if owner, ok := vm.fieldOwners[key]; ok {
+		return owner
+	}
+	owner := vm.findFieldOwner(className, fieldName, make(map[string]bool))
+	if owner == "" {
+		return className
+	}
+	if vm.fieldOwners == nil {
+		vm.fieldOwners = make(map[string]string)
+	}
+	vm.fieldOwners[key] = owner
+	return owner
+}
+
+func (vm *VM) findFieldOwner(className, fieldName string, visited map[string]bool) string {
+	if className == "" || visited[className] || vm.ClassLoader == nil {
+		return ""
+	}
+	visited[className] = true
+	cf, err := vm.ClassLoader.LoadClass(className)
+	if err != nil {
+		return ""
+	}
+	for _, field := range cf.Fields {
+		if field.Name == fieldName {
+			return className
+		}
+	}
+	for _, idx := range cf.Interfaces {
+		if iface, err := classfile.GetClassName(cf.ConstantPool, idx); err == nil {
+			if owner := vm.findFieldOwner(iface, fieldName, visited); owner != "" {
+				return owner
+			}
+		}
+	}
+	return vm.findFieldOwner(cf.SuperClassName(), fieldName, visited)
+}
+
 // getStaticField returns the value of a static field.
 func (vm *VM) getStaticField(className, fieldName string) Value {
 	if fields, ok := vm.staticFields[className]; ok {
@@ -412,6 +756,31 @@ func defaultValueForDescriptor(descriptor string) Value {
 	}
 }
 
+// fieldValue converts a value stored into a field to the field's type:
+// ints are narrowed for boolean, byte, char and short fields as putfield
+// and putstatic do, and numbers of the wrong kind are converted for long,
+// float and double fields.
+func fieldValue(descriptor string, v Value) Value {
+	if len(descriptor) == 0 {
+		return v
+	}
+	switch descriptor[0] {
+	case 'Z':
+		return IntValue(v.Int & 1)
+	case 'B':
+		return IntValue(int32(int8(v.Int)))
+	case 'C':
+		return IntValue(int32(uint16(v.Int)))
+	case 'S':
+		return IntValue(int32(int16(v.Int)))
+	case 'I', 'J', 'F', 'D':
+		if v.Type == TypeInt || v.Type == TypeLong || v.Type == TypeFloat || v.Type == TypeDouble {
+			return convertPrimitive(v, descriptor[0])
+		}
+	}
+	return v
+}
+
 // setStaticField sets the value of a static field.
 func (vm *VM) setStaticField(className, fieldName string, val Value) {
 	if _, ok := vm.staticFields[className]; !ok {
@@ -524,32 +893,18 @@ func (vm *VM) executeLdc(frame *Frame, index uint16) (Value, bool, error) {
 		return Value{}, false, fmt.Errorf("ldc: invalid constant pool index %d", index)
 	}
 
-	entry := pool[index]
-	switch c := entry.(type) {
-	case *classfile.ConstantInteger:
-		frame.Push(IntValue(c.Value))
-	case *classfile.ConstantFloat:
-		frame.Push(FloatValue(c.Value))
-	case *classfile.ConstantString:
-		str, err := classfile.GetUtf8(pool, c.StringIndex)
-		if err != nil {
-			return Value{}, false, fmt.Errorf("ldc: resolving string: %w", err)
-		}
-		frame.Push(RefValue(str))
-	case *classfile.ConstantClass:
-		name, err := classfile.GetUtf8(pool, c.NameIndex)
-		if err != nil {
-			return Value{}, false, fmt.Errorf("ldc: resolving class name: %w", err)
-		}
-		classObj := &JObject{
-			ClassName: "java/lang/Class",
-			Fields:    map[string]Value{"name": RefValue(name)},
+	switch entry := pool[index].(type) {
+	case *classfile.ConstantLong, *classfile.ConstantDouble:
+		return Value{}, false, fmt.Errorf("ldc: constant pool index %d (tag=%d) needs ldc2_w", index, entry.Tag())
+	}
+	v, err := vm.resolveConstant(frame.Class, index)
+	if err != nil {
+		if _, ok := err.(*JavaException); ok {
+			return Value{}, false, err
 		}
-		frame.Push(RefValue(classObj))
-	default:
-		return Value{}, false, fmt.Errorf("ldc: unsupported constant pool entry type at index %d (tag=%d)", index, entry.Tag())
+		return Value{}, false, fmt.Errorf("ldc: %w", err)
 	}
-
+	frame.Push(v)
 	return Value{}, false, nil
 }
 
@@ -562,18 +917,38 @@ func (vm *VM) executeGetstatic(frame *Frame) (Value, bool, error) {
 	if err != nil {
 		return Value{}, false, fmt.Errorf("getstatic: %w", err)
 	}
+	if val, ok := numberConstants[fieldRef.ClassName][fieldRef.FieldName]; ok {
+		frame.Push(val)
+		return Value{}, false, nil
+	}
+	if fieldRef.ClassName == "java/lang/Boolean" && (fieldRef.FieldName == "TRUE" || fieldRef.FieldName == "FALSE") {
+		frame.Push(vm.box(fieldRef.ClassName, boolValue(fieldRef.FieldName == "TRUE")))
+		return Value{}, false, nil
+	}
+	owner := vm.staticFieldOwner(fieldRef.ClassName, fieldRef.FieldName)
 
-	if err := vm.ensureInitialized(fieldRef.ClassName); err != nil {
-		return Value{}, false, fmt.Errorf("getstatic: initializing %s: %w", fieldRef.ClassName, err)
+	if err := vm.ensureInitialized(owner); err != nil {
+		if _, ok := err.(*JavaException); ok {
+			return Value{}, false, err
+		}
+		return Value{}, false, fmt.Errorf("getstatic: initializing %s: %w", owner, err)
 	}
 
-	// Handle java/lang/System.out
+	// Handle java/lang/System.out and System.err
 	if fieldRef.ClassName == "java/lang/System" && fieldRef.FieldName == "out" {
 		frame.Push(RefValue(&native.PrintStream{Writer: vm.Stdout}))
 		return Value{}, false, nil
 	}
+	if fieldRef.ClassName == "java/lang/System" && fieldRef.FieldName == "err" {
+		frame.Push(RefValue(&native.PrintStream{Writer: vm.Stderr, Err: true}))
+		return Value{}, false, nil
+	}
+	if fieldRef.ClassName == "java/lang/System" && fieldRef.FieldName == "in" {
+		frame.Push(vm.stdinObject())
+		return Value{}, false, nil
+	}
 
-	val, ok := vm.getStaticFieldOk(fieldRef.ClassName, fieldRef.FieldName)
+	val, ok := vm.getStaticFieldOk(owner, fieldRef.FieldName)
 	if !ok {
 		// Field never set: return type-appropriate default
 		val = defaultValueForDescriptor(fieldRef.Descriptor)
@@ -591,13 +966,17 @@ func (vm *VM) executePutstatic(frame *Frame) (Value, bool, error) {
 	if err != nil {
 		return Value{}, false, fmt.Errorf("putstatic: %w", err)
 	}
+	owner := vm.staticFieldOwner(fieldRef.ClassName, fieldRef.FieldName)
 
-	if err := vm.ensureInitialized(fieldRef.ClassName); err != nil {
-		return Value{}, false, fmt.Errorf("putstatic: initializing %s: %w", fieldRef.ClassName, err)
+	if err := vm.ensureInitialized(owner); err != nil {
+		if _, ok := err.(*JavaException); ok {
+			return Value{}, false, err
+		}
+		return Value{}, false, fmt.Errorf("putstatic: initializing %s: %w", owner, err)
 	}
 
 	value := frame.Pop()
-	vm.setStaticField(fieldRef.ClassName, fieldRef.FieldName, value)
+	vm.setStaticField(owner, fieldRef.FieldName, fieldValue(fieldRef.Descriptor, value))
 	return Value{}, false, nil
 }
 
@@ -613,7 +992,7 @@ func (vm *VM) executeGetfield(frame *Frame) (Value, bool, error) {
 
 	objectRef := frame.Pop()
 	if objectRef.Type == TypeNull || objectRef.Ref == nil {
-		return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		return Value{}, false, nullOperand()
 	}
 	obj, ok := objectRef.Ref.(*JObject)
 	if !ok {
@@ -642,14 +1021,14 @@ func (vm *VM) executePutfield(frame *Frame) (Value, bool, error) {
 	value := frame.Pop()
 	objectRef := frame.Pop()
 	if objectRef.Type == TypeNull || objectRef.Ref == nil {
-		return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		return Value{}, false, nullOperand()
 	}
 	obj, ok := objectRef.Ref.(*JObject)
 	if !ok {
 		return Value{}, false, fmt.Errorf("putfield: receiver is not a JObject")
 	}
 
-	obj.Fields[fieldRef.FieldName] = value
+	obj.Fields[fieldRef.FieldName] = fieldValue(fieldRef.Descriptor, value)
 	return Value{}, false, nil
 }
 
@@ -685,10 +1064,7 @@ func (vm *VM) executeInvokevirtual(frame *Frame) (Value, bool, error) {
 	// Handle array clone
 	if arr, ok := objectRef.Ref.(*JArray); ok {
 		if methodRef.MethodName == "clone" {
-			newElements := make([]Value, len(arr.Elements))
-			copy(newElements, arr.Elements)
-			newArr := &JArray{Elements: newElements}
-			frame.Push(RefValue(newArr))
+			frame.Push(RefValue(arr.Clone()))
 			return Value{}, false, nil
 		}
 	}
@@ -706,11 +1082,11 @@ func (vm *VM) executeInvokevirtual(frame *Frame) (Value, bool, error) {
 	}
 
 	if objectRef.Type == TypeNull || objectRef.Ref == nil {
-		return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		return Value{}, false, nullOperand()
 	}
 
-	// StringBuilder native handling
-	if obj, ok := objectRef.Ref.(*JObject); ok && obj.ClassName == "java/lang/StringBuilder" {
+	// StringBuilder and StringBuffer native handling
+	if obj, ok := objectRef.Ref.(*JObject); ok && isStringBuilderClass(obj.ClassName) {
 		retVal, _, err := vm.handleStringBuilder(objectRef, methodRef.MethodName, methodRef.Descriptor, args)
 		if err != nil {
 			return Value{}, false, err
@@ -721,28 +1097,109 @@ func (vm *VM) executeInvokevirtual(frame *Frame) (Value, bool, error) {
 		return Value{}, false, nil
 	}
 
-	// JObject method resolution via ClassLoader
-	obj, ok := objectRef.Ref.(*JObject)
-	if !ok {
-		return Value{}, false, fmt.Errorf("invokevirtual: receiver is not a JObject for method %s.%s", methodRef.ClassName, methodRef.MethodName)
+	// Class.getName: mirrors record internal names, Java expects binary names
+	if obj, ok := objectRef.Ref.(*JObject); ok && obj.ClassName == "java/lang/Class" &&
+		methodRef.MethodName == "getName" && methodRef.Descriptor == "()Ljava/lang/String;" {
+		frame.Push(RefValue(strings.ReplaceAll(classObjectName(objectRef), "/", ".")))
+		return Value{}, false, nil
 	}
 
-	// Handle Integer/Long/Double native methods
-	if retVal, handled, err := vm.handleBoxedType(frame, obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
-		return retVal, false, err
+	// Thread context class loader and friends
+	if obj, ok := objectRef.Ref.(*JObject); ok && obj.ClassName == "java/lang/Thread" {
+		if retVal, handled := vm.handleThreadMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
 	}
 
-	// Lambda proxy dispatch
-	if obj.LambdaTarget != nil && methodRef.MethodName == obj.LambdaTarget.MethodName {
-		lt := obj.LambdaTarget
-		cf, method, err := vm.resolveMethod(lt.TargetClass, lt.TargetMethod, lt.TargetDesc)
+	// Stack trace elements and stack walking
+	if obj, ok := objectRef.Ref.(*JObject); ok {
+		if retVal, handled, err := vm.handleStackMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
+
+	// Native java.nio.file paths and File.toPath
+	if obj, ok := objectRef.Ref.(*JObject); ok {
+		if retVal, handled, err := vm.handlePathMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
+
+	// Native java.util.regex patterns and matchers
+	if obj, ok := objectRef.Ref.(*JObject); ok {
+		if retVal, handled, err := vm.handleRegexMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
+
+	// Package and module reflection
+	if obj, ok := objectRef.Ref.(*JObject); ok && isModuleReflectionClass(obj.ClassName) {
+		if retVal, handled := vm.handleModuleMethod(obj, methodRef.MethodName, methodRef.Descriptor); handled {
+			frame.Push(retVal)
+			return Value{}, false, nil
+		}
+	}
+
+	// DecimalFormat/NumberFormat native handling
+	if obj, ok := objectRef.Ref.(*JObject); ok && isNumberFormatClass(obj.ClassName) {
+		retVal, err := vm.handleDecimalFormat(objectRef, methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// In-memory stream native handling
+	if streamClass := nativeStreamClassOf(objectRef, methodRef.ClassName); streamClass != "" &&
+		!vm.overridesStreamMethod(objectRef.Ref.(*JObject).ClassName, streamClass, methodRef.MethodName, methodRef.Descriptor) {
+		retVal, err := vm.handleNativeStream(streamClass, objectRef, methodRef.MethodName, methodRef.Descriptor, args)
 		if err != nil {
 			return Value{}, false, err
 		}
-		fullArgs := make([]Value, 0, len(lt.CapturedArgs)+len(args))
-		fullArgs = append(fullArgs, lt.CapturedArgs...)
-		fullArgs = append(fullArgs, args...)
-		retVal, err := vm.executeMethod(cf, method, fullArgs)
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// JObject method resolution via ClassLoader
+	obj, ok := objectRef.Ref.(*JObject)
+	if !ok {
+		return Value{}, false, fmt.Errorf("invokevirtual: receiver is not a JObject for method %s.%s", methodRef.ClassName, methodRef.MethodName)
+	}
+
+	// Handle Integer/Long/Double native methods
+	if retVal, handled, err := vm.handleBoxedType(frame, obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+		return retVal, false, err
+	}
+
+	// Lambda proxy dispatch
+	if obj.LambdaTarget != nil && methodRef.MethodName == obj.LambdaTarget.MethodName {
+		retVal, err := vm.invokeLambda(obj.LambdaTarget, methodRef.Descriptor, args)
 		if err != nil {
 			return Value{}, false, err
 		}
@@ -753,6 +1210,17 @@ func (vm *VM) executeInvokevirtual(frame *Frame) (Value, bool, error) {
 	}
 
 	cf, method, err := vm.resolveMethod(obj.ClassName, methodRef.MethodName, methodRef.Descriptor)
+	if vm.throwableNative(obj.ClassName, cf, err) {
+		if retVal, handled, err := vm.handleThrowableMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
 	if err != nil {
 		return Value{}, false, err
 	}
@@ -760,93 +1228,40 @@ func (vm *VM) executeInvokevirtual(frame *Frame) (Value, bool, error) {
 	fullArgs := make([]Value, 0, len(args)+1)
 	fullArgs = append(fullArgs, objectRef)
 	fullArgs = append(fullArgs, args...)
-	retVal, err := vm.executeMethod(cf, method, fullArgs)
-	if err != nil {
-		return Value{}, false, err
-	}
-	if !isVoidReturn(methodRef.Descriptor) {
-		frame.Push(retVal)
-	}
-	return Value{}, false, nil
+	return vm.invoke(frame, cf, method, fullArgs, methodRef.Descriptor)
 }
 
 // handlePrintStream handles PrintStream method calls.
 func (vm *VM) handlePrintStream(frame *Frame, ps *native.PrintStream, methodName, descriptor string, args []Value) (Value, bool, error) {
-	if methodName == "println" {
-		switch descriptor {
-		case "(I)V":
-			ps.Println(args[0].Int)
-		case "(J)V":
-			ps.Println(args[0].Long)
-		case "(D)V":
-			d := args[0].Double
-			if d == float64(int64(d)) && !math.IsInf(d, 0) {
-				fmt.Fprintf(ps.Writer, "%s\n", strconv.FormatFloat(d, 'f', 1, 64))
-			} else {
-				fmt.Fprintf(ps.Writer, "%s\n", strconv.FormatFloat(d, 'f', -1, 64))
-			}
-		case "(F)V":
-			fmt.Fprintf(ps.Writer, "%v\n", args[0].Float)
-		case "(Z)V":
-			if args[0].Int != 0 {
-				ps.Println("true")
-			} else {
-				ps.Println("false")
-			}
-		case "(C)V":
-			fmt.Fprintf(ps.Writer, "%c\n", rune(args[0].Int))
-		case "(Ljava/lang/String;)V":
-			if s, ok := extractGoString(args[0]); ok {
-				ps.Println(s)
-			} else {
-				ps.Println(args[0].Ref)
-			}
-		case "(Ljava/lang/Object;)V":
-			ps.Println(vm.valueToString(args[0]))
-		case "()V":
-			ps.Println()
-		default:
-			return Value{}, false, fmt.Errorf("invokevirtual: unsupported println descriptor %s", descriptor)
-		}
-		return Value{}, false, nil
+	if methodName != "println" && methodName != "print" {
+		return Value{}, false, fmt.Errorf("invokevirtual: unsupported PrintStream method %s:%s", methodName, descriptor)
 	}
-	if methodName == "print" {
-		switch descriptor {
-		case "(I)V":
-			fmt.Fprintf(ps.Writer, "%d", args[0].Int)
-		case "(J)V":
-			fmt.Fprintf(ps.Writer, "%d", args[0].Long)
-		case "(D)V":
-			d := args[0].Double
-			if d == float64(int64(d)) && !math.IsInf(d, 0) {
-				fmt.Fprintf(ps.Writer, "%s", strconv.FormatFloat(d, 'f', 1, 64))
-			} else {
-				fmt.Fprintf(ps.Writer, "%s", strconv.FormatFloat(d, 'f', -1, 64))
-			}
-		case "(F)V":
-			fmt.Fprintf(ps.Writer, "%v", args[0].Float)
-		case "(C)V":
-			fmt.Fprintf(ps.Writer, "%c", rune(args[0].Int))
-		case "(Z)V":
-			if args[0].Int != 0 {
-				fmt.Fprintf(ps.Writer, "true")
-			} else {
-				fmt.Fprintf(ps.Writer, "false")
-			}
-		case "(Ljava/lang/String;)V":
-			if s, ok := extractGoString(args[0]); ok {
-				fmt.Fprintf(ps.Writer, "%s", s)
-			} else {
-				fmt.Fprintf(ps.Writer, "%v", args[0].Ref)
-			}
-		case "(Ljava/lang/Object;)V":
-			fmt.Fprintf(ps.Writer, "%s", vm.valueToString(args[0]))
-		default:
+	var s string
+	switch param := descriptor[1:strings.IndexByte(descriptor, ')')]; param {
+	case "":
+		if methodName == "print" {
 			return Value{}, false, fmt.Errorf("invokevirtual: unsupported print descriptor %s", descriptor)
 		}
-		return Value{}, false, nil
+	case "[C":
+		arr, ok := args[0].Ref.(*JArray)
+		if !ok {
+			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		}
+		s = charsString(arrayChars(arr, 0, arr.Len()))
+	case "I", "J", "F", "D", "Z", "C", "Ljava/lang/String;", "Ljava/lang/Object;":
+		s = vm.stringValueOf(args[0], param)
+	default:
+		return Value{}, false, fmt.Errorf("invokevirtual: unsupported %s descriptor %s", methodName, descriptor)
+	}
+	if methodName == "println" {
+		s += "\n"
+	}
+	charset := vm.stdoutCharset()
+	if ps.Err {
+		charset = vm.stderrCharset()
 	}
-	return Value{}, false, fmt.Errorf("invokevirtual: unsupported PrintStream method %s:%s", methodName, descriptor)
+	ps.Writer.Write(encodeString(s, charset))
+	return Value{}, false, nil
 }
 
 // executeInvokespecial handles the invokespecial instruction.
@@ -875,11 +1290,11 @@ func (vm *VM) executeInvokespecial(frame *Frame) (Value, bool, error) {
 		return Value{}, false, nil
 	}
 
-	// StringBuilder native handling
-	if methodRef.ClassName == "java/lang/StringBuilder" ||
+	// StringBuilder and StringBuffer native handling
+	if isStringBuilderClass(methodRef.ClassName) ||
 		(objectRef.Ref != nil && func() bool {
 			if o, ok := objectRef.Ref.(*JObject); ok {
-				return o.ClassName == "java/lang/StringBuilder"
+				return isStringBuilderClass(o.ClassName)
 			}
 			return false
 		}()) {
@@ -890,8 +1305,38 @@ func (vm *VM) executeInvokespecial(frame *Frame) (Value, bool, error) {
 		return Value{}, false, nil
 	}
 
+	// DecimalFormat constructors
+	if obj, ok := objectRef.Ref.(*JObject); ok && isNumberFormatClass(obj.ClassName) && methodRef.MethodName == "<init>" {
+		_, err := vm.handleDecimalFormat(objectRef, methodRef.MethodName, methodRef.Descriptor, args)
+		return Value{}, false, err
+	}
+
+	// In-memory stream constructors, and super calls into native streams
+	if streamClass := nativeStreamClassOf(objectRef, methodRef.ClassName); streamClass != "" &&
+		!vm.overridesStreamMethod(methodRef.ClassName, streamClass, methodRef.MethodName, methodRef.Descriptor) {
+		retVal, err := vm.handleNativeStream(streamClass, objectRef, methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
 	// Resolve method from class loader
 	cf, method, err := vm.resolveMethod(methodRef.ClassName, methodRef.MethodName, methodRef.Descriptor)
+	if obj, ok := objectRef.Ref.(*JObject); ok && vm.throwableNative(methodRef.ClassName, cf, err) {
+		if retVal, handled, err := vm.handleThrowableMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
 	if err != nil {
 		return Value{}, false, err
 	}
@@ -899,14 +1344,7 @@ func (vm *VM) executeInvokespecial(frame *Frame) (Value, bool, error) {
 	fullArgs := make([]Value, 0, len(args)+1)
 	fullArgs = append(fullArgs, objectRef)
 	fullArgs = append(fullArgs, args...)
-	retVal, err := vm.executeMethod(cf, method, fullArgs)
-	if err != nil {
-		return Value{}, false, err
-	}
-	if !isVoidReturn(methodRef.Descriptor) {
-		frame.Push(retVal)
-	}
-	return Value{}, false, nil
+	return vm.invoke(frame, cf, method, fullArgs, methodRef.Descriptor)
 }
 
 // executeInvokestatic handles the invokestatic instruction.
@@ -924,6 +1362,9 @@ func (vm *VM) executeInvokestatic(frame *Frame) (Value, bool, error) {
 	}
 
 	if err := vm.ensureInitialized(methodRef.ClassName); err != nil {
+		if _, ok := err.(*JavaException); ok {
+			return Value{}, false, err
+		}
 		return Value{}, false, fmt.Errorf("invokestatic: initializing %s: %w", methodRef.ClassName, err)
 	}
 
@@ -939,6 +1380,95 @@ func (vm *VM) executeInvokestatic(frame *Frame) (Value, bool, error) {
 		args[i] = frame.Pop()
 	}
 
+	// StackWalker instances are native
+	if methodRef.ClassName == stackWalkerClass {
+		if retVal, handled := vm.handleStackWalkerStatic(methodRef.MethodName); handled {
+			frame.Push(retVal)
+			return Value{}, false, nil
+		}
+	}
+
+	// The current thread is the VM's main thread
+	if methodRef.ClassName == "java/lang/Thread" && methodRef.MethodName == "currentThread" {
+		frame.Push(vm.currentThread())
+		return Value{}, false, nil
+	}
+
+	// The system class loader is the VM's application loader
+	if methodRef.ClassName == "java/lang/ClassLoader" && methodRef.MethodName == "getSystemClassLoader" {
+		frame.Push(vm.appClassLoader())
+		return Value{}, false, nil
+	}
+
+	// valueOf shares the cached boxes with the VM's own boxing
+	if methodRef.MethodName == "valueOf" {
+		if retVal, ok := vm.handleBoxValueOf(methodRef.ClassName, methodRef.Descriptor, args); ok {
+			frame.Push(retVal)
+			return Value{}, false, nil
+		}
+	}
+
+	// java.nio.file paths and Files, java.util.regex, Character's
+	// CharacterData lookups and the number classes' parsing and formatting
+	// are native
+	var nativeHandler func(string, string, []Value) (Value, bool, error)
+	switch methodRef.ClassName {
+	case characterClass:
+		nativeHandler = handleCharacterStatic
+	case integerClass:
+		nativeHandler = vm.handleIntegerStatic
+	case longClass:
+		nativeHandler = vm.handleLongStatic
+	case floatClass:
+		nativeHandler = vm.handleFloatStatic
+	case doubleClass:
+		nativeHandler = vm.handleDoubleStatic
+	case "java/nio/file/Path", "java/nio/file/Paths":
+		nativeHandler = vm.handlePathStatic
+	case "java/nio/file/Files":
+		nativeHandler = vm.handleFilesStatic
+	case patternClass, matcherClass:
+		nativeHandler = vm.handleRegexStatic
+	}
+	if nativeHandler != nil {
+		if retVal, handled, err := nativeHandler(methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			if !isVoidReturn(methodRef.Descriptor) {
+				frame.Push(retVal)
+			}
+			return Value{}, false, nil
+		}
+	}
+
+	// System properties come from the VM property store
+	if methodRef.ClassName == "java/lang/System" {
+		if retVal, handled, err := vm.handleSystemProperty(methodRef.MethodName, methodRef.Descriptor, args); handled {
+			if err != nil {
+				return Value{}, false, err
+			}
+			frame.Push(retVal)
+			return Value{}, false, nil
+		}
+	}
+	if methodRef.ClassName == "jdk/internal/misc/VM" && methodRef.MethodName == "getSavedProperty" {
+		retVal, err := vm.executeNativeMethod(methodRef.ClassName, methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		frame.Push(retVal)
+		return Value{}, false, nil
+	}
+
+	// NumberFormat factories return a native DecimalFormat
+	if methodRef.ClassName == "java/text/NumberFormat" {
+		if retVal, ok := newNumberFormat(methodRef.MethodName); ok {
+			frame.Push(retVal)
+			return Value{}, false, nil
+		}
+	}
+
 	// Handle AccessController.doPrivileged natively — just call action.run()
 	if methodRef.ClassName == "java/security/AccessController" && methodRef.MethodName == "doPrivileged" {
 		// args[0] is the PrivilegedAction; call its run() method
@@ -947,14 +1477,7 @@ func (vm *VM) executeInvokestatic(frame *Frame) (Value, bool, error) {
 			return Value{}, false, NewJavaException("java/lang/NullPointerException")
 		}
 		if obj, ok := action.Ref.(*JObject); ok && obj.LambdaTarget != nil {
-			lt := obj.LambdaTarget
-			cf2, method2, err2 := vm.resolveMethod(lt.TargetClass, lt.TargetMethod, lt.TargetDesc)
-			if err2 != nil {
-				return Value{}, false, err2
-			}
-			fullArgs := make([]Value, 0, len(lt.CapturedArgs))
-			fullArgs = append(fullArgs, lt.CapturedArgs...)
-			retVal, err2 := vm.executeMethod(cf2, method2, fullArgs)
+			retVal, err2 := vm.invokeLambda(obj.LambdaTarget, "()Ljava/lang/Object;", nil)
 			if err2 != nil {
 				return Value{}, false, err2
 			}
@@ -994,6 +1517,14 @@ func (vm *VM) executeInvokestatic(frame *Frame) (Value, bool, error) {
 		frame.Push(retVal)
 		return Value{}, false, nil
 	}
+	if methodRef.ClassName == "java/lang/String" && (methodRef.MethodName == "join" || methodRef.MethodName == "format") {
+		retVal, err := vm.handleStringStatic(methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		frame.Push(retVal)
+		return Value{}, false, nil
+	}
 
 	// Resolve method from class loader
 	cf, method, err := vm.resolveMethod(methodRef.ClassName, methodRef.MethodName, methodRef.Descriptor)
@@ -1001,18 +1532,7 @@ func (vm *VM) executeInvokestatic(frame *Frame) (Value, bool, error) {
 		return Value{}, false, err
 	}
 
-	// Execute the method
-	retVal, err := vm.executeMethod(cf, method, args)
-	if err != nil {
-		return Value{}, false, err
-	}
-
-	// Push return value if the method returns something
-	if !isVoidReturn(methodRef.Descriptor) {
-		frame.Push(retVal)
-	}
-
-	return Value{}, false, nil
+	return vm.invoke(frame, cf, method, args, methodRef.Descriptor)
 }
 
 // executeInvokeinterface handles the invokeinterface instruction.
@@ -1040,7 +1560,7 @@ func (vm *VM) executeInvokeinterface(frame *Frame) (Value, bool, error) {
 	objectRef := frame.Pop()
 
 	if objectRef.Type == TypeNull || objectRef.Ref == nil {
-		return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		return Value{}, false, nullOperand()
 	}
 
 	// Handle String methods natively via interface dispatch
@@ -1055,22 +1575,73 @@ func (vm *VM) executeInvokeinterface(frame *Frame) (Value, bool, error) {
 		return Value{}, false, nil
 	}
 
+	// In-memory streams reached through Closeable, Appendable, etc.
+	if streamClass := nativeStreamClassOf(objectRef, ""); streamClass != "" &&
+		!vm.overridesStreamMethod(objectRef.Ref.(*JObject).ClassName, streamClass, methodRef.MethodName, methodRef.Descriptor) {
+		retVal, err := vm.handleNativeStream(streamClass, objectRef, methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
 	obj, ok := objectRef.Ref.(*JObject)
 	if !ok {
 		return Value{}, false, fmt.Errorf("invokeinterface: receiver is not a JObject for %s.%s", methodRef.ClassName, methodRef.MethodName)
 	}
 
-	// Lambda proxy dispatch
-	if obj.LambdaTarget != nil && methodRef.MethodName == obj.LambdaTarget.MethodName {
-		lt := obj.LambdaTarget
-		cf, method, err := vm.resolveMethod(lt.TargetClass, lt.TargetMethod, lt.TargetDesc)
+	// StringBuilder and StringBuffer as CharSequence, Appendable and
+	// Comparable
+	if isStringBuilderClass(obj.ClassName) {
+		retVal, _, err := vm.handleStringBuilder(objectRef, methodRef.MethodName, methodRef.Descriptor, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// StackWalker.StackFrame
+	if retVal, handled, err := vm.handleStackMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// Path, DirectoryStream and its Iterator
+	if retVal, handled, err := vm.handlePathMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
+		if err != nil {
+			return Value{}, false, err
+		}
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// MatchResult on native matchers
+	if retVal, handled, err := vm.handleRegexMethod(obj, methodRef.MethodName, methodRef.Descriptor, args); handled {
 		if err != nil {
 			return Value{}, false, err
 		}
-		fullArgs := make([]Value, 0, len(lt.CapturedArgs)+len(args))
-		fullArgs = append(fullArgs, lt.CapturedArgs...)
-		fullArgs = append(fullArgs, args...)
-		retVal, err := vm.executeMethod(cf, method, fullArgs)
+		if !isVoidReturn(methodRef.Descriptor) {
+			frame.Push(retVal)
+		}
+		return Value{}, false, nil
+	}
+
+	// Lambda proxy dispatch
+	if obj.LambdaTarget != nil && methodRef.MethodName == obj.LambdaTarget.MethodName {
+		retVal, err := vm.invokeLambda(obj.LambdaTarget, methodRef.Descriptor, args)
 		if err != nil {
 			return Value{}, false, err
 		}
@@ -1088,14 +1659,7 @@ func (vm *VM) executeInvokeinterface(frame *Frame) (Value, bool, error) {
 	fullArgs := make([]Value, 0, len(args)+1)
 	fullArgs = append(fullArgs, objectRef)
 	fullArgs = append(fullArgs, args...)
-	retVal, err := vm.executeMethod(cf, method, fullArgs)
-	if err != nil {
-		return Value{}, false, err
-	}
-	if !isVoidReturn(methodRef.Descriptor) {
-		frame.Push(retVal)
-	}
-	return Value{}, false, nil
+	return vm.invoke(frame, cf, method, fullArgs, methodRef.Descriptor)
 }
 
 // executeNew handles the new instruction.
@@ -1109,12 +1673,15 @@ func (vm *VM) executeNew(frame *Frame) (Value, bool, error) {
 	}
 
 	if err := vm.ensureInitialized(className); err != nil {
+		if _, ok := err.(*JavaException); ok {
+			return Value{}, false, err
+		}
 		return Value{}, false, fmt.Errorf("new: initializing %s: %w", className, err)
 	}
 
 	obj := &JObject{ClassName: className, Fields: make(map[string]Value)}
-	if className == "java/lang/StringBuilder" {
-		obj.Fields["_buffer"] = RefValue("")
+	if isStringBuilderClass(className) {
+		obj.Fields["_buffer"] = RefValue([]byte{})
 	}
 	frame.Push(RefValue(obj))
 	return Value{}, false, nil
@@ -1165,6 +1732,32 @@ func countParams(descriptor string) (int, error) {
 	return count, nil
 }
 
+// paramDescriptors splits the parameters of a method descriptor into
+// field descriptors: "(I[JLjava/lang/String;)V" gives "I", "[J" and
+// "Ljava/lang/String;".
+func paramDescriptors(descriptor string) []string {
+	start, end := strings.IndexByte(descriptor, '('), strings.IndexByte(descriptor, ')')
+	if start < 0 || end < start {
+		return nil
+	}
+	params := descriptor[start+1 : end]
+	var out []string
+	for len(params) > 0 {
+		i := 0
+		for i < len(params)-1 && params[i] == '[' {
+			i++
+		}
+		if params[i] == 'L' {
+			if semi := strings.IndexByte(params[i:], ';'); semi >= 0 {
+				i += semi
+			}
+		}
+		out = append(out, params[:i+1])
+		params = params[i+1:]
+	}
+	return out
+}
+
 // nativeArraycopy implements System.arraycopy.
 func (vm *VM) nativeArraycopy(args []Value) (Value, error) {
 	srcRef := args[0]
@@ -1177,35 +1770,198 @@ func (vm *VM) nativeArraycopy(args []Value) (Value, error) {
 		return Value{}, NewJavaException("java/lang/NullPointerException")
 	}
 
-	srcArr, ok1 := srcRef.Ref.(*JArray)
-	destArr, ok2 := destRef.Ref.(*JArray)
-	if !ok1 || !ok2 {
-		return Value{}, NewJavaException("java/lang/ArrayStoreException")
+	srcArr, ok := srcRef.Ref.(*JArray)
+	if !ok {
+		return Value{}, arraycopyFailure("ArrayStoreException", "source type %s is not an array", javaValueClass(srcRef))
 	}
-
-	if srcPos < 0 || destPos < 0 || length < 0 ||
-		srcPos+length > len(srcArr.Elements) ||
-		destPos+length > len(destArr.Elements) {
-		return Value{}, NewJavaException("java/lang/ArrayIndexOutOfBoundsException")
+	destArr, ok := destRef.Ref.(*JArray)
+	if !ok {
+		return Value{}, arraycopyFailure("ArrayStoreException", "destination type %s is not an array", javaValueClass(destRef))
+	}
+	// Arrays of different primitive types, or a primitive and a reference
+	// array, are incompatible whatever the range. Arrays whose component
+	// type was not recorded are let through.
+	srcPrim := len(srcArr.Component) == 1
+	destPrim := len(destArr.Component) == 1
+	if srcArr.Component != "" && destArr.Component != "" && (srcPrim || destPrim) && srcArr.Component != destArr.Component {
+		return Value{}, arraycopyFailure("ArrayStoreException", "type mismatch: can not copy %s[] into %s[]",
+			arraycopyKind(srcArr), arraycopyKind(destArr))
+	}
+
+	switch {
+	case srcPos < 0:
+		return Value{}, arraycopyFailure("ArrayIndexOutOfBoundsException", "source index %d out of bounds for %s[%d]",
+			srcPos, arraycopyKind(srcArr), srcArr.Len())
+	case destPos < 0:
+		return Value{}, arraycopyFailure("ArrayIndexOutOfBoundsException", "destination index %d out of bounds for %s[%d]",
+			destPos, arraycopyKind(destArr), destArr.Len())
+	case length < 0:
+		return Value{}, arraycopyFailure("ArrayIndexOutOfBoundsException", "length %d is negative", length)
+	case srcPos+length > srcArr.Len():
+		return Value{}, arraycopyFailure("ArrayIndexOutOfBoundsException", "last source index %d out of bounds for %s[%d]",
+			srcPos+length, arraycopyKind(srcArr), srcArr.Len())
+	case destPos+length > destArr.Len():
+		return Value{}, arraycopyFailure("ArrayIndexOutOfBoundsException", "last destination index %d out of bounds for %s[%d]",
+			destPos+length, arraycopyKind(destArr), destArr.Len())
+	}
+
+	if srcPrim || destPrim || vm.isAssignableDescriptor(srcArr.Component, destArr.Component) {
+		copyElements(destArr, destPos, srcArr, srcPos, length)
+		return Value{}, nil
 	}
-
+	// Elements of a reference array are checked one by one. Those before
+	// the first one that cannot be stored are copied, as in the JVM; src
+	// and dest are different arrays here, so copying forward is safe.
 	for i := 0; i < length; i++ {
-		destArr.Elements[destPos+i] = srcArr.Elements[srcPos+i]
+		v := srcArr.Elements[srcPos+i]
+		if !vm.canStore(v, destArr.Component) {
+			return Value{}, arraycopyFailure("ArrayStoreException",
+				"element type mismatch: can not cast one of the elements of %s[] to the type of the destination array, %s",
+				componentName(srcArr), componentName(destArr))
+		}
+		destArr.Elements[destPos+i] = v
 	}
 	return Value{}, nil
 }
 
-// isVoidReturn checks if a method descriptor has void return type.
+// arraycopyFailure returns the java.lang exception className that
+// System.arraycopy throws, with HotSpot's message.
+func arraycopyFailure(className, format string, args ...interface{}) *JavaException {
+	return NewJavaExceptionMessage("java/lang/"+className, "arraycopy: "+fmt.Sprintf(format, args...))
+}
+
+// arraycopyKind names the type of arr's elements as System.arraycopy
+// messages do: the primitive type, or "object array".
+func arraycopyKind(arr *JArray) string {
+	if len(arr.Component) == 1 {
+		return descriptorClassName(arr.Component)
+	}
+	return "object array"
+}
+
+// componentName returns the binary name of the component type of the
+// reference array arr, such as "java.lang.String".
+func componentName(arr *JArray) string {
+	if arr.Component == "" {
+		return "java.lang.Object"
+	}
+	return strings.ReplaceAll(descriptorClassName(arr.Component), "/", ".")
+}
+
+// javaValueClass returns the binary name of the class of the non-null
+// reference v, such as "java.lang.String", or "java.lang.Object" if the VM
+// keeps v as a Go value of no particular class.
+func javaValueClass(v Value) string {
+	if name := runtimeClassName(v); name != "" {
+		return strings.ReplaceAll(name, "/", ".")
+	}
+	return "java.lang.Object"
+}
+
+// canStore reports whether v may be stored in an array with the given
+// reference component type, which is unknown for arrays created without
+// one.
+func (vm *VM) canStore(v Value, component string) bool {
+	return v.Type == TypeNull || component == "" || vm.isInstanceOfDescriptor(v, component)
+}
+
+// isInstanceOfDescriptor reports whether the non-null reference v is an
+// instance of the type described by to, as checkcast and instanceof
+// decide. Strings are Go strings and lambda proxies are objects of their
+// functional interface. Arrays with an unrecorded component type are
+// taken to be instances of every array type, and other objects the VM
+// keeps as Go values of every class, since their Java type is unknown.
+func (vm *VM) isInstanceOfDescriptor(v Value, to string) bool {
+	switch ref := v.Ref.(type) {
+	case string:
+		return vm.isAssignableDescriptor("Ljava/lang/String;", to)
+	case *JObject:
+		return vm.isAssignableDescriptor(classDescriptor(ref.ClassName), to)
+	case *JArray:
+		if ref.Component == "" {
+			return vm.isAssignableDescriptor("[Ljava/lang/Object;", to) || to[0] == '['
+		}
+		return vm.isAssignableDescriptor("["+ref.Component, to)
+	}
+	return true
+}
+
+// isAssignableDescriptor reports whether a value of the type described by
+// from may be used where one described by to is expected.
+func (vm *VM) isAssignableDescriptor(from, to string) bool {
+	switch {
+	case from == to:
+		return true
+	case len(from) < 2 || len(to) < 2: // a primitive type
+		return false
+	case to == "Ljava/lang/Object;":
+		return true
+	case from[0] == '[' && to[0] == '[':
+		return vm.isAssignableDescriptor(from[1:], to[1:])
+	case from[0] == '[':
+		return to == "Ljava/lang/Cloneable;" || to == "Ljava/io/Serializable;"
+	case from == "Ljava/lang/String;":
+		switch to {
+		case "Ljava/lang/Comparable;", "Ljava/io/Serializable;", "Ljava/lang/CharSequence;",
+			"Ljava/lang/constant/Constable;", "Ljava/lang/constant/ConstantDesc;":
+			return true
+		}
+	}
+	return from[0] == 'L' && to[0] == 'L' && vm.isInstanceOf(descriptorClassName(from), descriptorClassName(to))
+}
+
+// isVoidReturn checks if a method descriptor has void return type.
 func isVoidReturn(descriptor string) bool {
 	return strings.HasSuffix(descriptor, ")V")
 }
 
 // formatDouble formats a double value matching Java's Double.toString behavior.
 func formatDouble(d float64) string {
-	if d == float64(int64(d)) && !math.IsInf(d, 0) {
-		return strconv.FormatFloat(d, 'f', 1, 64)
-	}
-	return strconv.FormatFloat(d, 'f', -1, 64)
+	return formatFloating(d, 64)
+}
+
+// formatFloat formats a float matching Float.toString, which picks digits
+// identifying the float rather than its widened double.
+func formatFloat(f float32) string {
+	return formatFloating(float64(f), 32)
+}
+
+// formatFloating formats v, a float64 or a widened float32 per bitSize, as
+// Double.toString and Float.toString do in JDK 17, with the digits of
+// floatingDigits. Magnitudes in [10^-3, 10^7) are shown in decimal notation
+// and the rest in computerized scientific notation ("1.0E7").
+func formatFloating(v float64, bitSize int) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	case v == 0 && math.Signbit(v):
+		return "-0.0"
+	case v == 0:
+		return "0.0"
+	}
+	sign := ""
+	if v < 0 {
+		sign, v = "-", -v
+	}
+	b, decExp := floatingDigits(v, bitSize, true)
+	digits, e := string(b), decExp-1
+	switch {
+	case e < -3 || e >= 7:
+		frac := digits[1:]
+		if frac == "" {
+			frac = "0"
+		}
+		return sign + digits[:1] + "." + frac + "E" + strconv.Itoa(e)
+	case e < 0:
+		return sign + "0." + strings.Repeat("0", -e-1) + digits
+	case len(digits) <= e+1:
+		return sign + digits + strings.Repeat("0", e+1-len(digits)) + ".0"
+	}
+	return sign + digits[:e+1] + "." + digits[e+1:]
 }
 
 // executeInvokedynamic handles the invokedynamic instruction.
@@ -1269,40 +2025,41 @@ func (vm *VM) executeInvokedynamic(frame *Frame) (Value, bool, error) {
 	bsmKey := bsmClassName + "." + bsmMethodName
 
 	switch bsmKey {
-	case "java/lang/invoke/LambdaMetafactory.metafactory":
-		return vm.handleLambdaMetafactory(frame, pool, bsm, methodName, descriptor)
-	case "java/lang/invoke/StringConcatFactory.makeConcatWithConstants":
-		return vm.handleStringConcatFactory(frame, pool, bsm, methodName, descriptor)
+	case "java/lang/invoke/LambdaMetafactory.metafactory", "java/lang/invoke/StringConcatFactory.makeConcatWithConstants":
+		args, err := vm.resolveBootstrapArgs(frame.Class, bsm)
+		if err != nil {
+			if _, ok := err.(*JavaException); ok {
+				return Value{}, false, err
+			}
+			return Value{}, false, fmt.Errorf("invokedynamic: %w", err)
+		}
+		if bsmMethodName == "metafactory" {
+			return vm.handleLambdaMetafactory(frame, args, methodName, descriptor)
+		}
+		return vm.handleStringConcatFactory(frame, args, methodName, descriptor)
+	case "java/lang/runtime/ObjectMethods.bootstrap":
+		return vm.handleObjectMethods(frame, pool, bsm, methodName, descriptor)
 	default:
 		return Value{}, false, fmt.Errorf("invokedynamic: unsupported bootstrap method %s", bsmKey)
 	}
 }
 
-// handleLambdaMetafactory handles LambdaMetafactory.metafactory bootstrap calls.
-func (vm *VM) handleLambdaMetafactory(frame *Frame, pool []classfile.ConstantPoolEntry, bsm classfile.BootstrapMethod, methodName, descriptor string) (Value, bool, error) {
-	if len(bsm.BootstrapArguments) < 3 {
-		return Value{}, false, fmt.Errorf("LambdaMetafactory: expected 3+ bootstrap args, got %d", len(bsm.BootstrapArguments))
+// handleLambdaMetafactory handles LambdaMetafactory.metafactory bootstrap
+// calls. args are the resolved static arguments: the erased interface
+// method type, the implementation handle and the instantiated method type.
+func (vm *VM) handleLambdaMetafactory(frame *Frame, args []Value, methodName, descriptor string) (Value, bool, error) {
+	if len(args) < 3 {
+		return Value{}, false, fmt.Errorf("LambdaMetafactory: expected 3+ bootstrap args, got %d", len(args))
 	}
 
-	// Get implementation method handle (arg[1])
-	implHandle, ok := pool[bsm.BootstrapArguments[1]].(*classfile.ConstantMethodHandle)
+	refKind, targetClass, targetMethod, targetDesc, ok := methodHandleTarget(args[1])
 	if !ok {
 		return Value{}, false, fmt.Errorf("LambdaMetafactory: arg[1] is not MethodHandle")
 	}
-
-	// Resolve implementation method
-	var targetClass, targetMethod, targetDesc string
-	switch implHandle.ReferenceKind {
-	case 5, 6, 7: // invokevirtual, invokestatic, invokespecial
-		mref, ok := pool[implHandle.ReferenceIndex].(*classfile.ConstantMethodref)
-		if ok {
-			targetClass, _ = classfile.GetClassName(pool, mref.ClassIndex)
-			implNat := pool[mref.NameAndTypeIndex].(*classfile.ConstantNameAndType)
-			targetMethod, _ = classfile.GetUtf8(pool, implNat.NameIndex)
-			targetDesc, _ = classfile.GetUtf8(pool, implNat.DescriptorIndex)
-		}
+	switch refKind {
+	case refInvokeVirtual, refInvokeStatic, refInvokeSpecial, refNewInvokeSpecial, refInvokeInterface:
 	default:
-		return Value{}, false, fmt.Errorf("LambdaMetafactory: unsupported impl reference kind %d", implHandle.ReferenceKind)
+		return Value{}, false, fmt.Errorf("LambdaMetafactory: unsupported impl reference kind %d", refKind)
 	}
 
 	// Get interface name from return type of factory descriptor
@@ -1331,7 +2088,7 @@ func (vm *VM) handleLambdaMetafactory(frame *Frame, pool []classfile.ConstantPoo
 			TargetMethod:  targetMethod,
 			TargetDesc:    targetDesc,
 			CapturedArgs:  capturedArgs,
-			ReferenceKind: implHandle.ReferenceKind,
+			ReferenceKind: refKind,
 		},
 	}
 
@@ -1339,38 +2096,25 @@ func (vm *VM) handleLambdaMetafactory(frame *Frame, pool []classfile.ConstantPoo
 	return Value{}, false, nil
 }
 
-// handleStringConcatFactory handles StringConcatFactory.makeConcatWithConstants bootstrap calls.
-func (vm *VM) handleStringConcatFactory(frame *Frame, pool []classfile.ConstantPoolEntry, bsm classfile.BootstrapMethod, methodName, descriptor string) (Value, bool, error) {
-	// Get recipe from bootstrap args[0] (ConstantString)
+// handleStringConcatFactory handles StringConcatFactory.makeConcatWithConstants
+// bootstrap calls. args are the resolved static arguments: the recipe,
+// followed by the constants it refers to.
+func (vm *VM) handleStringConcatFactory(frame *Frame, args []Value, methodName, descriptor string) (Value, bool, error) {
 	recipe := ""
-	if len(bsm.BootstrapArguments) > 0 {
-		argEntry := pool[bsm.BootstrapArguments[0]]
-		switch c := argEntry.(type) {
-		case *classfile.ConstantString:
-			recipe, _ = classfile.GetUtf8(pool, c.StringIndex)
-		}
+	if len(args) > 0 {
+		recipe, _ = extractGoString(args[0])
 	}
 
-	// Count parameters from descriptor
-	paramCount, _ := countParams(descriptor)
-	args := make([]Value, paramCount)
-	for i := paramCount - 1; i >= 0; i-- {
-		args[i] = frame.Pop()
+	// Constants from bootstrap args [1:], converted as String.valueOf does
+	constants := make([]string, 0, len(args))
+	for _, c := range args[min(1, len(args)):] {
+		constants = append(constants, vm.valueToString(c))
 	}
 
-	// Constants from bootstrap args [1:]
-	constants := make([]string, 0)
-	for i := 1; i < len(bsm.BootstrapArguments); i++ {
-		argEntry := pool[bsm.BootstrapArguments[i]]
-		switch c := argEntry.(type) {
-		case *classfile.ConstantString:
-			s, _ := classfile.GetUtf8(pool, c.StringIndex)
-			constants = append(constants, s)
-		case *classfile.ConstantInteger:
-			constants = append(constants, fmt.Sprintf("%d", c.Value))
-		default:
-			constants = append(constants, "")
-		}
+	params := paramDescriptors(descriptor)
+	operands := make([]Value, len(params))
+	for i := len(params) - 1; i >= 0; i-- {
+		operands[i] = frame.Pop()
 	}
 
 	// Build result string from recipe
@@ -1381,8 +2125,8 @@ func (vm *VM) handleStringConcatFactory(frame *Frame, pool []classfile.ConstantP
 	for i := 0; i < len(recipe); i++ {
 		ch := recipe[i]
 		if ch == '\x01' {
-			if argIdx < len(args) {
-				result.WriteString(vm.valueToString(args[argIdx]))
+			if argIdx < len(operands) {
+				result.WriteString(vm.stringValueOf(operands[argIdx], params[argIdx]))
 				argIdx++
 			}
 		} else if ch == '\x02' {
@@ -1417,21 +2161,16 @@ func extractGoString(v Value) (string, bool) {
 		}
 		coder := obj.Fields["coder"].Int // 0=LATIN1, 1=UTF16
 		if coder == 0 {
-			bytes := make([]byte, len(arr.Elements))
-			for i, e := range arr.Elements {
-				bytes[i] = byte(e.Int)
-			}
-			return string(bytes), true
+			return string(byteArrayToGo(arr, 0, arr.Len())), true
 		}
 		// UTF16: two bytes per character
-		n := len(arr.Elements) / 2
-		runes := make([]rune, n)
-		for i := 0; i < n; i++ {
-			lo := arr.Elements[i*2].Int & 0xFF
-			hi := arr.Elements[i*2+1].Int & 0xFF
-			runes[i] = rune(lo | (hi << 8))
+		chars := make([]uint16, arr.Len()/2)
+		for i := range chars {
+			lo := arr.Get(i*2).Int & 0xFF
+			hi := arr.Get(i*2+1).Int & 0xFF
+			chars[i] = uint16(lo | (hi << 8))
 		}
-		return string(runes), true
+		return charsString(chars), true
 	}
 	return "", false
 }
@@ -1443,12 +2182,15 @@ func (vm *VM) valueToString(v Value) string {
 	case TypeLong:
 		return fmt.Sprintf("%d", v.Long)
 	case TypeFloat:
-		return fmt.Sprintf("%v", v.Float)
+		return formatFloat(v.Float)
 	case TypeDouble:
-		return fmt.Sprintf("%v", v.Double)
+		return formatDouble(v.Double)
 	case TypeNull:
 		return "null"
 	case TypeRef:
+		if v.Ref == nil {
+			return "null"
+		}
 		if s, ok := extractGoString(v); ok {
 			return s
 		}
@@ -1460,7 +2202,7 @@ func (vm *VM) valueToString(v Value) string {
 				case "java/lang/Long":
 					return fmt.Sprintf("%d", val.Long)
 				case "java/lang/Float":
-					return fmt.Sprintf("%v", val.Float)
+					return formatFloat(val.Float)
 				case "java/lang/Double":
 					return formatDouble(val.Double)
 				case "java/lang/Boolean":
@@ -1471,15 +2213,49 @@ func (vm *VM) valueToString(v Value) string {
 				case "java/lang/Character":
 					return string(rune(val.Int))
 				}
+			}
+			if buf, ok := obj.Fields["_buffer"].Ref.([]byte); ok && isStringBuilderClass(obj.ClassName) {
+				return string(buf)
+			}
+			if ret, handled, err := vm.handleStackMethod(obj, "toString", "()Ljava/lang/String;", nil); handled && err == nil {
+				if s, ok := extractGoString(ret); ok {
+					return s
+				}
+			}
+			if ret, handled, err := vm.handlePathMethod(obj, "toString", "()Ljava/lang/String;", nil); handled && err == nil {
+				if s, ok := extractGoString(ret); ok {
+					return s
+				}
+			}
+			if ret, handled, err := vm.handleRegexMethod(obj, "toString", "()Ljava/lang/String;", nil); handled && err == nil {
+				if s, ok := extractGoString(ret); ok {
+					return s
+				}
+			}
+			if streamClass := nativeStreamClassOf(v, ""); streamClass != "" &&
+				!vm.overridesStreamMethod(obj.ClassName, streamClass, "toString", "()Ljava/lang/String;") {
+				if ret, err := vm.handleNativeStream(streamClass, v, "toString", "()Ljava/lang/String;", nil); err == nil {
+					if s, ok := extractGoString(ret); ok {
+						return s
+					}
+				}
 			}
 				// Try calling toString() via virtual dispatch
 			cf, m, err := vm.resolveMethod(obj.ClassName, "toString", "()Ljava/lang/String;")
+			if vm.throwableNative(obj.ClassName, cf, err) {
+				if s, err := vm.throwableString(obj); err == nil {
+					return s
+				}
+			}
 			if err == nil {
 				ret, err := vm.executeMethod(cf, m, []Value{v})
 				if err == nil {
 					if s, ok := extractGoString(ret); ok {
 						return s
 					}
+					if ret.Type == TypeNull || ret.Ref == nil {
+						return "null" // toString() returned null
+					}
 				}
 			}
 			return obj.ClassName
@@ -1489,104 +2265,417 @@ func (vm *VM) valueToString(v Value) string {
 	return ""
 }
 
-// handleStringBuilder handles StringBuilder method calls natively.
+// stringValueOf converts a value of the type with the given descriptor to
+// a string as String.valueOf does. Booleans and chars, which are ints on
+// the stack, are recognised by their descriptor; everything else,
+// including null, goes through valueToString.
+func (vm *VM) stringValueOf(v Value, descriptor string) string {
+	switch descriptor {
+	case "Z":
+		if v.Int != 0 {
+			return "true"
+		}
+		return "false"
+	case "C":
+		return charsString([]uint16{uint16(v.Int)})
+	case "D":
+		return formatDouble(v.Double)
+	case "F":
+		return formatFloat(v.Float)
+	}
+	return vm.valueToString(v)
+}
+
+// handleStringBuilder handles StringBuilder and StringBuffer method calls
+// natively. The contents are kept as UTF-8 in a []byte in the hidden
+// _buffer field, which append grows in place with amortized doubling
+// rather than rebuilding a string on every call. Methods taking char
+// indices convert them to byte offsets, which is free while the contents
+// are ASCII. The hidden _state field caches the char length and follows
+// capacity() as AbstractStringBuilder would grow it.
 func (vm *VM) handleStringBuilder(objectRef Value, methodName, descriptor string, args []Value) (Value, bool, error) {
 	obj := objectRef.Ref.(*JObject)
-	buf, _ := obj.Fields["_buffer"].Ref.(string)
+	buf, _ := obj.Fields["_buffer"].Ref.([]byte)
+	st := stateOf(obj)
 
 	switch methodName {
 	case "<init>":
 		switch descriptor {
 		case "()V":
-			// already initialized
-		case "(Ljava/lang/String;)V":
-			if s, ok := args[0].Ref.(string); ok {
-				obj.Fields["_buffer"] = RefValue(s)
-			}
+			st.capacity = 16
+			st.store(obj, make([]byte, 0, 16), 0)
 		case "(I)V":
-			// capacity hint, ignore
+			if args[0].Int < 0 {
+				return Value{}, false, negativeArraySize(int(args[0].Int))
+			}
+			st.capacity = int(args[0].Int)
+			st.store(obj, make([]byte, 0, args[0].Int), 0)
+		case "(Ljava/lang/String;)V", "(Ljava/lang/CharSequence;)V":
+			if args[0].Type == TypeNull || args[0].Ref == nil {
+				return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			}
+			s := vm.valueToString(args[0])
+			n := charLength(s)
+			st.capacity = n + 16
+			st.store(obj, append(make([]byte, 0, len(s)+16), s...), n)
 		}
 		return Value{}, false, nil
 
 	case "append":
-		var appendStr string
-		switch descriptor {
-		case "(Ljava/lang/String;)Ljava/lang/StringBuilder;":
-			if args[0].Type == TypeNull {
-				appendStr = "null"
-			} else if s, ok := args[0].Ref.(string); ok {
-				appendStr = s
-			} else {
-				appendStr = fmt.Sprintf("%v", args[0].Ref)
-			}
-		case "(I)Ljava/lang/StringBuilder;":
-			appendStr = fmt.Sprintf("%d", args[0].Int)
-		case "(J)Ljava/lang/StringBuilder;":
-			appendStr = fmt.Sprintf("%d", args[0].Long)
-		case "(D)Ljava/lang/StringBuilder;":
-			appendStr = formatDouble(args[0].Double)
-		case "(F)Ljava/lang/StringBuilder;":
-			appendStr = fmt.Sprintf("%v", args[0].Float)
-		case "(C)Ljava/lang/StringBuilder;":
-			appendStr = string(rune(args[0].Int))
-		case "(Z)Ljava/lang/StringBuilder;":
-			if args[0].Int != 0 {
-				appendStr = "true"
-			} else {
-				appendStr = "false"
-			}
-		case "(Ljava/lang/Object;)Ljava/lang/StringBuilder;":
-			appendStr = vm.valueToString(args[0])
-		}
-		obj.Fields["_buffer"] = RefValue(buf + appendStr)
+		param := descriptor[1:strings.IndexByte(descriptor, ')')]
+		appendStr, err := vm.builderArg(param, args)
+		if err != nil {
+			return Value{}, false, err
+		}
+		st.store(obj, append(buf, appendStr...), st.length(buf)+charLength(appendStr))
 		return objectRef, false, nil
 
+	case "appendCodePoint":
+		cp := args[0].Int
+		if cp < 0 || cp > unicode.MaxRune {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/IllegalArgumentException",
+				fmt.Sprintf("Not a valid Unicode code point: 0x%X", uint32(cp)))
+		}
+		n := 1
+		if cp >= 0x10000 {
+			n = 2
+		}
+		st.store(obj, utf8.AppendRune(buf, rune(cp)), st.length(buf)+n)
+		return objectRef, false, nil
+
+	case "insert":
+		n := st.length(buf)
+		offset := int(args[0].Int)
+		if offset < 0 || offset > n {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("offset %d, length %d", offset, n))
+		}
+		param := descriptor[2:strings.IndexByte(descriptor, ')')]
+		s, err := vm.builderArg(param, args[1:])
+		if err != nil {
+			return Value{}, false, err
+		}
+		st.splice(obj, buf, offset, offset, s, n-charLength(s))
+		return objectRef, false, nil
+
+	case "delete", "replace":
+		n := st.length(buf)
+		start, end := int(args[0].Int), min(int(args[1].Int), n)
+		if start < 0 || start > end {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("start %d, end %d, length %d", start, end, n))
+		}
+		var s string
+		if methodName == "replace" {
+			var ok bool
+			if s, ok = extractGoString(args[2]); !ok {
+				return Value{}, false, NewJavaException("java/lang/NullPointerException")
+			}
+		}
+		st.splice(obj, buf, start, end, s, n-(end-start)-charLength(s))
+		return objectRef, false, nil
+
+	case "deleteCharAt", "charAt", "setCharAt":
+		n := st.length(buf)
+		index := int(args[0].Int)
+		if index < 0 || index >= n {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("index %d,length %d", index, n))
+		}
+		switch methodName {
+		case "charAt":
+			if n == len(buf) { // ASCII, where chars and bytes coincide
+				return IntValue(int32(buf[index])), false, nil
+			}
+			return IntValue(int32(st.charsOf(buf)[index])), false, nil
+		case "setCharAt":
+			st.splice(obj, buf, index, index+1, charsString([]uint16{uint16(args[1].Int)}), n-1)
+			return Value{}, false, nil
+		}
+		st.splice(obj, buf, index, index+1, "", n-1)
+		return objectRef, false, nil
+
+	case "setLength":
+		newLength := int(args[0].Int)
+		if newLength < 0 {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("String index out of range: %d", newLength))
+		}
+		if n := st.length(buf); newLength > n {
+			st.store(obj, append(buf, make([]byte, newLength-n)...), newLength)
+		} else {
+			st.store(obj, buf[:charOffset(buf, newLength)], -1)
+		}
+		return Value{}, false, nil
+
+	case "reverse":
+		runes := []rune(string(buf))
+		slices.Reverse(runes)
+		st.store(obj, append(buf[:0], string(runes)...), st.length(buf))
+		return objectRef, false, nil
+
+	case "indexOf", "lastIndexOf":
+		target, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		}
+		s, n := string(buf), st.length(buf)
+		if methodName == "indexOf" {
+			from := 0
+			if len(args) > 1 {
+				from = min(max(int(args[1].Int), 0), n)
+			}
+			start := charOffset(buf, from)
+			if i := strings.Index(s[start:], target); i >= 0 {
+				return IntValue(int32(charIndex(s, start+i))), false, nil
+			}
+			return IntValue(-1), false, nil
+		}
+		from := n
+		if len(args) > 1 {
+			from = min(int(args[1].Int), n)
+		}
+		if from < 0 {
+			return IntValue(-1), false, nil
+		}
+		end := min(charOffset(buf, from)+len(target), len(s))
+		return IntValue(int32(charIndex(s, strings.LastIndex(s[:end], target)))), false, nil
+
+	case "substring", "subSequence":
+		n := st.length(buf)
+		start, end := int(args[0].Int), n
+		if len(args) > 1 {
+			end = int(args[1].Int)
+		}
+		if start < 0 || end > n || start > end {
+			return Value{}, false, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("start %d, end %d, length %d", start, end, n))
+		}
+		return RefValue(string(buf[charOffset(buf, start):charOffset(buf, end)])), false, nil
+
+	case "capacity":
+		return IntValue(int32(st.capacityOf(buf))), false, nil
+
+	case "ensureCapacity":
+		if st.capacity >= 0 && int(args[0].Int) > st.capacity {
+			st.capacity = max(st.capacity*2+2, int(args[0].Int))
+		}
+		return Value{}, false, nil
+
+	case "trimToSize":
+		st.capacity = st.length(buf)
+		return Value{}, false, nil
+
+	case "isEmpty":
+		return boolValue(len(buf) == 0), false, nil
+
+	case "compareTo":
+		other, ok := args[0].Ref.(*JObject)
+		if !ok || args[0].Type == TypeNull {
+			return Value{}, false, NewJavaException("java/lang/NullPointerException")
+		}
+		otherBuf, _ := other.Fields["_buffer"].Ref.([]byte)
+		return IntValue(int32(compareChars(string(buf), string(otherBuf)))), false, nil
+
 	case "toString":
-		return RefValue(buf), false, nil
+		return RefValue(string(buf)), false, nil
 
 	case "length":
-		return IntValue(int32(len(buf))), false, nil
+		return IntValue(int32(st.length(buf))), false, nil
 	}
 
 	return Value{}, false, fmt.Errorf("StringBuilder: unsupported method %s:%s", methodName, descriptor)
 }
 
+// isStringBuilderClass reports whether className is one of the native
+// string builders. StringBuffer only adds synchronization, which the VM
+// does not need, so it shares StringBuilder's implementation.
+func isStringBuilderClass(className string) bool {
+	return className == "java/lang/StringBuilder" || className == "java/lang/StringBuffer"
+}
+
+// builderArg returns the string that append or insert adds for the
+// arguments described by param.
+func (vm *VM) builderArg(param string, args []Value) (string, error) {
+	switch param {
+	case "I", "J", "F", "D", "Z", "C", "Ljava/lang/String;", "Ljava/lang/CharSequence;", "Ljava/lang/Object;",
+		"Ljava/lang/StringBuffer;":
+		return vm.stringValueOf(args[0], param), nil
+	case "[C", "[CII":
+		arr, ok := args[0].Ref.(*JArray)
+		if !ok {
+			return "", NewJavaException("java/lang/NullPointerException")
+		}
+		offset, length := 0, arr.Len()
+		if param == "[CII" {
+			offset, length = int(args[1].Int), int(args[2].Int)
+			if offset < 0 || length < 0 || offset > arr.Len()-length {
+				return "", NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException",
+					fmt.Sprintf("offset %d, count %d, length %d", offset, length, arr.Len()))
+			}
+		}
+		return charsString(arrayChars(arr, offset, length)), nil
+	case "Ljava/lang/CharSequence;II":
+		s := vm.stringValueOf(args[0], param)
+		start, end, n := int(args[1].Int), int(args[2].Int), charLength(s)
+		if start < 0 || start > end || end > n {
+			return "", NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException",
+				fmt.Sprintf("start %d, end %d, length %d", start, end, n))
+		}
+		if isASCII(s) {
+			return s[start:end], nil
+		}
+		return charsString(charsOf(s)[start:end]), nil
+	}
+	return "", fmt.Errorf("StringBuilder: unsupported argument %s", param)
+}
+
+// builderState is the bookkeeping of a builder beside its contents: the
+// char length and UTF-16 chars, valid while the contents have the recorded
+// byte length, and capacity(), or -1 for builders created without a
+// constructor, which count as full.
+type builderState struct {
+	bytes, chars int
+	view         []uint16 // nil until charsOf needs it
+	capacity     int
+}
+
+// stateOf returns the state of a builder, creating it on first use.
+func stateOf(obj *JObject) *builderState {
+	st, ok := obj.Fields["_state"].Ref.(*builderState)
+	if !ok {
+		st = &builderState{bytes: -1, capacity: -1}
+		obj.Fields["_state"] = RefValue(st)
+	}
+	return st
+}
+
+// length returns the char length of the contents buf.
+func (st *builderState) length(buf []byte) int {
+	if st.bytes != len(buf) {
+		st.bytes, st.chars, st.view = len(buf), bytesCharLength(buf), nil
+	}
+	return st.chars
+}
+
+// charsOf returns the UTF-16 chars of the contents buf, which callers must
+// not modify.
+func (st *builderState) charsOf(buf []byte) []uint16 {
+	if st.length(buf); st.view == nil {
+		st.view = stringChars(string(buf))
+	}
+	return st.view
+}
+
+// store sets the builder's contents to buf of n chars, or of unknown
+// length if n is negative, growing the capacity to fit.
+func (st *builderState) store(obj *JObject, buf []byte, n int) {
+	obj.Fields["_buffer"] = RefValue(buf)
+	st.view = nil
+	if n < 0 {
+		st.bytes = -1
+		return
+	}
+	st.bytes, st.chars = len(buf), n
+	if st.capacity >= 0 && n > st.capacity {
+		st.capacity = max(st.capacity*2+2, n) // AbstractStringBuilder.newCapacity
+	}
+}
+
+// splice replaces the chars [start, end) of buf with s, leaving n chars.
+func (st *builderState) splice(obj *JObject, buf []byte, start, end int, s string, n int) {
+	from, to := charOffset(buf, start), charOffset(buf, end)
+	spliced := make([]byte, 0, len(buf)-(to-from)+len(s))
+	spliced = append(append(append(spliced, buf[:from]...), s...), buf[to:]...)
+	st.store(obj, spliced, n)
+}
+
+// capacityOf returns capacity() for the contents buf.
+func (st *builderState) capacityOf(buf []byte) int {
+	if st.capacity >= 0 {
+		return st.capacity
+	}
+	return st.length(buf)
+}
+
 // handleStringMethod handles String instance method calls natively.
 func (vm *VM) handleStringMethod(str, methodName, descriptor string, args []Value) (Value, error) {
 	switch methodName {
 	case "length":
-		return IntValue(int32(len(str))), nil
+		return IntValue(int32(charLength(str))), nil
 	case "charAt":
 		idx := int(args[0].Int)
-		if idx < 0 || idx >= len(str) {
-			return Value{}, NewJavaException("java/lang/StringIndexOutOfBoundsException")
+		if isASCII(str) {
+			if idx < 0 || idx >= len(str) {
+				return Value{}, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+					fmt.Sprintf("String index out of range: %d", idx))
+			}
+			return IntValue(int32(str[idx])), nil
 		}
-		return IntValue(int32(str[idx])), nil
+		chars := charsOf(str)
+		if idx < 0 || idx >= len(chars) {
+			return Value{}, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("index %d, length %d", idx, len(chars)))
+		}
+		return IntValue(int32(chars[idx])), nil
 	case "substring":
-		if descriptor == "(I)Ljava/lang/String;" {
-			begin := int(args[0].Int)
-			if begin < 0 || begin > len(str) {
-				return Value{}, NewJavaException("java/lang/StringIndexOutOfBoundsException")
-			}
-			return RefValue(str[begin:]), nil
+		n := charLength(str)
+		begin, end := int(args[0].Int), n
+		if descriptor == "(II)Ljava/lang/String;" {
+			end = int(args[1].Int)
 		}
-		// (II)Ljava/lang/String;
-		begin := int(args[0].Int)
-		end := int(args[1].Int)
-		if begin < 0 || end > len(str) || begin > end {
-			return Value{}, NewJavaException("java/lang/StringIndexOutOfBoundsException")
+		if begin < 0 || end > n || begin > end {
+			return Value{}, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("begin %d, end %d, length %d", begin, end, n))
 		}
-		return RefValue(str[begin:end]), nil
+		if isASCII(str) {
+			return RefValue(str[begin:end]), nil
+		}
+		return RefValue(charsString(charsOf(str)[begin:end])), nil
 	case "indexOf":
-		if descriptor == "(Ljava/lang/String;)I" {
-			target, _ := args[0].Ref.(string)
-			return IntValue(int32(strings.Index(str, target))), nil
+		from := 0
+		if len(args) > 1 {
+			if from = max(int(args[1].Int), 0); from >= charLength(str) {
+				if target, ok := extractGoString(args[0]); ok && target == "" {
+					return IntValue(int32(charLength(str))), nil
+				}
+				return IntValue(-1), nil
+			}
 		}
-		if descriptor == "(I)I" {
-			ch := rune(args[0].Int)
-			return IntValue(int32(strings.IndexRune(str, ch))), nil
+		start := byteOffset(str, from)
+		i := -1
+		if target, ok := extractGoString(args[0]); ok {
+			i = strings.Index(str[start:], target)
+		} else if descriptor == "(Ljava/lang/String;)I" || descriptor == "(Ljava/lang/String;I)I" {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		} else {
+			i = indexCodePoint(str[start:], args[0].Int)
+			if i >= 0 {
+				return IntValue(int32(from + i)), nil
+			}
 		}
-		return IntValue(-1), nil
+		if i < 0 {
+			return IntValue(-1), nil
+		}
+		return IntValue(int32(charIndex(str, start+i))), nil
+	case "lastIndexOf":
+		n := charLength(str)
+		from := n
+		if len(args) > 1 {
+			from = int(args[1].Int)
+		}
+		if from < 0 {
+			return IntValue(-1), nil
+		}
+		target, ok := extractGoString(args[0])
+		if !ok {
+			if strings.HasPrefix(descriptor, "(Ljava/lang/String;") {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			return IntValue(int32(lastIndexCodePoint(str, args[0].Int, from))), nil
+		}
+		end := min(byteOffset(str, min(from, n))+len(target), len(str))
+		return IntValue(int32(charIndex(str, strings.LastIndex(str[:end], target)))), nil
 	case "contains":
 		target, _ := args[0].Ref.(string)
 		if strings.Contains(str, target) {
@@ -1602,17 +2691,121 @@ func (vm *VM) handleStringMethod(str, methodName, descriptor string, args []Valu
 			return IntValue(1), nil
 		}
 		return IntValue(0), nil
-	case "toUpperCase":
-		return RefValue(strings.ToUpper(str)), nil
-	case "toLowerCase":
-		return RefValue(strings.ToLower(str)), nil
+	case "toUpperCase", "toLowerCase":
+		// The no-arg forms use the default locale, user.language.
+		lang := vm.defaultLanguage()
+		if descriptor == "(Ljava/util/Locale;)Ljava/lang/String;" {
+			if args[0].Type == TypeNull || args[0].Ref == nil {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			lang = localeLanguage(args[0])
+		}
+		if methodName == "toUpperCase" {
+			return RefValue(javaToUpper(str, lang)), nil
+		}
+		return RefValue(javaToLower(str, lang)), nil
 	case "trim":
 		return RefValue(strings.TrimSpace(str)), nil
+	case "strip":
+		return RefValue(strings.TrimFunc(str, isJavaWhitespace)), nil
+	case "stripLeading":
+		return RefValue(strings.TrimLeftFunc(str, isJavaWhitespace)), nil
+	case "stripTrailing":
+		return RefValue(strings.TrimRightFunc(str, isJavaWhitespace)), nil
+	case "isBlank":
+		return boolValue(strings.TrimLeftFunc(str, isJavaWhitespace) == ""), nil
+	case "repeat":
+		count := int(args[0].Int)
+		if count < 0 {
+			return Value{}, NewJavaExceptionMessage("java/lang/IllegalArgumentException", fmt.Sprintf("count is negative: %d", count))
+		}
+		if n := charLength(str); n > 0 && count > math.MaxInt32/n {
+			return Value{}, NewJavaExceptionMessage("java/lang/OutOfMemoryError", "Required length exceeds implementation limit")
+		}
+		return RefValue(strings.Repeat(str, count)), nil
+	case "concat":
+		other, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		return RefValue(str + other), nil
+	case "equalsIgnoreCase":
+		other, ok := extractGoString(args[0])
+		return boolValue(ok && charLength(str) == charLength(other) && regionMatches(str, true, 0, other, 0, charLength(str))), nil
+	case "compareToIgnoreCase":
+		other, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		return IntValue(int32(compareCharsIgnoreCase(str, other))), nil
+	case "regionMatches":
+		ignoreCase := false
+		if descriptor == "(ZILjava/lang/String;II)Z" {
+			ignoreCase, args = args[0].Int != 0, args[1:]
+		}
+		other, ok := extractGoString(args[1])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		return boolValue(regionMatches(str, ignoreCase, int(args[0].Int), other, int(args[2].Int), int(args[3].Int))), nil
+	case "codePointAt":
+		chars := charsOf(str)
+		index := int(args[0].Int)
+		if index < 0 || index >= len(chars) {
+			return Value{}, NewJavaExceptionMessage("java/lang/StringIndexOutOfBoundsException",
+				fmt.Sprintf("index %d, length %d", index, len(chars)))
+		}
+		if hi := int32(chars[index]); hi >= 0xD800 && hi < 0xDC00 && index+1 < len(chars) {
+			if lo := int32(chars[index+1]); lo >= 0xDC00 && lo < 0xE000 {
+				return IntValue((hi-0xD800)<<10 + (lo - 0xDC00) + 0x10000), nil
+			}
+		}
+		return IntValue(int32(chars[index])), nil
+	case "codePointCount":
+		n := charLength(str)
+		begin, end := int(args[0].Int), int(args[1].Int)
+		if begin < 0 || end > n || begin > end {
+			return Value{}, NewJavaExceptionMessage("java/lang/IndexOutOfBoundsException",
+				fmt.Sprintf("begin %d, end %d, length %d", begin, end, n))
+		}
+		return IntValue(int32(utf8.RuneCountInString(str[byteOffset(str, begin):byteOffset(str, end)]))), nil
+	case "chars", "codePoints":
+		var points []int32
+		if methodName == "chars" {
+			for _, c := range stringChars(str) {
+				points = append(points, int32(c))
+			}
+		} else {
+			for _, r := range str {
+				points = append(points, r)
+			}
+		}
+		arr := NewArray("I", len(points))
+		copy(arr.Ints, points)
+		return vm.intStream(arr)
+	case "formatted":
+		var formatArgs []Value
+		if arr, ok := args[0].Ref.(*JArray); ok {
+			formatArgs = arr.Elements
+		}
+		s, err := vm.formatString(str, formatArgs)
+		if err != nil {
+			return Value{}, err
+		}
+		return RefValue(s), nil
 	case "replace":
 		if descriptor == "(CC)Ljava/lang/String;" {
-			oldCh := rune(args[0].Int)
-			newCh := rune(args[1].Int)
-			return RefValue(strings.ReplaceAll(str, string(oldCh), string(newCh))), nil
+			oldCh, newCh := uint16(args[0].Int), uint16(args[1].Int)
+			if oldCh < 0x80 && newCh < 0x80 {
+				return RefValue(strings.ReplaceAll(str, string(rune(oldCh)), string(rune(newCh)))), nil
+			}
+			chars := stringChars(str)
+			for i, c := range chars {
+				if c == oldCh {
+					chars[i] = newCh
+				}
+			}
+			return RefValue(charsString(chars)), nil
 		}
 		// (Ljava/lang/CharSequence;Ljava/lang/CharSequence;)Ljava/lang/String;
 		if len(args) >= 2 {
@@ -1621,6 +2814,8 @@ func (vm *VM) handleStringMethod(str, methodName, descriptor string, args []Valu
 			return RefValue(strings.ReplaceAll(str, oldStr, newStr)), nil
 		}
 		return RefValue(str), nil
+	case "matches", "replaceAll", "replaceFirst", "split":
+		return vm.handleStringRegex(str, methodName, descriptor, args)
 	case "isEmpty":
 		if len(str) == 0 {
 			return IntValue(1), nil
@@ -1628,7 +2823,7 @@ func (vm *VM) handleStringMethod(str, methodName, descriptor string, args []Valu
 		return IntValue(0), nil
 	case "hashCode":
 		h := int32(0)
-		for _, c := range str {
+		for _, c := range charsOf(str) {
 			h = 31*h + int32(c)
 		}
 		return IntValue(h), nil
@@ -1647,127 +2842,309 @@ func (vm *VM) handleStringMethod(str, methodName, descriptor string, args []Valu
 		}
 		return IntValue(0), nil
 	case "toCharArray":
-		chars := make([]Value, len(str))
-		for i, c := range str {
-			chars[i] = IntValue(int32(c))
-		}
-		return RefValue(&JArray{Elements: chars}), nil
+		return RefValue(charArray(str)), nil
 	case "getBytes":
-		bytes := make([]Value, len(str))
-		for i := 0; i < len(str); i++ {
-			bytes[i] = IntValue(int32(str[i]))
+		charset := vm.defaultCharset()
+		switch descriptor {
+		case "(Ljava/lang/String;)[B":
+			name, ok := extractGoString(args[0])
+			if !ok {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			if charset, ok = canonicalCharset(name); !ok {
+				return Value{}, NewJavaExceptionMessage("java/io/UnsupportedEncodingException", name)
+			}
+		case "(Ljava/nio/charset/Charset;)[B":
+			name, ok := charsetName(args[0])
+			if !ok {
+				return Value{}, NewJavaException("java/lang/NullPointerException")
+			}
+			if charset, ok = canonicalCharset(name); !ok {
+				return Value{}, fmt.Errorf("String.getBytes: unsupported charset %s", name)
+			}
 		}
-		return RefValue(&JArray{Elements: bytes}), nil
+		return RefValue(goBytesToArray(encodeString(str, charset))), nil
 	case "compareTo":
 		other, _ := args[0].Ref.(string)
-		return IntValue(int32(strings.Compare(str, other))), nil
+		return IntValue(int32(compareChars(str, other))), nil
 	case "intern":
 		return RefValue(str), nil
 	}
 	return Value{}, fmt.Errorf("String method not implemented: %s:%s", methodName, descriptor)
 }
 
+// localeLanguage returns the language code of a java.util.Locale object
+// ("" for Locale.ROOT or when it cannot be determined).
+func localeLanguage(locale Value) string {
+	obj, ok := locale.Ref.(*JObject)
+	if !ok {
+		return ""
+	}
+	if base, ok := obj.Fields["baseLocale"].Ref.(*JObject); ok {
+		if lang, ok := extractGoString(base.Fields["language"]); ok {
+			return lang
+		}
+	}
+	return ""
+}
+
+// javaToUpper follows String.toUpperCase: Turkish and Azerbaijani map i to
+// dotted capital I, and ß expands to SS. Every other locale behaves as ROOT.
+func javaToUpper(s, lang string) string {
+	if lang == "tr" || lang == "az" {
+		return strings.ToUpperSpecial(unicode.TurkishCase, s)
+	}
+	return strings.ReplaceAll(strings.ToUpper(s), "ß", "SS")
+}
+
+// javaToLower follows String.toLowerCase: Turkish and Azerbaijani map I to
+// dotless i, İ becomes i followed by a combining dot elsewhere, and a
+// capital sigma at the end of a word becomes final sigma.
+func javaToLower(s, lang string) string {
+	if lang == "tr" || lang == "az" {
+		return strings.ToLowerSpecial(unicode.TurkishCase, s)
+	}
+	runes := []rune(s)
+	var sb strings.Builder
+	for i, r := range runes {
+		switch {
+		case r == 'İ':
+			sb.WriteString("i\u0307")
+		case r == 'Σ' && i > 0 && unicode.IsLetter(runes[i-1]) && (i+1 == len(runes) || !unicode.IsLetter(runes[i+1])):
+			sb.WriteRune('ς')
+		default:
+			sb.WriteRune(unicode.ToLower(r))
+		}
+	}
+	return sb.String()
+}
+
 // handleStringValueOf handles String.valueOf static method calls natively.
 func (vm *VM) handleStringValueOf(descriptor string, args []Value) (Value, error) {
-	switch descriptor {
-	case "(I)Ljava/lang/String;":
-		return RefValue(fmt.Sprintf("%d", args[0].Int)), nil
-	case "(J)Ljava/lang/String;":
-		return RefValue(fmt.Sprintf("%d", args[0].Long)), nil
-	case "(D)Ljava/lang/String;":
-		return RefValue(fmt.Sprintf("%v", args[0].Double)), nil
-	case "(F)Ljava/lang/String;":
-		return RefValue(fmt.Sprintf("%v", args[0].Float)), nil
-	case "(Z)Ljava/lang/String;":
-		if args[0].Int != 0 {
-			return RefValue("true"), nil
-		}
-		return RefValue("false"), nil
-	case "(C)Ljava/lang/String;":
-		return RefValue(string(rune(args[0].Int))), nil
-	case "(Ljava/lang/Object;)Ljava/lang/String;":
-		if args[0].Type == TypeNull {
-			return RefValue("null"), nil
-		}
-		if s, ok := args[0].Ref.(string); ok {
-			return RefValue(s), nil
+	switch param := descriptor[1:strings.IndexByte(descriptor, ')')]; param {
+	case "I", "J", "F", "D", "Z", "C", "Ljava/lang/Object;":
+		return RefValue(vm.stringValueOf(args[0], param)), nil
+	case "[C":
+		arr, ok := args[0].Ref.(*JArray)
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
 		}
-		return RefValue(vm.valueToString(args[0])), nil
+		return RefValue(charsString(arrayChars(arr, 0, arr.Len()))), nil
 	}
 	return Value{}, fmt.Errorf("String.valueOf not implemented for %s", descriptor)
 }
 
+// handleStringStatic handles String.join and String.format natively.
+func (vm *VM) handleStringStatic(methodName, descriptor string, args []Value) (Value, error) {
+	if methodName == "format" {
+		if descriptor == "(Ljava/util/Locale;Ljava/lang/String;[Ljava/lang/Object;)Ljava/lang/String;" {
+			args = args[1:]
+		}
+		format, ok := extractGoString(args[0])
+		if !ok {
+			return Value{}, NewJavaException("java/lang/NullPointerException")
+		}
+		var formatArgs []Value
+		if arr, ok := args[1].Ref.(*JArray); ok {
+			formatArgs = arr.Elements
+		}
+		s, err := vm.formatString(format, formatArgs)
+		if err != nil {
+			return Value{}, err
+		}
+		return RefValue(s), nil
+	}
+
+	if args[0].Type == TypeNull || args[1].Type == TypeNull {
+		return Value{}, NewJavaException("java/lang/NullPointerException")
+	}
+	delimiter := vm.valueToString(args[0])
+	var parts []string
+	if arr, ok := args[1].Ref.(*JArray); ok {
+		for _, e := range arr.Elements {
+			parts = append(parts, vm.valueToString(e))
+		}
+		return RefValue(strings.Join(parts, delimiter)), nil
+	}
+	it, err := vm.callFunction(args[1], "iterator", "()Ljava/util/Iterator;")
+	if err != nil {
+		return Value{}, err
+	}
+	for {
+		more, err := vm.callFunction(it, "hasNext", "()Z")
+		if err != nil {
+			return Value{}, err
+		}
+		if more.Int == 0 {
+			return RefValue(strings.Join(parts, delimiter)), nil
+		}
+		e, err := vm.callFunction(it, "next", "()Ljava/lang/Object;")
+		if err != nil {
+			return Value{}, err
+		}
+		parts = append(parts, vm.valueToString(e))
+	}
+}
+
+// intStream returns Arrays.stream(arr) for an int[] arr, as String.chars
+// and String.codePoints do.
+func (vm *VM) intStream(arr *JArray) (Value, error) {
+	if err := vm.ensureInitialized("java/util/Arrays"); err != nil {
+		return Value{}, err
+	}
+	cf, method, err := vm.resolveMethod("java/util/Arrays", "stream", "([I)Ljava/util/stream/IntStream;")
+	if err != nil {
+		return Value{}, fmt.Errorf("String.chars: %w", err)
+	}
+	return vm.executeMethod(cf, method, []Value{RefValue(arr)})
+}
+
 // handleBoxedType handles methods on boxed types (Integer, Long, Double, etc.)
 func (vm *VM) handleBoxedType(frame *Frame, obj *JObject, methodName, descriptor string, args []Value) (Value, bool, error) {
 	val, hasValue := obj.Fields["value"]
-	if !hasValue {
+	if !hasValue || val.Type == TypeRef || val.Type == TypeNull {
 		return Value{}, false, nil // not handled
 	}
-	switch methodName {
-	case "intValue":
-		if val.Type == TypeInt {
-			frame.Push(IntValue(val.Int))
-			return Value{}, true, nil
-		}
-	case "longValue":
-		if val.Type == TypeLong {
-			frame.Push(LongValue(val.Long))
-			return Value{}, true, nil
-		}
-		if val.Type == TypeInt {
-			frame.Push(LongValue(int64(val.Int)))
-			return Value{}, true, nil
-		}
-	case "doubleValue":
-		if val.Type == TypeDouble {
-			frame.Push(DoubleValue(val.Double))
-			return Value{}, true, nil
-		}
-		if val.Type == TypeInt {
-			frame.Push(DoubleValue(float64(val.Int)))
-			return Value{}, true, nil
-		}
-	case "floatValue":
-		if val.Type == TypeFloat {
-			frame.Push(FloatValue(val.Float))
-			return Value{}, true, nil
-		}
-	case "compareTo":
-		if len(args) > 0 {
-			if other, ok := args[0].Ref.(*JObject); ok {
-				if otherVal, ok := other.Fields["value"]; ok {
-					cmp := int32(0)
-					if val.Int < otherVal.Int {
-						cmp = -1
-					} else if val.Int > otherVal.Int {
-						cmp = 1
-					}
-					frame.Push(IntValue(cmp))
-					return Value{}, true, nil
-				}
-			}
-		}
-	case "equals":
-		if len(args) > 0 {
-			if args[0].Type == TypeNull {
-				frame.Push(IntValue(0))
+	switch methodName + descriptor {
+	case "intValue()I", "longValue()J", "floatValue()F", "doubleValue()D", "shortValue()S", "byteValue()B":
+		frame.Push(convertPrimitive(val, descriptor[len(descriptor)-1]))
+		return Value{}, true, nil
+	case "compareTo(Ljava/lang/Object;)I", "compareTo(L" + obj.ClassName + ";)I":
+		if other, ok := args[0].Ref.(*JObject); ok {
+			if otherVal, ok := other.Fields["value"]; ok && otherVal.Type == val.Type {
+				frame.Push(IntValue(comparePrimitives(val, otherVal)))
 				return Value{}, true, nil
 			}
-			if other, ok := args[0].Ref.(*JObject); ok {
-				if otherVal, ok := other.Fields["value"]; ok && val.Type == otherVal.Type && val.Int == otherVal.Int {
-					frame.Push(IntValue(1))
-					return Value{}, true, nil
-				}
-			}
+		}
+	case "equals(Ljava/lang/Object;)Z":
+		equal := false
+		if other, ok := args[0].Ref.(*JObject); ok && other.ClassName == obj.ClassName {
+			otherVal := other.Fields["value"]
+			equal = otherVal.Type == val.Type && comparePrimitives(val, otherVal) == 0
+		}
+		if equal {
+			frame.Push(IntValue(1))
+		} else {
 			frame.Push(IntValue(0))
-			return Value{}, true, nil
 		}
-	case "hashCode":
-		frame.Push(IntValue(val.Int))
 		return Value{}, true, nil
-	case "toString":
-		frame.Push(RefValue(fmt.Sprintf("%d", val.Int)))
+	case "hashCode()I":
+		frame.Push(IntValue(primitiveHash(obj.ClassName, val)))
+		return Value{}, true, nil
+	case "toString()Ljava/lang/String;":
+		frame.Push(RefValue(vm.valueToString(RefValue(obj))))
 		return Value{}, true, nil
 	}
 	return Value{}, false, nil // not handled
 }
+
+// convertPrimitive converts a numeric value to the primitive type with the
+// given descriptor character, following the JVM conversion instructions.
+func convertPrimitive(v Value, to byte) Value {
+	var i int64
+	var f float64
+	floating := false
+	switch v.Type {
+	case TypeLong:
+		i = v.Long
+	case TypeFloat:
+		f, floating = float64(v.Float), true
+	case TypeDouble:
+		f, floating = v.Double, true
+	default:
+		i = int64(v.Int)
+	}
+	switch to {
+	case 'J':
+		if floating {
+			return LongValue(doubleToLong(f))
+		}
+		return LongValue(i)
+	case 'F':
+		if floating {
+			return FloatValue(float32(f))
+		}
+		return FloatValue(float32(i))
+	case 'D':
+		if floating {
+			return DoubleValue(f)
+		}
+		return DoubleValue(float64(i))
+	}
+	n := int32(i)
+	if floating {
+		n = doubleToInt(f)
+	}
+	switch to {
+	case 'S':
+		return IntValue(int32(int16(n)))
+	case 'B':
+		return IntValue(int32(int8(n)))
+	}
+	return IntValue(n)
+}
+
+// comparePrimitives compares two values of the same type like the
+// compareTo method of their box: floating-point values order -0.0 below
+// 0.0 and NaN above everything, and NaN equals itself.
+func comparePrimitives(a, b Value) int32 {
+	switch a.Type {
+	case TypeLong:
+		return int32(cmp.Compare(a.Long, b.Long))
+	case TypeFloat:
+		return compareFloating(float64(a.Float), float64(b.Float))
+	case TypeDouble:
+		return compareFloating(a.Double, b.Double)
+	}
+	return int32(cmp.Compare(a.Int, b.Int))
+}
+
+// compareFloating implements Double.compare.
+func compareFloating(x, y float64) int32 {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	xBits, yBits := canonicalBits(x), canonicalBits(y)
+	switch {
+	case xBits == yBits:
+		return 0
+	case int64(xBits) < int64(yBits): // -0.0 < 0.0, and NaN sorts last
+		return -1
+	}
+	return 1
+}
+
+// canonicalBits returns Double.doubleToLongBits(d): the IEEE bits of d with
+// every NaN collapsed to the canonical one.
+func canonicalBits(d float64) uint64 {
+	if d != d {
+		return 0x7ff8000000000000
+	}
+	return math.Float64bits(d)
+}
+
+// primitiveHash returns the hashCode of a box of the named class holding v.
+func primitiveHash(className string, v Value) int32 {
+	switch v.Type {
+	case TypeLong:
+		return int32(v.Long ^ int64(uint64(v.Long)>>32))
+	case TypeFloat:
+		if v.Float != v.Float {
+			return 0x7fc00000
+		}
+		return int32(math.Float32bits(v.Float))
+	case TypeDouble:
+		bits := canonicalBits(v.Double)
+		return int32(bits ^ bits>>32)
+	}
+	if className == "java/lang/Boolean" {
+		if v.Int != 0 {
+			return 1231
+		}
+		return 1237
+	}
+	return v.Int
+}
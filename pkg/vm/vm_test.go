@@ -0,0 +1,984 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+	"github.com/daimatz/gojvm/pkg/native"
+)
+
+// mapClassLoader serves hand-built ClassFiles for unit tests.
+type mapClassLoader map[string]*classfile.ClassFile
+
+func (m mapClassLoader) LoadClass(name string) (*classfile.ClassFile, error) {
+	if cf, ok := m[name]; ok {
+		return cf, nil
+	}
+	return nil, fmt.Errorf("class %s not found", name)
+}
+
+// isJavaException reports whether err is a Java exception of the class.
+func isJavaException(err error, class string) bool {
+	exc, ok := err.(*JavaException)
+	return ok && exc.Object.ClassName == class
+}
+
+// runFrame executes code in a fresh frame of cf and returns the returned value.
+func runFrame(t *testing.T, v *VM, cf *classfile.ClassFile, code []byte) Value {
+	t.Helper()
+	frame := NewFrame(4, 10, code, cf)
+	for frame.PC < len(frame.Code) {
+		opcode := frame.Code[frame.PC]
+		frame.PC++
+		retVal, hasReturn, err := v.executeInstruction(frame, opcode)
+		if err != nil {
+			t.Fatalf("execution error at PC=%d: %v", frame.PC-1, err)
+		}
+		if hasReturn {
+			return retVal
+		}
+	}
+	t.Fatal("bytecode did not return a value")
+	return Value{}
+}
+
+func TestConstantValueStaticFields(t *testing.T) {
+	// class Consts { static final int ANSWER = 42; static final String NAME = "gojvm"; }
+	// There is no <clinit>: the values come only from ConstantValue attributes.
+	pool := make([]classfile.ConstantPoolEntry, 14)
+	pool[1] = &classfile.ConstantClass{NameIndex: 2}
+	pool[2] = &classfile.ConstantUtf8{Value: "Consts"}
+	pool[3] = &classfile.ConstantFieldref{ClassIndex: 1, NameAndTypeIndex: 4}
+	pool[4] = &classfile.ConstantNameAndType{NameIndex: 5, DescriptorIndex: 6}
+	pool[5] = &classfile.ConstantUtf8{Value: "ANSWER"}
+	pool[6] = &classfile.ConstantUtf8{Value: "I"}
+	pool[7] = &classfile.ConstantInteger{Value: 42}
+	pool[8] = &classfile.ConstantFieldref{ClassIndex: 1, NameAndTypeIndex: 9}
+	pool[9] = &classfile.ConstantNameAndType{NameIndex: 10, DescriptorIndex: 11}
+	pool[10] = &classfile.ConstantUtf8{Value: "NAME"}
+	pool[11] = &classfile.ConstantUtf8{Value: "Ljava/lang/String;"}
+	pool[12] = &classfile.ConstantString{StringIndex: 13}
+	pool[13] = &classfile.ConstantUtf8{Value: "gojvm"}
+
+	cf := &classfile.ClassFile{
+		ConstantPool: pool,
+		ThisClass:    1,
+		Fields: []classfile.FieldInfo{
+			{AccessFlags: 0x0018, Name: "ANSWER", Descriptor: "I", ConstantValue: pool[7]},
+			{AccessFlags: 0x0018, Name: "NAME", Descriptor: "Ljava/lang/String;", ConstantValue: pool[12]},
+		},
+	}
+
+	v := NewVM(mapClassLoader{"Consts": cf})
+	v.Stdout = io.Discard
+
+	got := runFrame(t, v, cf, []byte{0xB2, 0x00, 0x03, 0xAC}) // getstatic #3, ireturn
+	if got.Type != TypeInt || got.Int != 42 {
+		t.Errorf("ANSWER: got %+v, want 42", got)
+	}
+	got = runFrame(t, v, cf, []byte{0xB2, 0x00, 0x08, 0xB0}) // getstatic #8, areturn
+	if s, ok := got.Ref.(string); !ok || s != "gojvm" {
+		t.Errorf("NAME: got %+v, want \"gojvm\"", got)
+	}
+}
+
+func TestStringCaseConversion(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	locale := func(lang string) Value {
+		base := &JObject{ClassName: "sun/util/locale/BaseLocale", Fields: map[string]Value{"language": RefValue(lang)}}
+		return RefValue(&JObject{ClassName: "java/util/Locale", Fields: map[string]Value{"baseLocale": RefValue(base)}})
+	}
+	const withLocale = "(Ljava/util/Locale;)Ljava/lang/String;"
+
+	tests := []struct {
+		method, input string
+		args          []Value
+		want          string
+	}{
+		{"toUpperCase", "straße", nil, "STRASSE"},
+		{"toUpperCase", "title", []Value{locale("")}, "TITLE"},
+		{"toUpperCase", "title", []Value{locale("tr")}, "TİTLE"},
+		{"toLowerCase", "TITLE", []Value{locale("en")}, "title"},
+		{"toLowerCase", "TITLE", []Value{locale("tr")}, "tıtle"},
+		{"toLowerCase", "ΟΔΟΣ", nil, "οδος"},
+	}
+	for _, tt := range tests {
+		desc := "()Ljava/lang/String;"
+		if tt.args != nil {
+			desc = withLocale
+		}
+		got, err := v.handleStringMethod(tt.input, tt.method, desc, tt.args)
+		if err != nil {
+			t.Errorf("%s(%q): %v", tt.method, tt.input, err)
+			continue
+		}
+		if got.Ref != tt.want {
+			t.Errorf("%s(%q): got %q, want %q", tt.method, tt.input, got.Ref, tt.want)
+		}
+	}
+
+	_, err := v.handleStringMethod("x", "toUpperCase", withLocale, []Value{NullValue()})
+	if javaExc, ok := err.(*JavaException); !ok || javaExc.Object.ClassName != "java/lang/NullPointerException" {
+		t.Errorf("null locale: expected NullPointerException, got %v", err)
+	}
+}
+
+func TestClassLiterals(t *testing.T) {
+	// String[].class == String[].class, and its component type is String.class
+	pool := make([]classfile.ConstantPoolEntry, 5)
+	pool[1] = &classfile.ConstantClass{NameIndex: 2}
+	pool[2] = &classfile.ConstantUtf8{Value: "Lits"}
+	pool[3] = &classfile.ConstantClass{NameIndex: 4}
+	pool[4] = &classfile.ConstantUtf8{Value: "[Ljava/lang/String;"}
+	cf := &classfile.ClassFile{ConstantPool: pool, ThisClass: 1}
+
+	v := NewVM(mapClassLoader{"Lits": cf})
+	v.Stdout = io.Discard
+
+	first := runFrame(t, v, cf, []byte{0x12, 0x03, 0xB0}) // ldc #3, areturn
+	second := runFrame(t, v, cf, []byte{0x12, 0x03, 0xB0})
+	if first.Ref != second.Ref {
+		t.Error("ldc of the same class literal should yield the same Class object")
+	}
+
+	native := func(method, desc string, args ...Value) Value {
+		t.Helper()
+		got, err := v.executeNativeMethod("java/lang/Class", method, desc, args)
+		if err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		return got
+	}
+	if native("isArray", "()Z", first).Int != 1 {
+		t.Error("String[].class.isArray() should be true")
+	}
+	if got := native("getComponentType", "()Ljava/lang/Class;", first); got.Ref != v.classObject("java/lang/String").Ref {
+		t.Errorf("getComponentType: got %v", got.Ref)
+	}
+
+	intClass := native("getPrimitiveClass", "(Ljava/lang/String;)Ljava/lang/Class;", RefValue("int"))
+	if native("getPrimitiveClass", "(Ljava/lang/String;)Ljava/lang/Class;", RefValue("int")).Ref != intClass.Ref {
+		t.Error("int.class should be canonical")
+	}
+	if native("isPrimitive", "()Z", intClass).Int != 1 {
+		t.Error("int.class.isPrimitive() should be true")
+	}
+	intArray := v.classObject("[I")
+	if got := native("getComponentType", "()Ljava/lang/Class;", intArray); got.Ref != intClass.Ref {
+		t.Errorf("int[].class.getComponentType(): got %v, want int.class", got.Ref)
+	}
+}
+
+func TestNestHost(t *testing.T) {
+	classFile := func(name, nestHost string, members ...string) *classfile.ClassFile {
+		pool := []classfile.ConstantPoolEntry{nil, &classfile.ConstantClass{NameIndex: 2}, &classfile.ConstantUtf8{Value: name}}
+		return &classfile.ClassFile{ConstantPool: pool, ThisClass: 1, NestHost: nestHost, NestMembers: members}
+	}
+	v := NewVM(mapClassLoader{
+		"Outer":       classFile("Outer", "", "Outer$Inner"),
+		"Outer$Inner": classFile("Outer$Inner", "Outer"),
+		"Rogue":       classFile("Rogue", "Outer"), // claims a host that does not list it
+	})
+
+	tests := map[string]string{"Outer": "Outer", "Outer$Inner": "Outer", "Rogue": "Rogue", "Missing": "Missing"}
+	for class, want := range tests {
+		if got := v.nestHostOf(class); got != want {
+			t.Errorf("nestHostOf(%s): got %s, want %s", class, got, want)
+		}
+	}
+
+	host, err := v.executeNativeMethod("java/lang/Class", "getNestHost0", "()Ljava/lang/Class;", []Value{v.classObject("Outer$Inner")})
+	if err != nil || host.Ref != v.classObject("Outer").Ref {
+		t.Errorf("getNestHost0: got %v, %v", host.Ref, err)
+	}
+}
+
+func TestPermittedSubclasses(t *testing.T) {
+	classFile := func(name, super string, permitted ...string) *classfile.ClassFile {
+		pool := []classfile.ConstantPoolEntry{nil,
+			&classfile.ConstantClass{NameIndex: 2}, &classfile.ConstantUtf8{Value: name},
+			&classfile.ConstantClass{NameIndex: 4}, &classfile.ConstantUtf8{Value: super},
+		}
+		return &classfile.ClassFile{ConstantPool: pool, ThisClass: 1, SuperClass: 3, PermittedSubclasses: permitted}
+	}
+	v := NewVM(mapClassLoader{
+		"Shape":  classFile("Shape", "java/lang/Object", "Circle"),
+		"Circle": classFile("Circle", "Shape"),
+		"Rogue":  classFile("Rogue", "Shape"),
+	})
+	v.Stdout = io.Discard
+
+	if err := v.ensureInitialized("Circle"); err != nil {
+		t.Errorf("Circle: unexpected error %v", err)
+	}
+	err := v.ensureInitialized("Rogue")
+	exc, ok := err.(*JavaException)
+	if !ok || exc.Object.ClassName != "java/lang/IncompatibleClassChangeError" {
+		t.Fatalf("Rogue: expected IncompatibleClassChangeError, got %v", err)
+	}
+
+	// Naming Rogue without initializing it fails the same way.
+	for name, op := range map[string]byte{"instanceof": OpInstanceof, "checkcast": OpCheckcast, "anewarray": OpAnewarray} {
+		v := NewVM(mapClassLoader{
+			"Shape": classFile("Shape", "java/lang/Object", "Circle"),
+			"Rogue": classFile("Rogue", "Shape"),
+		})
+		v.Stdout = io.Discard
+		b := classfile.NewBuilder("User", "java/lang/Object")
+		rogue := b.Class("Rogue")
+		first := byte(OpAconstNull)
+		if op == OpAnewarray {
+			first = OpIconst1
+		}
+		frame := NewFrame(4, 10, []byte{first, op, byte(rogue >> 8), byte(rogue), OpAreturn}, b.Build())
+		var err error
+		for err == nil && frame.PC < len(frame.Code) {
+			opcode := frame.Code[frame.PC]
+			frame.PC++
+			_, _, err = v.executeInstruction(frame, opcode)
+		}
+		if !isJavaException(err, "java/lang/IncompatibleClassChangeError") {
+			t.Errorf("%s Rogue: expected IncompatibleClassChangeError, got %v", name, err)
+		}
+		if v.classInits["Rogue"] != nil {
+			t.Errorf("%s Rogue: class was initialized", name)
+		}
+	}
+}
+
+func TestFloatingPointToString(t *testing.T) {
+	doubles := map[float64]string{
+		3.141592653589793:    "3.141592653589793",
+		1:                    "1.0",
+		-2.5:                 "-2.5",
+		1e7:                  "1.0E7",
+		1.2345e-5:            "1.2345E-5",
+		0.001:                "0.001",
+		1e300:                "1.0E300",
+		math.Inf(-1):         "-Infinity",
+		math.NaN():           "NaN",
+		math.Copysign(0, -1): "-0.0",
+		9999999:              "9999999.0",
+		1200:                 "1200.0",
+		0.0001:               "1.0E-4",
+		-0.00123:             "-0.00123",
+		4.9e-324:             "4.9E-324",
+		// JDK 17 digits, which are not always the shortest.
+		2e23:          "1.9999999999999998E23",
+		1.0e23:        "9.999999999999999E22",
+		0x1p-44:       "5.6843418860808015E-14",
+		0x1p60:        "1.15292150460684698E18",
+		123456789e-20: "1.23456789E-12",
+	}
+	for d, want := range doubles {
+		if got := formatDouble(d); got != want {
+			t.Errorf("formatDouble(%v) = %q, want %q", d, got, want)
+		}
+	}
+	floats := map[float32]string{0.1: "0.1", 1.0e10: "1.0E10", 3.4028235e38: "3.4028235E38", 100: "100.0", 1.4e-45: "1.4E-45", 1.0e-10: "1.0E-10"}
+	for f, want := range floats {
+		if got := formatFloat(f); got != want {
+			t.Errorf("formatFloat(%v) = %q, want %q", f, got, want)
+		}
+	}
+
+	// ldc2_w of a double constant keeps full precision.
+	b := classfile.NewBuilder("Pi", "java/lang/Object")
+	pi := b.Double(math.Pi)
+	v := &VM{Stdout: io.Discard}
+	got := runFrame(t, v, b.Build(), []byte{0x14, byte(pi >> 8), byte(pi), 0xAF}) // ldc2_w; dreturn
+	if got.Type != TypeDouble || got.Double != math.Pi || v.valueToString(got) != "3.141592653589793" {
+		t.Errorf("ldc2_w pi: got %+v", got)
+	}
+}
+
+func TestBoxedNumbers(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	box := func(class string, val Value) *JObject {
+		return &JObject{ClassName: class, Fields: map[string]Value{"value": val}}
+	}
+	call := func(obj *JObject, method, descriptor string, args ...Value) Value {
+		t.Helper()
+		frame := NewFrame(0, 2, nil, nil)
+		if _, handled, err := v.handleBoxedType(frame, obj, method, descriptor, args); !handled || err != nil {
+			t.Fatalf("%s.%s%s: handled=%v err=%v", obj.ClassName, method, descriptor, handled, err)
+		}
+		return frame.Pop()
+	}
+	d := box("java/lang/Double", DoubleValue(2.75))
+	f := box("java/lang/Float", FloatValue(1.5))
+	l := box("java/lang/Long", LongValue(1<<40))
+
+	if got := call(f, "doubleValue", "()D"); got.Type != TypeDouble || got.Double != 1.5 {
+		t.Errorf("Float.doubleValue: got %+v", got)
+	}
+	if got := call(d, "floatValue", "()F"); got.Type != TypeFloat || got.Float != 2.75 {
+		t.Errorf("Double.floatValue: got %+v", got)
+	}
+	if got := call(d, "intValue", "()I"); got.Int != 2 {
+		t.Errorf("Double.intValue: got %+v", got)
+	}
+	if got := call(l, "intValue", "()I"); got.Int != 0 {
+		t.Errorf("Long.intValue: got %+v, want truncation to 0", got)
+	}
+	if got := call(l, "compareTo", "(Ljava/lang/Long;)I", RefValue(box("java/lang/Long", LongValue(1)))); got.Int != 1 {
+		t.Errorf("Long.compareTo: got %d", got.Int)
+	}
+	nan := box("java/lang/Double", DoubleValue(math.NaN()))
+	if got := call(nan, "equals", "(Ljava/lang/Object;)Z", RefValue(box("java/lang/Double", DoubleValue(math.NaN())))); got.Int != 1 {
+		t.Error("Double.NaN.equals(NaN) should be true")
+	}
+	negZero := box("java/lang/Double", DoubleValue(math.Copysign(0, -1)))
+	if got := call(negZero, "compareTo", "(Ljava/lang/Object;)I", RefValue(box("java/lang/Double", DoubleValue(0)))); got.Int != -1 {
+		t.Errorf("Double.compare(-0.0, 0.0): got %d", got.Int)
+	}
+	if got := call(l, "hashCode", "()I"); got.Int != 256 {
+		t.Errorf("Long.hashCode: got %d", got.Int)
+	}
+	if got := call(box("java/lang/Double", DoubleValue(1)), "hashCode", "()I"); got.Int != 1072693248 {
+		t.Errorf("Double.hashCode(1.0): got %d", got.Int)
+	}
+	if got := call(f, "toString", "()Ljava/lang/String;"); got.Ref != "1.5" {
+		t.Errorf("Float.toString: got %v", got.Ref)
+	}
+	if got := call(l, "toString", "()Ljava/lang/String;"); got.Ref != "1099511627776" {
+		t.Errorf("Long.toString: got %v", got.Ref)
+	}
+}
+
+func TestNullRendering(t *testing.T) {
+	var out bytes.Buffer
+	v := &VM{Stdout: &out}
+	ps := &native.PrintStream{Writer: &out}
+	for _, c := range []struct {
+		method, descriptor string
+		arg                Value
+	}{
+		{"println", "(Ljava/lang/String;)V", NullValue()},
+		{"print", "(Ljava/lang/Object;)V", NullValue()},
+		{"println", "(Ljava/lang/Object;)V", RefValue(nil)},
+		{"println", "(C)V", IntValue('x')},
+		{"println", "(Z)V", IntValue(1)},
+		{"println", "([C)V", RefValue(charArray("hi"))},
+	} {
+		if _, _, err := v.handlePrintStream(nil, ps, c.method, c.descriptor, []Value{c.arg}); err != nil {
+			t.Fatalf("%s%s: %v", c.method, c.descriptor, err)
+		}
+	}
+	if _, _, err := v.handlePrintStream(nil, ps, "println", "()V", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "null\nnullnull\nx\ntrue\nhi\n\n"; got != want {
+		t.Errorf("printed %q, want %q", got, want)
+	}
+	_, _, err := v.handlePrintStream(nil, ps, "println", "([C)V", []Value{NullValue()})
+	if exc, ok := err.(*JavaException); !ok || exc.Object.ClassName != "java/lang/NullPointerException" {
+		t.Errorf("println((char[]) null): got %v", err)
+	}
+
+	// "x" + s + c + b with s == null, as javac compiles it with indy.
+	frame := NewFrame(0, 4, nil, nil)
+	frame.Push(NullValue())
+	frame.Push(IntValue('!'))
+	frame.Push(IntValue(0))
+	if _, _, err := v.handleStringConcatFactory(frame, []Value{RefValue("x\x01\x01\x01")}, "makeConcatWithConstants", "(Ljava/lang/String;CZ)Ljava/lang/String;"); err != nil {
+		t.Fatal(err)
+	}
+	if got := frame.Pop().Ref; got != "xnull!false" {
+		t.Errorf("concat: got %q", got)
+	}
+
+	if got, err := v.handleStringValueOf("(Ljava/lang/Object;)Ljava/lang/String;", []Value{NullValue()}); err != nil || got.Ref != "null" {
+		t.Errorf("valueOf((Object) null): got %v, %v", got.Ref, err)
+	}
+	if got, err := v.handleStringValueOf("(C)Ljava/lang/String;", []Value{IntValue('q')}); err != nil || got.Ref != "q" {
+		t.Errorf("valueOf(char): got %v, %v", got.Ref, err)
+	}
+
+	sb := RefValue(&JObject{ClassName: "java/lang/StringBuilder", Fields: map[string]Value{"_buffer": RefValue([]byte{})}})
+	for _, descriptor := range []string{"(Ljava/lang/String;)Ljava/lang/StringBuilder;", "(Ljava/lang/CharSequence;)Ljava/lang/StringBuilder;", "(Ljava/lang/Object;)Ljava/lang/StringBuilder;"} {
+		if _, _, err := v.handleStringBuilder(sb, "append", descriptor, []Value{NullValue()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := string(sb.Ref.(*JObject).Fields["_buffer"].Ref.([]byte)); got != "nullnullnull" {
+		t.Errorf("StringBuilder.append(null): got %q", got)
+	}
+}
+
+func TestStaticFieldWidths(t *testing.T) {
+	base := classfile.NewBuilder("Base", "java/lang/Object")
+	base.AddField(classfile.AccStatic, "count", "I", nil)
+	count := base.Fieldref("Base", "count", "I")
+	base.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{0x10, 7, 0xb3, byte(count >> 8), byte(count), 0xb1}, // bipush 7; putstatic count; return
+	})
+	sub := classfile.NewBuilder("Sub", "Base")
+	sub.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{Code: []byte{0xb1}})
+
+	b := classfile.NewBuilder("Widths", "java/lang/Object")
+	fields := map[string]uint16{}
+	for _, f := range []struct{ name, desc string }{{"z", "Z"}, {"b", "B"}, {"c", "C"}, {"s", "S"}, {"j", "J"}, {"d", "D"}, {"f", "F"}} {
+		b.AddField(classfile.AccStatic, f.name, f.desc, nil)
+		fields[f.name] = b.Fieldref("Widths", f.name, f.desc)
+	}
+	inherited := b.Fieldref("Sub", "count", "I")
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Widths": cf, "Base": base.Build(), "Sub": sub.Build()})
+	v.Stdout = io.Discard
+
+	ref := func(name string) []byte { return []byte{byte(fields[name] >> 8), byte(fields[name])} }
+	get := func(name string) Value {
+		return runFrame(t, v, cf, append(append([]byte{0xb2}, ref(name)...), 0xac)) // getstatic; ireturn
+	}
+	put := func(name string, push ...byte) {
+		runFrame(t, v, cf, append(append(append(push, 0xb3), ref(name)...), 0x01, 0xb0)) // ...; putstatic; aconst_null; areturn
+	}
+
+	for name, want := range map[string]ValueType{"z": TypeInt, "j": TypeLong, "d": TypeDouble, "f": TypeFloat} {
+		if got := get(name); got.Type != want {
+			t.Errorf("default %s: got %+v", name, got)
+		}
+	}
+
+	put("z", 0x05) // iconst_2
+	if got := get("z"); got.Int != 0 {
+		t.Errorf("boolean = 2: got %d, want 0", got.Int)
+	}
+	put("z", 0x06) // iconst_3
+	if got := get("z"); got.Int != 1 {
+		t.Errorf("boolean = 3: got %d, want 1", got.Int)
+	}
+	put("b", 0x11, 0x00, 0xC8) // sipush 200
+	if got := get("b"); got.Int != -56 {
+		t.Errorf("byte = 200: got %d", got.Int)
+	}
+	put("c", 0x02) // iconst_m1
+	if got := get("c"); got.Int != 0xFFFF {
+		t.Errorf("char = -1: got %d", got.Int)
+	}
+	put("s", 0x04, 0x10, 0x0F, 0x78) // iconst_1; bipush 15; ishl
+	if got := get("s"); got.Int != -32768 {
+		t.Errorf("short = 1 << 15: got %d", got.Int)
+	}
+	put("j", 0x0A) // lconst_1
+	if got := get("j"); got.Type != TypeLong || got.Long != 1 {
+		t.Errorf("long = 1: got %+v", got)
+	}
+	put("d", 0x0F) // dconst_1
+	if got := get("d"); got.Type != TypeDouble || got.Double != 1 {
+		t.Errorf("double = 1.0: got %+v", got)
+	}
+
+	// Sub.count is Base.count: only Base is initialized and both names share it.
+	got := runFrame(t, v, cf, []byte{0xb2, byte(inherited >> 8), byte(inherited), 0xac})
+	if got.Int != 7 {
+		t.Errorf("Sub.count: got %d, want 7", got.Int)
+	}
+	if _, ok := v.classInits["Sub"]; ok {
+		t.Error("getstatic Sub.count initialized Sub")
+	}
+	if v.getStaticField("Base", "count").Int != 7 {
+		t.Error("Base.count not shared")
+	}
+}
+
+func TestArraycopy(t *testing.T) {
+	v := NewVM(mapClassLoader{
+		"Animal": classfile.NewBuilder("Animal", "java/lang/Object").Build(),
+		"Dog":    classfile.NewBuilder("Dog", "Animal").Build(),
+	})
+	ints := func(xs ...int32) *JArray {
+		return &JArray{Component: "I", Ints: xs}
+	}
+	arraycopy := func(src *JArray, srcPos int32, dest *JArray, destPos, length int32) error {
+		_, err := v.executeNativeMethod("java/lang/System", "arraycopy", "(Ljava/lang/Object;ILjava/lang/Object;II)V",
+			[]Value{RefValue(src), IntValue(srcPos), RefValue(dest), IntValue(destPos), IntValue(length)})
+		return err
+	}
+
+	// Overlapping ranges copy as if through a temporary array.
+	a := ints(1, 2, 3, 4, 5)
+	if err := arraycopy(a, 0, a, 1, 4); err != nil || fmt.Sprint(a.Ints) != "[1 1 2 3 4]" {
+		t.Errorf("forward overlap: got %v, %v", a.Ints, err)
+	}
+	a = ints(1, 2, 3, 4, 5)
+	if err := arraycopy(a, 1, a, 0, 4); err != nil || fmt.Sprint(a.Ints) != "[2 3 4 5 5]" {
+		t.Errorf("backward overlap: got %v, %v", a.Ints, err)
+	}
+
+	// Failures carry HotSpot's messages.
+	message := func(err error) string {
+		if exc, ok := err.(*JavaException); ok {
+			msg, _ := exc.Message()
+			return msg
+		}
+		return ""
+	}
+	longs := NewArray("J", 1)
+	err := arraycopy(ints(1), 0, longs, 0, 0)
+	if !isJavaException(err, "java/lang/ArrayStoreException") || message(err) != "arraycopy: type mismatch: can not copy int[] into long[]" {
+		t.Errorf("int[] to long[]: got %v", err)
+	}
+	objects := &JArray{Component: "Ljava/lang/Object;", Elements: []Value{NullValue()}}
+	err = arraycopy(ints(1), 0, objects, 0, 1)
+	if !isJavaException(err, "java/lang/ArrayStoreException") || message(err) != "arraycopy: type mismatch: can not copy int[] into object array[]" {
+		t.Errorf("int[] to Object[]: got %v", err)
+	}
+	for _, tt := range []struct {
+		srcPos, destPos, length int32
+		want                    string
+	}{
+		{0, 1, 1, "arraycopy: last destination index 2 out of bounds for int[1]"},
+		{1, 0, 1, "arraycopy: last source index 2 out of bounds for int[1]"},
+		{-1, 0, 0, "arraycopy: source index -1 out of bounds for int[1]"},
+		{0, -2, 0, "arraycopy: destination index -2 out of bounds for int[1]"},
+		{0, 0, -1, "arraycopy: length -1 is negative"},
+	} {
+		err := arraycopy(ints(1), tt.srcPos, ints(0), tt.destPos, tt.length)
+		if !isJavaException(err, "java/lang/ArrayIndexOutOfBoundsException") || message(err) != tt.want {
+			t.Errorf("arraycopy(%d, %d, %d): got %v, want %q", tt.srcPos, tt.destPos, tt.length, err, tt.want)
+		}
+	}
+
+	// Reference elements are checked against the destination type, and the
+	// ones before a mismatch are still copied.
+	dog := RefValue(&JObject{ClassName: "Dog"})
+	animal := RefValue(&JObject{ClassName: "Animal"})
+	src := &JArray{Component: "Ljava/lang/Object;", Elements: []Value{dog, NullValue(), animal}}
+	dogs := &JArray{Component: "LDog;", Elements: []Value{NullValue(), NullValue(), NullValue()}}
+	err = arraycopy(src, 0, dogs, 0, 3)
+	if !isJavaException(err, "java/lang/ArrayStoreException") || message(err) != "arraycopy: element type mismatch: "+
+		"can not cast one of the elements of java.lang.Object[] to the type of the destination array, Dog" {
+		t.Errorf("Animal into Dog[]: got %v", err)
+	}
+	if dogs.Elements[0] != dog || dogs.Elements[2].Type != TypeNull {
+		t.Errorf("partial copy: got %+v", dogs.Elements)
+	}
+	animals := &JArray{Component: "LAnimal;", Elements: make([]Value, 3)}
+	if err := arraycopy(src, 0, animals, 0, 3); err != nil || animals.Elements[2] != animal {
+		t.Errorf("into Animal[]: got %+v, %v", animals.Elements, err)
+	}
+	nested := &JArray{Component: "[I", Elements: []Value{RefValue(ints(1)), RefValue(ints(2))}}
+	if err := arraycopy(nested, 0, objects, 0, 1); err != nil {
+		t.Errorf("int[][] to Object[]: %v", err)
+	}
+	strs := &JArray{Component: "Ljava/lang/CharSequence;", Elements: make([]Value, 1)}
+	if err := arraycopy(&JArray{Component: "Ljava/lang/Object;", Elements: []Value{RefValue("s")}}, 0, strs, 0, 1); err != nil {
+		t.Errorf("String into CharSequence[]: %v", err)
+	}
+}
+
+func TestStringBuilderBuffer(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	call := func(sb Value, method, descriptor string, args ...Value) (Value, error) {
+		got, _, err := v.handleStringBuilder(sb, method, descriptor, args)
+		return got, err
+	}
+	newBuilder := func(descriptor string, args ...Value) (Value, error) {
+		sb := RefValue(&JObject{ClassName: "java/lang/StringBuilder", Fields: map[string]Value{}})
+		_, err := call(sb, "<init>", descriptor, args...)
+		return sb, err
+	}
+
+	sb, err := newBuilder("(I)V", IntValue(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := cap(sb.Ref.(*JObject).Fields["_buffer"].Ref.([]byte)); c != 64 {
+		t.Errorf("capacity: got %d, want 64", c)
+	}
+	call(sb, "append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;", RefValue("a😀"))
+	first, _ := call(sb, "toString", "()Ljava/lang/String;")
+	call(sb, "append", "(C)Ljava/lang/StringBuilder;", IntValue('é'))
+	if first.Ref != "a😀" {
+		t.Errorf("toString is not a snapshot: got %q", first.Ref)
+	}
+	if got, _ := call(sb, "length", "()I"); got.Int != 4 {
+		t.Errorf("length: got %d, want 4 chars", got.Int)
+	}
+	if got := v.valueToString(sb); got != "a😀é" {
+		t.Errorf("valueToString: got %q", got)
+	}
+
+	copied, err := newBuilder("(Ljava/lang/CharSequence;)V", sb)
+	if err != nil || v.valueToString(copied) != "a😀é" {
+		t.Errorf("StringBuilder(CharSequence): got %q, %v", v.valueToString(copied), err)
+	}
+	if _, err := newBuilder("(I)V", IntValue(-1)); !isJavaException(err, "java/lang/NegativeArraySizeException") {
+		t.Errorf("negative capacity: got %v", err)
+	}
+	if _, err := newBuilder("(Ljava/lang/String;)V", NullValue()); !isJavaException(err, "java/lang/NullPointerException") {
+		t.Errorf("StringBuilder(null): got %v", err)
+	}
+}
+
+func TestStringBuilderMethods(t *testing.T) {
+	v := &VM{Stdout: io.Discard}
+	sb := RefValue(&JObject{ClassName: "java/lang/StringBuffer", Fields: map[string]Value{}})
+	call := func(method, descriptor string, args ...Value) Value {
+		t.Helper()
+		got, _, err := v.handleStringBuilder(sb, method, descriptor, args)
+		if err != nil {
+			t.Fatalf("%s%s: %v", method, descriptor, err)
+		}
+		return got
+	}
+	call("<init>", "(Ljava/lang/String;)V", RefValue("héllo"))
+	if got := call("capacity", "()I").Int; got != 21 {
+		t.Errorf("initial capacity: got %d, want 21", got)
+	}
+
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+		want               string
+	}{
+		{"insert", "(ILjava/lang/String;)Ljava/lang/StringBuilder;", []Value{IntValue(2), RefValue("😀")}, "hé😀llo"},
+		{"insert", "(IZ)Ljava/lang/StringBuilder;", []Value{IntValue(0), IntValue(1)}, "truehé😀llo"},
+		{"delete", "(II)Ljava/lang/StringBuilder;", []Value{IntValue(0), IntValue(4)}, "hé😀llo"},
+		{"deleteCharAt", "(I)Ljava/lang/StringBuilder;", []Value{IntValue(1)}, "h😀llo"},
+		{"replace", "(IILjava/lang/String;)Ljava/lang/StringBuilder;", []Value{IntValue(1), IntValue(3), RefValue("ey")}, "heyllo"},
+		{"reverse", "()Ljava/lang/StringBuilder;", nil, "ollyeh"},
+		{"setCharAt", "(IC)V", []Value{IntValue(0), IntValue('ö')}, "öllyeh"},
+		{"appendCodePoint", "(I)Ljava/lang/StringBuilder;", []Value{IntValue(0x1F600)}, "öllyeh😀"},
+		{"append", "([CII)Ljava/lang/StringBuilder;", []Value{RefValue(charArray("abc")), IntValue(1), IntValue(2)}, "öllyeh😀bc"},
+		{"append", "(Ljava/lang/CharSequence;II)Ljava/lang/StringBuilder;", []Value{RefValue("xyz"), IntValue(0), IntValue(1)}, "öllyeh😀bcx"},
+		{"setLength", "(I)V", []Value{IntValue(3)}, "öll"},
+		{"setLength", "(I)V", []Value{IntValue(4)}, "öll\x00"},
+	} {
+		call(c.method, c.descriptor, c.args...)
+		if got := v.valueToString(sb); got != c.want {
+			t.Errorf("after %s: got %q, want %q", c.method, got, c.want)
+		}
+	}
+	if got := call("length", "()I").Int; got != 4 {
+		t.Errorf("length: got %d, want 4", got)
+	}
+
+	call("setLength", "(I)V", IntValue(0))
+	call("append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;", RefValue("a😀bca"))
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+		want               int32
+	}{
+		{"charAt", "(I)C", []Value{IntValue(2)}, 0xDE00},
+		{"charAt", "(I)C", []Value{IntValue(3)}, 'b'},
+		{"indexOf", "(Ljava/lang/String;)I", []Value{RefValue("bc")}, 3},
+		{"indexOf", "(Ljava/lang/String;I)I", []Value{RefValue("a"), IntValue(1)}, 5},
+		{"lastIndexOf", "(Ljava/lang/String;)I", []Value{RefValue("a")}, 5},
+		{"lastIndexOf", "(Ljava/lang/String;I)I", []Value{RefValue("a"), IntValue(4)}, 0},
+		{"indexOf", "(Ljava/lang/String;)I", []Value{RefValue("z")}, -1},
+		{"capacity", "()I", nil, 21},
+	} {
+		if got := call(c.method, c.descriptor, c.args...).Int; got != c.want {
+			t.Errorf("%s(%v): got %d, want %d", c.method, c.args, got, c.want)
+		}
+	}
+	call("append", "(Ljava/lang/String;)Ljava/lang/StringBuilder;", RefValue(strings.Repeat("x", 20)))
+	if got := call("capacity", "()I").Int; got != 44 {
+		t.Errorf("grown capacity: got %d, want 44", got)
+	}
+	if got := v.valueToString(call("substring", "(II)Ljava/lang/String;", IntValue(1), IntValue(4))); got != "😀b" {
+		t.Errorf("substring: got %q", got)
+	}
+
+	for _, c := range []struct {
+		method, descriptor string
+		args               []Value
+	}{
+		{"charAt", "(I)C", []Value{IntValue(-1)}},
+		{"deleteCharAt", "(I)Ljava/lang/StringBuilder;", []Value{IntValue(100)}},
+		{"insert", "(ILjava/lang/String;)Ljava/lang/StringBuilder;", []Value{IntValue(100), RefValue("x")}},
+		{"delete", "(II)Ljava/lang/StringBuilder;", []Value{IntValue(3), IntValue(2)}},
+		{"setLength", "(I)V", []Value{IntValue(-1)}},
+	} {
+		if _, _, err := v.handleStringBuilder(sb, c.method, c.descriptor, c.args); !isJavaException(err, "java/lang/StringIndexOutOfBoundsException") {
+			t.Errorf("%s(%v): got %v", c.method, c.args, err)
+		}
+	}
+}
+
+func TestPreviewClassFiles(t *testing.T) {
+	lib := classfile.NewBuilder("Lib", "java/lang/Object")
+	lib.AddMethod(classfile.AccStatic, "f", "()V", &classfile.CodeAttribute{Code: []byte{0xb1}})
+	libCf := lib.Build()
+	libCf.MajorVersion, libCf.MinorVersion = 61, classfile.PreviewMinorVersion
+
+	b := classfile.NewBuilder("App", "java/lang/Object")
+	f := b.Methodref("Lib", "f", "()V")
+	b.AddMethod(classfile.AccPublic|classfile.AccStatic, "main", "([Ljava/lang/String;)V", &classfile.CodeAttribute{
+		MaxLocals: 1,
+		Code:      []byte{0xb8, byte(f >> 8), byte(f), 0xb1}, // invokestatic Lib.f; return
+	})
+	app := b.Build()
+
+	v := NewVM(mapClassLoader{"App": app, "Lib": libCf})
+	var exc *JavaException
+	if err := v.Execute("App"); !errors.As(err, &exc) || exc.Object.ClassName != "java/lang/UnsupportedClassVersionError" {
+		t.Fatalf("preview dependency: got %v", err)
+	}
+	msg, _ := exc.Object.Fields["detailMessage"].Ref.(string)
+	if want := "Preview features are not enabled for Lib (class file version 61.65535). Try running with '--enable-preview'"; msg != want {
+		t.Errorf("message: got %q", msg)
+	}
+
+	// Naming Lib without initializing it fails too.
+	b = classfile.NewBuilder("Cast", "java/lang/Object")
+	libClass := b.Class("Lib")
+	b.AddMethod(classfile.AccPublic|classfile.AccStatic, "main", "([Ljava/lang/String;)V", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code:      []byte{OpAconstNull, OpInstanceof, byte(libClass >> 8), byte(libClass), OpPop, OpReturn},
+	})
+	if err := NewVM(mapClassLoader{"Cast": b.Build(), "Lib": libCf}).Execute("Cast"); !isJavaException(err, "java/lang/UnsupportedClassVersionError") {
+		t.Errorf("instanceof preview class: got %v", err)
+	}
+
+	app.MinorVersion = classfile.PreviewMinorVersion
+	if err := NewVM(mapClassLoader{"App": app, "Lib": libCf}).Execute("App"); !isJavaException(err, "java/lang/UnsupportedClassVersionError") {
+		t.Errorf("preview main class: got %v", err)
+	}
+
+	v = NewVM(mapClassLoader{"App": app, "Lib": libCf})
+	v.EnablePreview = true
+	if err := v.Execute("App"); err != nil {
+		t.Errorf("with EnablePreview: %v", err)
+	}
+}
+
+func TestCheckReturns(t *testing.T) {
+	tests := []struct {
+		name       string
+		descriptor string
+		code       []byte
+		want       string // in the error, or "" if the return is consistent
+	}{
+		{"int", "()I", []byte{OpIconst1, OpIreturn}, ""},
+		{"boolean", "()Z", []byte{OpIconst1, OpIreturn}, ""},
+		{"null reference", "()Ljava/lang/Object;", []byte{OpAconstNull, OpAreturn}, ""},
+		{"void", "()V", []byte{OpReturn}, ""},
+		{"wrong instruction", "()I", []byte{OpLconst1, OpLreturn}, "lreturn in a method returning I"},
+		{"wrong category", "()J", []byte{OpIconst1, OpLreturn}, "lreturn of int value in a method returning J"},
+		{"int as reference", "()Ljava/lang/String;", []byte{OpIconst0, OpAreturn}, "areturn of int value in a method returning Ljava/lang/String;"},
+		{"value from void", "()V", []byte{OpIconst0, OpIreturn}, "ireturn in a method returning V"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := classfile.NewBuilder("Ret", "java/lang/Object")
+			b.AddMethod(classfile.AccStatic, "m", tt.descriptor, &classfile.CodeAttribute{MaxStack: 2, Code: tt.code})
+			cf := b.Build()
+			method := cf.FindMethodByName("m")
+
+			v := NewVM(mapClassLoader{"Ret": cf})
+			if _, err := v.executeMethod(cf, method, nil); err != nil {
+				t.Fatalf("unchecked: %v", err)
+			}
+			v.CheckReturns = true
+			_, err := v.executeMethod(cf, method, nil)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("checked: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "Ret.m"+tt.descriptor+": "+tt.want) {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func BenchmarkStringBuilderAppend(b *testing.B) {
+	v := &VM{Stdout: io.Discard}
+	const appendString = "(Ljava/lang/String;)Ljava/lang/StringBuilder;"
+	for i := 0; i < b.N; i++ {
+		sb := RefValue(&JObject{ClassName: "java/lang/StringBuilder", Fields: map[string]Value{}})
+		v.handleStringBuilder(sb, "<init>", "()V", nil)
+		for j := 0; j < 1000; j++ {
+			v.handleStringBuilder(sb, "append", appendString, []Value{RefValue("item, ")})
+			v.handleStringBuilder(sb, "append", "(I)Ljava/lang/StringBuilder;", []Value{IntValue(int32(j))})
+		}
+		v.handleStringBuilder(sb, "toString", "()Ljava/lang/String;", nil)
+	}
+}
+
+// Java calls between bytecode methods run in one interpreter loop, so the
+// Go stack does not grow with Java recursion, and exceptions unwind
+// through the activations on the call stack.
+func TestInterpreterCallStack(t *testing.T) {
+	b := classfile.NewBuilder("Deep", "java/lang/Object")
+	probe := b.Methodref("Deep", "probe", "()V")
+	down := b.Methodref("Deep", "down", "(I)V")
+	fail := b.Methodref("Deep", "fail", "(I)I")
+	arithmetic := b.Class("java/lang/ArithmeticException")
+	b.AddMethod(classfile.AccStatic|AccNative, "probe", "()V", nil)
+	b.AddMethod(classfile.AccStatic, "down", "(I)V", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpIfne, 0, 7, // if n != 0 goto 8
+			OpInvokestatic, byte(probe >> 8), byte(probe), OpReturn,
+			OpIload0, OpIconst1, OpIsub, // 8: down(n - 1)
+			OpInvokestatic, byte(down >> 8), byte(down), OpReturn,
+		},
+	})
+	// fail(n) recurses n times and then divides by zero.
+	b.AddMethod(classfile.AccStatic, "fail", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  2,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpIfne, 0, 6, // if n != 0 goto 7
+			OpIconst1, OpIload0, OpIdiv, // 1 / 0
+			OpIload0, OpIconst1, OpIsub, // 7: fail(n - 1)
+			OpInvokestatic, byte(fail >> 8), byte(fail), OpIreturn,
+		},
+	})
+	// catching(n) returns fail(n), or -1 if it throws ArithmeticException.
+	b.AddMethod(classfile.AccStatic, "catching", "(I)I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 1,
+		Code: []byte{
+			OpIload0, OpInvokestatic, byte(fail >> 8), byte(fail), OpIreturn,
+			OpPop, OpIconstM1, OpIreturn, // 5: handler
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 5, HandlerPC: 5, CatchType: arithmetic}},
+	})
+	cf := b.Build()
+
+	v := NewVM(mapClassLoader{"Deep": cf})
+	var goDepths, javaDepths []int
+	v.NativeFallback = func(vm *VM, className, methodName, descriptor string, args []Value) (Value, error) {
+		goDepths = append(goDepths, runtime.Callers(0, make([]uintptr, 1000)))
+		javaDepths = append(javaDepths, len(vm.callStack))
+		return Value{}, nil
+	}
+	for _, n := range []int32{1, 500} {
+		if _, err := v.executeMethod(cf, cf.FindMethodByName("down"), []Value{IntValue(n)}); err != nil {
+			t.Fatalf("down(%d): %v", n, err)
+		}
+	}
+	if goDepths[0] != goDepths[1] || javaDepths[1]-javaDepths[0] != 499 {
+		t.Errorf("Go stack depths %v and Java stack depths %v: want equal Go depths", goDepths, javaDepths)
+	}
+
+	got, err := v.executeMethod(cf, cf.FindMethodByName("catching"), []Value{IntValue(100)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != -1 {
+		t.Errorf("catching(100): got %d, want -1", got.Int)
+	}
+	_, err = v.executeMethod(cf, cf.FindMethodByName("fail"), []Value{IntValue(3)})
+	if !isJavaException(err, "java/lang/ArithmeticException") {
+		t.Fatalf("fail(3): got %v", err)
+	}
+	if trace := err.(*JavaException).StackTrace(); len(trace) != 4 {
+		t.Errorf("stack trace: got %v, want 4 frames", trace)
+	}
+	if len(v.callStack) != 0 || v.frameDepth != 0 || v.running != nil {
+		t.Errorf("stack not unwound: %d entries, depth %d", len(v.callStack), v.frameDepth)
+	}
+}
+
+func TestStackOverflowError(t *testing.T) {
+	b := classfile.NewBuilder("Overflow", "java/lang/Object")
+	forever := b.Methodref("Overflow", "forever", "()I")
+	overflow := b.Class("java/lang/StackOverflowError")
+	// forever() recurses until the stack runs out, catching the
+	// StackOverflowError one frame below the deepest call.
+	b.AddMethod(classfile.AccStatic, "forever", "()I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 0,
+		Code: []byte{
+			OpInvokestatic, byte(forever >> 8), byte(forever), OpIreturn,
+			OpPop, OpIconstM1, OpIreturn, // 4: handler
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 4, HandlerPC: 4, CatchType: overflow}},
+	})
+	// push() pushes two values onto a stack with room for one.
+	b.AddMethod(classfile.AccStatic, "push", "()I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 0,
+		Code:      []byte{OpIconst1, OpIconst1, OpIadd, OpIreturn},
+	})
+	// pushCaught() calls push() and returns -1 when it throws.
+	push := b.Methodref("Overflow", "push", "()I")
+	b.AddMethod(classfile.AccStatic, "pushCaught", "()I", &classfile.CodeAttribute{
+		MaxStack:  1,
+		MaxLocals: 0,
+		Code: []byte{
+			OpInvokestatic, byte(push >> 8), byte(push), OpIreturn,
+			OpPop, OpIconstM1, OpIreturn, // 4: handler
+		},
+		ExceptionHandlers: []classfile.ExceptionHandler{{StartPC: 0, EndPC: 4, HandlerPC: 4}},
+	})
+	cf := b.Build()
+	v := NewVM(mapClassLoader{"Overflow": cf})
+
+	got, err := v.executeMethod(cf, cf.FindMethodByName("forever"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Int != -1 {
+		t.Errorf("forever(): got %d, want -1", got.Int)
+	}
+	if len(v.callStack) != 0 || v.frameDepth != 0 {
+		t.Errorf("stack not unwound: %d entries, depth %d", len(v.callStack), v.frameDepth)
+	}
+
+	_, err = v.executeMethod(cf, cf.FindMethodByName("push"), nil)
+	if !isJavaException(err, "java/lang/VerifyError") {
+		t.Fatalf("push(): got %v, want VerifyError", err)
+	}
+	if msg, _ := err.(*JavaException).Message(); !strings.Contains(msg, "Overflow.push()I: pc 1: Operand stack overflow") {
+		t.Errorf("push(): got message %q", msg)
+	}
+	got, err = v.executeMethod(cf, cf.FindMethodByName("pushCaught"), nil)
+	if err != nil || got.Int != -1 {
+		t.Errorf("pushCaught(): got %d, %v, want -1", got.Int, err)
+	}
+	if len(v.callStack) != 0 || v.frameDepth != 0 {
+		t.Errorf("stack not unwound: %d entries, depth %d", len(v.callStack), v.frameDepth)
+	}
+}
+
+func TestSystemErr(t *testing.T) {
+	b := classfile.NewBuilder("Streams", "java/lang/Object")
+	out := b.Fieldref("java/lang/System", "out", "Ljava/io/PrintStream;")
+	errField := b.Fieldref("java/lang/System", "err", "Ljava/io/PrintStream;")
+	printStr := b.Methodref("java/io/PrintStream", "println", "(Ljava/lang/String;)V")
+	msg := b.String("né")
+	b.AddMethod(classfile.AccStatic, "run", "()V", &classfile.CodeAttribute{
+		MaxStack: 2,
+		Code: []byte{
+			OpGetstatic, byte(out >> 8), byte(out), OpLdc, byte(msg), OpInvokevirtual, byte(printStr >> 8), byte(printStr),
+			OpGetstatic, byte(errField >> 8), byte(errField), OpLdc, byte(msg), OpInvokevirtual, byte(printStr >> 8), byte(printStr),
+			OpReturn,
+		},
+	})
+	cf := b.Build()
+	var stdout, stderr bytes.Buffer
+	v := NewVM(mapClassLoader{"Streams": cf})
+	v.Stdout, v.Stderr = &stdout, &stderr
+	v.SetProperty("stderr.encoding", "US-ASCII")
+
+	if _, err := v.executeMethod(cf, cf.FindMethodByName("run"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if stdout.String() != "né\n" {
+		t.Errorf("System.out: got %q", stdout.String())
+	}
+	if stderr.String() != "n?\n" {
+		t.Errorf("System.err with stderr.encoding=US-ASCII: got %q", stderr.String())
+	}
+}
@@ -0,0 +1,116 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+// Classes are normally loaded, linked and initialized lazily on first use.
+// Latency-sensitive embedders can move that work to startup: Preload
+// loads, verifies and links classes without running any Java code, and
+// WarmUp additionally initializes entry point classes and preloads the
+// classes they reference.
+
+// Preload loads the named classes together with their superclasses and
+// superinterfaces, verifies their structure and sealed hierarchy, and
+// computes their field layouts. Classes are not initialized. Array class
+// names are accepted and preload their element class.
+func (vm *VM) Preload(classNames ...string) error {
+	seen := make(map[string]bool)
+	for _, name := range classNames {
+		if err := vm.preload(name, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (vm *VM) preload(name string, seen map[string]bool) error {
+	name = strings.TrimLeft(name, "[")
+	if strings.HasPrefix(name, "L") && strings.HasSuffix(name, ";") {
+		name = name[1 : len(name)-1]
+	}
+	if seen[name] || len(name) <= 1 { // primitive element types need no loading
+		return nil
+	}
+	seen[name] = true
+
+	cf, err := vm.ClassLoader.LoadClass(name)
+	if err != nil {
+		return fmt.Errorf("preloading %s: %w", name, err)
+	}
+	if err := cf.Validate(); err != nil {
+		return fmt.Errorf("preloading %s: %w", name, err)
+	}
+	if super := cf.SuperClassName(); super != "" {
+		if err := vm.preload(super, seen); err != nil {
+			return err
+		}
+	}
+	for _, idx := range cf.Interfaces {
+		iface, err := classfile.GetClassName(cf.ConstantPool, idx)
+		if err != nil {
+			return fmt.Errorf("preloading %s: %w", name, err)
+		}
+		if err := vm.preload(iface, seen); err != nil {
+			return err
+		}
+	}
+	if err := vm.checkPermittedSubclass(name, cf); err != nil {
+		return err
+	}
+	vm.layoutOf(name)
+	return nil
+}
+
+// WarmUp prepares entry points ahead of their first call. An entry point
+// is a class name such as "app/Server", or a method of it written
+// "app/Server.handle" or "app/Server.handle:(Ljava/lang/String;)V". The
+// class is preloaded and initialized, running its <clinit>; every class
+// named in its constant pool is preloaded; and a named method must exist.
+// Classes referenced from the constant pool that cannot be loaded are
+// skipped, since code may name classes it never uses.
+func (vm *VM) WarmUp(entrypoints ...string) error {
+	vm.startCall()
+	for _, entry := range entrypoints {
+		className, method := entry, ""
+		if i := strings.LastIndexByte(entry, '.'); i >= 0 {
+			className, method = entry[:i], entry[i+1:]
+		}
+
+		if err := vm.Preload(className); err != nil {
+			return err
+		}
+		if err := vm.ensureInitialized(className); err != nil {
+			return fmt.Errorf("initializing %s: %w", className, err)
+		}
+
+		cf, err := vm.ClassLoader.LoadClass(className)
+		if err != nil {
+			return err
+		}
+		if method != "" {
+			name, descriptor, _ := strings.Cut(method, ":")
+			found := descriptor == "" && cf.FindMethodByName(name) != nil
+			if descriptor != "" {
+				_, _, err := vm.resolveMethod(className, name, descriptor)
+				found = err == nil
+			}
+			if !found {
+				return fmt.Errorf("warming up %s: method not found", entry)
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, c := range cf.ConstantPool {
+			if class, ok := c.(*classfile.ConstantClass); ok {
+				if name, err := classfile.GetUtf8(cf.ConstantPool, class.NameIndex); err == nil {
+					_ = vm.preload(name, seen)
+				}
+			}
+		}
+	}
+	return nil
+}
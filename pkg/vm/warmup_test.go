@@ -0,0 +1,83 @@
+package vm
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/daimatz/gojvm/pkg/classfile"
+)
+
+func TestPreloadAndWarmUp(t *testing.T) {
+	classes := mapClassLoader{"java/lang/Object": classfile.NewBuilder("java/lang/Object", "").Build()}
+
+	iface := classfile.NewBuilder("app/Handler", "java/lang/Object")
+	iface.SetAccessFlags(classfile.AccPublic | AccInterface | classfile.AccAbstract)
+	classes["app/Handler"] = iface.Build()
+
+	base := classfile.NewBuilder("app/Base", "java/lang/Object")
+	base.AddField(0, "id", "J", nil)
+	classes["app/Base"] = base.Build()
+
+	helper := classfile.NewBuilder("app/Helper", "java/lang/Object")
+	helper.AddField(0, "count", "I", nil)
+	classes["app/Helper"] = helper.Build()
+
+	b := classfile.NewBuilder("app/Server", "app/Base")
+	b.AddInterface("app/Handler")
+	b.AddField(classfile.AccStatic, "ready", "I", nil)
+	ready := b.Fieldref("app/Server", "ready", "I")
+	b.Class("app/Helper")
+	b.Class("app/Unused") // referenced but never loadable
+	b.Class("[Lapp/Helper;")
+	b.AddMethod(classfile.AccStatic, "<clinit>", "()V", &classfile.CodeAttribute{
+		MaxStack: 1,
+		Code:     []byte{0x04, 0xb3, byte(ready >> 8), byte(ready), 0xb1}, // iconst_1; putstatic ready; return
+	})
+	b.AddMethod(classfile.AccPublic, "handle", "(Ljava/lang/String;)V", &classfile.CodeAttribute{MaxLocals: 2, Code: []byte{0xb1}})
+	classes["app/Server"] = b.Build()
+
+	v := NewVM(classes)
+	v.Stdout = io.Discard
+
+	if err := v.Preload("app/Server", "[[Lapp/Base;", "[I"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"app/Server", "app/Base", "app/Handler", "java/lang/Object"} {
+		if _, ok := v.layouts[name]; !ok {
+			t.Errorf("%s was not linked", name)
+		}
+	}
+	if v.layouts["app/Server"].offsets["id"] != 16 { // long aligned after the header
+		t.Errorf("inherited field id: got offset %d", v.layouts["app/Server"].offsets["id"])
+	}
+	if _, ok := v.classInits["app/Server"]; ok {
+		t.Error("Preload initialized app/Server")
+	}
+
+	if err := v.WarmUp("app/Server.handle:(Ljava/lang/String;)V"); err != nil {
+		t.Fatal(err)
+	}
+	if got := v.getStaticField("app/Server", "ready"); got.Int != 1 {
+		t.Errorf("<clinit> did not run: ready = %d", got.Int)
+	}
+	if _, ok := v.layouts["app/Helper"]; !ok {
+		t.Error("referenced class app/Helper was not preloaded")
+	}
+
+	if err := v.WarmUp("app/Server.stop"); err == nil || !strings.Contains(err.Error(), "method not found") {
+		t.Errorf("missing method: got %v", err)
+	}
+	if err := v.Preload("app/Unused"); err == nil {
+		t.Error("preloading a missing class should fail")
+	}
+
+	broken := classfile.NewBuilder("app/Broken", "java/lang/Object")
+	broken.AddField(0, "bad", "Q", nil)
+	classes["app/Broken"] = broken.Build()
+	var cfe *classfile.ClassFormatError
+	if err := v.Preload("app/Broken"); !errors.As(err, &cfe) {
+		t.Errorf("invalid class: got %v, want ClassFormatError", err)
+	}
+}